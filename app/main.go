@@ -1,1104 +1,949 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"compress/zlib"
-	"crypto/sha1"
-	"encoding/binary"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"path"
-	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
-	"time"
+	"os/signal"
+	"syscall"
+
+	"github.com/obradovicsl/mini-git/git"
 )
 
-// Usage: your_program.sh <command> <arg1> <arg2> ...
+// Commands that don't run inside an existing repository
+var repositoryFreeCommands = map[string]bool{
+	"init": true, "clone": true, "upload-pack": true, "credential-store": true, "ls-remote": true,
+	"serve-http": true, "receive-pack": true, "daemon": true,
+}
+
+// Commands that support --json
+var jsonCommands = map[string]bool{
+	"cat-file": true, "ls-tree": true, "log": true, "status": true, "ls-remote": true,
+}
+
+// Usage: your_program.sh [-C <path>] [--git-dir=<path>] [--work-tree=<path>] [--no-pager] [--json] [--no-replace-objects] <command> <arg1> <arg2> ...
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
-		os.Exit(1)
+	// Global options come before the command - args[0] is the command, the rest are its arguments
+	globalOptions, args, err := parseGlobalArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+		exit(1)
+	}
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "usage: mygit [-C <path>] [--git-dir=<path>] [--work-tree=<path>] [--no-pager] [--json] [--no-replace-objects] <command> [<args>...]\n")
+		exit(1)
+	}
+	git.TraceCommand(args)
+	if globalOptions.JSON && !jsonCommands[args[0]] {
+		fmt.Fprintf(os.Stderr, "Error while parsing args: --json is not supported by %s\n", args[0])
+		exit(1)
+	}
+	// Upload-pack and receive-pack of local transports and servers run this binary
+	if executable, err := os.Executable(); err == nil {
+		globalOptions.Program = executable
+	}
+	repoContext, err := git.NewRepoContext(globalOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while applying global options: %s\n", err)
+		exit(exitCode(err))
+	}
+
+	// Repository is searched from the starting directory (-C) upwards - paths on the command line stay
+	// relative to it
+	var repo *git.Repository
+	if !repositoryFreeCommands[args[0]] {
+		repo, err = repoContext.Open(".")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while looking for repository: %s\n", err)
+			exit(exitCode(err))
+		}
 	}
 
-	switch command := os.Args[1]; command {
+	switch command := args[0]; command {
 	case "init":
-		err := initRepo()
+		// Extract cmd arguments
+		options, err := parseInitCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
+		directory := options.Directory
+		if directory == "" {
+			directory = "."
+		}
+
+		_, err = repoContext.Init(directory, options)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error with init command: %s\n", err)
-			os.Exit(1)
+			exit(exitCode(err))
 		}
 		fmt.Println("Initialized git directory")
 	case "cat-file":
 		// Extract cmd arguments
-		objectHash, flag, err := parseCatCmdArgs(os.Args[2:])
+		objectHash, flag, err := parseCatCmdArgs(args[1:])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while parsing cat-file command: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
-		// Based on given SHA1 hash, read object from .git/objects
-		objType, objSize, objContent, err := readObjectFromHash(objectHash)
+		// Print type (-t), size (-s) or content (-p) of the object
+		err = repo.CatFile(objectHash, flag, globalOptions.JSON, os.Stdout)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while decompressing object: %s\n", err)
-			os.Exit(1)
-		}
-
-		// Based on provided flag, print required data
-		switch flag {
-		case "-t":
-			// Print type of the object
-			fmt.Println(objType)
-
-		case "-s":
-			// Print size of the object
-			fmt.Println(objSize)
-
-		case "-p":
-			// Print content of the object
-			fmt.Println(string(objContent))
+			fmt.Fprintf(os.Stderr, "Error while reading object: %s\n", err)
+			exit(exitCode(err))
 		}
 	case "hash-object":
 		// Extract cmd arguments
-		objectPath, flag, err := parseHashObjectCmdArgs(os.Args[2:])
+		objectPath, flag, err := parseHashObjectCmdArgs(args[1:])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while parssing hash-object command args: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
-		// Read file from provided path
-		objectContent, _, err := readObjectFromPath(objectPath)
+		// Hash file as blob - with -w also write it to .git/objects
+		hash, err := repo.HashObject(objectPath, flag == "-w")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while reading object: %s\n", err)
-			os.Exit(1)
-		}
-
-		// Generate object (<type> <size>\0<content>) and hashes it
-		objectBytes := generateObjectByte("blob", objectContent)
-		hash := hashObject(objectBytes)
-
-		// If -w flag is provided - write object to .git/objects
-		switch flag {
-		case "-w":
-			_, err := writeObject(objectBytes)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error while writting the object: %s\n", err)
-				os.Exit(1)
-			}
+			fmt.Fprintf(os.Stderr, "Error while writting the object: %s\n", err)
+			exit(exitCode(err))
 		}
-
-		// Print objects hash
-		fmt.Printf("%x\n", hash)
+		fmt.Println(hash)
 	case "ls-tree":
 		// Extract cmd arguments
-		treeHash, flag, err := parseLsTreeCmdArgs(os.Args[2:])
+		treeHash, flag, err := parseLsTreeCmdArgs(args[1:])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while getting tree path: %s\n", err)
-			os.Exit(1)
-		}
-
-		// Get tree content (from .git/objects/....)
-		_, _, treeContent, err := readObjectFromHash(treeHash)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while decompressing tree: %s\n", err)
-			os.Exit(1)
+			exit(exitCode(err))
 		}
 
 		// Print the tree content
-		err = printTreeData(treeContent, flag)
+		err = repo.LsTree(treeHash, flag, globalOptions.JSON, os.Stdout)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while reading tree: %s\n", err)
-			os.Exit(1)
+			exit(exitCode(err))
 		}
 	case "write-tree":
-		// Load the whole staging area (.git/index entries)
-		indexEntries, err := readGitIndex()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while reading .git/index: %s\n", err)
-			os.Exit(1)
-		}
-
-		// Make a tree struct for optimizing tree creation - without this, some object generations would be repeated
-		directoryRoot := makeDirTree(indexEntries)
-
-		// Iterate over created Tree, create required blob objects and tree objects, and populate directory nodes with hash values
-		err = dfsTreeCreation(directoryRoot)
+		// Build tree objects from the whole staging area (.git/index entries) - unchanged directories reuse cached trees
+		treeHash, err := repo.WriteTree()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while generating tree object: %s\n", err)
-			os.Exit(1)
+			exit(exitCode(err))
 		}
-		// printTree(directoryRoot)
 
 		// Print root dir hash
-		fmt.Printf("%x\n", directoryRoot.Hash)
+		fmt.Println(treeHash)
 	case "commit-tree":
 		// Extract cmd arguments
-		treeHash, commitMessage, parentHash, err := parseCommitTreeCmdArgs(os.Args[2:])
+		treeHash, commitMessage, parentHash, err := parseCommitTreeCmdArgs(args[1:])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
-		// Create content for commit object and use it to generate commit object
-		commitContent := createCommitContent(treeHash, commitMessage, parentHash)
-		objectBytes := generateObjectByte("commit", commitContent)
-
-		// Generate hash, compress object and write it to .git/objects/
-		hash, err := writeObject(objectBytes)
+		// Create commit object and write it to .git/objects/
+		hash, err := repo.CommitTree(treeHash, commitMessage, parentHash)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while writting the commit: %s\n", err)
-			os.Exit(1)
+			exit(exitCode(err))
 		}
 		// Print objects hash
-		fmt.Printf("%x\n", hash)
+		fmt.Println(hash)
 	case "clone":
-		// Extract URL and Directory names from cmd args
-		remoteUrl, directoryName, err := parseCloneCmdArgs(os.Args[2:])
+		// Extract URL, Directory names and options from cmd args
+		options, err := parseCloneCmdArgs(args[1:])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while parssing args: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
-		// Create a directory (with name that was provided)
-		err = os.MkdirAll(directoryName, 0755)
+		// Fetch refs and pack from the remote, then check out the default branch (or --branch/--tag)
+		ctx := interruptContext()
+		_, err = repoContext.Clone(ctx, options, os.Stdout)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while creating %s directory: %s\n", directoryName, err)
-			os.Exit(1)
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while cloning: %s\n", err)
+			exit(exitCode(err))
 		}
-		// Change to the new directory created to run all the other file creations
-		err = os.Chdir(directoryName)
+	case "add":
+		// Extract cmd arguments
+		paths, force, err := parseAddCmdArgs(args[1:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while changing to %s directory: %s\n", directoryName, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-		// Initialize repository inside newly created directory
-		initRepo()
 
-		fmt.Printf("Cloning from %s into %s\n", remoteUrl, directoryName)
-
-		// Send GET req to github to fetch refs (file formated as pkt-line - contains all refs that remote repository (GitHub) knows)
-		// We want only the commit object that is pointed by main HEAD
-		refs, err := fetchRefs(remoteUrl)
+		// Write blobs for all provided (non-ignored) files and update .git/index
+		err = repo.Add(paths, force)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while fetching refs: %v:\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while adding files: %s\n", err)
+			exit(exitCode(err))
 		}
-
-		hashHead, _, err := extractHeadFromRefs(refs)
+	case "status":
+		// Extract cmd arguments
+		options, err := parseStatusCmdArgs(args[1:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while extracting HEAD from refs: %v:\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-		fmt.Printf("HEAD sha1 hash: %s\n", hashHead)
+		options.JSON = globalOptions.JSON
 
-		// git-upload-pack REQUEST
-
-		// following GitHub Smart HTTP protocol make want-have request
-		request := buildUploadPackRequest(hashHead)
-		// send want-have request to get .pack file
-		packData, err := sendUploadPackRequest(remoteUrl, request)
+		// Compare HEAD tree, index and work tree
+		err = repo.Status(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while computing status: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "ls-files":
+		// Extract cmd arguments
+		options, err := parseLsFilesCmdArgs(args[1:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error during git-upload-pack request: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		// Parse pack file (extract objects - blob, trees, commits, deltified)
-		objects, err := parsePackFile(packData)
+		err = repo.LsFiles(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while listing files: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "clean":
+		// Extract cmd arguments
+		options, err := parseCleanCmdArgs(args[1:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while parsing packfile: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-		fmt.Printf("Successfully read %d objects:\n", len(objects))
 
-		// Write all objects to .git/objects
-		err = writePackObjects(objects)
+		// Remove untracked (and, depending on flags, ignored) files
+		err = repo.Clean(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while cleaning work tree: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "check-ignore":
+		// Extract cmd arguments
+		paths, verbose, nonMatching, err := parseCheckIgnoreCmdArgs(args[1:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while writing objects: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-		fmt.Printf("Successfully wrote %d objects:\n", len(objects))
 
-		err = renderFilesFromCommit(hashHead)
+		// Print every path that is ignored (with the matching pattern in verbose mode)
+		anyIgnored, err := repo.CheckIgnore(paths, verbose, nonMatching, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while loading ignore rules: %s\n", err)
+			exit(exitCode(err))
+		}
+		if !anyIgnored {
+			exit(1)
+		}
+	case "check-attr":
+		// Extract cmd arguments
+		attributes, paths, all, err := parseCheckAttrCmdArgs(args[1:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while rendering object files: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		fmt.Printf("Successfully cloned repository:\n")
+		// Print "<path>: <attr>: <value>" for every requested attribute (or every specified one with -a)
+		err = repo.CheckAttr(attributes, paths, all, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while loading attributes: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "pack-refs":
+		// Extract cmd arguments
+		all, noPrune, err := parsePackRefsCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
-		os.Exit(1)
-	}
-}
+		// Move loose refs into .git/packed-refs
+		err = repo.PackRefs(all, noPrune)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while packing refs: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "gc":
+		// Extract cmd arguments
+		options, err := parseGcCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Initialize .git repo with .git/objects .git/refs directories and .git/index .git/HEAD files
-func initRepo() error {
-	for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-		if err := os.Mkdir(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory: %v", err)
+		// Pack refs, expire reflogs, repack and prune objects, write commit-graph
+		err = repo.Gc(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while collecting garbage: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "rewrite-history":
+		// Extract cmd arguments
+		options, err := parseRewriteHistoryCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-	}
-	headFileContents := []byte("ref: refs/heads/master\n")
-	if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
-		return fmt.Errorf("failed to write HEAD file: %v", err)
-	}
 
-	err := createEmptyIndex()
-	if err != nil {
-		return fmt.Errorf("failed to create .git/index: %v", err)
-	}
-	return nil
-}
+		// Rebuild every commit without removed paths / with mailmap identities and move refs to the new history
+		err = repo.RewriteHistory(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while rewriting history: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "fsck":
+		// Extract cmd arguments
+		options, err := parseFsckCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Create empty .git/index file
-func createEmptyIndex() error {
-	// Index v2 header:
-	// 4 bytes: signature ("DIRC")
-	// 4 bytes: version (2)
-	// 4 bytes: entry count (0)
-	header := make([]byte, 12)
-	copy(header[0:4], []byte("DIRC"))
-	binary.BigEndian.PutUint32(header[4:8], 2)  // version 2
-	binary.BigEndian.PutUint32(header[8:12], 0) // 0 entries
-
-	// SHA1 checksum of the content (excluding checksum itself)
-	hash := sha1.Sum(header)
-
-	// Append checksum to end
-	full := append(header, hash[:]...)
-
-	// Write to .git/index
-	return os.WriteFile(".git/index", full, 0644)
-}
+		// Check objects and connectivity - non-zero exit when something is broken or missing
+		status, err := repo.Fsck(options, os.Stdout, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while checking objects: %s\n", err)
+			exit(exitCode(err))
+		}
+		if status != 0 {
+			exit(status)
+		}
+	case "reflog":
+		// Extract cmd arguments
+		options, err := parseReflogCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Read object from given SHA1 hash - returns ObjectType (blob/tree/commit), ObjectLen (in bytes), ObjectContent (byte array)
-func readObjectFromHash(objectHash string) (string, string, []byte, error) {
-	dir := objectHash[:2]
-	file := objectHash[2:]
-	objectPath := filepath.Join(".git", "objects", dir, file)
+		// Drop old reflog entries
+		_, err = repo.ReflogExpire(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while expiring reflogs: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "prune":
+		// Extract cmd arguments
+		options, err := parsePruneCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
-		return "", "", nil, fmt.Errorf("object on %s path not found", objectPath)
-	}
+		// Delete unreachable loose objects
+		_, err = repo.Prune(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while pruning: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "repack":
+		// Extract cmd arguments
+		options, err := parseRepackCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	data, err := os.ReadFile(objectPath)
-	if err != nil {
-		return "", "", nil, err
-	}
+		// Pack loose objects, or consolidate packs
+		err = repo.Repack(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while repacking: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "rev-list":
+		// Extract cmd arguments
+		options, err := parseRevListCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	reader, err := zlib.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return "", "", nil, err
-	}
-	defer reader.Close()
+		// Print reachable commits (and objects), using a bitmap when there is one
+		err = repo.RevList(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while listing revisions: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "maintenance":
+		// Extract cmd arguments
+		options, err := parseMaintenanceCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	decompressed, err := io.ReadAll(reader)
-	if err != nil {
-		return "", "", nil, err
-	}
+		// Run maintenance tasks (only those that are due with --auto)
+		err = repo.Maintenance(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running maintenance: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "multi-pack-index":
+		// Extract cmd arguments
+		_, err := parseMultiPackIndexCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	header, body, _ := bytes.Cut(decompressed, []byte{0x00})
+		// One lookup table for objects of every pack
+		packs, objects, err := repo.MultiPackIndex()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing multi-pack-index: %s\n", err)
+			exit(exitCode(err))
+		}
+		fmt.Printf("Indexed %d objects from %d packs\n", objects, packs)
+	case "sparse-checkout":
+		// Extract cmd arguments
+		options, err := parseSparseCheckoutCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	parts := strings.Split(string(header), " ")
-	objType, objSize := parts[0], parts[1]
+		// Update patterns, then check out included files and remove excluded ones
+		err = repo.SparseCheckout(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running sparse-checkout: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "upload-pack":
+		// Extract cmd arguments
+		options, err := parseUploadPackCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	return objType, objSize, body, nil
-}
+		// Serve fetch/clone over stdin/stdout (used by local clone, ssh remotes and serve-http)
+		err = repoContext.UploadPack(options, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while serving upload-pack: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "bundle":
+		// Extract cmd arguments
+		bundleFile, revs, err := parseBundleCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Compress given object using zlib
-func compressObject(object []byte) ([]byte, error) {
-	var b bytes.Buffer
-	zw := zlib.NewWriter(&b)
+		// Write refs and everything reachable from them into a single file (clone accepts it as remote)
+		err = repo.Bundle(bundleFile, revs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while creating bundle: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "fast-export":
+		// Extract cmd arguments
+		all, revs, err := parseFastExportCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	_, err := zw.Write(object)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compress the object")
-	}
+		err = repo.FastExport(all, revs, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while exporting: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "fast-import":
+		// Extract cmd arguments
+		quiet, err := parseFastImportCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	if err := zw.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close writter")
-	}
+		// Build objects and refs from the stream on stdin
+		counts, err := repo.FastImport(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while importing: %s\n", err)
+			exit(exitCode(err))
+		}
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Imported %d blobs, %d commits, %d tags\n", counts["blob"], counts["commit"], counts["tag"])
+		}
+	case "format-patch":
+		// Extract cmd arguments
+		options, err := parseFormatPatchCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	return b.Bytes(), nil
-}
+		// Write one mbox patch per commit (files are listed, like git does)
+		files, err := repo.FormatPatch(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while formatting patches: %s\n", err)
+			exit(exitCode(err))
+		}
+		for _, file := range files {
+			fmt.Println(file)
+		}
+	case "apply":
+		// Extract cmd arguments
+		cached, patchFiles, err := parseApplyCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Creates Object Hash using SHA1 function
-func hashObject(objectBytes []byte) []byte {
-	hasher := sha1.New()
-	hasher.Write(objectBytes)
-	hash := hasher.Sum(nil)
-	return hash
-}
+		// Work tree (or index with --cached) is changed only if every hunk applies
+		err = repo.Apply(cached, patchFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while applying patch: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "am":
+		// Extract cmd arguments
+		action, mboxFiles, err := parseAmCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Checks does object exists, and read its content
-func readObjectFromPath(path string) ([]byte, int, error) {
-	// check path
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, 0, fmt.Errorf("object on %s path not found", path)
-	}
+		err = repo.Am(action, mboxFiles, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while applying mailbox: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "commit":
+		// Extract cmd arguments
+		options, err := parseCommitCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	fileData, err := os.ReadFile(path)
-	if err != nil {
-		return nil, 0, err
-	}
+		// Commit staged changes on top of HEAD (signed with -S or commit.gpgSign)
+		_, err = repo.Commit(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while committing: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "merge":
+		// Extract cmd arguments
+		action, options, err := parseMergeCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	return fileData, len(fileData), nil
-}
+		// Merge commits into HEAD - conflicts stop it until it is committed (--continue) or --abort-ed
+		err = repo.Merge(action, options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while merging: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "stash":
+		// Extract cmd arguments
+		options, err := parseStashCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Print tree data based on provided Tree Object Content and flag
-func printTreeData(objectContent []byte, flag string) error {
-	i := 0
-	for i < len(objectContent) {
-		nullIndex := bytes.IndexByte(objectContent[i:], 0)
-		if nullIndex == -1 {
-			return fmt.Errorf("malformed tree entry")
+		// Save local changes as a stash entry, or list, show, apply, pop, drop or branch off entries
+		err = repo.Stash(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while stashing: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "rebase":
+		// Extract cmd arguments
+		action, options, err := parseRebaseCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		entryHeader := objectContent[i : i+nullIndex]
-		parts := bytes.SplitN(entryHeader, []byte(" "), 2)
-		mode := string(parts[0])
-		name := string(parts[1])
+		// Replay commits on the new base - a conflict stops it until --continue, --skip or --abort
+		err = repo.Rebase(action, options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while rebasing: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "cherry-pick":
+		// Extract cmd arguments
+		action, options, err := parseCherryPickCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-		i += nullIndex + 1
-		if i+20 > len(objectContent) {
-			return fmt.Errorf("unexpected end of SHA")
+		// Pick commits onto HEAD - a conflict stops it until --continue, --skip or --abort
+		err = repo.CherryPick(action, options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while cherry-picking: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "revert":
+		// Extract cmd arguments
+		action, options, err := parseRevertCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		shaBytes := objectContent[i : i+20]
-		shaHex := fmt.Sprintf("%x", shaBytes)
-		i += 20
+		// Undo commits with new commits on HEAD - a conflict stops it until --continue, --skip or --abort
+		err = repo.Revert(action, options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while reverting: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "rerere":
+		// Extract cmd arguments
+		action, paths, err := parseRerereCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-		if flag == "--name-only" {
-			fmt.Println(name)
-		} else {
-			fmt.Printf("%s %s %s\n", mode, shaHex, name)
+		err = repo.Rerere(action, paths, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running rerere: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "branch":
+		// Extract cmd arguments
+		options, err := parseBranchCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-	}
 
-	return nil
-}
+		// Create branch, list branches (filtered by --merged, --no-merged, --contains) or delete them with -d/-D
+		err = repo.Branch(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running branch: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "remote":
+		// Extract cmd arguments
+		options, err := parseRemoteCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Generate object with header and content (<type> <size>\0<content>) with provided type and content
-func generateObjectByte(objectType string, objectContent []byte) []byte {
-	header := objectType + " " + strconv.Itoa(len(objectContent))
-	headerNull := append([]byte(header), byte(0))
-	return append(headerNull, objectContent...)
-}
-
-// Takes in raw objet bytes, creates hash using SHA1, compress and write the object,
-func writeObject(object []byte) ([]byte, error) {
-
-	hash := hashObject(object)
-	compressedObject, err := compressObject(object)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error while compresing commit: %s\n", err)
-		os.Exit(1)
-	}
-
-	hashString := fmt.Sprintf("%x", hash)
-
-	dirName := hashString[:2]
-	fileName := hashString[2:]
-
-	dirPath := path.Join(".git/objects", dirName)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory: %v", err)
-	}
-
-	fullPath := path.Join(dirPath, fileName)
-
-	if _, err := os.Stat(fullPath); err == nil {
-		return hash, nil
-	} else if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("error checking object file: %v", err)
-	}
-
-	if err := os.WriteFile(fullPath, compressedObject, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write object file: %v", err)
-	}
-
-	return hash, nil
-}
-
-// Read .git/index file to retrieve all entries from it - returns IndexEntry array - used for write-tree command to write everything from staging area (.git/index)
-func readGitIndex() ([]IndexEntry, error) {
-	file, err := os.Open(".git/index")
-	if err != nil {
-		return nil, err
-	}
-
-	defer file.Close()
-
-	header := make([]byte, 12)
-	if _, err := io.ReadFull(file, header); err != nil {
-		return nil, err
-	}
-
-	if string(header[:4]) != "DIRC" {
-		return nil, fmt.Errorf("invalid index signature")
-	}
-
-	version := binary.BigEndian.Uint32(header[4:8])
-	if version != 2 {
-		return nil, fmt.Errorf("unsupported index version: %d", version)
-	}
-
-	entryCount := binary.BigEndian.Uint32(header[8:12])
-	entries := make([]IndexEntry, 0, entryCount)
-
-	for i := 0; i < int(entryCount); i++ {
-		entryHeader := make([]byte, 62)
-		if _, err := io.ReadFull(file, entryHeader); err != nil {
-			return nil, fmt.Errorf("reading entry header: %w", err)
+		// List, add, remove, rename remotes or change their URLs - show compares the remote's refs with ours
+		ctx := interruptContext()
+		err = repo.Remote(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while running remote: %s\n", err)
+			exit(exitCode(err))
 		}
-
-		mode := binary.BigEndian.Uint32(entryHeader[24:28])
-		hash := make([]byte, 20)
-		copy(hash, entryHeader[40:60])
-
-		flags := binary.BigEndian.Uint16(entryHeader[60:62])
-		nameLen := int(flags & 0x0FFF)
-
-		nameBytes := make([]byte, nameLen)
-		if _, err := io.ReadFull(file, nameBytes); err != nil {
-			return nil, fmt.Errorf("reading path: %w", err)
+	case "fetch":
+		// Extract cmd arguments
+		options, err := parseFetchCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		totalLen := 62 + nameLen
-		padding := (8 - (totalLen % 8)) % 8
-		if _, err := io.CopyN(io.Discard, file, int64(padding)); err != nil {
-			return nil, fmt.Errorf("discarding padding: %w", err)
+		// Download objects and update remote-tracking refs (and FETCH_HEAD)
+		ctx := interruptContext()
+		err = repo.Fetch(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while fetching: %s\n", err)
+			exit(exitCode(err))
 		}
-
-		entry := IndexEntry{
-			Path: string(nameBytes),
-			Hash: hash,
-			Mode: mode,
+	case "pull":
+		// Extract cmd arguments
+		options, err := parsePullCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		entries = append(entries, entry)
-	}
-
-	return entries, nil
-}
-
-// Creates Tree struct based on provided IndexEntries from .git/index
-func makeDirTree(indexEntries []IndexEntry) *TreeNode {
-	root := &TreeNode{
-		Children: make(map[string]*TreeNode),
-		Mode:     40000,
-	}
-
-	root.Name = "root"
-	root.IsDir = true
-
-	for _, entry := range indexEntries {
-		insertInTree(root, entry.Path, &entry)
-	}
-
-	return root
-}
-
-// Insert object on right place in the tree based on path - recursive
-func insertInTree(root *TreeNode, path string, entry *IndexEntry) {
-	// Get path parts by string splitting
-	pathParts := strings.Split(path, "/")
-
-	// Next path will not include current dir (if provided path is /app/src/text.txt - nextPath would be /src/text.txt)
-	nextPath := strings.Join(pathParts[1:], "/")
-
-	// If directory already exists - no need to create it
-	if _, ok := root.Children[pathParts[0]]; ok {
-		insertInTree(root.Children[pathParts[0]], nextPath, entry)
-		return
-	}
-
-	// Create new TreeNode and populate it
-	newNode := &TreeNode{
-		Children: make(map[string]*TreeNode),
-		Name:     pathParts[0],
-	}
-
-	// If path only has 1 element, that means that we are at 'leaf node' - STOPPING POINT in recursion
-	if len(pathParts) == 1 {
-		newNode.Hash = entry.Hash
-		newNode.IsDir = false
-		newNode.Mode = entry.Mode
-		root.Children[pathParts[0]] = newNode
-		return
-	}
-
-	newNode.IsDir = true
-	newNode.Mode = 40000
-	root.Children[pathParts[0]] = newNode
-	insertInTree(root.Children[pathParts[0]], nextPath, entry)
-}
-
-// Traverse down the Tree using DFS and prints each node name, hash and mode
-func printTree(root *TreeNode) {
-	if root == nil {
-		return
-	}
-
-	for _, child := range root.Children {
-		printTree(child)
-	}
-	fmt.Printf("Name: %s, hash: %x, mode: %s\n", root.Name, root.Hash)
-}
-
-// It will recursively create deepest subdirectories first, and then move up...
-func dfsTreeCreation(root *TreeNode) error {
-
-	// If it is a file - can't go deeper
-	if len(root.Children) == 0 {
-		return nil
-	}
-
-	// Create each subdirectory first
-	for _, child := range root.Children {
-		if child.IsDir {
-			if err := dfsTreeCreation(child); err != nil {
-				return err
-			}
+		// Fetch, then merge the fetched branch (or rebase onto it)
+		ctx := interruptContext()
+		err = repo.Pull(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while pulling: %s\n", err)
+			exit(exitCode(err))
 		}
-	}
-
-	// At this moment, we know that each sub-file/dir is already created
-	hash, err := createTree(root)
-	if err != nil {
-		return err
-	}
-
-	root.Hash = hash
-	return nil
-}
-
-// Creates compressed tree object and return its hash
-func createTree(root *TreeNode) ([]byte, error) {
-	// Tree content will consist of its children
-	treeContent := createTreeContent(root.Children)
-	//
-	treeByteObject := generateObjectByte("tree", treeContent)
-	hash, err := writeObject(treeByteObject)
-	if err != nil {
-		return nil, err
-	}
-	return hash, nil
-}
-
-// Iterates over childer hashMap and creates tree content (for each child: <mode> <type> <sha1_hash> <name>)
-func createTreeContent(children map[string]*TreeNode) []byte {
-	var content []byte
-	var keys []string
-	for name := range children {
-		keys = append(keys, name)
-	}
-	sort.Strings(keys)
-
-	for _, name := range keys {
-		child := children[name]
-
-		var modeStr string
-		if child.IsDir {
-			modeStr = "40000"
-		} else {
-			modeStr = fmt.Sprintf("%06o", child.Mode)
+	case "push":
+		// Extract cmd arguments
+		options, err := parsePushCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		entryHeader := fmt.Sprintf("%s %s", modeStr, child.Name)
-		content = append(content, []byte(entryHeader)...)
-		content = append(content, 0)
-
-		content = append(content, child.Hash[:]...)
-
-	}
-
-	return content
-}
-
-// Creates a content for commit object with provided treeHash, commitMessage and parentHash - it uses hardcoded vals for username and email
-func createCommitContent(treeHash, commitMessage, parentHash string) []byte {
-	authorName := "obradovicsl"
-	authorEmail := "slobodanobradovic3@gmail.com"
-	now := time.Now()
-	timestamp := now.Unix()
-	timezoneOffset := now.Format("-0700") // Git-style timezone
-
-	content := ""
-	content += fmt.Sprintf("tree %s\n", treeHash)
-	if parentHash != "" {
-		content += fmt.Sprintf("parent %s\n", parentHash)
-	}
-
-	content += fmt.Sprintf("author %s <%s> %d %s\n", authorName, authorEmail, timestamp, timezoneOffset)
-	content += fmt.Sprintf("committer %s <%s> %d %s\n", authorName, authorEmail, timestamp, timezoneOffset)
-	content += "\n"
-	content += commitMessage
-	content += "\n"
-
-	return []byte(content)
-}
-
-///////////////////////////// CLONE //////////////////////////////////////////
-
-// Sends HTTP GET request on /info/refs?service=git-upload-pack URL to get refs file.
-func fetchRefs(remoteUrl string) ([]byte, error) {
-	refsUrl := fmt.Sprintf("%s/info/refs?service=git-upload-pack", remoteUrl)
-
-	resp, err := http.Get(refsUrl)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch refs: %v", err)
-	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	return body, nil
-}
-
-// Extracts HEAD sha1 hash, and capabilities from refs file
-func extractHeadFromRefs(byteRefs []byte) (string, string, error) {
-	refs, capabilities, err := parseRefs(byteRefs)
-	if err != nil {
-		return "", "", err
-	}
-
-	return refs["HEAD"], capabilities, nil
-}
+		// Send objects and update refs of the remote
+		ctx := interruptContext()
+		err = repo.Push(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while pushing: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "tag":
+		// Extract cmd arguments
+		options, err := parseTagCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Parse refs file, and make hashMap out of it
-func parseRefs(body []byte) (map[string]string, string, error) {
-	refs := make(map[string]string)
-	var capabilities string
-
-	scanner := bufio.NewScanner(bytes.NewReader(body))
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) > 4 && string(line[4:]) != "" && !bytes.HasPrefix(line[4:], []byte("#")) {
-			// Split the line by null byte
-
-			parts := bytes.Split(line[4:], []byte{0x00})
-			var caps []byte
-			if len(parts) > 1 {
-				caps = parts[1]
-				capabilities = string(caps)
+		// Signature check with -v - verifier report goes to stderr, like verify-tag
+		if options.Verify {
+			verified, err := repo.VerifyTags(options.Names, os.Stdout, os.Stderr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while verifying: %s\n", err)
+				exit(exitCode(err))
 			}
-
-			if len(parts) > 0 {
-				chunk2 := parts[0]
-
-				// Check if the string ends with "HEAD", then remove the first 4 characters
-				if len(chunk2) > 4 && bytes.HasSuffix(chunk2, []byte("HEAD")) {
-					chunk2 = chunk2[4:]
-				}
-
-				// Split by space to form the chunk array
-				chunk := bytes.Split(chunk2, []byte(" "))
-				if len(chunk) >= 2 {
-					// Decode chunk[0] and chunk[1] and store them in refs map
-					refs[string(chunk[1])] = string(chunk[0])
-				}
+			if !verified {
+				exit(1)
 			}
+			break
 		}
-	}
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error scanning response body:", err)
-	}
-
-	return refs, capabilities, nil
-}
-
-// Build have-want request body
-func buildUploadPackRequest(hash string) []byte {
-	var buf bytes.Buffer
-
-	// First line: "want <hash> <capabilities>\n"
-	wantLine := fmt.Sprintf("want %s\n", hash)
-	writePktLine(&buf, wantLine)
-
-	buf.WriteString("0000")
-	// Second line - done - we don't want anything more
-	writePktLine(&buf, "done\n")
 
-	buf.WriteString("0000")
-
-	return buf.Bytes()
-}
-
-// Writes one line to Writer in pkt-line format
-func writePktLine(w io.Writer, line string) {
-	length := len(line) + 4
-	fmt.Fprintf(w, "%04x%s", length, line)
-}
-
-// Sends HTTP request to /git-upload-pack, to retrieve .pack file
-func sendUploadPackRequest(remoteUrl string, request []byte) ([]byte, error) {
-	url := remoteUrl + "/git-upload-pack"
-
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewReader(request))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create POST request: %v", err)
-	}
-
-	// REQUIRED headers for smart HTTP upload-pack request
-	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
-	req.Header.Set("Accept", "application/x-git-upload-pack-result")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	packData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	return packData, nil
-}
-
-// Parse pack file - header (version and obj size) and content (objects), and extract all object from it
-func parsePackFile(data []byte) ([]GitObject, error) {
-
-	// end of .pack file a check sum (last 20 bytes) - we don't need that now
-	data = data[:len(data)-20]
-
-	offset := bytes.Index(data, []byte("PACK")) + 4
-	version := binary.BigEndian.Uint32(data[offset : offset+4])
-	offset += 4
-	numObjects := binary.BigEndian.Uint32(data[offset : offset+4])
-	offset += 4
-
-	objects := make([]GitObject, 0, numObjects)
-
-	fmt.Printf("Version: %d, %d objects\n", version, numObjects)
-
-	for i := 0; i < int(numObjects); i++ {
-
-		_, used, objType, err := parseObjectHeader(data[offset:])
+		// Lightweight tag, or annotated tag object (signed with -s/-u or tag.gpgSign) - or list of tags with -l,
+		// deletion with -d
+		err = repo.Tag(options, os.Stdout)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse object header: %v", err)
+			fmt.Fprintf(os.Stderr, "Error while running tag: %s\n", err)
+			exit(exitCode(err))
 		}
-		offset += used
-		var baseObjHash string
-		if objType == OBJ_REF_DELTA {
-			baseObjHash = hex.EncodeToString(data[offset : offset+20])
-			offset += 20
-		} else if objType == OBJ_OFS_DELTA {
-			_, ofsLen := parseDeltaOffset(data[offset:])
-			offset += ofsLen
+	case "replace":
+		// Extract cmd arguments
+		options, err := parseReplaceCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
 
-		zlibStart := offset
-		decompressed, used, err := readZlibObject(data[zlibStart:])
+		// Point refs/replace/<object> to the replacement - or list replaced objects (-l), delete replace refs (-d)
+		err = repo.Replace(options, os.Stdout)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read obj delta content at %d: %w", zlibStart, err)
+			fmt.Fprintf(os.Stderr, "Error while running replace: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "rev-parse":
+		// Extract cmd arguments
+		options, err := parseRevParseCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-		offset += used
-
-		objects = append(objects, GitObject{
-			Type:        objType,
-			BaseObjHash: baseObjHash,
-			Data:        decompressed,
-		})
-	}
-
-	return objects, nil
-}
-
-// Parse object header - retrieve obj size, obj type and number of used bytes
-func parseObjectHeader(data []byte) (uint64, int, ObjectType, error) {
-	used := 0
-	// Header is usually the first byte
-	byteData := data[used]
-	used++
-
-	// Object type is always (6-4 bits)
-	objectType := ObjectType((byteData >> 4) & 0x7)
-	size := uint64(byteData & 0xF)
-	shift := 4
-	// If MSB == 1, we have to look the next byte
-	for byteData&0x80 != 0 {
-		// MSB == 1
-		if len(data) <= used || 64 <= shift {
-			return 0, 0, 0, fmt.Errorf("bad object header")
-		}
-		byteData = data[used]
-		used++
-		size += uint64(byteData&0x7F) << shift
-		shift += 7
-	}
-
-	return size, used, objectType, nil
-}
 
-// Parse DELTA_OFS offset
-func parseDeltaOffset(data []byte) (val uint64, used int) {
-	b := data[0]
-	val = uint64(b & 0x7F)
-	used = 1
-	for b&0x80 != 0 {
-		b = data[used]
-		val = (val+1)<<7 | uint64(b&0x7F)
-		used++
-	}
-	return
-}
+		// Object names of revisions - <rev>^{<type>} peels tags (and commits to trees)
+		err = repo.RevParse(options, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing revisions: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "verify-commit", "verify-tag":
+		// Extract cmd arguments
+		verbose, names, err := parseVerifyCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Read and decompress the whole Zlib object - returns object and number of used bytes
-func readZlibObject(pack []byte) ([]byte, int, error) {
-	reader := bytes.NewReader(pack)
-	r, err := zlib.NewReader(reader)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer r.Close()
+		// Verifier report goes to stderr, like git does - any bad or unverifiable signature fails the command
+		verified, err := repo.Verify(command[len("verify-"):], names, verbose, os.Stdout, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while verifying: %s\n", err)
+			exit(exitCode(err))
+		}
+		if !verified {
+			exit(1)
+		}
+	case "log":
+		// Extract cmd arguments
+		options, err := parseLogCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
+		options.JSON = globalOptions.JSON
 
-	decompData, err := io.ReadAll(r)
-	if err != nil {
-		return nil, 0, err
-	}
+		// Long output is paged on a terminal
+		git.StartPager("log", globalOptions.NoPager)
+		err = repo.Log(options, os.Stdout)
+		git.StopPager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing log: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "show":
+		// Extract cmd arguments
+		options, err := parseShowCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	used := int(reader.Size()) - reader.Len()
+		// Long output is paged on a terminal
+		git.StartPager("show", globalOptions.NoPager)
+		err = repo.Show(options, os.Stdout)
+		git.StopPager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while showing objects: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "diff":
+		// Extract cmd arguments
+		options, err := parseDiffCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	return decompData, used, nil
-}
+		// Long output is paged on a terminal
+		git.StartPager("diff", globalOptions.NoPager)
+		err = repo.Diff(options, os.Stdout)
+		git.StopPager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing diff: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "difftool":
+		// Extract cmd arguments
+		options, err := parseDifftoolCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Write object to .git/objects
-func writeObjectWithType(content []byte, objectType ObjectType) ([]byte, error) {
-	object := generateObjectByte(objectType.String(), content)
-	// Write to disk
-	hash, err := writeObject(object)
-	if err != nil {
-		return nil, err
-	}
-	return hash, nil
-}
+		if err := repo.Difftool(options, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running difftool: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "mergetool":
+		// Extract cmd arguments
+		options, err := parseMergetoolCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-// Takes a list of objects, and write them
-func writePackObjects(objects []GitObject) error {
+		if err := repo.Mergetool(options, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running mergetool: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "ls-remote":
+		// Extract cmd arguments
+		options, err := parseLsRemoteCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
+		options.JSON = globalOptions.JSON
 
-	for _, obj := range objects {
-		if obj.Type == OBJ_BLOB || obj.Type == OBJ_COMMIT || obj.Type == OBJ_TREE || obj.Type == OBJ_TAG {
-			_, err := writeObjectWithType(obj.Data, obj.Type)
-			if err != nil {
-				return fmt.Errorf("failed to write %s object: %v", string(obj.Type), err)
-			}
+		ctx := interruptContext()
+		err = repoContext.LsRemote(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while listing remote refs: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "receive-pack":
+		// Extract cmd arguments
+		options, err := parseReceivePackCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-		} else if obj.Type == OBJ_REF_DELTA {
-			err := writeRefDeltaObject(obj)
-			if err != nil {
-				return fmt.Errorf("failed to write %s object: %v", string(obj.Type), err)
-			}
+		// Serve push over stdin/stdout (used by local and ssh remotes, and serve-http)
+		err = repoContext.ReceivePack(options, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while serving receive-pack: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "daemon":
+		// Extract cmd arguments
+		options, err := parseDaemonCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
 		}
-	}
-	return nil
-}
 
-// Writes one DELTA_REF object
-func writeRefDeltaObject(object GitObject) error {
-	baseType, _, baseData, err := readObjectFromHash(object.BaseObjHash)
-	if err != nil {
-		return fmt.Errorf("failed to find base object for delta: %v", err)
-	}
-	read := 0
-	_, _, used := parseDeltaHeader(object.Data)
-	read += used
-	deltaObject := object.Data[read:]
+		// Runs until Ctrl-C
+		ctx := interruptContext()
+		err = repoContext.Daemon(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while serving: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "serve-http":
+		// Extract cmd arguments
+		options, err := parseServeHttpCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	reconstructed, err := applyDelta(baseData, deltaObject)
-	if err != nil {
-		return fmt.Errorf("failed to apply delta: %w", err)
-	}
+		// Runs until Ctrl-C
+		ctx := interruptContext()
+		err = repoContext.ServeHttp(ctx, options, os.Stdout)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fmt.Fprintf(os.Stderr, "Error while serving: %s\n", err)
+			exit(exitCode(err))
+		}
+	case "credential-store":
+		// Extract cmd arguments
+		storeFile, action, err := parseCredentialStoreCmdArgs(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while parsing args: %s\n", err)
+			exit(1)
+		}
 
-	objType, err := ObjectTypeFromString(baseType)
-	if err != nil {
-		return fmt.Errorf("unknown base object type: %v", err)
-	}
+		// Credential helper protocol - credential on stdin, answer (for get) on stdout
+		err = git.CredentialStore(storeFile, action, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while running credential store: %s\n", err)
+			exit(exitCode(err))
+		}
 
-	_, err = writeObjectWithType(reconstructed, objType)
-	if err != nil {
-		return fmt.Errorf("failed to write delta object: %v", err)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
+		exit(1)
 	}
-	return nil
+	exit(0)
 }
 
-// Read var-length (if MSB == 1, then it has to read the next byte - the process repeats until it reads a byte with MSB == 0)
-func parseDeltaHeader(objectData []byte) (int, int, int) {
-	read := 0
-	srcSize, used := parseDeltaSize(objectData)
-	read += used
-	targetSize, used := parseDeltaSize(objectData[read:])
-	read += used
-	return srcSize, targetSize, read
+// Log how long the command took (GIT_TRACE_PERFORMANCE) and exit with code
+func exit(code int) {
+	git.TraceCommandEnd()
+	os.Exit(code)
 }
 
-func parseDeltaSize(packFile []byte) (int, int) {
-	size := packFile[0] & 0b01111111
-	index, off := 1, 7
-
-	for packFile[index-1]&0b10000000 > 0 { // Check if MSB is set
-		size = size | (packFile[index]&0b01111111)<<off
-		off += 7
-		index += 1
-	}
-
-	// this index is the same as the used bytes
-
-	return int(size), index
+// Context canceled by Ctrl-C (or SIGTERM) - network commands stop their transfer and return. A second
+// signal kills the process as usual.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx
 }
 
-// Takes base object, and delta object, then apply COPY and INSERT instructions from delta object
-func applyDelta(base, delta []byte) ([]byte, error) {
-	var result []byte
-	i := 0
-	for i < len(delta) {
-		op := delta[i]
-		i++
-		if op&0x80 != 0 {
-			// COPY from base
-			var offset, size int
-			// offset
-			if op&0x01 != 0 {
-				offset |= int(delta[i])
-				i++
-			}
-			if op&0x02 != 0 {
-				offset |= int(delta[i]) << 8
-				i++
-			}
-			if op&0x04 != 0 {
-				offset |= int(delta[i]) << 16
-				i++
-			}
-			if op&0x08 != 0 {
-				offset |= int(delta[i]) << 24
-				i++
-			}
-			// size
-			if op&0x10 != 0 {
-				size |= int(delta[i])
-				i++
-			}
-			if op&0x20 != 0 {
-				size |= int(delta[i]) << 8
-				i++
-			}
-			if op&0x40 != 0 {
-				size |= int(delta[i]) << 16
-				i++
-			}
-			if size == 0 {
-				size = 0x10000
-			} // default
-			result = append(result, base[offset:offset+size]...)
-		} else {
-			// INSERT new bytes
-			size := int(op)
-			result = append(result, delta[i:i+size]...)
-			i += size
-		}
+// Exit with 130 (like git killed by SIGINT) when the command failed because it was interrupted
+func exitIfInterrupted(ctx context.Context) {
+	if ctx.Err() != nil {
+		exit(130)
 	}
-	return result, nil
 }
 
-// Generate all files from provided branch
-func renderFilesFromCommit(branchHash string) error {
-	_, _, commit, err := readObjectFromHash(branchHash)
-	if err != nil {
-		return fmt.Errorf("failed to read HEAD commit (%s): %v", branchHash, err)
+// Exit code for failed command, like git - 128 for fatal errors (no repository, missing or corrupt object,
+// authentication), 1 for everything else (rejected non-fast-forward update included)
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, git.ErrNotARepository), errors.Is(err, git.ErrObjectNotFound),
+		errors.Is(err, git.ErrCorruptObject), errors.Is(err, git.ErrAuth):
+		return 128
 	}
-
-	lines := strings.Split(string(commit), "\n")
-	var treeHash string
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "tree") {
-			treeHash = strings.TrimPrefix(line, "tree ")
-			break
-		}
-	}
-
-	if treeHash == "" {
-		return fmt.Errorf("tree hash not found in commit")
-	}
-
-	return renderTreeRecursive(treeHash, ".")
+	return 1
 }
-
-// Render the whole tree recursively 
-func renderTreeRecursive(treeHash, currentPath string) error {
-	objType, _, content, err := readObjectFromHash(treeHash)
-	if err != nil {
-		return fmt.Errorf("cannot read tree %s: %v", treeHash, err)
-	}
-	if objType != "tree" {
-		return fmt.Errorf("object %s is not a tree", treeHash)
-	}
-
-	// content of a directory (files/dirs)
-	data := content
-	i := 0
-	for i < len(data) {
-		// Read mode
-		modeEnd := bytes.IndexByte(data[i:], ' ')
-		mode := string(data[i : i+modeEnd])
-		i += modeEnd + 1
-
-		// Read name
-		nameEnd := bytes.IndexByte(data[i:], 0)
-		name := string(data[i : i+nameEnd])
-		i += nameEnd + 1
-
-		// Read SHA (20 bytes)
-		shaBytes := data[i : i+20]
-		objHash := hex.EncodeToString(shaBytes)
-		i += 20
-
-		fullPath := filepath.Join(currentPath, name)
-
-		if mode == "40000" {
-			// directory
-			if err := os.MkdirAll(fullPath, 0755); err != nil {
-				return err
-			}
-			if err := renderTreeRecursive(objHash, fullPath); err != nil {
-				return err
-			}
-		} else {
-			// blob (file)
-			typ, _, blobContent, err := readObjectFromHash(objHash)
-			if err != nil {
-				return err
-			}
-			if typ != "blob" {
-				return fmt.Errorf("expected blob, got %s", typ)
-			}
-			if err := os.WriteFile(fullPath, blobContent, 0644); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
\ No newline at end of file
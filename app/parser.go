@@ -1,6 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/obradovicsl/mini-git/git"
+)
 
 // Parsers for each available command - check the command format and return required infos
 
@@ -75,16 +81,1893 @@ func parseCommitTreeCmdArgs(args []string) (string, string, string, error) {
 	return treeHash, message, parentSHA, nil
 }
 
-func parseCloneCmdArgs(args []string) (string, string, error) {
-	if len(args) != 2 {
-		return "", "", fmt.Errorf("use: git clone <URL> <some_dir>")
+func parseCloneCmdArgs(args []string) (git.CloneOptions, error) {
+	var options git.CloneOptions
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-b", "--branch", "--tag":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "--tag" {
+				options.Tag = args[i]
+			} else {
+				options.Branch = args[i]
+			}
+		case "--depth":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			depth, err := strconv.Atoi(args[i])
+			if err != nil || depth < 1 {
+				return options, fmt.Errorf("depth %s is not a positive number", args[i])
+			}
+			options.Depth = depth
+		case "--token":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.Token = args[i]
+		case "--filter":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.Filter = args[i]
+		case "--reference":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.Reference = args[i]
+		case "-s", "--shared":
+			options.Shared = true
+		case "--bare":
+			options.Bare = true
+		case "--mirror":
+			options.Mirror = true
+		case "--sparse":
+			options.Sparse = true
+		default:
+			if filter, ok := strings.CutPrefix(arg, "--filter="); ok {
+				options.Filter = filter
+				continue
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) != 2 {
+		return options, fmt.Errorf("use: git clone [--branch <name> | --tag <name>] [--depth <n>] [--filter <spec>] [--token <token>] [--shared] [--reference <repo>] [--bare | --mirror] [--sparse] <URL> <some_dir>")
+	}
+	if options.Branch != "" && options.Tag != "" {
+		return options, fmt.Errorf("--branch and --tag cannot be used together")
+	}
+	if options.Mirror && (options.Branch != "" || options.Tag != "") {
+		return options, fmt.Errorf("--mirror clones every ref - it cannot be used with --branch or --tag")
+	}
+	if options.Filter != "" {
+		if err := git.ValidateFilterSpec(options.Filter); err != nil {
+			return options, err
+		}
+	}
+
+	options.Url = positional[0]
+	options.Directory = positional[1]
+
+	return options, nil
+}
+
+func parseAddCmdArgs(args []string) ([]string, bool, error) {
+	var paths []string
+	force := false
+	for _, arg := range args {
+		switch arg {
+		case "-f", "--force":
+			force = true
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, false, fmt.Errorf("use: git add [-f] <pathspec>...")
+	}
+
+	return paths, force, nil
+}
+
+func parseStatusCmdArgs(args []string) (git.StatusOptions, error) {
+	var options git.StatusOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--ignored":
+			options.ShowIgnored = true
+		case arg == "--porcelain" || arg == "--porcelain=v1":
+			options.Porcelain = 1
+		case arg == "--porcelain=v2":
+			options.Porcelain = 2
+		case arg == "-b" || arg == "--branch":
+			options.Branch = true
+		case arg == "-z":
+			options.NullTerminated = true
+		case parseColorOption(arg, &options.Color):
+		default:
+			return options, fmt.Errorf("use: git status [--ignored] [--porcelain[=<version>]] [-b] [-z] [--color[=<when>]]")
+		}
+	}
+	// -z alone means porcelain v1
+	if options.NullTerminated && options.Porcelain == 0 {
+		options.Porcelain = 1
+	}
+
+	return options, nil
+}
+
+// Parse --color[=<when>] (always when not given) and --no-color - returns false if arg is not one of them
+func parseColorOption(arg string, color *string) bool {
+	switch {
+	case arg == "--color":
+		*color = "always"
+	case strings.HasPrefix(arg, "--color="):
+		*color = strings.TrimPrefix(arg, "--color=")
+	case arg == "--no-color":
+		*color = "never"
+	default:
+		return false
+	}
+	return true
+}
+
+func parseLsFilesCmdArgs(args []string) (git.LsFilesOptions, error) {
+	var options git.LsFilesOptions
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			options.Paths = append(options.Paths, args[i+1:]...)
+			return options, nil
+		case arg == "-s" || arg == "--stage":
+			options.Stage = true
+		case arg == "-u" || arg == "--unmerged":
+			options.Stage, options.Unmerged = true, true
+		case arg == "-c" || arg == "--cached":
+			// Index entries are all ls-files lists
+		case arg == "-z":
+			options.NullTerminated = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("use: git ls-files [-s] [-u] [-z] [[--] <path>...]")
+		default:
+			options.Paths = append(options.Paths, arg)
+		}
+	}
+	return options, nil
+}
+
+func parseLsRemoteCmdArgs(args []string) (git.LsRemoteOptions, error) {
+	var options git.LsRemoteOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--heads" || arg == "-h":
+			options.Heads = true
+		case arg == "--tags" || arg == "-t":
+			options.Tags = true
+		case strings.HasPrefix(arg, "-") || options.Remote != "":
+			return options, fmt.Errorf("use: git ls-remote [--heads] [--tags] [<repository>]")
+		default:
+			options.Remote = arg
+		}
+	}
+	if options.Remote == "" {
+		options.Remote = "origin"
+	}
+	return options, nil
+}
+
+func parseCleanCmdArgs(args []string) (git.CleanOptions, error) {
+	var options git.CleanOptions
+	force := false
+	for _, arg := range args {
+		if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+			return options, fmt.Errorf("use: git clean [-n] [-f] [-d] [-x | -X]")
+		}
+		// Short flags can be combined (-fdx)
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 'n':
+				options.DryRun = true
+			case 'f':
+				force = true
+			case 'd':
+				options.Dirs = true
+			case 'x':
+				options.NoIgnore = true
+			case 'X':
+				options.OnlyIgnored = true
+			default:
+				return options, fmt.Errorf("use: git clean [-n] [-f] [-d] [-x | -X]")
+			}
+		}
+	}
+
+	if options.NoIgnore && options.OnlyIgnored {
+		return options, fmt.Errorf("-x and -X cannot be used together")
+	}
+	if !force && !options.DryRun {
+		return options, fmt.Errorf("refusing to clean without -f or -n")
+	}
+
+	return options, nil
+}
+
+func parseCheckIgnoreCmdArgs(args []string) ([]string, bool, bool, error) {
+	var paths []string
+	verbose := false
+	nonMatching := false
+	for _, arg := range args {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		case "-n", "--non-matching":
+			nonMatching = true
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, false, false, fmt.Errorf("use: git check-ignore [-v] [-n] <pathname>...")
+	}
+	if nonMatching && !verbose {
+		return nil, false, false, fmt.Errorf("--non-matching is only valid with --verbose")
+	}
+
+	return paths, verbose, nonMatching, nil
+}
+
+func parseCheckAttrCmdArgs(args []string) ([]string, []string, bool, error) {
+	var attributes, paths []string
+	all := false
+
+	// Attributes come first, then paths - "--" separates them explicitly
+	rest := args
+	if len(rest) > 0 && (rest[0] == "-a" || rest[0] == "--all") {
+		all = true
+		rest = rest[1:]
+	}
+	separator := -1
+	for i, arg := range rest {
+		if arg == "--" {
+			separator = i
+			break
+		}
+	}
+
+	switch {
+	case separator != -1:
+		attributes, paths = rest[:separator], rest[separator+1:]
+	case all:
+		paths = rest
+	case len(rest) >= 2:
+		attributes, paths = rest[:1], rest[1:]
+	}
+
+	if len(paths) == 0 || (!all && len(attributes) == 0) || (all && len(attributes) > 0) {
+		return nil, nil, false, fmt.Errorf("use: git check-attr [-a | <attr>... --] <pathname>...")
+	}
+
+	return attributes, paths, all, nil
+}
+
+func parsePackRefsCmdArgs(args []string) (bool, bool, error) {
+	all := false
+	noPrune := false
+	for _, arg := range args {
+		switch arg {
+		case "--all":
+			all = true
+		case "--no-prune":
+			noPrune = true
+		case "--prune":
+			noPrune = false
+		default:
+			return false, false, fmt.Errorf("use: git pack-refs [--all] [--no-prune]")
+		}
+	}
+
+	return all, noPrune, nil
+}
+
+func parseGcCmdArgs(args []string) (git.GcOptions, error) {
+	var options git.GcOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--auto":
+			options.Auto = true
+		case arg == "--no-prune":
+			options.Prune = "never"
+		case strings.HasPrefix(arg, "--prune="):
+			options.Prune = strings.TrimPrefix(arg, "--prune=")
+		default:
+			return options, fmt.Errorf("use: git gc [--auto] [--prune=<date> | --no-prune]")
+		}
+	}
+	return options, nil
+}
+
+func parseRewriteHistoryCmdArgs(args []string) (git.RewriteHistoryOptions, error) {
+	var options git.RewriteHistoryOptions
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--no-gc":
+			options.NoGc = true
+		case "--remove-path", "--mailmap":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "--mailmap" {
+				options.Mailmap = args[i]
+			} else {
+				options.RemovePaths = append(options.RemovePaths, args[i])
+			}
+		default:
+			if path, ok := strings.CutPrefix(arg, "--remove-path="); ok {
+				options.RemovePaths = append(options.RemovePaths, path)
+				continue
+			}
+			if file, ok := strings.CutPrefix(arg, "--mailmap="); ok {
+				options.Mailmap = file
+				continue
+			}
+			return options, fmt.Errorf("use: git rewrite-history [--remove-path <path>]... [--mailmap <file>] [--no-gc]")
+		}
+	}
+	if len(options.RemovePaths) == 0 && options.Mailmap == "" {
+		return options, fmt.Errorf("use: git rewrite-history [--remove-path <path>]... [--mailmap <file>] [--no-gc]")
+	}
+	return options, nil
+}
+
+func parseFsckCmdArgs(args []string) (git.FsckOptions, error) {
+	var options git.FsckOptions
+	for _, arg := range args {
+		switch arg {
+		case "--connectivity-only":
+			options.ConnectivityOnly = true
+		case "--unreachable":
+			options.Unreachable = true
+		case "--no-dangling":
+			options.NoDangling = true
+		case "--dangling":
+			options.NoDangling = false
+		default:
+			return options, fmt.Errorf("use: git fsck [--connectivity-only] [--unreachable] [--[no-]dangling]")
+		}
+	}
+	return options, nil
+}
+
+func parseReflogCmdArgs(args []string) (git.ReflogExpireOptions, error) {
+	var options git.ReflogExpireOptions
+	usage := fmt.Errorf("use: git reflog expire [--expire=<date>] [--expire-unreachable=<date>] [-n] [--verbose] [--all | <ref>...]")
+	if len(args) == 0 || args[0] != "expire" {
+		return options, usage
+	}
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--all":
+			options.All = true
+		case arg == "-n" || arg == "--dry-run":
+			options.DryRun = true
+		case arg == "--verbose":
+			options.Verbose = true
+		case strings.HasPrefix(arg, "--expire="):
+			options.Expire = strings.TrimPrefix(arg, "--expire=")
+		case strings.HasPrefix(arg, "--expire-unreachable="):
+			options.ExpireUnreachable = strings.TrimPrefix(arg, "--expire-unreachable=")
+		case strings.HasPrefix(arg, "-"):
+			return options, usage
+		default:
+			options.Refs = append(options.Refs, arg)
+		}
+	}
+	if !options.All && len(options.Refs) == 0 {
+		return options, fmt.Errorf("no reflog specified")
+	}
+	return options, nil
+}
+
+func parsePruneCmdArgs(args []string) (git.PruneOptions, error) {
+	var options git.PruneOptions
+	for _, arg := range args {
+		switch {
+		case arg == "-n" || arg == "--dry-run":
+			options.DryRun = true
+		case arg == "-v" || arg == "--verbose":
+			options.Verbose = true
+		case strings.HasPrefix(arg, "--expire="):
+			options.Expire = strings.TrimPrefix(arg, "--expire=")
+		default:
+			return options, fmt.Errorf("use: git prune [-n] [-v] [--expire=<date>]")
+		}
+	}
+	return options, nil
+}
+
+func parseRepackCmdArgs(args []string) (git.RepackOptions, error) {
+	var options git.RepackOptions
+	usage := fmt.Errorf("use: git repack [-a | -A] [-d] [-b] [--unpack-unreachable=<date>] [--geometric=<factor>]")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--write-bitmap-index":
+			options.WriteBitmap = true
+		case strings.HasPrefix(arg, "--unpack-unreachable="):
+			options.UnpackUnreachable = strings.TrimPrefix(arg, "--unpack-unreachable=")
+		case arg == "--geometric" || arg == "-g" || strings.HasPrefix(arg, "--geometric="):
+			value, ok := strings.CutPrefix(arg, "--geometric=")
+			if !ok {
+				if i+1 >= len(args) {
+					return options, usage
+				}
+				i++
+				value = args[i]
+			}
+			factor, err := strconv.Atoi(value)
+			if err != nil || factor < 1 {
+				return options, fmt.Errorf("invalid geometric factor: %s", value)
+			}
+			options.Geometric = factor
+		case len(arg) >= 2 && arg[0] == '-' && arg[1] != '-':
+			// Short flags can be combined (-Ad)
+			for _, flag := range arg[1:] {
+				switch flag {
+				case 'a':
+					options.All = true
+				case 'A':
+					options.All, options.KeepUnreachable = true, true
+				case 'd':
+					options.Delete = true
+				case 'b':
+					options.WriteBitmap = true
+				default:
+					return options, usage
+				}
+			}
+		default:
+			return options, usage
+		}
+	}
+	return options, nil
+}
+
+func parseRevListCmdArgs(args []string) (git.RevListOptions, error) {
+	var options git.RevListOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--all":
+			options.All = true
+		case arg == "--objects":
+			options.Objects = true
+		case arg == "--count":
+			options.Count = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("use: git rev-list [--all] [--objects] [--count] [<revision>...]")
+		default:
+			options.Revisions = append(options.Revisions, arg)
+		}
+	}
+	return options, nil
+}
+
+func parseMaintenanceCmdArgs(args []string) (git.MaintenanceOptions, error) {
+	var options git.MaintenanceOptions
+	usage := fmt.Errorf("use: git maintenance run [--auto] [--task=<task>]...")
+	if len(args) == 0 || args[0] != "run" {
+		return options, usage
+	}
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--auto":
+			options.Auto = true
+		case strings.HasPrefix(arg, "--task="):
+			options.Tasks = append(options.Tasks, strings.TrimPrefix(arg, "--task="))
+		default:
+			return options, usage
+		}
+	}
+	return options, nil
+}
+
+func parseCredentialStoreCmdArgs(args []string) (string, string, error) {
+	storeFile := ""
+	var positional []string
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--file="); ok {
+			storeFile = value
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	if len(positional) != 1 {
+		return "", "", fmt.Errorf("use: git credential-store [--file=<path>] <get|store|erase>")
+	}
+	return storeFile, positional[0], nil
+}
+
+func parseUploadPackCmdArgs(args []string) (git.UploadPackOptions, error) {
+	var options git.UploadPackOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--advertise-refs":
+			options.AdvertiseRefs = true
+		case arg == "--stateless-rpc":
+			options.StatelessRPC = true
+		case strings.HasPrefix(arg, "-") || options.Directory != "":
+			return options, fmt.Errorf("use: git upload-pack [--stateless-rpc] [--advertise-refs] <directory>")
+		default:
+			options.Directory = arg
+		}
+	}
+	if options.Directory == "" {
+		return options, fmt.Errorf("use: git upload-pack [--stateless-rpc] [--advertise-refs] <directory>")
+	}
+	return options, nil
+}
+
+func parseReceivePackCmdArgs(args []string) (git.ReceivePackOptions, error) {
+	var options git.ReceivePackOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--advertise-refs":
+			options.AdvertiseRefs = true
+		case arg == "--stateless-rpc":
+			options.StatelessRPC = true
+		case strings.HasPrefix(arg, "-") || options.Directory != "":
+			return options, fmt.Errorf("use: git receive-pack [--stateless-rpc] [--advertise-refs] <directory>")
+		default:
+			options.Directory = arg
+		}
+	}
+	if options.Directory == "" {
+		return options, fmt.Errorf("use: git receive-pack [--stateless-rpc] [--advertise-refs] <directory>")
+	}
+	return options, nil
+}
+
+func parseDaemonCmdArgs(args []string) (git.DaemonOptions, error) {
+	options := git.DaemonOptions{Port: 9418}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--port="):
+			port, err := strconv.Atoi(strings.TrimPrefix(arg, "--port="))
+			if err != nil || port < 0 || port > 65535 {
+				return options, fmt.Errorf("invalid port: %s", strings.TrimPrefix(arg, "--port="))
+			}
+			options.Port = port
+		case strings.HasPrefix(arg, "--base-path="):
+			options.BasePath = strings.TrimPrefix(arg, "--base-path=")
+		case arg == "--export-all":
+			options.ExportAll = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("use: git daemon [--port=<n>] [--base-path=<path>] [--export-all] [<directory>...]")
+		default:
+			options.Directories = append(options.Directories, arg)
+		}
+	}
+	return options, nil
+}
+
+func parseServeHttpCmdArgs(args []string) (git.ServeHttpOptions, error) {
+	options := git.ServeHttpOptions{Port: 8080}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--port" || strings.HasPrefix(arg, "--port="):
+			value, ok := strings.CutPrefix(arg, "--port=")
+			if !ok {
+				if i+1 >= len(args) {
+					return options, fmt.Errorf("%s requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return options, fmt.Errorf("invalid port: %s", value)
+			}
+			options.Port = port
+		case strings.HasPrefix(arg, "-") || options.Directory != "":
+			return options, fmt.Errorf("use: git serve-http [--port <port>] [<directory>]")
+		default:
+			options.Directory = arg
+		}
+	}
+	if options.Directory == "" {
+		options.Directory = "."
+	}
+	return options, nil
+}
+
+func parseBundleCmdArgs(args []string) (string, []string, error) {
+	if len(args) < 3 || args[0] != "create" {
+		return "", nil, fmt.Errorf("use: git bundle create <file> <rev>...")
+	}
+	return args[1], args[2:], nil
+}
+
+func parseFastExportCmdArgs(args []string) (bool, []string, error) {
+	all := false
+	var refs []string
+	for _, arg := range args {
+		switch {
+		case arg == "--all":
+			all = true
+		case strings.HasPrefix(arg, "-"):
+			return false, nil, fmt.Errorf("unknown option: %s", arg)
+		default:
+			refs = append(refs, arg)
+		}
+	}
+	if !all && len(refs) == 0 {
+		return false, nil, fmt.Errorf("use: git fast-export --all | <ref>...")
+	}
+	return all, refs, nil
+}
+
+func parseFastImportCmdArgs(args []string) (bool, error) {
+	quiet := false
+	for _, arg := range args {
+		switch arg {
+		case "--quiet":
+			quiet = true
+		default:
+			return false, fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+	return quiet, nil
+}
+
+func parseFormatPatchCmdArgs(args []string) (git.FormatPatchOptions, error) {
+	var options git.FormatPatchOptions
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--stdout":
+			options.Stdout = true
+		case "-n", "--numbered":
+			options.Numbered = true
+		case "-N", "--no-numbered":
+			options.NoNumbered = true
+		case "-o", "--output-directory":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.OutputDir = args[i]
+		default:
+			if ok, err := parseDiffOption(arg, &options.Diff); ok || err != nil {
+				if err != nil {
+					return options, err
+				}
+				continue
+			}
+			if count, ok := strings.CutPrefix(arg, "-"); ok && count != "" {
+				n, err := strconv.Atoi(count)
+				if err != nil || n < 1 {
+					return options, fmt.Errorf("unknown option: %s", arg)
+				}
+				options.MaxCount = n
+				continue
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) > 1 || (len(positional) == 0 && options.MaxCount == 0) {
+		return options, fmt.Errorf("use: git format-patch [--stdout] [-o <dir>] [-n | -N] [-M[<n>] | -C[<n>] | --no-renames] [-<n>] <since> | <revision range>")
+	}
+	if len(positional) == 1 {
+		options.Range = positional[0]
+	}
+	return options, nil
+}
+
+// Parse rename detection option shared by commands that show diffs - returns false if arg is not one
+// -M[<n>]/--find-renames[=<n>], -C[<n>]/--find-copies[=<n>] (given twice: --find-copies-harder), --no-renames
+func parseDiffOption(arg string, options *git.DiffOptions) (bool, error) {
+	var value string
+	switch {
+	case arg == "--no-renames":
+		options.Renames = git.DiffRenamesOff
+		return true, nil
+	case arg == "--find-copies-harder":
+		options.Renames, options.FindCopiesHarder = git.DiffCopiesOn, true
+		return true, nil
+	case arg == "--patience" || arg == "--histogram" || arg == "--minimal":
+		options.Algorithm = strings.TrimPrefix(arg, "--")
+		return true, nil
+	case strings.HasPrefix(arg, "--diff-algorithm="):
+		options.Algorithm = strings.TrimPrefix(arg, "--diff-algorithm=")
+		return true, nil
+	case strings.HasPrefix(arg, "-M") || arg == "--find-renames" || strings.HasPrefix(arg, "--find-renames="):
+		value = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(arg, "-M"), "--find-renames"), "=")
+		if options.Renames != git.DiffCopiesOn {
+			options.Renames = git.DiffRenamesOn
+		}
+	case strings.HasPrefix(arg, "-C") || arg == "--find-copies" || strings.HasPrefix(arg, "--find-copies="):
+		value = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(arg, "-C"), "--find-copies"), "=")
+		if options.Renames == git.DiffCopiesOn {
+			options.FindCopiesHarder = true
+		}
+		options.Renames = git.DiffCopiesOn
+	default:
+		return false, nil
+	}
+
+	if value != "" {
+		score, err := git.ParseRenameScore(value)
+		if err != nil {
+			return true, err
+		}
+		options.MinScore = score
+	}
+	return true, nil
+}
+
+// Parse diff output option - returns false if arg is not one
+// --word-diff[=<mode>], --color-words[=<regex>], --word-diff-regex=<regex>
+func parseDiffOutputOption(arg string, options *git.DiffOptions) bool {
+	switch {
+	case arg == "--word-diff":
+		options.WordDiff = "plain"
+	case strings.HasPrefix(arg, "--word-diff="):
+		if options.WordDiff = strings.TrimPrefix(arg, "--word-diff="); options.WordDiff == "none" {
+			options.WordDiff = ""
+		}
+	case arg == "--color-words" || strings.HasPrefix(arg, "--color-words="):
+		options.WordDiff = "color"
+		if regex, ok := strings.CutPrefix(arg, "--color-words="); ok {
+			options.WordRegex = regex
+		}
+	case arg == "--binary":
+		options.BinaryPatch = true
+	case strings.HasPrefix(arg, "--word-diff-regex="):
+		// Regex alone turns word diff on
+		options.WordRegex = strings.TrimPrefix(arg, "--word-diff-regex=")
+		if options.WordDiff == "" {
+			options.WordDiff = "plain"
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Parse diffstat option - returns false if arg is not one
+// --stat[=<width>[,<name-width>[,<count>]]], --stat-width/--stat-name-width/--stat-graph-width/--stat-count=<n>,
+// --numstat, --shortstat, --summary
+func parseDiffStatOption(arg string, options *git.DiffStatOptions) (bool, error) {
+	name, value, hasValue := strings.Cut(arg, "=")
+	var limits []*int
+	switch name {
+	case "--stat":
+		options.Stat = true
+		limits = []*int{&options.Width, &options.NameWidth, &options.Count}
+	case "--stat-width":
+		limits = []*int{&options.Width}
+	case "--stat-name-width":
+		limits = []*int{&options.NameWidth}
+	case "--stat-graph-width":
+		limits = []*int{&options.GraphWidth}
+	case "--stat-count":
+		limits = []*int{&options.Count}
+	case "--numstat":
+		options.NumStat = true
+	case "--shortstat":
+		options.ShortStat = true
+	case "--summary":
+		options.Summary = true
+	default:
+		return false, nil
+	}
+
+	if !hasValue {
+		if name != "--stat" && len(limits) > 0 {
+			return true, fmt.Errorf("%s requires a value", name)
+		}
+		return true, nil
+	}
+	values := strings.Split(value, ",")
+	if len(values) > len(limits) {
+		return true, fmt.Errorf("unknown option: %s", arg)
+	}
+	for i, text := range values {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			return true, fmt.Errorf("%s expects a numerical value", name)
+		}
+		*limits[i] = n
+	}
+	if name != "--stat" {
+		options.Stat = true
+	}
+	return true, nil
+}
+
+func parseDiffCmdArgs(args []string) (git.DiffCmdOptions, error) {
+	var options git.DiffCmdOptions
+	for i := 0; i < len(args); i++ {
+		if ok, err := parseDiffOption(args[i], &options.Diff); ok || err != nil {
+			if err != nil {
+				return options, err
+			}
+			continue
+		}
+		if parseDiffOutputOption(args[i], &options.Diff) || parseColorOption(args[i], &options.Color) {
+			continue
+		}
+		if ok, err := parseDiffStatOption(args[i], &options.Diff.Stat); ok || err != nil {
+			if err != nil {
+				return options, err
+			}
+			continue
+		}
+		switch arg := args[i]; {
+		case arg == "--":
+			// Paths are never nil after "--" - there are no paths among the revisions
+			options.Paths = append([]string{}, args[i+1:]...)
+			return options, nil
+		case arg == "--cached" || arg == "--staged":
+			options.Cached = true
+		case arg == "--raw" || arg == "--name-only" || arg == "--name-status":
+			options.Format = strings.TrimPrefix(arg, "--")
+		case arg == "-p" || arg == "--patch":
+			options.Format, options.Patch = "", true
+		case arg == "-z":
+			options.NullTerminated = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			options.Revisions = append(options.Revisions, arg)
+		}
+	}
+	return options, nil
+}
+
+func parseDifftoolCmdArgs(args []string) (git.DifftoolOptions, error) {
+	var options git.DifftoolOptions
+	var diffArgs []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			diffArgs = append(diffArgs, args[i:]...)
+			i = len(args)
+		case arg == "-y" || arg == "--no-prompt":
+			options.NoPrompt = true
+		case arg == "--prompt":
+			options.NoPrompt = false
+		case arg == "-t" || arg == "--tool":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("use: git difftool [-t <tool>] [-y] [<diff options>] [<commit> [<commit>]] [-- <path>...]")
+			}
+			i++
+			options.Tool = args[i]
+		case strings.HasPrefix(arg, "--tool="):
+			options.Tool = strings.TrimPrefix(arg, "--tool=")
+		default:
+			diffArgs = append(diffArgs, arg)
+		}
+	}
+	diff, err := parseDiffCmdArgs(diffArgs)
+	options.Diff = diff
+	return options, err
+}
+
+func parseMergetoolCmdArgs(args []string) (git.MergetoolOptions, error) {
+	var options git.MergetoolOptions
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			options.Paths = append(options.Paths, args[i+1:]...)
+			return options, nil
+		case arg == "-y" || arg == "--no-prompt":
+			options.NoPrompt, options.Prompt = true, false
+		case arg == "--prompt":
+			options.NoPrompt, options.Prompt = false, true
+		case arg == "-t" || arg == "--tool":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("use: git mergetool [-t <tool>] [-y | --prompt] [<path>...]")
+			}
+			i++
+			options.Tool = args[i]
+		case strings.HasPrefix(arg, "--tool="):
+			options.Tool = strings.TrimPrefix(arg, "--tool=")
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			options.Paths = append(options.Paths, arg)
+		}
+	}
+	return options, nil
+}
+
+func parseApplyCmdArgs(args []string) (bool, []string, error) {
+	cached := false
+	var files []string
+	for _, arg := range args {
+		switch {
+		case arg == "--cached":
+			cached = true
+		case strings.HasPrefix(arg, "-"):
+			return false, nil, fmt.Errorf("unknown option: %s", arg)
+		case arg != "-":
+			files = append(files, arg)
+		}
+	}
+	return cached, files, nil
+}
+
+func parseAmCmdArgs(args []string) (string, []string, error) {
+	action := ""
+	var files []string
+	for _, arg := range args {
+		switch {
+		case arg == "--continue" || arg == "--abort":
+			action = strings.TrimPrefix(arg, "--")
+		case strings.HasPrefix(arg, "-"):
+			return "", nil, fmt.Errorf("unknown option: %s", arg)
+		case arg != "-":
+			files = append(files, arg)
+		}
+	}
+	if action != "" && len(files) > 0 {
+		return "", nil, fmt.Errorf("use: git am [<mbox>...] | --continue | --abort")
+	}
+	return action, files, nil
+}
+
+func parseCommitCmdArgs(args []string) (git.CommitOptions, error) {
+	var options git.CommitOptions
+	hasMessage := false
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-m" || arg == "--message":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			// Several -m options are separate paragraphs
+			if hasMessage {
+				options.Message += "\n\n"
+			}
+			options.Message += args[i]
+			hasMessage = true
+		case arg == "--allow-empty":
+			options.AllowEmpty = true
+		case arg == "--no-edit":
+			options.NoEdit = true
+		case arg == "-n" || arg == "--no-verify":
+			options.NoVerify = true
+		case arg == "-S" || arg == "--gpg-sign":
+			options.Sign = true
+		case strings.HasPrefix(arg, "-S"):
+			options.Sign, options.SigningKey = true, arg[2:]
+		case strings.HasPrefix(arg, "--gpg-sign="):
+			options.Sign, options.SigningKey = true, strings.TrimPrefix(arg, "--gpg-sign=")
+		default:
+			return options, fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	return options, nil
+}
+
+func parseMergeCmdArgs(args []string) (string, git.MergeOptions, error) {
+	var options git.MergeOptions
+	action := ""
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-s" || arg == "--strategy" || arg == "-X" || arg == "--strategy-option" || arg == "-m" || arg == "--message":
+			if i+1 >= len(args) {
+				return "", options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			switch arg {
+			case "-s", "--strategy":
+				options.Strategy = args[i]
+			case "-X", "--strategy-option":
+				options.StrategyOptions = append(options.StrategyOptions, args[i])
+			default:
+				options.Message = args[i]
+			}
+		case strings.HasPrefix(arg, "--strategy="):
+			options.Strategy = strings.TrimPrefix(arg, "--strategy=")
+		case strings.HasPrefix(arg, "--strategy-option="):
+			options.StrategyOptions = append(options.StrategyOptions, strings.TrimPrefix(arg, "--strategy-option="))
+		case strings.HasPrefix(arg, "-X"):
+			options.StrategyOptions = append(options.StrategyOptions, arg[2:])
+		case strings.HasPrefix(arg, "-s"):
+			options.Strategy = arg[2:]
+		case arg == "--continue" || arg == "--abort":
+			action = strings.TrimPrefix(arg, "--")
+		case arg == "--allow-unrelated-histories":
+			options.AllowUnrelatedHistories = true
+		case arg == "--ff" || arg == "--no-ff" || arg == "--ff-only":
+			options.FastForward = map[string]string{"--ff": "ff", "--no-ff": "no", "--ff-only": "only"}[arg]
+		case arg == "--squash" || arg == "--no-squash":
+			options.Squash = arg == "--squash"
+		case strings.HasPrefix(arg, "-"):
+			return "", options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			options.Commits = append(options.Commits, arg)
+		}
+	}
+
+	if action != "" {
+		if len(options.Commits) > 0 {
+			return "", options, fmt.Errorf("use: git merge --continue | --abort")
+		}
+		return action, options, nil
+	}
+	if len(options.Commits) == 0 {
+		return "", options, fmt.Errorf("use: git merge [--squash] [--no-ff | --ff-only] [-s <strategy>] [-X <option>] [-m <message>] [--allow-unrelated-histories] <commit>...")
 	}
+	return "", options, nil
+}
+
+func parseRebaseCmdArgs(args []string) (string, git.RebaseOptions, error) {
+	var options git.RebaseOptions
+	action := ""
+	var positional []string
 
-	var url string
-	var directory string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			action = strings.TrimPrefix(arg, "--")
+		case arg == "-i" || arg == "--interactive":
+			options.Interactive = true
+		case arg == "--onto":
+			if i+1 >= len(args) {
+				return "", options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.Onto = args[i]
+		case strings.HasPrefix(arg, "--onto="):
+			options.Onto = strings.TrimPrefix(arg, "--onto=")
+		case strings.HasPrefix(arg, "-"):
+			return "", options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if action != "" {
+		if len(positional) > 0 || options.Onto != "" || options.Interactive {
+			return "", options, fmt.Errorf("use: git rebase --continue | --skip | --abort")
+		}
+		return action, options, nil
+	}
+	if len(positional) != 1 {
+		return "", options, fmt.Errorf("use: git rebase [-i] [--onto <newbase>] <upstream>")
+	}
+	options.Upstream = positional[0]
+	return "", options, nil
+}
+
+func parseCherryPickCmdArgs(args []string) (string, git.CherryPickOptions, error) {
+	action, commits, noCommit, mainline, err := parseSequencerCmdArgs("cherry-pick", args)
+	return action, git.CherryPickOptions{Commits: commits, NoCommit: noCommit, Mainline: mainline}, err
+}
 
-	url = args[0]
-	directory = args[1]
+func parseRevertCmdArgs(args []string) (string, git.RevertOptions, error) {
+	action, commits, noCommit, mainline, err := parseSequencerCmdArgs("revert", args)
+	return action, git.RevertOptions{Commits: commits, NoCommit: noCommit, Mainline: mainline}, err
+}
+
+// Arguments shared by cherry-pick and revert - action, commits, -n and -m <parent-number>
+func parseSequencerCmdArgs(command string, args []string) (string, []string, bool, int, error) {
+	action := ""
+	var commits []string
+	noCommit := false
+	mainline := 0
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			action = strings.TrimPrefix(arg, "--")
+		case arg == "-n" || arg == "--no-commit":
+			noCommit = true
+		case arg == "--no-edit":
+			// Messages are never edited
+		case arg == "-m" || arg == "--mainline" || strings.HasPrefix(arg, "--mainline=") || strings.HasPrefix(arg, "-m"):
+			value := strings.TrimPrefix(strings.TrimPrefix(arg, "--mainline="), "-m")
+			if arg == "-m" || arg == "--mainline" {
+				if i+1 >= len(args) {
+					return "", nil, false, 0, fmt.Errorf("%s requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 1 {
+				return "", nil, false, 0, fmt.Errorf("invalid mainline parent number: %s", value)
+			}
+			mainline = number
+		case strings.HasPrefix(arg, "-"):
+			return "", nil, false, 0, fmt.Errorf("unknown option: %s", arg)
+		default:
+			commits = append(commits, arg)
+		}
+	}
+
+	if action != "" {
+		if len(commits) > 0 || noCommit || mainline > 0 {
+			return "", nil, false, 0, fmt.Errorf("use: git %s --continue | --skip | --abort", command)
+		}
+		return action, nil, false, 0, nil
+	}
+	if len(commits) == 0 {
+		return "", nil, false, 0, fmt.Errorf("use: git %s [-n] [-m <parent-number>] <commit>...", command)
+	}
+	return "", commits, noCommit, mainline, nil
+}
+
+func parseRerereCmdArgs(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, nil
+	}
+	switch args[0] {
+	case "forget":
+		if len(args) < 2 {
+			return "", nil, fmt.Errorf("use: git rerere forget <path>...")
+		}
+		return args[0], args[1:], nil
+	case "status", "clear", "gc":
+		if len(args) == 1 {
+			return args[0], nil, nil
+		}
+	}
+	return "", nil, fmt.Errorf("use: git rerere [status | clear | gc | forget <path>...]")
+}
+
+func parseStashCmdArgs(args []string) (git.StashOptions, error) {
+	options := git.StashOptions{Command: "push"}
+	usage := fmt.Errorf("use: git stash [push [-m <message>] | save <message> | list | show [<diff-options>] [<stash>] | apply [--index] [<stash>] | pop [--index] [<stash>] | drop [<stash>] | branch <branch> [<stash>] | clear]")
+	// Options without a command belong to push
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		options.Command, args = args[0], args[1:]
+	}
+
+	var positional []string
+	switch options.Command {
+	case "show":
+		diff, err := parseDiffCmdArgs(args)
+		if err != nil {
+			return options, err
+		}
+		if len(diff.Revisions) > 1 || diff.Paths != nil {
+			return options, usage
+		}
+		if len(diff.Revisions) == 1 {
+			options.Stash = diff.Revisions[0]
+		}
+		options.Diff = diff
+		return options, nil
+	case "save":
+		options.Message = strings.Join(args, " ")
+		return options, nil
+	}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-m" || arg == "--message") && options.Command == "push":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.Message = args[i]
+		case strings.HasPrefix(arg, "--message=") && options.Command == "push":
+			options.Message = strings.TrimPrefix(arg, "--message=")
+		case arg == "--index" && (options.Command == "apply" || options.Command == "pop"):
+			options.Index = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	switch options.Command {
+	case "push", "list", "clear":
+		if len(positional) > 0 {
+			return options, usage
+		}
+	case "apply", "pop", "drop":
+		if len(positional) > 1 {
+			return options, usage
+		}
+		if len(positional) == 1 {
+			options.Stash = positional[0]
+		}
+	case "branch":
+		if len(positional) == 0 || len(positional) > 2 {
+			return options, usage
+		}
+		options.Branch = positional[0]
+		if len(positional) == 2 {
+			options.Stash = positional[1]
+		}
+	default:
+		return options, fmt.Errorf("unknown stash command: %s", options.Command)
+	}
+	return options, nil
+}
+
+func parseRevParseCmdArgs(args []string) (git.RevParseOptions, error) {
+	var options git.RevParseOptions
+	for _, arg := range args {
+		switch {
+		case arg == "--verify":
+			options.Verify = true
+		case arg == "--short":
+			options.Short = 7
+		case strings.HasPrefix(arg, "--short="):
+			length, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+			if err != nil || length < 0 {
+				return options, fmt.Errorf("invalid --short length: %s", arg)
+			}
+			options.Short = max(length, 4)
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			options.Revisions = append(options.Revisions, arg)
+		}
+	}
+	return options, nil
+}
+
+func parseBranchCmdArgs(args []string) (git.BranchOptions, error) {
+	var options git.BranchOptions
+	var positional []string
+	list := false
+	for i := 0; i < len(args); i++ {
+		if parseColorOption(args[i], &options.Color) {
+			continue
+		}
+		switch arg := args[i]; {
+		case arg == "-d" || arg == "--delete":
+			options.Delete = true
+		case arg == "-D":
+			options.Delete, options.Force = true, true
+		case arg == "-f" || arg == "--force":
+			options.Force = true
+		case arg == "-l" || arg == "--list":
+			list = true
+		case arg == "-r" || arg == "--remotes":
+			options.Remotes = true
+		case arg == "-a" || arg == "--all":
+			options.All = true
+		case arg == "-v" || arg == "--verbose":
+			options.Verbose++
+		case arg == "-vv":
+			options.Verbose += 2
+		case arg == "-t" || arg == "--track":
+			options.Track = true
+		case arg == "--no-track":
+			options.NoTrack = true
+		case arg == "--unset-upstream":
+			options.UnsetUpstream = true
+		case arg == "-u" || arg == "--set-upstream-to":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.Upstream = args[i]
+		case strings.HasPrefix(arg, "--set-upstream-to="):
+			options.Upstream = strings.TrimPrefix(arg, "--set-upstream-to=")
+		case arg == "--merged" || arg == "--no-merged" || arg == "--contains":
+			// Commit is optional - HEAD when the next argument isn't one
+			commit := "HEAD"
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				commit = args[i]
+			}
+			setBranchFilter(&options, arg, commit)
+		case strings.HasPrefix(arg, "--merged=") || strings.HasPrefix(arg, "--no-merged=") || strings.HasPrefix(arg, "--contains="):
+			name, commit, _ := strings.Cut(arg, "=")
+			setBranchFilter(&options, name, commit)
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	filtered := options.Merged != "" || options.NoMerged != "" || options.Contains != ""
+	if options.Upstream != "" || options.UnsetUpstream {
+		if len(positional) > 1 || options.Delete || list || filtered || (options.Upstream != "" && options.UnsetUpstream) {
+			return options, fmt.Errorf("use: git branch (--set-upstream-to=<upstream> | --unset-upstream) [<name>]")
+		}
+		if len(positional) == 1 {
+			options.Name = positional[0]
+		}
+		return options, nil
+	}
+	if options.Delete {
+		if len(positional) == 0 || list || filtered {
+			return options, fmt.Errorf("use: git branch (-d | -D) <name>...")
+		}
+		options.Names = positional
+		return options, nil
+	}
+	if list || filtered || options.Remotes || options.All || options.Verbose > 0 || len(positional) == 0 {
+		if len(positional) > 0 {
+			return options, fmt.Errorf("use: git branch [-r | -a] [-v | -vv] [--merged [<commit>]] [--no-merged [<commit>]] [--contains [<commit>]]")
+		}
+		return options, nil
+	}
+	if len(positional) > 2 {
+		return options, fmt.Errorf("use: git branch [-f] [--track | --no-track] <name> [<start-point>]")
+	}
+	options.Name = positional[0]
+	if len(positional) == 2 {
+		options.StartPoint = positional[1]
+	}
+	return options, nil
+}
+
+// Set commit of --merged, --no-merged or --contains
+func setBranchFilter(options *git.BranchOptions, option, commit string) {
+	switch option {
+	case "--merged":
+		options.Merged = commit
+	case "--no-merged":
+		options.NoMerged = commit
+	default:
+		options.Contains = commit
+	}
+}
 
-	return url, directory, nil
+func parseRemoteCmdArgs(args []string) (git.RemoteOptions, error) {
+	var options git.RemoteOptions
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-v" || arg == "--verbose":
+			options.Verbose = true
+		case arg == "--push":
+			options.Push = true
+		case arg == "-n":
+			options.NoQuery = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 {
+		return options, nil
+	}
+
+	options.Command, positional = positional[0], positional[1:]
+	if options.Command == "rm" {
+		options.Command = "remove"
+	}
+	counts := map[string]int{"add": 2, "remove": 1, "rename": 2, "set-url": 2, "show": 1}
+	count, ok := counts[options.Command]
+	if !ok {
+		return options, fmt.Errorf("unknown remote command: %s", options.Command)
+	}
+	if len(positional) != count || (options.Push && options.Command != "set-url") || (options.NoQuery && options.Command != "show") {
+		return options, fmt.Errorf("use: git remote [-v] | add <name> <url> | remove <name> | rename <old> <new> | set-url [--push] <name> <url> | show [-n] <name>")
+	}
+	options.Name = positional[0]
+	switch options.Command {
+	case "add", "set-url":
+		options.Url = positional[1]
+	case "rename":
+		options.NewName = positional[1]
+	}
+	return options, nil
+}
+
+func parseFetchCmdArgs(args []string) (git.FetchOptions, error) {
+	var options git.FetchOptions
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-t" || arg == "--tags":
+			options.Tags = true
+		case arg == "-n" || arg == "--no-tags":
+			options.NoTags = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("use: git fetch [--tags | --no-tags] [<remote> [<refspec>...]]")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) > 0 {
+		options.Remote, options.Refspecs = positional[0], positional[1:]
+	}
+	return options, nil
+}
+
+func parsePullCmdArgs(args []string) (git.PullOptions, error) {
+	var options git.PullOptions
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-r" || arg == "--rebase":
+			options.Rebase, options.NoRebase = true, false
+		case arg == "--no-rebase":
+			options.Rebase, options.NoRebase = false, true
+		case arg == "--ff" || arg == "--no-ff" || arg == "--ff-only":
+			options.FastForward = map[string]string{"--ff": "ff", "--no-ff": "no", "--ff-only": "only"}[arg]
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("use: git pull [--rebase | --no-rebase] [--ff | --no-ff | --ff-only] [<remote> [<refspec>...]]")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) > 0 {
+		options.Remote, options.Refspecs = positional[0], positional[1:]
+	}
+	return options, nil
+}
+
+func parsePushCmdArgs(args []string) (git.PushOptions, error) {
+	var options git.PushOptions
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-f" || arg == "--force":
+			options.Force = true
+		case arg == "--force-with-lease":
+			options.ForceWithLease = true
+		case strings.HasPrefix(arg, "--force-with-lease="):
+			options.Leases = append(options.Leases, strings.TrimPrefix(arg, "--force-with-lease="))
+		case arg == "-d" || arg == "--delete":
+			options.Delete = true
+		case arg == "--tags":
+			options.Tags = true
+		case arg == "--atomic":
+			options.Atomic = true
+		case arg == "--mirror":
+			options.Mirror = true
+		case arg == "--no-verify":
+			options.NoVerify = true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("use: git push [-f | --force-with-lease[=<ref>[:<expect>]]] [-d] [--tags] [--atomic] [--mirror] [--no-verify] [<remote> [<refspec>...]]")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) > 0 {
+		options.Remote, options.Refspecs = positional[0], positional[1:]
+	}
+	return options, nil
+}
+
+func parseReplaceCmdArgs(args []string) (git.ReplaceOptions, error) {
+	var options git.ReplaceOptions
+	var positional []string
+	usage := fmt.Errorf("use: git replace [-f] <object> <replacement> | -d <object>... | [--format=<format>] [-l [<pattern>]]")
+
+	for _, arg := range args {
+		switch arg {
+		case "-f", "--force":
+			options.Force = true
+		case "-d", "--delete":
+			options.Delete = true
+		case "-l", "--list":
+			options.List = true
+		default:
+			if format, ok := strings.CutPrefix(arg, "--format="); ok {
+				options.Format = format
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				return options, fmt.Errorf("unknown option: %s", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	switch {
+	case options.Delete:
+		if len(positional) == 0 || options.List || options.Force {
+			return options, usage
+		}
+		options.Objects = positional
+	case options.List || (len(positional) == 0 && !options.Force):
+		if len(positional) > 1 || options.Force {
+			return options, usage
+		}
+		options.List = true
+		if len(positional) == 1 {
+			options.Pattern = positional[0]
+		}
+	default:
+		if len(positional) != 2 || options.Format != "" {
+			return options, usage
+		}
+		options.Object, options.Replacement = positional[0], positional[1]
+	}
+	return options, nil
+}
+
+func parseTagCmdArgs(args []string) (git.TagOptions, error) {
+	var options git.TagOptions
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-a", "--annotate":
+			options.Annotate = true
+		case "-s", "--sign":
+			options.Sign = true
+		case "-f", "--force":
+			options.Force = true
+		case "-l", "--list":
+			options.List = true
+		case "-d", "--delete":
+			options.Delete = true
+		case "-v", "--verify":
+			options.Verify = true
+		case "--contains":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.List, options.Contains = true, args[i]
+		case "-m", "--message", "-u", "--local-user":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "-m" || arg == "--message" {
+				options.Message = args[i]
+			} else {
+				options.Sign, options.SigningKey = true, args[i]
+			}
+		default:
+			if strings.HasPrefix(arg, "--contains=") {
+				options.List, options.Contains = true, strings.TrimPrefix(arg, "--contains=")
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				return options, fmt.Errorf("unknown option: %s", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if options.Delete || options.Verify {
+		if len(positional) == 0 || options.List || (options.Delete && options.Verify) {
+			return options, fmt.Errorf("use: git tag -d <name>... | -v <name>...")
+		}
+		options.Names = positional
+		return options, nil
+	}
+	// Names given with -l are patterns
+	if len(positional) == 0 || options.List {
+		options.List, options.Patterns = true, positional
+		return options, nil
+	}
+	if len(positional) > 2 {
+		return options, fmt.Errorf("use: git tag [-a | -s | -u <key>] [-f] [-m <message>] <name> [<commit>] | -l [--contains <commit>] [<pattern>...] | -d <name>... | -v <name>...")
+	}
+	options.Name = positional[0]
+	if len(positional) == 2 {
+		options.Target = positional[1]
+	}
+	return options, nil
+}
+
+func parseVerifyCmdArgs(args []string) (bool, []string, error) {
+	verbose := false
+	var names []string
+	for _, arg := range args {
+		switch {
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case strings.HasPrefix(arg, "-"):
+			return false, nil, fmt.Errorf("unknown option: %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 {
+		return false, nil, fmt.Errorf("use: git verify-commit|verify-tag [-v] <object>...")
+	}
+	return verbose, names, nil
+}
+
+func parseLogCmdArgs(args []string) (git.LogOptions, error) {
+	var options git.LogOptions
+	for i := 0; i < len(args); i++ {
+		if ok, err := parseDiffOption(args[i], &options.Diff); ok || err != nil {
+			if err != nil {
+				return options, err
+			}
+			continue
+		}
+		if parseDiffOutputOption(args[i], &options.Diff) {
+			continue
+		}
+		if ok, err := parseDiffStatOption(args[i], &options.Diff.Stat); ok || err != nil {
+			if err != nil {
+				return options, err
+			}
+			continue
+		}
+		if next, ok, err := parseCommitFilterOption(args, i, &options.Filter); ok || err != nil {
+			if err != nil {
+				return options, err
+			}
+			i = next
+			continue
+		}
+		switch arg := args[i]; {
+		case arg == "--":
+			// Paths are never nil after "--" - there are no paths among the revisions
+			options.Paths = append([]string{}, args[i+1:]...)
+			return options, nil
+		case arg == "--follow":
+			options.Follow = true
+		case arg == "-p" || arg == "-u" || arg == "--patch":
+			options.Patch = true
+		case arg == "-m":
+			options.Merges = true
+		case arg == "--first-parent":
+			options.FirstParent = true
+		case arg == "--show-signature":
+			options.ShowSignature = true
+		case arg == "--oneline":
+			options.Format, options.AbbrevCommit = "oneline", true
+		case arg == "--pretty":
+			options.Format = "medium"
+		case strings.HasPrefix(arg, "--pretty=") || strings.HasPrefix(arg, "--format="):
+			// Empty format writes nothing for commits (as tformat:)
+			if _, options.Format, _ = strings.Cut(arg, "="); options.Format == "" {
+				options.Format = "tformat:"
+			}
+		case arg == "--abbrev-commit":
+			options.AbbrevCommit = true
+		case arg == "--no-abbrev-commit":
+			options.AbbrevCommit = false
+		case strings.HasPrefix(arg, "--date="):
+			options.Date = strings.TrimPrefix(arg, "--date=")
+		case arg == "--graph":
+			options.Graph = true
+		case arg == "--decorate":
+			options.Decorate = "short"
+		case strings.HasPrefix(arg, "--decorate="):
+			options.Decorate = strings.TrimPrefix(arg, "--decorate=")
+		case arg == "--no-decorate":
+			options.Decorate = "no"
+		case parseColorOption(arg, &options.Color):
+		case arg == "-n" || arg == "--max-count":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			count, err := strconv.Atoi(args[i])
+			if err != nil {
+				return options, fmt.Errorf("bad count: %s", args[i])
+			}
+			options.MaxCount = count
+		case strings.HasPrefix(arg, "--max-count="):
+			count, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-count="))
+			if err != nil {
+				return options, fmt.Errorf("bad count: %s", arg)
+			}
+			options.MaxCount = count
+		case strings.HasPrefix(arg, "-"):
+			// -<n> and -n<n>
+			count, err := strconv.Atoi(strings.TrimPrefix(arg[1:], "n"))
+			if err != nil || count < 0 {
+				return options, fmt.Errorf("unknown option: %s", arg)
+			}
+			options.MaxCount = count
+		default:
+			options.Revisions = append(options.Revisions, arg)
+		}
+	}
+	return options, nil
+}
+
+// Show takes the options of log (objects are its revisions) and -s/--no-patch
+func parseShowCmdArgs(args []string) (git.ShowOptions, error) {
+	var options git.ShowOptions
+	var logArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			logArgs = append(logArgs, args[i:]...)
+			break
+		}
+		if arg == "-s" || arg == "--no-patch" {
+			options.NoPatch = true
+			continue
+		}
+		logArgs = append(logArgs, arg)
+	}
+	var err error
+	options.Log, err = parseLogCmdArgs(logArgs)
+	return options, err
+}
+
+// Parse commit filter option at args[i] - returns index of its last argument, false if it is not one
+// --author/--committer/--grep[=]<pattern>, --since/--after/--until/--before[=]<date>, --all-match,
+// --invert-grep, -i/--regexp-ignore-case, -E/--extended-regexp, -F/--fixed-strings
+func parseCommitFilterOption(args []string, i int, filter *git.CommitFilter) (int, bool, error) {
+	name, value, hasValue := strings.Cut(args[i], "=")
+	var target *string
+	var list *[]string
+	switch name {
+	case "--all-match":
+		filter.AllMatch = true
+	case "--invert-grep":
+		filter.InvertGrep = true
+	case "-i", "--regexp-ignore-case":
+		filter.IgnoreCase = true
+	case "-E", "--extended-regexp":
+		filter.ExtendedRegexp = true
+	case "-F", "--fixed-strings":
+		filter.FixedStrings = true
+	case "--author":
+		list = &filter.Authors
+	case "--committer":
+		list = &filter.Committers
+	case "--grep":
+		list = &filter.Grep
+	case "--since", "--after":
+		target = &filter.Since
+	case "--until", "--before":
+		target = &filter.Until
+	default:
+		return i, false, nil
+	}
+	if target == nil && list == nil {
+		return i, true, nil
+	}
+
+	if !hasValue {
+		if i+1 >= len(args) {
+			return i, true, fmt.Errorf("%s requires a value", name)
+		}
+		i++
+		value = args[i]
+	}
+	if list != nil {
+		*list = append(*list, value)
+	} else {
+		*target = value
+	}
+	return i, true, nil
+}
+
+func parseMultiPackIndexCmdArgs(args []string) (string, error) {
+	if len(args) != 1 || args[0] != "write" {
+		return "", fmt.Errorf("use: git multi-pack-index write")
+	}
+	return args[0], nil
+}
+
+func parseInitCmdArgs(args []string) (git.InitOptions, error) {
+	var options git.InitOptions
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--bare":
+			options.Bare = true
+		case arg == "-b" || arg == "--initial-branch":
+			if i+1 >= len(args) {
+				return options, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			options.InitialBranch = args[i]
+		case strings.HasPrefix(arg, "--initial-branch="):
+			options.InitialBranch = strings.TrimPrefix(arg, "--initial-branch=")
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		case options.Directory != "":
+			return options, fmt.Errorf("use: git init [--bare] [-b <branch>] [<directory>]")
+		default:
+			options.Directory = arg
+		}
+	}
+	if options.InitialBranch != "" {
+		if err := git.CheckBranchName(options.InitialBranch); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+func parseSparseCheckoutCmdArgs(args []string) (git.SparseCheckoutOptions, error) {
+	var options git.SparseCheckoutOptions
+	usage := fmt.Errorf("use: git sparse-checkout (init | set | list | disable) [--cone | --no-cone] [<directory>...]")
+	if len(args) == 0 {
+		return options, usage
+	}
+	options.Command = args[0]
+
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--cone":
+			options.Cone, options.NoCone = true, false
+		case arg == "--no-cone":
+			options.Cone, options.NoCone = false, true
+		case strings.HasPrefix(arg, "-"):
+			return options, fmt.Errorf("unknown option: %s", arg)
+		default:
+			options.Directories = append(options.Directories, arg)
+		}
+	}
+
+	switch options.Command {
+	case "init", "set":
+	case "list", "disable":
+		if options.Cone || options.NoCone || len(options.Directories) > 0 {
+			return options, usage
+		}
+	default:
+		return options, usage
+	}
+	if options.Command == "init" && len(options.Directories) > 0 {
+		return options, usage
+	}
+	return options, nil
+}
+
+func parseGlobalArgs(args []string) (git.GlobalOptions, []string, error) {
+	var options git.GlobalOptions
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		arg := args[i]
+		if arg == "--no-pager" || arg == "-P" {
+			options.NoPager = true
+			continue
+		}
+		if arg == "--json" {
+			options.JSON = true
+			continue
+		}
+		if arg == "--no-replace-objects" {
+			options.NoReplaceObjects = true
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "-C" && name != "--git-dir" && name != "--work-tree" {
+			return options, nil, fmt.Errorf("unknown option: %s", arg)
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return options, nil, fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "-C":
+			// Empty path is ignored, like git does
+			if value != "" {
+				options.Directories = append(options.Directories, value)
+			}
+		case "--git-dir":
+			options.GitDir = value
+		case "--work-tree":
+			options.WorkTree = value
+		}
+	}
+	return options, args[i:], nil
 }
@@ -0,0 +1,295 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// am - apply patches from mailbox (format-patch output) and commit each of them
+//
+// State lives in .git/rebase-apply while patches are applied:
+//   - 0001, 0002, ...  - mails split out of the mbox
+//   - next, last       - number of mail being applied and number of mails
+//   - orig-head        - HEAD before am started (for --abort)
+//   - applying         - marks the directory as created by am (rebase uses the same place)
+//
+// When a patch doesn't apply, am stops - the user fixes the files, adds them and runs am --continue
+// (which commits the index with that mail's author and message), or runs am --abort.
+
+// Split mbox into mails, save them into .git/rebase-apply and apply them
+func amStart(mbox []byte, w io.Writer) error {
+	stateDir := gitDirPath("rebase-apply")
+	if _, err := os.Stat(stateDir); err == nil {
+		return fmt.Errorf("previous rebase directory %s still exists - use --continue or --abort", stateDir)
+	}
+
+	_, headHash, err := readHead()
+	if err != nil {
+		return err
+	}
+	if headHash != "" {
+		if dirty, err := indexDiffersFromCommit(headHash); err != nil {
+			return err
+		} else if dirty {
+			return fmt.Errorf("dirty index: cannot apply patches")
+		}
+	}
+
+	mails := splitMbox(mbox)
+	if len(mails) == 0 {
+		return fmt.Errorf("patch is empty")
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", stateDir, err)
+	}
+	for i, mailData := range mails {
+		if err := os.WriteFile(filepath.Join(stateDir, fmt.Sprintf("%04d", i+1)), mailData, 0644); err != nil {
+			return err
+		}
+	}
+	state := map[string]string{"next": "1", "last": strconv.Itoa(len(mails)), "orig-head": headHash, "applying": ""}
+	for name, value := range state {
+		if err := os.WriteFile(filepath.Join(stateDir, name), []byte(value+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	return amRun(false, w)
+}
+
+// Apply mails from "next" to "last" - with resolved, the current mail was fixed by the user and
+// its index is committed instead of applying the patch
+func amRun(resolved bool, w io.Writer) error {
+	stateDir := gitDirPath("rebase-apply")
+	next, err := readAmNumber("next")
+	if err != nil {
+		return err
+	}
+	last, err := readAmNumber("last")
+	if err != nil {
+		return err
+	}
+
+	for ; next <= last; next++ {
+		if err := os.WriteFile(filepath.Join(stateDir, "next"), []byte(strconv.Itoa(next)+"\n"), 0644); err != nil {
+			return err
+		}
+
+		mailData, err := os.ReadFile(filepath.Join(stateDir, fmt.Sprintf("%04d", next)))
+		if err != nil {
+			return fmt.Errorf("failed to read patch %04d: %w", next, err)
+		}
+		mailPatch, err := parseMailPatch(mailData)
+		if err != nil {
+			return fmt.Errorf("patch %04d: %w", next, err)
+		}
+		subject, _ := splitCommitMessage(mailPatch.Message)
+
+		if !resolved {
+			fmt.Fprintf(w, "Applying: %s\n", subject)
+			if err := applyMailPatch(mailPatch); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return fmt.Errorf("patch failed at %04d %s\n"+
+					"When you have resolved this problem, run \"git am --continue\".\n"+
+					"To restore the original branch and stop patching, run \"git am --abort\".", next, subject)
+			}
+		} else {
+			_, headHash, err := readHead()
+			if err != nil {
+				return err
+			}
+			if headHash != "" {
+				if dirty, err := indexDiffersFromCommit(headHash); err != nil {
+					return err
+				} else if !dirty {
+					return fmt.Errorf("no changes - did you forget to use 'git add'?")
+				}
+			}
+			fmt.Fprintf(w, "Applying: %s\n", subject)
+		}
+		resolved = false
+
+		if _, err := commitIndex(CommitOptions{Message: mailPatch.Message, Author: mailPatch.Author, AllowEmpty: true}); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(stateDir)
+}
+
+// Continue after the user resolved the failed patch
+func amContinue(w io.Writer) error {
+	if _, err := os.Stat(gitDirPath("rebase-apply", "applying")); err != nil {
+		return fmt.Errorf("am is not in progress")
+	}
+	return amRun(true, w)
+}
+
+// Stop am - HEAD, index and work tree go back to where they were before am started
+func amAbort() error {
+	stateDir := gitDirPath("rebase-apply")
+	if _, err := os.Stat(filepath.Join(stateDir, "applying")); err != nil {
+		return fmt.Errorf("am is not in progress")
+	}
+
+	data, err := os.ReadFile(filepath.Join(stateDir, "orig-head"))
+	if err != nil {
+		return err
+	}
+	origHead := strings.TrimSpace(string(data))
+	if origHead != "" {
+		branch, _, err := readHead()
+		if err != nil {
+			return err
+		}
+		if branch == "" {
+			branch = "HEAD"
+		}
+		if err := updateRef(branch, origHead, "am --abort"); err != nil {
+			return err
+		}
+		if err := resetWorkTreeToCommit(origHead); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(stateDir)
+}
+
+// Number stored in .git/rebase-apply/<name>
+func readAmNumber(name string) (int, error) {
+	data, err := os.ReadFile(gitDirPath("rebase-apply", name))
+	if err != nil {
+		return 0, fmt.Errorf("am is not in progress")
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Apply patch to index and work tree - index first, so a patch that doesn't fit leaves the work tree alone
+func applyMailPatch(mailPatch *MailPatch) error {
+	patches, err := parsePatch(mailPatch.Patch)
+	if err != nil {
+		return err
+	}
+	if err := applyPatches(patches, true); err != nil {
+		return err
+	}
+	return applyPatches(patches, false)
+}
+
+// Check whether index tree differs from commit tree
+func indexDiffersFromCommit(commitHash string) (bool, error) {
+	indexTree, err := writeTreeFromIndex()
+	if err != nil {
+		return false, err
+	}
+	commitTree, err := readCommitTreeHash(commitHash)
+	if err != nil {
+		return false, err
+	}
+	return indexTree != commitTree, nil
+}
+
+// Split mbox on "From " separator lines - input without separators is a single mail
+func splitMbox(data []byte) [][]byte {
+	var mails [][]byte
+	var current bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("From ")) && !bytes.HasPrefix(line, []byte("From: ")) {
+			if len(bytes.TrimSpace(current.Bytes())) > 0 {
+				mails = append(mails, append([]byte(nil), current.Bytes()...))
+			}
+			current.Reset()
+			continue
+		}
+		current.Write(line)
+	}
+	if len(bytes.TrimSpace(current.Bytes())) > 0 {
+		mails = append(mails, current.Bytes())
+	}
+	return mails
+}
+
+// Parse mail - From/Date/Subject headers give author and subject, body up to "---" (or the diff) is
+// the rest of the commit message
+func parseMailPatch(data []byte) (*MailPatch, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mail: %w", err)
+	}
+
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("bad From header: %w", err)
+	}
+	name := from.Name
+	if name == "" {
+		name, _, _ = strings.Cut(from.Address, "@")
+	}
+	date, err := message.Header.Date()
+	if err != nil {
+		return nil, fmt.Errorf("bad Date header: %w", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		subject = message.Header.Get("Subject")
+	}
+	subject = stripPatchSubject(subject)
+
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit message ends at "---" line (before diffstat), or where the diff starts
+	lines := strings.SplitAfter(string(body), "\n")
+	end := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "---" || strings.HasPrefix(trimmed, "diff --git ") || strings.HasPrefix(trimmed, "Index: ") ||
+			(strings.HasPrefix(trimmed, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")) {
+			end = i
+			break
+		}
+	}
+	description := strings.TrimSpace(strings.Join(lines[:end], ""))
+
+	commitMessage := subject + "\n"
+	if description != "" {
+		commitMessage += "\n" + description + "\n"
+	}
+
+	return &MailPatch{
+		Author:  formatSignature(name, from.Address, date),
+		Message: commitMessage,
+		Patch:   []byte(strings.Join(lines[end:], "")),
+	}, nil
+}
+
+// Remove "[PATCH n/m]"-like prefixes and "Re:" from mail subject
+func stripPatchSubject(subject string) string {
+	subject = strings.Join(strings.Fields(subject), " ")
+	for {
+		switch {
+		case strings.HasPrefix(subject, "["):
+			end := strings.IndexByte(subject, ']')
+			if end == -1 {
+				return subject
+			}
+			subject = strings.TrimSpace(subject[end+1:])
+		case strings.HasPrefix(strings.ToLower(subject), "re:"):
+			subject = strings.TrimSpace(subject[3:])
+		default:
+			return subject
+		}
+	}
+}
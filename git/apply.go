@@ -0,0 +1,550 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apply - unified diff (git diff / format-patch output, or plain diff -u) applied to work tree or index
+//
+// git extended headers (new/deleted file mode, old/new mode, rename/copy from/to) are understood, paths
+// lose their first component (a/, b/) and have to stay inside the work tree (no absolute paths, ".." or
+// .git components). Binary files need a binary patch (see binarypatch.go). Every hunk is looked for at the
+// line it names first, then further and further away; if context doesn't match anywhere, up to
+// maxApplyFuzz context lines are dropped from both ends of the hunk (fuzz). Nothing is written unless every
+// hunk applies.
+
+// Context lines that may be ignored at each end of a hunk that doesn't match exactly
+const maxApplyFuzz = 2
+
+// Parse patch text into file patches - text before, between and after diffs (mail headers, diffstat) is skipped
+func parsePatch(data []byte) ([]FilePatch, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	var patches []FilePatch
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			patch, next, err := parseGitFilePatch(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, patch)
+			i = next
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			var patch FilePatch
+			next, err := parseFilePatchBody(lines, i, &patch)
+			if err != nil {
+				return nil, err
+			}
+			patch.IsNew = patch.OldPath == ""
+			patch.IsDelete = patch.NewPath == ""
+			patches = append(patches, patch)
+			i = next
+		default:
+			i++
+		}
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no valid patches in input")
+	}
+	for _, patch := range patches {
+		if err := checkPatchPaths(patch); err != nil {
+			return nil, err
+		}
+	}
+	return patches, nil
+}
+
+// Check paths of file patch once they are stripped - absolute paths, ".." and .git components (and empty
+// or "." ones) would write outside the work tree or into the repository
+func checkPatchPaths(patch FilePatch) error {
+	for _, path := range []string{patch.OldPath, patch.NewPath} {
+		if path != "" && verifyPath(path) != nil {
+			return fmt.Errorf("invalid path '%s'", path)
+		}
+	}
+	return nil
+}
+
+// Parse "diff --git" header, extended header lines and the diff body that follows
+func parseGitFilePatch(lines []string, i int) (FilePatch, int, error) {
+	var patch FilePatch
+	header := strings.TrimSuffix(strings.TrimPrefix(lines[i], "diff --git "), "\n")
+	if separator := strings.LastIndex(header, " b/"); separator != -1 {
+		patch.OldPath = stripPatchPath(header[:separator])
+		patch.NewPath = stripPatchPath(header[separator+1:])
+	}
+	i++
+
+	for ; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\n")
+		key, value := "", ""
+		for _, prefix := range []string{"old mode ", "new mode ", "deleted file mode ", "new file mode ", "rename from ", "rename to ",
+			"copy from ", "copy to ", "index "} {
+			if rest, ok := strings.CutPrefix(line, prefix); ok {
+				key, value = prefix, rest
+				break
+			}
+		}
+
+		switch {
+		case key == "old mode ":
+			patch.OldMode = value
+		case key == "new mode ":
+			patch.NewMode = value
+		case key == "deleted file mode ":
+			patch.IsDelete, patch.OldMode = true, value
+		case key == "new file mode ":
+			patch.IsNew, patch.NewMode = true, value
+		case key == "rename from ":
+			patch.IsRename, patch.OldPath = true, value
+		case key == "rename to ":
+			patch.IsRename, patch.NewPath = true, value
+		case key == "copy from ":
+			patch.IsCopy, patch.OldPath = true, value
+		case key == "copy to ":
+			patch.IsCopy, patch.NewPath = true, value
+		case key == "index ":
+			// index <old>..<new> [<mode>] - mode is there when it didn't change
+			hashes, mode, ok := strings.Cut(value, " ")
+			if ok && patch.OldMode == "" && patch.NewMode == "" {
+				patch.OldMode, patch.NewMode = mode, mode
+			}
+			patch.OldHash, patch.NewHash, _ = strings.Cut(hashes, "..")
+		case strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "Binary files "):
+			// Binary change without data - applies only when the result is already known
+			patch.Binary = true
+			return patch, i + 1, nil
+		case line == "GIT binary patch":
+			patch.Binary = true
+			hunks, next, err := parseBinaryPatch(lines, i+1)
+			if err != nil {
+				return patch, next, fmt.Errorf("%s: %w", patch.NewPath, err)
+			}
+			patch.BinaryHunks = hunks
+			return patch, next, nil
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "@@ "):
+			oldPath, newPath := patch.OldPath, patch.NewPath
+			next, err := parseFilePatchBody(lines, i, &patch)
+			// ---/+++ of new and deleted files say /dev/null - the header has the real path
+			if patch.OldPath == "" {
+				patch.OldPath = oldPath
+			}
+			if patch.NewPath == "" {
+				patch.NewPath = newPath
+			}
+			return patch, next, err
+		default:
+			// Header without diff body (pure rename or mode change)
+			return patch, i, nil
+		}
+	}
+	return patch, i, nil
+}
+
+// Parse optional ---/+++ lines followed by hunks - returns index of the first line after them
+func parseFilePatchBody(lines []string, i int, patch *FilePatch) (int, error) {
+	if strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+		patch.OldPath = stripPatchPath(strings.TrimPrefix(lines[i], "--- "))
+		patch.NewPath = stripPatchPath(strings.TrimPrefix(lines[i+1], "+++ "))
+		i += 2
+	}
+
+	for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+		hunk, next, err := parseHunk(lines, i)
+		if err != nil {
+			return i, fmt.Errorf("%s: %w", patch.NewPath, err)
+		}
+		patch.Hunks = append(patch.Hunks, hunk)
+		i = next
+	}
+	return i, nil
+}
+
+// Parse "@@ -start,count +start,count @@" and the lines of the hunk
+func parseHunk(lines []string, i int) (DiffHunk, int, error) {
+	var hunk DiffHunk
+	fields := strings.Fields(lines[i])
+	if len(fields) < 4 || fields[3] != "@@" {
+		return hunk, i, fmt.Errorf("corrupt hunk header: %s", strings.TrimSpace(lines[i]))
+	}
+	var err error
+	if hunk.OldStart, hunk.OldLines, err = parseHunkRange(fields[1], "-"); err != nil {
+		return hunk, i, err
+	}
+	if hunk.NewStart, hunk.NewLines, err = parseHunkRange(fields[2], "+"); err != nil {
+		return hunk, i, err
+	}
+	i++
+
+	oldLeft, newLeft := hunk.OldLines, hunk.NewLines
+	for oldLeft > 0 || newLeft > 0 {
+		if i >= len(lines) || lines[i] == "" {
+			return hunk, i, fmt.Errorf("patch ends in the middle of a hunk")
+		}
+		line := lines[i]
+		i++
+		if line == "\n" {
+			// Mailers like to strip trailing space of empty context lines
+			line = " \n"
+		}
+
+		switch line[0] {
+		case ' ':
+			oldLeft--
+			newLeft--
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		case '\\':
+			markNoNewline(&hunk)
+			continue
+		default:
+			return hunk, i, fmt.Errorf("corrupt patch line: %s", strings.TrimSuffix(line, "\n"))
+		}
+		hunk.Lines = append(hunk.Lines, DiffLine{Kind: line[0], Text: line[1:]})
+	}
+	if oldLeft < 0 || newLeft < 0 {
+		return hunk, i, fmt.Errorf("hunk has more lines than its header says")
+	}
+
+	if i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+		markNoNewline(&hunk)
+		i++
+	}
+	return hunk, i, nil
+}
+
+// "\ No newline at end of file" - previous line has no newline
+func markNoNewline(hunk *DiffHunk) {
+	if len(hunk.Lines) > 0 {
+		last := &hunk.Lines[len(hunk.Lines)-1]
+		last.Text = strings.TrimSuffix(last.Text, "\n")
+	}
+}
+
+// Parse "-start,count" (count is 1 if left out)
+func parseHunkRange(value, sign string) (int, int, error) {
+	value, ok := strings.CutPrefix(value, sign)
+	if !ok {
+		return 0, 0, fmt.Errorf("corrupt hunk range: %s", value)
+	}
+	startText, countText, hasCount := strings.Cut(value, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("corrupt hunk range: %s", value)
+	}
+	count := 1
+	if hasCount {
+		if count, err = strconv.Atoi(countText); err != nil {
+			return 0, 0, fmt.Errorf("corrupt hunk range: %s", value)
+		}
+	}
+	return start, count, nil
+}
+
+// Path from ---/+++ line or diff --git header - first component removed, /dev/null becomes empty
+func stripPatchPath(value string) string {
+	value = strings.TrimSuffix(value, "\n")
+	// Timestamp (diff -u) or terminating tab (names with spaces) follows the name
+	value, _, _ = strings.Cut(value, "\t")
+	if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, "\"") {
+		value = unquoted
+	}
+	if value == "/dev/null" {
+		return ""
+	}
+	if _, rest, ok := strings.Cut(value, "/"); ok {
+		return rest
+	}
+	return value
+}
+
+// Apply hunks to content - returns new content
+func applyHunks(content []byte, hunks []DiffHunk, path string) ([]byte, error) {
+	lines := splitLines(content)
+	offset, searchFrom := 0, 0
+
+	for number, hunk := range hunks {
+		applied := false
+		for fuzz := 0; fuzz <= maxApplyFuzz && !applied; fuzz++ {
+			oldLines, newLines, lead, ok := fuzzHunk(hunk, fuzz)
+			if !ok {
+				break
+			}
+
+			expected := hunk.OldStart - 1 + lead + offset
+			if hunk.OldLines == 0 {
+				// Pure insertion - OldStart is the line after which lines are added
+				expected = hunk.OldStart + offset
+			}
+			position := findHunkPosition(lines, oldLines, expected, searchFrom)
+			if position == -1 {
+				continue
+			}
+			if fuzz > 0 {
+				fmt.Fprintf(os.Stderr, "warning: hunk #%d of %s applied with fuzz %d\n", number+1, path, fuzz)
+			}
+
+			lines = append(lines[:position], append(append([]string(nil), newLines...), lines[position+len(oldLines):]...)...)
+			offset += position - expected + len(newLines) - len(oldLines)
+			searchFrom = position + len(newLines)
+			applied = true
+		}
+		if !applied {
+			return nil, fmt.Errorf("patch failed: %s:%d", path, hunk.OldStart)
+		}
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// Old and new lines of hunk with up to fuzz context lines dropped at each end - ok is false if there is
+// not enough context to drop. lead is the number of dropped leading lines
+func fuzzHunk(hunk DiffHunk, fuzz int) ([]string, []string, int, bool) {
+	lead, trail := 0, 0
+	for lead < fuzz && lead < len(hunk.Lines) && hunk.Lines[lead].Kind == ' ' {
+		lead++
+	}
+	for trail < fuzz && trail < len(hunk.Lines)-lead && hunk.Lines[len(hunk.Lines)-1-trail].Kind == ' ' {
+		trail++
+	}
+	if fuzz > 0 && lead+trail == 0 {
+		return nil, nil, 0, false
+	}
+
+	var oldLines, newLines []string
+	for _, line := range hunk.Lines[lead : len(hunk.Lines)-trail] {
+		if line.Kind != '+' {
+			oldLines = append(oldLines, line.Text)
+		}
+		if line.Kind != '-' {
+			newLines = append(newLines, line.Text)
+		}
+	}
+	return oldLines, newLines, lead, true
+}
+
+// Find where old lines are in lines - closest match to expected position, not before searchFrom
+func findHunkPosition(lines, oldLines []string, expected, searchFrom int) int {
+	last := len(lines) - len(oldLines)
+	expected = min(max(expected, searchFrom), max(last, searchFrom))
+	for distance := 0; expected-distance >= searchFrom || expected+distance <= last; distance++ {
+		for _, position := range []int{expected - distance, expected + distance} {
+			if position < searchFrom || position > last {
+				continue
+			}
+			if linesMatch(lines[position:position+len(oldLines)], oldLines) {
+				return position
+			}
+		}
+	}
+	return -1
+}
+
+func linesMatch(a, b []string) bool {
+	for i := range b {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply file patches to work tree (or to index with cached) - all or nothing
+func applyPatches(patches []FilePatch, cached bool) error {
+	converter, err := newEolConverter()
+	if err != nil {
+		return err
+	}
+
+	var index map[string]IndexEntry
+	if cached {
+		entries, err := readGitIndex()
+		if err != nil {
+			return err
+		}
+		index = make(map[string]IndexEntry, len(entries))
+		for _, entry := range entries {
+			index[entry.Path] = entry
+		}
+	}
+
+	// Current state of every touched file - later patches for the same file see earlier results, except copies
+	// which are made from the file as it was before the patch
+	files := make(map[string]*PatchedFile)
+	originals := make(map[string]*PatchedFile)
+	load := func(path string) (*PatchedFile, error) {
+		if file, ok := files[path]; ok {
+			return file, nil
+		}
+		file := &PatchedFile{Deleted: true}
+		if cached {
+			if entry, ok := index[path]; ok {
+				_, _, content, err := readObjectFromHash(hex.EncodeToString(entry.Hash))
+				if err != nil {
+					return nil, err
+				}
+				file = &PatchedFile{Mode: fmt.Sprintf("%o", entry.Mode), Content: content}
+			}
+		} else {
+			content, mode, err := readWorkTreeFile(path, converter)
+			if err == nil {
+				file = &PatchedFile{Mode: fmt.Sprintf("%o", mode), Content: content}
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		files[path], originals[path] = file, file
+		return file, nil
+	}
+
+	for _, patch := range patches {
+		source := patch.OldPath
+		if patch.IsNew {
+			source = patch.NewPath
+		}
+		file, err := load(source)
+		if err != nil {
+			return err
+		}
+		if patch.IsCopy {
+			file = originals[source]
+		}
+
+		if patch.IsNew && !file.Deleted {
+			return fmt.Errorf("%s: already exists", patch.NewPath)
+		}
+		if !patch.IsNew && file.Deleted {
+			return fmt.Errorf("%s: does not exist", source)
+		}
+
+		var content []byte
+		if patch.Binary {
+			content, err = applyBinaryPatch(file.Content, patch, source)
+		} else {
+			content, err = applyHunks(file.Content, patch.Hunks, source)
+		}
+		if err != nil {
+			return err
+		}
+
+		if patch.IsDelete {
+			if len(content) > 0 {
+				return fmt.Errorf("removal patch leaves file contents: %s", source)
+			}
+			files[source] = &PatchedFile{Deleted: true}
+			continue
+		}
+
+		mode := file.Mode
+		if patch.NewMode != "" {
+			mode = patch.NewMode
+		}
+		if mode == "" {
+			mode = "100644"
+		}
+		if patch.IsRename || patch.IsCopy {
+			if existing, err := load(patch.NewPath); err != nil {
+				return err
+			} else if !existing.Deleted {
+				return fmt.Errorf("%s: already exists", patch.NewPath)
+			}
+		}
+		if patch.IsRename {
+			files[source] = &PatchedFile{Deleted: true}
+		}
+		files[patch.NewPath] = &PatchedFile{Mode: mode, Content: content}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	if cached {
+		return writePatchedIndex(index, files, paths)
+	}
+	for _, path := range paths {
+		file := files[path]
+		if file.Deleted {
+			if err := os.Remove(workTreePath(filepath.FromSlash(path))); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			removeEmptyParentDirs(path)
+			continue
+		}
+		if err := writeWorkTreeFile(path, file.Mode, file.Content, converter); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Write patched files as blobs and update index entries
+func writePatchedIndex(index map[string]IndexEntry, files map[string]*PatchedFile, paths []string) error {
+	for _, path := range paths {
+		file := files[path]
+		if file.Deleted {
+			delete(index, path)
+			continue
+		}
+
+		hash, err := writeObject(generateObjectByte("blob", file.Content))
+		if err != nil {
+			return err
+		}
+		mode, err := strconv.ParseUint(file.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("bad mode %s for %s", file.Mode, path)
+		}
+		index[path] = IndexEntry{Path: path, Hash: hash, Mode: uint32(mode)}
+	}
+
+	entries := make([]IndexEntry, 0, len(index))
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+	return writeGitIndex(entries)
+}
+
+// Remove directories left empty after path was deleted (work tree root is kept)
+func removeEmptyParentDirs(path string) {
+	for dir := filepath.Dir(filepath.FromSlash(path)); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if err := os.Remove(workTreePath(dir)); err != nil {
+			return
+		}
+	}
+}
+
+// Read patch files (stdin when there are none) and concatenate them
+func readPatchInput(files []string) ([]byte, error) {
+	if len(files) == 0 {
+		return io.ReadAll(os.Stdin)
+	}
+	var data bytes.Buffer
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patch %s: %w", file, err)
+		}
+		data.Write(content)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			data.WriteByte('\n')
+		}
+	}
+	return data.Bytes(), nil
+}
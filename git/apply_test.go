@@ -0,0 +1,54 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+// Patch paths that would leave the work tree or write into the repository are refused (apply and am
+// share parsePatch)
+func TestParsePatchRejectsUnsafePaths(t *testing.T) {
+	hunk := "@@ -0,0 +1 @@\n+pwned\n"
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"parent directory", "diff --git a/../pwned b/../pwned\nnew file mode 100644\n--- /dev/null\n+++ b/../pwned\n" + hunk},
+		{"nested parent directory", "--- /dev/null\n+++ b/dir/../../pwned\n" + hunk},
+		{"absolute path", "--- /dev/null\n+++ b//tmp/pwned\n" + hunk},
+		{".git component", "diff --git a/.git/hooks/pre-commit b/.git/hooks/pre-commit\nnew file mode 100755\n--- /dev/null\n+++ b/.git/hooks/pre-commit\n" + hunk},
+		{".git in other case", "--- /dev/null\n+++ b/.GIT/config\n" + hunk},
+		{"nested .git", "--- /dev/null\n+++ b/sub/.Git/hooks/post-checkout\n" + hunk},
+		{"rename to parent directory", "diff --git a/file b/file\nsimilarity index 100%\nrename from file\nrename to ../file\n"},
+		{"copy to .git", "diff --git a/file b/file\nsimilarity index 100%\ncopy from file\ncopy to .git/config\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parsePatch([]byte(test.patch))
+			if err == nil || !strings.Contains(err.Error(), "invalid path") {
+				t.Fatalf("expected invalid path error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePatchAcceptsWorkTreePaths(t *testing.T) {
+	patch := "diff --git a/dir/.gitignore b/dir/.gitignore\nnew file mode 100644\n--- /dev/null\n+++ b/dir/.gitignore\n" +
+		"@@ -0,0 +1 @@\n+*.o\n" +
+		"--- a/..file\n+++ b/..file\n@@ -1 +1 @@\n-a\n+b\n"
+	patches, err := parsePatch([]byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 2 || patches[0].NewPath != "dir/.gitignore" || patches[1].NewPath != "..file" {
+		t.Fatalf("unexpected patches: %+v", patches)
+	}
+}
+
+// am applies mail patches through parsePatch - a patch planting a hook fails before anything is written
+func TestApplyMailPatchRejectsDotGitPath(t *testing.T) {
+	mailPatch := &MailPatch{Patch: []byte("--- /dev/null\n+++ b/.git/hooks/pre-commit\n@@ -0,0 +1 @@\n+pwned\n")}
+	if err := applyMailPatch(mailPatch); err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("expected invalid path error, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Gitattributes engine - each line is "<pattern> <attr>..." where attr can be:
+//   - attr        -> set
+//   - -attr       -> unset
+//   - !attr       -> unspecified (reset to default)
+//   - attr=value  -> value
+//
+// Attribute files are applied from lowest to highest priority:
+//   - core.attributesFile
+//   - .gitattributes in every directory (deeper directories override parent ones)
+//   - .git/info/attributes
+//
+// Patterns follow gitignore rules (except negation, and patterns ending with / never match).
+// [attr]<name> lines define macros, e.g. the built-in "binary" macro is "-diff -merge -text".
+
+func (value AttributeValue) String() string {
+	switch value.State {
+	case ATTR_SET:
+		return "set"
+	case ATTR_UNSET:
+		return "unset"
+	case ATTR_VALUE:
+		return value.Value
+	default:
+		return "unspecified"
+	}
+}
+
+// Creates matcher with global attributes file and info/attributes loaded
+func newAttributeMatcher() (*AttributeMatcher, error) {
+	matcher := &AttributeMatcher{
+		loadedDirs: make(map[string]bool),
+		macros: map[string][]AttributeAssignment{
+			"binary": {
+				{Name: "diff", Value: AttributeValue{State: ATTR_UNSET}},
+				{Name: "merge", Value: AttributeValue{State: ATTR_UNSET}},
+				{Name: "text", Value: AttributeValue{State: ATTR_UNSET}},
+			},
+		},
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if attributesFile, ok := config.Get("core.attributesFile"); ok {
+		matcher.globalRules, err = matcher.loadFile(expandConfigPath(attributesFile), "", true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matcher.infoRules, err = matcher.loadFile(gitDirPath("info", "attributes"), "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	// .gitattributes files are loaded lazily on first lookup, so a checkout that writes them
+	// before other files in the same directory still gets correct attributes
+	return matcher, nil
+}
+
+// Load .gitattributes from directory (relative to work tree root) - parents are loaded first
+func (matcher *AttributeMatcher) loadDir(dir string) error {
+	if matcher.loadedDirs[dir] {
+		return nil
+	}
+
+	if dir != "" {
+		if err := matcher.loadDir(parentDir(dir)); err != nil {
+			return err
+		}
+	}
+
+	matcher.loadedDirs[dir] = true
+	// Macros can only be defined in top-level files
+	rules, err := matcher.loadFile(workTreePath(dir, ".gitattributes"), dir, dir == "")
+	if err != nil {
+		return err
+	}
+	matcher.dirRules = append(matcher.dirRules, rules...)
+	return nil
+}
+
+// Parse attributes file - base is directory (relative to work tree root) to which patterns are relative
+func (matcher *AttributeMatcher) loadFile(filePath, base string, allowMacros bool) ([]AttributeRule, error) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	var rules []AttributeRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimSuffix(scanner.Text(), "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		assignments := parseAttributeAssignments(fields[1:])
+
+		// [attr]name - macro definition
+		if strings.HasPrefix(fields[0], "[attr]") {
+			if allowMacros {
+				matcher.macros[strings.TrimPrefix(fields[0], "[attr]")] = assignments
+			}
+			continue
+		}
+
+		pattern, ok := parseIgnoreLine(fields[0])
+		if !ok || pattern.Negate {
+			// Negative patterns are forbidden in attributes files
+			continue
+		}
+		pattern.Base = base
+		pattern.Source = filePath
+
+		rules = append(rules, AttributeRule{Pattern: pattern, Assignments: assignments})
+	}
+
+	return rules, scanner.Err()
+}
+
+// Parse list of attr, -attr, !attr and attr=value tokens
+func parseAttributeAssignments(tokens []string) []AttributeAssignment {
+	assignments := make([]AttributeAssignment, 0, len(tokens))
+	for _, token := range tokens {
+		var assignment AttributeAssignment
+		switch {
+		case strings.HasPrefix(token, "-"):
+			assignment = AttributeAssignment{Name: token[1:], Value: AttributeValue{State: ATTR_UNSET}}
+		case strings.HasPrefix(token, "!"):
+			assignment = AttributeAssignment{Name: token[1:], Value: AttributeValue{State: ATTR_UNSPECIFIED}}
+		case strings.Contains(token, "="):
+			name, value, _ := strings.Cut(token, "=")
+			assignment = AttributeAssignment{Name: name, Value: AttributeValue{State: ATTR_VALUE, Value: value}}
+		default:
+			assignment = AttributeAssignment{Name: token, Value: AttributeValue{State: ATTR_SET}}
+		}
+		if assignment.Name != "" {
+			assignments = append(assignments, assignment)
+		}
+	}
+	return assignments
+}
+
+// All attributes that are specified for path (relative to work tree root, / separators)
+func (matcher *AttributeMatcher) attributesFor(filePath string) map[string]AttributeValue {
+	attributes := make(map[string]AttributeValue)
+	if err := matcher.loadDir(parentDir(filePath)); err != nil {
+		return attributes
+	}
+
+	for _, rules := range [][]AttributeRule{matcher.globalRules, matcher.dirRules, matcher.infoRules} {
+		for _, rule := range rules {
+			// Patterns ending with / are directory patterns and never match in attributes files
+			if rule.Pattern.DirOnly || !rule.Pattern.matches(filePath, false) {
+				continue
+			}
+			for _, assignment := range rule.Assignments {
+				matcher.assign(attributes, assignment, 0)
+			}
+		}
+	}
+
+	for name, value := range attributes {
+		if value.State == ATTR_UNSPECIFIED {
+			delete(attributes, name)
+		}
+	}
+	return attributes
+}
+
+// Apply one assignment, expanding macros when they are set
+func (matcher *AttributeMatcher) assign(attributes map[string]AttributeValue, assignment AttributeAssignment, depth int) {
+	attributes[assignment.Name] = assignment.Value
+
+	macro, isMacro := matcher.macros[assignment.Name]
+	if !isMacro || assignment.Value.State != ATTR_SET || depth > 10 {
+		return
+	}
+	for _, macroAssignment := range macro {
+		matcher.assign(attributes, macroAssignment, depth+1)
+	}
+}
+
+// Value of single attribute for path
+func (matcher *AttributeMatcher) attribute(filePath, name string) AttributeValue {
+	return matcher.attributesFor(filePath)[name]
+}
+
+// Names of specified attributes, sorted (used by check-attr --all)
+func sortedAttributeNames(attributes map[string]AttributeValue) []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,185 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Binary patches (diff --binary, format-patch) - a binary file change is written as
+//
+//	GIT binary patch
+//	literal <size>      new content (or "delta <size>" - a pack delta from the old content)
+//	<data lines>
+//	<empty line>
+//	literal <size>      old content, so the patch can be applied in reverse
+//	<data lines>
+//	<empty line>
+//
+// Data is zlib compressed and base85 encoded, at most 52 bytes per line; the first character of a line is
+// its byte count ('A'-'Z' for 1-26, 'a'-'z' for 27-52). Only literal hunks are written, apply takes deltas
+// too. The index line of a binary patch has full hashes - apply checks them against the file it patches.
+
+// Base85 alphabet of binary patches
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// Bytes encoded on one line of a binary hunk
+const binaryPatchLineBytes = 52
+
+// Write binary patch of a change from oldContent to newContent
+func writeBinaryPatch(w io.Writer, oldContent, newContent []byte) {
+	io.WriteString(w, "GIT binary patch\n")
+	writeBinaryHunk(w, newContent)
+	writeBinaryHunk(w, oldContent)
+}
+
+// Write content as literal hunk
+func writeBinaryHunk(w io.Writer, content []byte) {
+	var compressed bytes.Buffer
+	writer, _ := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	writer.Write(content)
+	writer.Close()
+
+	fmt.Fprintf(w, "literal %d\n", len(content))
+	data := compressed.Bytes()
+	for len(data) > 0 {
+		n := min(len(data), binaryPatchLineBytes)
+		io.WriteString(w, encodeBase85Line(data[:n])+"\n")
+		data = data[n:]
+	}
+	io.WriteString(w, "\n")
+}
+
+// Line of binary hunk - length character, then data in groups of 4 bytes (the last one padded with zeros)
+func encodeBase85Line(data []byte) string {
+	var line strings.Builder
+	if len(data) <= 26 {
+		line.WriteByte(byte('A' + len(data) - 1))
+	} else {
+		line.WriteByte(byte('a' + len(data) - 27))
+	}
+	for i := 0; i < len(data); i += 4 {
+		var group [4]byte
+		copy(group[:], data[i:])
+		value := uint32(group[0])<<24 | uint32(group[1])<<16 | uint32(group[2])<<8 | uint32(group[3])
+		var encoded [5]byte
+		for j := 4; j >= 0; j-- {
+			encoded[j] = base85Alphabet[value%85]
+			value /= 85
+		}
+		line.Write(encoded[:])
+	}
+	return line.String()
+}
+
+// Data of binary hunk line
+func decodeBase85Line(line string) ([]byte, error) {
+	if line == "" {
+		return nil, fmt.Errorf("corrupt binary patch line")
+	}
+	var length int
+	switch c := line[0]; {
+	case c >= 'A' && c <= 'Z':
+		length = int(c-'A') + 1
+	case c >= 'a' && c <= 'z':
+		length = int(c-'a') + 27
+	default:
+		return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+	}
+	encoded := line[1:]
+	if len(encoded) != (length+3)/4*5 {
+		return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+	}
+
+	data := make([]byte, 0, len(encoded)/5*4)
+	for i := 0; i < len(encoded); i += 5 {
+		var value uint64
+		for _, c := range []byte(encoded[i : i+5]) {
+			digit := strings.IndexByte(base85Alphabet, c)
+			if digit == -1 {
+				return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+			}
+			value = value*85 + uint64(digit)
+		}
+		if value > 0xffffffff {
+			return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+		}
+		data = append(data, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+	}
+	return data[:length], nil
+}
+
+// Parse hunks following "GIT binary patch" at lines[i] - returns index of the first line after them
+func parseBinaryPatch(lines []string, i int) ([]BinaryHunk, int, error) {
+	var hunks []BinaryHunk
+	for i < len(lines) {
+		header := strings.TrimSuffix(lines[i], "\n")
+		kind, sizeText, ok := strings.Cut(header, " ")
+		if !ok || (kind != "literal" && kind != "delta") {
+			break
+		}
+		size, err := strconv.Atoi(sizeText)
+		if err != nil {
+			return nil, i, fmt.Errorf("corrupt binary patch header: %s", header)
+		}
+		i++
+
+		var compressed []byte
+		for ; i < len(lines) && strings.TrimSuffix(lines[i], "\n") != ""; i++ {
+			data, err := decodeBase85Line(strings.TrimSuffix(lines[i], "\n"))
+			if err != nil {
+				return nil, i, err
+			}
+			compressed = append(compressed, data...)
+		}
+		// Empty line ends the hunk
+		i++
+
+		reader, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, i, fmt.Errorf("corrupt binary patch data: %w", err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, i, fmt.Errorf("corrupt binary patch data: %w", err)
+		}
+		if len(data) != size {
+			return nil, i, fmt.Errorf("binary patch data is %d bytes, header says %d", len(data), size)
+		}
+		hunks = append(hunks, BinaryHunk{Delta: kind == "delta", Data: data})
+	}
+	if len(hunks) == 0 {
+		return nil, i, fmt.Errorf("binary patch has no data")
+	}
+	return hunks, i, nil
+}
+
+// Apply binary patch (its forward hunk) to content - content has to be the blob of the index line
+func applyBinaryPatch(content []byte, patch FilePatch, path string) ([]byte, error) {
+	if len(patch.BinaryHunks) == 0 {
+		return nil, fmt.Errorf("cannot apply binary patch to '%s' without full index line", path)
+	}
+	if len(patch.OldHash) == 40 && !patch.IsNew {
+		if hash := hashObject(generateObjectByte("blob", content)); fmt.Sprintf("%x", hash) != patch.OldHash {
+			return nil, fmt.Errorf("the patch applies to '%s' (%s), which does not match the current contents", path, patch.OldHash)
+		}
+	}
+
+	hunk := patch.BinaryHunks[0]
+	result := hunk.Data
+	if hunk.Delta {
+		var err error
+		if result, err = applyDelta(content, hunk.Data); err != nil {
+			return nil, fmt.Errorf("binary patch does not apply to '%s': %w", path, err)
+		}
+	}
+	if len(patch.NewHash) == 40 && !patch.IsDelete {
+		if hash := hashObject(generateObjectByte("blob", result)); fmt.Sprintf("%x", hash) != patch.NewHash {
+			return nil, fmt.Errorf("binary patch to '%s' creates incorrect result (expecting %s)", path, patch.NewHash)
+		}
+	}
+	return result, nil
+}
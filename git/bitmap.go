@@ -0,0 +1,448 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reachability bitmaps - objects/pack/pack-<checksum>.bitmap says which objects of the pack are reachable from
+// some of its commits. Bit i stands for the i-th object of the pack in pack order (by offset):
+//
+//	"BITM" + version (1, 2 bytes) + flags (2 bytes, 1 - full DAG) + entry count (4 bytes) + pack checksum
+//	type bitmaps (EWAH) - commits, trees, blobs, tags
+//	entries - position of the commit in .idx order (4 bytes), XOR offset (1 byte), flags (1 byte) and EWAH
+//	          bitmap of objects reachable from the commit (XORed with the bitmap of the entry that many
+//	          entries before, unless the offset is 0)
+//	(name-hash cache and lookup table, when git wrote them - not needed here)
+//	checksum of everything above
+//
+// Walks for objects reachable from some commits (upload-pack, push, rev-list --objects) take the bitmap of
+// every commit that has one instead of walking its history and trees again - only newer commits and objects
+// outside of the pack are walked. Bitmaps are written with packs when repack.writeBitmaps is set (true in
+// bare repositories), for ref tips and every 100th commit whose whole history is in the pack.
+// pack.useBitmaps = false ignores them, and shallow repositories don't use them.
+
+const (
+	bitmapFullDag = 1
+	// Every n-th commit of the history gets a bitmap (ref tips always do)
+	bitmapCommitSpacing = 100
+)
+
+// Loaded bitmap by own object directory (nil - there is none) - read once per process
+var packBitmapCache = make(map[string]*PackBitmap)
+
+// Load bitmap of a pack in own object directory - nil when there is none (or bitmaps are off)
+func loadPackBitmap() (*PackBitmap, error) {
+	objectDir := resolveRepoLayout().ObjectDir
+	if bitmap, ok := packBitmapCache[objectDir]; ok {
+		return bitmap, nil
+	}
+	packBitmapCache[objectDir] = nil
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !config.GetBool("pack.useBitmaps", true) {
+		return nil, nil
+	}
+	// Bitmaps hold the stored history - not the one shallow commits and grafts give
+	if grafted, err := hasCommitGrafts(); err != nil || grafted {
+		return nil, err
+	}
+	bitmapPaths, err := filepath.Glob(objectDirPath("pack", "*.bitmap"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(bitmapPaths)
+	for _, bitmapPath := range bitmapPaths {
+		base := strings.TrimSuffix(bitmapPath, ".bitmap")
+		if _, err := os.Stat(base + ".pack"); err != nil {
+			continue
+		}
+		index, err := parsePackIndex(base + ".idx")
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(bitmapPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(bitmapPath), err)
+		}
+		bitmap, err := parsePackBitmap(data, index, base+".pack")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(bitmapPath), err)
+		}
+		packBitmapCache[objectDir] = bitmap
+		return bitmap, nil
+	}
+	return nil, nil
+}
+
+// Parse .bitmap of the pack that index belongs to
+func parsePackBitmap(data []byte, index *PackIndex, packPath string) (*PackBitmap, error) {
+	if len(data) < 32 || string(data[:4]) != "BITM" {
+		return nil, fmt.Errorf("not a bitmap file")
+	}
+	if version := binary.BigEndian.Uint16(data[4:6]); version != 1 {
+		return nil, fmt.Errorf("unsupported bitmap version: %d", version)
+	}
+	if binary.BigEndian.Uint16(data[6:8])&bitmapFullDag == 0 {
+		return nil, fmt.Errorf("bitmap is not of a full pack")
+	}
+	entryCount := int(binary.BigEndian.Uint32(data[8:12]))
+	packChecksum, err := readPackChecksum(packPath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data[12:32], packChecksum) {
+		return nil, fmt.Errorf("bitmap is not of pack %s", filepath.Base(packPath))
+	}
+
+	bitmap := &PackBitmap{PackPath: packPath, Positions: make(map[string]int), Commits: make(map[string][]bool)}
+	// Pack order - idx positions sorted by offset
+	count := index.count()
+	order := make([]int, count)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return index.Offsets[order[a]] < index.Offsets[order[b]] })
+	bitmap.Objects = make([]string, count)
+	for position, i := range order {
+		hash := hex.EncodeToString(index.Hashes[i*20 : i*20+20])
+		bitmap.Objects[position] = hash
+		bitmap.Positions[hash] = position
+	}
+
+	offset := 32
+	for i := range bitmap.Types {
+		bits, used, err := decodeEwahBitmap(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		bitmap.Types[i], offset = bits, offset+used
+	}
+	var entries [][]bool
+	for i := 0; i < entryCount; i++ {
+		if len(data) < offset+6 {
+			return nil, fmt.Errorf("bitmap entry is truncated")
+		}
+		position := int(binary.BigEndian.Uint32(data[offset:]))
+		xorOffset := int(data[offset+4])
+		bits, used, err := decodeEwahBitmap(data[offset+6:])
+		if err != nil {
+			return nil, err
+		}
+		offset += 6 + used
+		if position >= count || xorOffset > i {
+			return nil, fmt.Errorf("bad bitmap entry %d", i)
+		}
+		if xorOffset > 0 {
+			bits = xorBits(bits, entries[i-xorOffset])
+		}
+		entries = append(entries, bits)
+		bitmap.Commits[hex.EncodeToString(index.Hashes[position*20:position*20+20])] = bits
+	}
+	return bitmap, nil
+}
+
+// Checksum at the end of pack
+func readPackChecksum(packPath string) ([]byte, error) {
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	checksum := make([]byte, 20)
+	if _, err := file.Seek(-20, io.SeekEnd); err != nil {
+		return nil, fmt.Errorf("failed to read checksum of %s: %w", filepath.Base(packPath), err)
+	}
+	if _, err := io.ReadFull(file, checksum); err != nil {
+		return nil, fmt.Errorf("failed to read checksum of %s: %w", filepath.Base(packPath), err)
+	}
+	return checksum, nil
+}
+
+// Bits set in exactly one of a and b
+func xorBits(a, b []bool) []bool {
+	result := make([]bool, max(len(a), len(b)))
+	for i := range result {
+		result[i] = (i < len(a) && a[i]) != (i < len(b) && b[i])
+	}
+	return result
+}
+
+// Set bits of from in into (which has a bit for every object of the pack)
+func orBits(into, from []bool) {
+	for i, set := range from {
+		if set && i < len(into) {
+			into[i] = true
+		}
+	}
+}
+
+// Objects reachable from tips - bits of pack objects and hashes of objects outside the pack. Commits with a
+// bitmap are not walked, and blobs are not read.
+func reachableObjectBits(bitmap *PackBitmap, tips []string) ([]bool, map[string]bool, error) {
+	bits := make([]bool, len(bitmap.Objects))
+	extra := make(map[string]bool)
+	mark := func(hash string) bool {
+		if position, ok := bitmap.Positions[hash]; ok {
+			seen := bits[position]
+			bits[position] = true
+			return !seen
+		}
+		seen := extra[hash]
+		extra[hash] = true
+		return !seen
+	}
+
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		hash := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if commitBits, ok := bitmap.Commits[hash]; ok {
+			orBits(bits, commitBits)
+			continue
+		}
+		if !mark(hash) {
+			continue
+		}
+		typeName, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		if typeName != "tree" {
+			objType, err := ObjectTypeFromString(typeName)
+			if err != nil {
+				return nil, nil, err
+			}
+			references, err := objectReferences(objType, content)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse object %s: %w", hash, err)
+			}
+			queue = append(queue, references...)
+			continue
+		}
+		entries, err := parseTreeContent(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse object %s: %w", hash, err)
+		}
+		for _, entry := range entries {
+			switch entry.Mode {
+			case "160000":
+			case "40000":
+				queue = append(queue, entry.Hash)
+			default:
+				mark(entry.Hash)
+			}
+		}
+	}
+	return bits, extra, nil
+}
+
+// Objects reachable from wants but not from haves, found with the bitmap - pack objects in pack order, then
+// the others sorted. False when there is no bitmap to use.
+func bitmapObjects(wants, haves []string) ([]string, bool, error) {
+	bitmap, err := loadPackBitmap()
+	if err != nil || bitmap == nil {
+		return nil, false, err
+	}
+	defer tracePerformance("bitmap walk")()
+	included, includedExtra, err := reachableObjectBits(bitmap, wants)
+	if err != nil {
+		return nil, false, err
+	}
+	excluded, excludedExtra, err := reachableObjectBits(bitmap, haves)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var hashes []string
+	for position, hash := range bitmap.Objects {
+		if included[position] && !excluded[position] {
+			hashes = append(hashes, hash)
+		}
+	}
+	for _, hash := range sortedKeys(includedExtra) {
+		if !excludedExtra[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, true, nil
+}
+
+// Read objects with their content
+func readGitObjects(hashes []string) ([]GitObject, error) {
+	objects := make([]GitObject, 0, len(hashes))
+	for _, hash := range hashes {
+		typeName, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		objType, err := ObjectTypeFromString(typeName)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, GitObject{Type: objType, Data: content, Hash: hash})
+	}
+	return objects, nil
+}
+
+// Write .bitmap for pack that was just written (objects with their offsets) - ref tips and every
+// bitmapCommitSpacing-th commit get a bitmap, if all of their history is in the pack. Bitmaps of other packs
+// are removed. Returns the number of bitmapped commits (no file is written for none).
+func writePackBitmap(packName string, objects []GitObject, offsets []uint64, packChecksum []byte) (int, error) {
+	defer tracePerformance("write bitmap")()
+	order := make([]int, len(objects))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+	positions := make(map[string]int, len(objects))
+	var types [4][]bool
+	for i := range types {
+		types[i] = make([]bool, len(objects))
+	}
+	for position, i := range order {
+		positions[objects[i].Hash] = position
+		switch objects[i].Type {
+		case OBJ_COMMIT:
+			types[0][position] = true
+		case OBJ_TREE:
+			types[1][position] = true
+		case OBJ_BLOB:
+			types[2][position] = true
+		case OBJ_TAG:
+			types[3][position] = true
+		}
+	}
+
+	selected, err := selectBitmapCommits(positions)
+	if err != nil {
+		return 0, err
+	}
+	// Oldest first, so newer commits reuse bitmaps of older ones
+	bitmaps := make(map[string][]bool)
+	var bitmapped []string
+	for i := len(selected) - 1; i >= 0; i-- {
+		if bits := packClosureBits(selected[i], objects, order, positions, bitmaps); bits != nil {
+			bitmaps[selected[i]] = bits
+			bitmapped = append(bitmapped, selected[i])
+		}
+	}
+	if len(bitmapped) == 0 {
+		return 0, nil
+	}
+
+	// Entries name commits by their position in the .idx (sorted hashes)
+	sortedHashes := make([]string, 0, len(objects))
+	for _, object := range objects {
+		sortedHashes = append(sortedHashes, object.Hash)
+	}
+	sort.Strings(sortedHashes)
+	sort.Slice(bitmapped, func(a, b int) bool { return bitmapped[a] < bitmapped[b] })
+
+	var buf bytes.Buffer
+	buf.WriteString("BITM")
+	binary.Write(&buf, binary.BigEndian, uint16(1))
+	binary.Write(&buf, binary.BigEndian, uint16(bitmapFullDag))
+	binary.Write(&buf, binary.BigEndian, uint32(len(bitmapped)))
+	buf.Write(packChecksum)
+	for _, bits := range types {
+		buf.Write(encodeEwahBitmap(bits))
+	}
+	for _, hash := range bitmapped {
+		binary.Write(&buf, binary.BigEndian, uint32(sort.SearchStrings(sortedHashes, hash)))
+		buf.Write([]byte{0, 0})
+		buf.Write(encodeEwahBitmap(bitmaps[hash]))
+	}
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	// One bitmap per repository - older ones describe packs that are not complete anymore
+	others, err := filepath.Glob(objectDirPath("pack", "*.bitmap"))
+	if err != nil {
+		return 0, err
+	}
+	for _, other := range others {
+		if err := os.Remove(other); err != nil {
+			return 0, fmt.Errorf("failed to remove %s: %w", filepath.Base(other), err)
+		}
+	}
+	bitmapPath := objectDirPath("pack", strings.TrimSuffix(packName, ".pack")+".bitmap")
+	tmpPath := bitmapPath + ".lock"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0444); err != nil {
+		return 0, fmt.Errorf("failed to write bitmap: %w", err)
+	}
+	if err := os.Rename(tmpPath, bitmapPath); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write bitmap: %w", err)
+	}
+	return len(bitmapped), nil
+}
+
+// Commits of the pack that get a bitmap - ref tips (tags peeled) and every bitmapCommitSpacing-th commit of
+// the history, newest first
+func selectBitmapCommits(positions map[string]int) ([]string, error) {
+	if grafted, err := hasCommitGrafts(); err != nil || grafted {
+		return nil, err
+	}
+	tips, err := refTipCommits()
+	if err != nil || len(tips) == 0 {
+		return nil, err
+	}
+	commits, err := revList(tips, nil)
+	if err != nil {
+		return nil, err
+	}
+	isTip := make(map[string]bool)
+	for _, hash := range tips {
+		isTip[hash] = true
+	}
+
+	var selected []string
+	for i, hash := range commits {
+		if _, ok := positions[hash]; ok && (isTip[hash] || i%bitmapCommitSpacing == 0) {
+			selected = append(selected, hash)
+		}
+	}
+	return selected, nil
+}
+
+// Bits of objects reachable from commit, using bitmaps of older commits - nil when some object is not in
+// the pack
+func packClosureBits(commit string, objects []GitObject, order []int, positions map[string]int, bitmaps map[string][]bool) []bool {
+	bits := make([]bool, len(objects))
+	queue := []string{commit}
+	for len(queue) > 0 {
+		hash := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		position, ok := positions[hash]
+		if !ok {
+			return nil
+		}
+		if bits[position] {
+			continue
+		}
+		if commitBits, ok := bitmaps[hash]; ok {
+			orBits(bits, commitBits)
+			continue
+		}
+		bits[position] = true
+		object := objects[order[position]]
+		references, err := objectReferences(object.Type, object.Data)
+		if err != nil {
+			return nil
+		}
+		queue = append(queue, references...)
+	}
+	return bits
+}
@@ -0,0 +1,303 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Branches - refs/heads/<name>, remote-tracking branches are refs/remotes/<remote>/<name>. Listing marks
+// the branch HEAD is on with "*" and can be limited by commit ancestry (all filters have to pass):
+//
+//	--merged <commit>      branches whose tips are reachable from commit (merged into it)
+//	--no-merged <commit>   branches whose tips are not
+//	--contains <commit>    branches that have commit in their history
+//
+// Deleting (-d) refuses branches that are not merged into HEAD - their commits would be lost; -D deletes
+// them anyway. The branch HEAD is on is never deleted.
+
+// Create branch at start (HEAD when empty) - an existing branch is only moved with force. Branch created from a
+// remote-tracking branch tracks it (branch.autoSetupMerge - "always" for local branches too, false never),
+// track and noTrack decide instead when set.
+func createBranch(name, start string, force, track, noTrack bool, w io.Writer) error {
+	if err := CheckBranchName(name); err != nil {
+		return err
+	}
+	refName := "refs/heads/" + name
+	existing, err := resolveRef(refName)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		if !force {
+			return fmt.Errorf("a branch named '%s' already exists", name)
+		}
+		if headBranch, _, err := readHead(); err != nil {
+			return err
+		} else if headBranch == refName {
+			return fmt.Errorf("cannot force update the current branch")
+		}
+	}
+
+	if start == "" {
+		start = "HEAD"
+	}
+	commit, err := resolveCommitRevision(start)
+	if err != nil {
+		return fmt.Errorf("not a valid object name: '%s'", start)
+	}
+	if objType, _, _, err := readObjectFromHash(commit); err != nil {
+		return err
+	} else if objType != "commit" {
+		return fmt.Errorf("not a valid branch point: '%s'", start)
+	}
+	if err := updateRef(refName, commit, "branch: Created from "+start); err != nil {
+		return err
+	}
+
+	if noTrack {
+		return nil
+	}
+	startRef, _, _ := resolveRevision(start)
+	if !track {
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		switch setup, _ := config.Get("branch.autoSetupMerge"); strings.ToLower(setup) {
+		case "always":
+			track = strings.HasPrefix(startRef, "refs/heads/") || strings.HasPrefix(startRef, "refs/remotes/")
+		case "", "true", "inherit":
+			track = strings.HasPrefix(startRef, "refs/remotes/")
+		}
+	}
+	if !track {
+		return nil
+	}
+	return setUpstream(name, start, w)
+}
+
+// Delete branches, writing the commit each was at - without force, only branches merged into HEAD
+func deleteBranches(names []string, force bool, w io.Writer) error {
+	headBranch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		refName := "refs/heads/" + name
+		hash, err := resolveRef(refName)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			return fmt.Errorf("branch '%s' not found", name)
+		}
+		if refName == headBranch {
+			workTree, _ := filepath.Abs(workTreePath())
+			return fmt.Errorf("cannot delete branch '%s' checked out at '%s'", name, workTree)
+		}
+		if !force {
+			merged := false
+			if head != "" {
+				if merged, err = isAncestor(hash, head); err != nil {
+					return err
+				}
+			}
+			if !merged {
+				return fmt.Errorf("the branch '%s' is not fully merged - if you are sure you want to delete it, run 'git branch -D %s'", name, name)
+			}
+		}
+
+		if err := deleteRef(refName); err != nil {
+			return err
+		}
+		if err := writeReflog(refName, nil); err != nil {
+			return err
+		}
+		// Upstream and other settings of the branch go with it
+		if err := removeConfigSection(gitDirPath("config"), "branch."+name); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Deleted branch %s (was %s).\n", name, shortHash(hash))
+	}
+	return nil
+}
+
+// Write branches selected by options - local ones, remote-tracking ones (options.Remotes) or both
+// (options.All), sorted by name. Verbose listing adds the commit, tracking state and subject.
+func listBranches(options BranchOptions, output io.Writer) error {
+	filter, err := newBranchFilter(options)
+	if err != nil {
+		return err
+	}
+	var colors map[string]string
+	if color, err := useColor("branch", options.Color); err != nil {
+		return err
+	} else if color {
+		if colors, err = loadColors("branch"); err != nil {
+			return err
+		}
+	}
+	headBranch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+
+	var entries []branchListEntry
+	// Detached HEAD is listed before the branches
+	if headBranch == "" && head != "" && !options.Remotes {
+		if ok, err := filter.includes(head); err != nil {
+			return err
+		} else if ok {
+			entries = append(entries, branchListEntry{current: true, name: "(HEAD detached at " + shortHash(head) + ")", slot: "current", hash: head})
+		}
+	}
+	var prefixes []string
+	if !options.Remotes {
+		prefixes = append(prefixes, "refs/heads/")
+	}
+	if options.Remotes || options.All {
+		prefixes = append(prefixes, "refs/remotes/")
+	}
+	for _, prefix := range prefixes {
+		refs, err := listRefs(prefix)
+		if err != nil {
+			return err
+		}
+		for _, refName := range sortedKeys(refs) {
+			if ok, err := filter.includes(refs[refName]); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			entry := branchListEntry{name: strings.TrimPrefix(refName, prefix), refName: refName, slot: "local", hash: refs[refName]}
+			switch {
+			case refName == headBranch:
+				entry.current, entry.slot = true, "current"
+			case prefix == "refs/remotes/":
+				entry.slot = "remote"
+				if options.All {
+					entry.name = "remotes/" + entry.name
+				}
+				// Symbolic remote ref (refs/remotes/<remote>/HEAD) shows where it points
+				if target := readSymbolicRef(refName); target != "" {
+					entry.target = strings.TrimPrefix(target, "refs/remotes/")
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	width := 0
+	for _, entry := range entries {
+		width = max(width, len(entry.name))
+	}
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+	for _, entry := range entries {
+		marker := "  "
+		if entry.current {
+			marker = "* "
+		}
+		// Verbose listing aligns what follows the names
+		name := entry.name
+		if options.Verbose > 0 {
+			name += strings.Repeat(" ", width-len(entry.name))
+		}
+		details := ""
+		switch {
+		case entry.target != "":
+			details = " -> " + entry.target
+		case options.Verbose > 0:
+			if details, err = branchDetails(entry, options.Verbose); err != nil {
+				return err
+			}
+			details = " " + details
+		}
+		fmt.Fprintf(writer, "%s%s%s\n", marker, colorize(colors, entry.slot, name), details)
+	}
+	return nil
+}
+
+// Commit, tracking state ([<upstream>: ahead A, behind B] - upstream only named when verbose > 1) and subject
+// of listed branch
+func branchDetails(entry branchListEntry, verbose int) (string, error) {
+	commit, err := readCommit(entry.hash)
+	if err != nil {
+		return "", err
+	}
+	subject, _ := splitCommitMessage(commit.Message)
+	details := shortHash(entry.hash) + " "
+	if strings.HasPrefix(entry.refName, "refs/heads/") {
+		tracking, err := branchTracking(entry.refName)
+		if err != nil {
+			return "", err
+		}
+		if tracking != nil {
+			summary := tracking.summary()
+			switch {
+			case verbose > 1 && summary != "":
+				details += "[" + tracking.Upstream + ": " + summary + "] "
+			case verbose > 1:
+				details += "[" + tracking.Upstream + "] "
+			case summary != "":
+				details += "[" + summary + "] "
+			}
+		}
+	}
+	return details + subject, nil
+}
+
+// Target of symbolic ref - "" when ref is not symbolic
+func readSymbolicRef(refName string) string {
+	data, err := os.ReadFile(gitDirPath(filepath.FromSlash(refName)))
+	if err != nil {
+		return ""
+	}
+	if target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: "); ok {
+		return target
+	}
+	return ""
+}
+
+// Resolve commits of --merged, --no-merged and --contains
+func newBranchFilter(options BranchOptions) (*branchFilter, error) {
+	filter := &branchFilter{}
+	for _, option := range []struct {
+		name   string
+		target *string
+	}{{options.Merged, &filter.merged}, {options.NoMerged, &filter.noMerged}, {options.Contains, &filter.contains}} {
+		if option.name == "" {
+			continue
+		}
+		commit, err := resolveCommitRevision(option.name)
+		if err != nil {
+			return nil, fmt.Errorf("malformed object name %s", option.name)
+		}
+		*option.target = commit
+	}
+	return filter, nil
+}
+
+// Check whether branch at commit passes the filter
+func (filter *branchFilter) includes(commit string) (bool, error) {
+	if filter.merged != "" {
+		if ok, err := isAncestor(commit, filter.merged); err != nil || !ok {
+			return false, err
+		}
+	}
+	if filter.noMerged != "" {
+		if ok, err := isAncestor(commit, filter.noMerged); err != nil || ok {
+			return false, err
+		}
+	}
+	if filter.contains != "" {
+		if ok, err := isAncestor(filter.contains, commit); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
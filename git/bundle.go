@@ -0,0 +1,331 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Bundle - refs and objects of a repository in a single file, so they can be moved without a server:
+//
+//	# v2 git bundle
+//	-<hash> <subject>     - prerequisite (commit the receiver must already have), zero or more
+//	<hash> <ref name>     - ref, one or more
+//	                      - empty line
+//	PACK...               - pack with every object reachable from refs but not from prerequisites
+//
+// Version 3 adds "@key=value" capability lines after the signature (only object-format=sha1 is supported).
+// Clone reads bundle through BundleTransport - it looks like a server that always sends the whole pack.
+
+const bundleSignatureV2 = "# v2 git bundle\n"
+const bundleSignatureV3 = "# v3 git bundle\n"
+
+// Write bundle with refs named by revs - "^rev" and "a..b" exclude history already known to the receiver,
+// --all takes every ref and HEAD
+func createBundle(file string, revs []string) error {
+	defer ignoreReplaceRefs()()
+	var refs []PackedRef
+	var includeHashes, excludeHashes []string
+	for _, rev := range revs {
+		switch {
+		case rev == "--all":
+			all, err := listRefs("refs/")
+			if err != nil {
+				return err
+			}
+			if _, headHash, err := readHead(); err == nil && headHash != "" {
+				refs = append(refs, PackedRef{Name: "HEAD", Hash: headHash})
+			}
+			for _, name := range sortedKeys(all) {
+				refs = append(refs, PackedRef{Name: name, Hash: all[name]})
+			}
+		case strings.HasPrefix(rev, "^"):
+			_, hash, err := resolveRevision(rev[1:])
+			if err != nil {
+				return err
+			}
+			excludeHashes = append(excludeHashes, hash)
+		case strings.Contains(rev, ".."):
+			from, to, _ := strings.Cut(rev, "..")
+			_, fromHash, err := resolveRevision(from)
+			if err != nil {
+				return err
+			}
+			excludeHashes = append(excludeHashes, fromHash)
+			rev = to
+			fallthrough
+		default:
+			refName, hash, err := resolveRevision(rev)
+			if err != nil {
+				return err
+			}
+			if refName == "" {
+				// Bare hashes are walked, but receiver can't know what to call them
+				includeHashes = append(includeHashes, hash)
+				continue
+			}
+			refs = append(refs, PackedRef{Name: refName, Hash: hash})
+		}
+	}
+
+	if len(refs) == 0 {
+		return fmt.Errorf("refusing to create empty bundle")
+	}
+	for _, ref := range refs {
+		includeHashes = append(includeHashes, ref.Hash)
+	}
+
+	objects, prerequisites, err := collectBundleObjects(includeHashes, excludeHashes)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(bundleSignatureV2)
+	for _, hash := range prerequisites {
+		fmt.Fprintf(&buf, "-%s %s\n", hash, commitSubject(hash))
+	}
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if seen[ref.Name] {
+			continue
+		}
+		seen[ref.Name] = true
+		fmt.Fprintf(&buf, "%s %s\n", ref.Hash, ref.Name)
+	}
+	buf.WriteString("\n")
+	buf.Write(buildPackData(objects, loadPackCompression(true)))
+
+	// Write to temporary file first, so a failed write doesn't leave a broken bundle behind
+	tmpPath := file + ".lock"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return os.Rename(tmpPath, file)
+}
+
+// Walk objects reachable from include hashes but not from exclude hashes - returns objects and
+// prerequisites (excluded commits that are parents of included ones)
+func collectBundleObjects(includeHashes, excludeHashes []string) ([]GitObject, []string, error) {
+	excluded := make(map[string]bool)
+	excludedCommits := make(map[string]bool)
+	err := walkObjects(excludeHashes, excluded, func(hash string, objType ObjectType, content []byte) {
+		if objType == OBJ_COMMIT {
+			excludedCommits[hash] = true
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var objects []GitObject
+	boundary := make(map[string]bool)
+	err = walkObjects(includeHashes, excluded, func(hash string, objType ObjectType, content []byte) {
+		objects = append(objects, GitObject{Type: objType, Data: content, Hash: hash})
+		if objType != OBJ_COMMIT {
+			return
+		}
+		parents, _ := objectReferences(objType, content)
+		for _, parent := range parents {
+			if excludedCommits[parent] {
+				boundary[parent] = true
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prerequisites := make([]string, 0, len(boundary))
+	for hash := range boundary {
+		prerequisites = append(prerequisites, hash)
+	}
+	sort.Strings(prerequisites)
+	return objects, prerequisites, nil
+}
+
+// Visit every object reachable from hashes that is not in seen (seen is updated as objects are visited)
+func walkObjects(hashes []string, seen map[string]bool, visit func(hash string, objType ObjectType, content []byte)) error {
+	queue := append([]string(nil), hashes...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		typeName, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			return fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		objType, err := ObjectTypeFromString(typeName)
+		if err != nil {
+			return err
+		}
+		visit(hash, objType, content)
+
+		references, err := objectReferences(objType, content)
+		if err != nil {
+			return fmt.Errorf("failed to parse object %s: %w", hash, err)
+		}
+		queue = append(queue, references...)
+	}
+	return nil
+}
+
+// First line of commit message - used as prerequisite comment
+func commitSubject(commitHash string) string {
+	_, _, content, err := readObjectFromHash(commitHash)
+	if err != nil {
+		return ""
+	}
+	_, message, _ := strings.Cut(string(content), "\n\n")
+	subject, _, _ := strings.Cut(message, "\n")
+	return subject
+}
+
+// Check whether file starts with bundle signature
+func isBundleFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	signature := make([]byte, len(bundleSignatureV2))
+	if _, err := io.ReadFull(file, signature); err != nil {
+		return false
+	}
+	return string(signature) == bundleSignatureV2 || string(signature) == bundleSignatureV3
+}
+
+// Parse bundle file - header lines up to the empty line, the rest is pack
+func readBundle(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	bundle := &Bundle{}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	signature, err := reader.ReadString('\n')
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("'%s' does not look like a bundle file", path)
+	case signature == bundleSignatureV2:
+		bundle.Version = 2
+	case signature == bundleSignatureV3:
+		bundle.Version = 3
+	default:
+		return nil, fmt.Errorf("'%s' does not look like a bundle file", path)
+	}
+
+	headerLen := len(signature)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("bundle header is not terminated")
+		}
+		headerLen += len(line)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		switch line[0] {
+		case '@':
+			if bundle.Version < 3 {
+				return nil, fmt.Errorf("capability in v2 bundle: %s", line)
+			}
+			if key, value, _ := strings.Cut(line[1:], "="); key == "object-format" && value != "sha1" {
+				return nil, fmt.Errorf("unsupported bundle object format: %s", value)
+			}
+		case '-':
+			hash, _, _ := strings.Cut(line[1:], " ")
+			bundle.Prerequisites = append(bundle.Prerequisites, hash)
+		default:
+			hash, name, ok := strings.Cut(line, " ")
+			if !ok || len(hash) != 40 {
+				return nil, fmt.Errorf("malformed bundle ref line: %s", line)
+			}
+			bundle.Refs = append(bundle.Refs, PackedRef{Name: name, Hash: hash})
+		}
+	}
+
+	bundle.Pack = data[headerLen:]
+	return bundle, nil
+}
+
+// Read bundle and check prerequisites are in the local repository - returns refs advertisement built from
+// bundle refs (HEAD first, pointing to the branch with the same hash)
+func (transport *BundleTransport) Connect(ctx context.Context, service string) ([]byte, error) {
+	if service != "git-upload-pack" {
+		return nil, fmt.Errorf("%s is not supported for bundles", service)
+	}
+	bundle, err := readBundle(transport.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range bundle.Prerequisites {
+		if exists, err := objectExists(hash); err != nil || !exists {
+			return nil, fmt.Errorf("repository lacks prerequisite commit %s", hash)
+		}
+	}
+	transport.bundle = bundle
+
+	refs := make(map[string]string)
+	for _, ref := range bundle.Refs {
+		refs[ref.Name] = ref.Hash
+	}
+
+	var buf bytes.Buffer
+	capabilities := "\x00"
+	if headHash, ok := refs["HEAD"]; ok {
+		if branch := guessDefaultBranch(refs, headHash); branch != "" {
+			capabilities += "symref=HEAD:refs/heads/" + branch
+		}
+		writePktLine(&buf, fmt.Sprintf("%s HEAD%s\n", headHash, capabilities))
+		capabilities = ""
+	}
+	for _, ref := range bundle.Refs {
+		if ref.Name == "HEAD" {
+			continue
+		}
+		writePktLine(&buf, fmt.Sprintf("%s %s%s\n", ref.Hash, ref.Name, capabilities))
+		capabilities = ""
+	}
+	buf.WriteString("0000")
+
+	return buf.Bytes(), nil
+}
+
+// Answer upload-pack request with the bundle pack - it has everything bundle refs need, so wants don't matter
+func (transport *BundleTransport) Request(ctx context.Context, service string, request []byte, protocolVersion int) (io.ReadCloser, error) {
+	if transport.bundle == nil {
+		return nil, fmt.Errorf("bundle is not read yet")
+	}
+	for offset := 0; offset < len(request); {
+		payload, kind, next, err := readPktLine(request, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		if fields := strings.Fields(string(payload)); kind == PKT_DATA && len(fields) > 0 && (fields[0] == "deepen" || fields[0] == "filter") {
+			return nil, fmt.Errorf("%s is not supported for bundles", fields[0])
+		}
+	}
+
+	response := append([]byte("0008NAK\n"), transport.bundle.Pack...)
+	return io.NopCloser(bytes.NewReader(response)), nil
+}
+
+func (transport *BundleTransport) Close() error {
+	transport.bundle = nil
+	return nil
+}
@@ -0,0 +1,208 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Moving index and work tree from one set of files to another (merge, and everything that checks out
+// commits) - only paths that differ between the two are touched, so unrelated local changes survive.
+//
+// Before anything is written, every touched path is checked: a file with staged or unstaged changes, or
+// an untracked file where a new file has to go, would be lost - the operation is refused and nothing
+// changes, like git does it.
+
+// Move work tree from oldFiles to workFiles and index to indexFiles (path -> entry, as flattenTree builds
+// them) - they are the same except where a file is checked out with content the index doesn't have (e.g.
+// conflict markers). operation names the command in the error about local changes.
+func checkoutFiles(oldFiles, workFiles, indexFiles map[string]TreeEntry, operation string) error {
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(unmergedPaths(entries)) > 0 {
+		return fmt.Errorf("you need to resolve your current index first")
+	}
+	index := make(map[string]IndexEntry, len(entries))
+	for _, entry := range entries {
+		index[entry.Path] = entry
+	}
+	converter, err := newEolConverter()
+	if err != nil {
+		return err
+	}
+
+	touched := changedPaths(oldFiles, workFiles)
+	if err := checkLocalChanges(touched, oldFiles, index, converter, operation); err != nil {
+		return err
+	}
+
+	// Files are removed first - a directory may have to take the place of a removed file
+	for _, filePath := range touched {
+		if _, ok := workFiles[filePath]; ok {
+			continue
+		}
+		if err := os.Remove(workTreePath(filepath.FromSlash(filePath))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", filePath, err)
+		}
+		removeEmptyParentDirs(filePath)
+	}
+	sparse, err := loadSparseCheckout()
+	if err != nil {
+		return err
+	}
+	for _, filePath := range touched {
+		entry, ok := workFiles[filePath]
+		if !ok || !sparse.includes(filePath) {
+			continue
+		}
+		// Directory in the way of a file is empty by now (its files were removed above) unless it is untracked
+		if info, err := os.Lstat(workTreePath(filepath.FromSlash(filePath))); err == nil && info.IsDir() {
+			if err := os.Remove(workTreePath(filepath.FromSlash(filePath))); err != nil {
+				return fmt.Errorf("cannot create %s: directory is in the way", filePath)
+			}
+		}
+		if entry.Mode == "160000" {
+			if err := createWorkTreeDir(filePath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := renderBlob(entry, filePath, converter); err != nil {
+			return fmt.Errorf("failed to check out %s: %w", filePath, err)
+		}
+	}
+
+	// Index entries that don't change keep their stat data
+	for _, filePath := range changedPaths(oldFiles, indexFiles) {
+		entry, ok := indexFiles[filePath]
+		if !ok {
+			delete(index, filePath)
+			continue
+		}
+		indexEntry, err := indexEntryFromTree(filePath, entry)
+		if err != nil {
+			return err
+		}
+		// Stat data is only recorded when the work tree file has the content of the entry
+		if workEntry, ok := workFiles[filePath]; ok && sameTreeEntry(workEntry, entry) {
+			if sparse.includes(filePath) {
+				indexEntry.Stat = workTreeFileStat(filePath)
+			} else {
+				indexEntry.SkipWorktree = true
+			}
+		}
+		index[filePath] = indexEntry
+	}
+
+	newEntries := make([]IndexEntry, 0, len(index))
+	for _, entry := range index {
+		newEntries = append(newEntries, entry)
+	}
+	if err := writeGitIndex(newEntries); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// Paths whose entries differ between two sets of files, sorted
+func changedPaths(oldFiles, newFiles map[string]TreeEntry) []string {
+	changed := make(map[string]bool)
+	for filePath, entry := range oldFiles {
+		if newEntry, ok := newFiles[filePath]; !ok || !sameTreeEntry(entry, newEntry) {
+			changed[filePath] = true
+		}
+	}
+	for filePath := range newFiles {
+		if _, ok := oldFiles[filePath]; !ok {
+			changed[filePath] = true
+		}
+	}
+	return sortedKeys(changed)
+}
+
+// Same blob with the same mode
+func sameTreeEntry(a, b TreeEntry) bool {
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// Index entry (without stat data) for tree entry
+func indexEntryFromTree(filePath string, entry TreeEntry) (IndexEntry, error) {
+	mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("bad mode %s for %s", entry.Mode, filePath)
+	}
+	hash, err := hex.DecodeString(entry.Hash)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+	return IndexEntry{Path: filePath, Hash: hash, Mode: uint32(mode)}, nil
+}
+
+// Refuse to touch paths whose content would be lost - staged changes (index differs from oldFiles),
+// unstaged changes (work tree differs from index) and untracked files where a file has to be written
+func checkLocalChanges(paths []string, oldFiles map[string]TreeEntry, index map[string]IndexEntry, converter *EolConverter, operation string) error {
+	var changed, untracked []string
+	for _, filePath := range paths {
+		old, inOld := oldFiles[filePath]
+		entry, inIndex := index[filePath]
+		switch {
+		case !inIndex && !inOld:
+			if _, err := os.Lstat(workTreePath(filepath.FromSlash(filePath))); err == nil {
+				untracked = append(untracked, filePath)
+			}
+			continue
+		case !inIndex || !inOld || hex.EncodeToString(entry.Hash) != old.Hash || fmt.Sprintf("%06o", entry.Mode) != old.Mode:
+			changed = append(changed, filePath)
+			continue
+		}
+		if entry.SkipWorktree || entry.Mode == 0160000 {
+			continue
+		}
+		modified, err := workTreeFileModified(entry, converter)
+		if err != nil {
+			return err
+		}
+		if modified {
+			changed = append(changed, filePath)
+		}
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf("Your local changes to the following files would be overwritten by %s:\n\t%s\n"+
+			"Please commit your changes or stash them before you %s.", operation, strings.Join(changed, "\n\t"), operation)
+	}
+	if len(untracked) > 0 {
+		return fmt.Errorf("The following untracked working tree files would be overwritten by %s:\n\t%s\n"+
+			"Please move or remove them before you %s.", operation, strings.Join(untracked, "\n\t"), operation)
+	}
+	return nil
+}
+
+// Check whether work tree file differs from its index entry - stat data first, content only when it changed
+func workTreeFileModified(entry IndexEntry, converter *EolConverter) (bool, error) {
+	info, err := os.Lstat(workTreePath(filepath.FromSlash(entry.Path)))
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	indexModTime := info.ModTime()
+	if state, ok := indexStateCache[indexFilePath()]; ok {
+		indexModTime = state.ModTime
+	}
+	if statUnchanged(entry, indexStatFromFileInfo(info), indexModTime) {
+		return false, nil
+	}
+
+	hash, mode, err := hashWorkTreeFile(entry.Path, converter)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(hash, entry.Hash) || indexFileMode(mode, entry.Mode) != entry.Mode, nil
+}
@@ -0,0 +1,510 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Clone helpers - choosing what to fetch and setting up refs, HEAD, config and index after the pack is written
+
+// Clone remote repository into options.Directory - returns the new repository (its work tree is the
+// directory, or the directory is the git directory of a bare clone). Progress is written to w.
+func (c *RepoContext) cloneRepository(ctx context.Context, options CloneOptions, w io.Writer) (*Repository, error) {
+	directoryName := options.Directory
+	// Mirror is a bare clone that keeps all refs
+	options.Bare = options.Bare || options.Mirror
+
+	remoteUrl, alternateDirs, err := c.resolveCloneSources(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve alternates: %w", err)
+	}
+
+	// Create a directory (with name that was provided) - all the other files are created in it
+	dir := c.path(directoryName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s directory: %w", directoryName, err)
+	}
+	repo := &Repository{layout: c.initRepoLayout(dir, options.Bare), dir: dir, context: c}
+	defer repo.enter()()
+	// Initialize repository inside newly created directory (the directory itself is the git directory for --bare)
+	if err := initRepo(InitOptions{Bare: options.Bare}); err != nil {
+		return nil, fmt.Errorf("failed to initialize repository: %w", err)
+	}
+
+	// Borrowed objects are reached through objects/info/alternates instead of being copied
+	for _, dir := range alternateDirs {
+		if err := addAlternateObjectDir(dir); err != nil {
+			return nil, fmt.Errorf("failed to write alternates: %w", err)
+		}
+	}
+
+	fmt.Fprintf(w, "Cloning from %s into %s\n", remoteUrl, directoryName)
+
+	// Send GET req to github to fetch refs (file formated as pkt-line - contains all refs that remote repository (GitHub) knows)
+	// Protocol v2 servers answer with capabilities only - refs are then listed with ls-refs command
+	transport, err := newTransport(remoteUrl, options.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to remote: %w", err)
+	}
+	defer transport.Close()
+
+	remoteRefs, hashHead, headBranch, protocolV2, err := discoverRemoteRefs(ctx, transport, cloneRefPrefixes(options))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch refs: %w", err)
+	}
+	fmt.Fprintf(w, "HEAD sha1 hash: %s\n", hashHead)
+
+	// Pick refs to fetch - every branch and tag by default, or only the one provided with --branch/--tag
+	selectedRefs, checkoutBranch, checkoutHash, err := selectCloneRefs(remoteRefs, options.Branch, options.Tag, headBranch, hashHead)
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects already reachable through alternates don't have to be fetched
+	wants := collectWants(selectedRefs, options.Mirror)
+	if len(alternateDirs) > 0 {
+		if wants, err = filterMissingObjects(wants); err != nil {
+			return nil, fmt.Errorf("failed to check alternates: %w", err)
+		}
+	}
+
+	// git-upload-pack REQUEST
+	if len(wants) > 0 {
+		// following GitHub Smart HTTP protocol make want-have request, and get .pack file (and shallow commits) back
+		packData, shallow, unshallow, err := fetchClonePack(ctx, transport, protocolV2, wants, options.Depth, options.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("git-upload-pack request failed: %w", err)
+		}
+
+		// Parse pack file (extract objects - blob, trees, commits, deltified)
+		objects, err := parsePackFile(ctx, packData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse packfile: %w", err)
+		}
+		fmt.Fprintf(w, "Successfully read %d objects:\n", len(objects))
+
+		// Write all objects to .git/objects
+		if err := writePackObjects(ctx, objects); err != nil {
+			return nil, fmt.Errorf("failed to write objects: %w", err)
+		}
+		fmt.Fprintf(w, "Successfully wrote %d objects:\n", len(objects))
+
+		// Shallow clone - remember commits whose parents were not sent
+		if options.Depth > 0 {
+			if err := updateShallowFile(shallow, unshallow); err != nil {
+				return nil, fmt.Errorf("failed to write shallow file: %w", err)
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "All objects are available through alternates\n")
+	}
+
+	// Partial clone - remember where filtered out objects can be fetched from
+	if options.Filter != "" {
+		if err := setupPartialClone("origin", options.Filter); err != nil {
+			return nil, fmt.Errorf("failed to write partial clone config: %w", err)
+		}
+	}
+
+	// Remote without peeled tag values (dumb HTTP, bundle) - annotated tag is peeled now that objects are here
+	if peeled, err := peelTag(checkoutHash); err == nil && peeled != "" {
+		checkoutHash = peeled
+	}
+
+	// Create remote tracking refs, local branch, HEAD and origin remote config
+	if err := setupCloneRefs(selectedRefs, checkoutBranch, "origin", remoteUrl, options.Bare, options.Mirror); err != nil {
+		return nil, fmt.Errorf("failed to write refs: %w", err)
+	}
+	if checkoutBranch == "" && checkoutHash != "" {
+		// Cloned tag - HEAD is detached at the tagged commit
+		if err := updateRef("HEAD", checkoutHash, "clone: from "+remoteUrl); err != nil {
+			return nil, fmt.Errorf("failed to write HEAD: %w", err)
+		}
+	}
+
+	// Bare clone has no work tree to check out
+	if options.Bare {
+		fmt.Fprintf(w, "Successfully cloned repository:\n")
+		return repo, nil
+	}
+
+	// With --sparse only files in the root directory are checked out
+	if options.Sparse {
+		if err := enableSparseCheckout(true, ""); err != nil {
+			return nil, fmt.Errorf("failed to initialize sparse checkout: %w", err)
+		}
+	}
+
+	// Fetch blobs needed for checkout in one request, instead of one by one while rendering
+	if options.Filter != "" && checkoutHash != "" {
+		if err := fetchMissingBlobs(ctx, checkoutHash); err != nil {
+			return nil, fmt.Errorf("failed to fetch missing blobs: %w", err)
+		}
+	}
+
+	if err := renderFilesFromCommit(ctx, checkoutHash); err != nil {
+		return nil, fmt.Errorf("failed to render object files: %w", err)
+	}
+
+	// Index has to match the checked out files
+	if err := writeIndexFromCommit(checkoutHash); err != nil {
+		return nil, fmt.Errorf("failed to write index: %w", err)
+	}
+	// New repository has no hooks of its own - only core.hooksPath from global config can provide one
+	runHook("post-checkout", nil, zeroHash, checkoutHash, "1")
+
+	fmt.Fprintf(w, "Successfully cloned repository:\n")
+	return repo, nil
+}
+
+// Every distinct commit/tag hash that the remote advertises for branches and tags, or for all refs (mirror) -
+// peeled ^{} entries are skipped
+func collectWants(refs map[string]string, all bool) []string {
+	seen := make(map[string]bool)
+	var wants []string
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name != "HEAD" && !all && !strings.HasPrefix(name, "refs/heads/") && !strings.HasPrefix(name, "refs/tags/") {
+			continue
+		}
+		if strings.HasSuffix(name, "^{}") || seen[refs[name]] {
+			continue
+		}
+		seen[refs[name]] = true
+		wants = append(wants, refs[name])
+	}
+
+	return wants
+}
+
+// Guess which branch remote HEAD points to - branch with the same hash as HEAD (main/master preferred)
+func guessDefaultBranch(refs map[string]string, headHash string) string {
+	var candidates []string
+	for name, hash := range refs {
+		if strings.HasPrefix(name, "refs/heads/") && hash == headHash {
+			candidates = append(candidates, strings.TrimPrefix(name, "refs/heads/"))
+		}
+	}
+	sort.Strings(candidates)
+
+	for _, preferred := range []string{"main", "master"} {
+		for _, candidate := range candidates {
+			if candidate == preferred {
+				return candidate
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	return ""
+}
+
+// Write refs after clone:
+//   - refs/remotes/<remote>/<branch> for every remote branch, and tags (as packed-refs, like git clone does)
+//   - refs/remotes/<remote>/HEAD pointing to default branch
+//   - local refs/heads/<default branch> and HEAD pointing to it
+//   - [remote "<remote>"] section in .git/config
+//
+// Bare clone copies remote branches as they are (refs/heads/*), and remote has no fetch refspec. Mirror
+// clone copies every ref as it is and fetches them all again with +refs/*:refs/*.
+func setupCloneRefs(refs map[string]string, defaultBranch, remoteName, remoteUrl string, bare, mirror bool) error {
+	var packed []PackedRef
+	if mirror {
+		mappings, err := mapMirrorRefs(refs)
+		if err != nil {
+			return err
+		}
+		for _, mapping := range mappings {
+			packed = append(packed, PackedRef{Name: mapping.Destination, Hash: mapping.Hash, Peeled: refs[mapping.Source+"^{}"]})
+		}
+	}
+	for name, hash := range refs {
+		switch {
+		case mirror || strings.HasSuffix(name, "^{}") || !strings.HasPrefix(name, "refs/"):
+			continue
+		case strings.HasPrefix(name, "refs/heads/") && bare:
+			packed = append(packed, PackedRef{Name: name, Hash: hash})
+		case strings.HasPrefix(name, "refs/heads/"):
+			branch := strings.TrimPrefix(name, "refs/heads/")
+			packed = append(packed, PackedRef{Name: "refs/remotes/" + remoteName + "/" + branch, Hash: hash})
+		case strings.HasPrefix(name, "refs/tags/"):
+			packed = append(packed, PackedRef{Name: name, Hash: hash, Peeled: refs[name+"^{}"]})
+		}
+	}
+	if err := writePackedRefs(packed); err != nil {
+		return err
+	}
+
+	configPath := gitDirPath("config")
+	if err := setConfigValue(configPath, "remote."+remoteName+".url", remoteUrl); err != nil {
+		return fmt.Errorf("failed to write remote config: %w", err)
+	}
+	if mirror {
+		if err := setConfigValue(configPath, "remote."+remoteName+".fetch", mirrorRefspec); err != nil {
+			return fmt.Errorf("failed to write remote config: %w", err)
+		}
+		if err := setConfigValue(configPath, "remote."+remoteName+".mirror", "true"); err != nil {
+			return fmt.Errorf("failed to write remote config: %w", err)
+		}
+	}
+	if bare {
+		if defaultBranch == "" {
+			return nil
+		}
+		return writeSymbolicRef("HEAD", "refs/heads/"+defaultBranch)
+	}
+	if err := setConfigValue(configPath, "remote."+remoteName+".fetch", "+refs/heads/*:refs/remotes/"+remoteName+"/*"); err != nil {
+		return fmt.Errorf("failed to write remote config: %w", err)
+	}
+
+	if defaultBranch == "" {
+		// Empty repository, or HEAD is detached on the remote - HEAD stays as it is
+		return nil
+	}
+
+	if err := writeSymbolicRef("refs/remotes/"+remoteName+"/HEAD", "refs/remotes/"+remoteName+"/"+defaultBranch); err != nil {
+		return err
+	}
+	if err := updateRef("refs/heads/"+defaultBranch, refs["refs/heads/"+defaultBranch], "clone: from "+remoteUrl); err != nil {
+		return err
+	}
+	if err := writeUpstreamConfig(defaultBranch, remoteName, "refs/heads/"+defaultBranch); err != nil {
+		return err
+	}
+	return writeSymbolicRef("HEAD", "refs/heads/"+defaultBranch)
+}
+
+// Fill index with every file from commit tree, so a fresh checkout reports a clean status
+func writeIndexFromCommit(commitHash string) error {
+	defer tracePerformance("write index")()
+	treeHash, err := readCommitTreeHash(commitHash)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]TreeEntry)
+	if err := flattenTree(treeHash, "", files); err != nil {
+		return err
+	}
+
+	sparse, err := loadSparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]IndexEntry, 0, len(files))
+	for filePath, entry := range files {
+		mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("bad mode %s for %s", entry.Mode, filePath)
+		}
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return err
+		}
+		// Files were just checked out, so their stat data describes the committed content - files outside
+		// of sparse checkout patterns are not in the work tree
+		if !sparse.includes(filePath) {
+			entries = append(entries, IndexEntry{Path: filePath, Hash: hash, Mode: uint32(mode), SkipWorktree: true})
+			continue
+		}
+		entries = append(entries, IndexEntry{Path: filePath, Hash: hash, Mode: uint32(mode), Stat: workTreeFileStat(filePath)})
+	}
+
+	return writeGitIndex(entries)
+}
+
+// Decide which remote refs are fetched and what is checked out:
+//   - no branch/tag -> every ref, checkout of the branch remote HEAD points to
+//   - branch        -> only that branch (falls back to a tag with the same name, like git)
+//   - tag           -> only that tag, HEAD is detached at the tagged commit
+//
+// Returns selected refs, branch to create locally (empty for detached HEAD) and commit to check out
+func selectCloneRefs(remoteRefs map[string]string, branch, tag, headBranch, headHash string) (map[string]string, string, string, error) {
+	if branch == "" && tag == "" {
+		// Default branch is the one remote HEAD points to - guessed from hashes if server doesn't tell us
+		defaultBranch := headBranch
+		if defaultBranch == "" {
+			defaultBranch = guessDefaultBranch(remoteRefs, headHash)
+		}
+		return remoteRefs, defaultBranch, headHash, nil
+	}
+
+	if branch != "" {
+		if hash, ok := remoteRefs["refs/heads/"+branch]; ok {
+			return map[string]string{"refs/heads/" + branch: hash}, branch, hash, nil
+		}
+		tag = branch
+	}
+
+	tagRef := "refs/tags/" + tag
+	hash, ok := remoteRefs[tagRef]
+	if !ok {
+		if branch != "" {
+			return nil, "", "", fmt.Errorf("remote branch %s not found in upstream origin", branch)
+		}
+		return nil, "", "", fmt.Errorf("remote tag %s not found in upstream origin", tag)
+	}
+
+	selected := map[string]string{tagRef: hash}
+	commitHash := hash
+	// Annotated tag - checkout the commit it points to
+	if peeled, ok := remoteRefs[tagRef+"^{}"]; ok {
+		selected[tagRef+"^{}"] = peeled
+		commitHash = peeled
+	}
+	return selected, "", commitHash, nil
+}
+
+// Ref prefixes clone is interested in - protocol v2 server lists only refs matching them
+func cloneRefPrefixes(options CloneOptions) []string {
+	switch {
+	case options.Mirror:
+		return []string{"HEAD", "refs/"}
+	case options.Branch != "":
+		return []string{"HEAD", "refs/heads/" + options.Branch, "refs/tags/" + options.Branch}
+	case options.Tag != "":
+		return []string{"HEAD", "refs/tags/" + options.Tag}
+	default:
+		return []string{"HEAD", "refs/heads/", "refs/tags/"}
+	}
+}
+
+// Get remote refs, HEAD hash and the branch HEAD points to - using ls-refs if server speaks protocol v2,
+// v0 refs advertisement otherwise
+func discoverRemoteRefs(ctx context.Context, transport Transport, prefixes []string) (map[string]string, string, string, bool, error) {
+	defer tracePerformance("discover refs")()
+	advertisement, err := transport.Connect(ctx, "git-upload-pack")
+	if err != nil {
+		return nil, "", "", false, err
+	}
+
+	if isProtocolV2(advertisement) {
+		refs, headHash, headBranch, err := lsRefsV2(ctx, transport, prefixes)
+		if err != nil {
+			return nil, "", "", true, err
+		}
+		refs, headBranch = dropInvalidRemoteRefs(refs, headBranch)
+		return refs, headHash, headBranch, true, nil
+	}
+
+	headHash, headBranch, err := extractHeadFromRefs(advertisement)
+	if err != nil {
+		return nil, "", "", false, fmt.Errorf("failed to extract HEAD from refs: %w", err)
+	}
+	refs, _, err := parseRefs(advertisement)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	refs, headBranch = dropInvalidRemoteRefs(refs, headBranch)
+	return refs, headHash, headBranch, false, nil
+}
+
+// Drop advertised refs whose names are not valid ref names (with their peeled entries), and HEAD's branch
+// when it is one of them - git ignores such refs too, a name like refs/heads/../../x would be written
+// outside the refs directory
+func dropInvalidRemoteRefs(refs map[string]string, headBranch string) (map[string]string, string) {
+	for name := range refs {
+		if name != "HEAD" && checkRefName(strings.TrimSuffix(name, "^{}")) != nil {
+			delete(refs, name)
+		}
+	}
+	if headBranch != "" && CheckBranchName(headBranch) != nil {
+		headBranch = ""
+	}
+	return refs, headBranch
+}
+
+// Send wants to upload-pack (v0 or v2 request) - returns pack data and shallow/unshallow commits
+func fetchClonePack(ctx context.Context, transport Transport, protocolV2 bool, wants []string, depth int, filter string) ([]byte, []string, []string, error) {
+	defer tracePerformance("fetch pack")()
+	request, protocolVersion := buildUploadPackRequest(wants, depth, filter), 0
+	if protocolV2 {
+		request, protocolVersion = buildFetchRequestV2(wants, depth, filter), 2
+	}
+
+	stream, err := transport.Request(ctx, "git-upload-pack", request, protocolVersion)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer stream.Close()
+
+	if protocolV2 {
+		return readFetchResponseV2(bufio.NewReader(stream))
+	}
+	return readUploadPackResponse(bufio.NewReader(stream), depth > 0)
+}
+
+// Object directory of local repository - <path>/.git/objects, or <path>/objects for bare repository
+func localObjectDir(repoPath string) (string, error) {
+	for _, dir := range []string{filepath.Join(repoPath, ".git", "objects"), filepath.Join(repoPath, "objects")} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return filepath.Abs(dir)
+		}
+	}
+	return "", fmt.Errorf("%w: '%s'", ErrNotARepository, repoPath)
+}
+
+// Resolve remote URL and --shared / --reference object directories in the context - before the new
+// repository becomes the active one
+func (c *RepoContext) resolveCloneSources(options CloneOptions) (string, []string, error) {
+	defer c.enter()()
+	remoteUrl := absoluteRemoteUrl(options.Url)
+	alternateDirs, err := cloneAlternateDirs(options, remoteUrl)
+	return remoteUrl, alternateDirs, err
+}
+
+// Resolve object directories clone should borrow from - source repository with --shared (has to be local),
+// reference repository with --reference (relative to the context directory)
+func cloneAlternateDirs(options CloneOptions, remoteUrl string) ([]string, error) {
+	var dirs []string
+	if options.Shared {
+		path := localRepositoryPath(remoteUrl)
+		if path == "" {
+			return nil, fmt.Errorf("--shared needs a local repository")
+		}
+		dir, err := localObjectDir(path)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	if options.Reference != "" {
+		dir, err := localObjectDir(commandDirPath(options.Reference))
+		if err != nil {
+			return nil, fmt.Errorf("reference repository: %w", err)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// Wants whose objects are not available locally (alternates included) - only those have to be fetched
+func filterMissingObjects(hashes []string) ([]string, error) {
+	var missing []string
+	for _, hash := range hashes {
+		exists, err := objectExists(hash)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, hash)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,90 @@
+package git
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Write tree object from (mode, name, hash) entries in the given order - names are not checked, so the
+// tree can be one no well-behaved git would write
+func writeTestTree(t *testing.T, entries ...TreeEntry) string {
+	t.Helper()
+	var content []byte
+	for _, entry := range entries {
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, entry.Mode+" "+entry.Name+"\x00"...)
+		content = append(content, hash...)
+	}
+	return writeTestObject(t, OBJ_TREE, content)
+}
+
+func writeTestObject(t *testing.T, objectType ObjectType, content []byte) string {
+	t.Helper()
+	hash, err := writeObjectWithType(content, objectType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(hash)
+}
+
+// Clone of a repository whose tree plants .git/hooks/pre-commit has to fail without writing the hook -
+// otherwise the next commit in the clone would run it
+func TestCloneRejectsDotGitTreeEntry(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is needed to serve the local clone")
+	}
+	t.Chdir(t.TempDir())
+
+	for _, dotGit := range []string{".git", ".GIT", ".Git"} {
+		t.Run(dotGit, func(t *testing.T) {
+			base := t.TempDir()
+			source, target := filepath.Join(base, "source"), filepath.Join(base, "target")
+			repo, err := Init(source, InitOptions{InitialBranch: "main"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plantHook(t, repo, dotGit)
+
+			_, err = Clone(context.Background(), CloneOptions{Url: source, Directory: target}, io.Discard)
+			if err == nil {
+				t.Fatal("clone of a tree with a .git entry succeeded")
+			}
+			for _, hookPath := range []string{
+				filepath.Join(target, ".git", "hooks", "pre-commit"),
+				filepath.Join(target, dotGit, "hooks", "pre-commit"),
+			} {
+				if _, err := os.Lstat(hookPath); err == nil {
+					t.Fatalf("clone wrote %s", hookPath)
+				}
+			}
+		})
+	}
+}
+
+// Commit a tree with a dotGit/hooks/pre-commit entry to main of the given repository
+func plantHook(t *testing.T, repo *Repository, dotGit string) {
+	t.Helper()
+	defer repo.enter()()
+	hook := writeTestObject(t, OBJ_BLOB, []byte("#!/bin/sh\ntouch pwned\n"))
+	hooks := writeTestTree(t, TreeEntry{Mode: "100755", Name: "pre-commit", Hash: hook})
+	gitDir := writeTestTree(t, TreeEntry{Mode: "40000", Name: "hooks", Hash: hooks})
+	readme := writeTestObject(t, OBJ_BLOB, []byte("readme\n"))
+	root := writeTestTree(t,
+		TreeEntry{Mode: "40000", Name: dotGit, Hash: gitDir},
+		TreeEntry{Mode: "100644", Name: "README", Hash: readme},
+	)
+	commit := writeTestObject(t, OBJ_COMMIT, fmt.Appendf(nil,
+		"tree %s\nauthor A <a@example.com> 0 +0000\ncommitter A <a@example.com> 0 +0000\n\nhook\n", root))
+	if err := updateRef("refs/heads/main", commit, ""); err != nil {
+		t.Fatal(err)
+	}
+}
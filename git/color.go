@@ -0,0 +1,228 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Colored output - diff, status and log color their output when --color or configuration says so:
+//
+//	--color[=<when>] / --no-color   decides for one command
+//	color.<command>                 then for all uses of the command (color.diff also covers log)
+//	color.ui                        then for everything, "auto" by default
+//
+// <when> is always, never or auto (true means auto) - auto colors only output going to a terminal.
+// Colors of output parts come from color.<command>.<slot> and are written like git's:
+//
+//	[attributes] [foreground [background]]   e.g. "bold red", "ul #ff8000 black", "reverse"
+//
+// Colors are names (normal, black, red, green, yellow, blue, magenta, cyan, white, default, with a
+// "bright" prefix), numbers 0-255 or #rgb/#rrggbb; attributes are bold, dim, italic, ul, blink, reverse
+// and strike, turned off with a "no" or "no-" prefix; reset resets everything first.
+
+const colorReset = "\033[m"
+
+// Default colors of output parts (ANSI escapes) by command and slot - "" leaves the part uncolored
+var defaultColors = map[string]map[string]string{
+	"diff": {
+		"context": "", "meta": "\033[1m", "frag": "\033[36m", "func": "", "old": "\033[31m", "new": "\033[32m",
+		"commit": "\033[33m",
+	},
+	"decorate": {
+		"branch": "\033[1;32m", "remoteBranch": "\033[1;31m", "tag": "\033[1;33m", "stash": "\033[1;35m",
+		"HEAD": "\033[1;36m",
+	},
+	"branch": {
+		"current": "\033[32m", "local": "", "remote": "\033[31m", "plain": "",
+	},
+	"status": {
+		"header": "", "added": "\033[32m", "updated": "\033[32m", "changed": "\033[31m", "untracked": "\033[31m",
+		"ignored": "\033[31m", "branch": "\033[32m", "nobranch": "\033[31m", "unmerged": "\033[31m",
+	},
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// SGR codes of attributes and of turning them off
+var colorAttributes = map[string][2]int{
+	"bold": {1, 22}, "dim": {2, 22}, "italic": {3, 23}, "ul": {4, 24}, "blink": {5, 25}, "reverse": {7, 27},
+	"strike": {9, 29},
+}
+
+// Check whether output of command is colored - flag is the --color value ("" when not given), command
+// selects color.<command>
+func useColor(command, flag string) (bool, error) {
+	when := flag
+	if when == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return false, err
+		}
+		when = "auto"
+		if value, ok := config.Get("color." + command); ok {
+			when = value
+		} else if value, ok := config.Get("color.ui"); ok {
+			when = value
+		}
+	}
+
+	switch strings.ToLower(when) {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return stdoutIsTerminal(), nil
+	}
+	if flag != "" {
+		return false, fmt.Errorf("bad --color argument: %s", flag)
+	}
+	// Boolean config values - true colors when auto would
+	return parseBoolValue(when, false) && stdoutIsTerminal(), nil
+}
+
+// Check whether standard output goes to a terminal that can show colors - output sent to a pager counts
+// (GIT_PAGER_IN_USE, as git sets for pagers it starts)
+func stdoutIsTerminal() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	if parseBoolValue(os.Getenv("GIT_PAGER_IN_USE"), false) {
+		return true
+	}
+	return isTerminal(os.Stdout)
+}
+
+// Load colors of command's output parts - defaults overridden by color.<command>.<slot>
+func loadColors(command string) (map[string]string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	colors := make(map[string]string)
+	for slot, color := range defaultColors[command] {
+		colors[slot] = color
+		if value, ok := config.Get("color." + command + "." + slot); ok {
+			if colors[slot], err = parseColor(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return colors, nil
+}
+
+// Parse color value into ANSI escape - "" when it sets nothing (e.g. "normal")
+func parseColor(value string) (string, error) {
+	invalid := fmt.Errorf("invalid color value: %s", value)
+
+	reset := false
+	codes := make(map[int]bool)
+	var colors []string
+	for _, word := range strings.Fields(strings.ToLower(value)) {
+		if word == "reset" {
+			reset = true
+			continue
+		}
+		if code, ok := parseColorAttribute(word); ok {
+			codes[code] = true
+			continue
+		}
+		color, ok := parseColorName(word, len(colors) == 1)
+		if !ok || len(colors) == 2 {
+			return "", invalid
+		}
+		colors = append(colors, color)
+	}
+
+	var parts []string
+	if reset {
+		parts = append(parts, "")
+	}
+	for _, code := range sortedIntKeys(codes) {
+		parts = append(parts, strconv.Itoa(code))
+	}
+	for _, color := range colors {
+		if color != "" {
+			parts = append(parts, color)
+		}
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "\033[" + strings.Join(parts, ";") + "m", nil
+}
+
+// SGR code of attribute word (bold, nobold, no-bold, ...)
+func parseColorAttribute(word string) (int, bool) {
+	off := 0
+	if name, ok := strings.CutPrefix(word, "no"); ok {
+		word, off = strings.TrimPrefix(name, "-"), 1
+	}
+	codes, ok := colorAttributes[word]
+	return codes[off], ok
+}
+
+// SGR parameters of color word - "" for normal; background selects background codes
+func parseColorName(word string, background bool) (string, bool) {
+	base, bright, extended := 30, 90, 38
+	if background {
+		base, bright, extended = 40, 100, 48
+	}
+
+	switch {
+	case word == "normal":
+		return "", true
+	case word == "default":
+		return strconv.Itoa(base + 9), true
+	case strings.HasPrefix(word, "#"):
+		hex := word[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 6 || err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("%d;2;%d;%d;%d", extended, rgb>>16, rgb>>8&0xff, rgb&0xff), true
+	}
+	for i, name := range colorNames {
+		if word == name {
+			return strconv.Itoa(base + i), true
+		}
+		if word == "bright"+name {
+			return strconv.Itoa(bright + i), true
+		}
+	}
+
+	// Numbers - -1 is normal, 0-7 the basic colors, the rest the 256 color palette
+	n, err := strconv.Atoi(word)
+	switch {
+	case err != nil || n < -1 || n > 255:
+		return "", false
+	case n == -1:
+		return "", true
+	case n < 8:
+		return strconv.Itoa(base + n), true
+	}
+	return fmt.Sprintf("%d;5;%d", extended, n), true
+}
+
+// Wrap text in color of slot - text stays as it is when colors is nil (output not colored)
+func colorize(colors map[string]string, slot, text string) string {
+	if colors == nil {
+		return text
+	}
+	return colors[slot] + text + colorReset
+}
+
+func sortedIntKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,346 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identity used when neither environment nor user.name/user.email config provide one
+const defaultUserName = "obradovicsl"
+const defaultUserEmail = "slobodanobradovic3@gmail.com"
+
+// Commit and tag objects - header lines ("key value", continuation lines start with space),
+// empty line, then message
+
+// Parse commit object content
+func parseCommit(content []byte) (*Commit, error) {
+	header, message, _ := strings.Cut(string(content), "\n\n")
+	commit := &Commit{Message: message}
+
+	for _, line := range strings.Split(header, "\n") {
+		if strings.HasPrefix(line, " ") && len(commit.ExtraHeaders) > 0 {
+			commit.ExtraHeaders[len(commit.ExtraHeaders)-1] += "\n" + line
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author = value
+		case "committer":
+			commit.Committer = value
+		case "encoding":
+			commit.Encoding = value
+		default:
+			commit.ExtraHeaders = append(commit.ExtraHeaders, line)
+		}
+	}
+
+	if commit.Tree == "" {
+		return nil, fmt.Errorf("commit has no tree")
+	}
+	return commit, nil
+}
+
+// Read and parse commit object - grafted commits get their grafted parents (see shallow.go)
+func readCommit(commitHash string) (*Commit, error) {
+	objType, _, content, err := readObjectFromHash(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "commit" {
+		return nil, fmt.Errorf("object %s is not a commit", commitHash)
+	}
+	commit, err := parseCommit(content)
+	if err != nil {
+		return nil, fmt.Errorf("bad commit %s: %w", commitHash, err)
+	}
+	grafts, err := loadCommitGrafts()
+	if err != nil {
+		return nil, err
+	}
+	if parents, ok := grafts[commitHash]; ok {
+		commit.Parents = append([]string(nil), parents...)
+	}
+	return commit, nil
+}
+
+// Parse annotated tag object content
+func parseTag(content []byte) (*Tag, error) {
+	header, message, _ := strings.Cut(string(content), "\n\n")
+	tag := &Tag{Message: message}
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "object":
+			tag.Object = value
+		case "type":
+			tag.Type = value
+		case "tag":
+			tag.Name = value
+		case "tagger":
+			tag.Tagger = value
+		}
+	}
+
+	if tag.Object == "" {
+		return nil, fmt.Errorf("tag has no object")
+	}
+	return tag, nil
+}
+
+// Parse signature "Name <email> timestamp timezone" (author, committer, tagger)
+func parseSignature(signature string) (string, string, time.Time, error) {
+	open := strings.IndexByte(signature, '<')
+	closing := strings.LastIndexByte(signature, '>')
+	if open == -1 || closing < open {
+		return "", "", time.Time{}, fmt.Errorf("bad signature: %s", signature)
+	}
+	name := strings.TrimSpace(signature[:open])
+	email := signature[open+1 : closing]
+
+	fields := strings.Fields(signature[closing+1:])
+	if len(fields) < 2 {
+		return name, email, time.Time{}, fmt.Errorf("bad signature date: %s", signature)
+	}
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return name, email, time.Time{}, fmt.Errorf("bad signature date: %s", signature)
+	}
+
+	// Timezone is +hhmm / -hhmm
+	zone := fields[1]
+	offset := 0
+	if len(zone) == 5 {
+		hours, errHours := strconv.Atoi(zone[1:3])
+		minutes, errMinutes := strconv.Atoi(zone[3:5])
+		if errHours == nil && errMinutes == nil {
+			offset = (hours*60 + minutes) * 60
+			if zone[0] == '-' {
+				offset = -offset
+			}
+		}
+	}
+	return name, email, time.Unix(timestamp, 0).In(time.FixedZone(zone, offset)), nil
+}
+
+// First paragraph of commit message joined into one line, and the rest of the message
+func splitCommitMessage(message string) (string, string) {
+	message = strings.TrimLeft(message, "\n")
+	subject, body, _ := strings.Cut(message, "\n\n")
+	subject = strings.Join(strings.Fields(subject), " ")
+	return subject, strings.Trim(body, "\n")
+}
+
+// Format signature line value - "Name <email> timestamp timezone"
+func formatSignature(name, email string, when time.Time) string {
+	return fmt.Sprintf("%s <%s> %d %s", name, email, when.Unix(), when.Format("-0700"))
+}
+
+// Identity for role (AUTHOR or COMMITTER) - GIT_<role>_NAME/EMAIL/DATE env first, then user.name/user.email config
+func userSignature(role string) string {
+	name, email := os.Getenv("GIT_"+role+"_NAME"), os.Getenv("GIT_"+role+"_EMAIL")
+	if config, err := loadConfig(); err == nil {
+		if value, ok := config.Get("user.name"); ok && name == "" {
+			name = value
+		}
+		if value, ok := config.Get("user.email"); ok && email == "" {
+			email = value
+		}
+	}
+	if name == "" {
+		name = defaultUserName
+	}
+	if email == "" {
+		email = defaultUserEmail
+	}
+
+	when := time.Now()
+	if date := os.Getenv("GIT_" + role + "_DATE"); date != "" {
+		if parsed, err := parseSignatureDate(date); err == nil {
+			when = parsed
+		}
+	}
+	return formatSignature(name, email, when)
+}
+
+// Parse date in git internal format ("[@]timestamp timezone") or RFC 2822 format
+func parseSignatureDate(date string) (time.Time, error) {
+	if _, _, when, err := parseSignature("x <x> " + strings.TrimPrefix(date, "@")); err == nil {
+		return when, nil
+	}
+	return mail.ParseDate(date)
+}
+
+// Commit object content - header lines, empty line, message
+func buildCommitContent(treeHash string, parents []string, author, committer, message string) []byte {
+	var content strings.Builder
+	fmt.Fprintf(&content, "tree %s\n", treeHash)
+	for _, parent := range parents {
+		fmt.Fprintf(&content, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&content, "author %s\ncommitter %s\n\n%s", author, committer, message)
+	return []byte(content.String())
+}
+
+// Commit index with pre-commit, prepare-commit-msg, commit-msg and post-commit hooks around it - returns
+// hash and the final message (hooks may change it)
+func commitWithHooks(options CommitOptions) (string, string, error) {
+	if !options.NoVerify {
+		if err := runHook("pre-commit", nil); err != nil {
+			return "", "", err
+		}
+	}
+	message, err := runCommitMessageHooks(options.Message, !options.NoVerify)
+	if err != nil {
+		return "", "", err
+	}
+	options.Message = message
+
+	hash, err := commitIndex(options)
+	if err != nil {
+		return "", "", err
+	}
+	// Commit is already done - failing post-commit hook doesn't change that
+	runHook("post-commit", nil)
+	return hash, message, nil
+}
+
+// Commit index on top of HEAD - branch HEAD points to (or detached HEAD) moves to the new commit
+// A merge in progress (MERGE_HEAD) is concluded - the merged commits are parents too
+func commitIndex(options CommitOptions) (string, error) {
+	if err := requireMergedIndex("Committing"); err != nil {
+		return "", err
+	}
+	treeHash, err := writeTreeFromIndex()
+	if err != nil {
+		return "", err
+	}
+	branch, headHash, err := readHead()
+	if err != nil {
+		return "", err
+	}
+	mergeHeads, err := readMergeHeads()
+	if err != nil {
+		return "", err
+	}
+
+	var parents []string
+	if headHash != "" {
+		parents = append(parents, headHash)
+		headTree, err := readCommitTreeHash(headHash)
+		if err != nil {
+			return "", err
+		}
+		// Merge commit records the merge even when HEAD's tree won
+		if headTree == treeHash && !options.AllowEmpty && mergeHeads == nil {
+			return "", fmt.Errorf("nothing to commit")
+		}
+	}
+	parents = append(parents, mergeHeads...)
+
+	hash, err := createCommit(treeHash, parents, options)
+	if err != nil {
+		return "", err
+	}
+
+	subject, _ := splitCommitMessage(options.Message)
+	reflogMessage := "commit: " + subject
+	if headHash == "" {
+		reflogMessage = "commit (initial): " + subject
+	} else if mergeHeads != nil {
+		reflogMessage = "commit (merge): " + subject
+	}
+	if branch == "" {
+		return hash, updateRef("HEAD", hash, reflogMessage)
+	}
+	return hash, updateRef(branch, hash, reflogMessage)
+}
+
+// Write commit object of tree with parents - author and message come from options, commit is signed with -S
+// or when commit.gpgSign is set. Refs are not touched.
+func createCommit(treeHash string, parents []string, options CommitOptions) (string, error) {
+	author := options.Author
+	if author == "" {
+		author = userSignature("AUTHOR")
+	}
+	message := options.Message
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	content := buildCommitContent(treeHash, parents, author, userSignature("COMMITTER"), message)
+
+	sign := options.Sign
+	if config, err := loadConfig(); err == nil && !sign {
+		sign = config.GetBool("commit.gpgsign", false)
+	}
+	if sign {
+		signature, err := signPayload(content, options.SigningKey)
+		if err != nil {
+			return "", err
+		}
+		content = addCommitSignature(content, signature)
+	}
+
+	hashBytes, err := writeObject(generateObjectByte("commit", content))
+	if err != nil {
+		return "", fmt.Errorf("failed to write commit: %w", err)
+	}
+	return fmt.Sprintf("%x", hashBytes), nil
+}
+
+// Commit staged changes with hooks and write the summary line to w - without a message the editor is
+// opened on what a stopped merge left in MERGE_MSG (or a squash merge in SQUASH_MSG), options.NoEdit takes
+// that message as it is. Merge state is cleared and resolutions are remembered for rerere.
+func commitChanges(options CommitOptions, w io.Writer) (string, error) {
+	if options.Message == "" {
+		template, err := os.ReadFile(gitDirPath("MERGE_MSG"))
+		if os.IsNotExist(err) {
+			template, _ = os.ReadFile(gitDirPath("SQUASH_MSG"))
+		}
+		message := stripCommentLines(string(template))
+		if !options.NoEdit {
+			if message, err = editCommitMessage(string(template)); err != nil {
+				return "", err
+			}
+		}
+		if message == "" {
+			return "", fmt.Errorf("aborting commit due to empty commit message")
+		}
+		options.Message = message
+	}
+	hash, message, err := commitWithHooks(options)
+	if err != nil {
+		return "", err
+	}
+	if err := clearMergeState(); err != nil {
+		return "", err
+	}
+	// Conflicts resolved by this commit are remembered for rerere
+	if err := rerereRecordResolutions(w); err != nil {
+		return "", err
+	}
+
+	writeCommitSummary(hash, message, w)
+	return hash, nil
+}
+
+// Write "[<branch> <hash>] <subject>" line for a commit just made on HEAD
+func writeCommitSummary(hash, message string, w io.Writer) {
+	branch, _, _ := readHead()
+	if branch == "" {
+		branch = "detached HEAD"
+	}
+	subject, _ := splitCommitMessage(message)
+	fmt.Fprintf(w, "[%s %s] %s\n", strings.TrimPrefix(branch, "refs/heads/"), shortHash(hash), subject)
+}
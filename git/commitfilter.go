@@ -0,0 +1,156 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Commit filters of log - a commit is shown only when it passes all of them:
+//
+//	--author=<pattern>      "Name <email>" of the author matches (any of the --author patterns)
+//	--committer=<pattern>   the same for the committer
+//	--grep=<pattern>        a line of the message matches - any of the patterns, all of them with
+//	                        --all-match; --invert-grep shows commits no line of which matches any pattern
+//	--since, --until        committer date is in the range (approximate dates, see date.go)
+//
+// Patterns are basic regular expressions (as grep's) - -E makes them extended, -F fixed strings and -i
+// ignores case. Pathspec limiting happens while the commits are selected (see log.go), before the filters.
+
+// Compile filter into a matcher - relative dates count back from now
+func newCommitMatcher(filter CommitFilter, now time.Time) (*commitMatcher, error) {
+	matcher := &commitMatcher{allMatch: filter.AllMatch, invertGrep: filter.InvertGrep}
+	var err error
+	if matcher.authors, err = compileGrepPatterns(filter.Authors, filter); err != nil {
+		return nil, err
+	}
+	if matcher.committers, err = compileGrepPatterns(filter.Committers, filter); err != nil {
+		return nil, err
+	}
+	if matcher.grep, err = compileGrepPatterns(filter.Grep, filter); err != nil {
+		return nil, err
+	}
+	if filter.Since != "" {
+		if matcher.since, err = parseApproxDate(filter.Since, now); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Until != "" {
+		if matcher.until, err = parseApproxDate(filter.Until, now); err != nil {
+			return nil, err
+		}
+	}
+	return matcher, nil
+}
+
+// Check whether commit passes the filters
+func (matcher *commitMatcher) matches(commit *Commit) bool {
+	if !matcher.since.IsZero() || !matcher.until.IsZero() {
+		_, _, when, err := parseSignature(commit.Committer)
+		if err != nil || (!matcher.since.IsZero() && when.Before(matcher.since)) ||
+			(!matcher.until.IsZero() && when.After(matcher.until)) {
+			return false
+		}
+	}
+	if !matchesIdentity(matcher.authors, commit.Author) || !matchesIdentity(matcher.committers, commit.Committer) {
+		return false
+	}
+	if len(matcher.grep) == 0 {
+		return true
+	}
+
+	lines := strings.Split(commit.Message, "\n")
+	// Inverted grep rejects commits matching any pattern, with --all-match too (as git does)
+	allMatch := matcher.allMatch && !matcher.invertGrep
+	matched := allMatch
+	for _, pattern := range matcher.grep {
+		found := slices.ContainsFunc(lines, pattern.MatchString)
+		if found != allMatch {
+			matched = found
+			break
+		}
+	}
+	return matched != matcher.invertGrep
+}
+
+// Check whether "Name <email>" of signature matches any of patterns (true without patterns)
+func matchesIdentity(patterns []*regexp.Regexp, signature string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	if closing := strings.LastIndexByte(signature, '>'); closing != -1 {
+		signature = signature[:closing+1]
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile patterns as filter's flags say
+func compileGrepPatterns(patterns []string, filter CommitFilter) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		expression := pattern
+		switch {
+		case filter.FixedStrings:
+			expression = regexp.QuoteMeta(pattern)
+		case !filter.ExtendedRegexp:
+			expression = basicToExtendedRegexp(pattern)
+		}
+		if filter.IgnoreCase {
+			expression = "(?i)" + expression
+		}
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression '%s': %w", pattern, err)
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled, nil
+}
+
+// Translate basic regular expression into extended one - "\(", "\|", "\+" etc. are operators and the bare
+// characters are literal; bracket expressions stay as they are
+func basicToExtendedRegexp(pattern string) string {
+	var expression strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			if strings.IndexByte("+?|(){}", pattern[i]) != -1 {
+				expression.WriteByte(pattern[i])
+			} else {
+				expression.WriteByte('\\')
+				expression.WriteByte(pattern[i])
+			}
+		case strings.IndexByte("+?|(){}", c) != -1:
+			expression.WriteByte('\\')
+			expression.WriteByte(c)
+		case c == '[':
+			// "]" right after "[" or "[^" is part of the set
+			end := i + 1
+			if end < len(pattern) && pattern[end] == '^' {
+				end++
+			}
+			if end < len(pattern) && pattern[end] == ']' {
+				end++
+			}
+			if closing := strings.IndexByte(pattern[end:], ']'); closing != -1 {
+				end += closing
+				expression.WriteString(pattern[i : end+1])
+				i = end
+			} else {
+				expression.WriteString(`\[`)
+			}
+		default:
+			expression.WriteByte(c)
+		}
+	}
+	return expression.String()
+}
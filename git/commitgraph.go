@@ -0,0 +1,224 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Commit-graph - root tree, parents, commit time and generation number of every reachable commit in one file
+// (objects/info/commit-graph), which git reads instead of inflating and parsing commits:
+//
+//	"CGPH" + version (1) + hash version (1 = SHA-1) + chunk count + base graph count (0)
+//	chunk table: (chunk count + 1) x (4 byte id + 8 byte offset), last entry has id 0 and points to the end
+//	OIDF - fanout table, 256 x 4 bytes
+//	OIDL - sorted commit hashes
+//	CDAT - per commit: root tree, positions of the first two parents (0x70000000 - none; MSB set on the
+//	       second -> the other parents are listed in EDGE from that index), then generation (30 bits) and
+//	       commit time (34 bits)
+//	EDGE - parents of octopus merges after the first, MSB set on the last one of each commit
+//	checksum of everything above
+//
+// Generation is the topological level - 1 for root commits, one more than the highest parent otherwise.
+// Shallow repositories get no commit-graph, their commits' parents are missing.
+
+const (
+	commitGraphName     = "commit-graph"
+	commitGraphNoParent = 0x70000000
+	commitGraphOctopus  = 0x80000000
+	commitGraphMaxGen   = 0x3fffffff
+)
+
+// Commits reachable from refs and HEAD - tags are peeled, refs to other objects are skipped
+func reachableCommits() ([]string, error) {
+	tips, err := refTipCommits()
+	if err != nil || len(tips) == 0 {
+		return nil, err
+	}
+	return revList(tips, nil)
+}
+
+// Commits that refs and HEAD point to (tags peeled) - refs to other objects are skipped
+func refTipCommits() ([]string, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	if _, head, err := readHead(); err == nil && head != "" {
+		refs["HEAD"] = head
+	}
+
+	var tips []string
+	for _, name := range sortedKeys(refs) {
+		hash := refs[name]
+		if peeled, err := peelTag(hash); err != nil {
+			return nil, err
+		} else if peeled != "" {
+			hash = peeled
+		}
+		if objType, _, _, err := readObjectFromHash(hash); err == nil && objType == "commit" {
+			tips = append(tips, hash)
+		}
+	}
+	return tips, nil
+}
+
+// Write objects/info/commit-graph with every reachable commit - returns the number of commits in it
+func writeCommitGraph() (int, error) {
+	defer tracePerformance("write commit-graph")()
+	defer ignoreReplaceRefs()()
+	if grafted, err := hasCommitGrafts(); err != nil {
+		return 0, err
+	} else if grafted {
+		return 0, fmt.Errorf("commit-graph is not supported in shallow repositories or with grafts")
+	}
+
+	hashes, err := reachableCommits()
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(hashes)
+	positions := make(map[string]uint32, len(hashes))
+	for i, hash := range hashes {
+		positions[hash] = uint32(i)
+	}
+	commits := make([]*Commit, len(hashes))
+	times := make([]int64, len(hashes))
+	for i, hash := range hashes {
+		if commits[i], err = readCommit(hash); err != nil {
+			return 0, err
+		}
+		if _, _, when, err := parseSignature(commits[i].Committer); err == nil {
+			times[i] = when.Unix()
+		}
+	}
+
+	// Generations - parents first, without recursion (histories can be deep)
+	generations := make([]uint32, len(hashes))
+	for i := range hashes {
+		stack := []int{i}
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			if generations[current] != 0 {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			generation, pending := uint32(1), false
+			for _, parent := range commits[current].Parents {
+				position := positions[parent]
+				if generations[position] == 0 {
+					stack, pending = append(stack, int(position)), true
+				}
+				generation = max(generation, generations[position]+1)
+			}
+			if !pending {
+				generations[current] = min(generation, commitGraphMaxGen)
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	var fanout, oids, data, edges bytes.Buffer
+	var counts [256]uint32
+	for i, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		counts[raw[0]]++
+		oids.Write(raw)
+
+		tree, _ := hex.DecodeString(commits[i].Tree)
+		data.Write(tree)
+		parents := commits[i].Parents
+		for slot := 0; slot < 2; slot++ {
+			switch {
+			case slot >= len(parents):
+				binary.Write(&data, binary.BigEndian, uint32(commitGraphNoParent))
+			case slot == 1 && len(parents) > 2:
+				binary.Write(&data, binary.BigEndian, uint32(commitGraphOctopus|edges.Len()/4))
+				for j, parent := range parents[1:] {
+					position := positions[parent]
+					if j == len(parents)-2 {
+						position |= commitGraphOctopus
+					}
+					binary.Write(&edges, binary.BigEndian, position)
+				}
+			default:
+				binary.Write(&data, binary.BigEndian, positions[parents[slot]])
+			}
+		}
+		binary.Write(&data, binary.BigEndian, uint64(generations[i])<<34|uint64(times[i])&(1<<34-1))
+	}
+	for i, count := 0, uint32(0); i < 256; i++ {
+		count += counts[i]
+		binary.Write(&fanout, binary.BigEndian, count)
+	}
+
+	chunks := []MultiPackChunk{
+		{"OIDF", fanout.Bytes()},
+		{"OIDL", oids.Bytes()},
+		{"CDAT", data.Bytes()},
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, MultiPackChunk{"EDGE", edges.Bytes()})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("CGPH")
+	buf.Write([]byte{1, 1, byte(len(chunks)), 0})
+	offset := uint64(buf.Len() + (len(chunks)+1)*12)
+	for _, chunk := range chunks {
+		buf.WriteString(chunk.ID)
+		binary.Write(&buf, binary.BigEndian, offset)
+		offset += uint64(len(chunk.Data))
+	}
+	buf.Write([]byte{0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, offset)
+	for _, chunk := range chunks {
+		buf.Write(chunk.Data)
+	}
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	infoDir := objectDirPath("info")
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", infoDir, err)
+	}
+	tmpPath := filepath.Join(infoDir, commitGraphName+".lock")
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0444); err != nil {
+		return 0, fmt.Errorf("failed to write commit-graph: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(infoDir, commitGraphName)); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write commit-graph: %w", err)
+	}
+	return len(hashes), nil
+}
+
+// Commits in objects/info/commit-graph - empty when there is none (or it can't be read)
+func readCommitGraphHashes() map[string]bool {
+	hashes := make(map[string]bool)
+	data, err := os.ReadFile(objectDirPath("info", commitGraphName))
+	if err != nil || len(data) < 8 || string(data[:4]) != "CGPH" {
+		return hashes
+	}
+	chunkCount := int(data[6])
+	for i := 0; i < chunkCount; i++ {
+		entry := 8 + i*12
+		if len(data) < entry+24 || string(data[entry:entry+4]) != "OIDL" {
+			continue
+		}
+		start := binary.BigEndian.Uint64(data[entry+4:])
+		end := binary.BigEndian.Uint64(data[entry+16:])
+		if start > end || end > uint64(len(data)) {
+			return hashes
+		}
+		for oid := start; oid+20 <= end; oid += 20 {
+			hashes[hex.EncodeToString(data[oid:oid+20])] = true
+		}
+	}
+	return hashes
+}
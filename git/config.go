@@ -0,0 +1,445 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Git config reader - parses INI-like git config files (~/.gitconfig, .git/config)
+//
+// [section]                -> key: section.name
+// [section "subsection"]   -> key: section.subsection.name
+//
+// Section and variable names are case-insensitive, subsection names are case-sensitive.
+
+// Load global (~/.gitconfig) and repository (.git/config) config - repository values override global ones
+func loadConfig() (*Config, error) {
+	config := &Config{values: make(map[string][]string)}
+
+	for _, configPath := range configFilePaths() {
+		if err := config.loadFile(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// Config files in the order they should be applied (lowest priority first)
+func configFilePaths() []string {
+	var paths []string
+	if globalConfig := os.Getenv("GIT_CONFIG_GLOBAL"); globalConfig != "" {
+		paths = append(paths, globalConfig)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	paths = append(paths, gitDirPath("config"))
+	return paths
+}
+
+// Parse one config file and add its values - missing file is not an error
+func (config *Config) loadFile(configPath string) error {
+	file, err := os.Open(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open config %s: %w", configPath, err)
+	}
+	defer file.Close()
+
+	section := ""
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// Section header - [core] or [remote "origin"]
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end == -1 {
+				return fmt.Errorf("bad config line %d in %s", lineNumber, configPath)
+			}
+			section = parseConfigSection(line[1:end])
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+
+		if section == "" {
+			return fmt.Errorf("bad config line %d in %s", lineNumber, configPath)
+		}
+
+		// Variable - name = value (value is optional, "name" alone means true)
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !hasValue {
+			config.add(section+"."+name, "true")
+			continue
+		}
+		config.add(section+"."+name, parseConfigValue(value))
+	}
+
+	return scanner.Err()
+}
+
+// Turns `remote "origin"` into `remote.origin` (section lowercased, subsection kept as is)
+func parseConfigSection(header string) string {
+	name, subsection, hasSubsection := strings.Cut(strings.TrimSpace(header), " ")
+	name = strings.ToLower(name)
+	if !hasSubsection {
+		// Deprecated [section.subsection] syntax
+		if before, after, ok := strings.Cut(name, "."); ok {
+			return before + "." + after
+		}
+		return name
+	}
+
+	subsection = strings.TrimSpace(subsection)
+	subsection = strings.TrimPrefix(subsection, "\"")
+	subsection = strings.TrimSuffix(subsection, "\"")
+	subsection = strings.ReplaceAll(subsection, "\\\"", "\"")
+	subsection = strings.ReplaceAll(subsection, "\\\\", "\\")
+	return name + "." + subsection
+}
+
+// Strips comments and quotes from value and resolves escape sequences
+func parseConfigValue(raw string) string {
+	var value strings.Builder
+	inQuotes := false
+	pendingSpace := ""
+
+	raw = strings.TrimSpace(raw)
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '\\' && i+1 < len(raw):
+			i++
+			value.WriteString(pendingSpace)
+			pendingSpace = ""
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			default:
+				value.WriteByte(raw[i])
+			}
+		case (c == '#' || c == ';') && !inQuotes:
+			return value.String()
+		case (c == ' ' || c == '\t') && !inQuotes:
+			// Inner whitespace is kept, trailing is dropped
+			pendingSpace += string(c)
+		default:
+			value.WriteString(pendingSpace)
+			pendingSpace = ""
+			value.WriteByte(c)
+		}
+	}
+
+	return value.String()
+}
+
+// Normalize key - section and variable names are case-insensitive, subsection is not
+func normalizeConfigKey(key string) string {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first == -1 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+func (config *Config) add(key, value string) {
+	key = normalizeConfigKey(key)
+	config.values[key] = append(config.values[key], value)
+}
+
+// Get last value for provided key (later files/lines override earlier ones)
+func (config *Config) Get(key string) (string, bool) {
+	values := config.values[normalizeConfigKey(key)]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// Get all values for a multi-valued key (e.g. remote.origin.fetch)
+func (config *Config) GetAll(key string) []string {
+	return config.values[normalizeConfigKey(key)]
+}
+
+// Subsection names of section that have values (remote -> origin, upstream, ...), sorted
+func (config *Config) Subsections(section string) []string {
+	prefix := strings.ToLower(section) + "."
+	found := make(map[string]bool)
+	for key := range config.values {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			if last := strings.LastIndexByte(rest, '.'); last != -1 {
+				found[rest[:last]] = true
+			}
+		}
+	}
+	return sortedKeys(found)
+}
+
+// Get boolean value (true/yes/on/1 and false/no/off/0) - returns defaultValue if key is missing or invalid
+func (config *Config) GetBool(key string, defaultValue bool) bool {
+	value, ok := config.Get(key)
+	if !ok {
+		return defaultValue
+	}
+	return parseBoolValue(value, defaultValue)
+}
+
+// Parse boolean value (true/yes/on/1, false/no/off/0/empty) - defaultValue if it is neither
+func parseBoolValue(value string, defaultValue bool) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	default:
+		return defaultValue
+	}
+}
+
+// Get integer value with optional k/m/g suffix - returns defaultValue if key is missing or invalid
+func (config *Config) GetInt(key string, defaultValue int64) int64 {
+	value, ok := config.Get(key)
+	if !ok || value == "" {
+		return defaultValue
+	}
+
+	n, err := parseSizeValue(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
+
+// Parse integer with optional k/m/g suffix (1k -> 1024)
+func parseSizeValue(value string) (int64, error) {
+	if value == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+
+	multiplier := int64(1)
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "k":
+		multiplier = 1024
+	case "m":
+		multiplier = 1024 * 1024
+	case "g":
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// Expand leading ~/ in path-like config values (e.g. core.excludesFile) - other relative paths are relative
+// to the directory commands run in (see commandDirPath)
+func expandConfigPath(value string) string {
+	if strings.HasPrefix(value, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, value[2:])
+		}
+	}
+	if value == "" || strings.HasPrefix(value, "key::") {
+		return value
+	}
+	return commandDirPath(value)
+}
+
+// Set key in config file - replaces the last existing value, or adds it to its section (creating the section if needed)
+func setConfigValue(configPath, key, value string) error {
+	return editConfigFile(configPath, key, value, true)
+}
+
+// Add one more value to a (multi-valued) key, keeping existing values
+func addConfigValue(configPath, key, value string) error {
+	return editConfigFile(configPath, key, value, false)
+}
+
+// Rewrite config file with key set to value - replace controls whether the existing value is overwritten
+func editConfigFile(configPath, key, value string, replace bool) error {
+	key = normalizeConfigKey(key)
+	lastDot := strings.LastIndexByte(key, '.')
+	if lastDot == -1 {
+		return fmt.Errorf("invalid config key: %s", key)
+	}
+	section, name := key[:lastDot], key[lastDot+1:]
+
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config %s: %w", configPath, err)
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	newLine := fmt.Sprintf("\t%s = %s", name, formatConfigValue(value))
+	currentSection := ""
+	sectionEnd := -1
+	keyLine := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.LastIndexByte(trimmed, ']'); end != -1 {
+				currentSection = parseConfigSection(trimmed[1:end])
+			}
+		}
+		if currentSection != section {
+			continue
+		}
+
+		sectionEnd = i
+		lineName, _, _ := strings.Cut(trimmed, "=")
+		if !strings.HasPrefix(trimmed, "[") && strings.ToLower(strings.TrimSpace(lineName)) == name {
+			keyLine = i
+		}
+	}
+
+	switch {
+	case replace && keyLine != -1:
+		lines[keyLine] = newLine
+	case sectionEnd != -1:
+		lines = append(lines[:sectionEnd+1], append([]string{newLine}, lines[sectionEnd+1:]...)...)
+	default:
+		lines = append(lines, formatConfigSection(section), newLine)
+	}
+
+	return os.WriteFile(configPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Remove every value of key from config file - its section stays, even when empty
+func unsetConfigValue(configPath, key string) error {
+	key = normalizeConfigKey(key)
+	lastDot := strings.LastIndexByte(key, '.')
+	if lastDot == -1 {
+		return fmt.Errorf("invalid config key: %s", key)
+	}
+	section, name := key[:lastDot], key[lastDot+1:]
+	return filterConfigFile(configPath, func(lineSection, lineName string) bool {
+		return lineSection != section || lineName != name
+	})
+}
+
+// Remove section (e.g. remote.origin) with all its values from config file
+func removeConfigSection(configPath, section string) error {
+	section = normalizeConfigSection(section)
+	return filterConfigFile(configPath, func(lineSection, _ string) bool {
+		return lineSection != section
+	})
+}
+
+// Rename section (e.g. remote.origin to remote.upstream) in config file - values stay as they are
+func renameConfigSection(configPath, oldSection, newSection string) error {
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read config %s: %w", configPath, err)
+	}
+	oldSection = normalizeConfigSection(oldSection)
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "[") {
+			continue
+		}
+		// Values written on the header line ([section] key = value) are kept after the new header
+		if end := strings.LastIndexByte(trimmed, ']'); end != -1 && parseConfigSection(trimmed[1:end]) == oldSection {
+			lines[i] = formatConfigSection(newSection) + trimmed[end+1:]
+		}
+	}
+	return os.WriteFile(configPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Section name as config keys have it - section name lowercased, subsection name kept
+func normalizeConfigSection(section string) string {
+	if name, subsection, ok := strings.Cut(section, "."); ok {
+		return strings.ToLower(name) + "." + subsection
+	}
+	return strings.ToLower(section)
+}
+
+// Rewrite config file keeping only lines keep accepts - it gets section of the line and lowercased variable
+// name ("" for section headers, comments and blank lines)
+func filterConfigFile(configPath string, keep func(section, name string) bool) error {
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read config %s: %w", configPath, err)
+	}
+
+	var lines []string
+	currentSection := ""
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		name := ""
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.LastIndexByte(trimmed, ']'); end != -1 {
+				currentSection = parseConfigSection(trimmed[1:end])
+			}
+		} else if trimmed != "" && trimmed[0] != '#' && trimmed[0] != ';' {
+			lineName, _, _ := strings.Cut(trimmed, "=")
+			name = strings.ToLower(strings.TrimSpace(lineName))
+		}
+		if keep(currentSection, name) {
+			lines = append(lines, line)
+		}
+	}
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	return os.WriteFile(configPath, []byte(content), 0644)
+}
+
+// Section header for key section (remote.origin -> [remote "origin"])
+func formatConfigSection(section string) string {
+	name, subsection, hasSubsection := strings.Cut(section, ".")
+	if !hasSubsection {
+		return fmt.Sprintf("[%s]", name)
+	}
+	subsection = strings.ReplaceAll(subsection, "\\", "\\\\")
+	subsection = strings.ReplaceAll(subsection, "\"", "\\\"")
+	return fmt.Sprintf("[%s \"%s\"]", name, subsection)
+}
+
+// Quote value if it contains characters that would otherwise be lost (comments, surrounding spaces, quotes)
+func formatConfigValue(value string) string {
+	escaped := strings.ReplaceAll(value, "\\", "\\\\")
+	escaped = strings.ReplaceAll(escaped, "\"", "\\\"")
+	escaped = strings.ReplaceAll(escaped, "\n", "\\n")
+	escaped = strings.ReplaceAll(escaped, "\t", "\\t")
+
+	if strings.ContainsAny(value, "#;") || strings.TrimSpace(value) != value {
+		return "\"" + escaped + "\""
+	}
+	return escaped
+}
@@ -0,0 +1,914 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Initialize .git repo with .git/objects .git/refs directories and .git/index .git/HEAD files (in the
+// active layout) - bare repository has them in its directory, and no index. HEAD of an existing repository is kept.
+func initRepo(options InitOptions) error {
+	bare := options.Bare
+	for _, dir := range []string{gitDirPath(), objectDirPath(), gitDirPath("refs")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	if _, err := os.Stat(gitDirPath("HEAD")); os.IsNotExist(err) {
+		branch, err := initialBranchName(options.InitialBranch)
+		if err != nil {
+			return err
+		}
+		headFileContents := []byte("ref: refs/heads/" + branch + "\n")
+		if err := os.WriteFile(gitDirPath("HEAD"), headFileContents, 0644); err != nil {
+			return fmt.Errorf("failed to write HEAD file: %w", err)
+		}
+	}
+
+	configContents := []byte(fmt.Sprintf("[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = %t\n", bare))
+	if _, err := os.Stat(gitDirPath("config")); os.IsNotExist(err) {
+		if err := os.WriteFile(gitDirPath("config"), configContents, 0644); err != nil {
+			return fmt.Errorf("failed to write config file: %w", err)
+		}
+	}
+	if bare {
+		return nil
+	}
+
+	err := createEmptyIndex()
+	if err != nil {
+		return fmt.Errorf("failed to create .git/index: %w", err)
+	}
+	return nil
+}
+
+// Branch for HEAD of a new repository - given name, init.defaultBranch config, or master
+func initialBranchName(branch string) (string, error) {
+	if branch == "" {
+		if config, err := loadConfig(); err == nil {
+			branch, _ = config.Get("init.defaultBranch")
+		}
+	}
+	if branch == "" {
+		return "master", nil
+	}
+	if err := CheckBranchName(branch); err != nil {
+		return "", fmt.Errorf("invalid initial branch name: %w", err)
+	}
+	return branch, nil
+}
+
+// Create empty .git/index file
+func createEmptyIndex() error {
+	// Index v2 header:
+	// 4 bytes: signature ("DIRC")
+	// 4 bytes: version (2)
+	// 4 bytes: entry count (0)
+	header := make([]byte, 12)
+	copy(header[0:4], []byte("DIRC"))
+	binary.BigEndian.PutUint32(header[4:8], 2)  // version 2
+	binary.BigEndian.PutUint32(header[8:12], 0) // 0 entries
+
+	// SHA1 checksum of the content (excluding checksum itself)
+	hash := sha1.Sum(header)
+
+	// Append checksum to end
+	full := append(header, hash[:]...)
+
+	// Write to .git/index
+	return os.WriteFile(indexFilePath(), full, 0644)
+}
+
+// Read object from given SHA1 hash - returns ObjectType (blob/tree/commit), ObjectLen (in bytes), ObjectContent (byte array)
+// Content is re-hashed and *ObjectCorruptError is returned on mismatch (GIT_VERIFY_OBJECTS=0 skips the check).
+// Replaced objects (see replace.go) give the content of their replacement.
+func readObjectFromHash(objectHash string) (string, string, []byte, error) {
+	if len(objectHash) != 40 {
+		return "", "", nil, fmt.Errorf("%w: invalid object name %s", ErrObjectNotFound, objectHash)
+	}
+	objectHash, err := lookupReplaceObject(objectHash)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return readStoredObject(objectHash)
+}
+
+// Read object as it is stored, ignoring replace refs - delta bases must be the objects deltas were made against
+func readStoredObject(objectHash string) (string, string, []byte, error) {
+	if len(objectHash) != 40 {
+		return "", "", nil, fmt.Errorf("%w: invalid object name %s", ErrObjectNotFound, objectHash)
+	}
+	objectPath, loose := looseObjectPath(objectHash)
+	if !loose {
+		// Not a loose object - it may be stored in one of the packs
+		objType, content, found, err := readPackedObject(objectHash)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if !found {
+			// Partial clone - object may have been filtered out, ask the promisor remote for it (a single object
+			// is fetched without cancellation, only the HTTP low speed limit stops a stalled transfer)
+			fetched, err := fetchPromisedObjects(context.Background(), []string{objectHash})
+			if err != nil {
+				return "", "", nil, err
+			}
+			if fetched {
+				return readStoredObject(objectHash)
+			}
+			return "", "", nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectHash)
+		}
+		if err := verifyObjectHash(objectHash, generateObjectByte(objType.String(), content)); err != nil {
+			return "", "", nil, err
+		}
+		return objType.String(), strconv.Itoa(len(content)), content, nil
+	}
+
+	data, err := os.ReadFile(objectPath)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("%w: failed to decompress %s: %v", ErrCorruptObject, objectHash, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("%w: failed to decompress %s: %v", ErrCorruptObject, objectHash, err)
+	}
+	if err := verifyObjectHash(objectHash, decompressed); err != nil {
+		return "", "", nil, err
+	}
+
+	header, body, _ := bytes.Cut(decompressed, []byte{0x00})
+
+	parts := strings.Split(string(header), " ")
+	if len(parts) != 2 || parts[1] != strconv.Itoa(len(body)) {
+		return "", "", nil, fmt.Errorf("%w: %s has bad header", ErrCorruptObject, objectHash)
+	}
+	objType, objSize := parts[0], parts[1]
+
+	return objType, objSize, body, nil
+}
+
+// Check raw object (header and content) hashes to expected name
+func verifyObjectHash(objectHash string, object []byte) error {
+	if os.Getenv("GIT_VERIFY_OBJECTS") == "0" {
+		return nil
+	}
+	if actual := fmt.Sprintf("%x", sha1.Sum(object)); actual != objectHash {
+		return &ObjectCorruptError{Hash: objectHash, Actual: actual}
+	}
+	return nil
+}
+
+// Compress given object using zlib
+func compressObject(object []byte) ([]byte, error) {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+
+	_, err := zw.Write(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress the object")
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close writter")
+	}
+
+	return b.Bytes(), nil
+}
+
+// Creates Object Hash using SHA1 function
+func hashObject(objectBytes []byte) []byte {
+	hasher := sha1.New()
+	hasher.Write(objectBytes)
+	hash := hasher.Sum(nil)
+	return hash
+}
+
+// Checks does object exists, and read its content
+func readObjectFromPath(path string) ([]byte, int, error) {
+	// check path
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, 0, fmt.Errorf("object on %s path not found", path)
+	}
+
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return fileData, len(fileData), nil
+}
+
+// Print tree data based on provided Tree Object Content and flag
+func printTreeData(objectContent []byte, flag string, w io.Writer) error {
+	i := 0
+	for i < len(objectContent) {
+		nullIndex := bytes.IndexByte(objectContent[i:], 0)
+		if nullIndex == -1 {
+			return fmt.Errorf("malformed tree entry")
+		}
+
+		entryHeader := objectContent[i : i+nullIndex]
+		parts := bytes.SplitN(entryHeader, []byte(" "), 2)
+		mode := string(parts[0])
+		name := string(parts[1])
+
+		i += nullIndex + 1
+		if i+20 > len(objectContent) {
+			return fmt.Errorf("unexpected end of SHA")
+		}
+
+		shaBytes := objectContent[i : i+20]
+		shaHex := fmt.Sprintf("%x", shaBytes)
+		i += 20
+
+		if flag == "--name-only" {
+			fmt.Fprintln(w, name)
+		} else {
+			fmt.Fprintf(w, "%s %s %s\n", mode, shaHex, name)
+		}
+	}
+
+	return nil
+}
+
+// Generate object with header and content (<type> <size>\0<content>) with provided type and content
+func generateObjectByte(objectType string, objectContent []byte) []byte {
+	header := objectType + " " + strconv.Itoa(len(objectContent))
+	headerNull := append([]byte(header), byte(0))
+	return append(headerNull, objectContent...)
+}
+
+// Takes in raw objet bytes, creates hash using SHA1, compress and write the object,
+func writeObject(object []byte) ([]byte, error) {
+
+	hash := hashObject(object)
+	compressedObject, err := compressObject(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress object: %w", err)
+	}
+
+	hashString := fmt.Sprintf("%x", hash)
+
+	dirName := hashString[:2]
+	fileName := hashString[2:]
+
+	dirPath := objectDirPath(dirName)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	fullPath := filepath.Join(dirPath, fileName)
+
+	if _, err := os.Stat(fullPath); err == nil {
+		return hash, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error checking object file: %w", err)
+	}
+
+	if err := os.WriteFile(fullPath, compressedObject, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	return hash, nil
+}
+
+// Index entry flags - 16 bit flags field, and extended flags (v3+) that follow it when indexFlagExtended is set
+const (
+	indexFlagAssumeValid  = 0x8000
+	indexFlagExtended     = 0x4000
+	indexFlagStage        = 0x3000
+	indexFlagNameMask     = 0x0FFF
+	indexFlagSkipWorktree = 0x4000
+	indexFlagIntentToAdd  = 0x2000
+)
+
+// Read .git/index file to retrieve all entries from it - returns IndexEntry array - used for write-tree command to write everything from staging area (.git/index)
+// Versions 2, 3 (extended flags) and 4 (prefix compressed paths) are supported
+func readGitIndex() ([]IndexEntry, error) {
+	data, err := os.ReadFile(indexFilePath())
+	if os.IsNotExist(err) {
+		// No index yet (e.g. repository created by another tool) - nothing is staged
+		delete(indexStateCache, indexFilePath())
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 12 {
+		return nil, fmt.Errorf("index file is too short")
+	}
+	if string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("invalid index signature")
+	}
+
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version: %d", version)
+	}
+
+	entryCount := binary.BigEndian.Uint32(data[8:12])
+	entries := make([]IndexEntry, 0, entryCount)
+
+	offset := 12
+	previousPath := ""
+	for i := 0; i < int(entryCount); i++ {
+		entryStart := offset
+		if offset+62 > len(data) {
+			return nil, fmt.Errorf("reading entry header: index is truncated")
+		}
+		entryHeader := data[offset : offset+62]
+		offset += 62
+
+		mode := binary.BigEndian.Uint32(entryHeader[24:28])
+		hash := make([]byte, 20)
+		copy(hash, entryHeader[40:60])
+
+		flags := binary.BigEndian.Uint16(entryHeader[60:62])
+		var extendedFlags uint16
+		if flags&indexFlagExtended != 0 {
+			if version < 3 {
+				return nil, fmt.Errorf("extended flags in index version %d", version)
+			}
+			if offset+2 > len(data) {
+				return nil, fmt.Errorf("reading extended flags: index is truncated")
+			}
+			extendedFlags = binary.BigEndian.Uint16(data[offset : offset+2])
+			offset += 2
+		}
+
+		var path string
+		if version == 4 {
+			// Number of bytes to drop from the end of previous path, then NUL terminated suffix - no padding
+			strip, next, err := readIndexVarint(data, offset)
+			if err != nil {
+				return nil, err
+			}
+			if strip > len(previousPath) {
+				return nil, fmt.Errorf("bad path prefix in index entry %d", i)
+			}
+			end := bytes.IndexByte(data[next:], 0)
+			if end == -1 {
+				return nil, fmt.Errorf("reading path: index is truncated")
+			}
+			path = previousPath[:len(previousPath)-strip] + string(data[next:next+end])
+			offset = next + end + 1
+		} else {
+			// Names longer than the 12 bit length field are found by the NUL terminator
+			nameLen := int(flags & indexFlagNameMask)
+			if nameLen == indexFlagNameMask {
+				nameLen = bytes.IndexByte(data[offset:], 0)
+			}
+			if nameLen < 0 || offset+nameLen > len(data) {
+				return nil, fmt.Errorf("reading path: index is truncated")
+			}
+			path = string(data[offset : offset+nameLen])
+
+			// Entries are padded with 1-8 NUL bytes (path is always NUL terminated)
+			totalLen := offset + nameLen - entryStart
+			offset += nameLen + 8 - (totalLen % 8)
+		}
+		previousPath = path
+
+		entries = append(entries, IndexEntry{
+			Path:         path,
+			Hash:         hash,
+			Mode:         mode,
+			SkipWorktree: extendedFlags&indexFlagSkipWorktree != 0,
+			IntentToAdd:  extendedFlags&indexFlagIntentToAdd != 0,
+			Stage:        uint8((flags & indexFlagStage) >> 12),
+			Stat: IndexStat{
+				CTimeSeconds:     binary.BigEndian.Uint32(entryHeader[0:4]),
+				CTimeNanoseconds: binary.BigEndian.Uint32(entryHeader[4:8]),
+				MTimeSeconds:     binary.BigEndian.Uint32(entryHeader[8:12]),
+				MTimeNanoseconds: binary.BigEndian.Uint32(entryHeader[12:16]),
+				Dev:              binary.BigEndian.Uint32(entryHeader[16:20]),
+				Ino:              binary.BigEndian.Uint32(entryHeader[20:24]),
+				UID:              binary.BigEndian.Uint32(entryHeader[28:32]),
+				GID:              binary.BigEndian.Uint32(entryHeader[32:36]),
+				Size:             binary.BigEndian.Uint32(entryHeader[36:40]),
+			},
+		})
+	}
+
+	// Extensions fill the space up to the checksum (all zero checksum means index.skipHash was used)
+	if offset > len(data)-20 {
+		return nil, fmt.Errorf("index is truncated")
+	}
+	checksum := data[len(data)-20:]
+	if expected := sha1.Sum(data[:len(data)-20]); !bytes.Equal(checksum, expected[:]) && !bytes.Equal(checksum, make([]byte, 20)) {
+		return nil, fmt.Errorf("index file corrupt: bad checksum")
+	}
+	state := &IndexState{Entries: make(map[string]IndexEntry, len(entries))}
+	fsmonitorDirty, err := parseIndexExtensions(data[offset:len(data)-20], state)
+	if err != nil {
+		return nil, err
+	}
+	if fsmonitorDirty != nil {
+		if len(fsmonitorDirty) > len(entries) {
+			// Bitmap from another index - nothing can be trusted
+			state.FSMonitorToken = ""
+		} else {
+			for i := range entries {
+				entries[i].FSMonitorValid = i >= len(fsmonitorDirty) || !fsmonitorDirty[i]
+			}
+		}
+	}
+	if info, err := os.Stat(indexFilePath()); err == nil {
+		state.ModTime = info.ModTime()
+	}
+	for _, entry := range entries {
+		state.Entries[entry.Path] = entry
+	}
+	indexStateCache[indexFilePath()] = state
+
+	return entries, nil
+}
+
+// Write provided entries to .git/index - entries are sorted by path (and stage), as git requires
+// Version comes from indexWriteVersion, v2 is upgraded to v3 when some entry needs extended flags
+func writeGitIndex(entries []IndexEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Stage < entries[j].Stage
+	})
+
+	version := indexWriteVersion()
+	for _, entry := range entries {
+		if version < 3 && (entry.SkipWorktree || entry.IntentToAdd) {
+			version = 3
+		}
+	}
+
+	var buf bytes.Buffer
+	header := make([]byte, 12)
+	copy(header[0:4], []byte("DIRC"))
+	binary.BigEndian.PutUint32(header[4:8], version)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(entries)))
+	buf.Write(header)
+
+	// Files modified in the second the index is written may still change without changing their stat data, so
+	// size of their entries is zeroed - stat won't match and they are hashed again ("racy git" smudging)
+	racyTime := uint32(time.Now().Unix())
+
+	previousPath := ""
+	for _, entry := range entries {
+		// ctime, mtime, dev, ino, mode, uid, gid, size (4 bytes each), sha1 (20 bytes), flags (2 bytes)
+		stat := entry.Stat
+		if stat.MTimeSeconds >= racyTime {
+			stat.Size = 0
+		}
+		entryHeader := make([]byte, 62)
+		binary.BigEndian.PutUint32(entryHeader[0:4], stat.CTimeSeconds)
+		binary.BigEndian.PutUint32(entryHeader[4:8], stat.CTimeNanoseconds)
+		binary.BigEndian.PutUint32(entryHeader[8:12], stat.MTimeSeconds)
+		binary.BigEndian.PutUint32(entryHeader[12:16], stat.MTimeNanoseconds)
+		binary.BigEndian.PutUint32(entryHeader[16:20], stat.Dev)
+		binary.BigEndian.PutUint32(entryHeader[20:24], stat.Ino)
+		binary.BigEndian.PutUint32(entryHeader[24:28], entry.Mode)
+		binary.BigEndian.PutUint32(entryHeader[28:32], stat.UID)
+		binary.BigEndian.PutUint32(entryHeader[32:36], stat.GID)
+		binary.BigEndian.PutUint32(entryHeader[36:40], stat.Size)
+		copy(entryHeader[40:60], entry.Hash)
+
+		nameLen := len(entry.Path)
+		if nameLen > indexFlagNameMask {
+			nameLen = indexFlagNameMask
+		}
+		flags := uint16(nameLen) | uint16(entry.Stage)<<12&indexFlagStage
+		var extendedFlags uint16
+		if entry.SkipWorktree {
+			extendedFlags |= indexFlagSkipWorktree
+		}
+		if entry.IntentToAdd {
+			extendedFlags |= indexFlagIntentToAdd
+		}
+		if extendedFlags != 0 {
+			flags |= indexFlagExtended
+		}
+		binary.BigEndian.PutUint16(entryHeader[60:62], flags)
+		buf.Write(entryHeader)
+		if extendedFlags != 0 {
+			binary.Write(&buf, binary.BigEndian, extendedFlags)
+		}
+
+		if version == 4 {
+			common := 0
+			for common < len(previousPath) && common < len(entry.Path) && previousPath[common] == entry.Path[common] {
+				common++
+			}
+			buf.Write(encodeIndexVarint(len(previousPath) - common))
+			buf.WriteString(entry.Path[common:])
+			buf.WriteByte(0)
+			previousPath = entry.Path
+			continue
+		}
+
+		buf.WriteString(entry.Path)
+		totalLen := len(entryHeader) + len(entry.Path)
+		if extendedFlags != 0 {
+			totalLen += 2
+		}
+		buf.Write(make([]byte, 8-(totalLen%8)))
+	}
+
+	// Extensions of the index on disk are kept - cached trees of changed directories are invalidated
+	if _, ok := indexStateCache[indexFilePath()]; !ok {
+		readGitIndex()
+	}
+	state := &IndexState{Entries: make(map[string]IndexEntry, len(entries))}
+	if previous, ok := indexStateCache[indexFilePath()]; ok {
+		state.CacheTree, state.Extensions = previous.CacheTree, previous.Extensions
+		state.UntrackedCache, state.FSMonitorToken = previous.UntrackedCache, previous.FSMonitorToken
+		invalidateChangedPaths(state, previous.Entries, entries)
+	}
+	for _, entry := range entries {
+		state.Entries[entry.Path] = entry
+	}
+	writeIndexExtensions(&buf, state, entries)
+
+	// SHA1 checksum of the whole content
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	if err := os.WriteFile(indexFilePath(), buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if info, err := os.Stat(indexFilePath()); err == nil {
+		state.ModTime = info.ModTime()
+	}
+	indexStateCache[indexFilePath()] = state
+	return nil
+}
+
+// Index version to write - GIT_INDEX_VERSION, index.version config (feature.manyFiles means 4),
+// then version of the existing index file, 2 by default
+func indexWriteVersion() uint32 {
+	parseVersion := func(value string) (uint32, bool) {
+		version, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || version < 2 || version > 4 {
+			return 0, false
+		}
+		return uint32(version), true
+	}
+
+	if version, ok := parseVersion(os.Getenv("GIT_INDEX_VERSION")); ok {
+		return version
+	}
+	if config, err := loadConfig(); err == nil {
+		if value, ok := config.Get("index.version"); ok {
+			if version, ok := parseVersion(value); ok {
+				return version
+			}
+		}
+		if config.GetBool("feature.manyFiles", false) {
+			return 4
+		}
+	}
+
+	if file, err := os.Open(indexFilePath()); err == nil {
+		defer file.Close()
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(file, header); err == nil && string(header[:4]) == "DIRC" {
+			if version := binary.BigEndian.Uint32(header[4:8]); version >= 2 && version <= 4 {
+				return version
+			}
+		}
+	}
+	return 2
+}
+
+// Read index v4 varint at offset - big endian base 128 where every continuation adds one (as in OFS_DELTA)
+func readIndexVarint(data []byte, offset int) (int, int, error) {
+	if offset >= len(data) {
+		return 0, offset, fmt.Errorf("reading path prefix: index is truncated")
+	}
+	c := data[offset]
+	offset++
+	value := int(c & 0x7f)
+	for c&0x80 != 0 {
+		if offset >= len(data) {
+			return 0, offset, fmt.Errorf("reading path prefix: index is truncated")
+		}
+		c = data[offset]
+		offset++
+		value = ((value + 1) << 7) | int(c&0x7f)
+	}
+	return value, offset, nil
+}
+
+// Encode index v4 varint - inverse of readIndexVarint
+func encodeIndexVarint(value int) []byte {
+	var varint [16]byte
+	pos := len(varint) - 1
+	varint[pos] = byte(value & 0x7f)
+	for value >>= 7; value > 0; value >>= 7 {
+		value--
+		pos--
+		varint[pos] = 0x80 | byte(value&0x7f)
+	}
+	return append([]byte(nil), varint[pos:]...)
+}
+
+// Creates Tree struct based on provided IndexEntries from .git/index
+func makeDirTree(indexEntries []IndexEntry) *TreeNode {
+	root := &TreeNode{
+		Children: make(map[string]*TreeNode),
+		Mode:     40000,
+	}
+
+	root.Name = "root"
+	root.IsDir = true
+
+	for _, entry := range indexEntries {
+		// Intent-to-add entries only mark the path as tracked - they are not in the tree until really added
+		if entry.IntentToAdd {
+			continue
+		}
+		insertInTree(root, entry.Path, &entry)
+	}
+
+	return root
+}
+
+// Insert object on right place in the tree based on path - recursive
+func insertInTree(root *TreeNode, path string, entry *IndexEntry) {
+	// Get path parts by string splitting
+	pathParts := strings.Split(path, "/")
+
+	// Next path will not include current dir (if provided path is /app/src/text.txt - nextPath would be /src/text.txt)
+	nextPath := strings.Join(pathParts[1:], "/")
+
+	// If directory already exists - no need to create it
+	if _, ok := root.Children[pathParts[0]]; ok {
+		insertInTree(root.Children[pathParts[0]], nextPath, entry)
+		return
+	}
+
+	// Create new TreeNode and populate it
+	newNode := &TreeNode{
+		Children: make(map[string]*TreeNode),
+		Name:     pathParts[0],
+	}
+
+	// If path only has 1 element, that means that we are at 'leaf node' - STOPPING POINT in recursion
+	if len(pathParts) == 1 {
+		newNode.Hash = entry.Hash
+		newNode.IsDir = false
+		newNode.Mode = entry.Mode
+		root.Children[pathParts[0]] = newNode
+		return
+	}
+
+	newNode.IsDir = true
+	newNode.Mode = 40000
+	root.Children[pathParts[0]] = newNode
+	insertInTree(root.Children[pathParts[0]], nextPath, entry)
+}
+
+// Traverse down the Tree using DFS and prints each node name, hash and mode
+func printTree(root *TreeNode) {
+	if root == nil {
+		return
+	}
+
+	for _, child := range root.Children {
+		printTree(child)
+	}
+	fmt.Printf("Name: %s, hash: %x, mode: %o\n", root.Name, root.Hash, root.Mode)
+}
+
+// It will recursively create deepest subdirectories first, and then move up...
+func dfsTreeCreation(root *TreeNode) error {
+
+	// If it is a file - can't go deeper
+	if len(root.Children) == 0 {
+		return nil
+	}
+
+	// Create each subdirectory first
+	for _, child := range root.Children {
+		if child.IsDir {
+			if err := dfsTreeCreation(child); err != nil {
+				return err
+			}
+		}
+	}
+
+	// At this moment, we know that each sub-file/dir is already created
+	hash, err := createTree(root)
+	if err != nil {
+		return err
+	}
+
+	root.Hash = hash
+	return nil
+}
+
+// Creates compressed tree object and return its hash
+func createTree(root *TreeNode) ([]byte, error) {
+	// Tree content will consist of its children
+	treeContent := createTreeContent(root.Children)
+	//
+	treeByteObject := generateObjectByte("tree", treeContent)
+	hash, err := writeObject(treeByteObject)
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Iterates over childer hashMap and creates tree content (for each child: <mode> <type> <sha1_hash> <name>)
+func createTreeContent(children map[string]*TreeNode) []byte {
+	var content []byte
+	var keys []string
+	for name := range children {
+		keys = append(keys, name)
+	}
+	// Git sorts directories as if their name ended with "/" (so "a.txt" comes before directory "a")
+	sortKey := func(name string) string {
+		if children[name].IsDir {
+			return name + "/"
+		}
+		return name
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return sortKey(keys[i]) < sortKey(keys[j])
+	})
+
+	for _, name := range keys {
+		child := children[name]
+
+		var modeStr string
+		if child.IsDir {
+			modeStr = "40000"
+		} else {
+			modeStr = fmt.Sprintf("%06o", child.Mode)
+		}
+
+		entryHeader := fmt.Sprintf("%s %s", modeStr, child.Name)
+		content = append(content, []byte(entryHeader)...)
+		content = append(content, 0)
+
+		content = append(content, child.Hash[:]...)
+
+	}
+
+	return content
+}
+
+// Creates a content for commit object with provided treeHash, commitMessage and parentHash - it uses hardcoded vals for username and email
+func createCommitContent(treeHash, commitMessage, parentHash string) []byte {
+	authorName := "obradovicsl"
+	authorEmail := "slobodanobradovic3@gmail.com"
+	now := time.Now()
+	timestamp := now.Unix()
+	timezoneOffset := now.Format("-0700") // Git-style timezone
+
+	content := ""
+	content += fmt.Sprintf("tree %s\n", treeHash)
+	if parentHash != "" {
+		content += fmt.Sprintf("parent %s\n", parentHash)
+	}
+
+	content += fmt.Sprintf("author %s <%s> %d %s\n", authorName, authorEmail, timestamp, timezoneOffset)
+	content += fmt.Sprintf("committer %s <%s> %d %s\n", authorName, authorEmail, timestamp, timezoneOffset)
+	content += "\n"
+	content += commitMessage
+	content += "\n"
+
+	return []byte(content)
+}
+
+// Generate all files from provided branch
+func renderFilesFromCommit(ctx context.Context, branchHash string) error {
+	defer tracePerformance("checkout")()
+	_, _, commit, err := readObjectFromHash(branchHash)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD commit (%s): %w", branchHash, err)
+	}
+
+	lines := strings.Split(string(commit), "\n")
+	var treeHash string
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "tree") {
+			treeHash = strings.TrimPrefix(line, "tree ")
+			break
+		}
+	}
+
+	if treeHash == "" {
+		return fmt.Errorf("tree hash not found in commit")
+	}
+
+	converter, err := newEolConverter()
+	if err != nil {
+		return err
+	}
+	sparse, err := loadSparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	return renderTreeRecursive(ctx, treeHash, "", converter, sparse)
+}
+
+// Check out one blob - files at or above core.bigFileThreshold are streamed
+func renderBlob(entry TreeEntry, relPath string, converter *EolConverter) error {
+	stream, err := openObjectStream(entry.Hash)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if stream.Type != "blob" {
+		return fmt.Errorf("expected blob, got %s", stream.Type)
+	}
+
+	if entry.Mode != "120000" && stream.Size >= bigFileThreshold() {
+		return writeWorkTreeFileFromStream(relPath, entry.Mode, stream)
+	}
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+	return writeWorkTreeFile(relPath, entry.Mode, content, converter)
+}
+
+// Render the whole tree recursively - dirPath is relative to work tree root
+// Paths excluded by sparse checkout are skipped (sparse is nil when everything is checked out)
+func renderTreeRecursive(ctx context.Context, treeHash, dirPath string, converter *EolConverter, sparse *SparseCheckout) error {
+	objType, _, content, err := readObjectFromHash(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %s: %w", treeHash, err)
+	}
+	if objType != "tree" {
+		return fmt.Errorf("object %s is not a tree", treeHash)
+	}
+
+	// content of a directory (files/dirs)
+	entries, err := parseTreeContent(content)
+	if err != nil {
+		return fmt.Errorf("cannot parse tree %s: %w", treeHash, err)
+	}
+	if err := verifyTreeEntries(dirPath, entries); err != nil {
+		return err
+	}
+
+	// .gitattributes has to be on disk before other files in the same directory are converted
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name == ".gitattributes" && entries[j].Name != ".gitattributes"
+	})
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath := path.Join(dirPath, entry.Name)
+
+		if entry.Mode == "40000" {
+			// directory - with sparse checkout it is created when its first file is written
+			if sparse == nil {
+				if err := createWorkTreeDir(relPath); err != nil {
+					return err
+				}
+			}
+			if err := renderTreeRecursive(ctx, entry.Hash, relPath, converter, sparse); err != nil {
+				return err
+			}
+		} else if !sparse.includes(relPath) {
+			continue
+		} else if entry.Mode == "160000" {
+			// gitlink (submodule) - hash is a commit in another repository, so only an empty directory is created
+			if err := createWorkTreeDir(relPath); err != nil {
+				return err
+			}
+		} else {
+			// blob (file or symlink) - big files are copied straight from the object stream
+			if err := renderBlob(entry, relPath, converter); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// daemon - read-only git:// server (git daemon). Client opens TCP connection (port 9418 by default) and sends
+// one pkt-line request:
+//
+//	git-upload-pack <path>\0host=<host>\0
+//
+// and the rest of the connection is upload-pack talking to it. Only upload-pack is served.
+//
+// Path is looked up below --base-path (as it is when there is none), trying <path>, <path>.git/.git,
+// <path>/.git and <path>.git, in git daemon's order. The repository is exported only if its git directory
+// has a git-daemon-export-ok file (or with --export-all), and when directories are given, only repositories
+// inside them are served. Refused requests get "ERR access denied or repository not exported: <path>",
+// like from git daemon.
+//
+// Every connection runs upload-pack as a child process (see serviceCommand), so repository state isn't shared.
+
+// Time client has to send its request line in
+const daemonRequestTimeout = 30 * time.Second
+
+// Serve repositories over git:// until ctx is canceled - connections are logged to w
+func (c *RepoContext) runDaemon(ctx context.Context, options DaemonOptions, w io.Writer) error {
+	if options.BasePath != "" {
+		options.BasePath = c.path(options.BasePath)
+	}
+	for i, dir := range options.Directories {
+		options.Directories[i] = c.path(dir)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", options.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", options.Port, err)
+	}
+	fmt.Fprintf(w, "Ready to rumble on git://localhost:%d/\n", listener.Addr().(*net.TCPAddr).Port)
+	context.AfterFunc(ctx, func() { listener.Close() })
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		go c.serveDaemonConnection(ctx, conn, options, w)
+	}
+}
+
+// Read request line and hand the connection to upload-pack
+func (c *RepoContext) serveDaemonConnection(ctx context.Context, conn net.Conn, options DaemonOptions, w io.Writer) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(daemonRequestTimeout))
+	payload, kind, err := readPktPayload(reader)
+	if err != nil || kind != PKT_DATA {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// "<service> <path>\0host=<host>\0" - extra parameters (host, version) are ignored
+	request, _, _ := strings.Cut(string(payload), "\x00")
+	service, path, _ := strings.Cut(strings.TrimSuffix(request, "\n"), " ")
+	fmt.Fprintf(w, "Connection from %s: %s %s\n", conn.RemoteAddr(), service, path)
+	if service != "git-upload-pack" {
+		writePktLine(conn, "ERR service not enabled: '"+service+"'\n")
+		return
+	}
+
+	repoDir, ok := resolveDaemonPath(path, options)
+	if !ok {
+		writePktLine(conn, "ERR access denied or repository not exported: "+path+"\n")
+		return
+	}
+
+	cmd := serviceCommand(ctx, c.Program, "upload-pack", repoDir)
+	cmd.Env = serviceEnvironment()
+	// Request line may have been read together with what followed it - reader still holds that
+	cmd.Stdin = reader
+	cmd.Stdout = conn
+	cmd.Stderr = os.Stderr
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(w, "upload-pack for %s failed: %v\n", path, err)
+	}
+}
+
+// Repository directory for requested path - false when it doesn't exist, isn't exported or isn't
+// inside the allowed directories
+func resolveDaemonPath(path string, options DaemonOptions) (string, bool) {
+	if !strings.HasPrefix(path, "/") || strings.Contains(path, "/../") || strings.HasSuffix(path, "/..") {
+		return "", false
+	}
+	if options.BasePath != "" {
+		path = filepath.Join(options.BasePath, path)
+	}
+
+	for _, candidate := range []string{path, path + ".git/.git", path + "/.git", path + ".git"} {
+		candidate = filepath.Clean(candidate)
+		if !isGitDirectory(candidate) {
+			continue
+		}
+		repoDir := candidate
+		if filepath.Base(candidate) == ".git" {
+			repoDir = filepath.Dir(candidate)
+		}
+
+		if !options.ExportAll {
+			if _, err := os.Stat(filepath.Join(candidate, "git-daemon-export-ok")); err != nil {
+				return "", false
+			}
+		}
+		if len(options.Directories) == 0 {
+			return repoDir, true
+		}
+		for _, dir := range options.Directories {
+			if repoDir == dir || strings.HasPrefix(repoDir, dir+string(filepath.Separator)) {
+				return repoDir, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
@@ -0,0 +1,264 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dates - approximate dates (log --since/--until) are absolute or relative to now, as git's approxidate
+// takes them:
+//
+//	2006-01-02 15:04:05 [-0700]   also with "T" between date and time, without seconds or without the time -
+//	                              a missing time of day is the current one (as with git)
+//	2006/01/02, 2006.01.02        dates only
+//	@1136214245, <timestamp> <tz>, RFC 2822 dates
+//	now, yesterday
+//	never, all                    only as expiry dates - nothing expires, everything expires
+//	3.weeks.ago, 2 days ago       seconds, minutes, hours, days, weeks, months and years ("ago" is optional)
+//
+// Dates are shown (log --date, log.date) in git's styles - default "Mon Jan 2 15:04:05 2006 -0700", iso,
+// iso-strict, rfc, short, raw, unix, relative ("3 hours ago") or format:<strftime format>.
+
+// Layouts of absolute dates with time of day
+var approxDateLayouts = []string{
+	"2006-01-02 15:04:05 -0700", "2006-01-02T15:04:05 -0700", "2006-01-02T15:04:05-07:00", "2006-01-02T15:04:05Z",
+	"2006-01-02 15:04 -0700", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02T15:04",
+}
+
+// Layouts of absolute dates without time of day
+var approxDayLayouts = []string{"2006-01-02", "2006/01/02", "2006.01.02"}
+
+// Parse approximate date - relative dates count back from now
+func parseApproxDate(value string, now time.Time) (time.Time, error) {
+	text := strings.TrimSpace(value)
+	switch strings.ToLower(text) {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+	for _, layout := range approxDateLayouts {
+		if when, err := time.ParseInLocation(layout, text, now.Location()); err == nil {
+			return when, nil
+		}
+	}
+	for _, layout := range approxDayLayouts {
+		if day, err := time.ParseInLocation(layout, text, now.Location()); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), now.Second(), 0, now.Location()), nil
+		}
+	}
+	if timestamp, ok := strings.CutPrefix(text, "@"); ok {
+		if seconds, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+			return time.Unix(seconds, 0).In(now.Location()), nil
+		}
+	}
+	if when, ok := parseRelativeDate(strings.ToLower(text), now); ok {
+		return when, nil
+	}
+	if when, err := parseSignatureDate(value); err == nil {
+		return when, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
+
+// Parse expiry date of gc.pruneExpire and similar settings - "never" (or "false") gives the zero time, which
+// expires nothing; "all" expires everything, even what has a date in the future
+func parseExpiryDate(value string, now time.Time) (time.Time, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "never", "false":
+		return time.Time{}, nil
+	case "all":
+		return time.Unix(1<<62, 0), nil
+	}
+	return parseApproxDate(value, now)
+}
+
+// Parse "<n> <unit>[s] [ago]" (words separated by dots or spaces)
+func parseRelativeDate(text string, now time.Time) (time.Time, bool) {
+	words := strings.FieldsFunc(text, func(r rune) bool { return r == '.' || r == ' ' || r == '_' })
+	if len(words) > 0 && words[len(words)-1] == "ago" {
+		words = words[:len(words)-1]
+	}
+	if len(words) != 2 {
+		return time.Time{}, false
+	}
+	count, err := strconv.Atoi(words[0])
+	if err != nil || count < 0 {
+		return time.Time{}, false
+	}
+	switch strings.TrimSuffix(words[1], "s") {
+	case "second":
+		return now.Add(-time.Duration(count) * time.Second), true
+	case "minute":
+		return now.Add(-time.Duration(count) * time.Minute), true
+	case "hour":
+		return now.Add(-time.Duration(count) * time.Hour), true
+	case "day":
+		return now.AddDate(0, 0, -count), true
+	case "week":
+		return now.AddDate(0, 0, -7*count), true
+	case "month":
+		return now.AddDate(0, -count, 0), true
+	case "year":
+		return now.AddDate(-count, 0, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Check date style of --date and log.date - default, relative, local, iso (iso8601), iso-strict
+// (iso8601-strict), rfc (rfc2822), short, raw, unix or format:<strftime format>; all but relative and unix
+// take a "-local" suffix
+func validDateStyle(style string) bool {
+	if strings.HasPrefix(style, "format:") || style == "relative" || style == "unix" || style == "local" {
+		return true
+	}
+	switch strings.TrimSuffix(style, "-local") {
+	case "", "default", "iso", "iso8601", "iso-strict", "iso8601-strict", "rfc", "rfc2822", "short", "raw":
+		return true
+	}
+	return false
+}
+
+// Format date in style (see validDateStyle) - relative dates are relative to now
+func formatDate(when time.Time, style string, now time.Time) string {
+	if format, ok := strings.CutPrefix(style, "format:"); ok {
+		return strftime(format, when)
+	}
+	if style == "local" {
+		return when.Local().Format("Mon Jan 2 15:04:05 2006")
+	}
+	if base, ok := strings.CutSuffix(style, "-local"); ok {
+		when, style = when.Local(), base
+	}
+	switch style {
+	case "relative":
+		return formatRelativeDate(when, now)
+	case "unix":
+		return strconv.FormatInt(when.Unix(), 10)
+	case "raw":
+		return fmt.Sprintf("%d %s", when.Unix(), when.Format("-0700"))
+	case "iso", "iso8601":
+		return when.Format("2006-01-02 15:04:05 -0700")
+	case "iso-strict", "iso8601-strict":
+		return when.Format("2006-01-02T15:04:05-07:00")
+	case "rfc", "rfc2822":
+		return when.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	case "short":
+		return when.Format("2006-01-02")
+	}
+	return when.Format(logDateFormat)
+}
+
+// Format how long before now the date was, rounded as git does ("3 hours ago", "2 years, 1 month ago")
+func formatRelativeDate(when, now time.Time) string {
+	if when.After(now) {
+		return "in the future"
+	}
+	diff := int64(now.Sub(when) / time.Second)
+	if diff < 90 {
+		return pluralAgo(diff, "second")
+	}
+	if diff = (diff + 30) / 60; diff < 90 {
+		return pluralAgo(diff, "minute")
+	}
+	if diff = (diff + 30) / 60; diff < 36 {
+		return pluralAgo(diff, "hour")
+	}
+	if diff = (diff + 12) / 24; diff < 14 {
+		return pluralAgo(diff, "day")
+	}
+	if diff < 70 {
+		return pluralAgo((diff+3)/7, "week")
+	}
+	if diff < 365 {
+		return pluralAgo((diff+15)/30, "month")
+	}
+	if diff < 1825 {
+		months := (diff*12*2 + 365) / (365 * 2)
+		years := fmt.Sprintf("%d %s", months/12, plural(int(months/12), "year", "years"))
+		if months%12 == 0 {
+			return years + " ago"
+		}
+		return fmt.Sprintf("%s, %s", years, pluralAgo(months%12, "month"))
+	}
+	return pluralAgo((diff+183)/365, "year")
+}
+
+func pluralAgo(count int64, unit string) string {
+	return fmt.Sprintf("%d %s ago", count, plural(int(count), unit, unit+"s"))
+}
+
+// Format date with strftime conversions (%Y, %m, %d, %H, %M, %S, %a, %b, %e, %j, %z, %Z, ...) - unknown
+// ones are kept as they are
+func strftime(format string, when time.Time) string {
+	var text strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			text.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&text, "%04d", when.Year())
+		case 'y':
+			fmt.Fprintf(&text, "%02d", when.Year()%100)
+		case 'm':
+			fmt.Fprintf(&text, "%02d", int(when.Month()))
+		case 'd':
+			fmt.Fprintf(&text, "%02d", when.Day())
+		case 'e':
+			fmt.Fprintf(&text, "%2d", when.Day())
+		case 'j':
+			fmt.Fprintf(&text, "%03d", when.YearDay())
+		case 'H':
+			fmt.Fprintf(&text, "%02d", when.Hour())
+		case 'I':
+			fmt.Fprintf(&text, "%02d", (when.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&text, "%02d", when.Minute())
+		case 'S':
+			fmt.Fprintf(&text, "%02d", when.Second())
+		case 'p':
+			text.WriteString(when.Format("PM"))
+		case 'a':
+			text.WriteString(when.Format("Mon"))
+		case 'A':
+			text.WriteString(when.Format("Monday"))
+		case 'b', 'h':
+			text.WriteString(when.Format("Jan"))
+		case 'B':
+			text.WriteString(when.Format("January"))
+		case 'u':
+			fmt.Fprintf(&text, "%d", (int(when.Weekday())+6)%7+1)
+		case 'w':
+			fmt.Fprintf(&text, "%d", int(when.Weekday()))
+		case 's':
+			fmt.Fprintf(&text, "%d", when.Unix())
+		case 'z':
+			text.WriteString(when.Format("-0700"))
+		case 'Z':
+			text.WriteString(when.Format("MST"))
+		case 'F':
+			text.WriteString(when.Format("2006-01-02"))
+		case 'T':
+			text.WriteString(when.Format("15:04:05"))
+		case 'R':
+			text.WriteString(when.Format("15:04"))
+		case 'D':
+			text.WriteString(when.Format("01/02/06"))
+		case 'n':
+			text.WriteByte('\n')
+		case 't':
+			text.WriteByte('\t')
+		case '%':
+			text.WriteByte('%')
+		default:
+			text.WriteByte('%')
+			text.WriteByte(format[i])
+		}
+	}
+	return text.String()
+}
@@ -0,0 +1,146 @@
+package git
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Decorations (log --decorate) - refs pointing to a commit are shown after its hash:
+//
+//	commit 1a2b3c4... (HEAD -> master, tag: v1.0, origin/master, topic)
+//
+// HEAD comes first - "HEAD -> <branch>" when it is on a branch pointing to the commit (the branch isn't
+// listed again) - then the other refs in reverse name order, as git shows them. Branches, remote-tracking
+// branches, tags (annotated ones decorate the commit they tag) and refs/stash are shown; names are short
+// ("tag: v1.0", "origin/master") or, with --decorate=full, full refnames. Without --decorate, log.decorate
+// decides: short, full, no, or auto (the default) - short when output goes to a terminal.
+
+// Prefixes of decorating refs, with their color slot (color.decorate.<slot>)
+var decorationSlots = map[string]string{
+	"refs/heads/":   "branch",
+	"refs/remotes/": "remoteBranch",
+	"refs/tags/":    "tag",
+	"refs/stash":    "stash",
+}
+
+// Decoration style of log - flag is the --decorate value ("" when not given); returns short, full or no
+func decorationStyle(flag string) (string, error) {
+	style := flag
+	if style == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		style = "auto"
+		if value, ok := config.Get("log.decorate"); ok {
+			style = value
+		}
+	}
+
+	switch strings.ToLower(style) {
+	case "short", "full", "no":
+		return strings.ToLower(style), nil
+	case "auto":
+		if stdoutIsTerminal() {
+			return "short", nil
+		}
+		return "no", nil
+	}
+	if flag != "" {
+		return "", fmt.Errorf("invalid --decorate option: %s", flag)
+	}
+	if parseBoolValue(style, false) {
+		return "short", nil
+	}
+	return "no", nil
+}
+
+// Load refs decorating commits, as commit hash -> refnames in the order they are shown ("HEAD" first), and
+// the branch HEAD is on ("" when detached)
+func loadDecorations() (map[string][]string, string, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, "", err
+	}
+	decorations := make(map[string][]string)
+	names := sortedKeys(refs)
+	slices.Reverse(names)
+	for _, name := range names {
+		if decorationSlot(name) == "" {
+			continue
+		}
+		hash := refs[name]
+		if peeled, err := peelTag(hash); err != nil {
+			return nil, "", err
+		} else if peeled != "" {
+			hash = peeled
+		}
+		decorations[hash] = append(decorations[hash], name)
+	}
+
+	headBranch, headHash, err := readHead()
+	if err != nil {
+		return nil, "", err
+	}
+	if headHash != "" {
+		decorations[headHash] = append([]string{"HEAD"}, decorations[headHash]...)
+	}
+	return decorations, headBranch, nil
+}
+
+// Color slot of decorating ref - "" for refs that don't decorate
+func decorationSlot(refName string) string {
+	if refName == "HEAD" {
+		return "HEAD"
+	}
+	for prefix, slot := range decorationSlots {
+		if strings.HasPrefix(refName, prefix) && (refName == prefix || strings.HasSuffix(prefix, "/")) {
+			return slot
+		}
+	}
+	return ""
+}
+
+// Format decorations of a commit as " (HEAD -> master, tag: v1)" - "" without refs; full keeps full
+// refnames, colors are log colors (nil when not colored)
+func formatDecorations(refNames []string, headBranch string, full bool, colors map[string]string) string {
+	return formatDecorationList(refNames, headBranch, full, colors, " (", ")")
+}
+
+// Format decorations between prefix and suffix (colored as the commit line, even when empty - as in git)
+func formatDecorationList(refNames []string, headBranch string, full bool, colors map[string]string, prefix, suffix string) string {
+	if len(refNames) == 0 {
+		return ""
+	}
+	// Branch HEAD is on is shown with HEAD
+	current := ""
+	if refNames[0] == "HEAD" && slices.Contains(refNames, headBranch) {
+		current = headBranch
+	}
+
+	var parts []string
+	for _, name := range refNames {
+		if name == current {
+			continue
+		}
+		slot := decorationSlot(name)
+		text := name
+		if !full {
+			text = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(name, "refs/heads/"), "refs/remotes/"), "refs/tags/")
+		}
+		if slot == "tag" {
+			text = "tag: " + text
+		}
+		if name == "HEAD" && current != "" {
+			currentText := current
+			if !full {
+				currentText = strings.TrimPrefix(current, "refs/heads/")
+			}
+			parts = append(parts, colorize(colors, slot, text+" -> ")+colorize(colors, "branch", currentText))
+			continue
+		}
+		parts = append(parts, colorize(colors, slot, text))
+	}
+	return colorize(colors, "commit", prefix) + strings.Join(parts, colorize(colors, "commit", ", ")) + colorize(colors, "commit", suffix)
+}
@@ -0,0 +1,490 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Diff engine - line diff (Myers algorithm, others are in diffalgorithm.go), unified hunks, tree to tree
+// changes and diffstat
+//
+// Myers finds the shortest edit script by exploring diagonals k = x - y for growing number of edits d;
+// V[k] keeps the furthest x reached on diagonal k. V of every round is kept, so the path can be
+// walked back from the end once both sequences are consumed.
+
+// Lines of unified diff context around every change
+const diffContextLines = 3
+
+// Snapshots of V kept while searching - above this many entries, the rest of the files is diffed as
+// a plain replacement (very different big files would otherwise need a lot of memory)
+const maxDiffTraceSize = 1 << 24
+
+// Split content into lines that keep their newline (last line may not have one)
+func splitLines(content []byte) []string {
+	var lines []string
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n')
+		if end == -1 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:end+1]))
+		content = content[end+1:]
+	}
+	return lines
+}
+
+// Line diff of a and b - common prefix and suffix are cut before searching
+func diffLines(a, b []string) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []DiffLine
+	for _, line := range a[:prefix] {
+		lines = append(lines, DiffLine{Kind: ' ', Text: line})
+	}
+	lines = append(lines, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, DiffLine{Kind: ' ', Text: line})
+	}
+	return lines
+}
+
+func myersDiff(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replacementDiff(a, b)
+	}
+
+	// trace[d] is V before round d, only diagonals -d-1..d+1 (index k+d+1)
+	maxEdits := n + m
+	offset := maxEdits + 1
+	v := make([]int, 2*maxEdits+3)
+	var trace [][]int
+	traceSize := 0
+	for d := 0; d <= maxEdits; d++ {
+		snapshot := make([]int, 2*d+3)
+		for k := -d - 1; k <= d+1; k++ {
+			snapshot[k+d+1] = v[offset+k]
+		}
+		trace = append(trace, snapshot)
+		traceSize += len(snapshot)
+		if traceSize > maxDiffTraceSize {
+			return replacementDiff(a, b)
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(trace, a, b)
+			}
+		}
+	}
+	return replacementDiff(a, b)
+}
+
+// Walk back from (len(a), len(b)) through the kept V rounds - every round is one insertion or deletion
+// preceded by a snake of equal lines
+func backtrackDiff(trace [][]int, a, b []string) []DiffLine {
+	var reversed []DiffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffLine{Kind: ' ', Text: a[x-1]})
+			x--
+			y--
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			reversed = append(reversed, DiffLine{Kind: '+', Text: b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, DiffLine{Kind: '-', Text: a[x-1]})
+			x--
+		}
+	}
+
+	lines := make([]DiffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(reversed)-1-i] = line
+	}
+	return lines
+}
+
+// Every line of a removed, every line of b added
+func replacementDiff(a, b []string) []DiffLine {
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for _, line := range a {
+		lines = append(lines, DiffLine{Kind: '-', Text: line})
+	}
+	for _, line := range b {
+		lines = append(lines, DiffLine{Kind: '+', Text: line})
+	}
+	return lines
+}
+
+// Group diff lines into hunks - changes closer than 2*context lines share a hunk
+func buildHunks(lines []DiffLine, context int) []DiffHunk {
+	// Line numbers (0-based) in old and new file before lines[i]
+	oldBefore := make([]int, len(lines)+1)
+	newBefore := make([]int, len(lines)+1)
+	for i, line := range lines {
+		oldBefore[i+1], newBefore[i+1] = oldBefore[i], newBefore[i]
+		if line.Kind != '+' {
+			oldBefore[i+1]++
+		}
+		if line.Kind != '-' {
+			newBefore[i+1]++
+		}
+	}
+
+	var hunks []DiffHunk
+	for i := 0; i < len(lines); {
+		for i < len(lines) && lines[i].Kind == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		start := max(i-context, 0)
+		end := i
+		for {
+			for end < len(lines) && lines[end].Kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(lines) && lines[next].Kind == ' ' {
+				next++
+			}
+			if next < len(lines) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end = min(end+context, len(lines))
+			break
+		}
+
+		hunk := DiffHunk{
+			OldStart: oldBefore[start] + 1,
+			OldLines: oldBefore[end] - oldBefore[start],
+			NewStart: newBefore[start] + 1,
+			NewLines: newBefore[end] - newBefore[start],
+			Lines:    lines[start:end],
+		}
+		// Empty side points to the line before the hunk
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		hunks = append(hunks, hunk)
+		i = end
+	}
+	return hunks
+}
+
+// Files that differ between two trees (empty hash means empty tree), sorted by path - renames and copies
+// are detected as options say (options must be resolved)
+func diffTrees(oldTree, newTree string, options DiffOptions) ([]FileChange, error) {
+	oldFiles := make(map[string]TreeEntry)
+	if oldTree != "" {
+		if err := flattenTree(oldTree, "", oldFiles); err != nil {
+			return nil, err
+		}
+	}
+	newFiles := make(map[string]TreeEntry)
+	if newTree != "" {
+		if err := flattenTree(newTree, "", newFiles); err != nil {
+			return nil, err
+		}
+	}
+	return diffFileSets(oldFiles, newFiles, options)
+}
+
+// Files that differ between two sets of files (path -> entry, like flattenTree builds), sorted by path
+func diffFileSets(oldFiles, newFiles map[string]TreeEntry, options DiffOptions) ([]FileChange, error) {
+	var changes []FileChange
+	for path, old := range oldFiles {
+		entry, ok := newFiles[path]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Status: 'D', OldPath: path, NewPath: path, OldMode: old.Mode, OldHash: old.Hash})
+		case entry.Hash != old.Hash || entry.Mode != old.Mode:
+			changes = append(changes, FileChange{Status: 'M', OldPath: path, NewPath: path, OldMode: old.Mode, NewMode: entry.Mode, OldHash: old.Hash, NewHash: entry.Hash})
+		}
+	}
+	for path, entry := range newFiles {
+		if _, ok := oldFiles[path]; !ok {
+			changes = append(changes, FileChange{Status: 'A', OldPath: path, NewPath: path, NewMode: entry.Mode, NewHash: entry.Hash})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].NewPath < changes[j].NewPath
+	})
+	return detectRenames(changes, oldFiles, options)
+}
+
+// Line diffs of every changed file between two trees
+func diffTreeFiles(oldTree, newTree string, options DiffOptions) ([]FileDiff, error) {
+	changes, err := diffTrees(oldTree, newTree, options)
+	if err != nil {
+		return nil, err
+	}
+	return diffChangedFiles(changes, options)
+}
+
+// Line diffs of changed files - lines are matched by options.Algorithm
+func diffChangedFiles(changes []FileChange, options DiffOptions) ([]FileDiff, error) {
+	algorithm, err := lookupDiffAlgorithm(options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := newAttributeMatcher()
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]FileDiff, 0, len(changes))
+	for _, change := range changes {
+		oldContent, err := diffBlobContent(change.OldHash, change.OldMode)
+		if err != nil {
+			return nil, err
+		}
+		newContent, err := diffBlobContent(change.NewHash, change.NewMode)
+		if err != nil {
+			return nil, err
+		}
+
+		diff := FileDiff{Change: change}
+		if isBinaryDiff(attributes, change.NewPath, oldContent, newContent) {
+			diff.Binary = !bytes.Equal(oldContent, newContent)
+			diff.OldContent, diff.NewContent = oldContent, newContent
+		} else {
+			diff.Lines = algorithm(splitLines(oldContent), splitLines(newContent))
+			for _, line := range diff.Lines {
+				switch line.Kind {
+				case '+':
+					diff.Added++
+				case '-':
+					diff.Deleted++
+				}
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// Content of one side of a change - gitlinks are shown as "Subproject commit <hash>", like git does
+func diffBlobContent(hash, mode string) ([]byte, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	if mode == "160000" {
+		return []byte("Subproject commit " + hash + "\n"), nil
+	}
+	return readDiffBlob(hash)
+}
+
+// Blob content for diff - work tree files compared by diff are not in the object store, they come from
+// workTreeBlobs
+func readDiffBlob(hash string) ([]byte, error) {
+	if content, ok := workTreeBlobs[hash]; ok {
+		return content, nil
+	}
+	_, _, content, err := readObjectFromHash(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	return content, nil
+}
+
+// Content with NUL byte in the first 8000 bytes is binary (same check git does)
+func isBinaryContent(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) != -1
+}
+
+// Whether change of path is shown as binary - the diff attribute decides ("-diff", or the binary macro, makes
+// it binary, "diff" text), otherwise content of either side
+func isBinaryDiff(attributes *AttributeMatcher, path string, oldContent, newContent []byte) bool {
+	switch attributes.attribute(path, "diff").State {
+	case ATTR_UNSET:
+		return true
+	case ATTR_SET:
+		return false
+	}
+	return isBinaryContent(oldContent) || isBinaryContent(newContent)
+}
+
+// Write git style diff of one file - header, mode lines, index line and hunks (line by line, or words
+// as options.WordDiff says), colored with options.Colors
+func writeFileDiff(w io.Writer, diff FileDiff, options DiffOptions) {
+	colors := options.Colors
+	meta := func(format string, args ...any) {
+		io.WriteString(w, colorize(colors, "meta", fmt.Sprintf(format, args...))+"\n")
+	}
+
+	// Binary patch names its blobs in full - apply checks them
+	binaryPatch := diff.Binary && options.BinaryPatch
+	indexHash := shortHash
+	if binaryPatch {
+		indexHash = func(hash string) string {
+			if hash == "" {
+				return zeroHash
+			}
+			return hash
+		}
+	}
+
+	change := diff.Change
+	meta("diff --git a/%s b/%s", change.OldPath, change.NewPath)
+
+	switch change.Status {
+	case 'A':
+		meta("new file mode %s", change.NewMode)
+		meta("index %s..%s", indexHash(""), indexHash(change.NewHash))
+	case 'D':
+		meta("deleted file mode %s", change.OldMode)
+		meta("index %s..%s", indexHash(change.OldHash), indexHash(""))
+	default:
+		if change.OldMode != change.NewMode {
+			meta("old mode %s", change.OldMode)
+			meta("new mode %s", change.NewMode)
+		}
+		if change.Status == 'R' || change.Status == 'C' {
+			kind := "rename"
+			if change.Status == 'C' {
+				kind = "copy"
+			}
+			meta("similarity index %d%%", change.Similarity)
+			meta("%s from %s", kind, change.OldPath)
+			meta("%s to %s", kind, change.NewPath)
+		}
+		if change.OldHash == change.NewHash {
+			// Only mode changed (or file moved as it is)
+			return
+		}
+		if change.OldMode == change.NewMode {
+			meta("index %s..%s %s", indexHash(change.OldHash), indexHash(change.NewHash), change.NewMode)
+		} else {
+			meta("index %s..%s", indexHash(change.OldHash), indexHash(change.NewHash))
+		}
+	}
+
+	oldName, newName := "a/"+change.OldPath, "b/"+change.NewPath
+	if change.Status == 'A' {
+		oldName = "/dev/null"
+	}
+	if change.Status == 'D' {
+		newName = "/dev/null"
+	}
+	if binaryPatch {
+		writeBinaryPatch(w, diff.OldContent, diff.NewContent)
+		return
+	}
+	if diff.Binary {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return
+	}
+	if len(diff.Lines) == 0 || diff.Added+diff.Deleted == 0 {
+		return
+	}
+
+	meta("--- %s%s", oldName, pathTerminator(oldName))
+	meta("+++ %s%s", newName, pathTerminator(newName))
+	for _, hunk := range buildHunks(diff.Lines, diffContextLines) {
+		header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(hunk.OldStart, hunk.OldLines), hunkRange(hunk.NewStart, hunk.NewLines))
+		io.WriteString(w, colorize(colors, "frag", header)+"\n")
+
+		if options.WordDiff != "" {
+			writeWordDiff(w, hunk.Lines, options)
+			continue
+		}
+		for _, line := range hunk.Lines {
+			writeDiffLine(w, line, colors)
+		}
+	}
+}
+
+// Write one hunk line - colored like git does it: the "+" sign apart from the added text (so whitespace
+// errors could be highlighted in between), the reset even after uncolored context
+func writeDiffLine(w io.Writer, line DiffLine, colors map[string]string) {
+	text := strings.TrimSuffix(line.Text, "\n")
+	switch {
+	case colors == nil:
+		w.Write([]byte{line.Kind})
+		io.WriteString(w, text)
+	case line.Kind == '+':
+		io.WriteString(w, colorize(colors, "new", "+"))
+		if text != "" {
+			io.WriteString(w, colorize(colors, "new", text))
+		}
+	case line.Kind == '-':
+		io.WriteString(w, colorize(colors, "old", "-"+text))
+	default:
+		io.WriteString(w, colorize(colors, "context", " "+text))
+	}
+	io.WriteString(w, "\n")
+	if !strings.HasSuffix(line.Text, "\n") {
+		io.WriteString(w, colorize(colors, "context", "\\ No newline at end of file")+"\n")
+	}
+}
+
+// Names with spaces get a trailing tab in ---/+++ lines, so patch tools know where they end
+func pathTerminator(name string) string {
+	if strings.Contains(name, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+// Hunk range - count is left out when it is 1
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// Abbreviated object hash (7 characters), zeros for missing side
+func shortHash(hash string) string {
+	if hash == "" {
+		return "0000000"
+	}
+	return hash[:min(len(hash), 7)]
+}
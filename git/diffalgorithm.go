@@ -0,0 +1,222 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diff algorithms (--diff-algorithm, diff.algorithm):
+//
+//	myers      shortest edit script (also "default" and "minimal" - Myers here is always minimal)
+//	patience   lines that are unique in both files and appear in the same order anchor the diff; the parts
+//	           between anchors are diffed again the same way (Myers when they have no unique lines)
+//	histogram  the longest common run around the lines that occur least often in the old file anchors
+//	           the diff, and both sides of it are diffed again (Myers when every line repeats too much)
+//
+// Patience and histogram keep moved blocks (reordered functions) together where Myers would match their
+// braces and blank lines with each other. They follow git's xdiff, so they pick the same anchors.
+
+// Algorithms by name - Myers runs after the common prefix and suffix are cut, patience and histogram look
+// for anchors in the whole files
+var diffAlgorithms = map[string]diffAlgorithm{
+	"myers":     diffLines,
+	"minimal":   diffLines,
+	"default":   diffLines,
+	"patience":  patienceDiff,
+	"histogram": histogramDiff,
+}
+
+// Occurrences of a line in the old file above which histogram diff doesn't use it as anchor
+const histogramMaxChain = 64
+
+// Algorithm of options.Algorithm ("" is Myers)
+func lookupDiffAlgorithm(name string) (diffAlgorithm, error) {
+	if name == "" {
+		return diffLines, nil
+	}
+	algorithm, ok := diffAlgorithms[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown diff algorithm: %s", name)
+	}
+	return algorithm, nil
+}
+
+// Patience diff - anchors are the longest run of unique common lines that keeps their order in both files
+func patienceDiff(a, b []string) []DiffLine {
+	if len(a) == 0 || len(b) == 0 {
+		return replacementDiff(a, b)
+	}
+
+	// Lines in the order they first appear in a - indexB is -1 until b has the line, and -2 once the line
+	// is known to repeat on either side
+	type occurrence struct {
+		indexA, indexB int
+	}
+	occurrences := make(map[string]*occurrence)
+	var order []*occurrence
+	for i, line := range a {
+		if entry, ok := occurrences[line]; ok {
+			entry.indexB = -2
+			continue
+		}
+		occurrences[line] = &occurrence{indexA: i, indexB: -1}
+		order = append(order, occurrences[line])
+	}
+	common := false
+	for j, line := range b {
+		entry, ok := occurrences[line]
+		if !ok {
+			continue
+		}
+		common = true
+		if entry.indexB == -1 {
+			entry.indexB = j
+		} else {
+			entry.indexB = -2
+		}
+	}
+	if !common {
+		return replacementDiff(a, b)
+	}
+	var unique [][2]int
+	for _, entry := range order {
+		if entry.indexB >= 0 {
+			unique = append(unique, [2]int{entry.indexA, entry.indexB})
+		}
+	}
+	if len(unique) == 0 {
+		return diffLines(a, b)
+	}
+
+	// Equal lines next to an anchor are matched first (backwards from it), then those after the previous
+	// anchor - what is left in between is diffed again
+	anchors := longestIncreasingRun(unique)
+	var lines []DiffLine
+	nextA, nextB := 0, 0
+	for k := 0; ; k++ {
+		endA, endB := len(a), len(b)
+		if k < len(anchors) {
+			endA, endB = anchors[k][0], anchors[k][1]
+			for endA > nextA && endB > nextB && a[endA-1] == b[endB-1] {
+				endA--
+				endB--
+			}
+		}
+		for nextA < endA && nextB < endB && a[nextA] == b[nextB] {
+			lines = append(lines, DiffLine{Kind: ' ', Text: a[nextA]})
+			nextA++
+			nextB++
+		}
+		if endA > nextA || endB > nextB {
+			lines = append(lines, patienceDiff(a[nextA:endA], b[nextB:endB])...)
+		}
+		if k == len(anchors) {
+			return lines
+		}
+
+		// Anchors right after each other are one common run
+		for k+1 < len(anchors) && anchors[k+1][0] == anchors[k][0]+1 && anchors[k+1][1] == anchors[k][1]+1 {
+			k++
+		}
+		for _, line := range a[endA : anchors[k][0]+1] {
+			lines = append(lines, DiffLine{Kind: ' ', Text: line})
+		}
+		nextA, nextB = anchors[k][0]+1, anchors[k][1]+1
+	}
+}
+
+// Longest subsequence of pairs (ordered by first index) with increasing second index - patience sorting:
+// every pair goes on the leftmost pile whose top is above it, and remembers the top of the pile before
+func longestIncreasingRun(pairs [][2]int) [][2]int {
+	var tops []int
+	previous := make([]int, len(pairs))
+	for i, pair := range pairs {
+		pile := sort.Search(len(tops), func(p int) bool { return pairs[tops[p]][1] > pair[1] })
+		previous[i] = -1
+		if pile > 0 {
+			previous[i] = tops[pile-1]
+		}
+		if pile == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[pile] = i
+		}
+	}
+
+	run := make([][2]int, len(tops))
+	for i, p := len(tops)-1, tops[len(tops)-1]; i >= 0; i, p = i-1, previous[p] {
+		run[i] = pairs[p]
+	}
+	return run
+}
+
+// Histogram diff - for every line of b the runs of equal lines around its occurrences in a are tried; a
+// longer run wins, and so does one whose rarest line occurs less often in a
+func histogramDiff(a, b []string) []DiffLine {
+	if len(a) == 0 || len(b) == 0 {
+		return replacementDiff(a, b)
+	}
+
+	positions := make(map[string][]int)
+	for i, line := range a {
+		positions[line] = append(positions[line], i)
+	}
+
+	// Best run is a[startA:endA] = b[startB:endB], its rarest line occurs bestCount times
+	startA, startB, endA, endB := 0, 0, 0, 0
+	bestCount := histogramMaxChain + 1
+	common := false
+	for j := 0; j < len(b); {
+		next := j + 1
+		candidates := positions[b[j]]
+		if len(candidates) > 0 {
+			common = true
+		}
+		if len(candidates) > bestCount {
+			candidates = nil
+		}
+		for k := 0; k < len(candidates); {
+			runStartA, runStartB := candidates[k], j
+			runEndA, runEndB := runStartA+1, j+1
+			count := len(candidates)
+			for runStartA > 0 && runStartB > 0 && a[runStartA-1] == b[runStartB-1] {
+				runStartA--
+				runStartB--
+				if count > 1 {
+					count = min(count, len(positions[a[runStartA]]))
+				}
+			}
+			for runEndA < len(a) && runEndB < len(b) && a[runEndA] == b[runEndB] {
+				if count > 1 {
+					count = min(count, len(positions[a[runEndA]]))
+				}
+				runEndA++
+				runEndB++
+			}
+
+			next = max(next, runEndB)
+			if endA-startA < runEndA-runStartA || count < bestCount {
+				startA, startB, endA, endB = runStartA, runStartB, runEndA, runEndB
+				bestCount = count
+			}
+			// Occurrences inside the run would only find it again
+			for k < len(candidates) && candidates[k] < runEndA {
+				k++
+			}
+		}
+		j = next
+	}
+
+	if endA == startA {
+		if common {
+			return diffLines(a, b)
+		}
+		return replacementDiff(a, b)
+	}
+	lines := histogramDiff(a[:startA], b[:startB])
+	for _, line := range a[startA:endA] {
+		lines = append(lines, DiffLine{Kind: ' ', Text: line})
+	}
+	return append(lines, histogramDiff(a[endA:], b[endB:])...)
+}
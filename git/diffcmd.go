@@ -0,0 +1,297 @@
+package git
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// diff - changes between two sets of files:
+//
+//	diff                      index -> work tree
+//	diff --cached [<commit>]  commit (HEAD by default) -> index
+//	diff <commit>             commit -> work tree (files in the index)
+//	diff <commit> <commit>    commit -> commit (also <commit>..<commit>)
+//
+// Work tree files are hashed but not written to the object store - their content is kept in workTreeBlobs,
+// where the diff engine finds it. Only tracked files are compared, untracked files are never shown.
+// --stat, --numstat, --shortstat and --summary (diffstat.go) show summaries instead of the patch.
+
+// Contents of hashed work tree files, by blob hash
+var workTreeBlobs = make(map[string][]byte)
+
+// Write diff selected by options
+func writeDiff(options DiffCmdOptions, output io.Writer) error {
+	diffOptions, err := resolveDiffOptions(options.Diff)
+	if err != nil {
+		return err
+	}
+	if diffOptions, err = resolveWordDiffOptions(diffOptions); err != nil {
+		return err
+	}
+	// --color-words is always colored - words are only marked by colors
+	color := diffOptions.WordDiff == "color"
+	if !color {
+		if color, err = useColor("diff", options.Color); err != nil {
+			return err
+		}
+	}
+	if color {
+		if diffOptions.Colors, err = loadColors("diff"); err != nil {
+			return err
+		}
+	}
+
+	oldFiles, newFiles, err := diffSides(options)
+	if err != nil {
+		return err
+	}
+	if len(options.Paths) > 0 {
+		paths := indexPaths(options.Paths)
+		oldFiles = filterPaths(oldFiles, paths)
+		newFiles = filterPaths(newFiles, paths)
+	}
+
+	changes, err := diffFileSets(oldFiles, newFiles, diffOptions)
+	if err != nil {
+		return err
+	}
+	if options.Format != "" {
+		return writeDiffSummary(output, changes, options)
+	}
+	diffs, err := diffChangedFiles(changes, diffOptions)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+	// Diffstat replaces the patch, unless -p asks for both (separated by an empty line)
+	if stat := diffOptions.Stat; stat.enabled() {
+		stat.NullTerminated = options.NullTerminated
+		writeDiffStat(writer, diffs, stat)
+		if !options.Patch || len(diffs) == 0 {
+			return nil
+		}
+		writer.WriteString("\n")
+	}
+	for _, diff := range diffs {
+		writeFileDiff(writer, diff, diffOptions)
+	}
+	return nil
+}
+
+// Write changes one per line, without content - Format is one of:
+//
+//	raw          :<old mode> <new mode> <old hash> <new hash> <status>\t<path>
+//	name-status  <status>\t<path>
+//	name-only    <path>
+//
+// Status of renames and copies has the similarity (R100), both paths follow it. With NullTerminated, every
+// field after the status ends with NUL and paths are not quoted.
+func writeDiffSummary(output io.Writer, changes []FileChange, options DiffCmdOptions) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	quote := config.GetBool("core.quotePath", true)
+	separator, terminator := "\t", "\n"
+	if options.NullTerminated {
+		separator, terminator = "\x00", "\x00"
+	}
+	name := func(filePath string) string {
+		if options.NullTerminated {
+			return filePath
+		}
+		return quotePath(filePath, quote)
+	}
+
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+	for _, change := range changes {
+		status := string(change.Status)
+		paths := name(change.NewPath)
+		switch {
+		case change.Status == 'M' && (change.OldMode == "120000") != (change.NewMode == "120000"):
+			// File became a symlink or the other way around
+			status = "T"
+		case change.Status == 'R' || change.Status == 'C':
+			status = fmt.Sprintf("%c%03d", change.Status, change.Similarity)
+			paths = name(change.OldPath) + separator + paths
+		}
+
+		switch options.Format {
+		case "name-only":
+			writer.WriteString(name(change.NewPath) + terminator)
+		case "name-status":
+			writer.WriteString(status + separator + paths + terminator)
+		default:
+			// Work tree files are not in the object store - their hash is left out, as git does for changed files
+			newHash := shortHash(change.NewHash)
+			if _, ok := workTreeBlobs[change.NewHash]; ok {
+				newHash = shortHash("")
+			}
+			fmt.Fprintf(writer, ":%s %s %s %s %s%s%s%s", rawMode(change.OldMode), rawMode(change.NewMode),
+				shortHash(change.OldHash), newHash, status, separator, paths, terminator)
+		}
+	}
+	return nil
+}
+
+// Mode as raw diff shows it - 000000 when the file is missing
+func rawMode(mode string) string {
+	if mode == "" {
+		return "000000"
+	}
+	return fmt.Sprintf("%06s", mode)
+}
+
+// Old and new files of the diff (path -> entry)
+func diffSides(options DiffCmdOptions) (map[string]TreeEntry, map[string]TreeEntry, error) {
+	revisions := options.Revisions
+	if len(revisions) == 1 {
+		if from, to, ok := strings.Cut(revisions[0], ".."); ok {
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			revisions = []string{from, to}
+		}
+	}
+
+	switch {
+	case len(revisions) > 2 || (options.Cached && len(revisions) > 1):
+		return nil, nil, fmt.Errorf("use: git diff [--cached] [<commit> [<commit>]] [-- <path>...]")
+	case len(revisions) == 2:
+		oldFiles, err := commitFiles(revisions[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		newFiles, err := commitFiles(revisions[1])
+		return oldFiles, newFiles, err
+	}
+
+	if !options.Cached {
+		if err := requireWorkTree(); err != nil {
+			return nil, nil, err
+		}
+	}
+	entries, err := readGitIndex()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	var oldFiles map[string]TreeEntry
+	switch {
+	case len(revisions) == 1:
+		oldFiles, err = commitFiles(revisions[0])
+	case options.Cached:
+		// Nothing committed yet - everything in the index is new
+		oldFiles = make(map[string]TreeEntry)
+		if _, _, headErr := resolveRevision("HEAD"); headErr == nil {
+			oldFiles, err = commitFiles("HEAD")
+		}
+	default:
+		oldFiles = indexFiles(entries)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if options.Cached {
+		return oldFiles, indexFiles(entries), nil
+	}
+	newFiles, err := workTreeFiles(entries)
+	return oldFiles, newFiles, err
+}
+
+// Files of commit's tree
+func commitFiles(revision string) (map[string]TreeEntry, error) {
+	hash, err := resolveCommitRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	treeHash, err := readCommitTreeHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]TreeEntry)
+	return files, flattenTree(treeHash, "", files)
+}
+
+// Files recorded in the index - intent-to-add entries are not there yet
+func indexFiles(entries []IndexEntry) map[string]TreeEntry {
+	files := make(map[string]TreeEntry, len(entries))
+	for _, entry := range entries {
+		// Conflicted path is compared in our version (stage 2), like git diff --ours
+		if !entry.IntentToAdd && (entry.Stage == 0 || entry.Stage == 2) {
+			files[entry.Path] = TreeEntry{Mode: fmt.Sprintf("%o", entry.Mode), Name: entry.Path, Hash: hex.EncodeToString(entry.Hash)}
+		}
+	}
+	return files
+}
+
+// Tracked files as they are in the work tree - files with the stat data of their index entry are not read,
+// submodules and skip-worktree files are taken from the index
+func workTreeFiles(entries []IndexEntry) (map[string]TreeEntry, error) {
+	converter, err := newEolConverter()
+	if err != nil {
+		return nil, err
+	}
+	var indexModTime time.Time
+	if state, ok := indexStateCache[indexFilePath()]; ok {
+		indexModTime = state.ModTime
+	}
+
+	files := make(map[string]TreeEntry, len(entries))
+	for _, entry := range entries {
+		indexEntry := TreeEntry{Mode: fmt.Sprintf("%o", entry.Mode), Name: entry.Path, Hash: hex.EncodeToString(entry.Hash)}
+		if entry.Mode == 0160000 || entry.SkipWorktree {
+			files[entry.Path] = indexEntry
+			continue
+		}
+
+		info, err := os.Lstat(workTreePath(filepath.FromSlash(entry.Path)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !entry.IntentToAdd && statUnchanged(entry, indexStatFromFileInfo(info), indexModTime) {
+			files[entry.Path] = indexEntry
+			continue
+		}
+
+		content, mode, err := readWorkTreeFile(entry.Path, converter)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		hash := hex.EncodeToString(hashObject(generateObjectByte("blob", content)))
+		workTreeBlobs[hash] = content
+		files[entry.Path] = TreeEntry{Mode: fmt.Sprintf("%o", indexFileMode(mode, entry.Mode)), Name: entry.Path, Hash: hash}
+	}
+	return files, nil
+}
+
+// Files at or below paths ("" is everything)
+func filterPaths(files map[string]TreeEntry, paths []string) map[string]TreeEntry {
+	filtered := make(map[string]TreeEntry)
+	for filePath, entry := range files {
+		for _, prefix := range paths {
+			if prefix == "" || filePath == prefix || strings.HasPrefix(filePath, prefix+"/") {
+				filtered[filePath] = entry
+				break
+			}
+		}
+	}
+	return filtered
+}
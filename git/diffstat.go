@@ -0,0 +1,226 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diffstat - summaries of changed files, shown instead of their patches (or before them):
+//
+//	--stat       " path | <changes> +++---" per file, then the total line
+//	--numstat    "<added>\t<deleted>\t<path>" per file, "-" counts for binary files
+//	--shortstat  only the total line - " N files changed, N insertions(+), N deletions(-)"
+//	--summary    created, deleted, renamed and copied files and mode changes
+//
+// Stat lines fit in Width columns (terminal width by default, 72 in format-patch). When everything doesn't
+// fit, the graph gets at most 3/8 of the width and the path what is left - long paths lose their leading
+// part ("..."), up to a slash. Bars are scaled linearly to the graph width, as if it were one column
+// narrower, plus one - every non-zero count keeps at least one character.
+
+// Check whether any diffstat output is selected
+func (options DiffStatOptions) enabled() bool {
+	return options.Stat || options.NumStat || options.ShortStat || options.Summary
+}
+
+// Write diffstat parts selected by options, in git's order
+func writeDiffStat(w io.Writer, diffs []FileDiff, options DiffStatOptions) {
+	if options.NumStat {
+		writeNumStat(w, diffs, options.NullTerminated)
+	}
+	if options.Stat {
+		writeStatLines(w, diffs, options)
+	}
+	if options.ShortStat {
+		writeStatTotal(w, diffs)
+	}
+	if options.Summary {
+		writeStatSummary(w, diffs)
+	}
+}
+
+// Write " path | N ++--" line per file (at most options.Count of them), then the total line
+func writeStatLines(w io.Writer, diffs []FileDiff, options DiffStatOptions) {
+	if len(diffs) == 0 {
+		return
+	}
+	shown := len(diffs)
+	if options.Count > 0 {
+		shown = min(shown, options.Count)
+	}
+
+	// Widths the shown files ask for - "Bin <old> -> <new> bytes" of binary files starts in the count column
+	nameLength, maxChanges, countWidth, binaryWidth := 0, 0, 0, 0
+	for _, diff := range diffs[:shown] {
+		nameLength = max(nameLength, utf8.RuneCountInString(diffStatName(diff.Change)))
+		if diff.Binary {
+			binaryWidth = max(binaryWidth, 14+len(fmt.Sprint(len(diff.OldContent)))+len(fmt.Sprint(len(diff.NewContent))))
+			countWidth = 3
+			continue
+		}
+		maxChanges = max(maxChanges, diff.Added+diff.Deleted)
+	}
+	countWidth = max(countWidth, len(fmt.Sprint(maxChanges)))
+
+	width := options.Width
+	if width == 0 {
+		width = terminalColumns()
+	}
+	// Graph keeps at least 6 columns, the path 10
+	width = max(width, 16+6+countWidth)
+	graphWidth := maxChanges
+	if maxChanges+4 <= binaryWidth {
+		graphWidth = binaryWidth - 4
+	}
+	if options.GraphWidth > 0 && options.GraphWidth < graphWidth {
+		graphWidth = options.GraphWidth
+	}
+	nameWidth := nameLength
+	if options.NameWidth > 0 && options.NameWidth < nameLength {
+		nameWidth = options.NameWidth
+	}
+	if nameWidth+countWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-countWidth-6 {
+			graphWidth = max(width*3/8-countWidth-6, 6)
+		}
+		if options.GraphWidth > 0 && graphWidth > options.GraphWidth {
+			graphWidth = options.GraphWidth
+		}
+		if nameWidth > width-countWidth-6-graphWidth {
+			nameWidth = width - countWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - countWidth - 6 - nameWidth
+		}
+	}
+
+	for _, diff := range diffs[:shown] {
+		name, prefix, padding := diffStatName(diff.Change), "", nameWidth
+		if runes := []rune(name); len(runes) > nameWidth {
+			prefix, padding = "...", max(nameWidth-3, 0)
+			name = string(runes[len(runes)-padding:])
+			if slash := strings.IndexByte(name, '/'); slash != -1 {
+				name = name[slash:]
+			}
+		}
+		if diff.Binary {
+			fmt.Fprintf(w, " %s%-*s | %*s %d -> %d bytes\n", prefix, padding, name, countWidth, "Bin",
+				len(diff.OldContent), len(diff.NewContent))
+			continue
+		}
+
+		plus, minus := diff.Added, diff.Deleted
+		if graphWidth <= maxChanges {
+			// Bar of a file with both kinds of changes shows both
+			total := scaleStat(plus+minus, graphWidth, maxChanges)
+			if total < 2 && plus > 0 && minus > 0 {
+				total = 2
+			}
+			if plus < minus {
+				plus = scaleStat(plus, graphWidth, maxChanges)
+				minus = total - plus
+			} else {
+				minus = scaleStat(minus, graphWidth, maxChanges)
+				plus = total - minus
+			}
+		}
+		count := fmt.Sprintf("%*d", countWidth, diff.Added+diff.Deleted)
+		if diff.Added+diff.Deleted > 0 {
+			count += " "
+		}
+		fmt.Fprintf(w, " %s%-*s | %s%s%s\n", prefix, padding, name, count, strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	if shown < len(diffs) {
+		fmt.Fprintln(w, " ...")
+	}
+	writeStatTotal(w, diffs)
+}
+
+// Write " N files changed, N insertions(+), N deletions(-)" - both counts are shown when nothing was
+// inserted or deleted (files were only moved)
+func writeStatTotal(w io.Writer, diffs []FileDiff) {
+	if len(diffs) == 0 {
+		return
+	}
+	added, deleted := 0, 0
+	for _, diff := range diffs {
+		added += diff.Added
+		deleted += diff.Deleted
+	}
+	total := fmt.Sprintf(" %d %s changed", len(diffs), plural(len(diffs), "file", "files"))
+	if added > 0 || deleted == 0 {
+		total += fmt.Sprintf(", %d %s(+)", added, plural(added, "insertion", "insertions"))
+	}
+	if deleted > 0 || added == 0 {
+		total += fmt.Sprintf(", %d %s(-)", deleted, plural(deleted, "deletion", "deletions"))
+	}
+	fmt.Fprintln(w, total)
+}
+
+// Write "<added>\t<deleted>\t<path>" per file - with nullTerminated, lines end with NUL and renamed files
+// have both paths after an empty one
+func writeNumStat(w io.Writer, diffs []FileDiff, nullTerminated bool) {
+	for _, diff := range diffs {
+		added, deleted := fmt.Sprint(diff.Added), fmt.Sprint(diff.Deleted)
+		if diff.Binary {
+			added, deleted = "-", "-"
+		}
+		change := diff.Change
+		switch {
+		case !nullTerminated:
+			fmt.Fprintf(w, "%s\t%s\t%s\n", added, deleted, diffStatName(change))
+		case change.Status == 'R' || change.Status == 'C':
+			fmt.Fprintf(w, "%s\t%s\t\x00%s\x00%s\x00", added, deleted, change.OldPath, change.NewPath)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\x00", added, deleted, change.NewPath)
+		}
+	}
+}
+
+// Write created, deleted, renamed and copied files and mode changes
+func writeStatSummary(w io.Writer, diffs []FileDiff) {
+	for _, diff := range diffs {
+		change := diff.Change
+		switch {
+		case change.Status == 'A':
+			fmt.Fprintf(w, " create mode %s %s\n", change.NewMode, change.NewPath)
+		case change.Status == 'D':
+			fmt.Fprintf(w, " delete mode %s %s\n", change.OldMode, change.OldPath)
+		case change.Status == 'R' || change.Status == 'C':
+			kind := "rename"
+			if change.Status == 'C' {
+				kind = "copy"
+			}
+			fmt.Fprintf(w, " %s %s (%d%%)\n", kind, renamedPathName(change.OldPath, change.NewPath), change.Similarity)
+			if change.OldMode != change.NewMode {
+				fmt.Fprintf(w, " mode change %s => %s\n", change.OldMode, change.NewMode)
+			}
+		case change.OldMode != change.NewMode:
+			fmt.Fprintf(w, " mode change %s => %s %s\n", change.OldMode, change.NewMode, change.NewPath)
+		}
+	}
+}
+
+// Path shown in diffstat - "old => new" for renames and copies
+func diffStatName(change FileChange) string {
+	if change.Status == 'R' || change.Status == 'C' {
+		return renamedPathName(change.OldPath, change.NewPath)
+	}
+	return change.NewPath
+}
+
+// Scale count of changes to graph width - maxChanges fills the graph, any other non-zero count gets at
+// least one column
+func scaleStat(count, width, maxChanges int) int {
+	if count == 0 {
+		return 0
+	}
+	return 1 + count*(width-1)/maxChanges
+}
+
+func plural(count int, singular, pluralForm string) string {
+	if count == 1 {
+		return singular
+	}
+	return pluralForm
+}
@@ -0,0 +1,314 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dumb HTTP - repository served as static files (git update-server-info has to be run on the server):
+//   - info/refs          - "<hash>\t<ref name>" lines
+//   - HEAD               - symbolic ref to default branch
+//   - objects/xx/yyyy    - loose objects
+//   - objects/info/packs - "P pack-<hash>.pack" lines, .idx/.pack files next to it
+//
+// There is no negotiation - starting from the wanted commits, every object is downloaded and
+// its references (tree, parents, entries) are followed. Collected objects are handed to the caller
+// as an ordinary (undeltified) pack, so the rest of clone doesn't know the difference.
+
+// Check whether /info/refs response is smart HTTP advertisement - "# service=" pkt-line (v0) or "version 2" capabilities
+func isSmartAdvertisement(advertisement []byte) bool {
+	payload, kind, _, err := readPktLine(advertisement, 0)
+	if err != nil || kind != PKT_DATA {
+		return false
+	}
+	return bytes.HasPrefix(payload, []byte("# service=")) || isProtocolV2(advertisement)
+}
+
+// Turn plain info/refs (and HEAD file) into v0 refs advertisement, so it can be parsed like smart one
+func (transport *HttpTransport) dumbRefsAdvertisement(ctx context.Context, infoRefs []byte) ([]byte, error) {
+	refs := make(map[string]string)
+	var names []string
+	for _, line := range strings.Split(string(infoRefs), "\n") {
+		hash, name, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok {
+			continue
+		}
+		refs[name] = hash
+		names = append(names, name)
+	}
+
+	var buf bytes.Buffer
+	capabilities := ""
+	head, err := httpGet(ctx, transport.Url+"/HEAD", transport.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if head != nil {
+		content := strings.TrimSpace(string(head))
+		if target, ok := strings.CutPrefix(content, "ref: "); ok {
+			if hash, ok := refs[target]; ok {
+				capabilities = "symref=HEAD:" + target
+				writePktLine(&buf, fmt.Sprintf("%s HEAD\x00%s\n", hash, capabilities))
+			}
+		} else if len(content) == 40 {
+			writePktLine(&buf, fmt.Sprintf("%s HEAD\x00\n", content))
+		}
+	}
+
+	for _, name := range names {
+		writePktLine(&buf, fmt.Sprintf("%s %s\n", refs[name], name))
+	}
+	buf.WriteString("0000")
+
+	return buf.Bytes(), nil
+}
+
+// Answer upload-pack request (want lines) by walking remote objects - returns "NAK" + pack, like a smart server
+func (transport *HttpTransport) dumbUploadPack(ctx context.Context, request []byte) ([]byte, error) {
+	var queue []string
+	for offset := 0; offset < len(request); {
+		payload, kind, next, err := readPktLine(request, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		if kind != PKT_DATA {
+			continue
+		}
+
+		fields := strings.Fields(string(payload))
+		switch {
+		case len(fields) >= 2 && fields[0] == "want":
+			queue = append(queue, fields[1])
+		case len(fields) >= 1 && (fields[0] == "deepen" || fields[0] == "filter"):
+			return nil, fmt.Errorf("%s is not supported by dumb HTTP server", fields[0])
+		}
+	}
+
+	seen := make(map[string]bool)
+	var objects []GitObject
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		objType, content, err := transport.fetchDumbObject(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch object %s: %w", hash, err)
+		}
+		objects = append(objects, GitObject{Type: objType, Data: content, Hash: hash})
+
+		references, err := objectReferences(objType, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse object %s: %w", hash, err)
+		}
+		queue = append(queue, references...)
+	}
+
+	response := []byte("0008NAK\n")
+	// Pack is only parsed again here - deltas would be wasted work
+	return append(response, buildPackData(objects, packCompression{})...), nil
+}
+
+// Download one object - loose object first, then packs listed in objects/info/packs
+func (transport *HttpTransport) fetchDumbObject(ctx context.Context, objectHash string) (ObjectType, []byte, error) {
+	data, err := httpGet(ctx, fmt.Sprintf("%s/objects/%s/%s", transport.Url, objectHash[:2], objectHash[2:]), transport.Auth)
+	if err != nil {
+		return 0, nil, err
+	}
+	if data != nil {
+		return inflateLooseObject(data)
+	}
+
+	if transport.remotePacks == nil {
+		if err := transport.loadRemotePackIndexes(ctx); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	hash, err := hex.DecodeString(objectHash)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, index := range transport.remotePacks {
+		offset, ok := index.findOffset(hash)
+		if !ok {
+			continue
+		}
+		if err := transport.downloadPack(ctx, index.PackPath); err != nil {
+			return 0, nil, err
+		}
+		return readPackObjectAt(index.PackPath, offset, 0)
+	}
+
+	return 0, nil, fmt.Errorf("%w on server: %s", ErrObjectNotFound, objectHash)
+}
+
+// Download .idx of every remote pack into temporary directory (packs themselves are downloaded when needed)
+func (transport *HttpTransport) loadRemotePackIndexes(ctx context.Context) error {
+	transport.remotePacks = []*PackIndex{}
+
+	packsList, err := httpGet(ctx, transport.Url+"/objects/info/packs", transport.Auth)
+	if err != nil || packsList == nil {
+		return err
+	}
+
+	if transport.tempDir == "" {
+		transport.tempDir, err = os.MkdirTemp("", "mini-git-dumb-")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, line := range strings.Split(string(packsList), "\n") {
+		packName, ok := strings.CutPrefix(strings.TrimSpace(line), "P ")
+		if !ok || !strings.HasSuffix(packName, ".pack") || strings.ContainsAny(packName, "/\\") {
+			continue
+		}
+		idxName := strings.TrimSuffix(packName, ".pack") + ".idx"
+
+		idxData, err := httpGet(ctx, transport.Url+"/objects/pack/"+idxName, transport.Auth)
+		if err != nil {
+			return err
+		}
+		if idxData == nil {
+			return fmt.Errorf("pack index %s not found on server", idxName)
+		}
+		idxPath := filepath.Join(transport.tempDir, idxName)
+		if err := os.WriteFile(idxPath, idxData, 0644); err != nil {
+			return err
+		}
+
+		index, err := parsePackIndex(idxPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", idxName, err)
+		}
+		index.PackPath = filepath.Join(transport.tempDir, packName)
+		transport.remotePacks = append(transport.remotePacks, index)
+	}
+	return nil
+}
+
+// Download pack file next to its (already downloaded) .idx - only once
+func (transport *HttpTransport) downloadPack(ctx context.Context, packPath string) error {
+	if _, err := os.Stat(packPath); err == nil {
+		return nil
+	}
+
+	packName := filepath.Base(packPath)
+	data, err := httpGet(ctx, transport.Url+"/objects/pack/"+packName, transport.Auth)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("pack %s not found on server", packName)
+	}
+	return os.WriteFile(packPath, data, 0644)
+}
+
+// GET file from server - nil data (without error) if it doesn't exist
+func httpGet(ctx context.Context, url string, auth *HttpAuth) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	resp, err := doHttpRequest(req, auth)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, url)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Inflate loose object file and split "<type> <size>\0" header from content
+func inflateLooseObject(data []byte) (ObjectType, []byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	header, content, ok := bytes.Cut(decompressed, []byte{0})
+	if !ok {
+		return 0, nil, fmt.Errorf("malformed object header")
+	}
+	typeName, _, _ := strings.Cut(string(header), " ")
+	objType, err := ObjectTypeFromString(typeName)
+	if err != nil {
+		return 0, nil, err
+	}
+	return objType, content, nil
+}
+
+// Hashes of objects this object points to - commit: tree and parents, tree: entries, tag: tagged object
+func objectReferences(objType ObjectType, content []byte) ([]string, error) {
+	var references []string
+
+	switch objType {
+	case OBJ_COMMIT, OBJ_TAG:
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == "" {
+				break
+			}
+			key, value, _ := strings.Cut(line, " ")
+			if key == "tree" || key == "parent" || key == "object" {
+				references = append(references, value)
+			}
+		}
+	case OBJ_TREE:
+		entries, err := parseTreeContent(content)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// Gitlinks point to commits in another repository
+			if entry.Mode != "160000" {
+				references = append(references, entry.Hash)
+			}
+		}
+	}
+
+	return references, nil
+}
+
+// Build pack (version 2) from objects, deltified as compression says
+func buildPackData(objects []GitObject, compression packCompression) []byte {
+	defer tracePerformance("build pack")()
+	pack, _, _ := encodePack(objects, compression)
+	return pack
+}
+
+// Encode pack object header - type in bits 6-4 of first byte, size in the remaining bits (4 + 7 per byte)
+func encodeObjectHeader(objType ObjectType, size uint64) []byte {
+	header := []byte{byte(objType)<<4 | byte(size&0x0f)}
+	size >>= 4
+	for size > 0 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+		size >>= 7
+	}
+	return header
+}
@@ -0,0 +1,113 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Editor - commands that let the user edit text (the rebase todo list, commit messages) run an editor on a
+// file in the git directory. The editor is the first of:
+//
+//	GIT_EDITOR, core.editor, VISUAL (unless TERM is dumb), EDITOR, vi
+//
+// and for the rebase todo list GIT_SEQUENCE_EDITOR and sequence.editor come first. Like git, the editor runs
+// through the shell with the file as its argument, and ":" leaves the text as it is.
+
+// Comment added below a message the user edits
+const editMessageHelp = "\n# Please enter the commit message for your changes. Lines starting\n" +
+	"# with '#' will be ignored, and an empty message aborts the commit.\n"
+
+// Editor command - sequence selects the editor for the rebase todo list
+func editorCommand(sequence bool) (string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if sequence {
+		if editor, ok := os.LookupEnv("GIT_SEQUENCE_EDITOR"); ok {
+			return editor, nil
+		}
+		if editor, ok := config.Get("sequence.editor"); ok {
+			return editor, nil
+		}
+	}
+	if editor, ok := os.LookupEnv("GIT_EDITOR"); ok {
+		return editor, nil
+	}
+	if editor, ok := config.Get("core.editor"); ok {
+		return editor, nil
+	}
+	dumb := os.Getenv("TERM") == "" || os.Getenv("TERM") == "dumb"
+	if editor, ok := os.LookupEnv("VISUAL"); ok && !dumb {
+		return editor, nil
+	}
+	if editor, ok := os.LookupEnv("EDITOR"); ok {
+		return editor, nil
+	}
+	if dumb {
+		return "", fmt.Errorf("terminal is dumb, but EDITOR unset")
+	}
+	return "vi", nil
+}
+
+// Let the user edit file - returns error when the editor fails
+func launchEditor(filePath string, sequence bool) error {
+	editor, err := editorCommand(sequence)
+	if err != nil {
+		return err
+	}
+	if editor == ":" {
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, filePath)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = hookEnvironment()
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s': %w", editor, err)
+	}
+	return nil
+}
+
+// Let the user edit commit message (in .git/COMMIT_EDITMSG) - comment lines are dropped, empty message is
+// an error
+func editCommitMessage(message string) (string, error) {
+	messageFile := gitDirPath("COMMIT_EDITMSG")
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	if err := os.WriteFile(messageFile, []byte(message+editMessageHelp), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", messageFile, err)
+	}
+	if err := launchEditor(messageFile, false); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(messageFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", messageFile, err)
+	}
+
+	message = stripCommentLines(string(content))
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return message, nil
+}
+
+// Drop lines starting with '#' and trailing blank lines - "" when nothing is left
+func stripCommentLines(text string) string {
+	var kept strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			kept.WriteString(line)
+		}
+	}
+	stripped := strings.Trim(kept.String(), " \t\n")
+	if stripped == "" {
+		return ""
+	}
+	return stripped + "\n"
+}
@@ -0,0 +1,128 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Line ending conversion - files that are considered text are stored with LF in the object database,
+// and converted to the configured line ending when they are written to the work tree.
+//
+// A file is text if:
+//   - text attribute is set (or eol attribute is set)
+//   - text=auto (or core.autocrlf is true/input and text is unspecified) and content doesn't look binary
+//
+// Line ending in the work tree is taken from (first that is set): eol attribute, core.autocrlf
+// (true -> crlf, input -> lf), core.eol (lf, crlf or native - which is lf here).
+
+// Creates converter with attributes and core.autocrlf/core.eol config loaded
+func newEolConverter() (*EolConverter, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := newAttributeMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	autocrlf, _ := config.Get("core.autocrlf")
+	switch strings.ToLower(autocrlf) {
+	case "true", "yes", "on", "1":
+		autocrlf = "true"
+	case "input":
+		autocrlf = "input"
+	default:
+		autocrlf = "false"
+	}
+
+	eol, _ := config.Get("core.eol")
+	eol = strings.ToLower(eol)
+	if eol != "crlf" {
+		eol = "lf"
+	}
+
+	return &EolConverter{attributes: attributes, autocrlf: autocrlf, eol: eol}, nil
+}
+
+// Decide whether path should be treated as text - content is used for auto detection
+func (converter *EolConverter) isText(filePath string, content []byte) bool {
+	pathAttributes := converter.attributes.attributesFor(filePath)
+	text := pathAttributes["text"]
+	eol := pathAttributes["eol"]
+
+	switch text.State {
+	case ATTR_SET:
+		return true
+	case ATTR_UNSET:
+		return false
+	case ATTR_VALUE:
+		if text.Value == "auto" {
+			return !looksBinary(content)
+		}
+		return true
+	}
+
+	// text is unspecified - eol attribute implies text, autocrlf implies text=auto
+	if eol.State == ATTR_VALUE {
+		return true
+	}
+	if converter.autocrlf != "false" {
+		return !looksBinary(content)
+	}
+	return false
+}
+
+// Line ending that should be used in the work tree for text file ("lf" or "crlf")
+func (converter *EolConverter) workTreeEol(filePath string) string {
+	eol := converter.attributes.attribute(filePath, "eol")
+	if eol.State == ATTR_VALUE && (eol.Value == "lf" || eol.Value == "crlf") {
+		return eol.Value
+	}
+
+	switch converter.autocrlf {
+	case "true":
+		return "crlf"
+	case "input":
+		return "lf"
+	}
+	return converter.eol
+}
+
+// Convert content read from work tree to what should be stored in blob (CRLF -> LF for text files)
+func (converter *EolConverter) toGit(filePath string, content []byte) []byte {
+	if converter == nil || !bytes.Contains(content, []byte("\r\n")) || !converter.isText(filePath, content) {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// Convert blob content to what should be written to work tree (LF -> CRLF when crlf is configured)
+func (converter *EolConverter) toWorkTree(filePath string, content []byte) []byte {
+	if converter == nil || !converter.isText(filePath, content) || converter.workTreeEol(filePath) != "crlf" {
+		return content
+	}
+
+	// Blobs that already contain CRLF are left as they are, to avoid producing \r\r\n
+	if bytes.Contains(content, []byte("\r\n")) {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+}
+
+// Heuristic used by git for text=auto - NUL byte or lone CR in the first 8000 bytes means binary
+func looksBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	if bytes.IndexByte(content, 0) != -1 {
+		return true
+	}
+	for i, c := range content {
+		if c == '\r' && (i+1 >= len(content) || content[i+1] != '\n') {
+			return true
+		}
+	}
+	return false
+}
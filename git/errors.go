@@ -0,0 +1,22 @@
+package git
+
+import "errors"
+
+// Errors callers can check with errors.Is - they are wrapped with details (object name, path, URL), and
+// errors of lower layers are wrapped with %w on the way up, so the check works on what commands return:
+//
+//	ErrNotARepository   no repository at (or above) the path, or path isn't a git directory
+//	ErrObjectNotFound   object (or revision) doesn't exist, locally or on the server
+//	ErrCorruptObject    object can't be inflated, has a bad header or hashes to another name (*ObjectCorruptError)
+//	ErrNonFastForward   ref update would lose commits - the new value doesn't contain the old one
+//	ErrAuth             server rejected the credentials, or there was no way to ask for them
+//	ErrNoSignature      commit or tag to verify isn't signed
+
+var (
+	ErrNotARepository = errors.New("not a git repository")
+	ErrObjectNotFound = errors.New("object not found")
+	ErrCorruptObject  = errors.New("corrupt object")
+	ErrNonFastForward = errors.New("non-fast-forward update")
+	ErrAuth           = errors.New("authentication failed")
+	ErrNoSignature    = errors.New("no signature found")
+)
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EWAH compressed bitmap (as serialized by git):
+//
+//	4 byte bit count + 4 byte word count + words (8 bytes each) + 4 byte position of the last marker word
+//
+// Words are groups of one marker word followed by literal words. Marker word holds the running bit (bit 0),
+// how many words full of the running bit come first (bits 1-32) and how many literal words follow (bits 33-63).
+// Bit i of the bitmap is bit i%64 of word i/64.
+
+const ewahMaxRunLength = 1<<32 - 1
+const ewahMaxLiteralWords = 1<<31 - 1
+
+// Decode EWAH bitmap at the start of data - returns bits and number of bytes used
+func decodeEwahBitmap(data []byte) ([]bool, int, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("ewah bitmap is truncated")
+	}
+	bitCount := int(binary.BigEndian.Uint32(data[0:4]))
+	wordCount := int(binary.BigEndian.Uint32(data[4:8]))
+	size := 8 + wordCount*8 + 4
+	if wordCount < 0 || size > len(data) {
+		return nil, 0, fmt.Errorf("ewah bitmap is truncated")
+	}
+
+	bits := make([]bool, bitCount)
+	position := 0
+	setBits := func(word uint64) {
+		for bit := 0; bit < 64 && position+bit < bitCount; bit++ {
+			if word&(1<<bit) != 0 {
+				bits[position+bit] = true
+			}
+		}
+		position += 64
+	}
+
+	for i := 0; i < wordCount; {
+		marker := binary.BigEndian.Uint64(data[8+i*8:])
+		i++
+		runLength := int(marker >> 1 & ewahMaxRunLength)
+		literalWords := int(marker >> 33)
+		if marker&1 != 0 {
+			for run := 0; run < runLength && position < bitCount; run++ {
+				setBits(^uint64(0))
+			}
+		} else {
+			position += runLength * 64
+		}
+		if i+literalWords > wordCount {
+			return nil, 0, fmt.Errorf("ewah literal words run past the bitmap")
+		}
+		for literal := 0; literal < literalWords; literal++ {
+			setBits(binary.BigEndian.Uint64(data[8+i*8:]))
+			i++
+		}
+	}
+	return bits, size, nil
+}
+
+// Encode bitmap in EWAH format - bit count is position of the last set bit + 1 (as git's ewah_set leaves it)
+func encodeEwahBitmap(bits []bool) []byte {
+	bitCount := 0
+	for i := len(bits) - 1; i >= 0; i-- {
+		if bits[i] {
+			bitCount = i + 1
+			break
+		}
+	}
+
+	plain := make([]uint64, (bitCount+63)/64)
+	for i := 0; i < bitCount; i++ {
+		if bits[i] {
+			plain[i/64] |= 1 << (i % 64)
+		}
+	}
+
+	var words []uint64
+	lastMarker := 0
+	for i := 0; i < len(plain) || len(words) == 0; {
+		lastMarker = len(words)
+		words = append(words, 0)
+
+		// Words that are all zeros or all ones are counted, the rest is copied
+		var marker uint64
+		if i < len(plain) && (plain[i] == 0 || plain[i] == ^uint64(0)) {
+			clean := plain[i]
+			runLength := uint64(0)
+			for i < len(plain) && plain[i] == clean && runLength < ewahMaxRunLength {
+				runLength++
+				i++
+			}
+			marker = clean&1 | runLength<<1
+		}
+		literalWords := uint64(0)
+		for i < len(plain) && plain[i] != 0 && plain[i] != ^uint64(0) && literalWords < ewahMaxLiteralWords {
+			words = append(words, plain[i])
+			literalWords++
+			i++
+		}
+		words[lastMarker] = marker | literalWords<<33
+	}
+
+	data := make([]byte, 8+len(words)*8+4)
+	binary.BigEndian.PutUint32(data[0:4], uint32(bitCount))
+	binary.BigEndian.PutUint32(data[4:8], uint32(len(words)))
+	for i, word := range words {
+		binary.BigEndian.PutUint64(data[8+i*8:], word)
+	}
+	binary.BigEndian.PutUint32(data[8+len(words)*8:], uint32(lastMarker))
+	return data
+}
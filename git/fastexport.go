@@ -0,0 +1,272 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// fast-export - history as a git fast-import stream:
+//
+//	blob                         commit refs/heads/main          tag v1.0
+//	mark :1                      mark :2                         from :2
+//	data <size>                  author ... / committer ...      tagger ...
+//	<content>                    data <size> + message           data <size> + message
+//	                             from :<parent mark>
+//	                             merge :<other parent mark>
+//	                             D <path> / M <mode> :<mark> <path>
+//
+// Commits are written parents first, every blob right before the first commit that needs it.
+// Commit is written under the first exported ref it is reachable from - other refs pointing to it
+// get "reset <ref>" + "from :<mark>".
+
+// Write fast-import stream for refs (full ref names) to output
+func fastExport(refNames []string, output io.Writer) error {
+	exporter := &FastExporter{writer: bufio.NewWriter(output), marks: make(map[string]int)}
+
+	// Branches first, so commits are written under branch names rather than tags
+	sort.SliceStable(refNames, func(i, j int) bool {
+		return !strings.HasPrefix(refNames[i], "refs/tags/") && strings.HasPrefix(refNames[j], "refs/tags/")
+	})
+
+	for _, refName := range refNames {
+		hash, err := resolveRef(refName)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			return fmt.Errorf("ref %s does not exist", refName)
+		}
+		if err := exporter.exportRef(refName, hash); err != nil {
+			return err
+		}
+	}
+
+	return exporter.writer.Flush()
+}
+
+// Refs exported by fast-export --all - symbolic refs (refs/remotes/origin/HEAD) are left out
+func fastExportAllRefs() ([]string, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range sortedKeys(refs) {
+		data, err := os.ReadFile(gitDirPath(name))
+		if err == nil && strings.HasPrefix(string(data), "ref: ") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Export history of one ref - annotated tag is written as tag command after the commit it points to
+func (exporter *FastExporter) exportRef(refName, hash string) error {
+	objType, _, content, err := readObjectFromHash(hash)
+	if err != nil {
+		return err
+	}
+
+	var tag *Tag
+	if objType == "tag" {
+		tag, err = parseTag(content)
+		if err != nil {
+			return fmt.Errorf("bad tag %s: %w", hash, err)
+		}
+		peeled, err := peelTag(hash)
+		if err != nil {
+			return err
+		}
+		hash = peeled
+		objType, _, _, err = readObjectFromHash(hash)
+		if err != nil {
+			return err
+		}
+	}
+	if objType != "commit" {
+		fmt.Fprintf(os.Stderr, "warning: skipping %s - it doesn't point to a commit\n", refName)
+		return nil
+	}
+
+	exported := false
+	if _, ok := exporter.marks[hash]; !ok {
+		if err := exporter.exportCommits(refName, hash); err != nil {
+			return err
+		}
+		exported = true
+	}
+
+	if tag != nil {
+		name := strings.TrimPrefix(refName, "refs/tags/")
+		fmt.Fprintf(exporter.writer, "tag %s\nfrom :%d\n", name, exporter.marks[hash])
+		if tag.Tagger != "" {
+			fmt.Fprintf(exporter.writer, "tagger %s\n", tag.Tagger)
+		}
+		exporter.writeData([]byte(tag.Message))
+		return nil
+	}
+
+	if !exported {
+		// Commit was already written under another ref
+		fmt.Fprintf(exporter.writer, "reset %s\nfrom :%d\n\n", refName, exporter.marks[hash])
+	}
+	return nil
+}
+
+// Write every not yet exported commit reachable from tip - parents before children
+func (exporter *FastExporter) exportCommits(refName, tip string) error {
+	type frame struct {
+		hash     string
+		expanded bool
+	}
+	stack := []frame{{tip, false}}
+	onStack := map[string]bool{tip: true}
+
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if _, ok := exporter.marks[top.hash]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		parents, err := readCommitParents(top.hash)
+		if err != nil {
+			return err
+		}
+
+		if !top.expanded {
+			stack[len(stack)-1].expanded = true
+			for i := len(parents) - 1; i >= 0; i-- {
+				if _, ok := exporter.marks[parents[i]]; !ok && !onStack[parents[i]] {
+					onStack[parents[i]] = true
+					stack = append(stack, frame{parents[i], false})
+				}
+			}
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		if err := exporter.exportCommit(refName, top.hash, parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write one commit - changed blobs first, then commit with file changes against its first parent
+func (exporter *FastExporter) exportCommit(refName, hash string, parents []string) error {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]TreeEntry)
+	if err := flattenTree(commit.Tree, "", files); err != nil {
+		return err
+	}
+	parentFiles := make(map[string]TreeEntry)
+	if len(parents) > 0 {
+		parentCommit, err := readCommit(parents[0])
+		if err != nil {
+			return err
+		}
+		if err := flattenTree(parentCommit.Tree, "", parentFiles); err != nil {
+			return err
+		}
+	}
+
+	var deleted, modified []string
+	for path := range parentFiles {
+		if _, ok := files[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	for path, entry := range files {
+		if old, ok := parentFiles[path]; !ok || old.Hash != entry.Hash || old.Mode != entry.Mode {
+			modified = append(modified, path)
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(modified)
+
+	for _, path := range modified {
+		if err := exporter.exportBlob(files[path]); err != nil {
+			return err
+		}
+	}
+
+	if len(parents) == 0 {
+		// New line of history - ref starts from scratch
+		fmt.Fprintf(exporter.writer, "reset %s\n", refName)
+	}
+	exporter.nextMark++
+	exporter.marks[hash] = exporter.nextMark
+	fmt.Fprintf(exporter.writer, "commit %s\nmark :%d\n", refName, exporter.nextMark)
+	fmt.Fprintf(exporter.writer, "author %s\ncommitter %s\n", commit.Author, commit.Committer)
+	if commit.Encoding != "" {
+		fmt.Fprintf(exporter.writer, "encoding %s\n", commit.Encoding)
+	}
+	exporter.writeData([]byte(commit.Message))
+
+	for i, parent := range parents {
+		command := "merge"
+		if i == 0 {
+			command = "from"
+		}
+		fmt.Fprintf(exporter.writer, "%s :%d\n", command, exporter.marks[parent])
+	}
+	for _, path := range deleted {
+		fmt.Fprintf(exporter.writer, "D %s\n", quoteFastExportPath(path))
+	}
+	for _, path := range modified {
+		entry := files[path]
+		if entry.Mode == "160000" {
+			// Gitlink - commit from another repository, referenced by hash
+			fmt.Fprintf(exporter.writer, "M %s %s %s\n", entry.Mode, entry.Hash, quoteFastExportPath(path))
+			continue
+		}
+		fmt.Fprintf(exporter.writer, "M %s :%d %s\n", entry.Mode, exporter.marks[entry.Hash], quoteFastExportPath(path))
+	}
+	exporter.writer.WriteString("\n")
+	return nil
+}
+
+// Write blob command, unless blob already has a mark
+func (exporter *FastExporter) exportBlob(entry TreeEntry) error {
+	if _, ok := exporter.marks[entry.Hash]; ok || entry.Mode == "160000" {
+		return nil
+	}
+
+	_, _, content, err := readObjectFromHash(entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", entry.Hash, err)
+	}
+
+	exporter.nextMark++
+	exporter.marks[entry.Hash] = exporter.nextMark
+	fmt.Fprintf(exporter.writer, "blob\nmark :%d\n", exporter.nextMark)
+	exporter.writeData(content)
+	exporter.writer.WriteString("\n")
+	return nil
+}
+
+// Write "data <size>" followed by exact bytes
+func (exporter *FastExporter) writeData(data []byte) {
+	fmt.Fprintf(exporter.writer, "data %d\n", len(data))
+	exporter.writer.Write(data)
+}
+
+// Quote path in C style if fast-import would misread it (backslash, quote or newline in it)
+func quoteFastExportPath(path string) string {
+	if !strings.ContainsAny(path, "\"\\\n") {
+		return path
+	}
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+	return "\"" + replacer.Replace(path) + "\""
+}
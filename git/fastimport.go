@@ -0,0 +1,545 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fast-import - builds objects and refs from a fast-import stream (see fastexport.go for the format)
+//
+// Supported commands: blob, commit (M/D/C/R/deleteall file changes), reset, tag, progress, checkpoint,
+// feature/option (ignored), done. Data is either "data <size>" + exact bytes or "data <<DELIM" + lines
+// up to DELIM. Objects are written as they are read, refs are updated once the whole stream is imported.
+
+// Import stream from input - returns number of imported objects by type
+func fastImport(input io.Reader, w io.Writer) (map[string]int, error) {
+	importer := &FastImporter{
+		reader: bufio.NewReader(input),
+		marks:  make(map[string]string),
+		refs:   make(map[string]string),
+		counts: make(map[string]int),
+	}
+
+	for {
+		line, err := importer.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		command, argument, _ := strings.Cut(line, " ")
+		switch command {
+		case "":
+			// Commands may be separated by empty lines
+		case "blob":
+			err = importer.importBlob()
+		case "commit":
+			err = importer.importCommit(argument)
+		case "reset":
+			err = importer.importReset(argument)
+		case "tag":
+			err = importer.importTag(argument)
+		case "progress":
+			fmt.Fprintln(w, line)
+		case "checkpoint":
+			err = importer.writeRefs()
+		case "feature", "option":
+			// Stream hints (date-format=raw, done, ...) - nothing to set up
+		case "done":
+			return importer.counts, importer.writeRefs()
+		default:
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			err = fmt.Errorf("unsupported command: %s", line)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return importer.counts, importer.writeRefs()
+}
+
+// Read next line without trailing LF - line put back with unreadLine comes first
+func (importer *FastImporter) readLine() (string, error) {
+	if importer.hasPending {
+		importer.hasPending = false
+		return importer.pending, nil
+	}
+
+	line, err := importer.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, nil
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+func (importer *FastImporter) unreadLine(line string) {
+	importer.pending = line
+	importer.hasPending = true
+}
+
+// Read optional "<key> <value>" line - returns empty value (and puts line back) if next line is something else
+func (importer *FastImporter) readOptional(key string) (string, error) {
+	line, err := importer.readLine()
+	if err == io.EOF {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if value, ok := strings.CutPrefix(line, key+" "); ok {
+		return value, nil
+	}
+	importer.unreadLine(line)
+	return "", nil
+}
+
+// Read data command - "data <size>" followed by exactly size bytes, or "data <<DELIM" followed by lines up to DELIM
+func (importer *FastImporter) readData() ([]byte, error) {
+	line, err := importer.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("expected data: %w", err)
+	}
+	size, ok := strings.CutPrefix(line, "data ")
+	if !ok {
+		return nil, fmt.Errorf("expected data, got: %s", line)
+	}
+
+	if delimiter, ok := strings.CutPrefix(size, "<<"); ok {
+		var data strings.Builder
+		for {
+			line, err := importer.readLine()
+			if err != nil {
+				return nil, fmt.Errorf("data is not terminated with %s", delimiter)
+			}
+			if line == delimiter {
+				return []byte(data.String()), nil
+			}
+			data.WriteString(line + "\n")
+		}
+	}
+
+	length, err := strconv.Atoi(size)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("bad data size: %s", size)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(importer.reader, data); err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+
+	// Optional LF after data
+	if next, err := importer.reader.Peek(1); err == nil && next[0] == '\n' {
+		importer.reader.ReadByte()
+	}
+	return data, nil
+}
+
+// Write object and count it - returns hash
+func (importer *FastImporter) writeObject(objType string, content []byte) (string, error) {
+	hash, err := writeObject(generateObjectByte(objType, content))
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", objType, err)
+	}
+	importer.counts[objType]++
+	return fmt.Sprintf("%x", hash), nil
+}
+
+// blob: optional mark and original-oid, then data
+func (importer *FastImporter) importBlob() error {
+	mark, err := importer.readOptional("mark")
+	if err != nil {
+		return err
+	}
+	if _, err := importer.readOptional("original-oid"); err != nil {
+		return err
+	}
+	data, err := importer.readData()
+	if err != nil {
+		return err
+	}
+
+	hash, err := importer.writeObject("blob", data)
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		importer.marks[mark] = hash
+	}
+	return nil
+}
+
+// commit <ref>: mark, author, committer, encoding, data, from, merge, then file changes up to the next command
+func (importer *FastImporter) importCommit(refName string) error {
+	mark, err := importer.readOptional("mark")
+	if err != nil {
+		return err
+	}
+	if _, err := importer.readOptional("original-oid"); err != nil {
+		return err
+	}
+	author, err := importer.readOptional("author")
+	if err != nil {
+		return err
+	}
+	committer, err := importer.readOptional("committer")
+	if err != nil {
+		return err
+	}
+	if committer == "" {
+		return fmt.Errorf("commit %s has no committer", refName)
+	}
+	if author == "" {
+		author = committer
+	}
+	encoding, err := importer.readOptional("encoding")
+	if err != nil {
+		return err
+	}
+	message, err := importer.readData()
+	if err != nil {
+		return err
+	}
+
+	var parents []string
+	from, err := importer.readOptional("from")
+	if err != nil {
+		return err
+	}
+	if from != "" {
+		hash, err := importer.resolveCommitish(from)
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			parents = append(parents, hash)
+		}
+	} else if tip, err := importer.refTip(refName); err != nil {
+		return err
+	} else if tip != "" {
+		// No from - commit continues the branch
+		parents = append(parents, tip)
+	}
+	for {
+		merge, err := importer.readOptional("merge")
+		if err != nil {
+			return err
+		}
+		if merge == "" {
+			break
+		}
+		hash, err := importer.resolveCommitish(merge)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, hash)
+	}
+
+	files := make(map[string]TreeEntry)
+	if len(parents) > 0 {
+		treeHash, err := readCommitTreeHash(parents[0])
+		if err != nil {
+			return err
+		}
+		if err := flattenTree(treeHash, "", files); err != nil {
+			return err
+		}
+	}
+	if err := importer.applyFileChanges(files); err != nil {
+		return fmt.Errorf("commit %s: %w", refName, err)
+	}
+
+	treeHash, err := writeTreeFromFiles(files)
+	if err != nil {
+		return err
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "tree %s\n", treeHash)
+	for _, parent := range parents {
+		fmt.Fprintf(&content, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&content, "author %s\ncommitter %s\n", author, committer)
+	if encoding != "" {
+		fmt.Fprintf(&content, "encoding %s\n", encoding)
+	}
+	content.WriteString("\n")
+	content.Write(message)
+
+	hash, err := importer.writeObject("commit", []byte(content.String()))
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		importer.marks[mark] = hash
+	}
+	importer.setRef(refName, hash)
+	return nil
+}
+
+// Apply M/D/C/R/deleteall lines to files - stops at the first line that is not a file change
+func (importer *FastImporter) applyFileChanges(files map[string]TreeEntry) error {
+	for {
+		line, err := importer.readLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case line == "deleteall":
+			clear(files)
+		case strings.HasPrefix(line, "M "):
+			if err := importer.applyModify(files, line[2:]); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "D "):
+			path, _, err := parseFastImportPath(line[2:], true)
+			if err != nil {
+				return err
+			}
+			for _, filePath := range pathsUnder(files, path) {
+				delete(files, filePath)
+			}
+		case strings.HasPrefix(line, "C "), strings.HasPrefix(line, "R "):
+			source, rest, err := parseFastImportPath(line[2:], false)
+			if err != nil {
+				return err
+			}
+			destination, _, err := parseFastImportPath(rest, true)
+			if err != nil {
+				return err
+			}
+			matched := pathsUnder(files, source)
+			if len(matched) == 0 {
+				return fmt.Errorf("path %s not in branch", source)
+			}
+			for _, filePath := range matched {
+				entry := files[filePath]
+				newPath := destination + strings.TrimPrefix(filePath, source)
+				entry.Name = newPath
+				if line[0] == 'R' {
+					delete(files, filePath)
+				}
+				files[newPath] = entry
+			}
+		default:
+			importer.unreadLine(line)
+			return nil
+		}
+	}
+}
+
+// M <mode> <dataref> <path> - dataref is mark, object hash or "inline" (data follows the line)
+func (importer *FastImporter) applyModify(files map[string]TreeEntry, args string) error {
+	mode, rest, _ := strings.Cut(args, " ")
+	dataRef, pathArg, _ := strings.Cut(rest, " ")
+	path, _, err := parseFastImportPath(pathArg, true)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "644", "100644":
+		mode = "100644"
+	case "755", "100755":
+		mode = "100755"
+	case "120000", "160000", "040000":
+	default:
+		return fmt.Errorf("bad file mode %s for %s", mode, path)
+	}
+
+	var hash string
+	switch {
+	case dataRef == "inline":
+		data, err := importer.readData()
+		if err != nil {
+			return err
+		}
+		hash, err = importer.writeObject("blob", data)
+		if err != nil {
+			return err
+		}
+	case strings.HasPrefix(dataRef, ":"):
+		var ok bool
+		if hash, ok = importer.marks[dataRef]; !ok {
+			return fmt.Errorf("unknown mark %s", dataRef)
+		}
+	default:
+		hash = dataRef
+	}
+
+	if mode == "040000" {
+		// Whole tree replaces path
+		for _, filePath := range pathsUnder(files, path) {
+			delete(files, filePath)
+		}
+		return flattenTree(hash, path, files)
+	}
+
+	for _, filePath := range pathsUnder(files, path) {
+		delete(files, filePath)
+	}
+	files[path] = TreeEntry{Mode: mode, Name: path, Hash: hash}
+	return nil
+}
+
+// reset <ref>: branch starts from "from" (or from scratch if there is no from line)
+func (importer *FastImporter) importReset(refName string) error {
+	from, err := importer.readOptional("from")
+	if err != nil {
+		return err
+	}
+	hash := ""
+	if from != "" {
+		if hash, err = importer.resolveCommitish(from); err != nil {
+			return err
+		}
+	}
+	importer.setRef(refName, hash)
+	return nil
+}
+
+// tag <name>: from, optional tagger, data - written as annotated tag object
+func (importer *FastImporter) importTag(name string) error {
+	mark, err := importer.readOptional("mark")
+	if err != nil {
+		return err
+	}
+	from, err := importer.readOptional("from")
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		return fmt.Errorf("tag %s has no from", name)
+	}
+	if _, err := importer.readOptional("original-oid"); err != nil {
+		return err
+	}
+	tagger, err := importer.readOptional("tagger")
+	if err != nil {
+		return err
+	}
+	message, err := importer.readData()
+	if err != nil {
+		return err
+	}
+
+	target, err := importer.resolveCommitish(from)
+	if err != nil {
+		return err
+	}
+	targetType, _, _, err := readObjectFromHash(target)
+	if err != nil {
+		return err
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "object %s\ntype %s\ntag %s\n", target, targetType, name)
+	if tagger != "" {
+		fmt.Fprintf(&content, "tagger %s\n", tagger)
+	}
+	content.WriteString("\n")
+	content.Write(message)
+
+	hash, err := importer.writeObject("tag", []byte(content.String()))
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		importer.marks[mark] = hash
+	}
+	importer.setRef("refs/tags/"+name, hash)
+	return nil
+}
+
+// Resolve from/merge argument - mark, object hash, ref from this stream, or existing ref/revision
+// Null hash means no commit
+func (importer *FastImporter) resolveCommitish(value string) (string, error) {
+	if strings.HasPrefix(value, ":") {
+		hash, ok := importer.marks[value]
+		if !ok {
+			return "", fmt.Errorf("unknown mark %s", value)
+		}
+		return hash, nil
+	}
+	if value == strings.Repeat("0", 40) {
+		return "", nil
+	}
+	if hash, ok := importer.refs[value]; ok {
+		return hash, nil
+	}
+	_, hash, err := resolveRevision(value)
+	return hash, err
+}
+
+// Current tip of ref - from this stream, or from the repository if the stream didn't touch it yet
+func (importer *FastImporter) refTip(refName string) (string, error) {
+	if hash, ok := importer.refs[refName]; ok {
+		return hash, nil
+	}
+	return resolveRef(refName)
+}
+
+func (importer *FastImporter) setRef(refName, hash string) {
+	if _, ok := importer.refs[refName]; !ok {
+		importer.refOrder = append(importer.refOrder, refName)
+	}
+	importer.refs[refName] = hash
+}
+
+// Write every ref touched by the stream (refs reset without a commit are left as they are)
+func (importer *FastImporter) writeRefs() error {
+	for _, refName := range importer.refOrder {
+		if hash := importer.refs[refName]; hash != "" {
+			if err := updateRef(refName, hash, "fast-import"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Paths in files equal to path, or inside directory path
+func pathsUnder(files map[string]TreeEntry, path string) []string {
+	var matched []string
+	for filePath := range files {
+		if path == "" || filePath == path || strings.HasPrefix(filePath, path+"/") {
+			matched = append(matched, filePath)
+		}
+	}
+	return matched
+}
+
+// Parse path argument - C-style quoted or plain. If last is false, plain path ends at the first space
+// Returns path and the rest of the line
+func parseFastImportPath(value string, last bool) (string, string, error) {
+	if !strings.HasPrefix(value, "\"") {
+		if last {
+			return value, "", nil
+		}
+		path, rest, _ := strings.Cut(value, " ")
+		return path, rest, nil
+	}
+
+	for end := 1; end < len(value); end++ {
+		if value[end] == '\\' {
+			end++
+			continue
+		}
+		if value[end] == '"' {
+			path, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return "", "", fmt.Errorf("bad quoted path: %s", value[:end+1])
+			}
+			return path, strings.TrimPrefix(value[end+1:], " "), nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted path: %s", value)
+}
@@ -0,0 +1,336 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Fetch - refs the remote advertises are selected and mapped to local refs by refspecs (remote.<name>.fetch,
+// or the ones given), their objects are downloaded and the local refs updated. Each update is reported:
+//
+//	From /path/to/remote
+//	 * [new branch]      topic      -> origin/topic
+//	   1a2b3c4..5d6e7f8  main       -> origin/main
+//	 + 1a2b3c4...9f8e7d6 rewritten  -> origin/rewritten  (forced update)
+//	 ! [rejected]        v1.0       -> v1.0  (would clobber existing tag)
+//	 * branch            main       -> FETCH_HEAD
+//
+// Updates that are not fast-forwards need "+" on the refspec, existing tags are never moved without it.
+// Tags the remote has that point into fetched history are fetched as well (remote.<name>.tagOpt or --tags
+// and --no-tags change that). FETCH_HEAD lists every fetched ref - the ones marked for merge (the upstream
+// of the current branch, or refs named on the command line) come first, the rest are "not-for-merge".
+
+// Width of the summary column - two abbreviated hashes and "..."
+const fetchSummaryWidth = 17
+
+// Fetch from remote selected by options, writing updated refs to w
+func fetchRemote(ctx context.Context, options FetchOptions, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	headBranch, _, err := readHead()
+	if err != nil {
+		return err
+	}
+	remote := options.Remote
+	if remote == "" {
+		remote = "origin"
+		if name, ok := config.Get("branch." + strings.TrimPrefix(headBranch, "refs/heads/") + ".remote"); ok && headBranch != "" && name != "." {
+			remote = name
+		}
+	}
+	remoteUrl := resolveRemoteUrl(remote)
+
+	configuredSpecs, err := parseRefspecs(config.GetAll("remote." + remote + ".fetch"))
+	if err != nil {
+		return err
+	}
+	specs := configuredSpecs
+	if len(options.Refspecs) > 0 {
+		if specs, err = parseRefspecs(options.Refspecs); err != nil {
+			return err
+		}
+	}
+	// Refs named explicitly (or remote HEAD when nothing is configured) are the ones to merge
+	explicit := len(options.Refspecs) > 0 || len(specs) == 0
+	if len(specs) == 0 {
+		specs = []Refspec{{Source: "HEAD"}}
+	}
+	tagOption, _ := config.Get("remote." + remote + ".tagOpt")
+	fetchTags, followTags := options.Tags || tagOption == "--tags", !options.NoTags && tagOption != "--no-tags"
+	if fetchTags {
+		specs = append(specs, Refspec{Source: "refs/tags/*", Destination: "refs/tags/*", Glob: true})
+	}
+
+	transport, err := newTransport(remoteUrl, "")
+	if err != nil {
+		return fmt.Errorf("failed to connect to remote: %w", err)
+	}
+	defer transport.Close()
+	remoteRefs, _, _, protocolV2, err := discoverRemoteRefs(ctx, transport, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch refs: %w", err)
+	}
+
+	mappings, err := mapFetchRefspecs(specs, remoteRefs)
+	if err != nil {
+		return err
+	}
+	fetchHead := slices.Clone(mappings)
+	if len(options.Refspecs) > 0 {
+		// Refs fetched by command line refspecs update their remote-tracking refs too
+		for _, mapping := range fetchHead {
+			destination, spec := mapRefThroughRefspecs(configuredSpecs, mapping.Source)
+			if destination != "" && destination != mapping.Destination && validFetchDestination(destination) {
+				mappings = append(mappings, refMapping{Source: mapping.Source, Destination: destination, Hash: mapping.Hash, Force: spec.Force})
+			}
+		}
+	}
+
+	filter, _ := config.Get("remote." + remote + ".partialclonefilter")
+	requested := false
+	fetchObjects := func(mappings []refMapping) error {
+		var wants []string
+		for _, mapping := range mappings {
+			wants = append(wants, mapping.Hash)
+		}
+		wants, err := filterMissingObjects(slices.Compact(slices.Sorted(slices.Values(wants))))
+		if err != nil || len(wants) == 0 {
+			return err
+		}
+		// Services answer one request per connection
+		if requested {
+			if _, err := transport.Connect(ctx, "git-upload-pack"); err != nil {
+				return fmt.Errorf("failed to connect to remote: %w", err)
+			}
+		}
+		requested = true
+		pack, _, _, err := fetchClonePack(ctx, transport, protocolV2, wants, 0, filter)
+		if err != nil {
+			return fmt.Errorf("git-upload-pack request failed: %w", err)
+		}
+		objects, err := parsePackFile(ctx, pack)
+		if err != nil {
+			return fmt.Errorf("failed to parse packfile: %w", err)
+		}
+		if err := writePackObjects(ctx, objects); err != nil {
+			return fmt.Errorf("failed to write objects: %w", err)
+		}
+		return nil
+	}
+	if err := fetchObjects(mappings); err != nil {
+		return err
+	}
+	if followTags && !fetchTags {
+		tags, err := followRemoteTags(remoteRefs, mappings)
+		if err != nil {
+			return err
+		}
+		if err := fetchObjects(tags); err != nil {
+			return err
+		}
+		mappings, fetchHead = append(mappings, tags...), append(fetchHead, tags...)
+	}
+
+	forMerge := make(map[string]bool)
+	if explicit {
+		for _, spec := range specs {
+			if !spec.Glob && !spec.Negative && spec.Source != "" {
+				forMerge[findRefByShortName(spec.Source, remoteRefs)] = true
+			}
+		}
+	} else if name, _ := config.Get("branch." + strings.TrimPrefix(headBranch, "refs/heads/") + ".remote"); headBranch != "" && name == remote {
+		for _, merge := range config.GetAll("branch." + strings.TrimPrefix(headBranch, "refs/heads/") + ".merge") {
+			forMerge[merge] = true
+		}
+	}
+	if err := writeFetchHead(fetchHead, forMerge, remoteUrl); err != nil {
+		return err
+	}
+	return updateFetchedRefs(mappings, headBranch, remoteUrl, w)
+}
+
+// Tags the remote has and we don't, pointing to objects that are here now (history fetched here or that
+// was already here) - they are fetched along
+func followRemoteTags(remoteRefs map[string]string, mappings []refMapping) ([]refMapping, error) {
+	fetched := make(map[string]bool)
+	for _, mapping := range mappings {
+		fetched[mapping.Source] = true
+	}
+	localTags, err := listRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	var tags []refMapping
+	for _, refName := range sortedKeys(remoteRefs) {
+		if !strings.HasPrefix(refName, "refs/tags/") || strings.HasSuffix(refName, "^{}") || fetched[refName] {
+			continue
+		}
+		if _, ok := localTags[refName]; ok {
+			continue
+		}
+		target, ok := remoteRefs[refName+"^{}"]
+		if !ok {
+			target = remoteRefs[refName]
+		}
+		if exists, err := objectExists(target); err != nil {
+			return nil, err
+		} else if exists {
+			tags = append(tags, refMapping{Source: refName, Destination: refName, Hash: remoteRefs[refName]})
+		}
+	}
+	return tags, nil
+}
+
+// Write FETCH_HEAD - "<hash>\t[not-for-merge]\t<description>" per fetched ref, refs to merge first
+func writeFetchHead(mappings []refMapping, forMerge map[string]bool, remoteUrl string) error {
+	// Description names the repository without trailing slashes and .git
+	url := strings.TrimRight(remoteUrl, "/")
+	url = strings.TrimSuffix(url, ".git")
+
+	var merge, other strings.Builder
+	seen := make(map[string]bool)
+	for _, mapping := range mappings {
+		if seen[mapping.Source] {
+			continue
+		}
+		seen[mapping.Source] = true
+		if forMerge[mapping.Source] {
+			fmt.Fprintf(&merge, "%s\t\t%s\n", mapping.Hash, fetchHeadDescription(mapping.Source, url))
+		} else {
+			fmt.Fprintf(&other, "%s\tnot-for-merge\t%s\n", mapping.Hash, fetchHeadDescription(mapping.Source, url))
+		}
+	}
+	if err := os.WriteFile(gitDirPath("FETCH_HEAD"), []byte(merge.String()+other.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write FETCH_HEAD: %w", err)
+	}
+	return nil
+}
+
+// Description of fetched ref in FETCH_HEAD - "branch 'main' of <url>"
+func fetchHeadDescription(refName, url string) string {
+	switch {
+	case refName == "HEAD":
+		return url
+	case strings.HasPrefix(refName, "refs/heads/"):
+		return fmt.Sprintf("branch '%s' of %s", strings.TrimPrefix(refName, "refs/heads/"), url)
+	case strings.HasPrefix(refName, "refs/tags/"):
+		return fmt.Sprintf("tag '%s' of %s", strings.TrimPrefix(refName, "refs/tags/"), url)
+	case strings.HasPrefix(refName, "refs/remotes/"):
+		return fmt.Sprintf("remote-tracking branch '%s' of %s", strings.TrimPrefix(refName, "refs/remotes/"), url)
+	}
+	return fmt.Sprintf("'%s' of %s", refName, url)
+}
+
+// Update local refs of mappings and report each change - refs that only go to FETCH_HEAD are reported as
+// such, refs that didn't change are not reported
+func updateFetchedRefs(mappings []refMapping, headBranch, remoteUrl string, w io.Writer) error {
+	// Refs that are up to date are not reported, so they don't widen the column
+	width := 10
+	for _, mapping := range mappings {
+		if current, err := resolveRef(mapping.Destination); mapping.Destination == "" || err != nil || current != mapping.Hash {
+			width = max(width, len(shortRefName(mapping.Source)))
+		}
+	}
+
+	bare := resolveRepoLayout().Bare
+	rejected, reported := false, false
+	for _, mapping := range mappings {
+		remoteName, localName := shortRefName(mapping.Source), shortRefName(mapping.Destination)
+		var flag byte = '*'
+		summary, reason := "", ""
+		if mapping.Destination == "" {
+			summary, localName = "branch", "FETCH_HEAD"
+			if strings.HasPrefix(mapping.Source, "refs/tags/") {
+				summary = "tag"
+			}
+		} else {
+			var err error
+			if flag, summary, reason, err = updateFetchedRef(mapping, headBranch, bare); err != nil {
+				return err
+			}
+		}
+		if flag == '=' {
+			continue
+		}
+		rejected = rejected || flag == '!'
+
+		if !reported {
+			fmt.Fprintf(w, "From %s\n", remoteUrl)
+			reported = true
+		}
+		line := fmt.Sprintf(" %c %-*s %-*s -> %s", flag, fetchSummaryWidth, summary, width, remoteName, localName)
+		if reason != "" {
+			line += "  (" + reason + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+	if rejected {
+		return fmt.Errorf("some local refs could not be updated")
+	}
+	return nil
+}
+
+// Move local ref of mapping to the fetched value - returns flag, summary and reason of the change ('=' when
+// the ref already had it, '!' when the update was rejected)
+func updateFetchedRef(mapping refMapping, headBranch string, bare bool) (byte, string, string, error) {
+	old, err := resolveRef(mapping.Destination)
+	if err != nil {
+		return 0, "", "", err
+	}
+	switch {
+	case old == mapping.Hash:
+		return '=', "[up to date]", "", nil
+	case mapping.Destination == headBranch && !bare:
+		// Work tree of the checked out branch would no longer match it
+		workTree, _ := filepath.Abs(workTreePath())
+		return '!', "[rejected]", fmt.Sprintf("refusing to fetch into branch '%s' checked out at '%s'", headBranch, workTree), nil
+	}
+
+	var flag byte
+	summary, reason := "", ""
+	switch {
+	case old == "" && strings.HasPrefix(mapping.Destination, "refs/tags/"):
+		flag, summary = '*', "[new tag]"
+	case old == "" && strings.HasPrefix(mapping.Source, "refs/heads/"):
+		flag, summary = '*', "[new branch]"
+	case old == "":
+		flag, summary = '*', "[new ref]"
+	case strings.HasPrefix(mapping.Destination, "refs/tags/"):
+		if !mapping.Force {
+			return '!', "[rejected]", "would clobber existing tag", nil
+		}
+		flag, summary = 't', "[tag update]"
+	default:
+		// Only commits have history to fast-forward along
+		fastForward, err := isAncestor(old, mapping.Hash)
+		if err != nil {
+			fastForward = false
+		}
+		switch {
+		case fastForward:
+			flag, summary = ' ', shortHash(old)+".."+shortHash(mapping.Hash)
+		case !mapping.Force:
+			return '!', "[rejected]", "non-fast-forward", nil
+		default:
+			flag, summary, reason = '+', shortHash(old)+"..."+shortHash(mapping.Hash), "forced update"
+		}
+	}
+	reflogMessage := "fetch: fast-forward"
+	switch flag {
+	case '*':
+		reflogMessage = "fetch: storing head"
+	case '+', 't':
+		reflogMessage = "fetch: forced-update"
+	}
+	if err := updateRef(mapping.Destination, mapping.Hash, reflogMessage); err != nil {
+		return 0, "", "", err
+	}
+	return flag, summary, reason, nil
+}
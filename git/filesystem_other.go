@@ -0,0 +1,8 @@
+//go:build !unix
+
+package git
+
+// Devices can't be compared - every path is treated as one filesystem
+func sameFilesystem(a, b string) bool {
+	return true
+}
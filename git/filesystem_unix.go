@@ -0,0 +1,20 @@
+//go:build unix
+
+package git
+
+import (
+	"os"
+	"syscall"
+)
+
+// Check whether both paths are on the same device (filesystem)
+func sameFilesystem(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+	statA, okA := infoA.Sys().(*syscall.Stat_t)
+	statB, okB := infoB.Sys().(*syscall.Stat_t)
+	return !okA || !okB || statA.Dev == statB.Dev
+}
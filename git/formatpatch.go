@@ -0,0 +1,235 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// format-patch - every non-merge commit of the range as an mbox message:
+//
+//	From <hash> Mon Sep 17 00:00:00 2001      - fixed date marks git generated mbox
+//	From: / Date: / Subject: [PATCH n/m]      - author, author date and message subject
+//	<body>
+//	---
+//	<diffstat>                                - stat lines fit in 72 columns, then --summary lines
+//	<diff against first parent>               - renames detected as diff.renames or -M/-C say, binary
+//	                                            files as binary patches (so am can apply them)
+//	--
+//	mini-git
+//
+// Patches go to files 0001-<subject>.patch (or to stdout with --stdout), oldest commit first.
+
+// Signature line that ends every patch
+const formatPatchSignature = "mini-git"
+
+// Longest sanitized subject used in patch file names
+const patchNameMaxLength = 52
+
+// Commits for format-patch, oldest first - "<since>" means since..HEAD, -<n> takes last n commits
+func formatPatchCommits(options FormatPatchOptions) ([]string, error) {
+	var include, exclude []string
+	switch {
+	case strings.Contains(options.Range, ".."):
+		from, to, _ := strings.Cut(options.Range, "..")
+		if from == "" {
+			from = "HEAD"
+		}
+		if to == "" {
+			to = "HEAD"
+		}
+		_, fromHash, err := resolveRevision(from)
+		if err != nil {
+			return nil, err
+		}
+		_, toHash, err := resolveRevision(to)
+		if err != nil {
+			return nil, err
+		}
+		include, exclude = []string{toHash}, []string{fromHash}
+	case options.MaxCount > 0:
+		// With -<n>, revision is the newest commit (HEAD by default)
+		tip := options.Range
+		if tip == "" {
+			tip = "HEAD"
+		}
+		_, hash, err := resolveRevision(tip)
+		if err != nil {
+			return nil, err
+		}
+		include = []string{hash}
+	default:
+		_, sinceHash, err := resolveRevision(options.Range)
+		if err != nil {
+			return nil, err
+		}
+		_, headHash, err := resolveRevision("HEAD")
+		if err != nil {
+			return nil, err
+		}
+		include, exclude = []string{headHash}, []string{sinceHash}
+	}
+
+	walked, err := revList(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merges can't be expressed as a single patch
+	var commits []string
+	for _, hash := range walked {
+		if options.MaxCount > 0 && len(commits) == options.MaxCount {
+			break
+		}
+		parents, err := readCommitParents(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(parents) <= 1 {
+			commits = append(commits, hash)
+		}
+	}
+
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// Write patches for commits - to output with --stdout, to files otherwise (returns written file names)
+func formatPatch(commits []string, options FormatPatchOptions, output io.Writer) ([]string, error) {
+	numbered := options.Numbered || (len(commits) > 1 && !options.NoNumbered)
+
+	if !options.Stdout && options.OutputDir != "" {
+		if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	diffOptions, err := resolveDiffOptions(options.Diff)
+	if err != nil {
+		return nil, err
+	}
+	diffOptions.BinaryPatch = true
+
+	var files []string
+	for i, hash := range commits {
+		prefix := "[PATCH]"
+		if numbered {
+			prefix = fmt.Sprintf("[PATCH %d/%d]", i+1, len(commits))
+		}
+
+		var patch bytes.Buffer
+		subject, err := writeCommitPatch(&patch, hash, prefix, diffOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		if options.Stdout {
+			if _, err := output.Write(patch.Bytes()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fileName := filepath.Join(options.OutputDir, fmt.Sprintf("%04d-%s.patch", i+1, sanitizePatchSubject(subject)))
+		if err := os.WriteFile(fileName, patch.Bytes(), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", fileName, err)
+		}
+		files = append(files, fileName)
+	}
+	return files, nil
+}
+
+// Write one commit as mbox message - returns commit subject
+func writeCommitPatch(w io.Writer, hash, prefix string, diffOptions DiffOptions) (string, error) {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	name, email, date, err := parseSignature(commit.Author)
+	if err != nil {
+		return "", err
+	}
+	subject, body := splitCommitMessage(commit.Message)
+
+	parentTree := ""
+	if len(commit.Parents) > 0 {
+		if parentTree, err = readCommitTreeHash(commit.Parents[0]); err != nil {
+			return "", err
+		}
+	}
+	diffs, err := diffTreeFiles(parentTree, commit.Tree, diffOptions)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(w, "From %s Mon Sep 17 00:00:00 2001\n", hash)
+	fmt.Fprintf(w, "From: %s <%s>\n", encodeHeaderWord(name), email)
+	fmt.Fprintf(w, "Date: %s\n", date.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(w, "Subject: %s %s\n", prefix, encodeHeaderWord(subject))
+	if !isASCII(commit.Message) || !isASCII(name) {
+		fmt.Fprintf(w, "MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+	}
+	fmt.Fprintln(w)
+	if body != "" {
+		fmt.Fprintf(w, "%s\n\n", body)
+	}
+
+	fmt.Fprintln(w, "---")
+	writeDiffStat(w, diffs, DiffStatOptions{Stat: true, Summary: true, Width: 72})
+	fmt.Fprintln(w)
+	for _, diff := range diffs {
+		writeFileDiff(w, diff, diffOptions)
+	}
+	fmt.Fprintf(w, "-- \n%s\n\n", formatPatchSignature)
+	return subject, nil
+}
+
+// RFC 2047 encoded word for header values that are not plain ASCII
+func encodeHeaderWord(value string) string {
+	if isASCII(value) {
+		return value
+	}
+	return mime.QEncoding.Encode("UTF-8", value)
+}
+
+func isASCII(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Subject as file name part - letters, digits, '.' and '_' are kept, other runs become a single '-'
+func sanitizePatchSubject(subject string) string {
+	var name strings.Builder
+	dash := false
+	for _, c := range subject {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_':
+			if dash && name.Len() > 0 {
+				name.WriteByte('-')
+			}
+			dash = false
+			name.WriteRune(c)
+		default:
+			dash = true
+		}
+	}
+
+	sanitized := name.String()
+	for strings.Contains(sanitized, "..") {
+		sanitized = strings.ReplaceAll(sanitized, "..", ".")
+	}
+	if len(sanitized) > patchNameMaxLength {
+		sanitized = sanitized[:patchNameMaxLength]
+	}
+	return strings.Trim(sanitized, ".-")
+}
@@ -0,0 +1,339 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Fsck - checks the object database:
+//   - every object of own object directory (loose and packed) reads back, hashes to its name and parses -
+//     with --connectivity-only only objects that are walked are read, and blobs are not even read
+//   - refs, HEAD and reflog entries point to objects that exist ("invalid sha1 pointer")
+//   - everything reachable from them and from the index exists ("missing <type> <hash>"), and every link
+//     points to an object of the type it claims ("broken link from <type> <hash> to <type> <hash>")
+//   - objects nothing reaches are "dangling" when no other unreachable object refers to them either -
+//     --unreachable lists all of them, --no-dangling none
+//
+// Problems go to report, findings to w. The exit status is 1 for broken objects, 2 for missing objects,
+// broken links and bad refs - or both. Objects missing in a partial clone are promised by the remote, so
+// they are not errors.
+
+const (
+	fsckErrorObject    = 1
+	fsckErrorReachable = 2
+)
+
+// Check objects and connectivity - returns the exit status (0 when everything is fine)
+func fsck(options FsckOptions, w, report io.Writer) (int, error) {
+	defer tracePerformance("fsck")()
+	// Replaced objects are checked as they are stored
+	defer ignoreReplaceRefs()()
+	promisor, err := promisorRemoteUrl()
+	if err != nil {
+		return 0, err
+	}
+	stored, err := listStoredObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	status := 0
+	objects := make(map[string]*fsckObject, len(stored))
+	// Read and parse an object - nil (reported once) when it can't be
+	load := func(hash string) *fsckObject {
+		if object, ok := objects[hash]; ok {
+			return object
+		}
+		object, err := loadFsckObject(hash)
+		if err != nil {
+			fmt.Fprintf(report, "error: %s: %s\n", hash, err)
+			status |= fsckErrorObject
+		}
+		objects[hash] = object
+		return object
+	}
+	if !options.ConnectivityOnly {
+		for _, hash := range stored {
+			load(hash)
+		}
+	}
+
+	shallow, err := loadShallowSet()
+	if err != nil {
+		return 0, err
+	}
+	roots, refStatus, err := fsckRoots(promisor != "", report)
+	if err != nil {
+		return 0, err
+	}
+	status |= refStatus
+
+	// Walk from the roots - links are checked for existence and type
+	type pending struct {
+		hash     string
+		objType  string
+		from     string
+		fromType string
+	}
+	queue := make([]pending, 0, len(roots))
+	for _, root := range roots {
+		queue = append(queue, pending{hash: root.Hash, objType: root.Type})
+	}
+	// Type of every reachable object - empty when it wasn't read
+	reachable := make(map[string]string)
+	missing := make(map[string]string)
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if objType, ok := reachable[item.hash]; ok {
+			// Every link is checked, not only the first one reaching an object
+			if objType != "" && item.objType != "" && objType != item.objType {
+				fsckBrokenLink(w, report, item.from, item.fromType, item.hash, objType, item.objType)
+				status |= fsckErrorReachable
+			}
+			continue
+		}
+		exists, err := objectExists(item.hash)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			if promisor == "" {
+				missing[item.hash] = item.objType
+				status |= fsckErrorReachable
+			}
+			continue
+		}
+		reachable[item.hash] = ""
+		if options.ConnectivityOnly && item.objType == "blob" {
+			continue
+		}
+
+		object := load(item.hash)
+		if object == nil {
+			continue
+		}
+		reachable[item.hash] = object.Type
+		if item.objType != "" && object.Type != item.objType {
+			fsckBrokenLink(w, report, item.from, item.fromType, item.hash, object.Type, item.objType)
+			status |= fsckErrorReachable
+		}
+		for _, link := range object.Links {
+			if shallow[item.hash] && object.Type == "commit" && link.Type == "commit" {
+				// Parents of shallow commits are not in the repository
+				continue
+			}
+			queue = append(queue, pending{link.Hash, link.Type, item.hash, object.Type})
+		}
+	}
+
+	for _, hash := range sortedKeys(missing) {
+		objType := missing[hash]
+		if objType == "" {
+			objType = "object"
+		}
+		fmt.Fprintf(w, "missing %s %s\n", objType, hash)
+	}
+
+	// Unreachable objects - dangling unless another unreachable object refers to them
+	var unreachable []string
+	referenced := make(map[string]bool)
+	for _, hash := range stored {
+		if _, ok := reachable[hash]; ok {
+			continue
+		}
+		object := objects[hash]
+		if object == nil {
+			if options.ConnectivityOnly {
+				// Broken unreachable objects don't matter for connectivity
+				object, _ = loadFsckObject(hash)
+			}
+			if object == nil {
+				continue
+			}
+		}
+		unreachable = append(unreachable, hash)
+		objects[hash] = object
+		for _, link := range object.Links {
+			referenced[link.Hash] = true
+		}
+	}
+	for _, hash := range unreachable {
+		switch {
+		case options.Unreachable:
+			fmt.Fprintf(w, "unreachable %s %s\n", objects[hash].Type, hash)
+		case !options.NoDangling && !referenced[hash]:
+			fmt.Fprintf(w, "dangling %s %s\n", objects[hash].Type, hash)
+		}
+	}
+	return status, nil
+}
+
+// Report a link to an object of another type than the linking object claims
+func fsckBrokenLink(w, report io.Writer, from, fromType, hash, objType, expected string) {
+	fmt.Fprintf(report, "error: object %s is a %s, not a %s\n", hash, objType, expected)
+	fmt.Fprintf(w, "broken link from %7s %s\n", fromType, from)
+	fmt.Fprintf(w, "              to %7s %s\n", expected, hash)
+}
+
+// Hashes of objects in own object directory - loose and packed, sorted
+func listStoredObjects() ([]string, error) {
+	loose, err := listLooseObjects()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(loose))
+	for _, hash := range loose {
+		seen[hash] = true
+	}
+	indexes, err := loadPackIndexes()
+	if err != nil {
+		return nil, err
+	}
+	packDir := objectDirPath("pack")
+	for _, index := range indexes {
+		if filepath.Dir(index.PackPath) != packDir {
+			// Alternates are checked in their own repositories
+			continue
+		}
+		for i := 0; i < index.count(); i++ {
+			seen[hex.EncodeToString(index.Hashes[i*20:i*20+20])] = true
+		}
+	}
+	return sortedKeys(seen), nil
+}
+
+// Read object and the links it has to other objects
+func loadFsckObject(hash string) (*fsckObject, error) {
+	typeName, _, content, err := readObjectFromHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	object := &fsckObject{Type: typeName}
+	switch typeName {
+	case "commit", "tag":
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == "" {
+				break
+			}
+			key, value, _ := strings.Cut(line, " ")
+			switch key {
+			case "tree":
+				object.Links = append(object.Links, fsckLink{value, "tree"})
+			case "parent":
+				object.Links = append(object.Links, fsckLink{value, "commit"})
+			case "object":
+				object.Links = append(object.Links, fsckLink{Hash: value})
+			case "type":
+				if len(object.Links) > 0 {
+					object.Links[len(object.Links)-1].Type = value
+				}
+			}
+		}
+		if typeName == "commit" && (len(object.Links) == 0 || object.Links[0].Type != "tree") {
+			return nil, fmt.Errorf("invalid format - expected 'tree' line")
+		}
+		if typeName == "tag" && (len(object.Links) == 0 || object.Links[0].Type == "") {
+			return nil, fmt.Errorf("invalid format - expected 'object' and 'type' lines")
+		}
+	case "tree":
+		entries, err := parseTreeContent(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tree: %w", err)
+		}
+		for _, entry := range entries {
+			switch entry.Mode {
+			case "160000":
+			case "40000":
+				object.Links = append(object.Links, fsckLink{entry.Hash, "tree"})
+			default:
+				object.Links = append(object.Links, fsckLink{entry.Hash, "blob"})
+			}
+		}
+	}
+	return object, nil
+}
+
+// Objects fsck walks from - refs, HEAD, reflog entries and blobs of the index. Refs and reflog entries
+// pointing to missing objects are reported (not in a partial clone), returning the exit status they cause.
+func fsckRoots(partial bool, report io.Writer) ([]fsckLink, int, error) {
+	status := 0
+	var roots []fsckLink
+	check := func(hash, problem string) error {
+		exists, err := objectExists(hash)
+		if err != nil {
+			return err
+		}
+		if exists {
+			roots = append(roots, fsckLink{Hash: hash})
+		} else if !partial {
+			fmt.Fprintf(report, "error: %s %s\n", problem, hash)
+			status |= fsckErrorReachable
+		}
+		return nil
+	}
+
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(refs) == 0 {
+		fmt.Fprintln(report, "notice: No default references")
+	}
+	for _, name := range sortedKeys(refs) {
+		if err := check(refs[name], name+": invalid sha1 pointer"); err != nil {
+			return nil, 0, err
+		}
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return nil, 0, err
+	}
+	if head == "" {
+		fmt.Fprintf(report, "notice: HEAD points to an unborn branch (%s)\n", shortRefName(branch))
+	} else if err := check(head, "HEAD: invalid sha1 pointer"); err != nil {
+		return nil, 0, err
+	}
+
+	reflogs, err := listReflogs()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, name := range reflogs {
+		entries, err := readReflog(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, entry := range entries {
+			for _, hash := range []string{entry.Old, entry.New} {
+				if hash == zeroHash {
+					continue
+				}
+				if err := check(hash, name+": invalid reflog entry"); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	}
+
+	if !resolveRepoLayout().Bare {
+		entries, err := readGitIndex()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read index: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.Mode != 0160000 {
+				roots = append(roots, fsckLink{hex.EncodeToString(entry.Hash), "blob"})
+			}
+		}
+		if state, ok := indexStateCache[indexFilePath()]; ok {
+			for _, hash := range state.CacheTree.validHashes() {
+				roots = append(roots, fsckLink{hash, "tree"})
+			}
+		}
+	}
+	return roots, status, nil
+}
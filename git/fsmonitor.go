@@ -0,0 +1,174 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fsmonitor hook (core.fsmonitor) - a file system watcher tells which paths changed since the last query,
+// so status doesn't have to stat every file and directory:
+//
+//	<hook> 2 <token>     -> new token NUL, then changed paths (NUL terminated)
+//	<hook> 1 <time ns>   -> changed paths (NUL terminated), the query time is the new token
+//
+// "/" as the first path means everything may have changed. Token and entries that were unchanged at the
+// last query are kept in the FSMN index extension:
+//
+//	4 byte version (1: 8 byte time, 2: token NUL) + 4 byte bitmap size + EWAH bitmap of changed entries
+
+// Token used for the first query, when there is no token yet
+const fsmonitorInitialToken = "builtin:fake"
+
+// Hook command from core.fsmonitor - the builtin daemon (core.fsmonitor=true) is not supported
+func fsmonitorHook() (string, bool) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", false
+	}
+	value, ok := config.Get("core.fsmonitor")
+	if !ok || value == "" {
+		return "", false
+	}
+	// Boolean value either disables fsmonitor or selects the daemon - anything else is the hook path
+	if parseBoolValue(value, true) == parseBoolValue(value, false) {
+		return "", false
+	}
+	return expandConfigPath(value), true
+}
+
+// Parse FSMN extension - returns token and bitmap of entries that changed (bit per index entry)
+func parseFSMonitorExtension(data []byte) (string, []bool, error) {
+	if len(data) < 4 {
+		return "", nil, fmt.Errorf("fsmonitor extension is truncated")
+	}
+	version := binary.BigEndian.Uint32(data[0:4])
+	offset := 4
+
+	var token string
+	switch version {
+	case 1:
+		if len(data) < offset+8 {
+			return "", nil, fmt.Errorf("fsmonitor extension is truncated")
+		}
+		token = strconv.FormatUint(binary.BigEndian.Uint64(data[offset:]), 10)
+		offset += 8
+	case 2:
+		end := bytes.IndexByte(data[offset:], 0)
+		if end == -1 {
+			return "", nil, fmt.Errorf("fsmonitor token is not terminated")
+		}
+		token = string(data[offset : offset+end])
+		offset += end + 1
+	default:
+		return "", nil, fmt.Errorf("bad fsmonitor version %d", version)
+	}
+
+	if len(data) < offset+4 {
+		return "", nil, fmt.Errorf("fsmonitor extension is truncated")
+	}
+	bitmapSize := int(binary.BigEndian.Uint32(data[offset:]))
+	offset += 4
+	if len(data) < offset+bitmapSize {
+		return "", nil, fmt.Errorf("fsmonitor bitmap is truncated")
+	}
+	dirty, _, err := decodeEwahBitmap(data[offset : offset+bitmapSize])
+	if err != nil {
+		return "", nil, err
+	}
+	return token, dirty, nil
+}
+
+// Write FSMN extension data (version 2) - entries must be in index order
+func writeFSMonitorExtension(token string, entries []IndexEntry) []byte {
+	dirty := make([]bool, len(entries))
+	for i, entry := range entries {
+		dirty[i] = !entry.FSMonitorValid
+	}
+	bitmap := encodeEwahBitmap(dirty)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	buf.WriteString(token)
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.BigEndian, uint32(len(bitmap)))
+	buf.Write(bitmap)
+	return buf.Bytes()
+}
+
+// Ask fsmonitor hook what changed since the last query - entries and untracked cache directories of changed
+// paths are invalidated. Without an answer (hook failed, first query, or "/") nothing is trusted.
+func refreshFSMonitor(hook string, state *IndexState, entries []IndexEntry, cache *UntrackedCache) {
+	token := state.FSMonitorToken
+	if token == "" {
+		token = fsmonitorInitialToken
+	}
+
+	config, _ := loadConfig()
+	version := int64(-1)
+	if config != nil {
+		version = config.GetInt("core.fsmonitorHookVersion", -1)
+	}
+
+	var newToken string
+	var changed []string
+	succeeded := false
+	if version == -1 || version == 2 {
+		if output, err := runFSMonitorHook(hook, 2, token); err == nil {
+			fields := strings.Split(string(output), "\x00")
+			newToken, changed, succeeded = fields[0], fields[1:], fields[0] != ""
+		}
+	}
+	if !succeeded && (version == -1 || version == 1) {
+		// Version 1 token is the time of the query - taken before the hook runs, so nothing is missed
+		queryTime := strconv.FormatInt(time.Now().UnixNano(), 10)
+		if output, err := runFSMonitorHook(hook, 1, token); err == nil {
+			newToken, changed, succeeded = queryTime, strings.Split(string(output), "\x00"), true
+		}
+	}
+
+	if !succeeded || state.FSMonitorToken == "" || (len(changed) > 0 && changed[0] == "/") {
+		for i := range entries {
+			entries[i].FSMonitorValid = false
+		}
+		if cache != nil {
+			cache.UseFSMonitor = false
+		}
+	} else {
+		for _, changedPath := range changed {
+			if changedPath == "" {
+				continue
+			}
+			dirPath := strings.TrimSuffix(changedPath, "/")
+			for i := range entries {
+				if entries[i].Path == dirPath || strings.HasPrefix(entries[i].Path, dirPath+"/") {
+					entries[i].FSMonitorValid = false
+				}
+			}
+			cache.invalidate(dirPath)
+			cache.invalidate(dirPath + "/")
+		}
+		if cache != nil {
+			cache.UseFSMonitor = true
+		}
+	}
+
+	if succeeded {
+		state.FSMonitorToken = newToken
+	} else {
+		state.FSMonitorToken = ""
+	}
+}
+
+// Run hook through the shell in the work tree root (as git does)
+func runFSMonitorHook(hook string, version int, token string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", hook+` "$@"`, hook, strconv.Itoa(version), token)
+	cmd.Dir = workTreePath()
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
@@ -0,0 +1,937 @@
+// Package git is mini-git as a library - a repository is opened with Open (or created with Init or Clone)
+// and used through the methods of Repository, which mirror git commands. Commands that write output take
+// an io.Writer; the mygit command (app/) is a thin command line interface over this package. Network
+// operations take a context.Context - canceling it stops the transfer (and the checkout after clone).
+//
+// Global options (-C, --git-dir, --work-tree, --no-replace-objects) make a RepoContext (NewRepoContext);
+// repositories are opened, created, cloned and served through it, and Open, Init and Clone of the package
+// use the default one (current directory, GIT_DIR and GIT_WORK_TREE). Every Repository has its own layout
+// (git directory, work tree, object directory), resolved when it is opened; the process never changes its
+// directory or environment. A method makes the layout and context of its repository the active ones while
+// it runs, so methods of different repositories run one at a time. Configuration, objects and packs are
+// cached per process (by path). Paths given to methods are relative to the directory the repository was
+// opened from.
+//
+// Because of the active layout the library is one package, not separate object, index, refs, pack and
+// transport packages - only Repository, RepoContext and the option and result types are its API. Work on
+// other repositories (server side of local fetch and push, git daemon and HTTP connections) runs in child
+// processes: RepoContext.Program, which the mygit command sets to itself, or git when it is empty.
+package git
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Context of global options (-C, --git-dir, --work-tree, --no-replace-objects, program) - repositories are
+// opened, created and cloned through it
+func NewRepoContext(options GlobalOptions) (*RepoContext, error) {
+	return newRepoContext(options)
+}
+
+// Open repository containing path in the default context (see RepoContext.Open)
+func Open(path string) (*Repository, error) {
+	return defaultRepoContext.Open(path)
+}
+
+// Create repository in path in the default context (see RepoContext.Init)
+func Init(path string, options InitOptions) (*Repository, error) {
+	return defaultRepoContext.Init(path, options)
+}
+
+// Clone remote repository in the default context (see RepoContext.Clone)
+func Clone(ctx context.Context, options CloneOptions, w io.Writer) (*Repository, error) {
+	return defaultRepoContext.Clone(ctx, options, w)
+}
+
+// Open repository containing path (relative to the context directory) - it is searched from path upwards
+// (see discoverRepository)
+func (c *RepoContext) Open(path string) (*Repository, error) {
+	start := c.path(path)
+	if info, err := os.Stat(start); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("failed to open %s directory: not a directory", path)
+	}
+	layout, prefix, err := c.discoverRepository(start)
+	if errors.Is(err, ErrNotARepository) || (err == nil && !isGitDirectory(layout.GitDir)) {
+		return nil, fmt.Errorf("%w (or any of the parent directories): %s", ErrNotARepository, path)
+	} else if err != nil {
+		return nil, err
+	}
+	return &Repository{Prefix: prefix, layout: layout, dir: start, context: c}, nil
+}
+
+// Create repository in path (created when missing) - existing repository is reinitialized
+func (c *RepoContext) Init(path string, options InitOptions) (*Repository, error) {
+	dir := c.path(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s directory: %w", path, err)
+	}
+	r := &Repository{layout: c.initRepoLayout(dir, options.Bare), dir: dir, context: c}
+	defer r.enter()()
+	if err := initRepo(options); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Clone remote repository into options.Directory - progress is written to w. Canceling ctx stops the
+// transfer and checkout (the new directory is left as it is).
+func (c *RepoContext) Clone(ctx context.Context, options CloneOptions, w io.Writer) (*Repository, error) {
+	return c.cloneRepository(ctx, options, w)
+}
+
+// Serve fetch/clone of repository in options.Directory over input/output (upload-pack)
+func (c *RepoContext) UploadPack(options UploadPackOptions, input io.Reader, output io.Writer) error {
+	return c.uploadPack(options, input, output)
+}
+
+// Serve push to repository in options.Directory over input/output (receive-pack)
+func (c *RepoContext) ReceivePack(options ReceivePackOptions, input io.Reader, output io.Writer) error {
+	return c.receivePack(options, input, output)
+}
+
+// Serve repositories read-only over git:// until ctx is canceled - connections are logged to w
+func (c *RepoContext) Daemon(ctx context.Context, options DaemonOptions, w io.Writer) error {
+	return c.runDaemon(ctx, options, w)
+}
+
+// Serve repositories over smart HTTP until ctx is canceled - listening address is written to w
+func (c *RepoContext) ServeHttp(ctx context.Context, options ServeHttpOptions, w io.Writer) error {
+	return c.serveHttp(ctx, options, w)
+}
+
+// List refs of remote (name of a remote of the repository in the context directory, or URL) - as JSON with
+// options.JSON
+func (c *RepoContext) LsRemote(ctx context.Context, options LsRemoteOptions, w io.Writer) error {
+	refs, err := c.listRemoteRefs(ctx, options)
+	if err != nil {
+		return err
+	}
+	if options.JSON {
+		if refs == nil {
+			refs = []RemoteRef{}
+		}
+		return writeJSON(w, refs)
+	}
+	for _, ref := range refs {
+		fmt.Fprintf(w, "%s\t%s\n", ref.Hash, ref.Name)
+	}
+	return nil
+}
+
+// Run credential-store helper action (get, store or erase) on credential read from input - answer of get
+// is written to output
+func CredentialStore(storeFile, action string, input io.Reader, output io.Writer) error {
+	credential, err := readCredential(input)
+	if err != nil {
+		return fmt.Errorf("failed to read credential: %w", err)
+	}
+	found, err := credentialStore(storeFile, action, credential)
+	if err != nil {
+		return err
+	}
+	if found != nil {
+		writeCredential(output, found)
+	}
+	return nil
+}
+
+// Send standard output of command (log, diff) through the pager until StopPager - see startPager
+func StartPager(command string, disabled bool) {
+	startPager(command, disabled)
+}
+
+// Finish paged output
+func StopPager() {
+	stopPager()
+}
+
+// Path relative to the work tree root
+func (r *Repository) path(path string) string {
+	return prefixPath(r.Prefix, path)
+}
+
+// Paths relative to the work tree root
+func (r *Repository) paths(paths []string) []string {
+	return prefixPaths(r.Prefix, paths)
+}
+
+// File (not a pathspec) relative to the directory the repository was opened from - absolute paths and "-"
+// (stdin) are kept
+func (r *Repository) file(path string) string {
+	if path == "-" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(r.dir, path)
+}
+
+func (r *Repository) files(paths []string) []string {
+	files := make([]string, len(paths))
+	for i, path := range paths {
+		files[i] = r.file(path)
+	}
+	return files
+}
+
+// Write object - type with -t, size with -s, content with -p (streamed, so big blobs aren't buffered); as
+// JSON, the object with its type and size (-p adds the parsed content)
+func (r *Repository) CatFile(objectHash, flag string, asJSON bool, w io.Writer) error {
+	defer r.enter()()
+	if asJSON {
+		object, err := jsonObject(objectHash, flag == "-p")
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, object)
+	}
+
+	stream, err := openObjectStream(objectHash)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	switch flag {
+	case "-t":
+		_, err = fmt.Fprintln(w, stream.Type)
+	case "-s":
+		_, err = fmt.Fprintln(w, stream.Size)
+	case "-p":
+		writer := bufio.NewWriter(w)
+		if _, err = io.Copy(writer, stream); err != nil {
+			return err
+		}
+		writer.WriteString("\n")
+		err = writer.Flush()
+	}
+	return err
+}
+
+// Hash file as blob (written to .git/objects with write) - returns its hash
+func (r *Repository) HashObject(path string, write bool) (string, error) {
+	defer r.enter()()
+	path = r.file(path)
+
+	// Files above core.bigFileThreshold are streamed as they are, without line ending conversion
+	if isBigFile(path, bigFileThreshold()) {
+		hashFile := hashBlobFromFile
+		if write {
+			hashFile = writeBlobFromFile
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", hash), nil
+	}
+
+	content, _, err := readObjectFromPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Text files are normalized (CRLF -> LF) according to core.autocrlf and attributes
+	converter, err := newEolConverter()
+	if err != nil {
+		return "", err
+	}
+	objectBytes := generateObjectByte("blob", converter.toGit(filepath.ToSlash(filepath.Clean(path)), content))
+	hash := hashObject(objectBytes)
+	if write {
+		if _, err := writeObject(objectBytes); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hash), nil
+}
+
+// Write tree entries (only names with --name-only flag, as JSON with asJSON)
+func (r *Repository) LsTree(treeHash, flag string, asJSON bool, w io.Writer) error {
+	defer r.enter()()
+	_, _, content, err := readObjectFromHash(treeHash)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		entries, err := jsonTreeEntries(content)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, entries)
+	}
+	return printTreeData(content, flag, w)
+}
+
+// Build tree objects from the whole staging area - returns hash of the root tree
+func (r *Repository) WriteTree() (string, error) {
+	defer r.enter()()
+	return writeTreeFromIndex()
+}
+
+// Create commit object of tree with message (and parent, when not empty) - returns its hash
+func (r *Repository) CommitTree(treeHash, message, parentHash string) (string, error) {
+	defer r.enter()()
+	hash, err := writeObject(generateObjectByte("commit", createCommitContent(treeHash, message, parentHash)))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash), nil
+}
+
+// Add files (ignored ones only with force) to the index
+func (r *Repository) Add(paths []string, force bool) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	return addPaths(r.paths(paths), force)
+}
+
+// Write status of the work tree - long format, porcelain format or JSON, as options say
+func (r *Repository) Status(options StatusOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	status, err := computeStatus(options.ShowIgnored)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case options.JSON:
+		return writeJSON(w, jsonStatus(status, options.ShowIgnored))
+	case options.Porcelain != 0:
+		return printPorcelainStatus(status, options, w)
+	}
+	return printStatus(status, options, w)
+}
+
+// Write index entries selected by options
+func (r *Repository) LsFiles(options LsFilesOptions, w io.Writer) error {
+	defer r.enter()()
+	options.Paths = r.paths(options.Paths)
+	return listFiles(options, r.Prefix, w)
+}
+
+// Remove untracked (and, depending on options, ignored) files - removed paths are written to w
+func (r *Repository) Clean(options CleanOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	return cleanWorkTree(options.DryRun, options.Dirs, options.NoIgnore, options.OnlyIgnored, w)
+}
+
+// Write paths that are ignored (with the matching pattern when verbose, also for not ignored paths with
+// nonMatching) - returns whether any path is ignored
+func (r *Repository) CheckIgnore(paths []string, verbose, nonMatching bool, w io.Writer) (bool, error) {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return false, err
+	}
+	matcher, err := newIgnoreMatcher()
+	if err != nil {
+		return false, err
+	}
+
+	anyIgnored := false
+	for _, arg := range paths {
+		relPath := filepath.ToSlash(filepath.Clean(r.path(arg)))
+		info, statErr := os.Stat(workTreePath(filepath.FromSlash(relPath)))
+		isDir := statErr == nil && info.IsDir()
+
+		pattern := matcher.match(relPath, isDir)
+		ignored := matcher.isIgnored(relPath, isDir)
+		if ignored {
+			anyIgnored = true
+		}
+
+		switch {
+		case verbose && pattern != nil && (ignored || nonMatching):
+			negation := ""
+			if pattern.Negate {
+				negation = "!"
+			}
+			fmt.Fprintf(w, "%s:%d:%s%s\t%s\n", pattern.Source, pattern.Line, negation, pattern.Pattern, arg)
+		case verbose && nonMatching && !ignored:
+			fmt.Fprintf(w, "::\t%s\n", arg)
+		case ignored:
+			fmt.Fprintln(w, arg)
+		}
+	}
+	return anyIgnored, nil
+}
+
+// Write "<path>: <attr>: <value>" for every requested attribute of paths (every specified one with all)
+func (r *Repository) CheckAttr(attributes, paths []string, all bool, w io.Writer) error {
+	defer r.enter()()
+	matcher, err := newAttributeMatcher()
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range paths {
+		pathAttributes := matcher.attributesFor(filepath.ToSlash(filepath.Clean(r.path(arg))))
+		names := attributes
+		if all {
+			names = sortedAttributeNames(pathAttributes)
+		}
+		for _, name := range names {
+			fmt.Fprintf(w, "%s: %s: %s\n", arg, name, pathAttributes[name])
+		}
+	}
+	return nil
+}
+
+// Move loose refs (tags only, unless all) into packed-refs
+func (r *Repository) PackRefs(all, noPrune bool) error {
+	defer r.enter()()
+	return packRefs(all, noPrune)
+}
+
+// Pack refs, expire reflogs, repack and prune objects, write commit-graph - with options.Auto only when
+// gc.auto thresholds are exceeded
+func (r *Repository) Gc(options GcOptions, w io.Writer) error {
+	defer r.enter()()
+	return runGc(options, w)
+}
+
+// Consolidate packs - loose objects into a new pack, everything into one (options.All) or small packs
+// together (options.Geometric)
+func (r *Repository) Repack(options RepackOptions, w io.Writer) error {
+	defer r.enter()()
+	return runRepack(options, w)
+}
+
+// List commits (and with options.Objects their trees and blobs) reachable from revisions
+func (r *Repository) RevList(options RevListOptions, w io.Writer) error {
+	defer r.enter()()
+	return listRevisions(options, w)
+}
+
+// Rewrite every commit without options.RemovePaths and with identities of options.Mailmap, then move refs
+// and the work tree to the new history - progress and summary are written to w
+func (r *Repository) RewriteHistory(options RewriteHistoryOptions, w io.Writer) error {
+	defer r.enter()()
+	if options.Mailmap != "" {
+		options.Mailmap = r.file(options.Mailmap)
+	}
+	return rewriteHistory(options, w)
+}
+
+// Check objects and their connectivity - findings go to w, errors to report. Returns the exit status (1 -
+// broken objects, 2 - missing objects, broken links or bad refs).
+func (r *Repository) Fsck(options FsckOptions, w, report io.Writer) (int, error) {
+	defer r.enter()()
+	return fsck(options, w, report)
+}
+
+// Drop old reflog entries - returns how many were dropped
+func (r *Repository) ReflogExpire(options ReflogExpireOptions, w io.Writer) (int, error) {
+	defer r.enter()()
+	return expireReflogs(options, w)
+}
+
+// Delete unreachable loose objects (older than options.Expire) - returns how many were deleted
+func (r *Repository) Prune(options PruneOptions, w io.Writer) (int, error) {
+	defer r.enter()()
+	return pruneObjects(options, w)
+}
+
+// Run maintenance tasks (the enabled ones, unless options name them)
+func (r *Repository) Maintenance(options MaintenanceOptions, w io.Writer) error {
+	defer r.enter()()
+	return runMaintenance(options, w)
+}
+
+// Write multi-pack-index for every pack - returns number of packs and objects
+func (r *Repository) MultiPackIndex() (int, int, error) {
+	defer r.enter()()
+	return writeMultiPackIndex()
+}
+
+// Run sparse-checkout subcommand - cone mode directories are relative to the starting directory, plain
+// patterns to the work tree root
+func (r *Repository) SparseCheckout(options SparseCheckoutOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	if options.Command == "set" && sparseConeMode(options) {
+		options.Directories = r.paths(options.Directories)
+	}
+	return sparseCheckout(options, w)
+}
+
+// Write refs and everything reachable from them into bundle file
+func (r *Repository) Bundle(file string, revs []string) error {
+	defer r.enter()()
+	return createBundle(r.file(file), revs)
+}
+
+// Write fast-import stream of refs (every branch and tag with all)
+func (r *Repository) FastExport(all bool, revs []string, w io.Writer) error {
+	defer r.enter()()
+	// Short names (main, v1.0) are turned into full ref names - they name refs in the stream
+	refNames := revs
+	if all {
+		var err error
+		if refNames, err = fastExportAllRefs(); err != nil {
+			return err
+		}
+	}
+	for i, rev := range refNames {
+		refName, _, err := resolveRevision(rev)
+		if refName == "HEAD" {
+			refName, _, err = readHead()
+		}
+		if err != nil || refName == "" {
+			return fmt.Errorf("%s is not a ref", rev)
+		}
+		refNames[i] = refName
+	}
+	return fastExport(refNames, w)
+}
+
+// Build objects and refs from fast-import stream - returns number of blobs, commits and tags by type
+func (r *Repository) FastImport(input io.Reader, w io.Writer) (map[string]int, error) {
+	defer r.enter()()
+	return fastImport(input, w)
+}
+
+// Write one mbox patch per commit - to files in options.OutputDir (the starting directory by default,
+// names are returned as the directory was given), or to w with options.Stdout
+func (r *Repository) FormatPatch(options FormatPatchOptions, w io.Writer) ([]string, error) {
+	defer r.enter()()
+	outputDir := options.OutputDir
+	options.OutputDir = r.file(outputDir)
+	commits, err := formatPatchCommits(options)
+	if err != nil {
+		return nil, err
+	}
+	names, err := formatPatch(commits, options, w)
+	if !filepath.IsAbs(outputDir) {
+		for i, name := range names {
+			names[i] = filepath.Join(outputDir, filepath.Base(name))
+		}
+	}
+	return names, err
+}
+
+// Apply patch files (standard input when there are none) to the work tree, or to the index with cached -
+// nothing changes unless every hunk applies
+func (r *Repository) Apply(cached bool, patchFiles []string) error {
+	defer r.enter()()
+	// Only --cached works without a work tree
+	if !cached {
+		if err := requireWorkTree(); err != nil {
+			return err
+		}
+	}
+	patchData, err := readPatchInput(r.files(patchFiles))
+	if err != nil {
+		return err
+	}
+	patches, err := parsePatch(patchData)
+	if err != nil {
+		return err
+	}
+	return applyPatches(patches, cached)
+}
+
+// Apply mails from mbox files (standard input when there are none) as commits - action "continue" or
+// "abort" resumes or drops a stopped am
+func (r *Repository) Am(action string, mboxFiles []string, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	switch action {
+	case "continue":
+		return amContinue(w)
+	case "abort":
+		return amAbort()
+	}
+	// Mails from files (or stdin) - same reading as apply, mbox files are just concatenated
+	mbox, err := readPatchInput(r.files(mboxFiles))
+	if err != nil {
+		return err
+	}
+	return amStart(mbox, w)
+}
+
+// Commit staged changes on top of HEAD - "[<branch> <hash>] <subject>" is written to w, hash is returned
+// Commit hooks run around it (pre-commit and commit-msg can abort it, unless options.NoVerify is set)
+// Without a message the editor is opened on what a stopped merge left in MERGE_MSG (or SQUASH_MSG)
+func (r *Repository) Commit(options CommitOptions, w io.Writer) (string, error) {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return "", err
+	}
+	hash, err := commitChanges(options, w)
+	if err == nil {
+		runAutoMaintenance(w)
+	}
+	return hash, err
+}
+
+// Rebase HEAD onto another commit - action "continue", "skip" or "abort" resumes or drops a stopped rebase
+func (r *Repository) Rebase(action string, options RebaseOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	switch action {
+	case "continue":
+		return rebaseContinue(w)
+	case "skip":
+		return rebaseSkip(w)
+	case "abort":
+		return rebaseAbort()
+	}
+	return rebaseStart(options, w)
+}
+
+// Apply changes of commits (oldest first) as new commits on HEAD - action "continue", "skip" or "abort"
+// resumes or drops a stopped cherry-pick
+func (r *Repository) CherryPick(action string, options CherryPickOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	switch action {
+	case "continue":
+		return sequencerContinue(w)
+	case "skip":
+		return sequencerSkip(w)
+	case "abort":
+		return sequencerAbort()
+	}
+	return sequencerStart("pick", options.Commits, options.NoCommit, options.Mainline, w)
+}
+
+// Undo changes of commits (oldest first) with new commits on HEAD - action "continue", "skip" or "abort"
+// resumes or drops a stopped revert
+func (r *Repository) Revert(action string, options RevertOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	switch action {
+	case "continue":
+		return sequencerContinue(w)
+	case "skip":
+		return sequencerSkip(w)
+	case "abort":
+		return sequencerAbort()
+	}
+	return sequencerStart("revert", options.Commits, options.NoCommit, options.Mainline, w)
+}
+
+// Run rerere command - action is "" (record resolutions), status, forget (paths), clear or gc
+func (r *Repository) Rerere(action string, paths []string, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	return runRerere(action, r.paths(paths), w)
+}
+
+// Show changes in the configured diff tool - files are selected like diff selects them
+func (r *Repository) Difftool(options DifftoolOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	if options.Diff.Paths == nil {
+		options.Diff.Revisions, options.Diff.Paths = splitRevisionsAndPaths(options.Diff.Revisions, r.Prefix)
+	}
+	options.Diff.Paths = r.paths(options.Diff.Paths)
+	return runDifftool(options, w)
+}
+
+// Resolve conflicted paths with the configured merge tool - resolved paths are added to the index
+func (r *Repository) Mergetool(options MergetoolOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	options.Paths = r.paths(options.Paths)
+	return runMergetool(options, w)
+}
+
+// Merge commits into HEAD (fast-forward when possible) - progress and conflicts are written to w; action
+// "continue" or "abort" concludes or drops a merge stopped by conflicts
+func (r *Repository) Merge(action string, options MergeOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	switch action {
+	case "continue":
+		return mergeContinue(w)
+	case "abort":
+		return mergeAbort()
+	}
+	return mergeCommits(options, w)
+}
+
+// Stash local changes (options.Command "push") or work with stash entries - list, show, apply, pop, drop,
+// branch or clear them
+func (r *Repository) Stash(options StashOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	switch options.Command {
+	case "list":
+		return listStash(w)
+	case "show":
+		return showStash(options, w)
+	case "apply":
+		conflicts, err := applyStash(options.Stash, options.Index, w)
+		if err == nil && conflicts {
+			err = fmt.Errorf("conflicts in the work tree")
+		}
+		return err
+	case "pop":
+		return popStash(options.Stash, options.Index, w)
+	case "drop":
+		return dropStash(options.Stash, w)
+	case "branch":
+		return stashBranch(options.Branch, options.Stash, w)
+	case "clear":
+		return clearStash()
+	}
+	return pushStash(options.Message, w)
+}
+
+// Create branch (options.Name at options.StartPoint) - without a name, branches are written to w instead;
+// options.Delete deletes branches (only merged ones unless options.Force), options.Upstream and
+// options.UnsetUpstream change the upstream of branch options.Name (the current one when empty)
+func (r *Repository) Branch(options BranchOptions, w io.Writer) error {
+	defer r.enter()()
+	if options.Delete {
+		return deleteBranches(options.Names, options.Force, w)
+	}
+	if options.Upstream != "" || options.UnsetUpstream {
+		branch := options.Name
+		if branch == "" {
+			headBranch, _, err := readHead()
+			if err != nil {
+				return err
+			}
+			if headBranch == "" {
+				return fmt.Errorf("HEAD is detached - no branch to set upstream of")
+			}
+			branch = shortRefName(headBranch)
+		}
+		if options.UnsetUpstream {
+			return unsetUpstream(branch)
+		}
+		return setUpstream(branch, options.Upstream, w)
+	}
+	if options.Name == "" {
+		return listBranches(options, w)
+	}
+	return createBranch(options.Name, options.StartPoint, options.Force, options.Track, options.NoTrack, w)
+}
+
+// Manage remotes (options.Command) - without a command, remote names are written to w; show asks the
+// remote for its refs unless options.NoQuery
+func (r *Repository) Remote(ctx context.Context, options RemoteOptions, w io.Writer) error {
+	defer r.enter()()
+	switch options.Command {
+	case "add":
+		return addRemote(options.Name, options.Url)
+	case "remove":
+		return removeRemote(options.Name)
+	case "rename":
+		return renameRemote(options.Name, options.NewName)
+	case "set-url":
+		return setRemoteUrl(options.Name, options.Url, options.Push)
+	case "show":
+		return showRemote(ctx, options.Name, options.NoQuery, w)
+	}
+	return listRemotes(options.Verbose, w)
+}
+
+// Fetch refs and objects from remote (options.Remote, or the upstream remote of the current branch) - refs
+// are mapped to local refs by refspecs, updates are written to w. Canceling ctx stops the transfer.
+func (r *Repository) Fetch(ctx context.Context, options FetchOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := fetchRemote(ctx, options, w); err != nil {
+		return err
+	}
+	runAutoMaintenance(w)
+	return nil
+}
+
+// Push local refs to remote (options.Remote, or the push remote of the current branch) - refs are mapped to
+// remote refs by refspecs, updates are written to w. Canceling ctx stops the transfer.
+func (r *Repository) Push(ctx context.Context, options PushOptions, w io.Writer) error {
+	defer r.enter()()
+	return pushRemote(ctx, options, w)
+}
+
+// Fetch from remote and merge the fetched refs into the current branch (or rebase it onto them) - the
+// upstream of the branch when options name no refs. Canceling ctx stops the transfer.
+func (r *Repository) Pull(ctx context.Context, options PullOptions, w io.Writer) error {
+	defer r.enter()()
+	if err := requireWorkTree(); err != nil {
+		return err
+	}
+	if err := pullRemote(ctx, options, w); err != nil {
+		return err
+	}
+	runAutoMaintenance(w)
+	return nil
+}
+
+// Replace options.Object with options.Replacement - with options.List, replaced objects matching
+// options.Pattern are written to w instead, options.Delete deletes replace refs of options.Objects
+func (r *Repository) Replace(options ReplaceOptions, w io.Writer) error {
+	defer r.enter()()
+	if options.Delete {
+		return deleteReplaceRefs(options.Objects, w)
+	}
+	if options.List {
+		return listReplaceRefs(options.Pattern, options.Format, w)
+	}
+	return createReplaceRef(options)
+}
+
+// Create tag (lightweight, or annotated tag object) - with options.List, names of tags matching
+// options.Patterns (and containing options.Contains) are written to w instead, options.Delete deletes tags
+func (r *Repository) Tag(options TagOptions, w io.Writer) error {
+	defer r.enter()()
+	if options.Delete {
+		return deleteTags(options.Names, w)
+	}
+	if !options.List {
+		return createTag(options)
+	}
+	tags, err := listTags(options.Patterns, options.Contains)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		fmt.Fprintln(w, tag)
+	}
+	return nil
+}
+
+// Verify signatures of tags given by names (refs/tags/<name>) - the signed tag content goes to w, the
+// verifier report to report. Returns whether all signatures are good.
+func (r *Repository) VerifyTags(names []string, w, report io.Writer) (bool, error) {
+	defer r.enter()()
+	refNames := make([]string, 0, len(names))
+	for _, name := range names {
+		hash, err := resolveRef("refs/tags/" + name)
+		if err != nil {
+			return false, err
+		}
+		if hash == "" {
+			return false, fmt.Errorf("tag '%s' not found", name)
+		}
+		refNames = append(refNames, "refs/tags/"+name)
+	}
+	return r.verify("tag", refNames, true, w, report)
+}
+
+// Write object names of revisions (abbreviated to options.Short digits when set) - with options.Verify,
+// exactly one revision that has to name an existing object
+func (r *Repository) RevParse(options RevParseOptions, w io.Writer) error {
+	defer r.enter()()
+	if options.Verify && len(options.Revisions) != 1 {
+		return fmt.Errorf("needed a single revision")
+	}
+	for _, name := range options.Revisions {
+		hash, err := resolveShowObject(name)
+		if err != nil {
+			return err
+		}
+		if options.Short > 0 {
+			hash = hash[:min(len(hash), options.Short)]
+		}
+		fmt.Fprintln(w, hash)
+	}
+	return nil
+}
+
+// Verify signatures of commits or tags (objectType) - verifier report goes to report, like git's goes to
+// stderr; signed contents of objects are written to w when verbose. Returns whether all signatures are good.
+func (r *Repository) Verify(objectType string, names []string, verbose bool, w, report io.Writer) (bool, error) {
+	defer r.enter()()
+	return r.verify(objectType, names, verbose, w, report)
+}
+
+func (r *Repository) verify(objectType string, names []string, verbose bool, w, report io.Writer) (bool, error) {
+	verified := true
+	for _, name := range names {
+		var hash string
+		var err error
+		if objectType == "commit" {
+			hash, err = resolveCommitRevision(name)
+		} else {
+			_, hash, err = resolveRevision(name)
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve %s: %w", name, err)
+		}
+
+		check, content, err := verifyObjectSignature(hash, objectType)
+		if verbose && content != nil {
+			w.Write(content)
+		}
+		if err != nil {
+			fmt.Fprintf(report, "failed to verify %s: %v\n", name, err)
+			verified = false
+			continue
+		}
+		io.WriteString(report, check.Output)
+		if check.Status != 'G' && check.Status != 'U' {
+			verified = false
+		}
+	}
+	return verified, nil
+}
+
+// Write log of commits
+func (r *Repository) Log(options LogOptions, w io.Writer) error {
+	defer r.enter()()
+	// Without "--", files can be named among revisions
+	if options.Paths == nil {
+		options.Revisions, options.Paths = splitRevisionsAndPaths(options.Revisions, r.Prefix)
+	}
+	options.Paths = r.paths(options.Paths)
+	return writeLog(options, w)
+}
+
+// Write objects - commits with their diffs, tags, trees and blobs
+func (r *Repository) Show(options ShowOptions, w io.Writer) error {
+	defer r.enter()()
+	// Without "--", files can be named among objects
+	if options.Log.Paths == nil {
+		options.Log.Revisions, options.Log.Paths = splitRevisionsAndPaths(options.Log.Revisions, r.Prefix)
+	}
+	options.Log.Paths = r.paths(options.Log.Paths)
+	return writeShow(options, w)
+}
+
+// Write diff between commits, index and work tree
+func (r *Repository) Diff(options DiffCmdOptions, w io.Writer) error {
+	defer r.enter()()
+	// Without "--", files can be named among revisions
+	if options.Paths == nil {
+		options.Revisions, options.Paths = splitRevisionsAndPaths(options.Revisions, r.Prefix)
+	}
+	options.Paths = r.paths(options.Paths)
+	return writeDiff(options, w)
+}
@@ -0,0 +1,611 @@
+package git
+
+import (
+	"bufio"
+	"strings"
+)
+
+// Commit graph of log --graph (drawn as git's graph.c does) - every commit gets rows showing the branch lines
+// around it:
+//
+//	*   merge        commit row - "*" in the commit's column, other lines go on as "|"
+//	|\               post-merge row - edges to the parents of a merge
+//	| * second
+//	* | first
+//	|/               collapsing rows - lines leading to the same commit join, moving left one column per row
+//	* base
+//
+// Columns are the commits the branch lines lead to, in order; mapping says which column every screen column
+// (two characters per graph column) belongs to on the next row. Lines printed between commits (message lines,
+// diffstat) are prefixed with the next row of a merge or collapse still being drawn, or with a padding row
+// extending the lines. Merges of more than two parents are drawn "*-." with a dash pair per extra parent,
+// after rows that make room for them to the right when needed.
+
+// Rows of the graph
+const (
+	graphPadding = iota
+	graphSkip
+	graphPreCommit
+	graphCommit
+	graphPostMerge
+	graphCollapsing
+)
+
+// Colors of branch lines, handed out to new columns in turn (when log is colored)
+var graphColors = []string{
+	"\033[31m", "\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[36m",
+	"\033[1;31m", "\033[1;32m", "\033[1;33m", "\033[1;34m", "\033[1;35m", "\033[1;36m",
+}
+
+// Edge characters of merge parents, by merge layout
+var graphMergeChars = []byte{'/', '|', '\\'}
+
+// Graph of commits whose shown parents are in parents - lines are colored when colored is set
+func newLogGraph(parents map[string][]string, colored bool) *LogGraph {
+	return &LogGraph{
+		parents:      parents,
+		colored:      colored,
+		state:        graphPadding,
+		prevState:    graphPadding,
+		defaultColor: len(graphColors) - 1,
+	}
+}
+
+// Move graph to the next commit - its rows are drawn by nextLine
+func (g *LogGraph) update(commit string) {
+	g.commit = commit
+	g.prevCommitIndex = g.commitIndex
+	g.updateColumns()
+	g.expansionRow = 0
+
+	// Previous commit that didn't finish its rows leaves a gap ("...")
+	switch {
+	case g.state != graphPadding:
+		g.state = graphSkip
+	case g.needsPreCommitLine():
+		g.state = graphPreCommit
+	default:
+		g.state = graphCommit
+	}
+}
+
+func (g *LogGraph) numParents() int {
+	return len(g.parents[g.commit])
+}
+
+// Check whether an octopus merge still needs rows making room for it (when it has lines to its right)
+func (g *LogGraph) needsPreCommitLine() bool {
+	return g.numParents() >= 3 && g.commitIndex < len(g.columns)-1 && g.expansionRow < g.numExpansionRows()
+}
+
+// Parents drawn with dashes on the commit row of an octopus merge
+func (g *LogGraph) numDashedParents() int {
+	return g.numParents() + g.mergeLayout - 3
+}
+
+func (g *LogGraph) numExpansionRows() int {
+	return g.numDashedParents() * 2
+}
+
+// Compute columns below the commit row and the mapping of screen columns to them
+func (g *LogGraph) updateColumns() {
+	g.columns, g.newColumns = g.newColumns, g.columns[:0]
+	maxNewColumns := len(g.columns) + g.numParents()
+	if len(g.mapping) < 2*maxNewColumns {
+		g.mapping = make([]int, 2*maxNewColumns)
+		g.oldMapping = append(g.oldMapping, make([]int, 2*maxNewColumns-len(g.oldMapping))...)
+	}
+	g.mappingSize = 2 * maxNewColumns
+	for i := range g.mapping[:g.mappingSize] {
+		g.mapping[i] = -1
+	}
+	g.width = 0
+	g.prevEdgesAdded = g.edgesAdded
+	g.edgesAdded = 0
+
+	// Commit that nothing shown leads to yet gets a new column at the right end
+	seen, inColumns := false, true
+	for i := 0; i <= len(g.columns); i++ {
+		var columnCommit string
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			inColumns = false
+			columnCommit = g.commit
+		} else {
+			columnCommit = g.columns[i].commit
+		}
+
+		if columnCommit != g.commit {
+			g.insertIntoNewColumns(columnCommit, -1)
+			continue
+		}
+		seen = true
+		g.commitIndex = i
+		g.mergeLayout = -1
+		for _, parent := range g.parents[g.commit] {
+			// Merges and new lines of history change color
+			if g.numParents() > 1 || !inColumns {
+				g.defaultColor = (g.defaultColor + 1) % len(graphColors)
+			}
+			g.insertIntoNewColumns(parent, i)
+		}
+		// Commit takes 2 screen columns even with no parents
+		if g.numParents() == 0 {
+			g.width += 2
+		}
+	}
+
+	for g.mappingSize > 1 && g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+}
+
+// Add column leading to commit below the commit row (once) - index is the commit's column when commit is its
+// parent, -1 for lines passing by
+func (g *LogGraph) insertIntoNewColumns(commit string, index int) {
+	i := g.findNewColumn(commit)
+	if i < 0 {
+		i = len(g.newColumns)
+		g.newColumns = append(g.newColumns, graphColumn{commit: commit, color: g.findCommitColor(commit)})
+	}
+
+	var mappingIndex int
+	switch {
+	case g.numParents() > 1 && index > -1 && g.mergeLayout == -1:
+		// First parent of a merge decides its layout - skewed left when the parent is in a column to the left
+		distance := index - i
+		shift := 1
+		if distance > 1 {
+			shift = 2*distance - 3
+		}
+		g.mergeLayout = 1
+		if distance > 0 {
+			g.mergeLayout = 0
+		}
+		g.edgesAdded = g.numParents() + g.mergeLayout - 2
+		mappingIndex = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	case g.edgesAdded > 0 && g.width >= 2 && i == g.mapping[g.width-2]:
+		// Edge added by a merge joins the line right next to it at once
+		mappingIndex = g.width - 2
+		g.edgesAdded = -1
+	default:
+		mappingIndex = g.width
+		g.width += 2
+	}
+	g.mapping[mappingIndex] = i
+}
+
+func (g *LogGraph) findNewColumn(commit string) int {
+	for i, column := range g.newColumns {
+		if column.commit == commit {
+			return i
+		}
+	}
+	return -1
+}
+
+// Color of line leading to commit - the color its column has, or the current color for a new line
+func (g *LogGraph) findCommitColor(commit string) int {
+	for _, column := range g.columns {
+		if column.commit == commit {
+			return column.color
+		}
+	}
+	if !g.colored {
+		return len(graphColors)
+	}
+	return g.defaultColor
+}
+
+func (g *LogGraph) setState(state int) {
+	g.prevState = g.state
+	g.state = state
+}
+
+// Check whether every line reached its column (or is one to the right of it, drawn as "/")
+func (g *LogGraph) isMappingCorrect() bool {
+	for i, target := range g.mapping[:g.mappingSize] {
+		if target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Check whether all rows of the commit were drawn - what follows gets padding rows
+func (g *LogGraph) isCommitFinished() bool {
+	return g.state == graphPadding
+}
+
+// Draw the next row of the commit - true when it is the commit row
+func (g *LogGraph) nextLine() (string, bool) {
+	line := &graphLine{}
+	commitLine := false
+	switch g.state {
+	case graphPadding:
+		g.outputPaddingLine(line)
+	case graphSkip:
+		g.outputSkipLine(line)
+	case graphPreCommit:
+		g.outputPreCommitLine(line)
+	case graphCommit:
+		g.outputCommitLine(line)
+		commitLine = true
+	case graphPostMerge:
+		g.outputPostMergeLine(line)
+	case graphCollapsing:
+		g.outputCollapsingLine(line)
+	}
+	g.padLine(line)
+	return line.text.String(), commitLine
+}
+
+// Pad row to graph width, so text right of the graph stays aligned for the whole commit
+func (g *LogGraph) padLine(line *graphLine) {
+	if line.width < g.width {
+		line.addChars(' ', g.width-line.width)
+	}
+}
+
+func (g *LogGraph) writeColumn(line *graphLine, column graphColumn, c byte) {
+	if column.color < len(graphColors) {
+		line.text.WriteString(graphColors[column.color])
+		line.addChars(c, 1)
+		line.text.WriteString(colorReset)
+		return
+	}
+	line.addChars(c, 1)
+}
+
+func (g *LogGraph) outputPaddingLine(line *graphLine) {
+	for _, column := range g.newColumns {
+		g.writeColumn(line, column, '|')
+		line.addChars(' ', 1)
+	}
+}
+
+func (g *LogGraph) outputSkipLine(line *graphLine) {
+	line.addChars('.', 3)
+	if g.needsPreCommitLine() {
+		g.setState(graphPreCommit)
+	} else {
+		g.setState(graphCommit)
+	}
+}
+
+// Row widening the space right of an octopus merge - lines right of it move one column per row
+func (g *LogGraph) outputPreCommitLine(line *graphLine) {
+	seen := false
+	for i, column := range g.columns {
+		switch {
+		case column.commit == g.commit:
+			seen = true
+			g.writeColumn(line, column, '|')
+			line.addChars(' ', g.expansionRow)
+		case seen && g.expansionRow == 0:
+			// Lines drawn as "\" after the previous merge go on that way
+			if g.prevState == graphPostMerge && g.prevCommitIndex < i {
+				g.writeColumn(line, column, '\\')
+			} else {
+				g.writeColumn(line, column, '|')
+			}
+		case seen:
+			g.writeColumn(line, column, '\\')
+		default:
+			g.writeColumn(line, column, '|')
+		}
+		line.addChars(' ', 1)
+	}
+
+	g.expansionRow++
+	if !g.needsPreCommitLine() {
+		g.setState(graphCommit)
+	}
+}
+
+// Dashes of an octopus merge, colored as the columns its extra parents end up in
+func (g *LogGraph) drawOctopusMerge(line *graphLine) {
+	dashed := g.numDashedParents()
+	for i := 0; i < dashed; i++ {
+		column := g.newColumns[g.mapping[(g.commitIndex+i+2)*2]]
+		g.writeColumn(line, column, '-')
+		if i == dashed-1 {
+			g.writeColumn(line, column, '.')
+		} else {
+			g.writeColumn(line, column, '-')
+		}
+	}
+}
+
+func (g *LogGraph) outputCommitLine(line *graphLine) {
+	seen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var column graphColumn
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			column = graphColumn{commit: g.commit}
+		} else {
+			column = g.columns[i]
+		}
+
+		switch {
+		case column.commit == g.commit:
+			seen = true
+			line.addChars('*', 1)
+			if g.numParents() > 2 {
+				g.drawOctopusMerge(line)
+			}
+		case seen && g.edgesAdded > 1:
+			g.writeColumn(line, column, '\\')
+		case seen && g.edgesAdded == 1:
+			// Right-skewed merge has no row before this one - lines that were "\" after the previous merge
+			// stay that way
+			if g.prevState == graphPostMerge && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				g.writeColumn(line, column, '\\')
+			} else {
+				g.writeColumn(line, column, '|')
+			}
+		case g.prevState == graphCollapsing && g.oldMapping[2*i+1] == i && g.mapping[2*i] < i:
+			g.writeColumn(line, column, '/')
+		default:
+			g.writeColumn(line, column, '|')
+		}
+		line.addChars(' ', 1)
+	}
+
+	switch {
+	case g.numParents() > 1:
+		g.setState(graphPostMerge)
+	case g.isMappingCorrect():
+		g.setState(graphPadding)
+	default:
+		g.setState(graphCollapsing)
+	}
+}
+
+// Row with the edges from a merge to its parents
+func (g *LogGraph) outputPostMergeLine(line *graphLine) {
+	parents := g.parents[g.commit]
+	seen := false
+	var parentColumn *graphColumn
+	for i := 0; i <= len(g.columns); i++ {
+		var column graphColumn
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			column = graphColumn{commit: g.commit}
+		} else {
+			column = g.columns[i]
+		}
+
+		switch {
+		case column.commit == g.commit:
+			seen = true
+			layout := g.mergeLayout
+			for j, parent := range parents {
+				g.writeColumn(line, g.newColumns[g.findNewColumn(parent)], graphMergeChars[layout])
+				if layout == 2 {
+					if g.edgesAdded > 0 || j < len(parents)-1 {
+						line.addChars(' ', 1)
+					}
+				} else {
+					layout++
+				}
+			}
+			if g.edgesAdded == 0 {
+				line.addChars(' ', 1)
+			}
+		case seen:
+			if g.edgesAdded > 0 {
+				g.writeColumn(line, column, '\\')
+			} else {
+				g.writeColumn(line, column, '|')
+			}
+			line.addChars(' ', 1)
+		default:
+			g.writeColumn(line, column, '|')
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				if parentColumn != nil {
+					g.writeColumn(line, *parentColumn, '_')
+				} else {
+					line.addChars(' ', 1)
+				}
+			}
+		}
+
+		if column.commit == parents[0] {
+			parentColumn = &g.columns[i]
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(graphPadding)
+	} else {
+		g.setState(graphCollapsing)
+	}
+}
+
+// Row moving lines towards their columns - one line may cross others horizontally ("_") per row
+func (g *LogGraph) outputCollapsingLine(line *graphLine) {
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	for i := range g.mapping[:g.mappingSize] {
+		g.mapping[i] = -1
+	}
+
+	horizontalEdge, horizontalTarget := -1, -1
+	for i, target := range g.oldMapping[:g.mappingSize] {
+		if target < 0 {
+			continue
+		}
+		// Lines only ever move left
+		switch {
+		case target*2 == i:
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			// Nothing to the left - move left by one, the first such line may go horizontally
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// Line to the left leads to the same commit - the two join
+		default:
+			// Line to the left leads elsewhere - cross over it
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalTarget = i-1, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+	copy(g.oldMapping, g.mapping[:g.mappingSize])
+	if g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+
+	usedHorizontal := false
+	for i, target := range g.mapping[:g.mappingSize] {
+		switch {
+		case target < 0:
+			line.addChars(' ', 1)
+		case target*2 == i:
+			g.writeColumn(line, g.newColumns[target], '|')
+		case target == horizontalTarget && i != horizontalEdge-1:
+			// Only the first segment of the horizontal line goes on to the next row
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			g.writeColumn(line, g.newColumns[target], '_')
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			g.writeColumn(line, g.newColumns[target], '/')
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(graphPadding)
+	}
+}
+
+// Row that only extends the lines - unlike nextLine, it never draws the commit row (the lines go on as they
+// are right above it instead)
+func (g *LogGraph) paddingLine() string {
+	if g.state != graphCommit {
+		text, _ := g.nextLine()
+		return text
+	}
+	line := &graphLine{}
+	for _, column := range g.columns {
+		g.writeColumn(line, column, '|')
+		if column.commit == g.commit && g.numParents() > 2 {
+			line.addChars(' ', (g.numParents()-2)*2)
+		} else {
+			line.addChars(' ', 1)
+		}
+	}
+	g.padLine(line)
+	g.prevState = graphPadding
+	return line.text.String()
+}
+
+// Write rows up to the commit row - the commit row is left open for the commit header (nothing is written
+// without graph)
+func (g *LogGraph) showCommit(w *bufio.Writer) {
+	if g == nil {
+		return
+	}
+	if g.isCommitFinished() {
+		w.WriteString(g.paddingLine())
+		return
+	}
+	for !g.isCommitFinished() {
+		text, commitLine := g.nextLine()
+		w.WriteString(text)
+		if commitLine {
+			return
+		}
+		w.WriteString("\n")
+	}
+}
+
+// Write the next row of the commit (without newline)
+func (g *LogGraph) showOneline(w *bufio.Writer) {
+	if g == nil {
+		return
+	}
+	text, _ := g.nextLine()
+	w.WriteString(text)
+}
+
+// Write a padding row (without newline)
+func (g *LogGraph) showPadding(w *bufio.Writer) {
+	if g == nil {
+		return
+	}
+	w.WriteString(g.paddingLine())
+}
+
+// Write rows the commit still needs, separated by newlines (no newline after the last one)
+func (g *LogGraph) showRemainder(w *bufio.Writer) {
+	for !g.isCommitFinished() {
+		text, _ := g.nextLine()
+		w.WriteString(text)
+		if !g.isCommitFinished() {
+			w.WriteString("\n")
+		}
+	}
+}
+
+// Write message following the commit header - every line but the first gets a row of the graph, rows the
+// commit still needs come after it
+func (g *LogGraph) showMessage(w *bufio.Writer, message string) {
+	for i, line := range strings.SplitAfter(message, "\n") {
+		if i > 0 && line != "" {
+			g.showOneline(w)
+		}
+		w.WriteString(line)
+	}
+	if g == nil || g.isCommitFinished() {
+		return
+	}
+	terminated := strings.HasSuffix(message, "\n")
+	if !terminated {
+		w.WriteString("\n")
+	}
+	g.showRemainder(w)
+	if terminated {
+		w.WriteString("\n")
+	}
+}
+
+// Write text (diff output) with every line prefixed by a padding row
+func (g *LogGraph) showPrefixed(w *bufio.Writer, text string) {
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		g.showPadding(w)
+		w.WriteString(line)
+	}
+}
+
+func (line *graphLine) addChars(c byte, count int) {
+	for i := 0; i < count; i++ {
+		line.text.WriteByte(c)
+	}
+	line.width += count
+}
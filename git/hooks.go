@@ -0,0 +1,112 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Client-side hooks - executables in .git/hooks (or core.hooksPath) that git runs around some commands:
+//
+//	pre-commit          before commit is created, non-zero exit aborts the commit (skipped with --no-verify)
+//	prepare-commit-msg  <file> <source> - may edit the message file before commit-msg runs
+//	commit-msg          <file> - may edit or reject the message, non-zero exit aborts (skipped with --no-verify)
+//	post-commit         after commit is created, exit code is ignored
+//	post-checkout       <old HEAD> <new HEAD> <1 for branch checkout> - after clone checkout, exit code is ignored
+//	pre-push            <remote> <url> - refs to push on stdin, non-zero exit aborts the push (skipped with --no-verify)
+//
+// post-merge is run by merge, with the same runHook.
+//
+// Hooks run in the work tree root (git directory in bare repository) with stdout sent to stderr, like git
+// does. Missing or non-executable hook is skipped.
+
+// Path of hook name - empty when there is no executable hook
+func findHook(name string) string {
+	hooksDir := gitDirPath("hooks")
+	if config, err := loadConfig(); err == nil {
+		if value, ok := config.Get("core.hookspath"); ok && value != "" {
+			hooksDir = expandConfigPath(value)
+		}
+	}
+
+	hook := filepath.Join(hooksDir, name)
+	info, err := os.Stat(hook)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return ""
+	}
+	return hook
+}
+
+// Run hook with args (stdin may be nil) - returns error when the hook exits with non-zero code,
+// nil when there is no such hook
+func runHook(name string, stdin io.Reader, args ...string) error {
+	hook := findHook(name)
+	if hook == "" {
+		return nil
+	}
+
+	cmd := exec.Command(hook, args...)
+	cmd.Dir = commandDir()
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = hookEnvironment()
+	traceRunCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// Environment for hooks - GIT_DIR and GIT_INDEX_FILE are made absolute, as hooks run in another directory;
+// settings of the context are passed on (see contextEnvironment)
+func hookEnvironment() []string {
+	layout := resolveRepoLayout()
+	env := contextEnvironment(os.Environ())
+	for key, value := range map[string]string{"GIT_DIR": layout.GitDir, "GIT_INDEX_FILE": layout.IndexFile} {
+		env = append(env, key+"="+absolutePath(value))
+	}
+	return env
+}
+
+// Pass commit message through prepare-commit-msg and commit-msg hooks (commit-msg only when verify is
+// set) - message is written to .git/COMMIT_EDITMSG, hooks may change it there, and the result is read back
+func runCommitMessageHooks(message string, verify bool) (string, error) {
+	if findHook("prepare-commit-msg") == "" && (!verify || findHook("commit-msg") == "") {
+		return message, nil
+	}
+
+	messageFile, err := filepath.Abs(gitDirPath("COMMIT_EDITMSG"))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	if err := os.WriteFile(messageFile, []byte(message), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", messageFile, err)
+	}
+
+	if err := runHook("prepare-commit-msg", nil, messageFile, "message"); err != nil {
+		return "", err
+	}
+	if verify {
+		if err := runHook("commit-msg", nil, messageFile); err != nil {
+			return "", err
+		}
+	}
+
+	content, err := os.ReadFile(messageFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", messageFile, err)
+	}
+	// Hooks often leave trailing blank lines behind - only the text matters
+	message = strings.TrimRight(string(content), " \t\n")
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return message + "\n", nil
+}
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// HTTP authentication - every smart and dumb HTTP request goes through doHttpRequest
+//   - user:password@ in remote URL    -> basic auth (credentials are removed from URL used for requests)
+//   - --token flag or GIT_TOKEN env   -> basic auth with x-access-token user (GitHub/GitLab PATs),
+//                                        or with the user from URL if there is one
+//   - 401 response                    -> ask credential helpers (or user on terminal) and retry once
+
+// Split credentials from remote URL - returns URL without them and auth to use
+func newHttpAuth(remoteUrl, token string) (string, *HttpAuth, error) {
+	auth := &HttpAuth{Token: token}
+	if auth.Token == "" {
+		auth.Token = os.Getenv("GIT_TOKEN")
+	}
+
+	parsed, err := url.Parse(remoteUrl)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid url %s: %w", remoteUrl, err)
+	}
+	if parsed.User != nil {
+		auth.Username = parsed.User.Username()
+		auth.Password, _ = parsed.User.Password()
+		parsed.User = nil
+	}
+
+	return parsed.String(), auth, nil
+}
+
+// Send request with credentials - on 401 get credentials from helpers (or user) and send it again
+// Credentials that worked are approved (helpers may store them), rejected ones are erased from helpers
+//
+// With low speed limit, response body is a LowSpeedReader - the whole request is aborted when it is too slow
+func doHttpRequest(req *http.Request, auth *HttpAuth) (*http.Response, error) {
+	client, err := newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+	if httpLowSpeedLimit <= 0 || httpLowSpeedTime <= 0 {
+		return sendHttpRequest(client, req, auth)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	body := &LowSpeedReader{ctx: ctx, cancel: cancel}
+	go body.watch(httpLowSpeedLimit, httpLowSpeedTime)
+
+	resp, err := sendHttpRequest(client, req.WithContext(ctx), auth)
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		cancel(nil)
+		return nil, err
+	}
+	body.ReadCloser = resp.Body
+	resp.Body = body
+	return resp, nil
+}
+
+// Send request, asking for credentials on 401 (see doHttpRequest)
+func sendHttpRequest(client *http.Client, req *http.Request, auth *HttpAuth) (*http.Response, error) {
+	auth.apply(req)
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || auth == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	credential := credentialForUrl(req.URL, auth.Username)
+	if err := fillCredential(credential); err != nil {
+		return nil, err
+	}
+	auth.Username, auth.Password, auth.Token = credential.Username, credential.Password, ""
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	auth.apply(retry)
+	resp, err = client.Do(retry)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		rejectCredential(credential)
+		return nil, fmt.Errorf("%w for %s", ErrAuth, req.URL.Redacted())
+	}
+	approveCredential(credential)
+	return resp, nil
+}
+
+// Set Authorization header - nothing is sent if there are no credentials
+func (auth *HttpAuth) apply(req *http.Request) {
+	if auth == nil {
+		return
+	}
+	switch {
+	case auth.Password != "":
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case auth.Token != "":
+		username := auth.Username
+		if username == "" {
+			username = "x-access-token"
+		}
+		req.SetBasicAuth(username, auth.Token)
+	}
+}
@@ -0,0 +1,213 @@
+package git
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP client shared by all requests to remotes (smart and dumb HTTP)
+//
+// Proxy:
+//   - http.proxy config
+//   - http_proxy / https_proxy / all_proxy env (upper case works too)
+//   - no_proxy env - comma separated hosts/domains that are reached directly ("*" disables proxy)
+//
+// TLS:
+//   - http.sslVerify (GIT_SSL_NO_VERIFY env) - verify server certificate
+//   - http.sslCAInfo (GIT_SSL_CAINFO)        - file with CA certificates to trust instead of system ones
+//   - http.sslCert / http.sslKey (GIT_SSL_CERT / GIT_SSL_KEY) - client certificate and its key
+//
+// Timeouts:
+//   - http.lowSpeedLimit / http.lowSpeedTime (GIT_HTTP_LOW_SPEED_LIMIT / GIT_HTTP_LOW_SPEED_TIME) - request
+//     is aborted when less than lowSpeedLimit bytes per second arrive for lowSpeedTime seconds (waiting for
+//     the response included); both have to be set
+
+// Client is built once per process - config doesn't change while we run
+var sharedHttpClient *http.Client
+
+// Low speed limit in bytes per second and the time transfer may stay below it - 0 when not set
+var httpLowSpeedLimit int64
+var httpLowSpeedTime time.Duration
+
+func newHttpClient() (*http.Client, error) {
+	if sharedHttpClient != nil {
+		return sharedHttpClient, nil
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	configProxy, _ := config.Get("http.proxy")
+	transport.Proxy = httpProxyFunc(configProxy)
+
+	tlsConfig, err := httpTlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	httpLowSpeedLimit = configIntOrEnv(config, "http.lowSpeedLimit", "GIT_HTTP_LOW_SPEED_LIMIT")
+	httpLowSpeedTime = time.Duration(configIntOrEnv(config, "http.lowSpeedTime", "GIT_HTTP_LOW_SPEED_TIME")) * time.Second
+
+	sharedHttpClient = &http.Client{Transport: transport}
+	return sharedHttpClient, nil
+}
+
+// Integer value from environment variable, or from config if variable is not set
+func configIntOrEnv(config *Config, key, envName string) int64 {
+	if value := os.Getenv(envName); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return config.GetInt(key, 0)
+}
+
+// Watch transfer of request whose context is reader.ctx - it is canceled when less than limit bytes per
+// second were read during the last interval. Stops when the context is done (Close cancels it).
+func (reader *LowSpeedReader) watch(limit int64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := int64(0)
+	for {
+		select {
+		case <-reader.ctx.Done():
+			return
+		case <-ticker.C:
+			read := reader.read.Load()
+			if read-last < limit*int64(interval/time.Second) {
+				reader.cancel(fmt.Errorf("operation too slow: less than %d bytes/sec transferred the last %d seconds",
+					limit, interval/time.Second))
+				return
+			}
+			last = read
+		}
+	}
+}
+
+// Read from response body - aborted transfer reports why it was aborted
+func (reader *LowSpeedReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.read.Add(int64(n))
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(reader.ctx); cause != nil {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (reader *LowSpeedReader) Close() error {
+	reader.cancel(nil)
+	return reader.ReadCloser.Close()
+}
+
+// Pick proxy for request - http.proxy config wins over environment, no_proxy applies to both
+func httpProxyFunc(configProxy string) func(*http.Request) (*url.URL, error) {
+	if configProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	// "host:port" without scheme means HTTP proxy
+	if !strings.Contains(configProxy, "://") {
+		configProxy = "http://" + configProxy
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if proxyBypassed(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return url.Parse(configProxy)
+	}
+}
+
+// Check host against no_proxy list - entries match the host itself and its subdomains
+func proxyBypassed(host string) bool {
+	noProxy := os.Getenv("no_proxy")
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if entryHost, _, err := net.SplitHostPort(entry); err == nil {
+			entry = entryHost
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		entry = strings.TrimPrefix(entry, ".")
+		host = strings.ToLower(host)
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// TLS settings from config (environment variables override config, like in git)
+func httpTlsConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	verify := config.GetBool("http.sslverify", true)
+	if value := os.Getenv("GIT_SSL_NO_VERIFY"); value != "" {
+		verify = false
+	}
+	tlsConfig.InsecureSkipVerify = !verify
+
+	caInfo := configOrEnv(config, "http.sslcainfo", "GIT_SSL_CAINFO")
+	if caInfo != "" {
+		pem, err := os.ReadFile(caInfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", caInfo, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caInfo)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile := configOrEnv(config, "http.sslcert", "GIT_SSL_CERT")
+	if certFile != "" {
+		// Key may be in the same file as certificate
+		keyFile := configOrEnv(config, "http.sslkey", "GIT_SSL_KEY")
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// Path-like value from environment variable, or from config if variable is not set
+func configOrEnv(config *Config, key, envName string) string {
+	if value := os.Getenv(envName); value != "" {
+		return value
+	}
+	value, _ := config.Get(key)
+	return expandConfigPath(value)
+}
@@ -0,0 +1,159 @@
+package git
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// serve-http - smart HTTP server with git-http-backend semantics:
+//
+//	GET  <repo>/info/refs?service=git-upload-pack  - "# service=" pkt-line, flush, then refs advertisement
+//	POST <repo>/git-upload-pack                    - one upload-pack request, answered with NAK and the pack
+//	POST <repo>/git-receive-pack                   - push: commands and pack, answered with report-status
+//
+// Like in git-http-backend, http.uploadPack (on by default) and http.receivePack (off by default - there
+// is no authentication) in the served repository's config enable the services.
+//
+// <repo> is a path below the served directory - "/" is the directory itself, so a single repository can be
+// served as http://host:port/ and a directory of repositories as http://host:port/<name>.git.
+//
+// Every request runs upload-pack or receive-pack as a child process (see serviceCommand) (--advertise-refs for GET, --stateless-rpc for
+// POST), like git-http-backend does - repository state (current directory, GIT_DIR, caches) belongs to the
+// process, so concurrent requests for different repositories don't get mixed. Gzip request bodies are
+// accepted. Dumb HTTP (GET without ?service=) is not served.
+
+// Services available over HTTP - whether they are enabled when config doesn't say
+var httpBackendServices = map[string]bool{
+	"git-upload-pack":  true,
+	"git-receive-pack": false,
+}
+
+// Serve repositories below options.Directory until ctx is canceled - address is written to w
+func (c *RepoContext) serveHttp(ctx context.Context, options ServeHttpOptions, w io.Writer) error {
+	root := c.path(options.Directory)
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", options.Directory)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", options.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", options.Port, err)
+	}
+	fmt.Fprintf(w, "Serving %s on http://localhost:%d/\n", root, listener.Addr().(*net.TCPAddr).Port)
+
+	server := &http.Server{Handler: &HttpBackend{Root: root, Executable: c.Program}}
+	context.AfterFunc(ctx, func() { server.Close() })
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (backend *HttpBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	var repoPath, service string
+	advertise := false
+
+	switch {
+	case strings.HasSuffix(urlPath, "/info/refs") && r.Method == http.MethodGet:
+		repoPath, service, advertise = strings.TrimSuffix(urlPath, "/info/refs"), r.URL.Query().Get("service"), true
+		if service == "" {
+			http.Error(w, "dumb HTTP is not supported", http.StatusForbidden)
+			return
+		}
+	case strings.HasSuffix(urlPath, "/git-upload-pack") && r.Method == http.MethodPost:
+		repoPath, service = strings.TrimSuffix(urlPath, "/git-upload-pack"), "git-upload-pack"
+	case strings.HasSuffix(urlPath, "/git-receive-pack") && r.Method == http.MethodPost:
+		repoPath, service = strings.TrimSuffix(urlPath, "/git-receive-pack"), "git-receive-pack"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := httpBackendServices[service]; !ok {
+		http.Error(w, "unknown service "+service, http.StatusForbidden)
+		return
+	}
+
+	repoDir := filepath.Join(backend.Root, filepath.FromSlash(repoPath))
+	gitDir := repoDir
+	if !isGitDirectory(gitDir) {
+		gitDir = filepath.Join(repoDir, ".git")
+		if !isGitDirectory(gitDir) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if !httpServiceEnabled(gitDir, service) {
+		http.Error(w, service+" is not enabled", http.StatusForbidden)
+		return
+	}
+
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "bad gzip request body", http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	args := []string{strings.TrimPrefix(service, "git-"), "--stateless-rpc"}
+	if advertise {
+		args = append(args, "--advertise-refs")
+	}
+	cmd := serviceCommand(r.Context(), backend.Executable, append(args, repoDir)...)
+	cmd.Env = serviceEnvironment()
+	cmd.Stdin = body
+	cmd.Stderr = os.Stderr
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	traceRunCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+	if advertise {
+		w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+		writePktLine(w, "# service="+service+"\n")
+		io.WriteString(w, "0000")
+	} else {
+		w.Header().Set("Content-Type", "application/x-"+service+"-result")
+	}
+	// Response is streamed as the service writes it - pack is sent while it's still being produced
+	if _, err := io.Copy(w, output); err != nil {
+		fmt.Fprintf(os.Stderr, "serve-http: %s %s: %v\n", r.Method, urlPath, err)
+	}
+	if err := cmd.Wait(); err != nil {
+		fmt.Fprintf(os.Stderr, "serve-http: %s %s: %s failed: %v\n", r.Method, urlPath, service, err)
+	}
+}
+
+// Check http.uploadPack / http.receivePack in repository config (global config included)
+func httpServiceEnabled(gitDir, service string) bool {
+	config := &Config{values: make(map[string][]string)}
+	paths := configFilePaths()
+	// Server doesn't run inside the repository - its own config is read from gitDir
+	paths[len(paths)-1] = filepath.Join(gitDir, "config")
+	for _, configPath := range paths {
+		if err := config.loadFile(configPath); err != nil {
+			return false
+		}
+	}
+	key := "http." + strings.ReplaceAll(strings.TrimPrefix(service, "git-"), "-", "")
+	return config.GetBool(key, httpBackendServices[service])
+}
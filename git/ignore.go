@@ -0,0 +1,332 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Gitignore engine - patterns are collected from (lowest to highest priority):
+//   - core.excludesFile
+//   - .git/info/exclude
+//   - .gitignore in every directory (deeper directories override parent ones)
+//
+// Every candidate path is checked against all patterns in that order and the LAST matching pattern wins,
+// so a negated pattern (!foo) can re-include a file that was excluded earlier.
+
+// Creates matcher with global excludes, info/exclude and root .gitignore already loaded
+func newIgnoreMatcher() (*IgnoreMatcher, error) {
+	matcher := &IgnoreMatcher{loadedDirs: make(map[string]bool)}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if excludesFile, ok := config.Get("core.excludesFile"); ok {
+		if err := matcher.loadFile(expandConfigPath(excludesFile), ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := matcher.loadFile(gitDirPath("info", "exclude"), ""); err != nil {
+		return nil, err
+	}
+
+	if err := matcher.loadDir(""); err != nil {
+		return nil, err
+	}
+
+	return matcher, nil
+}
+
+// Load .gitignore from directory (relative to work tree root) - each directory is loaded only once
+func (matcher *IgnoreMatcher) loadDir(dir string) error {
+	if matcher.loadedDirs[dir] {
+		return nil
+	}
+
+	// Parent directories must be loaded first, so their patterns have lower priority
+	if dir != "" {
+		if err := matcher.loadDir(parentDir(dir)); err != nil {
+			return err
+		}
+	}
+
+	matcher.loadedDirs[dir] = true
+	return matcher.loadFile(workTreePath(dir, ".gitignore"), dir)
+}
+
+// Parse ignore file - base is directory (relative to work tree root) to which patterns are relative
+func (matcher *IgnoreMatcher) loadFile(filePath, base string) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		pattern, ok := parseIgnoreLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		pattern.Base = base
+		pattern.Source = filePath
+		pattern.Line = lineNumber
+		matcher.patterns = append(matcher.patterns, pattern)
+	}
+
+	return scanner.Err()
+}
+
+// Parse single line of ignore file - returns false for blank lines and comments
+func parseIgnoreLine(line string) (IgnorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimIgnoreTrailingSpaces(line)
+	if line == "" || line[0] == '#' {
+		return IgnorePattern{}, false
+	}
+
+	pattern := IgnorePattern{}
+
+	// !pattern - negation, \! and \# are literal
+	if line[0] == '!' {
+		pattern.Negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	// pattern/ - matches only directories
+	if strings.HasSuffix(line, "/") {
+		pattern.DirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	// Slash at the beginning or in the middle - pattern is relative to .gitignore directory
+	if strings.Contains(line, "/") {
+		pattern.Anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return IgnorePattern{}, false
+	}
+
+	pattern.Pattern = line
+	return pattern, true
+}
+
+// Trailing spaces are ignored unless they are escaped with backslash
+func trimIgnoreTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		if end >= 2 && line[end-2] == '\\' {
+			// "\ " - keep the space, drop the backslash
+			return line[:end-2] + " "
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// Check whether path (relative to work tree root, with / separators) is ignored
+// Files inside an ignored directory are always ignored - they can't be re-included by negation
+func (matcher *IgnoreMatcher) isIgnored(filePath string, isDir bool) bool {
+	parts := strings.Split(filePath, "/")
+	for i := 1; i < len(parts); i++ {
+		if pattern := matcher.match(strings.Join(parts[:i], "/"), true); pattern != nil && !pattern.Negate {
+			return true
+		}
+	}
+
+	pattern := matcher.match(filePath, isDir)
+	return pattern != nil && !pattern.Negate
+}
+
+// Find the last pattern that matches path - nil if nothing matches
+func (matcher *IgnoreMatcher) match(filePath string, isDir bool) *IgnorePattern {
+	// .gitignore files from all directories along the path must be loaded
+	if err := matcher.loadDir(parentDir(filePath)); err != nil {
+		return nil
+	}
+
+	var matched *IgnorePattern
+	for i := range matcher.patterns {
+		if matcher.patterns[i].matches(filePath, isDir) {
+			matched = &matcher.patterns[i]
+		}
+	}
+	return matched
+}
+
+// Check whether single pattern matches path
+func (pattern *IgnorePattern) matches(filePath string, isDir bool) bool {
+	if pattern.DirOnly && !isDir {
+		return false
+	}
+
+	// Pattern only applies to files below its .gitignore directory
+	relative := filePath
+	if pattern.Base != "" {
+		if !strings.HasPrefix(filePath, pattern.Base+"/") {
+			return false
+		}
+		relative = filePath[len(pattern.Base)+1:]
+	}
+
+	if pattern.Anchored {
+		return wildmatch(pattern.Pattern, relative)
+	}
+
+	// No slash in pattern - match against file name on any level
+	return wildmatch(pattern.Pattern, path.Base(relative))
+}
+
+// Match text against glob pattern with gitignore semantics:
+//   - * and ? never match /
+//   - [abc], [a-z] and [!abc] character classes
+//   - leading **/, trailing /** and /**/ match across directories
+func wildmatch(pattern, text string) bool {
+	var match func(p, t int) bool
+	match = func(p, t int) bool {
+		for p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				// ** surrounded by slashes (or pattern edges) matches any number of directories
+				if p+1 < len(pattern) && pattern[p+1] == '*' && (p == 0 || pattern[p-1] == '/') &&
+					(p+2 == len(pattern) || pattern[p+2] == '/') {
+					if p+2 == len(pattern) {
+						return true
+					}
+					// "**/" may match zero directories
+					rest := p + 3
+					if match(rest, t) {
+						return true
+					}
+					for i := t; i < len(text); i++ {
+						if text[i] == '/' && match(rest, i+1) {
+							return true
+						}
+					}
+					return false
+				}
+
+				// Collapse consecutive stars which are not **/ into single star
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				for i := t; i <= len(text); i++ {
+					if match(p, i) {
+						return true
+					}
+					if i < len(text) && text[i] == '/' {
+						return false
+					}
+				}
+				return false
+			case '?':
+				if t >= len(text) || text[t] == '/' {
+					return false
+				}
+				p++
+				t++
+			case '[':
+				if t >= len(text) || text[t] == '/' {
+					return false
+				}
+				matched, next, ok := matchCharClass(pattern, p, text[t])
+				if !ok {
+					// Unterminated class - treat [ as literal
+					if text[t] != '[' {
+						return false
+					}
+					p++
+					t++
+					continue
+				}
+				if !matched {
+					return false
+				}
+				p = next
+				t++
+			case '\\':
+				if p+1 < len(pattern) {
+					p++
+				}
+				if t >= len(text) || text[t] != pattern[p] {
+					return false
+				}
+				p++
+				t++
+			default:
+				if t >= len(text) || text[t] != c {
+					return false
+				}
+				p++
+				t++
+			}
+		}
+		return t == len(text)
+	}
+
+	return match(0, 0)
+}
+
+// Match one character against [...] class starting at pattern[start] - returns match result,
+// index after the closing ] and false if class is not terminated
+func matchCharClass(pattern string, start int, c byte) (bool, int, bool) {
+	i := start + 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+
+	matched := false
+	first := true
+	for i < len(pattern) {
+		if pattern[i] == ']' && !first {
+			return matched != negate, i + 1, true
+		}
+		first = false
+
+		low := pattern[i]
+		if low == '\\' && i+1 < len(pattern) {
+			i++
+			low = pattern[i]
+		}
+		i++
+
+		high := low
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			high = pattern[i+1]
+			if high == '\\' && i+2 < len(pattern) {
+				i++
+				high = pattern[i+1]
+			}
+			i += 2
+		}
+
+		if low <= c && c <= high {
+			matched = true
+		}
+	}
+
+	return false, 0, false
+}
+
+// Parent directory of slash-separated path ("" for top-level entries)
+func parentDir(filePath string) string {
+	dir := path.Dir(filePath)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,268 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Index extensions - after the last entry and before the checksum, each one is:
+//
+//	4 byte signature + 4 byte size + data
+//
+// Signature starting with an uppercase letter is optional (reader that doesn't know it may skip it), lowercase
+// signatures are required. TREE (cached tree), UNTR (untracked cache) and FSMN (fsmonitor) are parsed and kept
+// up to date, other optional extensions (REUC...) are written back byte-for-byte, except EOIE/IEOT which describe
+// offsets of the old file.
+//
+// TREE data is a depth first list of directories:
+//
+//	path NUL + entry count (ASCII) + " " + subtree count (ASCII) + "\n" + tree hash (only when entry count >= 0)
+
+// Index state by index file path - set by readGitIndex and used by writeGitIndex
+var indexStateCache = make(map[string]*IndexState)
+
+// Extensions that describe layout of the file they were read from, so they are dropped on write
+var indexLayoutExtensions = map[string]bool{"EOIE": true, "IEOT": true}
+
+// Parse extensions between entries and checksum into state - returns bitmap of entries fsmonitor didn't
+// see unchanged (nil when there is no FSMN extension)
+func parseIndexExtensions(data []byte, state *IndexState) ([]bool, error) {
+	var fsmonitorDirty []bool
+	for offset := 0; offset < len(data); {
+		if offset+8 > len(data) {
+			return nil, fmt.Errorf("index extension header is truncated")
+		}
+		signature := string(data[offset : offset+4])
+		size := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		offset += 8
+		if size < 0 || offset+size > len(data) {
+			return nil, fmt.Errorf("index extension %s is truncated", signature)
+		}
+		extensionData := data[offset : offset+size]
+		offset += size
+
+		switch {
+		case signature == "TREE":
+			tree, next, err := parseCacheTree(extensionData, 0)
+			if err != nil {
+				return nil, fmt.Errorf("bad TREE extension: %w", err)
+			}
+			if next != len(extensionData) {
+				return nil, fmt.Errorf("bad TREE extension: trailing data")
+			}
+			state.CacheTree = tree
+		case signature == "UNTR":
+			// Broken untracked cache is only an optimization lost - it is rebuilt
+			if cache, err := parseUntrackedCache(extensionData); err == nil {
+				state.UntrackedCache = cache
+			}
+		case signature == "FSMN":
+			token, dirty, err := parseFSMonitorExtension(extensionData)
+			if err != nil {
+				return nil, err
+			}
+			state.FSMonitorToken, fsmonitorDirty = token, dirty
+		case signature[0] < 'A' || signature[0] > 'Z':
+			return nil, fmt.Errorf("index uses %s extension, which we do not understand", signature)
+		case !indexLayoutExtensions[signature]:
+			state.Extensions = append(state.Extensions, IndexExtension{Signature: signature, Data: extensionData})
+		}
+	}
+	return fsmonitorDirty, nil
+}
+
+// Append extensions to index content - TREE first, then preserved ones in their original order, then untracked
+// cache and fsmonitor state (only while core.fsmonitor is set)
+func writeIndexExtensions(buf *bytes.Buffer, state *IndexState, entries []IndexEntry) {
+	if state.CacheTree != nil {
+		var tree bytes.Buffer
+		writeCacheTree(&tree, state.CacheTree)
+		writeIndexExtension(buf, "TREE", tree.Bytes())
+	}
+	for _, extension := range state.Extensions {
+		writeIndexExtension(buf, extension.Signature, extension.Data)
+	}
+	if state.UntrackedCache != nil {
+		var untracked bytes.Buffer
+		writeUntrackedCache(&untracked, state.UntrackedCache)
+		writeIndexExtension(buf, "UNTR", untracked.Bytes())
+	}
+	if _, enabled := fsmonitorHook(); enabled && state.FSMonitorToken != "" {
+		writeIndexExtension(buf, "FSMN", writeFSMonitorExtension(state.FSMonitorToken, entries))
+	}
+}
+
+func writeIndexExtension(buf *bytes.Buffer, signature string, data []byte) {
+	buf.WriteString(signature)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// Parse cached tree node at offset and its subtrees - returns node and offset after it
+func parseCacheTree(data []byte, offset int) (*CacheTree, int, error) {
+	end := bytes.IndexByte(data[offset:], 0)
+	if end == -1 {
+		return nil, offset, fmt.Errorf("path is not terminated")
+	}
+	tree := &CacheTree{Name: string(data[offset : offset+end])}
+	offset += end + 1
+
+	lineEnd := bytes.IndexByte(data[offset:], '\n')
+	if lineEnd == -1 {
+		return nil, offset, fmt.Errorf("counts are not terminated")
+	}
+	counts := strings.Fields(string(data[offset : offset+lineEnd]))
+	offset += lineEnd + 1
+	if len(counts) != 2 {
+		return nil, offset, fmt.Errorf("bad counts for '%s'", tree.Name)
+	}
+	entryCount, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return nil, offset, fmt.Errorf("bad entry count for '%s'", tree.Name)
+	}
+	subtreeCount, err := strconv.Atoi(counts[1])
+	if err != nil || subtreeCount < 0 {
+		return nil, offset, fmt.Errorf("bad subtree count for '%s'", tree.Name)
+	}
+	tree.EntryCount = entryCount
+
+	if entryCount >= 0 {
+		if offset+20 > len(data) {
+			return nil, offset, fmt.Errorf("hash of '%s' is truncated", tree.Name)
+		}
+		tree.Hash = append([]byte(nil), data[offset:offset+20]...)
+		offset += 20
+	}
+
+	for i := 0; i < subtreeCount; i++ {
+		child, next, err := parseCacheTree(data, offset)
+		if err != nil {
+			return nil, next, err
+		}
+		tree.Children = append(tree.Children, child)
+		offset = next
+	}
+	return tree, offset, nil
+}
+
+// Serialize cached tree node and its subtrees - subtrees are ordered by name length, then name (as git does)
+func writeCacheTree(buf *bytes.Buffer, tree *CacheTree) {
+	sort.Slice(tree.Children, func(i, j int) bool {
+		if len(tree.Children[i].Name) != len(tree.Children[j].Name) {
+			return len(tree.Children[i].Name) < len(tree.Children[j].Name)
+		}
+		return tree.Children[i].Name < tree.Children[j].Name
+	})
+
+	fmt.Fprintf(buf, "%s\x00%d %d\n", tree.Name, tree.EntryCount, len(tree.Children))
+	if tree.EntryCount >= 0 {
+		buf.Write(tree.Hash)
+	}
+	for _, child := range tree.Children {
+		writeCacheTree(buf, child)
+	}
+}
+
+// Subtree with given name, nil when there is none
+func (tree *CacheTree) child(name string) *CacheTree {
+	if tree == nil {
+		return nil
+	}
+	for _, child := range tree.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Hashes of the valid cached trees (this node and its subtrees) - they are written objects the index refers to
+func (tree *CacheTree) validHashes() []string {
+	if tree == nil {
+		return nil
+	}
+	var hashes []string
+	if tree.EntryCount >= 0 && len(tree.Hash) == 20 {
+		hashes = append(hashes, hex.EncodeToString(tree.Hash))
+	}
+	for _, child := range tree.Children {
+		hashes = append(hashes, child.validHashes()...)
+	}
+	return hashes
+}
+
+// Mark every directory on the way to path as changed
+func (tree *CacheTree) invalidate(path string) {
+	for node := tree; node != nil; {
+		node.EntryCount = -1
+		name, rest, isDir := strings.Cut(path, "/")
+		if !isDir {
+			return
+		}
+		node, path = node.child(name), rest
+	}
+}
+
+// Invalidate cached trees of paths whose entries differ between previously read and new entries - untracked
+// cache directories are invalidated only for added and removed paths (a file can become untracked)
+func invalidateChangedPaths(state *IndexState, previous map[string]IndexEntry, entries []IndexEntry) {
+	current := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		current[entry.Path] = true
+		old, ok := previous[entry.Path]
+		if !ok || !bytes.Equal(old.Hash, entry.Hash) || old.Mode != entry.Mode || old.IntentToAdd != entry.IntentToAdd {
+			state.CacheTree.invalidate(entry.Path)
+		}
+		if !ok {
+			state.UntrackedCache.invalidate(entry.Path)
+		}
+	}
+	for path := range previous {
+		if !current[path] {
+			state.CacheTree.invalidate(path)
+			state.UntrackedCache.invalidate(path)
+		}
+	}
+}
+
+// Write tree objects of directory node, reusing hashes of valid cached trees - returns cached tree for the node.
+// A cached tree whose object is gone (e.g. pruned) is dropped and the tree is written again.
+func writeTreeWithCache(node *TreeNode, cached *CacheTree) (*CacheTree, error) {
+	if cached != nil && cached.EntryCount >= 0 && len(cached.Hash) == 20 {
+		exists, err := objectExists(hex.EncodeToString(cached.Hash))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			node.Hash = cached.Hash
+			return cached, nil
+		}
+	}
+
+	tree := &CacheTree{Name: node.Name}
+	for name, child := range node.Children {
+		if !child.IsDir {
+			tree.EntryCount++
+			continue
+		}
+		subtree, err := writeTreeWithCache(child, cached.child(name))
+		if err != nil {
+			return nil, err
+		}
+		tree.EntryCount += subtree.EntryCount
+		tree.Children = append(tree.Children, subtree)
+	}
+
+	hash, err := createTree(node)
+	if err != nil {
+		return nil, err
+	}
+	node.Hash = hash
+	tree.Hash = hash
+	return tree, nil
+}
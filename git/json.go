@@ -0,0 +1,154 @@
+package git
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JSON output (global --json) - these commands write one JSON document instead of their usual
+// output, for tools that would rather not parse text:
+//
+//	cat-file   {"hash", "type", "size"}, -p adds the object: "content" of blobs, "entries" of trees,
+//	           "commit" or "tag" fields
+//	ls-tree    [{"mode", "type", "hash", "name"}, ...]
+//	log        [{"hash", "tree", "parents", "author", "committer", "message"}, ...]
+//	status     {"branch", "head", "staged", "unstaged", "untracked", "ignored"} - changes are {"path", "status"},
+//	           staged renames also have "from"
+//	ls-remote  [{"name", "hash", "target"}, ...]
+//
+// Authors, committers and taggers are {"name", "email", "date"} with the date in RFC 3339 format. Blob content
+// that is not UTF-8 is base64 encoded ("content_base64" instead of "content").
+
+// Status names of changes in JSON status
+var jsonStatusNames = map[string]string{"new file": "added", "modified": "modified", "deleted": "deleted", "typechange": "typechange",
+	"renamed": "renamed"}
+
+// Write value as indented JSON document
+func writeJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// JSON form of object - with parse, content is included (parsed for trees, commits and tags)
+func jsonObject(hash string, parse bool) (*JSONObject, error) {
+	objectType, _, content, err := readObjectFromHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	object := &JSONObject{Hash: hash, Type: objectType, Size: int64(len(content))}
+	if !parse {
+		return object, nil
+	}
+
+	switch objectType {
+	case "blob":
+		if utf8.Valid(content) {
+			text := string(content)
+			object.Content = &text
+		} else {
+			object.ContentBase64 = base64.StdEncoding.EncodeToString(content)
+		}
+	case "tree":
+		object.Entries, err = jsonTreeEntries(content)
+	case "commit":
+		var commit *Commit
+		if commit, err = parseCommit(content); err == nil {
+			object.Commit, err = jsonCommit("", commit)
+		}
+	case "tag":
+		var tag *Tag
+		if tag, err = parseTag(content); err == nil {
+			object.Tag = &JSONTag{Object: tag.Object, ObjectType: tag.Type, Tag: tag.Name, Message: tag.Message}
+			if tag.Tagger != "" {
+				object.Tag.Tagger, err = jsonSignature(tag.Tagger)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// JSON form of tree entries - type comes from the mode
+func jsonTreeEntries(content []byte) ([]JSONTreeEntry, error) {
+	entries, err := parseTreeContent(content)
+	if err != nil {
+		return nil, err
+	}
+	jsonEntries := make([]JSONTreeEntry, len(entries))
+	for i, entry := range entries {
+		objectType := "blob"
+		switch entry.Mode {
+		case "40000":
+			objectType = "tree"
+		case "160000":
+			objectType = "commit"
+		}
+		jsonEntries[i] = JSONTreeEntry{Mode: entry.Mode, Type: objectType, Hash: entry.Hash, Name: entry.Name}
+	}
+	return jsonEntries, nil
+}
+
+// JSON form of commit - hash is left out when empty
+func jsonCommit(hash string, commit *Commit) (*JSONCommit, error) {
+	author, err := jsonSignature(commit.Author)
+	if err != nil {
+		return nil, err
+	}
+	committer, err := jsonSignature(commit.Committer)
+	if err != nil {
+		return nil, err
+	}
+	parents := commit.Parents
+	if parents == nil {
+		parents = []string{}
+	}
+	return &JSONCommit{
+		Hash: hash, Tree: commit.Tree, Parents: parents,
+		Author: author, Committer: committer, Message: commit.Message,
+	}, nil
+}
+
+// JSON form of "Name <email> <time> <zone>" signature
+func jsonSignature(signature string) (*JSONSignature, error) {
+	name, email, when, err := parseSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSignature{Name: name, Email: email, Date: when.Format(time.RFC3339)}, nil
+}
+
+// JSON form of status - ignored files only with showIgnored
+func jsonStatus(status *WorkTreeStatus, showIgnored bool) *JSONStatus {
+	result := &JSONStatus{
+		Branch:    strings.TrimPrefix(status.Branch, "refs/heads/"),
+		Head:      status.Head,
+		Staged:    []JSONStatusChange{},
+		Unstaged:  []JSONStatusChange{},
+		Untracked: status.Untracked,
+	}
+	for _, filePath := range sortedKeys(status.Staged) {
+		result.Staged = append(result.Staged, JSONStatusChange{Path: filePath, Status: jsonStatusNames[status.Staged[filePath]],
+			From: status.RenamedFrom[filePath]})
+	}
+	for _, filePath := range sortedKeys(status.Unstaged) {
+		result.Unstaged = append(result.Unstaged, JSONStatusChange{Path: filePath, Status: jsonStatusNames[status.Unstaged[filePath]]})
+	}
+	for _, filePath := range sortedKeys(status.Unmerged) {
+		result.Unmerged = append(result.Unmerged, JSONStatusChange{Path: filePath, Status: unmergedLabel(status.Unmerged[filePath])})
+	}
+	if result.Untracked == nil {
+		result.Untracked = []string{}
+	}
+	if showIgnored {
+		result.Ignored = status.Ignored
+	}
+	return result
+}
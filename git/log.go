@@ -0,0 +1,563 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Log - commits from revList, newest first, by default in git's "medium" format (other formats and
+// --pretty=format: placeholders are in pretty.go):
+//
+//	commit <hash>
+//	Merge: <short parent> <short parent>   - merges only
+//	Author: Name <email>
+//	Date:   Mon Jan 2 15:04:05 2006 -0700
+//
+//	    message, indented by 4 spaces
+//
+// With paths, only commits that changed them are shown - a commit that has a parent with the same entries
+// (file or directory) at every path is left out, and a merge like that is only followed through that parent
+// (git's default history simplification). --author, --grep, --since and the other filters of commitfilter.go
+// leave out more commits. --follow keeps following a single file through renames: once a commit turns out
+// to have renamed it, older commits are checked for the old name; history isn't simplified then (as with
+// git), the graph shows "..." where commits not changing the file were left out. --stat (and the other
+// diffstat options) follow each commit with the summary of its changes against its first parent - of the
+// paths only, or of the followed file under its old and new name.
+// -p adds the patch of each commit against its first parent, after the diffstat when both are shown. Merges
+// get no diff unless -m asks for one against every parent (the log is repeated as "commit <hash> (from
+// <parent>)" before each) or --first-parent for one against the first parent - --first-parent also walks
+// only the first parents of merges.
+// --graph draws the commit graph left of the log (see graph.go) and shows commits in topological order -
+// parents of a commit shown are the nearest shown commits along its history; --decorate adds the refs
+// pointing to each commit (see decorate.go).
+
+// Dates of git's default date style
+const logDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+// Write log of commits selected by options
+func writeLog(options LogOptions, output io.Writer) error {
+	include, exclude, err := resolveRevisionArgs(options.Revisions)
+	if err != nil {
+		return err
+	}
+	commits, err := walkCommits(include, exclude, options.FirstParent)
+	if err != nil {
+		return err
+	}
+	if options.Follow && len(options.Paths) != 1 {
+		return fmt.Errorf("--follow requires exactly one pathspec")
+	}
+	// Followed file may also be a copy of a file the commit didn't touch (as with git)
+	diffOptions, err := resolveDiffOptions(DiffOptions{Renames: DiffCopiesOn, FindCopiesHarder: true, MinScore: options.Diff.MinScore})
+	if err != nil {
+		return err
+	}
+	matcher, err := newCommitMatcher(options.Filter, time.Now())
+	if err != nil {
+		return err
+	}
+
+	walked := make(map[string]*Commit, len(commits))
+	for _, hash := range commits {
+		if walked[hash], err = readCommit(hash); err != nil {
+			return err
+		}
+	}
+	// History goes through first parents only with --first-parent - merges still show all their parents
+	history := walked
+	if options.FirstParent {
+		history = make(map[string]*Commit, len(walked))
+		for hash, commit := range walked {
+			firstParent := *commit
+			if len(firstParent.Parents) > 1 {
+				firstParent.Parents = firstParent.Parents[:1]
+			}
+			history[hash] = &firstParent
+		}
+	}
+	// Graph keeps lines of history apart
+	if options.Graph {
+		parents := make(map[string][]string, len(commits))
+		for hash, commit := range history {
+			parents[hash] = commit.Parents
+		}
+		commits = topoOrder(commits, parents)
+	}
+	entries, parents, err := selectLogCommits(include, commits, history, options, matcher, diffOptions)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+	out, err := newLogOutput(options, writer)
+	if err != nil {
+		return err
+	}
+	if options.Graph && !options.JSON {
+		out.graph = newLogGraph(parents, out.formatter.colors != nil)
+	}
+	jsonCommits := []*JSONCommit{}
+	shown := 0
+	for _, entry := range entries {
+		if options.MaxCount > 0 && shown == options.MaxCount {
+			break
+		}
+		hash, commit := entry.hash, walked[entry.hash]
+		// Commits --follow leaves out are still drawn through - the graph marks the gap they leave
+		if !entry.shown {
+			if out.graph != nil {
+				out.graph.update(hash)
+			}
+			continue
+		}
+		shown++
+		if options.JSON {
+			jsonCommit, err := jsonCommit(hash, commit)
+			if err != nil {
+				return err
+			}
+			jsonCommits = append(jsonCommits, jsonCommit)
+			continue
+		}
+
+		if out.graph != nil {
+			out.graph.update(hash)
+		}
+		if err := out.writeCommit(hash, commit, entry.paths); err != nil {
+			return err
+		}
+	}
+	if options.JSON {
+		return writeJSON(writer, jsonCommits)
+	}
+	return nil
+}
+
+// Set up output of commits as options say - colors, commit format, decorations and diffs
+func newLogOutput(options LogOptions, writer *bufio.Writer) (*logOutput, error) {
+	diffOptions, err := resolveDiffOptions(options.Diff)
+	if err != nil {
+		return nil, err
+	}
+	if diffOptions, err = resolveWordDiffOptions(diffOptions); err != nil {
+		return nil, err
+	}
+	// Log is colored as diffs are (color.diff) - --color-words always is
+	color := diffOptions.WordDiff == "color"
+	if !color {
+		if color, err = useColor("diff", options.Color); err != nil {
+			return nil, err
+		}
+	}
+	var colors map[string]string
+	if color {
+		if colors, err = loadColors("diff"); err != nil {
+			return nil, err
+		}
+		diffOptions.Colors = maps.Clone(colors)
+		decorateColors, err := loadColors("decorate")
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(colors, decorateColors)
+	}
+
+	pretty, err := resolvePrettyFormat(options)
+	if err != nil {
+		return nil, err
+	}
+	style, err := decorationStyle(options.Decorate)
+	if err != nil {
+		return nil, err
+	}
+	formatter := &commitFormatter{pretty: pretty, decorate: style != "no", fullRefNames: style == "full", colors: colors,
+		showSignature: options.ShowSignature, now: time.Now()}
+	// User formats may show decorations (%d) without --decorate
+	if formatter.decorate || pretty.name == "format" {
+		if formatter.decorations, formatter.headBranch, err = loadDecorations(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &logOutput{writer: writer, formatter: formatter, diffOptions: diffOptions, patch: options.Patch}
+	switch {
+	case options.FirstParent:
+		out.merges = "first-parent"
+	case options.Merges:
+		out.merges = "separate"
+	}
+	return out, nil
+}
+
+// Write commit with its diffs - the log is written again for every parent of a merge the commit differs from
+// (with -m), or once when there is no diff to show; only files at paths are compared (all with no paths)
+func (out *logOutput) writeCommit(hash string, commit *Commit, paths []string) error {
+	if len(commit.Parents) > 1 && out.merges == "combined" {
+		return out.writeCombinedDiff(hash, commit, paths)
+	}
+	var diffParents []string
+	switch {
+	case !out.patch && !out.diffOptions.Stat.enabled():
+	case len(commit.Parents) == 0:
+		// Root commit is compared with nothing
+		diffParents = []string{""}
+	case len(commit.Parents) == 1 || out.merges == "first-parent":
+		diffParents = commit.Parents[:1]
+	case out.merges == "separate":
+		diffParents = commit.Parents
+	}
+
+	shown := false
+	for _, parent := range diffParents {
+		diffs, err := commitDiffs(commit, parent, paths, out.diffOptions)
+		if err != nil {
+			return err
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		from := ""
+		if len(commit.Parents) > 1 && out.merges == "separate" {
+			from = parent
+		}
+		if err := out.writeLog(hash, commit, from); err != nil {
+			return err
+		}
+		out.writeDiffs(diffs)
+		shown = true
+	}
+	if shown {
+		return nil
+	}
+	return out.writeLog(hash, commit, "")
+}
+
+// Write merge with the combined diff show gives it - a merge whose files all come from one of its parents
+// has no combined patch, so only the diffstat against its first parent follows the log (combined patches of
+// merges that resolved conflicts are not computed)
+func (out *logOutput) writeCombinedDiff(hash string, commit *Commit, paths []string) error {
+	if err := out.writeLog(hash, commit, ""); err != nil {
+		return err
+	}
+	if !out.patch && !out.diffOptions.Stat.enabled() {
+		return nil
+	}
+	if !out.formatter.pretty.empty() {
+		out.graph.showPadding(out.writer)
+		out.writer.WriteString("\n")
+	}
+	if !out.diffOptions.Stat.enabled() {
+		return nil
+	}
+	diffs, err := commitDiffs(commit, commit.Parents[0], paths, out.diffOptions)
+	if err != nil {
+		return err
+	}
+	var stat bytes.Buffer
+	writeDiffStat(&stat, diffs, out.diffOptions.Stat)
+	out.graph.showPrefixed(out.writer, stat.String())
+	return nil
+}
+
+// Write log of commit (the header and message of its format) - from is the parent the diff that follows is
+// against, shown in the header for merges
+func (out *logOutput) writeLog(hash string, commit *Commit, from string) error {
+	writer, graph, pretty := out.writer, out.graph, out.formatter.pretty
+	// Commits are separated by a newline, or each one ends with one - after a padding row of the graph
+	// unless the message left its last line open
+	if out.shown > 0 && !pretty.terminator {
+		if !out.missingNewline {
+			graph.showPadding(writer)
+		}
+		writer.WriteString("\n")
+	}
+	out.shown++
+	graph.showCommit(writer)
+	writer.WriteString(out.formatter.header(hash, from))
+	if pretty.name != "format" && pretty.name != "oneline" {
+		graph.showOneline(writer)
+	}
+	message, err := out.formatter.message(hash, commit)
+	if err != nil {
+		return err
+	}
+	out.missingNewline = !strings.HasSuffix(message, "\n")
+	graph.showMessage(writer, message)
+	if pretty.terminator && !pretty.empty() {
+		if !out.missingNewline {
+			graph.showPadding(writer)
+		}
+		writer.WriteString("\n")
+	}
+	return nil
+}
+
+// Write diffstat and patch following the log of a commit - separated from the message by an empty line, or
+// by "---" when both are shown; lines are prefixed with graph rows
+func (out *logOutput) writeDiffs(diffs []FileDiff) {
+	writer, options := out.writer, out.diffOptions
+	if pretty := out.formatter.pretty; pretty.name != "oneline" && !pretty.empty() {
+		out.graph.showPadding(writer)
+		if options.Stat.Stat && out.patch {
+			writer.WriteString("---")
+		}
+		writer.WriteString("\n")
+	}
+	if options.Stat.enabled() {
+		var stat bytes.Buffer
+		writeDiffStat(&stat, diffs, options.Stat)
+		out.graph.showPrefixed(writer, stat.String())
+		if !out.patch {
+			return
+		}
+		// Summary of files that were only modified is empty
+		if stat.Len() > 0 {
+			out.graph.showPadding(writer)
+			writer.WriteString("\n")
+		}
+	}
+	var patch bytes.Buffer
+	for _, diff := range diffs {
+		writeFileDiff(&patch, diff, options)
+	}
+	out.graph.showPrefixed(writer, patch.String())
+}
+
+// Commits of walk (in its order) the log goes through, with their parents in the graph. With paths, only
+// commits changing them are shown, and history is simplified as git does by default: a merge that has a
+// parent with the same paths is followed only through that parent (so branches whose changes it dropped are
+// left out). A parent that is left out for having the same paths is replaced by that parent of it, so the
+// graph joins the shown commits; parents the filters left out are dropped. --follow doesn't simplify -
+// every commit is gone through, those not changing the followed file are just not shown. Neither does -m: a
+// merge is shown when its paths differ from those of any parent.
+func selectLogCommits(include, commits []string, walked map[string]*Commit, options LogOptions, matcher *commitMatcher, diffOptions DiffOptions) ([]logEntry, map[string][]string, error) {
+	paths := indexPaths(options.Paths)
+	// Diffs of merges against every parent (-m) need the history they come from
+	fullHistory := options.Merges && !options.Follow
+	var entries []logEntry
+	inGraph := make(map[string]bool)
+	sameAs := make(map[string]string)
+	reached := make(map[string]bool)
+	for _, hash := range include {
+		reached[hash] = true
+	}
+	// Walk order has commits before their parents, so commits are reached before they come
+	for _, hash := range commits {
+		commit := walked[hash]
+		entry := logEntry{hash: hash, shown: true, paths: paths}
+		if len(paths) > 0 {
+			if !reached[hash] && !options.Follow {
+				continue
+			}
+			changed, sameParent, err := commitChangesPaths(commit, paths, fullHistory)
+			if err != nil {
+				return nil, nil, err
+			}
+			if sameParent != "" && !fullHistory {
+				reached[sameParent] = true
+			} else {
+				for _, parent := range commit.Parents {
+					reached[parent] = true
+				}
+			}
+			switch {
+			case !changed && !options.Follow:
+				sameAs[hash] = sameParent
+				continue
+			case !changed:
+				entry.shown = false
+			case options.Follow:
+				// Diff of the commit shows the followed file under both names
+				oldPath, err := followRename(commit, paths[0], diffOptions)
+				if err != nil {
+					return nil, nil, err
+				}
+				entry.paths = []string{paths[0]}
+				if oldPath != paths[0] {
+					entry.paths = append(entry.paths, oldPath)
+				}
+				paths = []string{oldPath}
+			}
+		}
+		if !matcher.matches(commit) {
+			continue
+		}
+		entries = append(entries, entry)
+		inGraph[hash] = true
+	}
+
+	parents := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		for _, parent := range walked[entry.hash].Parents {
+			for range len(sameAs) {
+				replacement, ok := sameAs[parent]
+				if !ok {
+					break
+				}
+				parent = replacement
+			}
+			if inGraph[parent] && !slices.Contains(parents[entry.hash], parent) {
+				parents[entry.hash] = append(parents[entry.hash], parent)
+			}
+		}
+	}
+	return entries, parents, nil
+}
+
+// Write one commit in medium format - with showSignature, verification report goes right after commit line;
+// colors are diff colors (nil when not colored)
+func writeLogEntry(writer *bufio.Writer, hash string, commit *Commit, showSignature bool, colors map[string]string) error {
+	formatter := newMediumFormatter(colors, showSignature)
+	message, err := formatter.message(hash, commit)
+	if err != nil {
+		return err
+	}
+	writer.WriteString(formatter.header(hash, "") + message)
+	return nil
+}
+
+// Diffs of commit against parent (against nothing when parent is "") - only files at paths are compared (all
+// with no paths), renames are looked for among them
+func commitDiffs(commit *Commit, parent string, paths []string, options DiffOptions) ([]FileDiff, error) {
+	oldFiles, newFiles := make(map[string]TreeEntry), make(map[string]TreeEntry)
+	if parent != "" {
+		parentTree, err := readCommitTreeHash(parent)
+		if err != nil {
+			return nil, err
+		}
+		if err := flattenTree(parentTree, "", oldFiles); err != nil {
+			return nil, err
+		}
+	}
+	if err := flattenTree(commit.Tree, "", newFiles); err != nil {
+		return nil, err
+	}
+	if len(paths) > 0 {
+		oldFiles, newFiles = filterPaths(oldFiles, paths), filterPaths(newFiles, paths)
+	}
+	changes, err := diffFileSets(oldFiles, newFiles, options)
+	if err != nil {
+		return nil, err
+	}
+	return diffChangedFiles(changes, options)
+}
+
+// Check whether commit changed anything at paths - false when some parent has the same entries there, that
+// parent is returned too (root commit changed every path it has). With fullHistory, a merge changed them
+// unless every parent has the same entries (the first parent is returned then).
+func commitChangesPaths(commit *Commit, paths []string, fullHistory bool) (bool, string, error) {
+	entries, err := treeEntriesAtPaths(commit.Tree, paths)
+	if err != nil {
+		return false, "", err
+	}
+	if len(commit.Parents) == 0 {
+		return strings.Join(entries, "") != "", "", nil
+	}
+	for _, parent := range commit.Parents {
+		parentTree, err := readCommitTreeHash(parent)
+		if err != nil {
+			return false, "", err
+		}
+		parentEntries, err := treeEntriesAtPaths(parentTree, paths)
+		if err != nil {
+			return false, "", err
+		}
+		same := equalStrings(entries, parentEntries)
+		if same && !fullHistory {
+			return false, parent, nil
+		}
+		if !same && fullHistory {
+			return true, "", nil
+		}
+	}
+	if fullHistory {
+		return false, commit.Parents[0], nil
+	}
+	return true, "", nil
+}
+
+// "<mode> <hash>" of the entry at every path of tree, empty for paths that are not there ("" is the tree itself)
+func treeEntriesAtPaths(treeHash string, paths []string) ([]string, error) {
+	entries := make([]string, len(paths))
+	for i, filePath := range paths {
+		mode, hash := "40000", treeHash
+		for _, name := range strings.Split(filePath, "/") {
+			if name == "" {
+				continue
+			}
+			if mode != "40000" {
+				hash = ""
+				break
+			}
+			_, _, content, err := readObjectFromHash(hash)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read tree %s: %w", hash, err)
+			}
+			treeEntries, err := parseTreeContent(content)
+			if err != nil {
+				return nil, err
+			}
+			hash = ""
+			for _, entry := range treeEntries {
+				if entry.Name == name {
+					mode, hash = entry.Mode, entry.Hash
+					break
+				}
+			}
+			if hash == "" {
+				break
+			}
+		}
+		if hash != "" {
+			entries[i] = mode + " " + hash
+		}
+	}
+	return entries, nil
+}
+
+// Path the followed file had before commit - the old name when commit renamed or copied it (compared with the
+// first parent)
+func followRename(commit *Commit, filePath string, options DiffOptions) (string, error) {
+	if len(commit.Parents) == 0 {
+		return filePath, nil
+	}
+	parentTree, err := readCommitTreeHash(commit.Parents[0])
+	if err != nil {
+		return "", err
+	}
+	if entries, err := treeEntriesAtPaths(parentTree, []string{filePath}); err != nil || entries[0] != "" {
+		return filePath, err
+	}
+
+	// Only the followed file is paired - other added files would just cost time
+	changes, err := diffTrees(parentTree, commit.Tree, DiffOptions{Renames: DiffRenamesOff})
+	if err != nil {
+		return "", err
+	}
+	var candidates []FileChange
+	for _, change := range changes {
+		if change.Status != 'A' || change.NewPath == filePath {
+			candidates = append(candidates, change)
+		}
+	}
+	oldFiles := make(map[string]TreeEntry)
+	if err := flattenTree(parentTree, "", oldFiles); err != nil {
+		return "", err
+	}
+	if changes, err = detectRenames(candidates, oldFiles, options); err != nil {
+		return "", err
+	}
+	for _, change := range changes {
+		if change.NewPath == filePath && (change.Status == 'R' || change.Status == 'C') {
+			return change.OldPath, nil
+		}
+	}
+	return filePath, nil
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ls-files - paths in the index, relative to the starting directory (as git shows them), or with --stage
+//
+//	<mode> <hash> <stage>\t<path>
+//
+// A conflicted path has a line for each of its stages 1-3 with --stage, and --unmerged lists only those.
+// Without paths only files below the starting directory are listed. Paths are C-quoted when they have special
+// characters, unless -z ends entries with NUL instead of a newline.
+
+// List index entries selected by options - paths must be relative to the work tree root, prefix is the
+// starting directory
+func listFiles(options LsFilesOptions, prefix string, w io.Writer) error {
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	quote := config.GetBool("core.quotePath", true)
+
+	paths := indexPaths(options.Paths)
+	if len(paths) == 0 {
+		paths = indexPaths([]string{prefix})
+	}
+	if prefix == "" {
+		prefix = "."
+	}
+
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+	for i, entry := range entries {
+		if options.Unmerged && entry.Stage == 0 {
+			continue
+		}
+		// Without --stage a conflicted path is listed once
+		if !options.Stage && i > 0 && entries[i-1].Path == entry.Path {
+			continue
+		}
+		selected := false
+		for _, path := range paths {
+			if path == "" || entry.Path == path || strings.HasPrefix(entry.Path, path+"/") {
+				selected = true
+				break
+			}
+		}
+		if !selected {
+			continue
+		}
+
+		name, err := filepath.Rel(filepath.FromSlash(prefix), filepath.FromSlash(entry.Path))
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if options.NullTerminated {
+			name += "\x00"
+		} else {
+			name = quotePath(name, quote) + "\n"
+		}
+		if options.Stage {
+			fmt.Fprintf(writer, "%06o %x %d\t%s", entry.Mode, entry.Hash, entry.Stage, name)
+		} else {
+			writer.WriteString(name)
+		}
+	}
+	return nil
+}
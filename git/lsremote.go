@@ -0,0 +1,83 @@
+package git
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// ls-remote - refs of a remote repository, as its upload-pack advertises them:
+//
+//	<hash>\t<ref>
+//
+// HEAD comes first, then the other refs by name (peeled tags as <tag>^{}). --heads and --tags only list
+// branches and tags, without HEAD.
+
+// URL of remote - remote.<name>.url when the active repository has such remote, otherwise remote is the URL
+// (or path) itself
+func resolveRemoteUrl(remote string) string {
+	remoteUrl := absoluteRemoteUrl(remote)
+	if activeLayout == nil {
+		return remoteUrl
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return remoteUrl
+	}
+	if configUrl, ok := config.Get("remote." + remote + ".url"); ok {
+		return absoluteRemoteUrl(configUrl)
+	}
+	return remoteUrl
+}
+
+// List refs of remote selected by options - remote names are looked up in the repository of the context
+// directory, when there is one
+func (c *RepoContext) listRemoteRefs(ctx context.Context, options LsRemoteOptions) ([]RemoteRef, error) {
+	if repo, err := c.Open("."); err == nil {
+		defer repo.enter()()
+	} else {
+		defer c.enter()()
+	}
+	transport, err := newTransport(resolveRemoteUrl(options.Remote), "")
+	if err != nil {
+		return nil, err
+	}
+	defer transport.Close()
+
+	var prefixes []string
+	if options.Heads {
+		prefixes = append(prefixes, "refs/heads/")
+	}
+	if options.Tags {
+		prefixes = append(prefixes, "refs/tags/")
+	}
+	refs, _, headBranch, _, err := discoverRemoteRefs(ctx, transport, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	var remoteRefs []RemoteRef
+	if hash, ok := refs["HEAD"]; ok && len(prefixes) == 0 {
+		head := RemoteRef{Name: "HEAD", Hash: hash}
+		if headBranch != "" {
+			head.Target = "refs/heads/" + headBranch
+		}
+		remoteRefs = append(remoteRefs, head)
+	}
+	// Peeled tag comes right after its tag
+	names := sortedKeys(refs)
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.TrimSuffix(names[i], "^{}") < strings.TrimSuffix(names[j], "^{}")
+	})
+	// Protocol v0 advertises every ref, whatever the prefixes
+	for _, name := range names {
+		selected := len(prefixes) == 0
+		for _, prefix := range prefixes {
+			selected = selected || strings.HasPrefix(name, prefix)
+		}
+		if name != "HEAD" && selected {
+			remoteRefs = append(remoteRefs, RemoteRef{Name: name, Hash: refs[name]})
+		}
+	}
+	return remoteRefs, nil
+}
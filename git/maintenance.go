@@ -0,0 +1,413 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Maintenance - keeping the object database fast as it grows. Tasks:
+//   - loose-objects - loose objects that are already packed are removed, the others go to a new pack (up to
+//     50000 at a time). They are removed by the next run, so a reader that found them just now doesn't
+//     lose them.
+//   - commit-graph  - objects/info/commit-graph is rewritten with every reachable commit
+//   - gc            - refs are packed, old reflog entries expire (see reflog.go), objects are repacked
+//     (`repack -A -d` - with --auto only loose objects, unless there are too many packs), unreachable
+//     objects older than gc.pruneExpire are pruned (see prune.go), commit-graph is written
+//     (gc.writeCommitGraph) and old rerere records are forgotten
+//
+// `maintenance run` runs tasks given with --task, or those enabled with maintenance.<task>.enabled (only gc
+// by default). With --auto only tasks that are due run:
+//   - gc            - more loose objects than gc.auto (6700 - estimated from objects/17, like git does), or
+//     more packs without .keep than gc.autoPackLimit (50); gc.auto = 0 turns it off
+//   - loose-objects - maintenance.loose-objects.auto (100) loose objects
+//   - commit-graph  - maintenance.commit-graph.auto (100) reachable commits missing from commit-graph
+//
+// (0 turns a task's --auto run off, a negative value makes it always due)
+//
+// Commit and fetch run auto maintenance afterwards (unless maintenance.auto is false) - in a background
+// process, or in the foreground when gc.autoDetach is false. gc.pid keeps two gcs from running at once.
+
+const (
+	defaultGcAuto           = 6700
+	defaultGcAutoPackLimit  = 50
+	defaultLooseObjectsAuto = 100
+	defaultCommitGraphAuto  = 100
+	looseObjectsBatchSize   = 50000
+	// gc.pid older than this belongs to a gc that died
+	gcLockExpiry = 12 * time.Hour
+)
+
+// Maintenance tasks, in the order they run
+var maintenanceTasks = []string{"loose-objects", "gc", "commit-graph"}
+
+// Run maintenance tasks - writes what was done to w
+func runMaintenance(options MaintenanceOptions, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	for _, task := range options.Tasks {
+		if !slices.Contains(maintenanceTasks, task) {
+			return fmt.Errorf("'%s' is not a valid task", task)
+		}
+	}
+
+	for _, task := range selectMaintenanceTasks(config, options.Tasks) {
+		if options.Auto && !maintenanceTaskDue(config, task) {
+			continue
+		}
+		switch task {
+		case "loose-objects":
+			err = packLooseObjectsTask(w)
+		case "commit-graph":
+			err = writeCommitGraphTask(w)
+		case "gc":
+			err = runGc(GcOptions{Auto: options.Auto}, w)
+		}
+		if err != nil {
+			return fmt.Errorf("task '%s' failed: %w", task, err)
+		}
+	}
+	return nil
+}
+
+// Tasks to run - the requested ones, or the enabled ones in their order
+func selectMaintenanceTasks(config *Config, requested []string) []string {
+	if len(requested) > 0 {
+		return requested
+	}
+	var tasks []string
+	for _, task := range maintenanceTasks {
+		if config.GetBool("maintenance."+task+".enabled", task == "gc") {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// Check whether task has enough work to run with --auto
+func maintenanceTaskDue(config *Config, task string) bool {
+	switch task {
+	case "gc":
+		return gcNeeded(config)
+	case "loose-objects":
+		limit := config.GetInt("maintenance.loose-objects.auto", defaultLooseObjectsAuto)
+		if limit <= 0 {
+			return limit < 0
+		}
+		hashes, err := listLooseObjects()
+		return err == nil && int64(len(hashes)) >= limit
+	case "commit-graph":
+		limit := config.GetInt("maintenance.commit-graph.auto", defaultCommitGraphAuto)
+		if limit <= 0 {
+			return limit < 0
+		}
+		commits, err := reachableCommits()
+		if err != nil {
+			return false
+		}
+		inGraph, missing := readCommitGraphHashes(), 0
+		for _, hash := range commits {
+			if !inGraph[hash] {
+				missing++
+			}
+		}
+		return int64(missing) >= limit
+	}
+	return false
+}
+
+// Check gc.auto thresholds - loose objects (those in objects/17 times 256) and packs without .keep
+func gcNeeded(config *Config) bool {
+	if config.GetInt("gc.auto", defaultGcAuto) <= 0 {
+		return false
+	}
+	return gcTooManyLooseObjects(config) || gcTooManyPacks(config)
+}
+
+// Check whether objects/17 has more loose objects than gc.auto/256
+func gcTooManyLooseObjects(config *Config) bool {
+	limit := config.GetInt("gc.auto", defaultGcAuto)
+	entries, _ := os.ReadDir(objectDirPath("17"))
+	loose := 0
+	for _, entry := range entries {
+		if isHexHash("17" + entry.Name()) {
+			loose++
+		}
+	}
+	return int64(loose) > (limit+255)/256
+}
+
+// Check whether there are more packs without .keep than gc.autoPackLimit
+func gcTooManyPacks(config *Config) bool {
+	packLimit := config.GetInt("gc.autoPackLimit", defaultGcAutoPackLimit)
+	packs, _ := filepath.Glob(objectDirPath("pack", "*.pack"))
+	count := 0
+	for _, pack := range packs {
+		if _, err := os.Stat(strings.TrimSuffix(pack, ".pack") + ".keep"); err != nil {
+			count++
+		}
+	}
+	return packLimit > 0 && int64(count) > packLimit
+}
+
+// Run due maintenance after a command that added objects - in a background process of RepoContext.Program
+// (the mygit command), or in this one when gc.autoDetach is false or there is no program to start. Failures
+// don't fail the command, they are only reported.
+func runAutoMaintenance(w io.Writer) {
+	config, err := loadConfig()
+	if err != nil || !config.GetBool("maintenance.auto", true) {
+		return
+	}
+	due, gcDue := false, false
+	for _, task := range selectMaintenanceTasks(config, nil) {
+		if maintenanceTaskDue(config, task) {
+			due, gcDue = true, gcDue || task == "gc"
+		}
+	}
+	if !due {
+		return
+	}
+
+	program := currentContext().Program
+	if !config.GetBool("gc.autoDetach", true) || program == "" {
+		if err := runMaintenance(MaintenanceOptions{Auto: true}, w); err != nil {
+			fmt.Fprintf(w, "warning: auto maintenance failed: %s\n", err)
+		}
+		return
+	}
+	if gcDue {
+		fmt.Fprintf(w, "Auto packing the repository in background for optimum performance.\n")
+		fmt.Fprintf(w, "See \"git help gc\" for manual housekeeping.\n")
+	}
+	cmd := exec.Command(program, "maintenance", "run", "--auto")
+	cmd.Dir, cmd.Env = commandDir(), hookEnvironment()
+	if err = cmd.Start(); err == nil {
+		err = cmd.Process.Release()
+	}
+	if err != nil {
+		fmt.Fprintf(w, "warning: failed to start auto maintenance: %s\n", err)
+	}
+}
+
+// Garbage collection - pack refs, expire reflogs, repack objects, prune unreachable ones, write commit-graph,
+// forget old rerere records. With Auto, only when gc.auto thresholds are exceeded (and quietly nothing when
+// another gc is running).
+func runGc(options GcOptions, w io.Writer) error {
+	defer ignoreReplaceRefs()()
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if options.Auto {
+		if !gcNeeded(config) {
+			return nil
+		}
+		fmt.Fprintf(w, "Auto packing the repository for optimum performance.\n")
+	}
+	unlock, err := lockGc()
+	if err != nil {
+		if options.Auto {
+			return nil
+		}
+		return err
+	}
+	defer unlock()
+
+	if config.GetBool("gc.packRefs", true) {
+		if err := packRefs(true, false); err != nil {
+			return fmt.Errorf("failed to pack refs: %w", err)
+		}
+	}
+	expired, err := expireReflogs(ReflogExpireOptions{All: true}, w)
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		fmt.Fprintf(w, "Expired %d reflog entries\n", expired)
+	}
+
+	pruneExpire := options.Prune
+	if pruneExpire == "" {
+		if value, ok := config.Get("gc.pruneExpire"); ok {
+			pruneExpire = value
+		} else {
+			pruneExpire = defaultPruneExpire
+		}
+	}
+	if _, err := parseExpiryDate(pruneExpire, time.Now()); err != nil {
+		return fmt.Errorf("invalid gc.pruneExpire: %w", err)
+	}
+
+	// Auto gc only rewrites every pack when there are too many of them - loose objects just get a new one
+	repack := RepackOptions{Delete: true, UnpackUnreachable: pruneExpire}
+	if !options.Auto || gcTooManyPacks(config) {
+		repack.All, repack.KeepUnreachable = true, true
+	}
+	if err := runRepack(repack, w); err != nil {
+		return err
+	}
+	pruned, err := pruneObjects(PruneOptions{Expire: pruneExpire}, w)
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		fmt.Fprintf(w, "Pruned %d unreachable objects\n", pruned)
+	}
+	if config.GetBool("gc.writeCommitGraph", true) {
+		if grafted, err := hasCommitGrafts(); err != nil {
+			return err
+		} else if !grafted {
+			if err := writeCommitGraphTask(w); err != nil {
+				return err
+			}
+		}
+	}
+	return rerereGc()
+}
+
+// Take gc.pid - fails while another gc holds it (unless it is so old that its gc must have died)
+func lockGc() (func(), error) {
+	lockPath := gitDirPath("gc.pid")
+	hostname, _ := os.Hostname()
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d %s", os.Getpid(), hostname)
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create %s: %w", lockPath, err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) < gcLockExpiry {
+			owner, _ := os.ReadFile(lockPath)
+			return nil, fmt.Errorf("gc is already running (pid %s) - remove %s if it is not", strings.TrimSpace(string(owner)), lockPath)
+		}
+		os.Remove(lockPath)
+	}
+	return nil, fmt.Errorf("failed to create %s", lockPath)
+}
+
+// loose-objects task - remove loose objects that are packed, then pack a batch of the rest
+func packLooseObjectsTask(w io.Writer) error {
+	if _, err := prunePackedObjects(); err != nil {
+		return err
+	}
+	_, err := packLooseObjects(looseObjectsBatchSize, w)
+	return err
+}
+
+// commit-graph task
+func writeCommitGraphTask(w io.Writer) error {
+	commits, err := writeCommitGraph()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Wrote commit-graph with %d commits\n", commits)
+	return nil
+}
+
+// Hashes of loose objects in own object directory
+func listLooseObjects() ([]string, error) {
+	dirs, err := os.ReadDir(objectDirPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object directory: %w", err)
+	}
+	var hashes []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(objectDirPath(dir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object directory: %w", err)
+		}
+		for _, entry := range entries {
+			if hash := dir.Name() + entry.Name(); isHexHash(hash) {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// Check whether name is a full hex object hash
+func isHexHash(name string) bool {
+	if len(name) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// Write loose objects that are not packed yet to a new pack (at most limit of them, 0 - all) - loose copies
+// stay until prunePackedObjects. Returns the number of packed objects.
+func packLooseObjects(limit int, w io.Writer) (int, error) {
+	hashes, err := listLooseObjects()
+	if err != nil {
+		return 0, err
+	}
+	var objects []GitObject
+	for _, hash := range hashes {
+		if limit > 0 && len(objects) >= limit {
+			break
+		}
+		raw, _ := hex.DecodeString(hash)
+		if _, _, packed, err := findPackedObject(raw); err != nil {
+			return 0, err
+		} else if packed {
+			continue
+		}
+		typeName, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		objType, err := ObjectTypeFromString(typeName)
+		if err != nil {
+			return 0, err
+		}
+		objects = append(objects, GitObject{Type: objType, Data: content, Hash: hash})
+	}
+	if len(objects) == 0 {
+		return 0, nil
+	}
+	name, err := writePack(objects, false)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(w, "Packed %d loose objects into %s\n", len(objects), name)
+	return len(objects), nil
+}
+
+// Remove loose objects that are in a pack (git prune-packed) - returns how many were removed
+func prunePackedObjects() (int, error) {
+	hashes, err := listLooseObjects()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		_, _, packed, err := findPackedObject(raw)
+		if err != nil {
+			return removed, err
+		}
+		if !packed {
+			continue
+		}
+		if err := os.Remove(objectDirPath(hash[:2], hash[2:])); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove loose object %s: %w", hash, err)
+		}
+		os.Remove(objectDirPath(hash[:2]))
+		removed++
+	}
+	return removed, nil
+}
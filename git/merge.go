@@ -0,0 +1,1080 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Merge - three-way merge of another commit into HEAD (git merge <commit>)
+//
+// Merge base is the best common ancestor of the two commits. When there are several of them (criss-cross
+// history), the recursive strategy first merges them into a virtual base (its conflicts stay in it as
+// conflict markers). Trees are then merged file by file: a file only one side changed takes that side's
+// version, a file both sides changed is merged line by line (diff3). Renames are detected on both sides
+// against the base, so a file renamed on one side gets the other side's changes at its new name.
+//
+// Strategies (-s):
+//   - recursive (default, "ort" means the same) - as described above
+//   - ours - the result is HEAD's tree as it is, the other commits are only recorded as parents
+//   - octopus (default with several commits) - see octopus.go
+//
+// Strategy options (-X):
+//   - ours / theirs - conflicting hunks are resolved in favor of that side (other changes are still merged)
+//   - no-renames, find-renames[=<n>], rename-threshold=<n> - rename detection and its similarity threshold
+//
+// Fast-forward (HEAD is an ancestor of the merged commit) just moves the branch, unless --no-ff (or
+// merge.ff false) asks for a merge commit anyway; --ff-only (merge.ff only) refuses to do anything else.
+// With --squash the merge result is left in index and work tree without moving HEAD, and .git/SQUASH_MSG
+// lists the merged commits for the commit that follows.
+//
+// Conflict hunks are written as below - the base part only with merge.conflictStyle diff3 or zdiff3. Lines
+// both sides have at the start and end of a hunk are moved out of it (except with diff3, which shows the
+// hunk as it is):
+//
+//	<<<<<<< HEAD
+//	our lines
+//	||||||| <merge base>
+//	base lines
+//	=======
+//	their lines
+//	>>>>>>> <merged name>
+
+// Merge strategies by name - ort is what git calls its recursive merge these days
+var mergeStrategies = map[string]string{"recursive": "recursive", "ort": "recursive", "ours": "ours", "octopus": "octopus"}
+
+// Merge commits named by options into HEAD (octopus merge when there are several) - progress and conflicts
+// are written to w
+func mergeCommits(options MergeOptions, w io.Writer) error {
+	if len(options.Commits) == 0 {
+		return fmt.Errorf("merge needs a commit to merge")
+	}
+	if options.Strategy == "" {
+		options.Strategy = "ort"
+		if len(options.Commits) > 1 {
+			options.Strategy = "octopus"
+		}
+	}
+	strategy, ok := mergeStrategies[options.Strategy]
+	if !ok {
+		return fmt.Errorf("Could not find merge strategy '%s'.\nAvailable strategies are: %s.", options.Strategy, strings.Join(sortedKeys(mergeStrategies), " "))
+	}
+
+	fastForwardMode := options.FastForward
+	if fastForwardMode == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		switch value, _ := config.Get("merge.ff"); value {
+		case "false":
+			fastForwardMode = "no"
+		case "only":
+			fastForwardMode = "only"
+		}
+	}
+	if options.Squash && options.FastForward == "no" {
+		return fmt.Errorf("options '--squash' and '--no-ff' cannot be used together")
+	}
+
+	names := make(map[string]string)
+	var remotes []string
+	for _, name := range options.Commits {
+		hash, err := resolveCommitRevision(name)
+		if err != nil {
+			return fmt.Errorf("%s - not something we can merge: %w", name, err)
+		}
+		if _, ok := names[hash]; !ok {
+			names[hash] = name
+		}
+		remotes = append(remotes, hash)
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	headRef := branch
+	if headRef == "" {
+		headRef = "HEAD"
+	}
+
+	if head == "" {
+		if len(remotes) > 1 {
+			return fmt.Errorf("can merge only exactly one commit into empty head")
+		}
+		// Unborn branch - the merged commit simply becomes its first commit
+		theirsFiles, err := commitFiles(remotes[0])
+		if err != nil {
+			return err
+		}
+		if err := checkoutFiles(map[string]TreeEntry{}, theirsFiles, theirsFiles, "merge"); err != nil {
+			return err
+		}
+		return updateRef(headRef, remotes[0], "initial pull")
+	}
+
+	if heads, err := readMergeHeads(); err != nil {
+		return err
+	} else if heads != nil {
+		return fmt.Errorf("You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.")
+	}
+	if err := requireMergedIndex("Merging"); err != nil {
+		return err
+	}
+	if dirty, err := indexDiffersFromCommit(head); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("your index contains uncommitted changes - commit or stash them before you merge")
+	}
+
+	// Merged commits contained in other merged commits add nothing - one left is an ordinary merge
+	if remotes, err = reduceHeads(remotes); err != nil {
+		return err
+	}
+	headFiles, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	result := &MergeResult{Files: headFiles}
+	parents := append([]string{head}, remotes...)
+
+	if len(remotes) == 1 {
+		theirs := remotes[0]
+		bases, err := mergeBases(head, theirs)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(bases) == 0 && !options.AllowUnrelatedHistories:
+			return fmt.Errorf("refusing to merge unrelated histories")
+		case slices.Contains(bases, theirs):
+			fmt.Fprintln(w, "Already up to date.")
+			return nil
+		case slices.Contains(bases, head) && (fastForwardMode != "no" || options.Squash):
+			return fastForward(headRef, head, theirs, names[theirs], options.Squash, w)
+		case fastForwardMode == "only":
+			return fmt.Errorf("Not possible to fast-forward, aborting: %w", ErrNonFastForward)
+		}
+		// Octopus of what turned out to be a single commit is an ordinary merge
+		if strategy != "ours" {
+			if result, err = mergeRecursive(bases, headFiles, theirs, options.StrategyOptions, names[theirs]); err != nil {
+				return err
+			}
+		}
+		for _, message := range result.Messages {
+			fmt.Fprintln(w, message.Text)
+		}
+	} else {
+		// Commits HEAD already has are left out - HEAD reachable from the others isn't a parent, unless a
+		// merge commit is asked for
+		if parents, err = reduceHeads(parents); err != nil {
+			return err
+		}
+		if len(parents) == 1 && parents[0] == head {
+			fmt.Fprintln(w, "Already up to date.")
+			return nil
+		}
+		if parents[0] != head && fastForwardMode == "no" {
+			parents = append([]string{head}, parents...)
+		}
+
+		switch {
+		case fastForwardMode == "only":
+			return fmt.Errorf("Not possible to fast-forward, aborting: %w", ErrNonFastForward)
+		case strategy == "recursive":
+			return fmt.Errorf("Merge with strategy %s failed.", options.Strategy)
+		case strategy == "octopus":
+			if result, err = mergeOctopus(head, headFiles, remotes, names, fastForwardMode != "no", w); err != nil {
+				return err
+			}
+		}
+	}
+
+	conflicts, err := checkoutMergeResult(headFiles, result, "merge", w)
+	if err != nil {
+		return err
+	}
+	message := options.Message
+	if message == "" {
+		message = mergeMessage(options.Commits, branch)
+	} else if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	// Merge stopped by conflicts is concluded by the commit of the resolution
+	if conflicts && !options.Squash {
+		merged := slices.DeleteFunc(slices.Clone(parents), func(parent string) bool { return parent == head })
+		if err := writeMergeState(head, merged, message, result.Conflicts, fastForwardMode == "no"); err != nil {
+			return err
+		}
+	}
+	if options.Squash {
+		if err := writeSquashMessage(head, remotes); err != nil {
+			return err
+		}
+		if !conflicts {
+			fmt.Fprintln(w, "Automatic merge went well; stopped before committing as requested")
+		}
+		fmt.Fprintln(w, "Squash commit -- not updating HEAD")
+	}
+	if conflicts {
+		return fmt.Errorf("Automatic merge failed; fix conflicts and then commit the result.")
+	}
+	if options.Squash {
+		runHook("post-merge", nil, "1")
+		return nil
+	}
+
+	treeHash, err := writeTreeFromFiles(result.Files)
+	if err != nil {
+		return err
+	}
+	commit, err := createCommit(treeHash, parents, CommitOptions{Message: message})
+	if err != nil {
+		return err
+	}
+	reflogMessage := fmt.Sprintf("merge %s: Merge made by the '%s' strategy.",
+		strings.Join(options.Commits, " "), options.Strategy)
+	if err := updateRef(headRef, commit, reflogMessage); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Merge made by the '%s' strategy.\n", options.Strategy)
+	if err := writeMergeStat(w, head, commit); err != nil {
+		return err
+	}
+	runHook("post-merge", nil, "0")
+	return nil
+}
+
+// Three-way merge of theirs into headFiles over the merge bases (merged into a virtual base when there are
+// several) - name labels theirs in conflict markers
+func mergeRecursive(bases []string, headFiles map[string]TreeEntry, theirs string, strategyOptions []string, name string) (*MergeResult, error) {
+	settings, err := resolveMergeSettings(strategyOptions, name)
+	if err != nil {
+		return nil, err
+	}
+	theirsFiles, err := commitFiles(theirs)
+	if err != nil {
+		return nil, err
+	}
+	baseTree, err := mergeBaseTree(bases, settings)
+	if err != nil {
+		return nil, err
+	}
+	baseFiles := make(map[string]TreeEntry)
+	if baseTree != "" {
+		if err := flattenTree(baseTree, "", baseFiles); err != nil {
+			return nil, err
+		}
+	}
+	settings.BaseLabel = "merged common ancestors"
+	if len(bases) == 1 {
+		settings.BaseLabel = shortHash(bases[0])
+	}
+	return mergeFileSets(baseFiles, headFiles, theirsFiles, settings)
+}
+
+// Check out merge result over headFiles - conflicted paths are in the index as stage 1-3 entries, the work
+// tree has the conflict markers (remembered by rerere, which reports to w). Returns whether there are
+// conflicts.
+func checkoutMergeResult(headFiles map[string]TreeEntry, result *MergeResult, operation string, w io.Writer) (bool, error) {
+	indexFiles := make(map[string]TreeEntry, len(result.Files))
+	for filePath, entry := range result.Files {
+		indexFiles[filePath] = entry
+	}
+	for _, conflict := range result.Conflicts {
+		delete(indexFiles, conflict.Path)
+		if conflict.Ours != nil {
+			indexFiles[conflict.Path] = *conflict.Ours
+		}
+	}
+	if err := checkoutFiles(headFiles, result.Files, indexFiles, operation); err != nil {
+		return false, err
+	}
+	if len(result.Conflicts) == 0 {
+		return false, nil
+	}
+	if err := writeConflictStages(result.Conflicts); err != nil {
+		return false, err
+	}
+
+	conflicted := make([]string, 0, len(result.Conflicts))
+	for _, conflict := range result.Conflicts {
+		conflicted = append(conflicted, conflict.Path)
+	}
+	return true, rerereConflicts(conflicted, w)
+}
+
+// Move HEAD's branch forward to commit that already contains it - with squash only index and work tree move
+func fastForward(headRef, head, commit, name string, squash bool, w io.Writer) error {
+	fmt.Fprintf(w, "Updating %s..%s\nFast-forward\n", shortHash(head), shortHash(commit))
+	headFiles, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(commit)
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(headFiles, files, files, "merge"); err != nil {
+		return err
+	}
+	if squash {
+		if err := writeSquashMessage(head, []string{commit}); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "Squash commit -- not updating HEAD")
+		if err := writeMergeStat(w, head, commit); err != nil {
+			return err
+		}
+		runHook("post-merge", nil, "1")
+		return nil
+	}
+	if err := updateRef(headRef, commit, "merge "+name+": Fast-forward"); err != nil {
+		return err
+	}
+	if err := writeMergeStat(w, head, commit); err != nil {
+		return err
+	}
+	runHook("post-merge", nil, "0")
+	return nil
+}
+
+// Write .git/SQUASH_MSG - log of the commits squashed into HEAD, for the commit that concludes the squash
+func writeSquashMessage(head string, merged []string) error {
+	commits, err := revList(merged, []string{head})
+	if err != nil {
+		return err
+	}
+	var message bytes.Buffer
+	writer := bufio.NewWriter(&message)
+	writer.WriteString("Squashed commit of the following:\n")
+	for _, hash := range commits {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		writer.WriteString("\n")
+		if err := writeLogEntry(writer, hash, commit, false, nil); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(gitDirPath("SQUASH_MSG"), message.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write SQUASH_MSG: %w", err)
+	}
+	return nil
+}
+
+// Diffstat of what the merge brought into HEAD
+func writeMergeStat(w io.Writer, oldCommit, newCommit string) error {
+	oldTree, err := readCommitTreeHash(oldCommit)
+	if err != nil {
+		return err
+	}
+	newTree, err := readCommitTreeHash(newCommit)
+	if err != nil {
+		return err
+	}
+	options, err := resolveDiffOptions(DiffOptions{})
+	if err != nil {
+		return err
+	}
+	diffs, err := diffTreeFiles(oldTree, newTree, options)
+	if err != nil {
+		return err
+	}
+	writeDiffStat(w, diffs, DiffStatOptions{Stat: true, Summary: true})
+	return nil
+}
+
+// Default merge commit message - "Merge branch 'x'" (tag, remote-tracking branch or commit for other
+// names; several names are grouped by kind - "Merge branches 'a' and 'b', tag 'v1'"), with " into <branch>"
+// unless merged into main or master
+func mergeMessage(names []string, branch string) string {
+	kinds := []string{"branch", "tag", "remote-tracking branch", "commit"}
+	groups := make(map[string][]string)
+	for _, name := range names {
+		refName, _, _ := resolveRevision(name)
+		switch {
+		case strings.HasPrefix(refName, "refs/heads/"):
+			groups["branch"] = append(groups["branch"], "'"+strings.TrimPrefix(refName, "refs/heads/")+"'")
+		case strings.HasPrefix(refName, "refs/remotes/"):
+			groups["remote-tracking branch"] = append(groups["remote-tracking branch"], "'"+strings.TrimPrefix(refName, "refs/remotes/")+"'")
+		case strings.HasPrefix(refName, "refs/tags/"):
+			groups["tag"] = append(groups["tag"], "'"+strings.TrimPrefix(refName, "refs/tags/")+"'")
+		default:
+			groups["commit"] = append(groups["commit"], "'"+name+"'")
+		}
+	}
+	var parts []string
+	for _, kind := range kinds {
+		group := groups[kind]
+		switch {
+		case len(group) == 1:
+			parts = append(parts, kind+" "+group[0])
+		case len(group) > 1:
+			plural := kind + "s"
+			if strings.HasSuffix(kind, "branch") {
+				plural = kind + "es"
+			}
+			parts = append(parts, plural+" "+strings.Join(group[:len(group)-1], ", ")+" and "+group[len(group)-1])
+		}
+	}
+	message := "Merge " + strings.Join(parts, ", ")
+	if branch = strings.TrimPrefix(branch, "refs/heads/"); branch != "" && branch != "main" && branch != "master" {
+		message += " into " + branch
+	}
+	return message + "\n"
+}
+
+// Settings of a merge from -X options and config - theirsLabel names the merged side in conflict markers
+func resolveMergeSettings(strategyOptions []string, theirsLabel string) (MergeSettings, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return MergeSettings{}, err
+	}
+	settings := MergeSettings{
+		Renames:       config.GetBool("merge.renames", config.GetBool("diff.renames", true)),
+		RenameScore:   diffDefaultMinScore,
+		ConflictStyle: "merge",
+		OursLabel:     "HEAD",
+		TheirsLabel:   theirsLabel,
+	}
+	if style, ok := config.Get("merge.conflictstyle"); ok {
+		if style != "merge" && style != "diff3" && style != "zdiff3" {
+			return settings, fmt.Errorf("unknown style '%s' given for 'merge.conflictstyle'", style)
+		}
+		settings.ConflictStyle = style
+	}
+
+	for _, option := range strategyOptions {
+		name, value, hasValue := strings.Cut(option, "=")
+		switch {
+		case (option == "ours" || option == "theirs") && !hasValue:
+			settings.Favor = option
+		case option == "no-renames":
+			settings.Renames = false
+		case name == "find-renames" || (name == "rename-threshold" && hasValue):
+			settings.Renames = true
+			if hasValue {
+				if settings.RenameScore, err = ParseRenameScore(value); err != nil {
+					return settings, err
+				}
+			}
+		default:
+			return settings, fmt.Errorf("unknown strategy option: -X%s", option)
+		}
+	}
+	return settings, nil
+}
+
+// Best common ancestors of two commits - common ancestors that are not ancestors of another common ancestor
+// (usually just one; none for unrelated histories)
+func mergeBases(a, b string) ([]string, error) {
+	ancestorsOfA := make(map[string]bool)
+	queue := []string{a}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if ancestorsOfA[hash] {
+			continue
+		}
+		ancestorsOfA[hash] = true
+		parents, err := readCommitParents(hash)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, parents...)
+	}
+
+	// Walk from b stops at the first common commits on every path - their ancestors are common too, but worse
+	var candidates []string
+	seen := make(map[string]bool)
+	queue = []string{b}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		if ancestorsOfA[hash] {
+			candidates = append(candidates, hash)
+			continue
+		}
+		parents, err := readCommitParents(hash)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, parents...)
+	}
+
+	var bases []string
+	for _, candidate := range candidates {
+		redundant := false
+		for _, other := range candidates {
+			if other == candidate {
+				continue
+			}
+			var err error
+			if redundant, err = isAncestor(candidate, other); err != nil {
+				return nil, err
+			} else if redundant {
+				break
+			}
+		}
+		if !redundant {
+			bases = append(bases, candidate)
+		}
+	}
+	sort.Strings(bases)
+	return bases, nil
+}
+
+// Tree to use as merge base - tree of the only base, or the bases merged one by one into a virtual base
+// (recursively, with their own merge bases); "" (empty tree) when there is no base
+func mergeBaseTree(bases []string, settings MergeSettings) (string, error) {
+	if len(bases) == 0 {
+		return "", nil
+	}
+	tree, err := readCommitTreeHash(bases[0])
+	if err != nil {
+		return "", err
+	}
+
+	// Conflicts of the virtual base stay in it as markers - they conflict again only where the sides changed them
+	settings.Favor, settings.ConflictStyle = "", "merge"
+	settings.OursLabel, settings.TheirsLabel = "Temporary merge branch 1", "Temporary merge branch 2"
+	for _, next := range bases[1:] {
+		innerBases, err := mergeBases(bases[0], next)
+		if err != nil {
+			return "", err
+		}
+		innerTree, err := mergeBaseTree(innerBases, settings)
+		if err != nil {
+			return "", err
+		}
+		nextTree, err := readCommitTreeHash(next)
+		if err != nil {
+			return "", err
+		}
+		result, err := mergeTrees(innerTree, tree, nextTree, settings)
+		if err != nil {
+			return "", err
+		}
+		if tree, err = writeTreeFromFiles(result.Files); err != nil {
+			return "", err
+		}
+	}
+	return tree, nil
+}
+
+// Three-way merge of trees ("" is an empty tree)
+func mergeTrees(baseTree, oursTree, theirsTree string, settings MergeSettings) (*MergeResult, error) {
+	sides := make([]map[string]TreeEntry, 3)
+	for i, tree := range []string{baseTree, oursTree, theirsTree} {
+		sides[i] = make(map[string]TreeEntry)
+		if tree == "" {
+			continue
+		}
+		if err := flattenTree(tree, "", sides[i]); err != nil {
+			return nil, err
+		}
+	}
+	return mergeFileSets(sides[0], sides[1], sides[2], settings)
+}
+
+// Three-way merge of file sets (path -> entry, as flattenTree builds them) - renamed files are merged first,
+// at their new paths, then every other path
+func mergeFileSets(base, ours, theirs map[string]TreeEntry, settings MergeSettings) (*MergeResult, error) {
+	oursRenames, err := detectMergeRenames(base, ours, settings)
+	if err != nil {
+		return nil, err
+	}
+	theirsRenames, err := detectMergeRenames(base, theirs, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MergeResult{Files: make(map[string]TreeEntry)}
+	// Paths of every side already merged as part of a rename
+	baseDone, oursDone, theirsDone := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	// Paths the result got from a rename - files added there by the other side collide with it
+	renamedTo := make(map[string]bool)
+
+	for _, oldPath := range sortedKeys(base) {
+		oursPath, oursRenamed := oursRenames[oldPath]
+		theirsPath, theirsRenamed := theirsRenames[oldPath]
+		if !oursRenamed && !theirsRenamed {
+			continue
+		}
+		baseEntry := base[oldPath]
+		baseDone[oldPath] = true
+
+		switch {
+		case oursRenamed && theirsRenamed && oursPath == theirsPath:
+			oursEntry, theirsEntry := ours[oursPath], theirs[theirsPath]
+			oursDone[oursPath], theirsDone[theirsPath], renamedTo[oursPath] = true, true, true
+			if err := mergeEntry(result, oursPath, &baseEntry, &oursEntry, &theirsEntry, settings); err != nil {
+				return nil, err
+			}
+		case oursRenamed && theirsRenamed:
+			// Renamed differently on each side - both names are kept, the user picks one
+			oursEntry, theirsEntry := ours[oursPath], theirs[theirsPath]
+			oursDone[oursPath], theirsDone[theirsPath] = true, true
+			result.Files[oursPath], result.Files[theirsPath] = oursEntry, theirsEntry
+			result.Conflicts = append(result.Conflicts,
+				MergeConflict{Path: oursPath, Kind: "rename/rename", Base: &baseEntry, Ours: &oursEntry},
+				MergeConflict{Path: theirsPath, Kind: "rename/rename", Base: &baseEntry, Theirs: &theirsEntry})
+			result.message(oursPath, "CONFLICT (rename/rename): %s renamed to %s in %s and to %s in %s.",
+				oldPath, oursPath, settings.OursLabel, theirsPath, settings.TheirsLabel)
+		default:
+			// Renamed on one side - the other side's version at the old path is merged into the new path
+			newPath, renamedEntry, renamedLabel, otherLabel := oursPath, ours[oursPath], settings.OursLabel, settings.TheirsLabel
+			renamedDone, other, otherDone := oursDone, theirs, theirsDone
+			if theirsRenamed {
+				newPath, renamedEntry, renamedLabel, otherLabel = theirsPath, theirs[theirsPath], settings.TheirsLabel, settings.OursLabel
+				renamedDone, other, otherDone = theirsDone, ours, oursDone
+			}
+			renamedDone[newPath], renamedTo[newPath] = true, true
+			otherEntry, ok := other[oldPath]
+			if !ok {
+				result.Files[newPath] = renamedEntry
+				conflict := MergeConflict{Path: newPath, Kind: "rename/delete", Base: &baseEntry, Ours: &renamedEntry}
+				if theirsRenamed {
+					conflict.Ours, conflict.Theirs = nil, &renamedEntry
+				}
+				result.Conflicts = append(result.Conflicts, conflict)
+				result.message(newPath, "CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.",
+					oldPath, newPath, renamedLabel, otherLabel)
+				continue
+			}
+			otherDone[oldPath] = true
+			oursEntry, theirsEntry := &renamedEntry, &otherEntry
+			if theirsRenamed {
+				oursEntry, theirsEntry = theirsEntry, oursEntry
+			}
+			if err := mergeEntry(result, newPath, &baseEntry, oursEntry, theirsEntry, settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, files := range []map[string]TreeEntry{base, ours, theirs} {
+		for filePath := range files {
+			paths[filePath] = true
+		}
+	}
+	for _, filePath := range sortedKeys(paths) {
+		baseEntry, oursEntry, theirsEntry := sideEntry(base, baseDone, filePath), sideEntry(ours, oursDone, filePath), sideEntry(theirs, theirsDone, filePath)
+		if baseEntry == nil && oursEntry == nil && theirsEntry == nil {
+			continue
+		}
+		if renamedTo[filePath] {
+			// File added by the other side where the renamed file went
+			if err := mergeRenameAdd(result, filePath, oursEntry, theirsEntry, settings); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := mergeEntry(result, filePath, baseEntry, oursEntry, theirsEntry, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(result.Messages, func(i, j int) bool {
+		return result.Messages[i].Path < result.Messages[j].Path
+	})
+	return result, nil
+}
+
+// Entry of side at path - nil when the side doesn't have it or it was already merged as part of a rename
+func sideEntry(files map[string]TreeEntry, done map[string]bool, filePath string) *TreeEntry {
+	entry, ok := files[filePath]
+	if !ok || done[filePath] {
+		return nil
+	}
+	return &entry
+}
+
+// Renames from base to side - old path -> new path (nil map when rename detection is off)
+func detectMergeRenames(base, side map[string]TreeEntry, settings MergeSettings) (map[string]string, error) {
+	if !settings.Renames {
+		return nil, nil
+	}
+	changes, err := diffFileSets(base, side, DiffOptions{Renames: DiffRenamesOn, MinScore: settings.RenameScore})
+	if err != nil {
+		return nil, err
+	}
+	renames := make(map[string]string)
+	for _, change := range changes {
+		if change.Status == 'R' {
+			renames[change.OldPath] = change.NewPath
+		}
+	}
+	return renames, nil
+}
+
+// Merge one path - a side that didn't change it takes the other side's version, changes on both sides are
+// merged by content (nil entry - the side doesn't have the file)
+func mergeEntry(result *MergeResult, filePath string, base, ours, theirs *TreeEntry, settings MergeSettings) error {
+	switch {
+	case sameEntryPointer(ours, theirs), sameEntryPointer(base, theirs):
+		result.keep(filePath, ours)
+	case sameEntryPointer(base, ours):
+		result.keep(filePath, theirs)
+	case ours == nil || theirs == nil:
+		// Deleted on one side, changed on the other - the changed version is left in the tree
+		kept, deletedIn, modifiedIn := ours, settings.TheirsLabel, settings.OursLabel
+		if ours == nil {
+			kept, deletedIn, modifiedIn = theirs, settings.OursLabel, settings.TheirsLabel
+		}
+		result.keep(filePath, kept)
+		result.Conflicts = append(result.Conflicts, MergeConflict{Path: filePath, Kind: "modify/delete", Base: base, Ours: ours, Theirs: theirs})
+		result.message(filePath, "CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.",
+			filePath, deletedIn, modifiedIn, modifiedIn, filePath)
+	default:
+		return mergeContent(result, filePath, base, ours, theirs, settings)
+	}
+	return nil
+}
+
+// Merge file changed on both sides (base is nil when both added it) - modes and content are merged separately
+func mergeContent(result *MergeResult, filePath string, base, ours, theirs *TreeEntry, settings MergeSettings) error {
+	kind := "content"
+	if base == nil {
+		kind = "add/add"
+	}
+	conflict := MergeConflict{Path: filePath, Kind: kind, Base: base, Ours: ours, Theirs: theirs}
+
+	mode, modeConflict := ours.Mode, false
+	switch {
+	case ours.Mode == theirs.Mode:
+	case base != nil && ours.Mode == base.Mode:
+		mode = theirs.Mode
+	case base != nil && theirs.Mode == base.Mode:
+	default:
+		modeConflict = true
+	}
+
+	hash := ours.Hash
+	contentConflict := false
+	switch {
+	case ours.Hash == theirs.Hash:
+	case base != nil && base.Hash == ours.Hash:
+		hash = theirs.Hash
+	case base != nil && base.Hash == theirs.Hash:
+	case !isRegularFileMode(ours.Mode) || !isRegularFileMode(theirs.Mode):
+		// Symlinks and submodules can't be merged line by line
+		hash, contentConflict = favoredHash(ours, theirs, settings)
+	default:
+		var baseContent []byte
+		if base != nil && isRegularFileMode(base.Mode) {
+			var err error
+			if baseContent, err = readDiffBlob(base.Hash); err != nil {
+				return err
+			}
+		}
+		oursContent, err := readDiffBlob(ours.Hash)
+		if err != nil {
+			return err
+		}
+		theirsContent, err := readDiffBlob(theirs.Hash)
+		if err != nil {
+			return err
+		}
+
+		if isBinaryContent(baseContent) || isBinaryContent(oursContent) || isBinaryContent(theirsContent) {
+			hash, contentConflict = favoredHash(ours, theirs, settings)
+			if contentConflict {
+				result.message(filePath, "warning: Cannot merge binary files: %s (%s vs. %s)", filePath, settings.OursLabel, settings.TheirsLabel)
+			}
+			result.message(filePath, "Auto-merging %s", filePath)
+			break
+		}
+		result.message(filePath, "Auto-merging %s", filePath)
+		merged, conflicts := mergeText(baseContent, oursContent, theirsContent, settings)
+		hashBytes, err := writeObject(generateObjectByte("blob", merged))
+		if err != nil {
+			return fmt.Errorf("failed to write merged %s: %w", filePath, err)
+		}
+		hash, contentConflict = fmt.Sprintf("%x", hashBytes), conflicts > 0
+	}
+
+	result.Files[filePath] = TreeEntry{Mode: mode, Name: filePath, Hash: hash}
+	if contentConflict || modeConflict {
+		result.Conflicts = append(result.Conflicts, conflict)
+		result.message(filePath, "CONFLICT (%s): Merge conflict in %s", kind, filePath)
+	}
+	return nil
+}
+
+// File added by one side where the other side's renamed file went - both versions are merged as if both
+// sides added the file
+func mergeRenameAdd(result *MergeResult, filePath string, ours, theirs *TreeEntry, settings MergeSettings) error {
+	placed := result.Files[filePath]
+	if ours == nil {
+		ours = &placed
+	} else {
+		theirs = &placed
+	}
+	if sameEntryPointer(ours, theirs) {
+		return nil
+	}
+	if err := mergeContent(result, filePath, nil, ours, theirs, settings); err != nil {
+		return err
+	}
+	if n := len(result.Conflicts); n > 0 && result.Conflicts[n-1].Path == filePath {
+		result.Conflicts[n-1].Kind = "rename/add"
+	}
+	return nil
+}
+
+// Hash of the side -X favors - conflict when no side is favored (ours is kept then)
+func favoredHash(ours, theirs *TreeEntry, settings MergeSettings) (string, bool) {
+	switch settings.Favor {
+	case "ours":
+		return ours.Hash, false
+	case "theirs":
+		return theirs.Hash, false
+	}
+	return ours.Hash, true
+}
+
+// Both missing, or the same blob with the same mode
+func sameEntryPointer(a, b *TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return sameTreeEntry(*a, *b)
+}
+
+// Put entry into the result (nil - path is deleted)
+func (result *MergeResult) keep(filePath string, entry *TreeEntry) {
+	if entry != nil {
+		result.Files[filePath] = *entry
+	}
+}
+
+func (result *MergeResult) message(filePath, format string, args ...any) {
+	result.Messages = append(result.Messages, MergeMessage{Path: filePath, Text: fmt.Sprintf(format, args...)})
+}
+
+// Conflicts further apart than this (in lines) stay separate with the merge conflict style
+const mergeConflictGap = 3
+
+// Merge three versions of text line by line (diff3) - returns merged content and number of conflict hunks
+//
+// Base lines unchanged on both sides are stable - they are copied and keep the three versions aligned.
+// Between them, a hunk only one side changed takes that side's lines, a hunk both sides changed the same way
+// is taken once, anything else is a conflict. Like in git, conflicts are then made as small as the style
+// allows: merge style splits them at lines both sides have and joins the ones that end up close to each
+// other again, zdiff3 only moves out lines both sides have at the edges, diff3 keeps them as they are.
+func mergeText(base, ours, theirs []byte, settings MergeSettings) ([]byte, int) {
+	hunks := mergeHunks(splitLines(base), splitLines(ours), splitLines(theirs), settings.Favor)
+	switch settings.ConflictStyle {
+	case "merge":
+		hunks = joinConflicts(refineConflicts(hunks))
+	case "zdiff3":
+		hunks = trimConflicts(hunks)
+	}
+
+	var merged bytes.Buffer
+	conflicts := 0
+	for _, hunk := range hunks {
+		if !hunk.conflict {
+			writeLines(&merged, hunk.ours)
+			continue
+		}
+		conflicts++
+		fmt.Fprintf(&merged, "<<<<<<< %s\n", settings.OursLabel)
+		writeConflictSide(&merged, hunk.ours)
+		if settings.ConflictStyle != "merge" {
+			fmt.Fprintf(&merged, "||||||| %s\n", settings.BaseLabel)
+			writeConflictSide(&merged, hunk.base)
+		}
+		merged.WriteString("=======\n")
+		writeConflictSide(&merged, hunk.theirs)
+		fmt.Fprintf(&merged, ">>>>>>> %s\n", settings.TheirsLabel)
+	}
+	return merged.Bytes(), conflicts
+}
+
+// Split three versions into hunks - stable lines and hunks changed on at least one side, resolved where one
+// side didn't change them, both changed them the same way or favor names the side to take
+func mergeHunks(base, ours, theirs []string, favor string) []mergeHunk {
+	oursMatch := matchingLines(base, ours)
+	theirsMatch := matchingLines(base, theirs)
+
+	var hunks []mergeHunk
+	i, j, k := 0, 0, 0
+	for {
+		next := i
+		for next < len(base) && (oursMatch[next] == -1 || theirsMatch[next] == -1) {
+			next++
+		}
+		oursEnd, theirsEnd := len(ours), len(theirs)
+		if next < len(base) {
+			oursEnd, theirsEnd = oursMatch[next], theirsMatch[next]
+		}
+
+		if next == i && oursEnd == j && theirsEnd == k {
+			if i == len(base) {
+				break
+			}
+			hunks = appendStable(hunks, base[i:i+1])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		hunk := mergeHunk{base: base[i:next], ours: ours[j:oursEnd], theirs: theirs[k:theirsEnd]}
+		switch {
+		case slices.Equal(hunk.ours, hunk.base), favor == "theirs":
+			hunk.ours = hunk.theirs
+		case slices.Equal(hunk.theirs, hunk.base), slices.Equal(hunk.ours, hunk.theirs), favor == "ours":
+		default:
+			hunk.conflict = true
+		}
+		hunks = append(hunks, hunk)
+		i, j, k = next, oursEnd, theirsEnd
+	}
+	return hunks
+}
+
+// Add stable lines - to the last hunk when it is stable too
+func appendStable(hunks []mergeHunk, lines []string) []mergeHunk {
+	if len(lines) == 0 {
+		return hunks
+	}
+	if n := len(hunks); n > 0 && hunks[n-1].stable {
+		hunks[n-1].ours = append(slices.Clip(hunks[n-1].ours), lines...)
+		return hunks
+	}
+	return append(hunks, mergeHunk{stable: true, ours: lines})
+}
+
+// Split conflicts at lines both sides have (by diff of the two sides) - conflicts where a side is empty stay
+func refineConflicts(hunks []mergeHunk) []mergeHunk {
+	var refined []mergeHunk
+	for _, hunk := range hunks {
+		if !hunk.conflict || len(hunk.ours) == 0 || len(hunk.theirs) == 0 {
+			if hunk.stable {
+				refined = appendStable(refined, hunk.ours)
+			} else {
+				refined = append(refined, hunk)
+			}
+			continue
+		}
+
+		// Base of split conflicts isn't known - it's only shown with diff3 styles, which don't split
+		j, k := 0, 0
+		diff := diffLines(hunk.ours, hunk.theirs)
+		for x := 0; x < len(diff); {
+			if diff[x].Kind == ' ' {
+				refined = appendStable(refined, hunk.ours[j:j+1])
+				j, k, x = j+1, k+1, x+1
+				continue
+			}
+			oursEnd, theirsEnd := j, k
+			for ; x < len(diff) && diff[x].Kind != ' '; x++ {
+				if diff[x].Kind == '-' {
+					oursEnd++
+				} else {
+					theirsEnd++
+				}
+			}
+			refined = append(refined, mergeHunk{conflict: true, ours: hunk.ours[j:oursEnd], theirs: hunk.theirs[k:theirsEnd]})
+			j, k = oursEnd, theirsEnd
+		}
+	}
+	return refined
+}
+
+// Join conflicts separated only by a few stable lines - one conflict is easier to resolve than several
+func joinConflicts(hunks []mergeHunk) []mergeHunk {
+	var joined []mergeHunk
+	for i := 0; i < len(hunks); i++ {
+		n := len(joined)
+		if hunks[i].conflict && n >= 2 && joined[n-2].conflict && joined[n-1].stable && len(joined[n-1].ours) <= mergeConflictGap {
+			first, gap, second := joined[n-2], joined[n-1].ours, hunks[i]
+			joined = append(joined[:n-2], mergeHunk{
+				conflict: true,
+				base:     slices.Concat(first.base, gap, second.base),
+				ours:     slices.Concat(first.ours, gap, second.ours),
+				theirs:   slices.Concat(first.theirs, gap, second.theirs),
+			})
+			continue
+		}
+		joined = append(joined, hunks[i])
+	}
+	return joined
+}
+
+// Move lines both sides have at the start and end of conflicts out of them (base stays whole)
+func trimConflicts(hunks []mergeHunk) []mergeHunk {
+	var trimmed []mergeHunk
+	for _, hunk := range hunks {
+		if !hunk.conflict {
+			if hunk.stable {
+				trimmed = appendStable(trimmed, hunk.ours)
+			} else {
+				trimmed = append(trimmed, hunk)
+			}
+			continue
+		}
+		ours, theirs := hunk.ours, hunk.theirs
+		prefix, suffix := 0, 0
+		for prefix < len(ours) && prefix < len(theirs) && ours[prefix] == theirs[prefix] {
+			prefix++
+		}
+		for suffix < len(ours)-prefix && suffix < len(theirs)-prefix && ours[len(ours)-1-suffix] == theirs[len(theirs)-1-suffix] {
+			suffix++
+		}
+		trimmed = appendStable(trimmed, ours[:prefix])
+		trimmed = append(trimmed, mergeHunk{conflict: true, base: hunk.base, ours: ours[prefix : len(ours)-suffix], theirs: theirs[prefix : len(theirs)-suffix]})
+		trimmed = appendStable(trimmed, ours[len(ours)-suffix:])
+	}
+	return trimmed
+}
+
+// For every line of a, index of the same line in b according to their diff (-1 when it was removed)
+func matchingLines(a, b []string) []int {
+	matches := make([]int, len(a))
+	x, y := 0, 0
+	for _, line := range diffLines(a, b) {
+		switch line.Kind {
+		case ' ':
+			matches[x] = y
+			x, y = x+1, y+1
+		case '-':
+			matches[x] = -1
+			x++
+		case '+':
+			y++
+		}
+	}
+	return matches
+}
+
+func writeLines(w *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+}
+
+// Lines of one side of a conflict - the last one gets a newline, so the marker after it starts a new line
+func writeConflictSide(w *bytes.Buffer, lines []string) {
+	writeLines(w, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		w.WriteString("\n")
+	}
+}
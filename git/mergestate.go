@@ -0,0 +1,204 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Merge state - a merge stopped by conflicts is concluded by a later commit (or dropped with merge --abort):
+//
+//	.git/MERGE_HEAD   merged commits, one per line - the commit concluding the merge has them as parents
+//	.git/MERGE_MSG    message for that commit, with the conflicted paths listed in comments
+//	.git/MERGE_MODE   "no-ff" when a merge commit was asked for
+//	.git/ORIG_HEAD    HEAD before the merge
+//
+// Conflicted paths are in the index as up to three entries - stage 1 (base), 2 (ours) and 3 (theirs), one
+// for every side that has the file. Nothing can be committed while they are there; adding the path (its
+// resolved work tree file) replaces them with an ordinary stage 0 entry.
+
+// Status of a conflicted path by the stages it has - labels of git status ("UU" and so on in short formats)
+var unmergedLabels = map[string]string{
+	"123": "both modified",
+	"23":  "both added",
+	"12":  "deleted by them",
+	"13":  "deleted by us",
+	"2":   "added by us",
+	"3":   "added by them",
+	"1":   "both deleted",
+}
+
+// Short status codes of unmerged labels
+var unmergedCodes = map[string]string{
+	"both modified":   "UU",
+	"both added":      "AA",
+	"deleted by them": "UD",
+	"deleted by us":   "DU",
+	"added by us":     "AU",
+	"added by them":   "UA",
+	"both deleted":    "DD",
+}
+
+// Replace index entries of conflicted paths with their stage 1-3 entries
+func writeConflictStages(conflicts []MergeConflict) error {
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	conflicted := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		conflicted[conflict.Path] = true
+	}
+
+	kept := make([]IndexEntry, 0, len(entries)+2*len(conflicts))
+	for _, entry := range entries {
+		if !conflicted[entry.Path] {
+			kept = append(kept, entry)
+		}
+	}
+	for _, conflict := range conflicts {
+		for stage, side := range []*TreeEntry{conflict.Base, conflict.Ours, conflict.Theirs} {
+			if side == nil {
+				continue
+			}
+			entry, err := indexEntryFromTree(conflict.Path, *side)
+			if err != nil {
+				return err
+			}
+			entry.Stage = uint8(stage + 1)
+			kept = append(kept, entry)
+		}
+	}
+	return writeGitIndex(kept)
+}
+
+// Stage 1-3 entries of conflicted paths
+func unmergedPaths(entries []IndexEntry) map[string][]IndexEntry {
+	unmerged := make(map[string][]IndexEntry)
+	for _, entry := range entries {
+		if entry.Stage != 0 {
+			unmerged[entry.Path] = append(unmerged[entry.Path], entry)
+		}
+	}
+	return unmerged
+}
+
+// Status label of a conflicted path by the stages it has ("both modified", ...)
+func unmergedLabel(stages []IndexEntry) string {
+	present := ""
+	for _, entry := range stages {
+		present += fmt.Sprint(entry.Stage)
+	}
+	return unmergedLabels[present]
+}
+
+// Error when the index has conflicted paths - what fails is named by action ("Committing", "Merging", ...)
+func requireMergedIndex(action string) error {
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if unmerged := unmergedPaths(entries); len(unmerged) > 0 {
+		return fmt.Errorf("%s is not possible because you have unmerged files:\n%s\nFix them up in the work tree, and then use 'git add <file>' as appropriate to mark resolution",
+			action, strings.Join(sortedKeys(unmerged), "\n"))
+	}
+	return nil
+}
+
+// Remember a merge stopped by conflicts - heads are the merged commits, message the merge commit message
+func writeMergeState(head string, heads []string, message string, conflicts []MergeConflict, noFastForward bool) error {
+	var paths []string
+	for _, conflict := range conflicts {
+		if !slices.Contains(paths, conflict.Path) {
+			paths = append(paths, conflict.Path)
+		}
+	}
+	slices.Sort(paths)
+	message += "\n# Conflicts:\n"
+	for _, filePath := range paths {
+		message += "#\t" + filePath + "\n"
+	}
+	mode := ""
+	if noFastForward {
+		mode = "no-ff"
+	}
+
+	files := map[string]string{
+		"MERGE_HEAD": strings.Join(heads, "\n") + "\n",
+		"MERGE_MSG":  message,
+		"MERGE_MODE": mode,
+		"ORIG_HEAD":  head + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(gitDirPath(name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Commits of MERGE_HEAD - nil when no merge is in progress
+func readMergeHeads() ([]string, error) {
+	data, err := os.ReadFile(gitDirPath("MERGE_HEAD"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read MERGE_HEAD: %w", err)
+	}
+	var heads []string
+	for _, line := range strings.Fields(string(data)) {
+		if _, err := hex.DecodeString(line); err != nil || len(line) != 40 {
+			return nil, fmt.Errorf("corrupt MERGE_HEAD: %s", line)
+		}
+		heads = append(heads, line)
+	}
+	return heads, nil
+}
+
+// Forget merge in progress (squash merge included) - its commit was made or the merge was aborted
+func clearMergeState() error {
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "SQUASH_MSG"} {
+		if err := os.Remove(gitDirPath(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Drop merge in progress - index and work tree go back to HEAD
+func mergeAbort() error {
+	heads, err := readMergeHeads()
+	if err != nil {
+		return err
+	}
+	if heads == nil {
+		return fmt.Errorf("There is no merge to abort (MERGE_HEAD missing).")
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if err := resetWorkTreeToCommit(head); err != nil {
+		return err
+	}
+	if err := rerereClear(); err != nil {
+		return err
+	}
+	return clearMergeState()
+}
+
+// Conclude merge in progress with a commit of the resolved index - message from MERGE_MSG
+func mergeContinue(w io.Writer) error {
+	heads, err := readMergeHeads()
+	if err != nil {
+		return err
+	}
+	if heads == nil {
+		return fmt.Errorf("There is no merge in progress (MERGE_HEAD missing).")
+	}
+	_, err = commitChanges(CommitOptions{NoEdit: true}, w)
+	return err
+}
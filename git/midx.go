@@ -0,0 +1,271 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Multi-pack-index - objects of every pack in one sorted table (.git/objects/pack/multi-pack-index), so a
+// lookup is a single binary search instead of one per pack:
+//
+//	"MIDX" + version (1) + hash version (1 = SHA-1) + chunk count + base MIDX count (0) + pack count (4 bytes)
+//	chunk table: (chunk count + 1) x (4 byte id + 8 byte offset), last entry has id 0 and points to the end
+//	PNAM - idx file names of packs, NUL terminated, sorted, padded to 4 bytes
+//	OIDF - fanout table, 256 x 4 bytes
+//	OIDL - sorted object hashes
+//	OOFF - pack int id (position in PNAM) + offset for every object, MSB of offset -> index into LOFF
+//	LOFF - 8 byte offsets (only if a pack is bigger than 2GB)
+//	checksum of everything above
+//
+// When object is in several packs, the copy from the newest pack is used.
+
+const multiPackIndexName = "multi-pack-index"
+
+// Loaded multi-pack-index by pack directory - nil when there is none
+var multiPackIndexCache = make(map[string]*MultiPackIndex)
+
+// Write multi-pack-index for every pack in the pack directory - returns number of packs and objects
+func writeMultiPackIndex() (int, int, error) {
+	packDir := objectDirPath("pack")
+	idxPaths, err := filepath.Glob(filepath.Join(packDir, "*.idx"))
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.Strings(idxPaths)
+
+	var packNames []string
+	latest := make(map[string]MultiPackObject)
+	for _, idxPath := range idxPaths {
+		packInfo, err := os.Stat(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+		if err != nil {
+			continue
+		}
+		index, err := parsePackIndex(idxPath)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to read %s: %w", idxPath, err)
+		}
+
+		packID := uint32(len(packNames))
+		packNames = append(packNames, filepath.Base(idxPath))
+		for i := 0; i < index.count(); i++ {
+			hash := index.Hashes[i*20 : i*20+20]
+			object := MultiPackObject{hash, packID, index.Offsets[i], packInfo.ModTime().UnixNano()}
+			if existing, ok := latest[string(hash)]; !ok || object.ModTime > existing.ModTime {
+				latest[string(hash)] = object
+			}
+		}
+	}
+	if len(packNames) == 0 {
+		return 0, 0, fmt.Errorf("no packs to index")
+	}
+
+	hashes := make([]string, 0, len(latest))
+	for hash := range latest {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	// Chunk contents
+	var names bytes.Buffer
+	for _, name := range packNames {
+		names.WriteString(name + "\x00")
+	}
+	for names.Len()%4 != 0 {
+		names.WriteByte(0)
+	}
+
+	var fanout [256 * 4]byte
+	var oids, offsets, largeOffsets bytes.Buffer
+	for i, hash := range hashes {
+		object := latest[hash]
+		oids.Write(object.Hash)
+		binary.BigEndian.PutUint32(fanout[int(hash[0])*4:], uint32(i+1))
+
+		offset := uint32(object.Offset)
+		if object.Offset >= 0x80000000 {
+			offset = 0x80000000 | uint32(largeOffsets.Len()/8)
+			binary.Write(&largeOffsets, binary.BigEndian, object.Offset)
+		}
+		binary.Write(&offsets, binary.BigEndian, object.PackID)
+		binary.Write(&offsets, binary.BigEndian, offset)
+	}
+	// Buckets without objects carry the count of the previous one
+	for i := 1; i < 256; i++ {
+		if binary.BigEndian.Uint32(fanout[i*4:]) == 0 {
+			copy(fanout[i*4:i*4+4], fanout[(i-1)*4:i*4])
+		}
+	}
+
+	chunks := []MultiPackChunk{
+		{"PNAM", names.Bytes()},
+		{"OIDF", fanout[:]},
+		{"OIDL", oids.Bytes()},
+		{"OOFF", offsets.Bytes()},
+	}
+	if largeOffsets.Len() > 0 {
+		chunks = append(chunks, MultiPackChunk{"LOFF", largeOffsets.Bytes()})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("MIDX")
+	buf.Write([]byte{1, 1, byte(len(chunks)), 0})
+	binary.Write(&buf, binary.BigEndian, uint32(len(packNames)))
+
+	offset := uint64(buf.Len() + (len(chunks)+1)*12)
+	for _, chunk := range chunks {
+		buf.WriteString(chunk.ID)
+		binary.Write(&buf, binary.BigEndian, offset)
+		offset += uint64(len(chunk.Data))
+	}
+	buf.Write([]byte{0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, offset)
+	for _, chunk := range chunks {
+		buf.Write(chunk.Data)
+	}
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	midxPath := filepath.Join(packDir, multiPackIndexName)
+	tmpPath := midxPath + ".lock"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0444); err != nil {
+		return 0, 0, fmt.Errorf("failed to write multi-pack-index: %w", err)
+	}
+	if err := os.Rename(tmpPath, midxPath); err != nil {
+		return 0, 0, fmt.Errorf("failed to write multi-pack-index: %w", err)
+	}
+	delete(multiPackIndexCache, packDir)
+	return len(packNames), len(hashes), nil
+}
+
+// Load multi-pack-index of the pack directory - nil if there is none, or if a pack it lists is gone
+// (stale index is ignored, packs are then searched one by one)
+func loadMultiPackIndex(packDir string) (*MultiPackIndex, error) {
+	if midx, ok := multiPackIndexCache[packDir]; ok {
+		return midx, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(packDir, multiPackIndexName))
+	if os.IsNotExist(err) {
+		multiPackIndexCache[packDir] = nil
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	midx, err := parseMultiPackIndex(data, packDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read multi-pack-index: %w", err)
+	}
+	for _, packPath := range midx.PackPaths {
+		if _, err := os.Stat(packPath); err != nil {
+			midx = nil
+			break
+		}
+	}
+	multiPackIndexCache[packDir] = midx
+	return midx, nil
+}
+
+// Parse multi-pack-index file - pack paths are resolved against the pack directory
+func parseMultiPackIndex(data []byte, packDir string) (*MultiPackIndex, error) {
+	if len(data) < 12+20 || string(data[:4]) != "MIDX" {
+		return nil, fmt.Errorf("bad signature")
+	}
+	if data[4] != 1 || data[5] != 1 {
+		return nil, fmt.Errorf("unsupported version %d (hash version %d)", data[4], data[5])
+	}
+	chunkCount := int(data[6])
+	packCount := int(binary.BigEndian.Uint32(data[8:12]))
+
+	chunks := make(map[string][]byte)
+	tableEnd := 12 + (chunkCount+1)*12
+	if len(data) < tableEnd {
+		return nil, fmt.Errorf("chunk table is truncated")
+	}
+	for i := 0; i < chunkCount; i++ {
+		entry := data[12+i*12:]
+		start := binary.BigEndian.Uint64(entry[4:12])
+		end := binary.BigEndian.Uint64(entry[16:24])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("bad offset of chunk %s", entry[:4])
+		}
+		chunks[string(entry[:4])] = data[start:end]
+	}
+
+	midx := &MultiPackIndex{Hashes: chunks["OIDL"], Offsets: chunks["OOFF"], LargeOffsets: chunks["LOFF"]}
+	fanout := chunks["OIDF"]
+	if len(fanout) != 256*4 || chunks["PNAM"] == nil {
+		return nil, fmt.Errorf("required chunk is missing")
+	}
+	for i := 0; i < 256; i++ {
+		midx.Fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+	}
+	count := int(midx.Fanout[255])
+	if len(midx.Hashes) < count*20 || len(midx.Offsets) < count*8 {
+		return nil, fmt.Errorf("object tables are truncated")
+	}
+
+	for _, name := range strings.Split(string(chunks["PNAM"]), "\x00") {
+		if name != "" {
+			midx.PackPaths = append(midx.PackPaths, filepath.Join(packDir, strings.TrimSuffix(name, ".idx")+".pack"))
+		}
+	}
+	if len(midx.PackPaths) != packCount {
+		return nil, fmt.Errorf("expected %d packs, found %d names", packCount, len(midx.PackPaths))
+	}
+	return midx, nil
+}
+
+// Find pack and offset of object - binary search between fanout[first_byte-1] and fanout[first_byte]
+func (midx *MultiPackIndex) find(hash []byte) (string, uint64, bool) {
+	low := 0
+	if hash[0] > 0 {
+		low = int(midx.Fanout[hash[0]-1])
+	}
+	high := int(midx.Fanout[hash[0]])
+
+	for low < high {
+		mid := (low + high) / 2
+		cmp := bytes.Compare(midx.Hashes[mid*20:mid*20+20], hash)
+		if cmp < 0 {
+			low = mid + 1
+			continue
+		} else if cmp > 0 {
+			high = mid
+			continue
+		}
+
+		packID := binary.BigEndian.Uint32(midx.Offsets[mid*8:])
+		offset := uint64(binary.BigEndian.Uint32(midx.Offsets[mid*8+4:]))
+		if offset&0x80000000 != 0 {
+			large := int(offset&0x7fffffff) * 8
+			if len(midx.LargeOffsets) < large+8 {
+				return "", 0, false
+			}
+			offset = binary.BigEndian.Uint64(midx.LargeOffsets[large:])
+		}
+		if int(packID) >= len(midx.PackPaths) {
+			return "", 0, false
+		}
+		return midx.PackPaths[packID], offset, true
+	}
+	return "", 0, false
+}
+
+// Check whether pack is covered by one of multi-pack-indexes
+func multiPackIndexCovers(midxs []*MultiPackIndex, packPath string) bool {
+	for _, midx := range midxs {
+		for _, path := range midx.PackPaths {
+			if path == packPath {
+				return true
+			}
+		}
+	}
+	return false
+}
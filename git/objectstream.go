@@ -0,0 +1,210 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Streaming object I/O - files at or above core.bigFileThreshold are hashed, compressed and checked out
+// through io.Reader/io.Writer, so they never have to fit in memory. Such files are stored as they are
+// (no line ending conversion), like git treats big files as binary.
+//
+// Packed objects can be deltas, so they are still read into memory and only wrapped as a stream.
+
+const defaultBigFileThreshold = 512 * 1024 * 1024
+
+// core.bigFileThreshold - size from which blobs are streamed
+func bigFileThreshold() int64 {
+	config, err := loadConfig()
+	if err != nil {
+		return defaultBigFileThreshold
+	}
+	return config.GetInt("core.bigFileThreshold", defaultBigFileThreshold)
+}
+
+// Check whether regular file is big enough to be streamed (symlinks never are)
+func isBigFile(fullPath string, threshold int64) bool {
+	info, err := os.Lstat(fullPath)
+	return err == nil && info.Mode().IsRegular() && info.Size() >= threshold
+}
+
+// Open object for reading - header is parsed up front, so type and size are known before content is read.
+// Replaced objects (see replace.go) give their replacement.
+func openObjectStream(objectHash string) (*ObjectStream, error) {
+	objectHash, err := lookupReplaceObject(objectHash)
+	if err != nil {
+		return nil, err
+	}
+	objectPath, loose := looseObjectPath(objectHash)
+	if !loose {
+		objType, _, content, err := readStoredObject(objectHash)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectStream{Type: objType, Size: int64(len(content)), reader: bytes.NewReader(content)}, nil
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	inflater, err := zlib.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: failed to decompress %s: %v", ErrCorruptObject, objectHash, err)
+	}
+	reader := bufio.NewReader(inflater)
+	header, err := reader.ReadString(0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s has bad header", ErrCorruptObject, objectHash)
+	}
+	objType, sizeText, _ := strings.Cut(strings.TrimSuffix(header, "\x00"), " ")
+	size, err := strconv.ParseInt(sizeText, 10, 64)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s has bad header", ErrCorruptObject, objectHash)
+	}
+
+	stream := &ObjectStream{Type: objType, Size: size, reader: io.LimitReader(reader, size), closer: file}
+	if os.Getenv("GIT_VERIFY_OBJECTS") != "0" {
+		stream.hasher = sha1.New()
+		stream.hasher.Write([]byte(header))
+		stream.expected = objectHash
+	}
+	return stream, nil
+}
+
+func (stream *ObjectStream) Read(p []byte) (int, error) {
+	n, err := stream.reader.Read(p)
+	if stream.hasher == nil {
+		return n, err
+	}
+	stream.hasher.Write(p[:n])
+	if err == io.EOF {
+		if actual := fmt.Sprintf("%x", stream.hasher.Sum(nil)); actual != stream.expected {
+			return n, &ObjectCorruptError{Hash: stream.expected, Actual: actual}
+		}
+	}
+	return n, err
+}
+
+func (stream *ObjectStream) Close() error {
+	if stream.closer == nil {
+		return nil
+	}
+	return stream.closer.Close()
+}
+
+// Hash file as blob without reading it into memory
+func hashBlobFromFile(fullPath string) ([]byte, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha1.New()
+	fmt.Fprintf(hasher, "blob %d\x00", info.Size())
+	if _, err := io.CopyN(hasher, file, info.Size()); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", fullPath, err)
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Write file as blob object - content goes through SHA-1 and zlib into a temporary file in the object
+// directory, which is renamed to the object name at the end
+func writeBlobFromFile(fullPath string) ([]byte, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(objectDirPath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmpFile, err := os.CreateTemp(objectDirPath(), "tmp_obj_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hasher := sha1.New()
+	deflater := zlib.NewWriter(tmpFile)
+	writer := io.MultiWriter(hasher, deflater)
+	fmt.Fprintf(writer, "blob %d\x00", info.Size())
+	if _, err := io.CopyN(writer, file, info.Size()); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", fullPath, err)
+	}
+	if err := deflater.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress object: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	hash := hasher.Sum(nil)
+	hashString := fmt.Sprintf("%x", hash)
+	objectPath := objectDirPath(hashString[:2], hashString[2:])
+	if _, err := os.Stat(objectPath); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), objectPath); err != nil {
+		return nil, fmt.Errorf("failed to write object file: %w", err)
+	}
+	return hash, nil
+}
+
+// Check out blob stream into work tree file (regular files only)
+func writeWorkTreeFileFromStream(relPath, mode string, stream *ObjectStream) error {
+	if err := verifyPath(relPath); err != nil {
+		return err
+	}
+	if err := createWorkTreeDir(path.Dir(relPath)); err != nil {
+		return err
+	}
+	fullPath := workTreePath(filepath.FromSlash(relPath))
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if mode == "100755" {
+		perm = 0755
+	}
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, stream); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", relPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(fullPath, perm)
+}
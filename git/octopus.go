@@ -0,0 +1,121 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Octopus merge - several commits merged into HEAD at once, the merge commit has all of them as parents
+// (git merge <commit> <commit>...)
+//
+// The commits are merged one by one into the tree built so far, each over its merge base with the commits
+// merged before it. While nothing but fast-forward happened, the next commit that contains the result is
+// fast-forwarded to. Each merge is first tried at tree level (paths changed by one side only); paths
+// both sides changed are then merged line by line. Octopus is meant for branches that don't touch the same
+// lines - a conflict in any commit but the last one fails the whole merge, conflicts of the last one are
+// left in the work tree like for an ordinary merge. Renames are not detected.
+
+// Merge remotes into headFiles one by one - progress is written to w, the result may have conflicts of the
+// last remote
+func mergeOctopus(head string, headFiles map[string]TreeEntry, remotes []string, names map[string]string, fastForward bool, w io.Writer) (*MergeResult, error) {
+	merged := []string{head}
+	result := &MergeResult{Files: headFiles}
+	for _, remote := range remotes {
+		if len(result.Conflicts) > 0 {
+			fmt.Fprintln(w, "Automated merge did not work.")
+			fmt.Fprintln(w, "Should not be doing an octopus.")
+			return nil, fmt.Errorf("Merge with strategy octopus failed.")
+		}
+
+		var bases []string
+		for _, commit := range merged {
+			commitBases, err := mergeBases(remote, commit)
+			if err != nil {
+				return nil, err
+			}
+			bases = append(bases, commitBases...)
+		}
+		bases, err := reduceHeads(bases)
+		if err != nil {
+			return nil, err
+		}
+		remoteFiles, err := commitFiles(remote)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case slices.Contains(bases, remote):
+			fmt.Fprintf(w, "Already up to date with %s\n", names[remote])
+			continue
+		case fastForward && len(merged) == 1 && slices.Equal(bases, merged):
+			fmt.Fprintf(w, "Fast-forwarding to: %s\n", names[remote])
+			merged = []string{remote}
+			result = &MergeResult{Files: remoteFiles}
+			continue
+		}
+		fastForward = false
+
+		fmt.Fprintf(w, "Trying simple merge with %s\n", names[remote])
+		settings, err := resolveMergeSettings(nil, names[remote])
+		if err != nil {
+			return nil, err
+		}
+		settings.Renames = false
+		baseTree, err := mergeBaseTree(bases, settings)
+		if err != nil {
+			return nil, err
+		}
+		baseFiles := make(map[string]TreeEntry)
+		if baseTree != "" {
+			if err := flattenTree(baseTree, "", baseFiles); err != nil {
+				return nil, err
+			}
+		}
+		settings.BaseLabel = "merged common ancestors"
+		if len(bases) == 1 {
+			settings.BaseLabel = shortHash(bases[0])
+		}
+		if result, err = mergeFileSets(baseFiles, result.Files, remoteFiles, settings); err != nil {
+			return nil, err
+		}
+		// Messages come from paths changed on both sides - the tree level merge wasn't enough
+		if len(result.Messages) > 0 {
+			fmt.Fprintln(w, "Simple merge did not work, trying automatic merge.")
+		}
+		for _, message := range result.Messages {
+			fmt.Fprintln(w, message.Text)
+		}
+		merged = append(merged, remote)
+	}
+	return result, nil
+}
+
+// Commits without duplicates and without those reachable from another one of them - order is kept
+func reduceHeads(commits []string) ([]string, error) {
+	var reduced []string
+	for i, commit := range commits {
+		if slices.Contains(commits[:i], commit) {
+			continue
+		}
+		contained := false
+		for _, other := range commits {
+			if other == commit {
+				continue
+			}
+			ancestor, err := isAncestor(commit, other)
+			if err != nil {
+				return nil, err
+			}
+			if ancestor {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			reduced = append(reduced, commit)
+		}
+	}
+	return reduced, nil
+}
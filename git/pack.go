@@ -0,0 +1,519 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+///////////////////////////// CLONE //////////////////////////////////////////
+
+// Sends HTTP GET request on /info/refs?service=<service> URL to get refs file (service is git-upload-pack or git-receive-pack).
+// Protocol v2 is requested with Git-Protocol header - servers that don't know it answer with v0 refs advertisement
+func fetchRefs(ctx context.Context, remoteUrl, service string, auth *HttpAuth) ([]byte, error) {
+	refsUrl := fmt.Sprintf("%s/info/refs?service=%s", remoteUrl, service)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", refsUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	if service == "git-upload-pack" {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
+
+	resp, err := doHttpRequest(req, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch refs: %w", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return body, nil
+}
+
+// Extracts HEAD sha1 hash, and the branch HEAD points to (from symref=HEAD:refs/heads/<name> capability) from refs file
+// Branch is empty if server doesn't advertise symref (older servers) or HEAD is detached
+func extractHeadFromRefs(byteRefs []byte) (string, string, error) {
+	refs, capabilities, err := parseRefs(byteRefs)
+	if err != nil {
+		return "", "", err
+	}
+
+	headBranch := strings.TrimPrefix(parseSymrefs(capabilities)["HEAD"], "refs/heads/")
+	return refs["HEAD"], headBranch, nil
+}
+
+// Parse symref=<ref>:<target> entries from capabilities string
+func parseSymrefs(capabilities string) map[string]string {
+	symrefs := make(map[string]string)
+	for _, capability := range strings.Fields(capabilities) {
+		value, ok := strings.CutPrefix(capability, "symref=")
+		if !ok {
+			continue
+		}
+		if ref, target, ok := strings.Cut(value, ":"); ok {
+			symrefs[ref] = target
+		}
+	}
+	return symrefs
+}
+
+// Parse refs file, and make hashMap out of it
+// Refs file is a list of pkt-lines "<hash> <ref name>", first ref line has capabilities after NUL byte
+func parseRefs(body []byte) (map[string]string, string, error) {
+	refs := make(map[string]string)
+	var capabilities string
+
+	for offset := 0; offset < len(body); {
+		payload, kind, next, err := readPktLine(body, offset)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse refs: %w", err)
+		}
+		offset = next
+		// Skip flush packets and "# service=..." header (smart HTTP only)
+		if kind != PKT_DATA || bytes.HasPrefix(payload, []byte("#")) {
+			continue
+		}
+
+		line := strings.TrimSuffix(string(payload), "\n")
+		if refPart, caps, ok := strings.Cut(line, "\x00"); ok {
+			capabilities = caps
+			line = refPart
+		}
+
+		if hash, name, ok := strings.Cut(line, " "); ok {
+			refs[name] = hash
+		}
+	}
+
+	return refs, capabilities, nil
+}
+
+// Build have-want request body
+// depth > 0 makes a shallow request - server sends only the last depth commits of history
+// Non-empty filter (e.g. blob:none) asks server to leave out objects that don't match it (partial clone)
+func buildUploadPackRequest(hashes []string, depth int, filter string) []byte {
+	var buf bytes.Buffer
+
+	capabilities := "ofs-delta side-band-64k"
+	if depth > 0 {
+		capabilities += " shallow"
+	}
+	if filter != "" {
+		capabilities += " filter"
+	}
+
+	// First line: "want <hash> <capabilities>\n", other lines: "want <hash>\n"
+	for i, hash := range hashes {
+		wantLine := fmt.Sprintf("want %s\n", hash)
+		if i == 0 {
+			wantLine = fmt.Sprintf("want %s %s\n", hash, capabilities)
+		}
+		writePktLine(&buf, wantLine)
+	}
+
+	// Commits we already have as shallow must be told to the server, so it can extend them
+	if depth > 0 {
+		shallow, err := readShallowCommits()
+		if err == nil {
+			for _, hash := range shallow {
+				writePktLine(&buf, fmt.Sprintf("shallow %s\n", hash))
+			}
+		}
+		writePktLine(&buf, fmt.Sprintf("deepen %d\n", depth))
+	}
+	if filter != "" {
+		writePktLine(&buf, fmt.Sprintf("filter %s\n", filter))
+	}
+
+	buf.WriteString("0000")
+	// Second line - done - we don't want anything more
+	// Nothing may follow done - on stateful connections (ssh, git://) unread bytes make the server reset the connection
+	writePktLine(&buf, "done\n")
+
+	return buf.Bytes()
+}
+
+// Writes one line to Writer in pkt-line format
+func writePktLine(w io.Writer, line string) {
+	length := len(line) + 4
+	fmt.Fprintf(w, "%04x%s", length, line)
+}
+
+// Sends HTTP request to /<service> - /git-upload-pack to retrieve .pack file (protocolVersion 2 for v2 command requests)
+// Response body is returned as it arrives, so progress can be shown while pack is downloaded
+func sendServiceRequest(ctx context.Context, remoteUrl, service string, request []byte, protocolVersion int, auth *HttpAuth) (io.ReadCloser, error) {
+	url := remoteUrl + "/" + service
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create POST request: %w", err)
+	}
+
+	// REQUIRED headers for smart HTTP service request
+	req.Header.Set("Content-Type", "application/x-"+service+"-request")
+	req.Header.Set("Accept", "application/x-"+service+"-result")
+	if protocolVersion == 2 {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
+
+	resp, err := doHttpRequest(req, auth)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// Parse pack file - header (version and obj size) and content (objects), and extract all object from it
+func parsePackFile(ctx context.Context, data []byte) ([]GitObject, error) {
+	defer tracePerformance("parse pack")()
+
+	// end of .pack file a check sum (last 20 bytes) - we don't need that now
+	data = data[:len(data)-20]
+
+	// Objects offsets are relative to the start of the pack (OFS_DELTA base is addressed by that offset)
+	packStart := bytes.Index(data, []byte("PACK"))
+	offset := packStart + 4
+	version := binary.BigEndian.Uint32(data[offset : offset+4])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+	offset += 4
+	numObjects := binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+
+	objects := make([]GitObject, 0, numObjects)
+
+	for i := 0; i < int(numObjects); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		objectOffset := uint64(offset - packStart)
+
+		_, used, objType, err := parseObjectHeader(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse object header: %w", err)
+		}
+		offset += used
+		var baseObjHash string
+		var baseOffset uint64
+		if objType == OBJ_REF_DELTA {
+			baseObjHash = hex.EncodeToString(data[offset : offset+20])
+			offset += 20
+		} else if objType == OBJ_OFS_DELTA {
+			// Base object is located <negative offset> bytes before this object
+			negativeOffset, ofsLen := parseDeltaOffset(data[offset:])
+			if negativeOffset > objectOffset {
+				return nil, fmt.Errorf("ofs-delta base offset out of pack at %d", objectOffset)
+			}
+			baseOffset = objectOffset - negativeOffset
+			offset += ofsLen
+		}
+
+		zlibStart := offset
+		decompressed, used, err := readZlibObject(data[zlibStart:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read obj delta content at %d: %w", zlibStart, err)
+		}
+		offset += used
+
+		objects = append(objects, GitObject{
+			Type:        objType,
+			Data:        decompressed,
+			BaseObjHash: baseObjHash,
+			Offset:      objectOffset,
+			BaseOffset:  baseOffset,
+		})
+	}
+
+	return objects, nil
+}
+
+// Parse object header - retrieve obj size, obj type and number of used bytes
+func parseObjectHeader(data []byte) (uint64, int, ObjectType, error) {
+	used := 0
+	// Header is usually the first byte
+	byteData := data[used]
+	used++
+
+	// Object type is always (6-4 bits)
+	objectType := ObjectType((byteData >> 4) & 0x7)
+	size := uint64(byteData & 0xF)
+	shift := 4
+	// If MSB == 1, we have to look the next byte
+	for byteData&0x80 != 0 {
+		// MSB == 1
+		if len(data) <= used || 64 <= shift {
+			return 0, 0, 0, fmt.Errorf("bad object header")
+		}
+		byteData = data[used]
+		used++
+		size += uint64(byteData&0x7F) << shift
+		shift += 7
+	}
+
+	return size, used, objectType, nil
+}
+
+// Parse DELTA_OFS offset
+func parseDeltaOffset(data []byte) (val uint64, used int) {
+	b := data[0]
+	val = uint64(b & 0x7F)
+	used = 1
+	for b&0x80 != 0 {
+		b = data[used]
+		val = (val+1)<<7 | uint64(b&0x7F)
+		used++
+	}
+	return
+}
+
+// Read and decompress the whole Zlib object - returns object and number of used bytes
+func readZlibObject(pack []byte) ([]byte, int, error) {
+	reader := bytes.NewReader(pack)
+	r, err := zlib.NewReader(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	decompData, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	used := int(reader.Size()) - reader.Len()
+
+	return decompData, used, nil
+}
+
+// Write object to .git/objects
+func writeObjectWithType(content []byte, objectType ObjectType) ([]byte, error) {
+	object := generateObjectByte(objectType.String(), content)
+	// Write to disk
+	hash, err := writeObject(object)
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Takes a list of objects, and write them
+// Deltas can depend on objects that come later in the pack (or on other deltas), so they are resolved
+// by walking the dependency graph: every resolved object unlocks the deltas that use it as a base
+func writePackObjects(ctx context.Context, objects []GitObject) error {
+	defer tracePerformance("write objects")()
+	// Deltas waiting for their base - by base offset (OFS_DELTA) and by base hash (REF_DELTA)
+	dependentsByOffset := make(map[uint64][]int)
+	dependentsByHash := make(map[string][]int)
+	resolved := make([]bool, len(objects))
+
+	var queue []GitObject
+	for i, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch obj.Type {
+		case OBJ_BLOB, OBJ_COMMIT, OBJ_TREE, OBJ_TAG:
+			hash, err := writeObjectWithType(obj.Data, obj.Type)
+			if err != nil {
+				return fmt.Errorf("failed to write %s object: %w", obj.Type, err)
+			}
+			obj.Hash = hex.EncodeToString(hash)
+			resolved[i] = true
+			queue = append(queue, obj)
+		case OBJ_OFS_DELTA:
+			dependentsByOffset[obj.BaseOffset] = append(dependentsByOffset[obj.BaseOffset], i)
+		case OBJ_REF_DELTA:
+			dependentsByHash[obj.BaseObjHash] = append(dependentsByHash[obj.BaseObjHash], i)
+		}
+	}
+
+	for {
+		// Resolve every delta whose base is known, breadth-first
+		for len(queue) > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			base := queue[0]
+			queue = queue[1:]
+
+			dependents := append(dependentsByOffset[base.Offset], dependentsByHash[base.Hash]...)
+			delete(dependentsByOffset, base.Offset)
+			delete(dependentsByHash, base.Hash)
+
+			for _, i := range dependents {
+				if resolved[i] {
+					continue
+				}
+				reconstructed, err := writeDeltaObject(objects[i], base)
+				if err != nil {
+					return fmt.Errorf("failed to write %s object: %w", objects[i].Type, err)
+				}
+				resolved[i] = true
+				queue = append(queue, reconstructed)
+			}
+		}
+
+		// Remaining REF_DELTA objects may use a base that isn't in the pack, but is already in .git/objects (thin pack)
+		for baseHash := range dependentsByHash {
+			baseType, _, baseData, err := readStoredObject(baseHash)
+			if err != nil {
+				continue
+			}
+			objType, err := ObjectTypeFromString(baseType)
+			if err != nil {
+				return fmt.Errorf("unknown base object type: %w", err)
+			}
+			// Base is not in the pack, so it has no pack offset - only REF_DELTA dependents can use it
+			queue = append(queue, GitObject{Type: objType, Data: baseData, Hash: baseHash, Offset: ^uint64(0)})
+			break
+		}
+
+		if len(queue) == 0 {
+			break
+		}
+	}
+
+	unresolved := 0
+	for _, isResolved := range resolved {
+		if !isResolved {
+			unresolved++
+		}
+	}
+	if unresolved > 0 {
+		return fmt.Errorf("%d delta objects could not be resolved (missing base objects)", unresolved)
+	}
+	return nil
+}
+
+// Apply delta on top of base object and write the result - reconstructed object has the type of its base
+func writeDeltaObject(object GitObject, base GitObject) (GitObject, error) {
+	reconstructed, err := applyDelta(base.Data, object.Data)
+	if err != nil {
+		return GitObject{}, fmt.Errorf("failed to apply delta: %w", err)
+	}
+
+	hash, err := writeObjectWithType(reconstructed, base.Type)
+	if err != nil {
+		return GitObject{}, fmt.Errorf("failed to write delta object: %w", err)
+	}
+	return GitObject{Type: base.Type, Data: reconstructed, Hash: hex.EncodeToString(hash), Offset: object.Offset}, nil
+}
+
+// Read var-length (if MSB == 1, then it has to read the next byte - the process repeats until it reads a byte with MSB == 0)
+// Returns source size, target size and header length
+func parseDeltaHeader(objectData []byte) (int, int, int, error) {
+	read := 0
+	srcSize, used, err := parseDeltaSize(objectData)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	read += used
+	targetSize, used, err := parseDeltaSize(objectData[read:])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	read += used
+	return srcSize, targetSize, read, nil
+}
+
+func parseDeltaSize(packFile []byte) (int, int, error) {
+	size, index, off := 0, 0, 0
+	for {
+		if index >= len(packFile) || off > 56 {
+			return 0, 0, fmt.Errorf("%w: truncated delta header", ErrCorruptObject)
+		}
+		b := packFile[index]
+		size |= int(b&0b01111111) << off
+		off += 7
+		index += 1
+		if b&0b10000000 == 0 { // Last byte has MSB unset
+			break
+		}
+	}
+
+	// this index is the same as the used bytes
+
+	return size, index, nil
+}
+
+// Takes base object, and delta object (with its header), then apply COPY and INSERT instructions from delta object
+// Delta whose source size doesn't match base, or whose instructions reach outside base or delta, is corrupt
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, targetSize, i, err := parseDeltaHeader(delta)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("%w: delta base is %d bytes, expected %d", ErrCorruptObject, len(base), srcSize)
+	}
+
+	var result []byte
+	for i < len(delta) {
+		op := delta[i]
+		i++
+		if op&0x80 != 0 {
+			// COPY from base - offset (4 bytes) and size (3 bytes), only bytes whose bit is set are present
+			var offset, size int
+			for bit := 0; bit < 7; bit++ {
+				if op&(1<<bit) == 0 {
+					continue
+				}
+				if i >= len(delta) {
+					return nil, fmt.Errorf("%w: truncated delta copy instruction", ErrCorruptObject)
+				}
+				if bit < 4 {
+					offset |= int(delta[i]) << (8 * bit)
+				} else {
+					size |= int(delta[i]) << (8 * (bit - 4))
+				}
+				i++
+			}
+			if size == 0 {
+				size = 0x10000
+			} // default
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("%w: delta copies outside of its base", ErrCorruptObject)
+			}
+			result = append(result, base[offset:offset+size]...)
+		} else if op != 0 {
+			// INSERT new bytes
+			size := int(op)
+			if i+size > len(delta) {
+				return nil, fmt.Errorf("%w: truncated delta insert instruction", ErrCorruptObject)
+			}
+			result = append(result, delta[i:i+size]...)
+			i += size
+		} else {
+			return nil, fmt.Errorf("%w: unexpected delta opcode 0", ErrCorruptObject)
+		}
+		if len(result) > targetSize {
+			return nil, fmt.Errorf("%w: delta result is larger than %d bytes", ErrCorruptObject, targetSize)
+		}
+	}
+	if len(result) != targetSize {
+		return nil, fmt.Errorf("%w: delta result is %d bytes, expected %d", ErrCorruptObject, len(result), targetSize)
+	}
+	return result, nil
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package git
+
+import (
+	"io"
+	"os"
+)
+
+// No mmap - window is read into memory instead
+func mapPackFile(file *os.File, offset, length int64) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func unmapPackFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package git
+
+import (
+	"os"
+	"syscall"
+)
+
+// Map part of pack file read-only
+func mapPackFile(file *os.File, offset, length int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), offset, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapPackFile(data []byte) error {
+	return syscall.Munmap(data)
+}
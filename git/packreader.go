@@ -0,0 +1,300 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Pack reader - objects that are not loose are looked up in .git/objects/pack/*.pack using their .idx files
+//
+// .idx (version 2) layout:
+//   - 4 bytes magic (\377tOc) + 4 bytes version (2)
+//   - fanout table: 256 x 4 bytes - entry N is the number of objects whose first hash byte is <= N
+//   - sorted object hashes: count x 20 bytes
+//   - CRC32 of packed objects: count x 4 bytes
+//   - pack offsets: count x 4 bytes (MSB set -> index into the 8 byte offsets table)
+//   - 8 byte offsets for packs bigger than 2GB
+//   - pack checksum + idx checksum
+//
+// Version 1 has no header - fanout table is followed by count x (4 byte offset + 20 byte hash).
+
+// Loaded .idx files, by own object directory - indexes are parsed only once per process
+var packIndexCache = make(map[string][]*PackIndex)
+
+// Load every .idx file from pack directories of own and alternate object directories
+func loadPackIndexes() ([]*PackIndex, error) {
+	objectDir := resolveRepoLayout().ObjectDir
+	if indexes, ok := packIndexCache[objectDir]; ok {
+		return indexes, nil
+	}
+
+	var idxPaths []string
+	for _, dir := range objectDirectories() {
+		paths, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+		idxPaths = append(idxPaths, paths...)
+	}
+
+	indexes := make([]*PackIndex, 0, len(idxPaths))
+	for _, idxPath := range idxPaths {
+		packPath := strings.TrimSuffix(idxPath, ".idx") + ".pack"
+		if _, err := os.Stat(packPath); err != nil {
+			// .idx without its pack is useless
+			continue
+		}
+
+		index, err := parsePackIndex(idxPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", idxPath, err)
+		}
+		index.PackPath = packPath
+		indexes = append(indexes, index)
+	}
+
+	packIndexCache[objectDir] = indexes
+	return indexes, nil
+}
+
+// Forget loaded indexes and unmap pack windows (after new packs are written to, or removed from, the pack directory)
+func resetPackIndexCache() {
+	packIndexCache = make(map[string][]*PackIndex)
+	multiPackIndexCache = make(map[string]*MultiPackIndex)
+	packBitmapCache = make(map[string]*PackBitmap)
+	if packWindows != nil {
+		packWindows.unmapAll()
+	}
+}
+
+// Parse .idx file (version 1 or 2)
+func parsePackIndex(idxPath string) (*PackIndex, error) {
+	data, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &PackIndex{Version: 1}
+	fanoutStart := 0
+	if len(data) >= 8 && bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) {
+		index.Version = binary.BigEndian.Uint32(data[4:8])
+		if index.Version != 2 {
+			return nil, fmt.Errorf("unsupported idx version: %d", index.Version)
+		}
+		fanoutStart = 8
+	}
+
+	if len(data) < fanoutStart+256*4 {
+		return nil, fmt.Errorf("idx file too short")
+	}
+	for i := 0; i < 256; i++ {
+		index.Fanout[i] = binary.BigEndian.Uint32(data[fanoutStart+i*4:])
+	}
+	count := int(index.Fanout[255])
+	tableStart := fanoutStart + 256*4
+
+	if index.Version == 1 {
+		if len(data) < tableStart+count*24 {
+			return nil, fmt.Errorf("idx file too short")
+		}
+		index.Hashes = make([]byte, 0, count*20)
+		index.Offsets = make([]uint64, count)
+		for i := 0; i < count; i++ {
+			entry := data[tableStart+i*24:]
+			index.Offsets[i] = uint64(binary.BigEndian.Uint32(entry[:4]))
+			index.Hashes = append(index.Hashes, entry[4:24]...)
+		}
+		return index, nil
+	}
+
+	hashesStart := tableStart
+	crcStart := hashesStart + count*20
+	offsetsStart := crcStart + count*4
+	largeOffsetsStart := offsetsStart + count*4
+	if len(data) < largeOffsetsStart {
+		return nil, fmt.Errorf("idx file too short")
+	}
+
+	index.Hashes = data[hashesStart:crcStart]
+	index.Offsets = make([]uint64, count)
+	for i := 0; i < count; i++ {
+		offset := binary.BigEndian.Uint32(data[offsetsStart+i*4:])
+		if offset&0x80000000 == 0 {
+			index.Offsets[i] = uint64(offset)
+			continue
+		}
+
+		// MSB set - remaining bits are position in 8 byte offsets table
+		largeOffset := largeOffsetsStart + int(offset&0x7fffffff)*8
+		if len(data) < largeOffset+8 {
+			return nil, fmt.Errorf("bad large offset in idx")
+		}
+		index.Offsets[i] = binary.BigEndian.Uint64(data[largeOffset:])
+	}
+
+	return index, nil
+}
+
+// Find object offset in pack - binary search between fanout[first_byte-1] and fanout[first_byte]
+func (index *PackIndex) findOffset(hash []byte) (uint64, bool) {
+	low := 0
+	if hash[0] > 0 {
+		low = int(index.Fanout[hash[0]-1])
+	}
+	high := int(index.Fanout[hash[0]])
+
+	for low < high {
+		mid := (low + high) / 2
+		cmp := bytes.Compare(index.Hashes[mid*20:mid*20+20], hash)
+		if cmp == 0 {
+			return index.Offsets[mid], true
+		} else if cmp < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	return 0, false
+}
+
+// Number of objects in pack
+func (index *PackIndex) count() int {
+	return int(index.Fanout[255])
+}
+
+// Read object from one of the packs - returns false if no pack contains it
+func readPackedObject(objectHash string) (ObjectType, []byte, bool, error) {
+	hash, err := hex.DecodeString(objectHash)
+	if err != nil || len(hash) != 20 {
+		return 0, nil, false, fmt.Errorf("%w: invalid object name %s", ErrObjectNotFound, objectHash)
+	}
+
+	packPath, offset, found, err := findPackedObject(hash)
+	if err != nil || !found {
+		return 0, nil, false, err
+	}
+
+	objType, content, err := readPackObjectAt(packPath, offset, 0)
+	if err != nil {
+		// Missing REF_DELTA base is reported as it is, anything else means the pack is damaged
+		if !errors.Is(err, ErrObjectNotFound) && !errors.Is(err, ErrCorruptObject) {
+			err = fmt.Errorf("%w: %v", ErrCorruptObject, err)
+		}
+		return 0, nil, false, fmt.Errorf("failed to read %s from %s: %w", objectHash, filepath.Base(packPath), err)
+	}
+	return objType, content, true, nil
+}
+
+// Find pack and offset of object - multi-pack-indexes (own and alternates) are consulted first, then idx of
+// every pack they don't cover
+func findPackedObject(hash []byte) (string, uint64, bool, error) {
+	var midxs []*MultiPackIndex
+	for _, dir := range objectDirectories() {
+		midx, err := loadMultiPackIndex(filepath.Join(dir, "pack"))
+		if err != nil {
+			return "", 0, false, err
+		}
+		if midx == nil {
+			continue
+		}
+		if packPath, offset, ok := midx.find(hash); ok {
+			return packPath, offset, true, nil
+		}
+		midxs = append(midxs, midx)
+	}
+
+	indexes, err := loadPackIndexes()
+	if err != nil {
+		return "", 0, false, err
+	}
+	for _, index := range indexes {
+		if multiPackIndexCovers(midxs, index.PackPath) {
+			continue
+		}
+		if offset, ok := index.findOffset(hash); ok {
+			return index.PackPath, offset, true, nil
+		}
+	}
+	return "", 0, false, nil
+}
+
+// Read and inflate object at offset in pack - delta chains are resolved recursively
+func readPackObjectAt(packPath string, offset uint64, depth int) (ObjectType, []byte, error) {
+	if depth > 1000 {
+		return 0, nil, fmt.Errorf("delta chain too long")
+	}
+
+	// Object header (type + size) and delta base are at most a few dozen bytes - small objects at the end
+	// of the pack can be shorter than that
+	header := make([]byte, 64)
+	n, err := io.ReadFull(&PackCursor{PackPath: packPath, Offset: int64(offset)}, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, nil, err
+	}
+	header = header[:n]
+
+	_, used, objType, err := parseObjectHeader(header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var baseType ObjectType
+	var baseData []byte
+	switch objType {
+	case OBJ_OFS_DELTA:
+		negativeOffset, ofsLen := parseDeltaOffset(header[used:])
+		used += ofsLen
+		if negativeOffset > offset {
+			return 0, nil, fmt.Errorf("bad ofs-delta base offset")
+		}
+		baseType, baseData, err = readPackObjectAt(packPath, offset-negativeOffset, depth+1)
+		if err != nil {
+			return 0, nil, err
+		}
+	case OBJ_REF_DELTA:
+		baseHash := hex.EncodeToString(header[used : used+20])
+		used += 20
+		typeName, _, data, err := readStoredObject(baseHash)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read delta base %s: %w", baseHash, err)
+		}
+		baseType, err = ObjectTypeFromString(typeName)
+		if err != nil {
+			return 0, nil, err
+		}
+		baseData = data
+	}
+
+	// Compressed data starts right after the header
+	reader, err := zlib.NewReader(&PackCursor{PackPath: packPath, Offset: int64(offset) + int64(used)})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if objType != OBJ_OFS_DELTA && objType != OBJ_REF_DELTA {
+		return objType, content, nil
+	}
+
+	reconstructed, err := applyDelta(baseData, content)
+	if err != nil {
+		return 0, nil, err
+	}
+	return baseType, reconstructed, nil
+}
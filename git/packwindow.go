@@ -0,0 +1,134 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Pack windows - instead of reading pack files, parts of them are memory mapped (like git's
+// core.packedGitWindowSize / core.packedGitLimit), so random access into multi-GB packs only touches
+// pages that are really needed. Windows start at multiples of half the window size, so an object near
+// the end of one window is usually in the middle of the next one.
+
+const defaultPackedGitWindowSize = 1 << 30
+const defaultPackedGitLimit = 8 << 30
+
+// Windows of the current process - created on first use
+var packWindows *PackWindowCache
+
+// Window cache with sizes from config
+func packWindowCache() *PackWindowCache {
+	if packWindows != nil {
+		return packWindows
+	}
+
+	windowSize, limit := int64(defaultPackedGitWindowSize), int64(defaultPackedGitLimit)
+	if config, err := loadConfig(); err == nil {
+		windowSize = config.GetInt("core.packedGitWindowSize", windowSize)
+		limit = config.GetInt("core.packedGitLimit", limit)
+	}
+
+	// Mapping offsets have to be page aligned
+	pageSize := int64(os.Getpagesize())
+	windowSize = (windowSize + 2*pageSize - 1) / (2 * pageSize) * (2 * pageSize)
+	if limit < windowSize {
+		limit = windowSize
+	}
+
+	packWindows = &PackWindowCache{WindowSize: windowSize, Limit: limit}
+	return packWindows
+}
+
+// Bytes of pack from offset to the end of the window that contains it
+func (cache *PackWindowCache) bytesAt(packPath string, offset int64) ([]byte, error) {
+	cache.tick++
+	for _, window := range cache.windows {
+		if window.PackPath == packPath && offset >= window.Offset && offset < window.Offset+int64(len(window.Data)) {
+			window.lastUsed = cache.tick
+			return window.Data[offset-window.Offset:], nil
+		}
+	}
+
+	window, err := cache.mapWindow(packPath, offset)
+	if err != nil {
+		return nil, err
+	}
+	return window.Data[offset-window.Offset:], nil
+}
+
+// Map new window that contains offset - least recently used windows are unmapped to stay under the limit
+func (cache *PackWindowCache) mapWindow(packPath string, offset int64) (*PackWindow, error) {
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if offset >= info.Size() {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	align := cache.WindowSize / 2
+	start := offset / align * align
+	length := min(cache.WindowSize, info.Size()-start)
+
+	for cache.mapped+length > cache.Limit && len(cache.windows) > 0 {
+		if err := cache.unmapLeastRecent(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := mapPackFile(file, start, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", packPath, err)
+	}
+	window := &PackWindow{PackPath: packPath, Offset: start, Data: data, lastUsed: cache.tick}
+	cache.windows = append(cache.windows, window)
+	cache.mapped += length
+	return window, nil
+}
+
+// Unmap window that wasn't used for the longest time
+func (cache *PackWindowCache) unmapLeastRecent() error {
+	oldest := 0
+	for i, window := range cache.windows {
+		if window.lastUsed < cache.windows[oldest].lastUsed {
+			oldest = i
+		}
+	}
+	return cache.unmapWindow(oldest)
+}
+
+func (cache *PackWindowCache) unmapWindow(i int) error {
+	window := cache.windows[i]
+	cache.windows = append(cache.windows[:i], cache.windows[i+1:]...)
+	cache.mapped -= int64(len(window.Data))
+	return unmapPackFile(window.Data)
+}
+
+// Unmap every window (before packs are removed or replaced)
+func (cache *PackWindowCache) unmapAll() error {
+	for len(cache.windows) > 0 {
+		if err := cache.unmapWindow(len(cache.windows) - 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy from the current window - data is copied out, so windows can be unmapped between reads
+func (cursor *PackCursor) Read(p []byte) (int, error) {
+	data, err := packWindowCache().bytesAt(cursor.PackPath, cursor.Offset)
+	if err == io.ErrUnexpectedEOF {
+		return 0, io.EOF
+	} else if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	cursor.Offset += int64(n)
+	return n, nil
+}
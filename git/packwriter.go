@@ -0,0 +1,390 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	defaultPackWindow = 10
+	defaultPackDepth  = 50
+	// Longest delta chain git accepts
+	maxPackDepth = 4095
+	// Objects smaller than this are stored whole
+	minDeltaSize = 50
+	// Bytes that have to match before a copy is worth it
+	deltaBlockSize = 16
+	// Base offsets kept for one block hash
+	maxDeltaBucketSize  = 64
+	deltaHashMultiplier = 257
+	// deltaHashMultiplier to the power of deltaBlockSize-1 - weight of the byte leaving the window
+	deltaHashTop = 0xc7690f01
+)
+
+// Pack writer - local packs are objects/pack/pack-<checksum>.pack:
+//
+//	"PACK" + version (2) + object count (4 bytes)
+//	every object - type and size header (see encodeObjectHeader) + zlib compressed content
+//	SHA-1 checksum of everything above (the <checksum> in the name)
+//
+// An object can be stored as a delta against another object of the pack instead (the inverse of applyDelta):
+//
+//	base size, target size (7 bits per byte, MSB - more bytes follow)
+//	0x80 | offset byte flags (bits 0-3) | size byte flags (bits 4-6), then those bytes - copy from base
+//	1-127, then that many bytes - insert them
+//
+// with its base referenced by hash (REF_DELTA) or by the distance back to it in the pack (OFS_DELTA - the base
+// is then written first). Bases are picked as git picks them: objects are sorted by type, file name, path and
+// size (largest first), so versions of one file end up next to each other, and each is compared with the
+// pack.window (10) objects sorted before it. Chains of deltas are at most pack.depth (50) long.
+//
+// with a version 2 index next to it (.idx):
+//
+//	"\377tOc" + version (2)
+//	fanout - 256 x 4 bytes, number of objects whose hash starts with a byte <= i
+//	sorted object hashes (20 bytes each)
+//	CRC32 of every packed entry (header + compressed data)
+//	4 byte pack offsets - MSB set -> index into the 8 byte offsets table
+//	8 byte offsets (only for objects past 2GB)
+//	pack checksum + checksum of everything above
+//
+// The .pack is written before the .idx, so readers (which look for .idx files) never see half a pack.
+
+// Delta compression settings from pack.window and pack.depth config
+func loadPackCompression(offsetDeltas bool) packCompression {
+	compression := packCompression{Window: defaultPackWindow, Depth: defaultPackDepth, OffsetDeltas: offsetDeltas}
+	if config, err := loadConfig(); err == nil {
+		compression.Window = int(config.GetInt("pack.window", defaultPackWindow))
+		compression.Depth = min(int(config.GetInt("pack.depth", defaultPackDepth)), maxPackDepth)
+	}
+	return compression
+}
+
+// Encode objects as a pack - returns the pack with offsets and CRC32s of the entries, in objects order
+func encodePack(objects []GitObject, compression packCompression) ([]byte, []uint64, []uint32) {
+	var buf bytes.Buffer
+	buf.WriteString("PACK")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(len(objects)))
+
+	bases, deltas := selectDeltaBases(objects, compression)
+	offsets := make([]uint64, len(objects))
+	crcs := make([]uint32, len(objects))
+	written := make([]bool, len(objects))
+	var write func(i int)
+	write = func(i int) {
+		if written[i] {
+			return
+		}
+		// OFS_DELTA can only point back - base goes first
+		if bases[i] >= 0 && compression.OffsetDeltas {
+			write(bases[i])
+		}
+		written[i] = true
+		start := buf.Len()
+		offsets[i] = uint64(start)
+		data := objects[i].Data
+		switch {
+		case bases[i] < 0:
+			buf.Write(encodeObjectHeader(objects[i].Type, uint64(len(data))))
+		case compression.OffsetDeltas:
+			data = deltas[i]
+			buf.Write(encodeObjectHeader(OBJ_OFS_DELTA, uint64(len(data))))
+			buf.Write(encodeDeltaOffset(offsets[i] - offsets[bases[i]]))
+		default:
+			data = deltas[i]
+			buf.Write(encodeObjectHeader(OBJ_REF_DELTA, uint64(len(data))))
+			base, _ := hex.DecodeString(objects[bases[i]].Hash)
+			buf.Write(base)
+		}
+		writer := zlib.NewWriter(&buf)
+		writer.Write(data)
+		writer.Close()
+		crcs[i] = crc32.ChecksumIEEE(buf.Bytes()[start:])
+	}
+	for i := range objects {
+		write(i)
+	}
+
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes(), offsets, crcs
+}
+
+// Encode distance back to OFS_DELTA base - big-endian 7 bit groups, MSB - more bytes follow, every group
+// but the last one is stored minus one (see parseDeltaOffset)
+func encodeDeltaOffset(distance uint64) []byte {
+	encoded := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		encoded = append([]byte{0x80 | byte(distance&0x7f)}, encoded...)
+	}
+	return encoded
+}
+
+// Pick delta base for every object (-1 - stored whole) and compute the deltas. A delta is kept when it is
+// smaller than half of the object - the deeper the base already is in a chain, the smaller it has to be.
+func selectDeltaBases(objects []GitObject, compression packCompression) ([]int, [][]byte) {
+	defer tracePerformance("select delta bases")()
+	bases := make([]int, len(objects))
+	deltas := make([][]byte, len(objects))
+	for i := range bases {
+		bases[i] = -1
+	}
+	if compression.Window <= 0 || compression.Depth <= 0 {
+		return bases, deltas
+	}
+
+	order := make([]int, len(objects))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		x, y := objects[order[a]], objects[order[b]]
+		if x.Type != y.Type {
+			return x.Type < y.Type
+		}
+		if xName, yName := path.Base(x.Path), path.Base(y.Path); xName != yName {
+			return xName < yName
+		}
+		if x.Path != y.Path {
+			return x.Path < y.Path
+		}
+		return len(x.Data) > len(y.Data)
+	})
+
+	depths := make([]int, len(objects))
+	for position, target := range order {
+		data := objects[target].Data
+		if len(data) < minDeltaSize {
+			continue
+		}
+		for _, base := range order[max(0, position-compression.Window):position] {
+			baseData := objects[base].Data
+			if objects[base].Type != objects[target].Type || depths[base] >= compression.Depth || len(baseData) < len(data)/32 {
+				continue
+			}
+			limit := (len(data)/2 - 20) * (compression.Depth - depths[base]) / compression.Depth
+			if deltas[target] != nil {
+				limit = min(limit, len(deltas[target])-1)
+			}
+			if delta := createDelta(baseData, data, limit); delta != nil {
+				bases[target], deltas[target], depths[target] = base, delta, depths[base]+1
+			}
+		}
+	}
+	return bases, deltas
+}
+
+// Delta that turns base into target - nil when it would be longer than limit. Base is indexed by blocks of
+// deltaBlockSize bytes, target is scanned with a rolling hash of the same length, and every match is
+// extended both ways as far as the bytes agree.
+func createDelta(base, target []byte, limit int) []byte {
+	if limit <= 0 {
+		return nil
+	}
+	index := make(map[uint32][]int)
+	for offset := 0; offset+deltaBlockSize <= len(base); offset += deltaBlockSize {
+		hash := deltaBlockHash(base[offset : offset+deltaBlockSize])
+		if len(index[hash]) < maxDeltaBucketSize {
+			index[hash] = append(index[hash], offset)
+		}
+	}
+
+	delta := appendDeltaSize(appendDeltaSize(nil, len(base)), len(target))
+	insertStart, position := 0, 0
+	var hash uint32
+	if len(target) >= deltaBlockSize {
+		hash = deltaBlockHash(target[:deltaBlockSize])
+	}
+	for position+deltaBlockSize <= len(target) {
+		matchOffset, matchLength := 0, 0
+		for _, offset := range index[hash] {
+			length := 0
+			for offset+length < len(base) && position+length < len(target) && base[offset+length] == target[position+length] {
+				length++
+			}
+			if length > matchLength {
+				matchOffset, matchLength = offset, length
+			}
+		}
+		if matchLength < deltaBlockSize {
+			// Slide the window one byte
+			if position+deltaBlockSize < len(target) {
+				hash = (hash-uint32(target[position])*deltaHashTop)*deltaHashMultiplier + uint32(target[position+deltaBlockSize])
+			}
+			position++
+			continue
+		}
+
+		// Bytes before the match may match too - they don't have to be inserted then
+		for matchOffset > 0 && position > insertStart && base[matchOffset-1] == target[position-1] {
+			matchOffset, position, matchLength = matchOffset-1, position-1, matchLength+1
+		}
+		delta = appendDeltaInsert(delta, target[insertStart:position])
+		for matchLength > 0 {
+			size := min(matchLength, 0x10000)
+			delta = appendDeltaCopy(delta, matchOffset, size)
+			matchOffset, position, matchLength = matchOffset+size, position+size, matchLength-size
+		}
+		insertStart = position
+		if len(delta) > limit {
+			return nil
+		}
+		if position+deltaBlockSize <= len(target) {
+			hash = deltaBlockHash(target[position : position+deltaBlockSize])
+		}
+	}
+	delta = appendDeltaInsert(delta, target[insertStart:])
+	if len(delta) > limit {
+		return nil
+	}
+	return delta
+}
+
+// Hash of a deltaBlockSize block - polynomial, so it can be rolled one byte at a time
+func deltaBlockHash(block []byte) uint32 {
+	var hash uint32
+	for _, b := range block {
+		hash = hash*deltaHashMultiplier + uint32(b)
+	}
+	return hash
+}
+
+// Append size in the delta header encoding - 7 bits per byte, least significant first
+func appendDeltaSize(delta []byte, size int) []byte {
+	for size >= 0x80 {
+		delta = append(delta, byte(size&0x7f)|0x80)
+		size >>= 7
+	}
+	return append(delta, byte(size))
+}
+
+// Append insert instructions for data - at most 127 bytes each
+func appendDeltaInsert(delta, data []byte) []byte {
+	for len(data) > 0 {
+		size := min(len(data), 0x7f)
+		delta = append(append(delta, byte(size)), data[:size]...)
+		data = data[size:]
+	}
+	return delta
+}
+
+// Append copy instruction - only non-zero bytes of offset and size are stored (size 0x10000 is stored as 0)
+func appendDeltaCopy(delta []byte, offset, size int) []byte {
+	command := len(delta)
+	delta = append(delta, 0x80)
+	for i := 0; i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			delta[command] |= 1 << i
+			delta = append(delta, b)
+		}
+	}
+	if size == 0x10000 {
+		return delta
+	}
+	for i := 0; i < 3; i++ {
+		if b := byte(size >> (8 * i)); b != 0 {
+			delta[command] |= 0x10 << i
+			delta = append(delta, b)
+		}
+	}
+	return delta
+}
+
+// Build version 2 index for pack entries - objects need their Hash
+func encodePackIndex(objects []GitObject, offsets []uint64, crcs []uint32, packChecksum []byte) ([]byte, error) {
+	order := make([]int, len(objects))
+	hashes := make([][]byte, len(objects))
+	for i, object := range objects {
+		hash, err := hex.DecodeString(object.Hash)
+		if err != nil || len(hash) != 20 {
+			return nil, fmt.Errorf("invalid object hash %q", object.Hash)
+		}
+		order[i], hashes[i] = i, hash
+	}
+	sort.Slice(order, func(a, b int) bool { return bytes.Compare(hashes[order[a]], hashes[order[b]]) < 0 })
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 't', 'O', 'c'})
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, hash := range hashes {
+		fanout[hash[0]]++
+	}
+	for i, count := uint32(0), uint32(0); i < 256; i++ {
+		count += fanout[i]
+		binary.Write(&buf, binary.BigEndian, count)
+	}
+	for _, i := range order {
+		buf.Write(hashes[i])
+	}
+	for _, i := range order {
+		binary.Write(&buf, binary.BigEndian, crcs[i])
+	}
+	var largeOffsets []uint64
+	for _, i := range order {
+		if offsets[i] < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(offsets[i]))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(largeOffsets)))
+		largeOffsets = append(largeOffsets, offsets[i])
+	}
+	for _, offset := range largeOffsets {
+		binary.Write(&buf, binary.BigEndian, offset)
+	}
+
+	buf.Write(packChecksum)
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes(), nil
+}
+
+// Write objects (with their Hash) as a new pack in objects/pack, with a reachability bitmap when bitmap is
+// set - returns the pack's name (pack-<checksum>.pack)
+func writePack(objects []GitObject, bitmap bool) (string, error) {
+	defer tracePerformance("write pack")()
+	pack, offsets, crcs := encodePack(objects, loadPackCompression(true))
+	packChecksum := pack[len(pack)-20:]
+	index, err := encodePackIndex(objects, offsets, crcs, packChecksum)
+	if err != nil {
+		return "", err
+	}
+
+	packDir := objectDirPath("pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create pack directory: %w", err)
+	}
+	name := "pack-" + hex.EncodeToString(packChecksum)
+	for _, file := range []struct {
+		extension string
+		data      []byte
+	}{{".pack", pack}, {".idx", index}} {
+		path := filepath.Join(packDir, name+file.extension)
+		tmpPath := filepath.Join(packDir, "tmp_"+name+file.extension)
+		if err := os.WriteFile(tmpPath, file.data, 0444); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", name+file.extension, err)
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(tmpPath)
+			return "", fmt.Errorf("failed to write %s: %w", name+file.extension, err)
+		}
+	}
+	resetPackIndexCache()
+	if bitmap {
+		if _, err := writePackBitmap(name+".pack", objects, offsets, packChecksum); err != nil {
+			return "", err
+		}
+	}
+	return name + ".pack", nil
+}
@@ -0,0 +1,139 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// Pager - output of log and diff goes through a pager when standard output is a terminal. The pager is the
+// first of:
+//
+//	GIT_PAGER, pager.<command>, core.pager, PAGER, less
+//
+// pager.<command> may also be a boolean - false turns paging of the command off. Pager "cat" (or empty)
+// means no pager. Like git, the pager runs through the shell with LESS=FRX (quit when output fits on one
+// screen, pass colors through, don't clear the screen) and LV=-c unless they are set.
+
+// Running pager - nil when output is not paged
+var activePager *Pager
+
+// Check whether file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Width of the terminal - COLUMNS when it is set, 80 otherwise
+func terminalColumns() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// Pager command for command's output - "" when it is not paged
+func pagerCommand(command string) string {
+	config, _ := loadConfig()
+
+	// Boolean pager.<command> only says whether to page, anything else is the pager itself
+	var commandPager string
+	if config != nil {
+		if value, ok := config.Get("pager." + command); ok {
+			if parseBoolValue(value, true) == parseBoolValue(value, false) {
+				if !parseBoolValue(value, false) {
+					return ""
+				}
+			} else {
+				commandPager = value
+			}
+		}
+	}
+
+	pager, ok := os.LookupEnv("GIT_PAGER")
+	if !ok && commandPager != "" {
+		pager, ok = commandPager, true
+	}
+	if !ok && config != nil {
+		pager, ok = config.Get("core.pager")
+	}
+	if !ok {
+		pager, ok = os.LookupEnv("PAGER")
+	}
+	if !ok {
+		pager = "less"
+	}
+	if pager == "cat" {
+		return ""
+	}
+	return pager
+}
+
+// Start pager for command's output - standard output (and standard error, when it is a terminal too) goes
+// to the pager until stopPager. Nothing happens when disabled (--no-pager), output is not a terminal or
+// the pager can't be started.
+func startPager(command string, disabled bool) {
+	if disabled || activePager != nil || !isTerminal(os.Stdout) {
+		return
+	}
+	pagerCmd := pagerCommand(command)
+	if pagerCmd == "" {
+		return
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	cmd := exec.Command("sh", "-c", pagerCmd)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = reader, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	traceRunCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return
+	}
+	reader.Close()
+
+	activePager = &Pager{Cmd: cmd, Input: writer, Stdout: os.Stdout, Stderr: os.Stderr, Signals: make(chan os.Signal, 1)}
+	// Paged output is still meant for a terminal (colors, see stdoutIsTerminal)
+	os.Setenv("GIT_PAGER_IN_USE", "true")
+	os.Stdout = writer
+	if isTerminal(os.Stderr) {
+		os.Stderr = writer
+	}
+
+	// Interrupted command waits for the pager too, so the terminal stays with it until it quits
+	signal.Notify(activePager.Signals, os.Interrupt, syscall.SIGTERM)
+	go func(pager *Pager) {
+		if _, ok := <-pager.Signals; ok {
+			pager.Input.Close()
+			pager.Cmd.Wait()
+			os.Exit(130)
+		}
+	}(activePager)
+}
+
+// Finish paged output - pager gets end of input and is waited for, standard output and error are restored
+func stopPager() {
+	pager := activePager
+	if pager == nil {
+		return
+	}
+	activePager = nil
+
+	signal.Stop(pager.Signals)
+	close(pager.Signals)
+	pager.Input.Close()
+	os.Stdout, os.Stderr = pager.Stdout, pager.Stderr
+	pager.Cmd.Wait()
+}
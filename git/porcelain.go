@@ -0,0 +1,249 @@
+package git
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Machine readable status (--porcelain) - the format stays the same across releases, paths are relative to
+// the work tree root and C-quoted when they have special characters (never with -z, where entries end with
+// NUL instead of a newline):
+//
+//	v1  XY <path>                                       X index, Y work tree change (" " unchanged)
+//	    R  <old path> -> <path>                         staged rename (-z: "R  <path>\0<old path>")
+//	    ?? <path> / !! <path>                           untracked / ignored
+//	v2  1 XY <sub> <mH> <mI> <mW> <hH> <hI> <path>      "." unchanged
+//	    2 XY <sub> <mH> <mI> <mW> <hH> <hI> R<score> <path>\t<old path>   staged rename (-z: NUL, not tab)
+//	    u XY <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>   unmerged, stages 1-3 ("000000"/zeros when missing)
+//	    ? <path> / ! <path>
+//
+// Unmerged paths have XY codes of their own in both versions - "UU" (both modified), "AA", "DU", "UD", ...
+// mH, mI and mW are modes in HEAD, index and work tree, hH and hI the HEAD and index hashes, <sub> is
+// "N..." for files and "S..." for submodules. With --branch, v1 starts with "## <branch>" (followed by
+// "...<upstream> [ahead A, behind B]" when it tracks one) and v2 with "# branch.oid <commit>" and
+// "# branch.head <branch>" lines, then "# branch.upstream <upstream>" and "# branch.ab +A -B".
+
+// Status letters of changes
+var statusLetters = map[string]byte{"new file": 'A', "modified": 'M', "deleted": 'D', "typechange": 'T', "renamed": 'R'}
+
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// Print status in porcelain format (options.Porcelain is the version)
+func printPorcelainStatus(status *WorkTreeStatus, options StatusOptions, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	quote := config.GetBool("core.quotePath", true)
+	terminator := "\n"
+	name := func(filePath string) string {
+		return quotePath(filePath, quote)
+	}
+	if options.NullTerminated {
+		terminator = "\x00"
+		name = func(filePath string) string {
+			return filePath
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+	if options.Branch {
+		writePorcelainBranch(writer, status, options.Porcelain, terminator)
+	}
+
+	changed := make(map[string]bool)
+	for filePath := range status.Staged {
+		changed[filePath] = true
+	}
+	for filePath := range status.Unstaged {
+		changed[filePath] = true
+	}
+	// v1 lists unmerged paths among the others, v2 after them
+	if options.Porcelain == 1 {
+		for filePath := range status.Unmerged {
+			changed[filePath] = true
+		}
+	}
+	unchanged := byte(' ')
+	if options.Porcelain == 2 {
+		unchanged = '.'
+	}
+	for _, filePath := range sortedKeys(changed) {
+		if stages, ok := status.Unmerged[filePath]; ok {
+			writePorcelainUnmerged(writer, filePath, stages, options.Porcelain, name(filePath)+terminator)
+			continue
+		}
+		x, y := unchanged, unchanged
+		if label, ok := status.Staged[filePath]; ok {
+			x = statusLetters[label]
+		}
+		if label, ok := status.Unstaged[filePath]; ok {
+			y = statusLetters[label]
+		}
+		if oldPath, ok := status.RenamedFrom[filePath]; ok && options.Porcelain == 1 {
+			// Old path follows the new one with -z
+			if options.NullTerminated {
+				fmt.Fprintf(writer, "%c%c %s%s%s%s", x, y, filePath, terminator, oldPath, terminator)
+			} else {
+				fmt.Fprintf(writer, "%c%c %s -> %s%s", x, y, name(oldPath), name(filePath), terminator)
+			}
+			continue
+		}
+		if options.Porcelain == 1 {
+			fmt.Fprintf(writer, "%c%c %s%s", x, y, name(filePath), terminator)
+			continue
+		}
+
+		// Intent-to-add entry has nothing staged yet - it is all zeros, like a file missing from the index
+		// Renamed file is in HEAD under its old path
+		oldPath, renamed := status.RenamedFrom[filePath]
+		headPath := filePath
+		if renamed {
+			headPath = oldPath
+		}
+		headMode, headHash := "000000", zeroHash
+		if entry, ok := status.HeadFiles[headPath]; ok {
+			headMode, headHash = fmt.Sprintf("%06s", entry.Mode), entry.Hash
+		}
+		indexMode, indexHash := "000000", zeroHash
+		if entry, ok := status.IndexEntries[filePath]; ok && !entry.IntentToAdd {
+			indexMode, indexHash = fmt.Sprintf("%06o", entry.Mode), hex.EncodeToString(entry.Hash)
+		}
+		workTreeMode := indexMode
+		if _, ok := status.Unstaged[filePath]; ok {
+			workTreeMode = fmt.Sprintf("%06o", status.WorkTreeModes[filePath])
+		}
+		submodule := "N..."
+		if headMode == "160000" || indexMode == "160000" {
+			submodule = "S..."
+		}
+		if renamed {
+			// Old path is separated by a tab, or by NUL with -z
+			separator := "\t"
+			if options.NullTerminated {
+				separator = "\x00"
+			}
+			fmt.Fprintf(writer, "2 %c%c %s %s %s %s %s %s R%d %s%s%s%s", x, y, submodule, headMode, indexMode,
+				workTreeMode, headHash, indexHash, status.RenameScores[filePath], name(filePath), separator,
+				name(oldPath), terminator)
+			continue
+		}
+		fmt.Fprintf(writer, "1 %c%c %s %s %s %s %s %s %s%s", x, y, submodule, headMode, indexMode, workTreeMode,
+			headHash, indexHash, name(filePath), terminator)
+	}
+
+	if options.Porcelain == 2 {
+		for _, filePath := range sortedKeys(status.Unmerged) {
+			writePorcelainUnmerged(writer, filePath, status.Unmerged[filePath], 2, name(filePath)+terminator)
+		}
+	}
+
+	untracked, ignored := "??", "!!"
+	if options.Porcelain == 2 {
+		untracked, ignored = "?", "!"
+	}
+	for _, filePath := range status.Untracked {
+		fmt.Fprintf(writer, "%s %s%s", untracked, name(filePath), terminator)
+	}
+	if options.ShowIgnored {
+		for _, filePath := range status.Ignored {
+			fmt.Fprintf(writer, "%s %s%s", ignored, name(filePath), terminator)
+		}
+	}
+	return nil
+}
+
+// Write unmerged path of porcelain status - line is the (quoted) path with its terminator
+func writePorcelainUnmerged(w io.Writer, filePath string, stages []IndexEntry, version int, line string) {
+	code := unmergedCodes[unmergedLabel(stages)]
+	if version == 1 {
+		fmt.Fprintf(w, "%s %s", code, line)
+		return
+	}
+
+	modes := []string{"000000", "000000", "000000"}
+	hashes := []string{zeroHash, zeroHash, zeroHash}
+	for _, entry := range stages {
+		modes[entry.Stage-1], hashes[entry.Stage-1] = fmt.Sprintf("%06o", entry.Mode), hex.EncodeToString(entry.Hash)
+	}
+	workTreeMode := "000000"
+	if info, err := os.Lstat(workTreePath(filepath.FromSlash(filePath))); err == nil && !info.IsDir() {
+		mode := indexFileMode(workTreeFileMode(workTreePath(filepath.FromSlash(filePath))), stages[0].Mode)
+		workTreeMode = fmt.Sprintf("%06o", mode)
+	}
+	fmt.Fprintf(w, "u %s N... %s %s %s %s %s %s %s %s", code, modes[0], modes[1], modes[2], workTreeMode,
+		hashes[0], hashes[1], hashes[2], line)
+}
+
+// Write branch header of porcelain status
+func writePorcelainBranch(w io.Writer, status *WorkTreeStatus, version int, terminator string) {
+	branch := strings.TrimPrefix(status.Branch, "refs/heads/")
+	if version == 1 {
+		switch {
+		case status.Head == "":
+			fmt.Fprintf(w, "## No commits yet on %s%s", branch, terminator)
+		case status.Branch == "":
+			fmt.Fprintf(w, "## HEAD (no branch)%s", terminator)
+		case status.Tracking != nil:
+			summary := status.Tracking.summary()
+			if summary != "" {
+				summary = " [" + summary + "]"
+			}
+			fmt.Fprintf(w, "## %s...%s%s%s", branch, status.Tracking.Upstream, summary, terminator)
+		default:
+			fmt.Fprintf(w, "## %s%s", branch, terminator)
+		}
+		return
+	}
+
+	head := status.Head
+	if head == "" {
+		head = "(initial)"
+	}
+	if status.Branch == "" {
+		branch = "(detached)"
+	}
+	fmt.Fprintf(w, "# branch.oid %s%s# branch.head %s%s", head, terminator, branch, terminator)
+	if tracking := status.Tracking; tracking != nil {
+		fmt.Fprintf(w, "# branch.upstream %s%s", tracking.Upstream, terminator)
+		if !tracking.Gone {
+			fmt.Fprintf(w, "# branch.ab +%d -%d%s", tracking.Ahead, tracking.Behind, terminator)
+		}
+	}
+}
+
+// C-quote path the way git shows it - quoted when it has control characters, '"' or '\' (with quoteHigh
+// also bytes above 0x7f, as octal escapes)
+func quotePath(path string, quoteHigh bool) string {
+	var quoted strings.Builder
+	needed := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '"' || c == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case c < 0x20 || c == 0x7f || (c >= 0x80 && quoteHigh):
+			if escape := strings.IndexByte("\a\b\t\n\v\f\r", c); escape != -1 {
+				quoted.WriteByte('\\')
+				quoted.WriteByte("abtnvfr"[escape])
+			} else {
+				fmt.Fprintf(&quoted, "\\%03o", c)
+			}
+		default:
+			quoted.WriteByte(c)
+			continue
+		}
+		needed = true
+	}
+	if !needed {
+		return path
+	}
+	return "\"" + quoted.String() + "\""
+}
@@ -0,0 +1,411 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commit formats (log --pretty=<format>, --format=<format>, format.pretty):
+//
+//	oneline                  "<hash> <subject>" (--oneline also abbreviates the hash)
+//	short, medium, full      "commit <hash>", then Author (short), Author and Date (medium, the default) or
+//	                         Author and Commit (full), then the message - only its first paragraph in short
+//	fuller                   Author, AuthorDate, Commit and CommitDate
+//	raw                      tree, parent, author and committer headers as they are stored
+//	format:<text>            text with placeholders, between commits (no newline after the last one)
+//	tformat:<text>           the same, after every commit - a format with "%" is tformat
+//
+// Other names are pretty.<name> aliases. Placeholders are git's:
+//
+//	%H %h          commit hash, abbreviated          %T %t   tree hash        %P %p   parent hashes
+//	%an %ae %al    author name, email, email local part (%c.. for the committer)
+//	%ad            author date in --date style; %aD rfc, %ai iso, %aI iso-strict, %as short, %at unix,
+//	               %ar relative (%cd.. for the committer)
+//	%s %b %B       subject, body, raw message             %f      subject fit for a file name
+//	%d %D          decorations " (HEAD -> master)", without the parentheses
+//	%n %% %x00     newline, percent sign, byte in hex
+//	%Cred %Cgreen %Cblue %Creset %C(<color>)   colors (only when log is colored, unless %C(always,<color>));
+//	%C(auto)       colors hashes and decorations the way log does
+//
+// "%+x" adds a newline before a non-empty placeholder, "%-x" drops the newlines before an empty one and
+// "% x" adds a space before a non-empty one. Unknown placeholders are kept as they are.
+
+// Named formats (before aliases are expanded)
+var prettyFormatNames = []string{"oneline", "short", "medium", "full", "fuller", "raw"}
+
+// Aliases of pretty.<name> expanded in a row at most
+const prettyAliasDepth = 10
+
+// Resolve commit format of log options - --pretty/--format, then format.pretty; date style is --date, then
+// log.date
+func resolvePrettyFormat(options LogOptions) (prettyFormat, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return prettyFormat{}, err
+	}
+	value := options.Format
+	if value == "" {
+		value, _ = config.Get("format.pretty")
+	}
+	if value == "" {
+		value = "medium"
+	}
+	pretty, err := parsePrettyFormat(value, config)
+	if err != nil {
+		return pretty, err
+	}
+	pretty.abbrev = options.AbbrevCommit
+
+	pretty.date = options.Date
+	if pretty.date == "" {
+		pretty.date, _ = config.Get("log.date")
+	}
+	if !validDateStyle(pretty.date) {
+		return pretty, fmt.Errorf("unknown date format %s", pretty.date)
+	}
+	return pretty, nil
+}
+
+// Parse format name or format:<text>/tformat:<text> - aliases are looked up in config
+func parsePrettyFormat(value string, config *Config) (prettyFormat, error) {
+	for depth := 0; depth < prettyAliasDepth; depth++ {
+		switch {
+		case strings.HasPrefix(value, "format:"):
+			return prettyFormat{name: "format", user: strings.TrimPrefix(value, "format:")}, nil
+		case strings.HasPrefix(value, "tformat:"):
+			return prettyFormat{name: "format", user: strings.TrimPrefix(value, "tformat:"), terminator: true}, nil
+		case strings.Contains(value, "%"):
+			return prettyFormat{name: "format", user: value, terminator: true}, nil
+		}
+		for _, name := range prettyFormatNames {
+			if strings.EqualFold(value, name) {
+				return prettyFormat{name: name, terminator: name == "oneline"}, nil
+			}
+		}
+		alias, ok := config.Get("pretty." + value)
+		if !ok {
+			break
+		}
+		value = alias
+	}
+	return prettyFormat{}, fmt.Errorf("invalid --pretty format: %s", value)
+}
+
+// Check whether commits are separated by an empty format - nothing at all is written for them
+func (pretty prettyFormat) empty() bool {
+	return pretty.name == "format" && pretty.user == ""
+}
+
+// Header of commit - "commit <hash> (decorations)" line, "<hash> (decorations) " for oneline, nothing for
+// user formats; from is the parent a diff following the log is against (log -m), "" when not shown
+func (formatter *commitFormatter) header(hash, from string) string {
+	decoration := ""
+	if formatter.decorate {
+		decoration = formatDecorations(formatter.decorations[hash], formatter.headBranch, formatter.fullRefNames, formatter.colors)
+	}
+	if formatter.pretty.abbrev {
+		hash = shortHash(hash)
+	}
+	if from != "" {
+		if formatter.pretty.abbrev {
+			from = shortHash(from)
+		}
+		hash += " (from " + from + ")"
+	}
+	switch formatter.pretty.name {
+	case "format":
+		return ""
+	case "oneline":
+		return colorize(formatter.colors, "commit", hash) + decoration + " "
+	}
+	return colorize(formatter.colors, "commit", "commit "+hash) + decoration + "\n"
+}
+
+// Message of commit following its header - for the named formats the headers they show and the indented
+// message (with showSignature, the verification report first), the expanded format for user formats
+func (formatter *commitFormatter) message(hash string, commit *Commit) (string, error) {
+	pretty := formatter.pretty
+	switch pretty.name {
+	case "format":
+		return formatter.expand(pretty.user, hash, commit), nil
+	case "oneline":
+		subject, _ := splitCommitMessage(commit.Message)
+		return subject, nil
+	}
+
+	var message strings.Builder
+	if formatter.showSignature {
+		check, _, err := verifyObjectSignature(hash, "commit")
+		switch {
+		case check != nil:
+			message.WriteString(check.Output)
+		case err != nil && !errors.Is(err, ErrNoSignature):
+			fmt.Fprintf(&message, "%s\n", err)
+		}
+	}
+
+	if pretty.name == "raw" {
+		fmt.Fprintf(&message, "tree %s\n", commit.Tree)
+		for _, parent := range commit.Parents {
+			fmt.Fprintf(&message, "parent %s\n", parent)
+		}
+		fmt.Fprintf(&message, "author %s\ncommitter %s\n", commit.Author, commit.Committer)
+	} else if len(commit.Parents) > 1 {
+		shortParents := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			shortParents[i] = shortHash(parent)
+		}
+		fmt.Fprintf(&message, "Merge: %s\n", strings.Join(shortParents, " "))
+	}
+
+	authorName, authorEmail, authorDate, err := parseSignature(commit.Author)
+	if err != nil {
+		return "", err
+	}
+	committerName, committerEmail, committerDate, err := parseSignature(commit.Committer)
+	if err != nil {
+		return "", err
+	}
+	switch pretty.name {
+	case "short":
+		fmt.Fprintf(&message, "Author: %s <%s>\n", authorName, authorEmail)
+	case "medium":
+		fmt.Fprintf(&message, "Author: %s <%s>\n", authorName, authorEmail)
+		fmt.Fprintf(&message, "Date:   %s\n", formatDate(authorDate, pretty.date, formatter.now))
+	case "full":
+		fmt.Fprintf(&message, "Author: %s <%s>\n", authorName, authorEmail)
+		fmt.Fprintf(&message, "Commit: %s <%s>\n", committerName, committerEmail)
+	case "fuller":
+		fmt.Fprintf(&message, "Author:     %s <%s>\n", authorName, authorEmail)
+		fmt.Fprintf(&message, "AuthorDate: %s\n", formatDate(authorDate, pretty.date, formatter.now))
+		fmt.Fprintf(&message, "Commit:     %s <%s>\n", committerName, committerEmail)
+		fmt.Fprintf(&message, "CommitDate: %s\n", formatDate(committerDate, pretty.date, formatter.now))
+	}
+	message.WriteString("\n")
+
+	// Every line is indented, empty ones too; short stops after the first paragraph
+	text := strings.Trim(commit.Message, "\n")
+	if pretty.name == "short" {
+		text, _, _ = strings.Cut(text, "\n\n")
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&message, "    %s\n", line)
+	}
+	return message.String(), nil
+}
+
+// Expand placeholders of user format for commit
+func (formatter *commitFormatter) expand(format, hash string, commit *Commit) string {
+	var text strings.Builder
+	autoColor := false
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			text.WriteByte(format[i])
+			continue
+		}
+		start := i + 1
+		magic := byte(0)
+		if strings.IndexByte("+- ", format[start]) != -1 && start+1 < len(format) {
+			magic = format[start]
+			start++
+		}
+		value, length, ok := formatter.placeholder(format[start:], hash, commit, &autoColor)
+		if !ok {
+			text.WriteByte('%')
+			continue
+		}
+		i = start + length - 1
+
+		switch {
+		case magic == '-' && value == "":
+			trimmed := strings.TrimRight(text.String(), "\n")
+			text.Reset()
+			text.WriteString(trimmed)
+		case magic == '+' && value != "":
+			value = "\n" + value
+		case magic == ' ' && value != "":
+			value = " " + value
+		}
+		text.WriteString(value)
+	}
+	return text.String()
+}
+
+// Value of placeholder at the start of spec (after "%") and the length of the placeholder - false when it
+// is unknown; %C(auto) turns autoColor on, other colors off
+func (formatter *commitFormatter) placeholder(spec, hash string, commit *Commit, autoColor *bool) (string, int, bool) {
+	colors := formatter.colors
+	autoColorize := func(slot, text string) string {
+		if !*autoColor || colors == nil {
+			return text
+		}
+		return colorize(colors, slot, text)
+	}
+
+	switch spec[0] {
+	case 'n':
+		return "\n", 1, true
+	case '%':
+		return "%", 1, true
+	case 'x':
+		if len(spec) < 3 {
+			return "", 0, false
+		}
+		value, err := strconv.ParseUint(spec[1:3], 16, 8)
+		if err != nil {
+			return "", 0, false
+		}
+		return string([]byte{byte(value)}), 3, true
+	case 'H':
+		return autoColorize("commit", hash), 1, true
+	case 'h':
+		return autoColorize("commit", shortHash(hash)), 1, true
+	case 'T':
+		return commit.Tree, 1, true
+	case 't':
+		return shortHash(commit.Tree), 1, true
+	case 'P', 'p':
+		parents := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parents[i] = parent
+			if spec[0] == 'p' {
+				parents[i] = shortHash(parent)
+			}
+		}
+		return strings.Join(parents, " "), 1, true
+	case 'a', 'c':
+		signature := commit.Author
+		if spec[0] == 'c' {
+			signature = commit.Committer
+		}
+		if len(spec) < 2 {
+			return "", 0, false
+		}
+		value, ok := formatter.signaturePlaceholder(spec[1], signature)
+		return value, 2, ok
+	case 'd', 'D':
+		var decorationColors map[string]string
+		if *autoColor {
+			decorationColors = colors
+		}
+		prefix, suffix := " (", ")"
+		if spec[0] == 'D' {
+			prefix, suffix = "", ""
+		}
+		return formatDecorationList(formatter.decorations[hash], formatter.headBranch, formatter.fullRefNames, decorationColors, prefix, suffix), 1, true
+	case 's':
+		subject, _ := splitCommitMessage(commit.Message)
+		return subject, 1, true
+	case 'f':
+		subject, _ := splitCommitMessage(commit.Message)
+		return sanitizePatchSubject(subject), 1, true
+	case 'b':
+		return commitMessageBody(commit.Message), 1, true
+	case 'B':
+		return strings.TrimLeft(commit.Message, "\n"), 1, true
+	case 'C':
+		return formatter.colorPlaceholder(spec, autoColor)
+	}
+	return "", 0, false
+}
+
+// Value of author/committer placeholder (the letter after "a" or "c")
+func (formatter *commitFormatter) signaturePlaceholder(field byte, signature string) (string, bool) {
+	name, email, when, err := parseSignature(signature)
+	if err != nil {
+		return "", true
+	}
+	style := ""
+	switch field {
+	case 'n', 'N':
+		return name, true
+	case 'e', 'E':
+		return email, true
+	case 'l', 'L':
+		local, _, _ := strings.Cut(email, "@")
+		return local, true
+	case 'd':
+		style = formatter.pretty.date
+	case 'D':
+		style = "rfc"
+	case 'i':
+		style = "iso"
+	case 'I':
+		style = "iso-strict"
+	case 's':
+		style = "short"
+	case 't':
+		style = "unix"
+	case 'r':
+		style = "relative"
+	default:
+		return "", false
+	}
+	return formatDate(when, style, formatter.now), true
+}
+
+// Value and length of color placeholder - %Cred, %Cgreen, %Cblue, %Creset or %C(<color>), written only
+// when log is colored (or with "always,")
+func (formatter *commitFormatter) colorPlaceholder(spec string, autoColor *bool) (string, int, bool) {
+	for _, name := range []string{"red", "green", "blue", "reset"} {
+		if strings.HasPrefix(spec[1:], name) {
+			*autoColor = false
+			if formatter.colors == nil {
+				return "", 1 + len(name), true
+			}
+			if name == "reset" {
+				return colorReset, 1 + len(name), true
+			}
+			color, _ := parseColor(name)
+			return color, 1 + len(name), true
+		}
+	}
+	if !strings.HasPrefix(spec, "C(") {
+		return "", 0, false
+	}
+	end := strings.IndexByte(spec, ')')
+	if end == -1 {
+		return "", 0, false
+	}
+	value := spec[2:end]
+	if value == "auto" {
+		*autoColor = true
+		return "", end + 1, true
+	}
+	*autoColor = false
+	always := false
+	if rest, ok := strings.CutPrefix(value, "always,"); ok {
+		value, always = rest, true
+	} else {
+		value = strings.TrimPrefix(value, "auto,")
+	}
+	color, err := parseColor(value)
+	if err != nil {
+		return "", 0, false
+	}
+	if formatter.colors == nil && !always {
+		return "", end + 1, true
+	}
+	if value == "reset" {
+		color = colorReset
+	}
+	return color, end + 1, true
+}
+
+// Body of commit message - what follows the subject paragraph and the empty lines after it
+func commitMessageBody(message string) string {
+	message = strings.TrimLeft(message, "\n")
+	end := strings.Index(message, "\n\n")
+	if end == -1 {
+		return ""
+	}
+	return strings.TrimLeft(message[end:], "\n")
+}
+
+// Formatter writing commits in medium format - what log uses elsewhere (squash messages)
+func newMediumFormatter(colors map[string]string, showSignature bool) *commitFormatter {
+	return &commitFormatter{pretty: prettyFormat{name: "medium"}, colors: colors, showSignature: showSignature, now: time.Now()}
+}
@@ -0,0 +1,162 @@
+package git
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Partial clone - objects filtered out on clone (--filter=blob:none) are fetched from the promisor remote
+// when something needs them (checkout, cat-file...)
+//
+// Clone records the promisor remote in .git/config, like git does:
+//   [core]           repositoryformatversion = 1
+//   [extensions]     partialClone = <remote>
+//   [remote "<remote>"] promisor = true, partialclonefilter = <filter>
+
+// Set while promised objects are fetched - objects missing during that fetch are not fetched again
+var promisorFetchInProgress = false
+
+// Check filter spec - blob:none and blob:limit=<n>[kmg] are supported
+func ValidateFilterSpec(filter string) error {
+	if filter == "blob:none" {
+		return nil
+	}
+	if limit, ok := strings.CutPrefix(filter, "blob:limit="); ok {
+		limit = strings.TrimRight(strings.ToLower(limit), "kmg")
+		if _, err := strconv.ParseUint(limit, 10, 64); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported filter spec: %s", filter)
+}
+
+// Mark remote as promisor of the objects that were filtered out
+func setupPartialClone(remoteName, filter string) error {
+	configPath := gitDirPath("config")
+	values := [][2]string{
+		{"core.repositoryformatversion", "1"},
+		{"extensions.partialclone", remoteName},
+		{"remote." + remoteName + ".promisor", "true"},
+		{"remote." + remoteName + ".partialclonefilter", filter},
+	}
+	for _, value := range values {
+		if err := setConfigValue(configPath, value[0], value[1]); err != nil {
+			return fmt.Errorf("failed to write partial clone config: %w", err)
+		}
+	}
+	return nil
+}
+
+// URL of the promisor remote - empty if repository is not a partial clone
+func promisorRemoteUrl() (string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+
+	remoteName, ok := config.Get("extensions.partialclone")
+	if !ok || remoteName == "" {
+		return "", nil
+	}
+	remoteUrl, ok := config.Get("remote." + remoteName + ".url")
+	if !ok {
+		return "", fmt.Errorf("promisor remote %s has no url", remoteName)
+	}
+	return remoteUrl, nil
+}
+
+// Fetch objects from the promisor remote - returns false if repository is not a partial clone
+func fetchPromisedObjects(ctx context.Context, hashes []string) (bool, error) {
+	if promisorFetchInProgress || len(hashes) == 0 {
+		return false, nil
+	}
+
+	remoteUrl, err := promisorRemoteUrl()
+	if err != nil || remoteUrl == "" {
+		return false, err
+	}
+
+	promisorFetchInProgress = true
+	defer func() { promisorFetchInProgress = false }()
+
+	transport, err := newTransport(remoteUrl, "")
+	if err != nil {
+		return false, err
+	}
+	defer transport.Close()
+
+	// Stateful transports (ssh) expect the refs advertisement to be read first
+	if _, err := transport.Connect(ctx, "git-upload-pack"); err != nil {
+		return false, fmt.Errorf("failed to fetch promised objects: %w", err)
+	}
+	packData, _, _, err := fetchClonePack(ctx, transport, false, hashes, 0, "")
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch promised objects: %w", err)
+	}
+	objects, err := parsePackFile(ctx, packData)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse promised objects: %w", err)
+	}
+	if err := writePackObjects(ctx, objects); err != nil {
+		return false, fmt.Errorf("failed to write promised objects: %w", err)
+	}
+	return true, nil
+}
+
+// Fetch every blob of commit tree that is not in the object database, in a single request
+func fetchMissingBlobs(ctx context.Context, commitHash string) error {
+	treeHash, err := readCommitTreeHash(commitHash)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]TreeEntry)
+	if err := flattenTree(treeHash, "", files); err != nil {
+		return err
+	}
+
+	sparse, err := loadSparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var missing []string
+	for filePath, entry := range files {
+		// Gitlinks point to commits in another repository, and files outside of sparse checkout are not needed
+		if entry.Mode == "160000" || seen[entry.Hash] || !sparse.includes(filePath) {
+			continue
+		}
+		seen[entry.Hash] = true
+
+		exists, err := objectExists(entry.Hash)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			missing = append(missing, entry.Hash)
+		}
+	}
+	sort.Strings(missing)
+
+	_, err = fetchPromisedObjects(ctx, missing)
+	return err
+}
+
+// Check whether object is stored locally (loose or packed), without fetching it
+func objectExists(objectHash string) (bool, error) {
+	if _, loose := looseObjectPath(objectHash); loose {
+		return true, nil
+	}
+
+	hash, err := hex.DecodeString(objectHash)
+	if err != nil || len(hash) != 20 {
+		return false, fmt.Errorf("invalid object name %s", objectHash)
+	}
+	_, _, found, err := findPackedObject(hash)
+	return found, err
+}
@@ -0,0 +1,219 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Git wire protocol v2 (upload-pack side) - https://git-scm.com/docs/protocol-v2
+//
+// Client asks for it with "Git-Protocol: version=2" header. Server answers /info/refs with its capabilities
+// instead of refs, and every following request is a command:
+//
+//	command=<name>\n  <capability lines>  0001 (delim)  <argument lines>  0000 (flush)
+//
+// ls-refs lists refs (only those matching ref-prefix arguments), fetch sends the pack.
+
+// Special pkt-lines - flush ends a message, delim separates its sections, response-end ends a stateless response
+const (
+	PKT_DATA = iota
+	PKT_FLUSH
+	PKT_DELIM
+	PKT_RESPONSE_END
+)
+
+// Read one pkt-line at offset - returns payload, kind of packet and offset of the next packet
+func readPktLine(data []byte, offset int) ([]byte, int, int, error) {
+	if offset+4 > len(data) {
+		return nil, 0, 0, fmt.Errorf("unexpected end of pkt-line stream")
+	}
+	length, err := strconv.ParseUint(string(data[offset:offset+4]), 16, 16)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid pkt-line length at %d", offset)
+	}
+
+	switch length {
+	case 0:
+		return nil, PKT_FLUSH, offset + 4, nil
+	case 1:
+		return nil, PKT_DELIM, offset + 4, nil
+	case 2:
+		return nil, PKT_RESPONSE_END, offset + 4, nil
+	case 3:
+		return nil, 0, 0, fmt.Errorf("invalid pkt-line length at %d", offset)
+	}
+	if offset+int(length) > len(data) {
+		return nil, 0, 0, fmt.Errorf("pkt-line at %d is longer than response", offset)
+	}
+	return data[offset+4 : offset+int(length)], PKT_DATA, offset + int(length), nil
+}
+
+// Check whether /info/refs response is a v2 capability advertisement ("version 2" line)
+func isProtocolV2(advertisement []byte) bool {
+	for offset := 0; offset < len(advertisement); {
+		payload, kind, next, err := readPktLine(advertisement, offset)
+		if err != nil {
+			return false
+		}
+		offset = next
+		if kind != PKT_DATA {
+			continue
+		}
+		line := strings.TrimSuffix(string(payload), "\n")
+		if line == "version 2" {
+			return true
+		}
+		if !strings.HasPrefix(line, "# service=") {
+			// First ref line of v0 advertisement
+			return false
+		}
+	}
+	return false
+}
+
+// Build v2 command request - capabilities are sent before the delimiter, arguments after it
+func buildCommandRequestV2(command string, arguments []string) []byte {
+	var buf bytes.Buffer
+
+	writePktLine(&buf, "command="+command+"\n")
+	writePktLine(&buf, "agent=mini-git\n")
+	buf.WriteString("0001")
+	for _, argument := range arguments {
+		writePktLine(&buf, argument+"\n")
+	}
+	buf.WriteString("0000")
+
+	return buf.Bytes()
+}
+
+// List remote refs with ls-refs command - returns refs (with peeled ^{} entries, like v0 advertisement),
+// HEAD hash and the branch HEAD points to
+func lsRefsV2(ctx context.Context, transport Transport, prefixes []string) (map[string]string, string, string, error) {
+	arguments := []string{"peel", "symrefs"}
+	for _, prefix := range prefixes {
+		arguments = append(arguments, "ref-prefix "+prefix)
+	}
+
+	stream, err := transport.Request(ctx, "git-upload-pack", buildCommandRequestV2("ls-refs", arguments), 2)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("ls-refs failed: %w", err)
+	}
+	response, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("ls-refs failed: %w", err)
+	}
+
+	refs := make(map[string]string)
+	headBranch := ""
+	for offset := 0; offset < len(response); {
+		payload, kind, next, err := readPktLine(response, offset)
+		if err != nil {
+			return nil, "", "", err
+		}
+		offset = next
+		if kind == PKT_FLUSH {
+			break
+		} else if kind != PKT_DATA {
+			continue
+		}
+
+		// <hash> <ref name> [symref-target:<target>] [peeled:<hash>]
+		fields := strings.Fields(string(payload))
+		if len(fields) < 2 {
+			return nil, "", "", fmt.Errorf("malformed ls-refs line: %s", payload)
+		}
+		hash, name := fields[0], fields[1]
+		if hash == "unborn" {
+			continue
+		}
+		refs[name] = hash
+
+		for _, attribute := range fields[2:] {
+			if target, ok := strings.CutPrefix(attribute, "symref-target:"); ok && name == "HEAD" {
+				headBranch = strings.TrimPrefix(target, "refs/heads/")
+			} else if peeled, ok := strings.CutPrefix(attribute, "peeled:"); ok {
+				refs[name+"^{}"] = peeled
+			}
+		}
+	}
+
+	return refs, refs["HEAD"], headBranch, nil
+}
+
+// Build v2 fetch request - same wants/deepen/filter as v0 request, sent as fetch command arguments
+func buildFetchRequestV2(hashes []string, depth int, filter string) []byte {
+	arguments := []string{"ofs-delta"}
+	for _, hash := range hashes {
+		arguments = append(arguments, "want "+hash)
+	}
+	if depth > 0 {
+		if shallow, err := readShallowCommits(); err == nil {
+			for _, hash := range shallow {
+				arguments = append(arguments, "shallow "+hash)
+			}
+		}
+		arguments = append(arguments, fmt.Sprintf("deepen %d", depth))
+	}
+	if filter != "" {
+		arguments = append(arguments, "filter "+filter)
+	}
+	arguments = append(arguments, "done")
+
+	return buildCommandRequestV2("fetch", arguments)
+}
+
+// Read v2 fetch response - sections "shallow-info" (shallow/unshallow lines) and "packfile"
+// Pack is always sent over side-band (see readSideband)
+func readFetchResponseV2(reader *bufio.Reader) ([]byte, []string, []string, error) {
+	var shallow, unshallow []string
+
+	section := ""
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		switch kind {
+		case PKT_FLUSH, PKT_RESPONSE_END:
+			return nil, shallow, unshallow, nil
+		case PKT_DELIM:
+			section = ""
+			continue
+		}
+
+		if section == "" {
+			section = strings.TrimSuffix(string(payload), "\n")
+			if section == "packfile" {
+				pack, err := readSideband(reader)
+				return pack, shallow, unshallow, err
+			}
+			continue
+		}
+
+		if section == "shallow-info" {
+			line := strings.TrimSuffix(string(payload), "\n")
+			if hash, ok := strings.CutPrefix(line, "shallow "); ok {
+				shallow = append(shallow, hash)
+			} else if hash, ok := strings.CutPrefix(line, "unshallow "); ok {
+				unshallow = append(unshallow, hash)
+			}
+		}
+	}
+}
+
+// Read one pkt-line from stream - returns payload (without length) and kind of packet
+func readPktPayload(reader *bufio.Reader) ([]byte, int, error) {
+	packet, _, err := readPktLineFrom(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, kind, _, err := readPktLine(packet, 0)
+	return payload, kind, err
+}
@@ -0,0 +1,99 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Prune - unreachable loose objects are deleted in two phases, so that objects a concurrent command has just
+// written (and not referenced from a ref yet) are not lost:
+//  1. repack -A (as gc runs it) writes unreachable objects of the packs it deletes as loose objects, with the
+//     time of their pack - packs older than the expiry date are not unpacked at all
+//  2. prune deletes unreachable loose objects that are older than the expiry date - gc.pruneExpire
+//     (2.weeks.ago) for gc, `gc --prune=<date>` or `prune --expire=<date>`; prune alone deletes all of them
+//
+// Reachable is everything reachable from refs, HEAD, reflogs, the index and MERGE_HEAD - and from loose
+// objects newer than the expiry date, so that a new commit nothing points to yet doesn't lose the older trees
+// and blobs it uses. "never" keeps everything, "now" deletes every unreachable object.
+
+const defaultPruneExpire = "2.weeks.ago"
+
+// Delete unreachable loose objects older than options.Expire (all of them when empty) - with DryRun or
+// Verbose they are listed as "<hash> <type>". Returns how many were (or would be) deleted.
+func pruneObjects(options PruneOptions, w io.Writer) (int, error) {
+	defer tracePerformance("prune")()
+	defer ignoreReplaceRefs()()
+	expire := time.Unix(1<<62, 0)
+	if options.Expire != "" {
+		var err error
+		if expire, err = parseExpiryDate(options.Expire, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+	if expire.IsZero() {
+		return 0, nil
+	}
+
+	roots, err := reachabilityRoots()
+	if err != nil {
+		return 0, err
+	}
+	loose, err := listLooseObjects()
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for _, hash := range loose {
+		info, err := os.Stat(objectDirPath(hash[:2], hash[2:]))
+		if err != nil {
+			// Removed meanwhile (e.g. by prune-packed)
+			continue
+		}
+		if info.ModTime().After(expire) {
+			roots = append(roots, hash)
+		} else {
+			expired = append(expired, hash)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	reachable := make(map[string]bool)
+	err = walkReachableObjects(roots, func(object GitObject) {
+		reachable[object.Hash] = true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, hash := range expired {
+		if reachable[hash] {
+			continue
+		}
+		if options.DryRun || options.Verbose {
+			typeName, _, _, err := readObjectFromHash(hash)
+			if err != nil {
+				typeName = "unknown"
+			}
+			fmt.Fprintf(w, "%s %s\n", hash, typeName)
+		}
+		pruned++
+		if options.DryRun {
+			continue
+		}
+		if err := os.Remove(objectDirPath(hash[:2], hash[2:])); err != nil && !os.IsNotExist(err) {
+			return pruned, fmt.Errorf("failed to remove loose object %s: %w", hash, err)
+		}
+		os.Remove(objectDirPath(hash[:2]))
+	}
+	if !options.DryRun {
+		if _, err := prunePackedObjects(); err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
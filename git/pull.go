@@ -0,0 +1,196 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Pull - fetch, then integrate what was fetched for merge (FETCH_HEAD entries without "not-for-merge") into
+// the current branch. Without refspecs that is the branch's upstream (branch.<name>.remote and merge).
+// Integration is a merge, with the message git builds from FETCH_HEAD:
+//
+//	Merge branch 'main' of https://example.com/repo [into <branch>]
+//
+// or a rebase onto the fetched commit with --rebase (branch.<name>.rebase, pull.rebase) - commits that were
+// already in the upstream before the fetch (its old remote-tracking ref) are not replayed, even when the
+// upstream was rewritten. Fast-forwards are made without rebasing. --ff, --no-ff and --ff-only (pull.ff)
+// decide about fast-forwards as for merge.
+
+// Fetch and merge (or rebase onto) the fetched refs
+func pullRemote(ctx context.Context, options PullOptions, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	headBranch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	branch := strings.TrimPrefix(headBranch, "refs/heads/")
+
+	// Upstream before the fetch - where our commits forked from it
+	forkPoint := ""
+	if len(options.Refspecs) == 0 {
+		if headBranch == "" {
+			return fmt.Errorf("you are not currently on a branch - specify which branch you want to merge with")
+		}
+		upstreamRemote, _ := config.Get("branch." + branch + ".remote")
+		merge, _ := config.Get("branch." + branch + ".merge")
+		switch {
+		case options.Remote != "" && options.Remote != upstreamRemote:
+			return fmt.Errorf("you asked to pull from the remote '%s', but did not specify a branch - because this is not the default configured remote for your current branch, you must specify a branch on the command line", options.Remote)
+		case merge == "":
+			return fmt.Errorf("there is no tracking information for the current branch - use 'git pull <remote> <branch>' or set it with 'git branch --set-upstream-to=<remote>/<branch> %s'", branch)
+		}
+		if trackingRef, _ := branchUpstream(config, headBranch); trackingRef != "" {
+			if forkPoint, err = resolveRef(trackingRef); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := fetchRemote(ctx, FetchOptions{Remote: options.Remote, Refspecs: options.Refspecs}, w); err != nil {
+		return err
+	}
+	entries, err := readFetchHead()
+	if err != nil {
+		return err
+	}
+	var merged []fetchHeadEntry
+	for _, entry := range entries {
+		if entry.Merge {
+			merged = append(merged, entry)
+		}
+	}
+	if len(merged) == 0 {
+		return fmt.Errorf("there are no candidates for merging among the refs that you just fetched")
+	}
+
+	rebase, interactive := options.Rebase, false
+	if !options.Rebase && !options.NoRebase {
+		value, ok := config.Get("branch." + branch + ".rebase")
+		if !ok || headBranch == "" {
+			value, _ = config.Get("pull.rebase")
+		}
+		switch strings.ToLower(value) {
+		case "true", "yes", "on", "1", "merges", "m":
+			rebase = true
+		case "interactive", "i":
+			rebase, interactive = true, true
+		}
+	}
+	if rebase && head != "" {
+		if len(merged) > 1 {
+			return fmt.Errorf("cannot rebase onto multiple branches")
+		}
+		onto := merged[0].Hash
+		if fastForward, err := isAncestor(head, onto); err != nil {
+			return err
+		} else if !fastForward {
+			upstream := onto
+			if forkPoint != "" {
+				if ok, err := isAncestor(forkPoint, head); err == nil && ok {
+					upstream = forkPoint
+				}
+			}
+			return rebaseStart(RebaseOptions{Upstream: upstream, Onto: onto, Interactive: interactive}, w)
+		}
+	}
+
+	fastForwardMode := options.FastForward
+	if fastForwardMode == "" {
+		switch value, _ := config.Get("pull.ff"); strings.ToLower(value) {
+		case "only":
+			fastForwardMode = "only"
+		case "false":
+			fastForwardMode = "no"
+		}
+	}
+	var commits []string
+	for _, entry := range merged {
+		commits = append(commits, entry.Hash)
+	}
+	return mergeCommits(MergeOptions{Commits: commits, Message: fetchHeadMergeMessage(merged, headBranch), FastForward: fastForwardMode}, w)
+}
+
+// Read FETCH_HEAD - "<hash>\t[not-for-merge]\t<description>" lines
+func readFetchHead() ([]fetchHeadEntry, error) {
+	data, err := os.ReadFile(gitDirPath("FETCH_HEAD"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FETCH_HEAD: %w", err)
+	}
+	var entries []fetchHeadEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		entries = append(entries, fetchHeadEntry{Hash: fields[0], Merge: fields[1] == "", Description: fields[2]})
+	}
+	return entries, nil
+}
+
+// Merge message for fetched refs - "Merge branch 'a' of <url>", refs of one repository grouped by kind
+// ("branches 'a' and 'b', tag 'v1'"), " into <branch>" unless merged into main or master
+func fetchHeadMergeMessage(entries []fetchHeadEntry, branch string) string {
+	kinds := []string{"branch", "tag", "remote-tracking branch", ""}
+	var urls []string
+	groups := make(map[string]map[string][]string)
+	for _, entry := range entries {
+		kind, name, url := "", "", entry.Description
+		// "<kind> '<name>' of <url>", "'<ref>' of <url>" or just the URL (remote HEAD)
+		if quoted, rest, ok := strings.Cut(entry.Description, " of "); ok && strings.HasSuffix(quoted, "'") {
+			url = rest
+			if prefix, quotedName, ok := strings.Cut(quoted, " '"); ok {
+				kind, name = prefix, "'"+quotedName
+			} else {
+				name = quoted
+			}
+		}
+		if groups[url] == nil {
+			groups[url] = make(map[string][]string)
+			urls = append(urls, url)
+		}
+		if name != "" {
+			groups[url][kind] = append(groups[url][kind], name)
+		}
+	}
+
+	var sources []string
+	for _, url := range urls {
+		var parts []string
+		for _, kind := range kinds {
+			group := groups[url][kind]
+			if len(group) == 0 {
+				continue
+			}
+			names := group[0]
+			if len(group) > 1 {
+				names = strings.Join(group[:len(group)-1], ", ") + " and " + group[len(group)-1]
+			}
+			switch {
+			case kind == "":
+				parts = append(parts, names)
+			case len(group) == 1:
+				parts = append(parts, kind+" "+names)
+			case strings.HasSuffix(kind, "branch"):
+				parts = append(parts, kind+"es "+names)
+			default:
+				parts = append(parts, kind+"s "+names)
+			}
+		}
+		if len(parts) == 0 {
+			sources = append(sources, url)
+		} else {
+			sources = append(sources, strings.Join(parts, ", ")+" of "+url)
+		}
+	}
+	message := "Merge " + strings.Join(sources, "; ")
+	if branch = strings.TrimPrefix(branch, "refs/heads/"); branch != "" && branch != "main" && branch != "master" {
+		message += " into " + branch
+	}
+	return message + "\n"
+}
@@ -0,0 +1,597 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Push - local refs are mapped to remote refs by refspecs (the ones given, remote.<name>.push, or the
+// current branch as push.default says), the remote gets the objects it doesn't have and updates its refs
+// (receive-pack, see receivepack.go). Each ref is reported:
+//
+//	To /path/to/remote
+//	 * [new branch]      topic -> topic
+//	   1a2b3c4..5d6e7f8  main -> main
+//	 - [deleted]         old
+//	 ! [rejected]        stale -> stale (non-fast-forward)
+//	 ! [remote rejected] wip -> wip (deletion prohibited)
+//
+// Updates that would lose commits on the remote need "+" (or --force), and so do updates of remote refs whose
+// commits are not here (fetch first) and of existing tags. --force-with-lease forces updates only of remote
+// refs that are still where we last saw them (their remote-tracking refs) - what someone else pushed
+// meanwhile is not overwritten. Pushing nothing (":<ref>", --delete) deletes the remote ref, --tags pushes
+// every tag, and --mirror force pushes every ref and deletes remote refs that don't exist here. With --atomic,
+// the remote updates all refs or none (a ref rejected here fails the others before anything is sent).
+// Remote-tracking refs of pushed refs are updated as if they had been fetched.
+
+// Push to remote selected by options, writing updated refs to w
+func pushRemote(ctx context.Context, options PushOptions, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	headBranch, _, err := readHead()
+	if err != nil {
+		return err
+	}
+	branch := strings.TrimPrefix(headBranch, "refs/heads/")
+
+	remote := options.Remote
+	for _, key := range []string{"branch." + branch + ".pushRemote", "remote.pushDefault", "branch." + branch + ".remote"} {
+		if remote != "" {
+			break
+		}
+		if strings.HasPrefix(key, "branch.") && headBranch == "" {
+			continue
+		}
+		remote, _ = config.Get(key)
+	}
+	if remote == "" {
+		remote = "origin"
+	}
+
+	refspecs := options.Refspecs
+	// Configured mirror is pushed to as a mirror, unless refspecs (or --tags) say what to push
+	options.Mirror = options.Mirror || (len(refspecs) == 0 && !options.Tags && config.GetBool("remote."+remote+".mirror", false))
+	if options.Mirror {
+		switch {
+		case options.Tags:
+			return fmt.Errorf("--mirror and --tags are incompatible")
+		case options.Delete:
+			return fmt.Errorf("--delete is incompatible with --mirror")
+		case len(refspecs) > 0:
+			return fmt.Errorf("--mirror can't be combined with refspecs")
+		}
+		refspecs = []string{mirrorRefspec}
+	}
+	if options.Delete {
+		if len(refspecs) == 0 {
+			return fmt.Errorf("--delete doesn't make sense without any refs")
+		}
+		// Deleting is pushing nothing to the refs
+		refspecs = nil
+		for _, refName := range options.Refspecs {
+			if strings.Contains(refName, ":") {
+				return fmt.Errorf("--delete only accepts plain target ref names")
+			}
+			refspecs = append(refspecs, ":"+refName)
+		}
+	}
+	if options.Tags {
+		refspecs = append(refspecs, "refs/tags/*:refs/tags/*")
+	}
+	var specs []Refspec
+	if len(refspecs) > 0 {
+		specs, err = parseRefspecs(refspecs)
+	} else {
+		specs, err = defaultPushRefspecs(config, remote, headBranch)
+	}
+	if err != nil {
+		return err
+	}
+	options.Atomic = options.Atomic || config.GetBool("push.atomic", false)
+	if options.Force {
+		for i := range specs {
+			specs[i].Force = true
+		}
+	}
+
+	urls := []string{resolveRemoteUrl(remote)}
+	_, hasUrl := config.Get("remote." + remote + ".url")
+	if _, hasPushUrl := config.Get("remote." + remote + ".pushurl"); hasUrl || hasPushUrl {
+		urls = nil
+		for _, url := range remotePushUrls(config, remote) {
+			urls = append(urls, absoluteRemoteUrl(url))
+		}
+	}
+	// Remote-tracking refs only exist for configured remotes
+	trackingSpecs, err := parseRefspecs(config.GetAll("remote." + remote + ".fetch"))
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	nonFastForward := false
+	for _, url := range urls {
+		rejected, lostCommits, err := pushToUrl(ctx, remote, url, specs, trackingSpecs, options, w)
+		if err != nil {
+			return err
+		}
+		if rejected {
+			failed = append(failed, url)
+		}
+		nonFastForward = nonFastForward || lostCommits
+	}
+	if len(failed) > 0 && nonFastForward {
+		return fmt.Errorf("failed to push some refs to '%s': %w", strings.Join(failed, "', '"), ErrNonFastForward)
+	} else if len(failed) > 0 {
+		return fmt.Errorf("failed to push some refs to '%s'", strings.Join(failed, "', '"))
+	}
+	return nil
+}
+
+// Refspecs pushed when none are given - remote.<name>.push, otherwise push.default decides what happens to
+// the current branch: simple (default) and current push it to the branch of the same name, upstream to
+// its upstream, matching pushes every branch that exists on both sides, nothing refuses to push
+func defaultPushRefspecs(config *Config, remote, headBranch string) ([]Refspec, error) {
+	if configured := config.GetAll("remote." + remote + ".push"); len(configured) > 0 {
+		return parseRefspecs(configured)
+	}
+	mode, _ := config.Get("push.default")
+	switch mode = strings.ToLower(mode); mode {
+	case "nothing":
+		return nil, fmt.Errorf("you didn't specify any refspecs to push, and push.default is \"nothing\"")
+	case "matching":
+		return []Refspec{{Matching: true}}, nil
+	}
+	if headBranch == "" {
+		return nil, fmt.Errorf("you are not currently on a branch")
+	}
+
+	branch := strings.TrimPrefix(headBranch, "refs/heads/")
+	upstreamRemote, _ := config.Get("branch." + branch + ".remote")
+	merge, _ := config.Get("branch." + branch + ".merge")
+	switch {
+	case mode == "upstream" && (merge == "" || upstreamRemote != remote):
+		return nil, fmt.Errorf("the current branch %s has no upstream branch on '%s'", branch, remote)
+	case mode == "upstream":
+		return []Refspec{{Source: headBranch, Destination: merge}}, nil
+	case mode != "current" && upstreamRemote == remote && merge != "" && merge != headBranch:
+		return nil, fmt.Errorf("the upstream branch of your current branch does not match the name of your current branch - use 'git push %s HEAD:%s' to push to its upstream", remote, strings.TrimPrefix(merge, "refs/heads/"))
+	}
+	return []Refspec{{Source: headBranch, Destination: headBranch}}, nil
+}
+
+// Push refs selected by specs to url of remote - reports whether some were rejected, and whether a rejected
+// one would have lost commits on the remote. Refs that were updated move their remote-tracking refs (mapped
+// through trackingSpecs), which also give the leases of options.
+func pushToUrl(ctx context.Context, remote, url string, specs, trackingSpecs []Refspec, options PushOptions, w io.Writer) (bool, bool, error) {
+	// Objects are sent as they are stored - the receiver has its own replace refs
+	defer ignoreReplaceRefs()()
+	transport, err := newTransport(url, "")
+	if err != nil {
+		return false, false, fmt.Errorf("failed to connect to remote: %w", err)
+	}
+	defer transport.Close()
+	advertisement, err := transport.Connect(ctx, "git-receive-pack")
+	if err != nil {
+		return false, false, fmt.Errorf("failed to fetch refs: %w", err)
+	}
+	remoteRefs, capabilities, err := parseRefs(advertisement)
+	if err != nil {
+		return false, false, err
+	}
+	// Empty repository advertises its capabilities on a placeholder
+	delete(remoteRefs, "capabilities^{}")
+
+	localRefs, err := listRefs("refs/")
+	if err != nil {
+		return false, false, err
+	}
+	mappings, err := mapPushRefspecs(specs, localRefs, remoteRefs)
+	if err != nil {
+		return false, false, err
+	}
+	// Mirror deletes remote refs that are gone here (remote refs with invalid names are left alone)
+	if options.Mirror {
+		remoteMirror, err := mapMirrorRefs(remoteRefs)
+		if err != nil {
+			return false, false, err
+		}
+		for _, mirrored := range remoteMirror {
+			if _, ok := localRefs[mirrored.Destination]; !ok {
+				mappings = append(mappings, refMapping{Destination: mirrored.Destination, Force: true})
+			}
+		}
+	}
+	leases, err := resolvePushLeases(options, mappings, trackingSpecs)
+	if err != nil {
+		return false, false, err
+	}
+	var updates, commands []*pushRefUpdate
+	for _, mapping := range mappings {
+		old := remoteRefs[mapping.Destination]
+		// Remote ref has to be where the lease expects it - the update may then lose commits. The old value
+		// sent with the command is the expected one, so the remote refuses it if the ref moved meanwhile.
+		if expected, ok := leases[mapping.Destination]; ok {
+			if old != expected {
+				updates = append(updates, &pushRefUpdate{Source: mapping.Source, Destination: mapping.Destination, Old: old, New: mapping.Hash,
+					Flag: '!', Summary: "[rejected]", Reason: "stale info"})
+				continue
+			}
+			mapping.Force = true
+		}
+		update, err := checkPushUpdate(mapping, old)
+		if err != nil {
+			return false, false, err
+		}
+		updates = append(updates, update)
+		if update.Flag != '=' && update.Flag != '!' {
+			commands = append(commands, update)
+		}
+	}
+
+	if options.Atomic && !strings.Contains(" "+capabilities+" ", " atomic ") {
+		return false, false, fmt.Errorf("the receiving end does not support --atomic push")
+	}
+	// Atomic push sends nothing when a ref is rejected already - the others fail with it
+	if options.Atomic && len(commands) < len(updates) {
+		for _, update := range updates {
+			if update.Flag == '!' {
+				for _, command := range commands {
+					command.Flag, command.Summary, command.Reason = '!', "[rejected]", "atomic push failed"
+				}
+				commands = nil
+				break
+			}
+		}
+	}
+	if len(commands) > 0 && !options.NoVerify {
+		if err := runPrePushHook(remote, url, commands); err != nil {
+			return false, false, err
+		}
+	}
+	if len(commands) > 0 {
+		if err := sendPushCommands(ctx, transport, commands, remoteRefs, capabilities, options.Atomic); err != nil {
+			return false, false, err
+		}
+	}
+	rejected := reportPushUpdates(updates, url, w)
+	nonFastForward := false
+	for _, update := range updates {
+		// Remote has commits we don't (fetch first), or that the update would drop
+		nonFastForward = nonFastForward || (update.Flag == '!' &&
+			(update.Reason == "non-fast-forward" || update.Reason == "fetch first"))
+	}
+
+	// Remote refs are now what was pushed - their remote-tracking refs follow
+	for _, update := range commands {
+		if update.Flag == '!' {
+			continue
+		}
+		trackingRef, _ := mapRefThroughRefspecs(trackingSpecs, update.Destination)
+		switch {
+		case trackingRef == "":
+		case update.New == "":
+			err = deleteRef(trackingRef)
+		default:
+			err = updateRef(trackingRef, update.New, "update by push")
+		}
+		if err != nil {
+			return rejected, nonFastForward, err
+		}
+	}
+	return rejected, nonFastForward, nil
+}
+
+// Run pre-push hook with remote (name, or URL when pushed to directly) and url as arguments, and a
+// "<local ref> <local hash> <remote ref> <remote hash>" line for every ref to update on stdin - deleted refs
+// are "(delete)" with zero hash, refs the remote doesn't have yet get zero remote hash. Its failure stops
+// the push before anything is sent.
+func runPrePushHook(remote, url string, commands []*pushRefUpdate) error {
+	var lines strings.Builder
+	for _, command := range commands {
+		localRef, localHash, remoteHash := command.Source, command.New, command.Old
+		if command.New == "" {
+			localRef, localHash = "(delete)", zeroHash
+		}
+		if remoteHash == "" {
+			remoteHash = zeroHash
+		}
+		fmt.Fprintf(&lines, "%s %s %s %s\n", localRef, localHash, command.Destination, remoteHash)
+	}
+	if err := runHook("pre-push", strings.NewReader(lines.String()), remote, url); err != nil {
+		return fmt.Errorf("failed to push some refs to '%s': %w", url, err)
+	}
+	return nil
+}
+
+// Values remote refs are expected to have for --force-with-lease ("" when the ref must not exist) - a lease
+// without a value expects the remote-tracking ref of the remote ref
+func resolvePushLeases(options PushOptions, mappings []refMapping, trackingSpecs []Refspec) (map[string]string, error) {
+	leases := make(map[string]string)
+	trackingValue := func(refName string) (string, error) {
+		trackingRef, _ := mapRefThroughRefspecs(trackingSpecs, refName)
+		if trackingRef == "" {
+			return "", nil
+		}
+		return resolveRef(trackingRef)
+	}
+	if options.ForceWithLease {
+		for _, mapping := range mappings {
+			value, err := trackingValue(mapping.Destination)
+			if err != nil {
+				return nil, err
+			}
+			leases[mapping.Destination] = value
+		}
+	}
+
+	for _, lease := range options.Leases {
+		name, expected, explicit := strings.Cut(lease, ":")
+		for _, mapping := range mappings {
+			if _, ok := matchRefspecSide(name, false, mapping.Destination); !ok {
+				continue
+			}
+			value := ""
+			switch {
+			case !explicit:
+				var err error
+				if value, err = trackingValue(mapping.Destination); err != nil {
+					return nil, err
+				}
+			case expected != "":
+				_, hash, err := resolveRevision(expected)
+				if err != nil {
+					return nil, fmt.Errorf("cannot parse expected object name '%s'", expected)
+				}
+				value = hash
+			}
+			leases[mapping.Destination] = value
+		}
+	}
+	return leases, nil
+}
+
+// Decide what push does to remote ref of mapping, which currently is at old ("" when it doesn't exist)
+func checkPushUpdate(mapping refMapping, old string) (*pushRefUpdate, error) {
+	update := &pushRefUpdate{Source: mapping.Source, Destination: mapping.Destination, Old: old, New: mapping.Hash, Force: mapping.Force}
+	switch {
+	case update.New == "":
+		update.Flag, update.Summary = '-', "[deleted]"
+	case update.New == old:
+		update.Flag, update.Summary = '=', "[up to date]"
+	case old == "" && strings.HasPrefix(update.Destination, "refs/tags/"):
+		update.Flag, update.Summary = '*', "[new tag]"
+	case old == "" && strings.HasPrefix(update.Destination, "refs/heads/"):
+		update.Flag, update.Summary = '*', "[new branch]"
+	case old == "":
+		update.Flag, update.Summary = '*', "[new reference]"
+	case strings.HasPrefix(update.Destination, "refs/tags/") && !update.Force:
+		update.Flag, update.Summary, update.Reason = '!', "[rejected]", "already exists"
+	default:
+		// Remote commit has to be here to know whether it is in the history of the pushed one
+		known, err := objectExists(old)
+		if err != nil {
+			return nil, err
+		}
+		fastForward := false
+		if known {
+			if fastForward, err = isAncestor(old, update.New); err != nil {
+				fastForward = false
+			}
+		}
+		switch {
+		case fastForward:
+			update.Flag, update.Summary = ' ', shortHash(old)+".."+shortHash(update.New)
+		case !update.Force && !known:
+			update.Flag, update.Summary, update.Reason = '!', "[rejected]", "fetch first"
+		case !update.Force:
+			update.Flag, update.Summary, update.Reason = '!', "[rejected]", "non-fast-forward"
+		default:
+			update.Flag, update.Summary, update.Reason = '+', shortHash(old)+"..."+shortHash(update.New), "forced update"
+		}
+	}
+	return update, nil
+}
+
+// Send ref update commands and the pack of objects the remote lacks, then read report-status - commands
+// the remote refused are marked rejected
+func sendPushCommands(ctx context.Context, transport Transport, commands []*pushRefUpdate, remoteRefs map[string]string, advertised string, atomic bool) error {
+	supported := make(map[string]bool)
+	for _, capability := range strings.Fields(advertised) {
+		supported[capability] = true
+	}
+	var requested []string
+	for _, capability := range []string{"report-status", "side-band-64k"} {
+		if supported[capability] {
+			requested = append(requested, capability)
+		}
+	}
+	// Remote applies all updates in one transaction
+	if atomic {
+		requested = append(requested, "atomic")
+	}
+	requested = append(requested, "agent=mini-git")
+
+	var request bytes.Buffer
+	var wants []string
+	for i, command := range commands {
+		oldHash, newHash := command.Old, command.New
+		if oldHash == "" {
+			oldHash = zeroHash
+		}
+		if newHash == "" {
+			newHash = zeroHash
+		} else {
+			wants = append(wants, newHash)
+		}
+		line := fmt.Sprintf("%s %s %s", oldHash, newHash, command.Destination)
+		if i == 0 {
+			line += "\x00" + strings.Join(requested, " ")
+		}
+		writePktLine(&request, line+"\n")
+	}
+	request.WriteString("0000")
+
+	// Deleting refs needs no pack
+	if len(wants) > 0 {
+		var haves []string
+		for _, hash := range remoteRefs {
+			if exists, err := objectExists(hash); err != nil {
+				return err
+			} else if exists {
+				haves = append(haves, hash)
+			}
+		}
+		objects, err := collectPushObjects(wants, haves)
+		if err != nil {
+			return err
+		}
+		request.Write(buildPackData(objects, loadPackCompression(supported["ofs-delta"])))
+	}
+
+	stream, err := transport.Request(ctx, "git-receive-pack", request.Bytes(), 0)
+	if err != nil {
+		return fmt.Errorf("git-receive-pack request failed: %w", err)
+	}
+	defer stream.Close()
+	if !supported["report-status"] {
+		_, err := io.Copy(io.Discard, stream)
+		return err
+	}
+
+	reader := bufio.NewReader(stream)
+	if supported["side-band-64k"] {
+		report, err := readSideband(reader)
+		if err != nil {
+			return err
+		}
+		reader = bufio.NewReader(bytes.NewReader(report))
+	}
+	unpackStatus, statuses, err := readReportStatus(reader)
+	if err != nil {
+		return err
+	}
+	for _, command := range commands {
+		reason, reported := statuses[command.Destination]
+		switch {
+		case unpackStatus != "ok":
+			command.Flag, command.Summary, command.Reason = '!', "[remote rejected]", "unpacker error: "+unpackStatus
+		case !reported:
+			command.Flag, command.Summary, command.Reason = '!', "[remote failure]", "remote failed to report status"
+		case reason != "":
+			command.Flag, command.Summary, command.Reason = '!', "[remote rejected]", reason
+		}
+	}
+	return nil
+}
+
+// Read report-status - "unpack <status>", then "ok <ref>" or "ng <ref> <reason>" per command. Returns the
+// unpack status and reason per ref ("" for refs that were updated).
+func readReportStatus(reader *bufio.Reader) (string, map[string]string, error) {
+	unpackStatus := ""
+	statuses := make(map[string]string)
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err == io.EOF || (err == nil && kind == PKT_FLUSH) {
+			break
+		} else if err != nil {
+			return "", nil, fmt.Errorf("failed to read report-status: %w", err)
+		}
+		line := strings.TrimSuffix(string(payload), "\n")
+		if status, ok := strings.CutPrefix(line, "unpack "); ok {
+			unpackStatus = status
+		} else if refName, ok := strings.CutPrefix(line, "ok "); ok {
+			statuses[refName] = ""
+		} else if rest, ok := strings.CutPrefix(line, "ng "); ok {
+			refName, reason, _ := strings.Cut(rest, " ")
+			statuses[refName] = reason
+		}
+	}
+	if unpackStatus == "" {
+		return "", nil, fmt.Errorf("protocol error: no unpack status in report-status")
+	}
+	return unpackStatus, statuses, nil
+}
+
+// Write what happened to each ref ("Everything up-to-date" when nothing changed) - reports whether some
+// were rejected
+func reportPushUpdates(updates []*pushRefUpdate, url string, w io.Writer) bool {
+	// Refs the remote had come first, by name, new refs follow in the order they were pushed
+	updates = slices.Clone(updates)
+	slices.SortStableFunc(updates, func(a, b *pushRefUpdate) int {
+		if (a.Old == "") != (b.Old == "") {
+			if a.Old == "" {
+				return 1
+			}
+			return -1
+		}
+		if a.Old == "" {
+			return 0
+		}
+		return strings.Compare(a.Destination, b.Destination)
+	})
+	rejected, reported := false, false
+	for _, update := range updates {
+		if update.Flag == '=' {
+			continue
+		}
+		rejected = rejected || update.Flag == '!'
+		if !reported {
+			fmt.Fprintf(w, "To %s\n", url)
+			reported = true
+		}
+		line := fmt.Sprintf(" %c %-*s ", update.Flag, fetchSummaryWidth, update.Summary)
+		if update.New == "" {
+			line += shortRefName(update.Destination)
+		} else {
+			line += shortRefName(update.Source) + " -> " + shortRefName(update.Destination)
+		}
+		if update.Reason != "" {
+			line += " (" + update.Reason + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+	if !reported {
+		fmt.Fprintln(w, "Everything up-to-date")
+	}
+	return rejected
+}
+
+// Objects reachable from wants that are not reachable from haves - remote refs known here, the remote has
+// everything they reach
+func collectPushObjects(wants, haves []string) ([]GitObject, error) {
+	defer tracePerformance("collect push objects")()
+	if hashes, ok, err := bitmapObjects(wants, haves); err != nil {
+		return nil, err
+	} else if ok {
+		return readGitObjects(hashes)
+	}
+	excluded := make(map[string]bool)
+	if len(haves) > 0 {
+		objects, _, err := collectUploadObjects(haves, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			excluded[object.Hash] = true
+		}
+	}
+	objects, _, err := collectUploadObjects(wants, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	var missing []GitObject
+	for _, object := range objects {
+		if !excluded[object.Hash] {
+			missing = append(missing, object)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,623 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// rebase - replay commits of the current branch that upstream doesn't have on top of upstream (or --onto).
+// HEAD is detached at the new base, the commits are picked one by one (see pickCommit), and at the end the
+// branch is moved to the result and checked out again. Merge commits are left out (their changes come with
+// the commits they merged), commits whose changes upstream already has end up empty and are dropped.
+//
+// The commits to pick are a todo list, "<command> <commit> <subject>" per line. With -i the list goes to the
+// editor first (GIT_SEQUENCE_EDITOR, see editorCommand), where lines can be reordered, removed or given
+// another command:
+//
+//	p, pick    use commit
+//	r, reword  use commit, but edit the commit message
+//	e, edit    use commit, but stop for amending (staged changes are added to it on --continue)
+//	s, squash  use commit, but meld into previous commit (messages are joined and edited)
+//	f, fixup   like squash, but keep only the previous commit's message
+//	d, drop    remove commit
+//
+// State lives in .git/rebase-merge while commits are picked:
+//   - head-name        - branch being rebased (refs/heads/<name>, or "detached HEAD")
+//   - onto             - the new base
+//   - orig-head        - HEAD before rebase started (for --abort)
+//   - interactive      - marks rebase -i
+//   - git-rebase-todo  - lines still to do
+//   - done             - lines already done
+//   - stopped-sha      - commit whose pick stopped with conflicts
+//   - amend            - HEAD when rebase stopped at an edit line
+//   - message-squash   - message of the commit a squash/fixup chain is melding into
+//   - squash-edit      - marks that the chain had a squash, so its message is edited when the chain ends
+//   - rewritten-list   - "<old> <new>" per rewritten commit, given to the post-rewrite hook
+//
+// When a pick stops with conflicts, the user resolves them, adds the files and runs rebase --continue (which
+// commits the index with the stopped commit's author and message), or --skip to drop the commit, or --abort
+// to get back to where the rebase started.
+
+// Todo list commands by name and abbreviation
+var rebaseCommands = map[string]string{
+	"p": "pick", "pick": "pick",
+	"r": "reword", "reword": "reword",
+	"e": "edit", "edit": "edit",
+	"s": "squash", "squash": "squash",
+	"f": "fixup", "fixup": "fixup",
+	"d": "drop", "drop": "drop",
+}
+
+// Help below the todo list given to the editor
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash", but discard this commit's log message
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+// Start rebase of HEAD onto options.Onto (options.Upstream when not set)
+func rebaseStart(options RebaseOptions, w io.Writer) error {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(gitDirPath(dir)); err == nil {
+			return fmt.Errorf("a rebase is already in progress (%s exists) - use --continue, --skip or --abort", gitDirPath(dir))
+		}
+	}
+
+	upstream, err := resolveCommitRevision(options.Upstream)
+	if err != nil {
+		return fmt.Errorf("invalid upstream '%s': %w", options.Upstream, err)
+	}
+	onto := upstream
+	if options.Onto != "" {
+		if onto, err = resolveCommitRevision(options.Onto); err != nil {
+			return fmt.Errorf("does not point to a valid commit '%s': %w", options.Onto, err)
+		}
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("cannot rebase an unborn branch")
+	}
+	status, err := computeStatus(false)
+	if err != nil {
+		return err
+	}
+	if len(status.Unstaged) > 0 {
+		return fmt.Errorf("cannot rebase: You have unstaged changes.\nPlease commit or stash them.")
+	}
+	if len(status.Staged) > 0 {
+		return fmt.Errorf("cannot rebase: Your index contains uncommitted changes.\nPlease commit or stash them.")
+	}
+
+	// Oldest commit first - revList lists children before their parents
+	commits, err := revList([]string{head}, []string{upstream})
+	if err != nil {
+		return err
+	}
+	slices.Reverse(commits)
+	var todo strings.Builder
+	upToDate, previous := true, onto
+	for _, commit := range commits {
+		parsed, err := readCommit(commit)
+		if err != nil {
+			return err
+		}
+		if len(parsed.Parents) > 1 {
+			upToDate = false
+			continue
+		}
+		if len(parsed.Parents) == 0 || parsed.Parents[0] != previous {
+			upToDate = false
+		}
+		previous = commit
+		subject, _ := splitCommitMessage(parsed.Message)
+		fmt.Fprintf(&todo, "pick %s %s\n", commit, subject)
+	}
+
+	headName := branch
+	if headName == "" {
+		headName = "detached HEAD"
+	}
+	if upToDate && previous == head && !options.Interactive {
+		fmt.Fprintf(w, "Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+		return nil
+	}
+
+	stateDir := gitDirPath("rebase-merge")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", stateDir, err)
+	}
+	state := map[string]string{"head-name": headName + "\n", "onto": onto + "\n", "orig-head": head + "\n", "git-rebase-todo": todo.String(), "done": ""}
+	if options.Interactive {
+		state["interactive"] = ""
+	}
+	for name, value := range state {
+		if err := os.WriteFile(filepath.Join(stateDir, name), []byte(value), 0644); err != nil {
+			return err
+		}
+	}
+	if options.Interactive {
+		if err := editRebaseTodo(upstream, head, onto); err != nil {
+			os.RemoveAll(stateDir)
+			return err
+		}
+	}
+
+	// Commits are picked on detached HEAD at the new base - the branch moves only when all of them are done
+	headFiles, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	ontoFiles, err := commitFiles(onto)
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(headFiles, ontoFiles, ontoFiles, "rebase"); err != nil {
+		os.RemoveAll(stateDir)
+		return err
+	}
+	if err := updateRef("HEAD", onto, "rebase (start): checkout "+onto); err != nil {
+		return err
+	}
+	return rebaseRun(w)
+}
+
+// Let the user edit the todo list - it is checked and written back with full commit hashes
+func editRebaseTodo(upstream, head, onto string) error {
+	todoFile := gitDirPath("rebase-merge", "git-rebase-todo")
+	todo, err := os.ReadFile(todoFile)
+	if err != nil {
+		return err
+	}
+	count := strings.Count(string(todo), "\n")
+	// Short hashes are easier to read - they are expanded again below
+	var short strings.Builder
+	for _, line := range strings.SplitAfter(string(todo), "\n") {
+		if fields := strings.SplitN(line, " ", 3); len(fields) == 3 {
+			line = fields[0] + " " + shortHash(fields[1]) + " " + fields[2]
+		}
+		short.WriteString(line)
+	}
+	plural := "s"
+	if count == 1 {
+		plural = ""
+	}
+	help := fmt.Sprintf("\n# Rebase %s..%s onto %s (%d command%s)\n", shortHash(upstream), shortHash(head), shortHash(onto), count, plural)
+	if err := os.WriteFile(todoFile, []byte(short.String()+help+rebaseTodoHelp), 0644); err != nil {
+		return err
+	}
+	if err := launchEditor(todoFile, true); err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(todoFile)
+	if err != nil {
+		return err
+	}
+	var expanded strings.Builder
+	commands := 0
+	for _, line := range strings.Split(string(edited), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		command, commit, subject, err := parseRebaseTodoLine(line)
+		if err != nil {
+			return err
+		}
+		if commands == 0 && (command == "squash" || command == "fixup") {
+			return fmt.Errorf("cannot '%s' without a previous commit", command)
+		}
+		fmt.Fprintf(&expanded, "%s %s %s\n", command, commit, subject)
+		commands++
+	}
+	if commands == 0 {
+		return fmt.Errorf("nothing to do")
+	}
+	return os.WriteFile(todoFile, []byte(expanded.String()), 0644)
+}
+
+// Split todo line into command (full name), commit hash and subject
+func parseRebaseTodoLine(line string) (string, string, string, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", "", fmt.Errorf("invalid line in todo list: %s", line)
+	}
+	command, ok := rebaseCommands[fields[0]]
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid command '%s' in todo list: %s", fields[0], line)
+	}
+	commit, err := resolveCommitRevision(fields[1])
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid commit '%s' in todo list: %w", fields[1], err)
+	}
+	return command, commit, strings.Join(fields[2:], " "), nil
+}
+
+// Do lines of the todo list until it is empty (or a line stops the rebase), then finish the rebase
+func rebaseRun(w io.Writer) error {
+	stateDir := gitDirPath("rebase-merge")
+	for {
+		todo, err := os.ReadFile(filepath.Join(stateDir, "git-rebase-todo"))
+		if err != nil {
+			return fmt.Errorf("failed to read todo list: %w", err)
+		}
+		line, rest, _ := strings.Cut(string(todo), "\n")
+		if strings.TrimSpace(line) == "" && rest == "" {
+			break
+		}
+		if err := appendRebaseState("done", line+"\n"); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(stateDir, "git-rebase-todo"), []byte(rest), 0644); err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		command, commit, subject, err := parseRebaseTodoLine(line)
+		if err != nil {
+			return err
+		}
+		if command == "drop" {
+			continue
+		}
+		_, head, err := readHead()
+		if err != nil {
+			return err
+		}
+		_, conflicts, err := pickCommit(commit, pickOptions{Operation: "rebase", FastForward: true}, w)
+		if err != nil {
+			return err
+		}
+		if conflicts {
+			if err := os.WriteFile(filepath.Join(stateDir, "stopped-sha"), []byte(commit+"\n"), 0644); err != nil {
+				return err
+			}
+			return fmt.Errorf("could not apply %s... %s\n"+
+				"Resolve all conflicts manually, mark them as resolved with \"git add\", then run \"git rebase --continue\".\n"+
+				"You can instead skip this commit: run \"git rebase --skip\".\n"+
+				"To abort and get back to the state before \"git rebase\", run \"git rebase --abort\".", shortHash(commit), subject)
+		}
+		if stop, err := rebaseFinishLine(command, commit, head, w); err != nil || stop {
+			return err
+		}
+	}
+	return rebaseFinish(w)
+}
+
+// Do what the line's command does after its commit was picked onto previousHead (and committed, unless its
+// changes were already there) - returns whether the rebase stops here
+func rebaseFinishLine(command, commit, previousHead string, w io.Writer) (bool, error) {
+	_, head, err := readHead()
+	if err != nil {
+		return false, err
+	}
+	parsed, err := readCommit(commit)
+	if err != nil {
+		return false, err
+	}
+
+	switch command {
+	case "reword":
+		if head == previousHead {
+			break
+		}
+		message, err := editCommitMessage(parsed.Message)
+		if err != nil {
+			return false, err
+		}
+		if head, err = amendHead("", message); err != nil {
+			return false, err
+		}
+	case "squash", "fixup":
+		if head, err = meldIntoPrevious(command, parsed, previousHead); err != nil {
+			return false, err
+		}
+	}
+	if head != previousHead && head != commit {
+		if err := appendRebaseState("rewritten-list", commit+" "+head+"\n"); err != nil {
+			return false, err
+		}
+	}
+
+	// Squash/fixup chain ends when the next line isn't one of them - then its message is edited
+	if command == "squash" || command == "fixup" {
+		todo, err := os.ReadFile(gitDirPath("rebase-merge", "git-rebase-todo"))
+		if err != nil {
+			return false, err
+		}
+		next, _, _ := strings.Cut(strings.TrimSpace(string(todo)), " ")
+		if next := rebaseCommands[next]; next != "squash" && next != "fixup" {
+			if err := finishSquashChain(); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if command == "edit" {
+		if err := os.WriteFile(gitDirPath("rebase-merge", "amend"), []byte(head+"\n"), 0644); err != nil {
+			return false, err
+		}
+		subject, _ := splitCommitMessage(parsed.Message)
+		fmt.Fprintf(w, "Stopped at %s...  %s\n"+
+			"You can amend the commit now - stage the changes and run\n\n"+
+			"  git rebase --continue\n", shortHash(commit), subject)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Replace the commit rebase was at before squash/fixup line (previousHead) and the picked commit at HEAD with
+// one commit of HEAD's tree - returns the new commit
+func meldIntoPrevious(command string, picked *Commit, previousHead string) (string, error) {
+	previous, err := readCommit(previousHead)
+	if err != nil {
+		return "", err
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return "", err
+	}
+	treeHash, err := readCommitTreeHash(head)
+	if err != nil {
+		return "", err
+	}
+
+	message, err := readRebaseState("message-squash")
+	if err != nil {
+		message = strings.TrimRight(previous.Message, "\n")
+	}
+	if command == "squash" {
+		message += "\n\n" + strings.TrimRight(picked.Message, "\n")
+		if err := os.WriteFile(gitDirPath("rebase-merge", "squash-edit"), nil, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := os.WriteFile(gitDirPath("rebase-merge", "message-squash"), []byte(message+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	hash, err := createCommit(treeHash, previous.Parents, CommitOptions{Message: message + "\n", Author: previous.Author})
+	if err != nil {
+		return "", err
+	}
+	subject, _ := splitCommitMessage(message)
+	if err := moveHead(hash, "rebase ("+command+"): "+subject); err != nil {
+		return "", err
+	}
+	// The commit melded into is rewritten again
+	if err := replaceRewritten(previousHead, hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// End of squash/fixup chain - the joined message is edited when the chain had a squash
+func finishSquashChain() error {
+	message, err := readRebaseState("message-squash")
+	if err != nil {
+		return nil
+	}
+	if _, err := os.Stat(gitDirPath("rebase-merge", "squash-edit")); err == nil {
+		edited, err := editCommitMessage(message)
+		if err != nil {
+			return err
+		}
+		_, head, err := readHead()
+		if err != nil {
+			return err
+		}
+		newHead, err := amendHead("", edited)
+		if err != nil {
+			return err
+		}
+		if err := replaceRewritten(head, newHead); err != nil {
+			return err
+		}
+	}
+	os.Remove(gitDirPath("rebase-merge", "squash-edit"))
+	return os.Remove(gitDirPath("rebase-merge", "message-squash"))
+}
+
+// Move the rebased branch to HEAD and check it out again
+func rebaseFinish(w io.Writer) error {
+	stateDir := gitDirPath("rebase-merge")
+	headName, err := readRebaseState("head-name")
+	if err != nil {
+		return err
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(headName, "refs/") {
+		if err := updateRef(headName, head, "rebase (finish): "+headName); err != nil {
+			return err
+		}
+		if err := writeSymbolicRef("HEAD", headName); err != nil {
+			return err
+		}
+	}
+
+	if rewritten, err := os.ReadFile(filepath.Join(stateDir, "rewritten-list")); err == nil {
+		runHook("post-rewrite", strings.NewReader(string(rewritten)), "rebase")
+	}
+	if err := os.RemoveAll(stateDir); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Successfully rebased and updated %s.\n", headName)
+	return nil
+}
+
+// Commit what the user resolved for the stopped pick (or amend the commit rebase stopped at for edit) and
+// go on with the todo list
+func rebaseContinue(w io.Writer) error {
+	if _, err := os.Stat(gitDirPath("rebase-merge")); err != nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+	if err := requireMergedIndex("Committing"); err != nil {
+		return err
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	dirty, err := indexDiffersFromCommit(head)
+	if err != nil {
+		return err
+	}
+
+	if stopped, err := readRebaseState("stopped-sha"); err == nil {
+		if err := rerereRecordResolutions(w); err != nil {
+			return err
+		}
+		// Resolution that leaves nothing of the commit drops it
+		if dirty {
+			parsed, err := readCommit(stopped)
+			if err != nil {
+				return err
+			}
+			if _, err := commitIndex(CommitOptions{Message: parsed.Message, Author: parsed.Author}); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(gitDirPath("rebase-merge", "stopped-sha")); err != nil {
+			return err
+		}
+
+		done, err := os.ReadFile(gitDirPath("rebase-merge", "done"))
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSpace(string(done)), "\n")
+		command, _, _, err := parseRebaseTodoLine(lines[len(lines)-1])
+		if err != nil {
+			return err
+		}
+		if stop, err := rebaseFinishLine(command, stopped, head, w); err != nil || stop {
+			return err
+		}
+	} else if amend, err := readRebaseState("amend"); err == nil {
+		if amend != head {
+			return fmt.Errorf("HEAD moved since rebase stopped at %s - commit your changes on top of it instead", shortHash(amend))
+		}
+		if dirty {
+			tree, err := writeTreeFromIndex()
+			if err != nil {
+				return err
+			}
+			newHead, err := amendHead(tree, "")
+			if err != nil {
+				return err
+			}
+			if err := replaceRewritten(head, newHead); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(gitDirPath("rebase-merge", "amend")); err != nil {
+			return err
+		}
+	}
+	return rebaseRun(w)
+}
+
+// Drop the stopped pick (its conflicts included) and go on with the todo list
+func rebaseSkip(w io.Writer) error {
+	if _, err := os.Stat(gitDirPath("rebase-merge")); err != nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if err := resetWorkTreeToCommit(head); err != nil {
+		return err
+	}
+	if err := rerereClear(); err != nil {
+		return err
+	}
+	os.Remove(gitDirPath("rebase-merge", "stopped-sha"))
+	os.Remove(gitDirPath("rebase-merge", "amend"))
+	return rebaseRun(w)
+}
+
+// Stop rebase - HEAD, index and work tree go back to where they were before rebase started
+func rebaseAbort() error {
+	stateDir := gitDirPath("rebase-merge")
+	if _, err := os.Stat(stateDir); err != nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+	headName, err := readRebaseState("head-name")
+	if err != nil {
+		return err
+	}
+	origHead, err := readRebaseState("orig-head")
+	if err != nil {
+		return err
+	}
+
+	if err := resetWorkTreeToCommit(origHead); err != nil {
+		return err
+	}
+	if strings.HasPrefix(headName, "refs/") {
+		err = writeSymbolicRef("HEAD", headName)
+	} else {
+		err = updateRef("HEAD", origHead, "rebase (abort): returning to "+origHead)
+	}
+	if err != nil {
+		return err
+	}
+	if err := rerereClear(); err != nil {
+		return err
+	}
+	return os.RemoveAll(stateDir)
+}
+
+// Commit rewritten again - entries of rewritten-list that led to oldHash lead to newHash now
+func replaceRewritten(oldHash, newHash string) error {
+	rewritten, err := os.ReadFile(gitDirPath("rebase-merge", "rewritten-list"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	updated := strings.ReplaceAll(string(rewritten), " "+oldHash+"\n", " "+newHash+"\n")
+	return os.WriteFile(gitDirPath("rebase-merge", "rewritten-list"), []byte(updated), 0644)
+}
+
+// Value stored in .git/rebase-merge/<name>
+func readRebaseState(name string) (string, error) {
+	data, err := os.ReadFile(gitDirPath("rebase-merge", name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func appendRebaseState(name, text string) error {
+	file, err := os.OpenFile(gitDirPath("rebase-merge", name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(text)
+	return err
+}
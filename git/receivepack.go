@@ -0,0 +1,558 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// receive-pack - server side of push (protocol v0):
+//  1. advertise refs (capabilities after NUL on the first line), flush
+//  2. read commands "<old> <new> <ref>" (capabilities after NUL on the first one), flush
+//  3. read the pack (none when every command is a delete)
+//  4. unpack it into a quarantine directory (objects/incoming-*), check that every new ref value is
+//     connected - all objects reachable from it are either in the pack or were in the repository
+//  5. move objects out of quarantine and update refs - old value has to match the current one
+//  6. send report-status: "unpack ok" (or the error), then "ok <ref>" / "ng <ref> <reason>" per command
+//
+// With the atomic capability either every ref is updated or none is - all refs are locked and checked
+// before the first one is written, and refs written before a failing write are put back. Rules from config:
+//   - receive.denyNonFastForwards - reject updates that would lose commits (off by default)
+//   - receive.denyDeletes         - reject ref deletion (off by default)
+//   - receive.denyCurrentBranch   - refuse (default), warn or ignore updates of the checked out branch
+//     of a non-bare repository, as its work tree would no longer match
+//
+// Like upload-pack, --advertise-refs and --stateless-rpc split it for smart HTTP.
+
+const receivePackCapabilities = "report-status delete-refs atomic ofs-delta side-band-64k quiet agent=mini-git"
+
+// Serve receive-pack for repository in options.Directory, reading commands and pack from input and
+// answering to output
+func (c *RepoContext) receivePack(options ReceivePackOptions, input io.Reader, output io.Writer) error {
+	repo, err := c.openServedRepository(options.Directory)
+	if err != nil {
+		return err
+	}
+	defer repo.enter()()
+	defer ignoreReplaceRefs()()
+	if traceWriter("GIT_TRACE_PACKET") != nil {
+		input = &PacketTraceReader{ReadCloser: io.NopCloser(input), tracer: &PacketTracer{Direction: '<'}}
+		output = &PacketTraceWriter{Writer: output, tracer: &PacketTracer{Direction: '>'}}
+	}
+
+	if options.AdvertiseRefs || !options.StatelessRPC {
+		if err := writeReceivePackAdvertisement(output); err != nil {
+			return err
+		}
+	}
+	if options.AdvertiseRefs {
+		return nil
+	}
+
+	reader := bufio.NewReader(input)
+	commands, capabilities, err := readRefUpdateCommands(reader)
+	if err != nil {
+		return err
+	}
+	if len(commands) == 0 {
+		// Client only wanted to see the refs
+		return nil
+	}
+
+	unpackErr := receiveObjects(reader, commands)
+	if unpackErr == nil {
+		applyRefUpdates(commands, capabilities["atomic"])
+	} else {
+		for _, command := range commands {
+			command.Error = "unpacker error"
+		}
+	}
+
+	if capabilities["report-status"] {
+		return writeReportStatus(output, commands, unpackErr, capabilities["side-band-64k"])
+	}
+	return unpackErr
+}
+
+// Write refs advertisement - every ref by name (no HEAD and no peeled tags, like git receive-pack)
+func writeReceivePackAdvertisement(output io.Writer) error {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, name := range sortedKeys(refs) {
+		lines = append(lines, refs[name]+" "+name)
+	}
+	if len(lines) == 0 {
+		// Empty repository still has to advertise its capabilities
+		lines = append(lines, zeroHash+" capabilities^{}")
+	}
+
+	for i, line := range lines {
+		if i == 0 {
+			line += "\x00" + receivePackCapabilities
+		}
+		writePktLine(output, line+"\n")
+	}
+	_, err = io.WriteString(output, "0000")
+	return err
+}
+
+// Read command list - ref updates and capabilities from the first command line
+func readRefUpdateCommands(reader *bufio.Reader) ([]*RefUpdateCommand, map[string]bool, error) {
+	var commands []*RefUpdateCommand
+	capabilities := make(map[string]bool)
+
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err == io.EOF {
+			return commands, capabilities, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if kind == PKT_FLUSH {
+			return commands, capabilities, nil
+		}
+
+		line := strings.TrimSuffix(string(payload), "\n")
+		if commandPart, caps, ok := strings.Cut(line, "\x00"); ok {
+			for _, capability := range strings.Fields(caps) {
+				capabilities[capability] = true
+			}
+			line = commandPart
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || len(fields[0]) != 40 || len(fields[1]) != 40 {
+			return nil, nil, fmt.Errorf("protocol error: expected old/new/ref, got %q", line)
+		}
+		commands = append(commands, &RefUpdateCommand{Old: fields[0], New: fields[1], Name: fields[2]})
+	}
+}
+
+// Read pack that follows the commands, unpack it into quarantine and check connectivity of every new ref
+// value (failing commands get their Error set). Objects are moved into the repository when at least one
+// command can still succeed. Returned error means the pack itself couldn't be unpacked.
+func receiveObjects(reader *bufio.Reader, commands []*RefUpdateCommand) error {
+	needsPack := false
+	for _, command := range commands {
+		needsPack = needsPack || command.New != zeroHash
+	}
+	if !needsPack {
+		return nil
+	}
+
+	pack, err := readPackStream(reader)
+	if err != nil {
+		return err
+	}
+
+	objectDir, err := filepath.Abs(objectDirPath())
+	if err != nil {
+		return err
+	}
+	quarantine, err := os.MkdirTemp(objectDir, "incoming-")
+	if err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	defer os.RemoveAll(quarantine)
+
+	// New objects go to quarantine, existing ones are still found through it (as alternate)
+	restore, err := enterQuarantine(quarantine, objectDir)
+	if err != nil {
+		return err
+	}
+	err = unpackIntoQuarantine(pack)
+	if err == nil {
+		for _, command := range commands {
+			if command.New == zeroHash {
+				continue
+			}
+			if connectErr := checkConnectivity(command.New, quarantine); connectErr != nil {
+				command.Error = "missing necessary objects"
+			}
+		}
+	}
+	restore()
+	if err != nil {
+		return err
+	}
+
+	for _, command := range commands {
+		if command.Error == "" {
+			return migrateQuarantine(quarantine, objectDir)
+		}
+	}
+	return nil
+}
+
+// Read pack from stream - only its own bytes are consumed (objects are parsed to find where it ends),
+// so it works on connections that stay open after the pack. Trailing checksum is verified.
+func readPackStream(reader *bufio.Reader) ([]byte, error) {
+	recorder := &RecordingReader{Reader: reader}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(recorder, header); err != nil {
+		return nil, fmt.Errorf("failed to read pack header: %w", err)
+	}
+	if string(header[:4]) != "PACK" {
+		return nil, fmt.Errorf("protocol error: bad pack header")
+	}
+	count := binary.BigEndian.Uint32(header[8:12])
+
+	for i := uint32(0); i < count; i++ {
+		if err := skipPackObject(recorder); err != nil {
+			return nil, fmt.Errorf("failed to read pack object %d: %w", i, err)
+		}
+	}
+
+	sum := sha1.Sum(recorder.Recorded.Bytes())
+	trailer := make([]byte, 20)
+	if _, err := io.ReadFull(reader, trailer); err != nil {
+		return nil, fmt.Errorf("failed to read pack checksum: %w", err)
+	}
+	if !bytes.Equal(sum[:], trailer) {
+		return nil, fmt.Errorf("%w: pack checksum mismatch", ErrCorruptObject)
+	}
+	return append(recorder.Recorded.Bytes(), trailer...), nil
+}
+
+// Read one pack object - header, delta base (offset or hash), then zlib stream (inflating it is the only
+// way to find its end)
+func skipPackObject(recorder *RecordingReader) error {
+	b, err := recorder.ReadByte()
+	if err != nil {
+		return err
+	}
+	objType := ObjectType((b >> 4) & 0x7)
+	for b&0x80 != 0 {
+		if b, err = recorder.ReadByte(); err != nil {
+			return err
+		}
+	}
+
+	switch objType {
+	case OBJ_OFS_DELTA:
+		for b = 0x80; b&0x80 != 0; {
+			if b, err = recorder.ReadByte(); err != nil {
+				return err
+			}
+		}
+	case OBJ_REF_DELTA:
+		if _, err := io.ReadFull(recorder, make([]byte, 20)); err != nil {
+			return err
+		}
+	}
+
+	return inflateToDiscard(recorder)
+}
+
+// Inflate zlib stream to its end, throwing the data away
+func inflateToDiscard(reader *RecordingReader) error {
+	inflater, err := zlib.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer inflater.Close()
+	_, err = io.Copy(io.Discard, inflater)
+	return err
+}
+
+func (reader *RecordingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.Recorded.Write(p[:n])
+	return n, err
+}
+
+func (reader *RecordingReader) ReadByte() (byte, error) {
+	b, err := reader.Reader.ReadByte()
+	if err == nil {
+		reader.Recorded.WriteByte(b)
+	}
+	return b, err
+}
+
+// Make quarantine the object directory of the active repository, with the real object directory as its
+// alternate (in quarantine/info/alternates) - returned function makes the real one active again
+func enterQuarantine(quarantine, objectDir string) (func(), error) {
+	if err := os.MkdirAll(filepath.Join(quarantine, "info"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(quarantine, "info", "alternates"), []byte(objectDir+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	activeObjectDir := activeLayout.ObjectDir
+	activeLayout.ObjectDir = quarantine
+	return func() {
+		activeLayout.ObjectDir = activeObjectDir
+		delete(objectDirectoriesCache, quarantine)
+	}, nil
+}
+
+// Parse pack and write its objects (deltas resolved, thin pack bases taken from the repository)
+func unpackIntoQuarantine(pack []byte) error {
+	objects, err := parsePackFile(context.Background(), pack)
+	if err != nil {
+		return err
+	}
+	return writePackObjects(context.Background(), objects)
+}
+
+// Walk objects reachable from tip - objects from the pack (in quarantine) are followed, objects that were
+// in the repository before are assumed complete (their history was checked when they were received)
+func checkConnectivity(tip, quarantine string) error {
+	seen := make(map[string]bool)
+	queue := []string{tip}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		if _, err := os.Stat(filepath.Join(quarantine, hash[:2], hash[2:])); err != nil {
+			exists, err := objectExists(hash)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%w: %s", ErrObjectNotFound, hash)
+			}
+			continue
+		}
+
+		objType, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			return err
+		}
+		switch objType {
+		case "commit":
+			commit, err := parseCommit(content)
+			if err != nil {
+				return err
+			}
+			queue = append(queue, commit.Tree)
+			queue = append(queue, commit.Parents...)
+		case "tree":
+			entries, err := parseTreeContent(content)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				// Submodule commits live in another repository
+				if entry.Mode != "160000" {
+					queue = append(queue, entry.Hash)
+				}
+			}
+		case "tag":
+			tag, err := parseTag(content)
+			if err != nil {
+				return err
+			}
+			queue = append(queue, tag.Object)
+		}
+	}
+	return nil
+}
+
+// Move loose objects from quarantine into the object directory (objects already there are kept) - its info
+// directory only points back to the object directory
+func migrateQuarantine(quarantine, objectDir string) error {
+	return filepath.WalkDir(quarantine, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && entry.IsDir() && path == filepath.Join(quarantine, "info") {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(quarantine, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(objectDir, relative)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, target); err != nil {
+			return fmt.Errorf("failed to move object %s: %w", relative, err)
+		}
+		return nil
+	})
+}
+
+// Check and apply ref updates - commands that can't be applied get their Error set. With atomic, one
+// failing command fails all of them (see applyAtomicRefUpdates).
+func applyRefUpdates(commands []*RefUpdateCommand, atomic bool) {
+	for _, command := range commands {
+		if command.Error == "" {
+			command.Error = checkRefUpdate(command)
+		}
+	}
+	if atomic {
+		applyAtomicRefUpdates(commands)
+		return
+	}
+
+	for _, command := range commands {
+		if command.Error != "" {
+			continue
+		}
+		var err error
+		// Ref is checked again under its lock - another push may have moved it since checkRefUpdate
+		if command.New == zeroHash {
+			err = deleteRefVerified(command.Name, command.Old)
+		} else {
+			err = updateRefVerified(command.Name, command.New, command.Old, "push")
+		}
+		if err != nil {
+			command.Error = "failed to update ref"
+		}
+	}
+}
+
+// Apply commands as one ref transaction - every ref is locked and its old value checked before any of them
+// is written, so either all refs are updated or none is. Errors (including refs that couldn't be put back
+// after a failed write) are reported on stderr.
+func applyAtomicRefUpdates(commands []*RefUpdateCommand) {
+	if failAtomicTransaction(commands) {
+		return
+	}
+	tx := &refTransaction{message: "push"}
+	for _, command := range commands {
+		tx.updates = append(tx.updates, &refTransactionUpdate{Name: command.Name, Old: command.Old, New: command.New})
+	}
+	failedRef, err := tx.prepare()
+	if err == nil {
+		failedRef, err = tx.commit()
+	}
+	if err == nil {
+		// Refs are updated - a reflog that couldn't be written doesn't fail the push
+		if err := tx.log(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	for _, command := range commands {
+		if command.Name == failedRef {
+			command.Error = "failed to update ref"
+		}
+	}
+	failAtomicTransaction(commands)
+}
+
+// Mark every command as failed when one of them failed - reports whether that happened
+func failAtomicTransaction(commands []*RefUpdateCommand) bool {
+	failed := false
+	for _, command := range commands {
+		failed = failed || command.Error != ""
+	}
+	if failed {
+		for _, command := range commands {
+			if command.Error == "" {
+				command.Error = "atomic transaction failed"
+			}
+		}
+	}
+	return failed
+}
+
+// Reason why the ref update can't be applied (as sent in "ng" line) - empty when it can
+func checkRefUpdate(command *RefUpdateCommand) string {
+	if !strings.HasPrefix(command.Name, "refs/") || checkRefName(command.Name) != nil {
+		return "funny refname"
+	}
+
+	current, err := resolveRef(command.Name)
+	if err != nil {
+		return "failed to read ref"
+	}
+	if current == "" {
+		current = zeroHash
+	}
+	if current != command.Old {
+		return "stale info"
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return "failed to read config"
+	}
+	headBranch, _, _ := readHead()
+	isCurrentBranch := !resolveRepoLayout().Bare && command.Name == headBranch
+
+	if command.New == zeroHash {
+		if config.GetBool("receive.denydeletes", false) {
+			return "deletion prohibited"
+		}
+		if isCurrentBranch {
+			return "deletion of the current branch prohibited"
+		}
+		return ""
+	}
+
+	if isCurrentBranch {
+		mode, _ := config.Get("receive.denycurrentbranch")
+		switch strings.ToLower(mode) {
+		case "warn":
+			fmt.Fprintf(os.Stderr, "warning: updating the current branch\n")
+		case "ignore", "false":
+		default:
+			return "branch is currently checked out"
+		}
+	}
+
+	if command.Old != zeroHash && config.GetBool("receive.denynonfastforwards", false) {
+		// Only commits have history - tags and other objects can't be fast-forwarded
+		if objType, _, _, err := readObjectFromHash(command.Old); err == nil && objType == "commit" {
+			if ancestor, err := isAncestor(command.Old, command.New); err != nil || !ancestor {
+				return "non-fast-forward"
+			}
+		}
+	}
+	return ""
+}
+
+// Write report-status - over side-band channel 1 when client asked for side-band-64k
+func writeReportStatus(output io.Writer, commands []*RefUpdateCommand, unpackErr error, sideband bool) error {
+	var report bytes.Buffer
+	if unpackErr != nil {
+		writePktLine(&report, fmt.Sprintf("unpack %s\n", strings.ReplaceAll(unpackErr.Error(), "\n", " ")))
+	} else {
+		writePktLine(&report, "unpack ok\n")
+	}
+	for _, command := range commands {
+		if command.Error == "" {
+			writePktLine(&report, fmt.Sprintf("ok %s\n", command.Name))
+		} else {
+			writePktLine(&report, fmt.Sprintf("ng %s %s\n", command.Name, command.Error))
+		}
+	}
+	report.WriteString("0000")
+
+	if !sideband {
+		_, err := output.Write(report.Bytes())
+		return err
+	}
+	data := report.Bytes()
+	for start := 0; start < len(data); start += sidebandChunkSize {
+		writeSidebandPacket(output, 1, data[start:min(start+sidebandChunkSize, len(data))])
+	}
+	_, err := io.WriteString(output, "0000")
+	return err
+}
@@ -0,0 +1,351 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reflogs - .git/logs/<refname> records updates of a ref, oldest first, one per line:
+//
+//	<old hash> <new hash> <committer signature>\t<message>
+//
+// The old hash of the first update is all zeros. <ref>@{<n>} names the value the ref had n updates ago -
+// @{0} is the current one. updateRef logs the refs core.logAllRefUpdates asks for (HEAD and branches by
+// default, see shouldLogRef); refs/stash keeps its stack of entries this way (see stash.go).
+//
+// `reflog expire` (run by gc for every reflog) drops entries older than gc.reflogExpire (90 days), and
+// entries older than gc.reflogExpireUnreachable (30 days) whose old or new commit is not reachable from the
+// ref's current value (from any branch for HEAD) - those were left behind by resets, rebases and amends.
+// Until then the commits of every entry count as reachable for repack and prune, so a rewritten history can
+// still be recovered from the reflog.
+
+const (
+	defaultReflogExpire            = "90.days.ago"
+	defaultReflogExpireUnreachable = "30.days.ago"
+)
+
+// Read reflog of ref - no entries when there is none
+func readReflog(refName string) ([]reflogEntry, error) {
+	data, err := os.ReadFile(gitDirPath("logs", filepath.FromSlash(refName)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read reflog of %s: %w", refName, err)
+	}
+
+	var entries []reflogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		header, message, _ := strings.Cut(line, "\t")
+		fields := strings.SplitN(header, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		entries = append(entries, reflogEntry{Old: fields[0], New: fields[1], Committer: fields[2], Message: message})
+	}
+	return entries, nil
+}
+
+// Names of refs that have a reflog (HEAD included), sorted
+func listReflogs() ([]string, error) {
+	logsDir := gitDirPath("logs")
+	var names []string
+	err := filepath.WalkDir(logsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == logsDir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			name, _ := filepath.Rel(logsDir, path)
+			names = append(names, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reflogs: %w", err)
+	}
+	return names, nil
+}
+
+// Log update of ref (made by updateRef) in its reflog - and in HEAD's, when HEAD is a symbolic ref to it.
+// Empty message means the caller keeps the reflog itself (stash), so nothing is logged
+func logRefUpdate(refName, oldHash, newHash, message string) error {
+	if message == "" {
+		return nil
+	}
+	if shouldLogRef(refName) {
+		if err := appendReflog(refName, oldHash, newHash, message); err != nil {
+			return err
+		}
+	}
+	if refName == "HEAD" || !strings.HasPrefix(refName, "refs/") {
+		return nil
+	}
+	headBranch, _, err := readHead()
+	if err != nil || headBranch != refName || !shouldLogRef("HEAD") {
+		return nil
+	}
+	return appendReflog("HEAD", oldHash, newHash, message)
+}
+
+// Whether updates of ref are logged - refs that already have a reflog always are, others depend on
+// core.logAllRefUpdates: "always" logs every ref, true (default outside bare repositories) logs HEAD,
+// branches, remote-tracking branches and notes
+func shouldLogRef(refName string) bool {
+	if _, err := os.Stat(gitDirPath("logs", filepath.FromSlash(refName))); err == nil {
+		return true
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return false
+	}
+	value, ok := config.Get("core.logAllRefUpdates")
+	if !ok {
+		return !resolveRepoLayout().Bare && isAutoLoggedRef(refName)
+	}
+	if strings.EqualFold(value, "always") {
+		return true
+	}
+	return parseBoolValue(value, false) && isAutoLoggedRef(refName)
+}
+
+// Refs whose reflog is created by core.logAllRefUpdates=true
+func isAutoLoggedRef(refName string) bool {
+	return refName == "HEAD" || strings.HasPrefix(refName, "refs/heads/") ||
+		strings.HasPrefix(refName, "refs/remotes/") || strings.HasPrefix(refName, "refs/notes/")
+}
+
+// Record update of ref from oldHash ("" when it didn't exist) to newHash
+func appendReflog(refName, oldHash, newHash, message string) error {
+	if oldHash == "" {
+		oldHash = zeroHash
+	}
+	logPath := gitDirPath("logs", filepath.FromSlash(refName))
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog of %s: %w", refName, err)
+	}
+	defer file.Close()
+	// Message is a single line
+	message = strings.ReplaceAll(strings.TrimRight(message, "\n"), "\n", " ")
+	if _, err := fmt.Fprintf(file, "%s %s %s\t%s\n", oldHash, newHash, userSignature("COMMITTER"), message); err != nil {
+		return fmt.Errorf("failed to write reflog of %s: %w", refName, err)
+	}
+	return nil
+}
+
+// Replace reflog of ref with entries - the reflog is deleted when there are none
+func writeReflog(refName string, entries []reflogEntry) error {
+	logPath := gitDirPath("logs", filepath.FromSlash(refName))
+	if len(entries) == 0 {
+		if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete reflog of %s: %w", refName, err)
+		}
+		return nil
+	}
+	var content strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&content, "%s %s %s\t%s\n", entry.Old, entry.New, entry.Committer, entry.Message)
+	}
+	if err := os.WriteFile(logPath, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write reflog of %s: %w", refName, err)
+	}
+	return nil
+}
+
+// Resolve <ref>@{<n>} - the value ref had n updates ago (ref is a revision name, HEAD when empty)
+func resolveReflogRevision(ref, selector string) (string, string, error) {
+	count, err := strconv.Atoi(selector)
+	if err != nil || count < 0 {
+		return "", "", fmt.Errorf("%w: unknown revision %s@{%s}", ErrObjectNotFound, ref, selector)
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	refName, _, err := resolveRevision(ref)
+	if err != nil {
+		return "", "", err
+	}
+	entries, err := readReflog(refName)
+	if err != nil {
+		return "", "", err
+	}
+	if count >= len(entries) {
+		return "", "", fmt.Errorf("%w: log for '%s' only has %d entries", ErrObjectNotFound, ref, len(entries))
+	}
+	return "", entries[len(entries)-1-count].New, nil
+}
+
+// Drop old reflog entries of options.Refs (every reflog with All) - returns how many were (or would be) dropped
+func expireReflogs(options ReflogExpireOptions, w io.Writer) (int, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	expireValue, unreachableValue := options.Expire, options.ExpireUnreachable
+	if expireValue == "" {
+		expireValue = defaultReflogExpire
+		if value, ok := config.Get("gc.reflogExpire"); ok {
+			expireValue = value
+		}
+	}
+	if unreachableValue == "" {
+		unreachableValue = defaultReflogExpireUnreachable
+		if value, ok := config.Get("gc.reflogExpireUnreachable"); ok {
+			unreachableValue = value
+		}
+	}
+	expire, err := parseExpiryDate(expireValue, now)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reflog expiry: %w", err)
+	}
+	expireUnreachable, err := parseExpiryDate(unreachableValue, now)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reflog expiry: %w", err)
+	}
+
+	refNames := options.Refs
+	if options.All {
+		if refNames, err = listReflogs(); err != nil {
+			return 0, err
+		}
+	} else {
+		for i, name := range refNames {
+			refName, _, err := resolveRevision(name)
+			if err != nil || refName == "" {
+				return 0, fmt.Errorf("reflog could not be found: '%s'", name)
+			}
+			refNames[i] = refName
+		}
+	}
+
+	dropped := 0
+	for _, refName := range refNames {
+		count, err := expireReflog(refName, expire, expireUnreachable, options, w)
+		if err != nil {
+			return dropped, err
+		}
+		dropped += count
+	}
+	return dropped, nil
+}
+
+// Drop entries of one reflog - older than expire, or older than expireUnreachable with an old or new commit
+// that the ref doesn't reach anymore
+func expireReflog(refName string, expire, expireUnreachable time.Time, options ReflogExpireOptions, w io.Writer) (int, error) {
+	entries, err := readReflog(refName)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	// Commits reachable from the ref (every branch for HEAD) - walked only when an entry needs them
+	var reachable map[string]bool
+	isUnreachable := func(hash string) (bool, error) {
+		if hash == zeroHash {
+			return false, nil
+		}
+		if peeled, err := peelTag(hash); err == nil && peeled != "" {
+			hash = peeled
+		}
+		// Objects that are not (or no longer) commits are kept
+		if objType, _, _, err := readObjectFromHash(hash); err != nil || objType != "commit" {
+			return false, nil
+		}
+		if reachable == nil {
+			commits, err := reflogTipHistory(refName)
+			if err != nil {
+				return false, err
+			}
+			reachable = make(map[string]bool, len(commits))
+			for _, commit := range commits {
+				reachable[commit] = true
+			}
+		}
+		return !reachable[hash], nil
+	}
+
+	var kept []reflogEntry
+	for _, entry := range entries {
+		drop := false
+		if _, _, when, err := parseSignature(entry.Committer); err == nil {
+			if when.Before(expire) {
+				drop = true
+			} else if when.Before(expireUnreachable) {
+				for _, hash := range []string{entry.Old, entry.New} {
+					unreachable, err := isUnreachable(hash)
+					if err != nil {
+						return 0, err
+					}
+					drop = drop || unreachable
+				}
+			}
+		}
+		if !drop {
+			kept = append(kept, entry)
+		}
+		if options.Verbose {
+			action := "keep"
+			if drop {
+				action = "prune"
+			}
+			fmt.Fprintf(w, "%s %s\n", action, entry.Message)
+		}
+	}
+
+	dropped := len(entries) - len(kept)
+	if dropped == 0 || options.DryRun {
+		return dropped, nil
+	}
+	if len(kept) == 0 {
+		// The reflog stays, only empty - the ref still logs its updates
+		if err := os.WriteFile(gitDirPath("logs", filepath.FromSlash(refName)), nil, 0644); err != nil {
+			return dropped, fmt.Errorf("failed to write reflog of %s: %w", refName, err)
+		}
+		return dropped, nil
+	}
+	return dropped, writeReflog(refName, kept)
+}
+
+// History of the commits a reflog's entries have to be reachable from - the ref's value, or every branch
+// for HEAD
+func reflogTipHistory(refName string) ([]string, error) {
+	var tips []string
+	if refName == "HEAD" {
+		branches, err := listRefs("refs/heads/")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(branches) {
+			tips = append(tips, branches[name])
+		}
+	} else if hash, err := resolveRef(refName); err != nil {
+		return nil, err
+	} else if hash != "" {
+		if peeled, err := peelTag(hash); err == nil && peeled != "" {
+			hash = peeled
+		}
+		tips = append(tips, hash)
+	}
+
+	var commits []string
+	for _, tip := range tips {
+		if objType, _, _, err := readObjectFromHash(tip); err == nil && objType == "commit" {
+			commits = append(commits, tip)
+		}
+	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	return revList(commits, nil)
+}
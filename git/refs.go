@@ -0,0 +1,782 @@
+package git
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// References - HEAD and everything under .git/refs (branches, tags, remote branches)
+// Loose refs (.git/refs/...) are consulted first, .git/packed-refs is the fallback
+
+// Read HEAD - returns branch HEAD points to (refs/heads/<name>, empty if HEAD is detached) and commit hash
+// Hash is empty when the branch doesn't have any commits yet
+func readHead() (string, string, error) {
+	data, err := os.ReadFile(gitDirPath("HEAD"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	content := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(content, "ref: ") {
+		// Detached HEAD - contains commit hash directly
+		return "", content, nil
+	}
+
+	branch := strings.TrimPrefix(content, "ref: ")
+	hash, err := resolveRef(branch)
+	if err != nil {
+		return "", "", err
+	}
+	return branch, hash, nil
+}
+
+// Resolve full ref name (refs/heads/master) to hash, following symbolic refs - empty hash if ref doesn't exist
+func resolveRef(refName string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		data, err := os.ReadFile(gitDirPath(filepath.FromSlash(refName)))
+		if os.IsNotExist(err) {
+			// No loose ref - it may be in packed-refs
+			return readPackedRef(refName)
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read ref %s: %w", refName, err)
+		}
+
+		content := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(content, "ref: ") {
+			return content, nil
+		}
+		refName = strings.TrimPrefix(content, "ref: ")
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic refs")
+}
+
+// Write hash to ref file (e.g. refs/heads/master), creating parent directories - the update is logged with
+// message in the ref's reflog (and HEAD's, when HEAD points to the ref), see logRefUpdate
+func updateRef(refName, hash, message string) error {
+	return updateRefVerified(refName, hash, "", message)
+}
+
+// Write hash to ref while holding its lock - the ref must still be at oldHash (zeroHash: must not exist) once the
+// lock is taken, unless oldHash is empty. Value goes to <ref>.lock, which is renamed over the ref, so readers
+// never see a partly written ref. The old value logged in the reflog is the one read under the lock.
+func updateRefVerified(refName, hash, oldHash, message string) error {
+	previous, err := writeRefLocked(refName, hash+"\n", oldHash)
+	if err != nil {
+		return err
+	}
+	return logRefUpdate(refName, previous, hash, message)
+}
+
+// Write content to ref through <ref>.lock, checking oldHash under the lock (see updateRefVerified) - returns
+// the value the ref had under the lock
+func writeRefLocked(refName, content, oldHash string) (string, error) {
+	lock, previous, err := lockRef(refName, oldHash)
+	if err != nil {
+		return "", err
+	}
+	if err := commitRefLock(lock, refName, content); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// Write content to taken ref lock and rename it over the ref - the lock is released either way
+func commitRefLock(lock *os.File, refName, content string) error {
+	if _, err := lock.WriteString(content); err != nil {
+		unlockRef(lock)
+		return fmt.Errorf("failed to write ref %s: %w", refName, err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(lock.Name())
+		return fmt.Errorf("failed to write ref %s: %w", refName, err)
+	}
+	if err := os.Rename(lock.Name(), gitDirPath(filepath.FromSlash(refName))); err != nil {
+		os.Remove(lock.Name())
+		return fmt.Errorf("failed to write ref %s: %w", refName, err)
+	}
+	return nil
+}
+
+// Take <ref>.lock - created exclusively, so it fails while another process updates the ref. Ref is then read
+// and checked against oldHash (zeroHash: ref must not exist, empty: no check). Returns the open lock file and
+// the value of the ref under the lock (empty when it doesn't exist)
+func lockRef(refName, oldHash string) (*os.File, string, error) {
+	refPath := gitDirPath(filepath.FromSlash(refName))
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create ref directory: %w", err)
+	}
+	lock, err := os.OpenFile(refPath+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, "", fmt.Errorf("cannot lock ref '%s': unable to create '%s.lock': file exists - another git process "+
+			"seems to be running in this repository", refName, refPath)
+	} else if err != nil {
+		return nil, "", fmt.Errorf("cannot lock ref '%s': %w", refName, err)
+	}
+
+	current, err := resolveRef(refName)
+	if err != nil {
+		unlockRef(lock)
+		return nil, "", err
+	}
+	if oldHash == "" {
+		return lock, current, nil
+	}
+	actual := current
+	if actual == "" {
+		actual = zeroHash
+	}
+	if actual != oldHash {
+		unlockRef(lock)
+		if oldHash == zeroHash {
+			return nil, "", fmt.Errorf("cannot lock ref '%s': reference already exists", refName)
+		}
+		return nil, "", fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", refName, actual, oldHash)
+	}
+	return lock, current, nil
+}
+
+// Release ref lock without changing the ref
+func unlockRef(lock *os.File) {
+	lock.Close()
+	os.Remove(lock.Name())
+}
+
+// Delete ref - loose file, packed-refs entry and reflog (missing ref is not an error)
+func deleteRef(refName string) error {
+	return deleteRefVerified(refName, "")
+}
+
+// Delete ref while holding its lock - the ref must still be at oldHash once the lock is taken, unless oldHash
+// is empty. Packed entry goes first, so a failure never brings back an older packed value.
+func deleteRefVerified(refName, oldHash string) error {
+	lock, _, err := lockRef(refName, oldHash)
+	if err != nil {
+		return err
+	}
+	if err := removePackedRefs(map[string]bool{refName: true}); err != nil {
+		unlockRef(lock)
+		return err
+	}
+	refPath := gitDirPath(filepath.FromSlash(refName))
+	err = os.Remove(refPath)
+	unlockRef(lock)
+	removeEmptyRefDirs(filepath.Dir(refPath))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete ref %s: %w", refName, err)
+	}
+
+	// Reflog goes with the ref
+	logPath := gitDirPath("logs", filepath.FromSlash(refName))
+	if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete reflog of %s: %w", refName, err)
+	}
+	return nil
+}
+
+// Lock every ref of the transaction and check its old value under the lock - nothing is written yet. On
+// failure every lock is released, and the ref that couldn't be locked is returned with the error.
+func (tx *refTransaction) prepare() (string, error) {
+	for _, update := range tx.updates {
+		lock, previous, err := lockRef(update.Name, update.Old)
+		if err != nil {
+			tx.release()
+			return update.Name, err
+		}
+		update.lock, update.previous = lock, previous
+	}
+	return "", nil
+}
+
+// Write every locked ref of the prepared transaction - entries of deleted refs leave packed-refs first, then
+// refs are written one by one. When a write fails, refs already changed are put back to the values they had
+// under their locks; failures to do that are returned with the error (and the ref whose write failed).
+// Reflogs are left to refTransaction.log.
+func (tx *refTransaction) commit() (string, error) {
+	deleted := make(map[string]bool)
+	for _, update := range tx.updates {
+		if update.New == zeroHash {
+			deleted[update.Name] = true
+		}
+	}
+	if len(deleted) > 0 {
+		if err := removePackedRefs(deleted); err != nil {
+			tx.release()
+			return "", err
+		}
+	}
+
+	for _, update := range tx.updates {
+		if err := update.write(); err != nil {
+			return update.Name, errors.Join(err, tx.rollback())
+		}
+	}
+	return "", nil
+}
+
+// Log updates of the committed transaction - reflogs of deleted refs go with them
+func (tx *refTransaction) log() error {
+	for _, update := range tx.updates {
+		if update.New != zeroHash {
+			if err := logRefUpdate(update.Name, update.previous, update.New, tx.message); err != nil {
+				return err
+			}
+			continue
+		}
+		logPath := gitDirPath("logs", filepath.FromSlash(update.Name))
+		if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete reflog of %s: %w", update.Name, err)
+		}
+	}
+	return nil
+}
+
+// Write locked update - new value is renamed over the ref (the lock is gone either way), deleted ref loses its
+// loose file (the lock stays when that fails)
+func (update *refTransactionUpdate) write() error {
+	if update.New != zeroHash {
+		lock := update.lock
+		update.lock = nil
+		if err := commitRefLock(lock, update.Name, update.New+"\n"); err != nil {
+			return err
+		}
+		update.written = true
+		return nil
+	}
+
+	refPath := gitDirPath(filepath.FromSlash(update.Name))
+	if err := os.Remove(refPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete ref %s: %w", update.Name, err)
+	}
+	unlockRef(update.lock)
+	update.lock = nil
+	update.written = true
+	removeEmptyRefDirs(filepath.Dir(refPath))
+	return nil
+}
+
+// Put refs of a failed commit back - written refs are locked again (they must still be at the new value),
+// deleted refs still locked are written through their lock, as their packed entries are gone. Returns what
+// couldn't be put back.
+func (tx *refTransaction) rollback() error {
+	var errs []error
+	for _, update := range tx.updates {
+		var err error
+		switch {
+		case update.lock != nil && update.New == zeroHash && update.previous != "":
+			err = commitRefLock(update.lock, update.Name, update.previous+"\n")
+		case update.lock != nil:
+			unlockRef(update.lock)
+		case !update.written:
+			// Write failed before it changed the ref
+		case update.previous == "":
+			err = deleteRefVerified(update.Name, update.New)
+		default:
+			_, err = writeRefLocked(update.Name, update.previous+"\n", update.New)
+		}
+		update.lock = nil
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to roll back ref %s: %w", update.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Release locks of the transaction without writing anything
+func (tx *refTransaction) release() {
+	for _, update := range tx.updates {
+		if update.lock != nil {
+			unlockRef(update.lock)
+			update.lock = nil
+		}
+	}
+}
+
+// Check ref name against git's refname rules (git check-ref-format):
+//   - at least two components, none starting with "." or ending with ".lock"
+//   - no "..", "@{", "//", backslash, control characters, space or any of ~^:?*[
+//   - doesn't start or end with "/", doesn't end with ".", isn't "@"
+func checkRefName(refName string) error {
+	if refName == "@" || !strings.Contains(refName, "/") {
+		return fmt.Errorf("'%s' is not a valid ref name", refName)
+	}
+	if strings.Contains(refName, "..") || strings.Contains(refName, "@{") || strings.HasSuffix(refName, ".") {
+		return fmt.Errorf("'%s' is not a valid ref name", refName)
+	}
+	for _, char := range refName {
+		if char < 0x20 || char == 0x7f || strings.ContainsRune(" ~^:?*[\\", char) {
+			return fmt.Errorf("'%s' is not a valid ref name", refName)
+		}
+	}
+	for _, component := range strings.Split(refName, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return fmt.Errorf("'%s' is not a valid ref name", refName)
+		}
+	}
+	return nil
+}
+
+// Check branch name - valid as refs/heads/<name>, and not an option or HEAD
+func CheckBranchName(branch string) error {
+	if strings.HasPrefix(branch, "-") || branch == "HEAD" || checkRefName("refs/heads/"+branch) != nil {
+		return fmt.Errorf("'%s' is not a valid branch name", branch)
+	}
+	return nil
+}
+
+// Write symbolic ref (e.g. HEAD -> refs/heads/main)
+func writeSymbolicRef(refName, target string) error {
+	_, err := writeRefLocked(refName, "ref: "+target+"\n", "")
+	return err
+}
+
+// Read .git/packed-refs - refs in "<hash> <name>" lines, "^<hash>" line after an annotated tag is its peeled value
+func readPackedRefs() ([]PackedRef, error) {
+	data, err := os.ReadFile(gitDirPath("packed-refs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read packed-refs: %w", err)
+	}
+
+	var refs []PackedRef
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '^' {
+			if len(refs) == 0 {
+				return nil, fmt.Errorf("malformed packed-refs: peeled line without ref")
+			}
+			refs[len(refs)-1].Peeled = line[1:]
+			continue
+		}
+
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok || len(hash) != 40 {
+			return nil, fmt.Errorf("malformed packed-refs line: %s", line)
+		}
+		refs = append(refs, PackedRef{Name: name, Hash: hash})
+	}
+
+	return refs, nil
+}
+
+// Write .git/packed-refs (sorted by name, with peeled values for annotated tags)
+func writePackedRefs(refs []PackedRef) error {
+	return updatePackedRefs(func([]PackedRef) ([]PackedRef, error) {
+		return append([]PackedRef{}, refs...), nil
+	})
+}
+
+// Drop refs from packed-refs - file is left alone when none of them is there
+func removePackedRefs(names map[string]bool) error {
+	return updatePackedRefs(func(packed []PackedRef) ([]PackedRef, error) {
+		kept := packed[:0]
+		for _, ref := range packed {
+			if !names[ref.Name] {
+				kept = append(kept, ref)
+			}
+		}
+		if len(kept) == len(packed) {
+			return nil, nil
+		}
+		return kept, nil
+	})
+}
+
+// Rewrite packed-refs under packed-refs.lock - update gets the refs read under the lock and returns the new
+// list (nil: nothing to write). The lock is created exclusively, so concurrent rewrites fail instead of
+// losing each other's changes, and it is renamed over packed-refs, so readers never see a half written file.
+func updatePackedRefs(update func([]PackedRef) ([]PackedRef, error)) error {
+	lockPath := gitDirPath("packed-refs.lock")
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("unable to create '%s': file exists - another git process seems to be running in this "+
+			"repository", lockPath)
+	} else if err != nil {
+		return fmt.Errorf("failed to lock packed-refs: %w", err)
+	}
+
+	refs, err := readPackedRefs()
+	if err == nil {
+		refs, err = update(refs)
+	}
+	if err != nil || refs == nil {
+		unlockRef(lock)
+		return err
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+
+	var buf strings.Builder
+	buf.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, ref := range refs {
+		fmt.Fprintf(&buf, "%s %s\n", ref.Hash, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&buf, "^%s\n", ref.Peeled)
+		}
+	}
+	if _, err := lock.WriteString(buf.String()); err != nil {
+		unlockRef(lock)
+		return fmt.Errorf("failed to write packed-refs: %w", err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed to write packed-refs: %w", err)
+	}
+	if err := os.Rename(lockPath, gitDirPath("packed-refs")); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed to write packed-refs: %w", err)
+	}
+	return nil
+}
+
+// Look up single ref in packed-refs - empty hash if it isn't there
+func readPackedRef(refName string) (string, error) {
+	refs, err := readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		if ref.Name == refName {
+			return ref.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// List all refs (loose and packed - loose ones win) whose name starts with prefix, as name -> hash
+func listRefs(prefix string) (map[string]string, error) {
+	refs := make(map[string]string)
+
+	packed, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if strings.HasPrefix(ref.Name, prefix) {
+			refs[ref.Name] = ref.Hash
+		}
+	}
+
+	loose, err := listLooseRefs()
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range loose {
+		if strings.HasPrefix(name, prefix) {
+			refs[name] = hash
+		}
+	}
+
+	return refs, nil
+}
+
+// Walk .git/refs and read every loose ref (symbolic refs are resolved)
+func listLooseRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	refsDir := gitDirPath("refs")
+
+	err := filepath.WalkDir(refsDir, func(walkPath string, dirEntry os.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), ".lock") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(gitDirPath(), walkPath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		hash, err := resolveRef(name)
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			refs[name] = hash
+		}
+		return nil
+	})
+
+	return refs, err
+}
+
+// Move loose refs into packed-refs - with all every ref is packed, otherwise only tags (like git pack-refs)
+// Loose files of packed refs are removed unless noPrune is set
+func packRefs(all, noPrune bool) error {
+	loose, err := listLooseRefs()
+	if err != nil {
+		return err
+	}
+
+	var packedLoose []string
+	err = updatePackedRefs(func(packed []PackedRef) ([]PackedRef, error) {
+		byName := make(map[string]PackedRef)
+		for _, ref := range packed {
+			byName[ref.Name] = ref
+		}
+
+		for name, hash := range loose {
+			_, alreadyPacked := byName[name]
+			if !all && !alreadyPacked && !strings.HasPrefix(name, "refs/tags/") {
+				continue
+			}
+			// Symbolic refs (e.g. refs/remotes/origin/HEAD) stay loose
+			if data, err := os.ReadFile(gitDirPath(filepath.FromSlash(name))); err == nil && strings.HasPrefix(string(data), "ref: ") {
+				continue
+			}
+
+			ref := PackedRef{Name: name, Hash: hash}
+			peeled, err := peelTag(hash)
+			if err != nil {
+				return nil, err
+			}
+			ref.Peeled = peeled
+			byName[name] = ref
+			packedLoose = append(packedLoose, name)
+		}
+
+		refs := make([]PackedRef, 0, len(byName))
+		for _, ref := range byName {
+			refs = append(refs, ref)
+		}
+		return refs, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if noPrune {
+		return nil
+	}
+	for _, name := range packedLoose {
+		if err := os.Remove(gitDirPath(filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove loose ref %s: %w", name, err)
+		}
+		removeEmptyRefDirs(filepath.Dir(gitDirPath(filepath.FromSlash(name))))
+	}
+	return nil
+}
+
+// If hash is an annotated tag, follow tag chain to the tagged (non-tag) object - empty otherwise
+func peelTag(hash string) (string, error) {
+	peeled := ""
+	for depth := 0; depth < 10; depth++ {
+		objType, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			// Ref pointing to a missing object is packed without peeled value
+			return "", nil
+		}
+		if objType != "tag" {
+			return peeled, nil
+		}
+
+		object, _, _ := strings.Cut(string(content), "\n")
+		hash = strings.TrimPrefix(object, "object ")
+		peeled = hash
+	}
+	return "", fmt.Errorf("tag chain too long")
+}
+
+// Remove empty directories left under .git/refs after loose refs were deleted (refs/heads etc. are kept)
+func removeEmptyRefDirs(dir string) {
+	refsDir := gitDirPath("refs")
+	for dir != refsDir && filepath.Dir(dir) != refsDir {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Resolve revision name to full ref name and hash - HEAD, full ref name, short branch/tag/remote branch
+// name, or object hash (ref name is empty then). ~<n> (n-th first-parent ancestor) and ^<n> (n-th parent)
+// suffixes are followed from the resolved commit, ^{<type>} and ^{} peel it, <ref>@{<n>} is taken from the
+// reflog
+func resolveRevision(name string) (string, string, error) {
+	if base, suffix, ok := cutRevisionSuffix(name); ok {
+		_, hash, err := resolveRevision(base)
+		if err != nil {
+			return "", "", err
+		}
+		hash, err = followRevisionSuffix(hash, suffix)
+		if err != nil {
+			return "", "", fmt.Errorf("unknown revision %s: %w", name, err)
+		}
+		return "", hash, nil
+	}
+
+	if ref, selector, ok := strings.Cut(name, "@{"); ok && strings.HasSuffix(selector, "}") {
+		return resolveReflogRevision(ref, strings.TrimSuffix(selector, "}"))
+	}
+
+	if name == "HEAD" {
+		_, hash, err := readHead()
+		if err != nil || hash == "" {
+			return "", "", fmt.Errorf("HEAD does not point to a commit")
+		}
+		return "HEAD", hash, nil
+	}
+
+	for _, refName := range []string{name, "refs/" + name, "refs/heads/" + name, "refs/tags/" + name, "refs/remotes/" + name} {
+		// Outside refs/ only pseudo refs in the git directory (CHERRY_PICK_HEAD, ...) - all caps names
+		pseudo := refName == name && name != "" && strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") == ""
+		if !strings.HasPrefix(refName, "refs/") && !pseudo {
+			continue
+		}
+		hash, err := resolveRef(refName)
+		if err != nil {
+			return "", "", err
+		}
+		if hash != "" {
+			return refName, hash, nil
+		}
+	}
+
+	if len(name) == 40 && strings.Trim(strings.ToLower(name), "0123456789abcdef") == "" {
+		if exists, err := objectExists(strings.ToLower(name)); err == nil && exists {
+			return "", strings.ToLower(name), nil
+		}
+	}
+	if len(name) >= 4 && len(name) < 40 && strings.Trim(strings.ToLower(name), "0123456789abcdef") == "" {
+		hash, err := resolveAbbreviatedHash(strings.ToLower(name))
+		if err != nil || hash != "" {
+			return "", hash, err
+		}
+	}
+	return "", "", fmt.Errorf("%w: unknown revision %s", ErrObjectNotFound, name)
+}
+
+// Object whose hash starts with prefix (at least 4 hex digits) - loose and packed objects are searched,
+// "" when there is none, error when there are more
+func resolveAbbreviatedHash(prefix string) (string, error) {
+	found := make(map[string]bool)
+	for _, dir := range objectDirectories() {
+		names, err := os.ReadDir(filepath.Join(dir, prefix[:2]))
+		if err != nil {
+			continue
+		}
+		for _, entry := range names {
+			if hash := prefix[:2] + entry.Name(); len(hash) == 40 && strings.HasPrefix(hash, prefix) {
+				found[hash] = true
+			}
+		}
+	}
+
+	indexes, err := loadPackIndexes()
+	if err != nil {
+		return "", err
+	}
+	first, err := strconv.ParseUint(prefix[:2], 16, 8)
+	if err != nil {
+		return "", err
+	}
+	for _, index := range indexes {
+		// Fanout gives the range of hashes starting with the first byte
+		start := uint32(0)
+		if first > 0 {
+			start = index.Fanout[first-1]
+		}
+		for i := start; i < index.Fanout[first]; i++ {
+			if hash := hex.EncodeToString(index.Hashes[i*20 : i*20+20]); strings.HasPrefix(hash, prefix) {
+				found[hash] = true
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return sortedKeys(found)[0], nil
+	}
+	return "", fmt.Errorf("short object ID %s is ambiguous", prefix)
+}
+
+// Split "main~2^2" into "main" and "~2^2" - ok is false if there is no ancestry suffix
+func cutRevisionSuffix(name string) (string, string, bool) {
+	index := strings.IndexAny(name, "~^")
+	if index <= 0 {
+		return name, "", false
+	}
+	return name[:index], name[index:], true
+}
+
+// Follow ~<n>, ^<n> and ^{<type>} steps from commit
+func followRevisionSuffix(hash, suffix string) (string, error) {
+	for suffix != "" {
+		// ^{<type>} peels the object (^{} to whatever the tags end at)
+		if strings.HasPrefix(suffix, "^{") {
+			end := strings.IndexByte(suffix, '}')
+			if end < 0 {
+				return "", fmt.Errorf("bad suffix %s", suffix)
+			}
+			peeled, err := peelObject(hash, suffix[2:end])
+			if err != nil {
+				return "", err
+			}
+			hash, suffix = peeled, suffix[end+1:]
+			continue
+		}
+
+		operator := suffix[0]
+		digits := 0
+		for 1+digits < len(suffix) && suffix[1+digits] >= '0' && suffix[1+digits] <= '9' {
+			digits++
+		}
+		if operator != '~' && operator != '^' {
+			return "", fmt.Errorf("bad suffix %s", suffix)
+		}
+		count := 1
+		if digits > 0 {
+			count, _ = strconv.Atoi(suffix[1 : 1+digits])
+		}
+		suffix = suffix[1+digits:]
+
+		if peeled, err := peelTag(hash); err == nil && peeled != "" {
+			hash = peeled
+		}
+
+		if operator == '^' {
+			if count == 0 {
+				continue
+			}
+			parents, err := readCommitParents(hash)
+			if err != nil {
+				return "", err
+			}
+			if count > len(parents) {
+				return "", fmt.Errorf("commit %s has no parent %d", hash, count)
+			}
+			hash = parents[count-1]
+			continue
+		}
+
+		for ; count > 0; count-- {
+			parents, err := readCommitParents(hash)
+			if err != nil {
+				return "", err
+			}
+			if len(parents) == 0 {
+				return "", fmt.Errorf("commit %s has no parent", hash)
+			}
+			hash = parents[0]
+		}
+	}
+	return hash, nil
+}
@@ -0,0 +1,346 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Refspecs - how refs of one repository map to refs of another, "[+]<source>:<destination>":
+//
+//	+refs/heads/*:refs/remotes/origin/*   every branch to a remote-tracking branch ("*" matches any part of
+//	                                      the name, "/" included - both sides have one)
+//	refs/heads/main:refs/heads/backup     one ref to another
+//	main                                  only the source - fetch writes it to FETCH_HEAD alone, push updates
+//	                                      the ref of the same name
+//	:refs/heads/old                       push of nothing - deletes the remote ref
+//	^refs/heads/wip/*                     negative - refs matching it are left out, whatever else matches them
+//
+// Fetch maps refs the remote advertises (sources) to local refs, push maps local refs to remote ones. Updates
+// that are not fast-forwards (and tag updates) are refused unless the refspec starts with "+". A source that
+// is not a full ref name is looked up like a revision name: <name>, refs/<name>, refs/tags/<name>,
+// refs/heads/<name>, refs/remotes/<name>, refs/remotes/<name>/HEAD - the first existing ref wins.
+
+// Refspec of a mirror - every ref maps to the ref of the same name
+const mirrorRefspec = "+refs/*:refs/*"
+
+// Parse refspec - only the source and destination patterns are checked, a non-glob source can name any
+// revision (push sends it)
+func parseRefspec(value string) (Refspec, error) {
+	spec := Refspec{}
+	text := value
+	if rest, ok := strings.CutPrefix(text, "^"); ok {
+		spec.Negative, text = true, rest
+	} else if rest, ok := strings.CutPrefix(text, "+"); ok {
+		spec.Force, text = true, rest
+	}
+	// Source may be a revision with a colon in it (HEAD:path is not, but <hash>:refs/heads/x is) - the last
+	// colon splits
+	if index := strings.LastIndex(text, ":"); index >= 0 {
+		spec.Source, spec.Destination = text[:index], text[index+1:]
+		spec.Matching = spec.Source == "" && spec.Destination == ""
+		if spec.Negative {
+			return spec, fmt.Errorf("invalid refspec '%s' - negative refspecs have no destination", value)
+		}
+	} else {
+		spec.Source = text
+	}
+
+	sourceGlobs, destinationGlobs := strings.Count(spec.Source, "*"), strings.Count(spec.Destination, "*")
+	switch {
+	case spec.Negative && spec.Source == "":
+		return spec, fmt.Errorf("invalid refspec '%s'", value)
+	case sourceGlobs > 1 || destinationGlobs > 1:
+		return spec, fmt.Errorf("invalid refspec '%s' - only one '*' is allowed", value)
+	case sourceGlobs != destinationGlobs && spec.Destination != "":
+		return spec, fmt.Errorf("invalid refspec '%s' - '*' has to be on both sides", value)
+	}
+	spec.Glob = sourceGlobs == 1
+
+	// "*" stands for a part of a name - with a letter in its place, patterns have to be valid ref names
+	if spec.Glob && !validRefspecName(strings.Replace(spec.Source, "*", "x", 1)) {
+		return spec, fmt.Errorf("invalid refspec '%s'", value)
+	}
+	if spec.Destination != "" && !validRefspecName(strings.Replace(spec.Destination, "*", "x", 1)) {
+		return spec, fmt.Errorf("invalid refspec '%s'", value)
+	}
+	return spec, nil
+}
+
+// Parse list of refspecs
+func parseRefspecs(values []string) ([]Refspec, error) {
+	specs := make([]Refspec, 0, len(values))
+	for _, value := range values {
+		spec, err := parseRefspec(value)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// Check ref name in refspec - full (refs/...), HEAD, or a short name that is valid under refs/heads/
+func validRefspecName(name string) bool {
+	if name == "HEAD" {
+		return true
+	}
+	if strings.HasPrefix(name, "refs/") {
+		return checkRefName(name) == nil
+	}
+	return checkRefName("refs/heads/"+name) == nil
+}
+
+// Full ref names a short name stands for, in the order they are tried
+func expandRefName(name string) []string {
+	if strings.HasPrefix(name, "refs/") || name == "HEAD" {
+		return []string{name}
+	}
+	return []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"}
+}
+
+// First ref of refs a short name stands for - "" when there is none
+func findRefByShortName(name string, refs map[string]string) string {
+	for _, refName := range expandRefName(name) {
+		if _, ok := refs[refName]; ok {
+			return refName
+		}
+	}
+	return ""
+}
+
+// Match ref against refspec side - a glob pattern returns the part "*" stands for
+func matchRefspecSide(pattern string, glob bool, refName string) (string, bool) {
+	if !glob {
+		for _, candidate := range expandRefName(pattern) {
+			if candidate == refName {
+				return "", true
+			}
+		}
+		return "", false
+	}
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	if len(refName) < len(prefix)+len(suffix) || !strings.HasPrefix(refName, prefix) || !strings.HasSuffix(refName, suffix) {
+		return "", false
+	}
+	return refName[len(prefix) : len(refName)-len(suffix)], true
+}
+
+// Map ref matching the source to the destination ("" for a refspec without one)
+func (spec Refspec) mapSource(refName string) (string, bool) {
+	if spec.Negative || spec.Source == "" {
+		return "", false
+	}
+	matched, ok := matchRefspecSide(spec.Source, spec.Glob, refName)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(spec.Destination, "*", matched, 1), true
+}
+
+// Map ref matching the destination back to the source
+func (spec Refspec) mapDestination(refName string) (string, bool) {
+	if spec.Negative || spec.Destination == "" {
+		return "", false
+	}
+	matched, ok := matchRefspecSide(spec.Destination, spec.Glob, refName)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(spec.Source, "*", matched, 1), true
+}
+
+// Check whether a negative refspec leaves source ref out
+func excludedByRefspecs(specs []Refspec, refName string) bool {
+	for _, spec := range specs {
+		if !spec.Negative {
+			continue
+		}
+		if _, ok := matchRefspecSide(spec.Source, spec.Glob, refName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Map source ref through the first refspec with a destination that matches it - "" when none does
+func mapRefThroughRefspecs(specs []Refspec, refName string) (string, Refspec) {
+	if excludedByRefspecs(specs, refName) {
+		return "", Refspec{}
+	}
+	for _, spec := range specs {
+		if destination, ok := spec.mapSource(refName); ok && destination != "" {
+			return destination, spec
+		}
+	}
+	return "", Refspec{}
+}
+
+// Full name for short destination - a ref of the same kind as the source (tag or branch), a branch otherwise
+func expandRefspecDestination(destination, source string) string {
+	if strings.HasPrefix(destination, "refs/") || destination == "HEAD" {
+		return destination
+	}
+	if strings.HasPrefix(source, "refs/tags/") {
+		return "refs/tags/" + destination
+	}
+	return "refs/heads/" + destination
+}
+
+// Local ref a fetch may write - a valid name below refs/ (a glob can map a remote ref to a name that is not)
+func validFetchDestination(refName string) bool {
+	return strings.HasPrefix(refName, "refs/") && checkRefName(refName) == nil
+}
+
+// Select advertised refs that fetch refspecs ask for and map them to local refs (Destination "" - only
+// FETCH_HEAD gets the ref). Glob refspecs take every matching ref (peeled ^{} entries are not refs), other
+// refspecs have to find their ref. Mappings to invalid local ref names are skipped, as git does.
+func mapFetchRefspecs(specs []Refspec, remoteRefs map[string]string) ([]refMapping, error) {
+	var refNames []string
+	for refName := range remoteRefs {
+		if refName != "HEAD" && !strings.HasSuffix(refName, "^{}") && checkRefName(refName) == nil {
+			refNames = append(refNames, refName)
+		}
+	}
+	sort.Strings(refNames)
+
+	var mappings []refMapping
+	seen := make(map[refMapping]bool)
+	add := func(mapping refMapping) {
+		if mapping.Destination != "" && !validFetchDestination(mapping.Destination) {
+			return
+		}
+		if !seen[mapping] && !excludedByRefspecs(specs, mapping.Source) {
+			seen[mapping] = true
+			mappings = append(mappings, mapping)
+		}
+	}
+	for _, spec := range specs {
+		switch {
+		case spec.Negative:
+			continue
+		case spec.Glob:
+			for _, refName := range refNames {
+				if destination, ok := spec.mapSource(refName); ok {
+					add(refMapping{Source: refName, Destination: destination, Hash: remoteRefs[refName], Force: spec.Force})
+				}
+			}
+		default:
+			source := spec.Source
+			if source == "" {
+				source = "HEAD"
+			}
+			refName := findRefByShortName(source, remoteRefs)
+			if refName == "" || (refName != "HEAD" && checkRefName(refName) != nil) {
+				return nil, fmt.Errorf("couldn't find remote ref %s", source)
+			}
+			destination := ""
+			if spec.Destination != "" {
+				destination = expandRefspecDestination(spec.Destination, refName)
+			}
+			add(refMapping{Source: refName, Destination: destination, Hash: remoteRefs[refName], Force: spec.Force})
+		}
+	}
+	return mappings, nil
+}
+
+// Map every advertised ref to the ref of the same name (mirror clone, remote refs a mirror push deletes) -
+// through mapFetchRefspecs, so refs with invalid names are left out
+func mapMirrorRefs(refs map[string]string) ([]refMapping, error) {
+	specs, err := parseRefspecs([]string{mirrorRefspec})
+	if err != nil {
+		return nil, err
+	}
+	return mapFetchRefspecs(specs, refs)
+}
+
+// Map local refs to remote refs through push refspecs - a source is a local revision (globs match local
+// refs), a short destination names the remote ref it stands for or a new ref of the source's kind. Mapping
+// without Hash deletes the remote ref. ":" pushes branches that exist on both sides under their names.
+func mapPushRefspecs(specs []Refspec, localRefs, remoteRefs map[string]string) ([]refMapping, error) {
+	var localNames []string
+	for refName := range localRefs {
+		localNames = append(localNames, refName)
+	}
+	sort.Strings(localNames)
+
+	var mappings []refMapping
+	seen := make(map[string]bool)
+	add := func(mapping refMapping) {
+		if !seen[mapping.Destination] && (mapping.Source == "" || !excludedByRefspecs(specs, mapping.Source)) {
+			seen[mapping.Destination] = true
+			mappings = append(mappings, mapping)
+		}
+	}
+	for _, spec := range specs {
+		switch {
+		case spec.Negative:
+			continue
+		case spec.Matching:
+			for _, refName := range localNames {
+				if _, ok := remoteRefs[refName]; ok && strings.HasPrefix(refName, "refs/heads/") {
+					add(refMapping{Source: refName, Destination: refName, Hash: localRefs[refName], Force: spec.Force})
+				}
+			}
+		case spec.Glob:
+			for _, refName := range localNames {
+				if destination, ok := spec.mapSource(refName); ok {
+					add(refMapping{Source: refName, Destination: destination, Hash: localRefs[refName], Force: spec.Force})
+				}
+			}
+		case spec.Source == "":
+			destination := findRefByShortName(spec.Destination, remoteRefs)
+			if destination == "" {
+				return nil, fmt.Errorf("unable to delete '%s': remote ref does not exist", spec.Destination)
+			}
+			add(refMapping{Destination: destination, Force: spec.Force})
+		default:
+			mapping, err := resolvePushSource(spec, localRefs, remoteRefs)
+			if err != nil {
+				return nil, err
+			}
+			add(mapping)
+		}
+	}
+	return mappings, nil
+}
+
+// Resolve source of non-glob push refspec and pick its destination
+func resolvePushSource(spec Refspec, localRefs, remoteRefs map[string]string) (refMapping, error) {
+	source := findRefByShortName(spec.Source, localRefs)
+	hash := localRefs[source]
+	if source == "" || spec.Source == "HEAD" {
+		// HEAD pushes the branch it is on, anything else has to be a revision
+		refName, revision, err := resolveRevision(spec.Source)
+		if err != nil {
+			return refMapping{}, fmt.Errorf("src refspec %s does not match any", spec.Source)
+		}
+		if refName == "HEAD" {
+			if refName, _, err = readHead(); err != nil {
+				return refMapping{}, err
+			}
+		}
+		source, hash = refName, revision
+	}
+
+	destination := spec.Destination
+	switch {
+	case destination == "" && source == "":
+		return refMapping{}, fmt.Errorf("the destination of '%s' has to be given - the source is not a ref", spec.Source)
+	case destination == "":
+		destination = source
+	case strings.HasPrefix(destination, "refs/"):
+	case findRefByShortName(destination, remoteRefs) != "":
+		destination = findRefByShortName(destination, remoteRefs)
+	case source == "":
+		return refMapping{}, fmt.Errorf("the destination you provided is not a full refname (i.e., starting with \"refs/\") - '%s'", spec.Destination)
+	default:
+		destination = expandRefspecDestination(destination, source)
+	}
+
+	display := source
+	if spec.Source == "HEAD" || source == "" {
+		display = spec.Source
+	}
+	return refMapping{Source: display, Destination: destination, Hash: hash, Force: spec.Force}, nil
+}
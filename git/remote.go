@@ -0,0 +1,417 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Remotes - [remote "<name>"] sections of .git/config: url (and pushurl when pushing goes elsewhere) and
+// fetch refspecs mapping the remote's branches to remote-tracking refs, by default
+//
+//	+refs/heads/*:refs/remotes/<name>/*
+//
+// Renaming or removing a remote moves or deletes its remote-tracking refs and updates the branches that
+// track it. remote show asks the remote for its refs and compares them with the remote-tracking refs:
+//
+//	tracked   the remote branch has a remote-tracking ref
+//	new       it will get one on the next fetch
+//	stale     remote-tracking ref of a branch the remote no longer has
+
+// Write names of remotes - with verbose, also their fetch and push URLs
+func listRemotes(verbose bool, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	for _, name := range config.Subsections("remote") {
+		if !verbose {
+			fmt.Fprintln(w, name)
+			continue
+		}
+		fetchUrl, _ := config.Get("remote." + name + ".url")
+		fmt.Fprintf(w, "%s\t%s (fetch)\n", name, fetchUrl)
+		for _, pushUrl := range remotePushUrls(config, name) {
+			fmt.Fprintf(w, "%s\t%s (push)\n", name, pushUrl)
+		}
+	}
+	return nil
+}
+
+// Push URLs of remote - remote.<name>.pushurl values, or its URL when there are none
+func remotePushUrls(config *Config, name string) []string {
+	if pushUrls := config.GetAll("remote." + name + ".pushurl"); len(pushUrls) > 0 {
+		return pushUrls
+	}
+	fetchUrl, _ := config.Get("remote." + name + ".url")
+	return []string{fetchUrl}
+}
+
+// Check remote name - it has to make valid remote-tracking ref names
+func checkRemoteName(name string) error {
+	if strings.HasPrefix(name, "-") || checkRefName("refs/remotes/"+name+"/test") != nil {
+		return fmt.Errorf("'%s' is not a valid remote name", name)
+	}
+	return nil
+}
+
+// Check whether remote is configured
+func remoteExists(config *Config, name string) bool {
+	for _, remote := range config.Subsections("remote") {
+		if remote == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add remote with the default fetch refspec
+func addRemote(name, remoteUrl string) error {
+	if err := checkRemoteName(name); err != nil {
+		return err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if remoteExists(config, name) {
+		return fmt.Errorf("remote %s already exists", name)
+	}
+	configPath := gitDirPath("config")
+	if err := setConfigValue(configPath, "remote."+name+".url", remoteUrl); err != nil {
+		return fmt.Errorf("failed to write remote config: %w", err)
+	}
+	if err := setConfigValue(configPath, "remote."+name+".fetch", "+refs/heads/*:refs/remotes/"+name+"/*"); err != nil {
+		return fmt.Errorf("failed to write remote config: %w", err)
+	}
+	return nil
+}
+
+// Remove remote with its remote-tracking refs - branches tracking it lose their upstream
+func removeRemote(name string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !remoteExists(config, name) {
+		return fmt.Errorf("no such remote: '%s'", name)
+	}
+	refs, err := listRefs("refs/remotes/" + name + "/")
+	if err != nil {
+		return err
+	}
+	for _, refName := range sortedKeys(refs) {
+		if err := deleteRef(refName); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(gitDirPath("logs", "refs", "remotes", name)); err != nil {
+		return fmt.Errorf("failed to remove reflogs of %s: %w", name, err)
+	}
+
+	configPath := gitDirPath("config")
+	for _, branch := range config.Subsections("branch") {
+		if remote, _ := config.Get("branch." + branch + ".remote"); remote != name {
+			continue
+		}
+		// Section goes away when the upstream was all it had
+		if len(configSectionKeys(config, "branch."+branch)) <= 2 {
+			if err := removeConfigSection(configPath, "branch."+branch); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := unsetConfigValue(configPath, "branch."+branch+".remote"); err != nil {
+			return err
+		}
+		if err := unsetConfigValue(configPath, "branch."+branch+".merge"); err != nil {
+			return err
+		}
+	}
+	return removeConfigSection(configPath, "remote."+name)
+}
+
+// Keys set in section (e.g. branch.main)
+func configSectionKeys(config *Config, section string) []string {
+	prefix := normalizeConfigSection(section) + "."
+	var keys []string
+	for key := range config.values {
+		if rest, ok := strings.CutPrefix(key, prefix); ok && !strings.Contains(rest, ".") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Rename remote - its remote-tracking refs, fetch refspecs and branches tracking it follow
+func renameRemote(oldName, newName string) error {
+	if err := checkRemoteName(newName); err != nil {
+		return err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !remoteExists(config, oldName) {
+		return fmt.Errorf("no such remote: '%s'", oldName)
+	}
+	if remoteExists(config, newName) {
+		return fmt.Errorf("remote %s already exists", newName)
+	}
+
+	configPath := gitDirPath("config")
+	if err := renameConfigSection(configPath, "remote."+oldName, "remote."+newName); err != nil {
+		return err
+	}
+	oldPrefix, newPrefix := "refs/remotes/"+oldName+"/", "refs/remotes/"+newName+"/"
+	if specs := config.GetAll("remote." + oldName + ".fetch"); len(specs) > 0 {
+		if err := unsetConfigValue(configPath, "remote."+newName+".fetch"); err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			if err := addConfigValue(configPath, "remote."+newName+".fetch", strings.Replace(spec, ":"+oldPrefix, ":"+newPrefix, 1)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, branch := range config.Subsections("branch") {
+		if remote, _ := config.Get("branch." + branch + ".remote"); remote == oldName {
+			if err := setConfigValue(configPath, "branch."+branch+".remote", newName); err != nil {
+				return err
+			}
+		}
+	}
+
+	refs, err := listRefs(oldPrefix)
+	if err != nil {
+		return err
+	}
+	for _, refName := range sortedKeys(refs) {
+		newRef := newPrefix + strings.TrimPrefix(refName, oldPrefix)
+		if target := readSymbolicRef(refName); target != "" {
+			err = writeSymbolicRef(newRef, strings.Replace(target, oldPrefix, newPrefix, 1))
+		} else {
+			err = updateRef(newRef, refs[refName], "remote: renamed "+refName+" to "+newRef)
+		}
+		if err != nil {
+			return err
+		}
+		if err := deleteRef(refName); err != nil {
+			return err
+		}
+	}
+	oldLogs := gitDirPath("logs", "refs", "remotes", oldName)
+	if _, err := os.Stat(oldLogs); err == nil {
+		if err := os.Rename(oldLogs, gitDirPath("logs", "refs", "remotes", filepath.FromSlash(newName))); err != nil {
+			return fmt.Errorf("failed to move reflogs of %s: %w", oldName, err)
+		}
+	}
+	return nil
+}
+
+// Change URL of remote - with push, the URL pushes go to (remote.<name>.pushurl)
+func setRemoteUrl(name, remoteUrl string, push bool) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !remoteExists(config, name) {
+		return fmt.Errorf("no such remote '%s'", name)
+	}
+	key := "remote." + name + ".url"
+	if push {
+		key = "remote." + name + ".pushurl"
+	}
+	return setConfigValue(gitDirPath("config"), key, remoteUrl)
+}
+
+// Write remote's URLs, its branches compared with the remote-tracking refs, branches that pull from it and
+// branches that push to it - noQuery doesn't contact the remote, only what is known locally is written
+func showRemote(ctx context.Context, name string, noQuery bool, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	fetchUrl, _ := config.Get("remote." + name + ".url")
+	if !remoteExists(config, name) {
+		// Like git, an unknown name is shown as a remote with that URL
+		fetchUrl = name
+	}
+	fmt.Fprintf(w, "* remote %s\n  Fetch URL: %s\n", name, fetchUrl)
+	for _, pushUrl := range remotePushUrls(config, name) {
+		if pushUrl == "" {
+			pushUrl = name
+		}
+		fmt.Fprintf(w, "  Push  URL: %s\n", pushUrl)
+	}
+	specs, err := parseRefspecs(config.GetAll("remote." + name + ".fetch"))
+	if err != nil {
+		return err
+	}
+	tracking, err := listRefs("refs/remotes/" + name + "/")
+	if err != nil {
+		return err
+	}
+
+	var remoteRefs map[string]string
+	if noQuery {
+		fmt.Fprintf(w, "  HEAD branch: (not queried)\n")
+		var names []string
+		for _, refName := range sortedKeys(tracking) {
+			if readSymbolicRef(refName) == "" {
+				names = append(names, strings.TrimPrefix(refName, "refs/remotes/"+name+"/"))
+			}
+		}
+		if len(names) > 0 {
+			fmt.Fprintf(w, "  Remote %s: (status not queried)\n", plural(len(names), "branch", "branches"))
+			for _, branch := range names {
+				fmt.Fprintf(w, "    %s\n", branch)
+			}
+		}
+	} else {
+		transport, err := newTransport(resolveRemoteUrl(name), "")
+		if err != nil {
+			return err
+		}
+		defer transport.Close()
+		var headBranch string
+		remoteRefs, _, headBranch, _, err = discoverRemoteRefs(ctx, transport, []string{"HEAD", "refs/heads/"})
+		if err != nil {
+			return err
+		}
+		if headBranch == "" {
+			headBranch = "(unknown)"
+		}
+		fmt.Fprintf(w, "  HEAD branch: %s\n", headBranch)
+		writeRemoteBranchStates(name, specs, remoteRefs, tracking, w)
+	}
+
+	if err := writePullBranches(config, name, w); err != nil {
+		return err
+	}
+	return writePushBranches(remoteRefs, noQuery, w)
+}
+
+// Write states of remote's branches - tracked, new or stale
+func writeRemoteBranchStates(name string, specs []Refspec, remoteRefs, tracking map[string]string, w io.Writer) {
+	states := make(map[string]string)
+	tracked := make(map[string]bool)
+	for refName := range remoteRefs {
+		branch, ok := strings.CutPrefix(refName, "refs/heads/")
+		if !ok {
+			continue
+		}
+		trackingRef, _ := mapRefThroughRefspecs(specs, refName)
+		switch {
+		case trackingRef == "":
+			continue
+		case tracking[trackingRef] != "":
+			states[branch], tracked[trackingRef] = "tracked", true
+		default:
+			states[branch] = "new (next fetch will store in remotes/" + name + ")"
+		}
+	}
+	for refName := range tracking {
+		if !tracked[refName] && readSymbolicRef(refName) == "" {
+			states[refName] = "stale (use 'git remote prune' to remove)"
+		}
+	}
+	if len(states) == 0 {
+		return
+	}
+
+	names := sortedKeys(states)
+	width := 0
+	for _, branch := range names {
+		width = max(width, len(branch))
+	}
+	fmt.Fprintf(w, "  Remote %s:\n", plural(len(names), "branch", "branches"))
+	for _, branch := range names {
+		fmt.Fprintf(w, "    %-*s %s\n", width, branch, states[branch])
+	}
+}
+
+// Write local branches whose upstream is on remote
+func writePullBranches(config *Config, name string, w io.Writer) error {
+	merges := make(map[string]string)
+	for _, branch := range config.Subsections("branch") {
+		remote, _ := config.Get("branch." + branch + ".remote")
+		merge, _ := config.Get("branch." + branch + ".merge")
+		if remote != name || merge == "" {
+			continue
+		}
+		action := "merges with remote "
+		if config.GetBool("branch."+branch+".rebase", false) {
+			action = "rebases onto remote "
+		}
+		merges[branch] = action + strings.TrimPrefix(merge, "refs/heads/")
+	}
+	if len(merges) == 0 {
+		return nil
+	}
+
+	branches := sortedKeys(merges)
+	width := 0
+	for _, branch := range branches {
+		width = max(width, len(branch))
+	}
+	fmt.Fprintf(w, "  Local %s configured for 'git pull':\n", plural(len(branches), "branch", "branches"))
+	for _, branch := range branches {
+		fmt.Fprintf(w, "    %-*s %s\n", width, branch, merges[branch])
+	}
+	return nil
+}
+
+// Write local branches that push to the branches of the same name on remote (matching push) with what a
+// push would do - remoteRefs is nil when the remote wasn't queried
+func writePushBranches(remoteRefs map[string]string, noQuery bool, w io.Writer) error {
+	if noQuery {
+		fmt.Fprintf(w, "  Local ref configured for 'git push' (status not queried):\n    (matching) pushes to (matching)\n")
+		return nil
+	}
+	local, err := listRefs("refs/heads/")
+	if err != nil {
+		return err
+	}
+	states := make(map[string]string)
+	for refName, hash := range local {
+		remoteHash, ok := remoteRefs[refName]
+		if !ok {
+			continue
+		}
+		switch {
+		case remoteHash == hash:
+			states[refName] = "up to date"
+		default:
+			// Fast-forward needs the remote commit to be known here, in the history of the local one
+			states[refName] = "local out of date"
+			if exists, err := objectExists(remoteHash); err == nil && exists {
+				if ok, err := isAncestor(remoteHash, hash); err != nil {
+					return err
+				} else if ok {
+					states[refName] = "fast-forwardable"
+				}
+			}
+		}
+	}
+	if len(states) == 0 {
+		return nil
+	}
+
+	refNames := sortedKeys(states)
+	width := 0
+	for _, refName := range refNames {
+		width = max(width, len(strings.TrimPrefix(refName, "refs/heads/")))
+	}
+	fmt.Fprintf(w, "  Local %s configured for 'git push':\n", plural(len(refNames), "ref", "refs"))
+	for _, refName := range refNames {
+		branch := strings.TrimPrefix(refName, "refs/heads/")
+		fmt.Fprintf(w, "    %-*s pushes to %-*s (%s)\n", width, branch, width, branch, states[refName])
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Rename and copy detection - added files are paired with deleted files (renames) or, with copy detection,
+// with files that stay (copies) when their content is similar enough. Similarity is estimated like git does:
+// content is cut into chunks (lines, long lines every 64 bytes) and bytes of chunks both files have count as
+// copied:
+//
+//	score = copied bytes * diffMaxScore / size of the bigger file
+//
+// Identical blobs are paired first, then the best scoring pairs above the minimum score. A deleted file
+// that became several files is renamed to the last of them (by path) and copied to the others.
+
+// Similarity scale - scores are in 1/diffMaxScore units, shown as percentage
+const diffMaxScore = 60000
+const diffDefaultMinScore = 30000
+
+// Longest chunk used for similarity estimation - longer lines are split
+const similarityChunkSize = 64
+const similarityHashBase = 107927
+
+// Default of diff.renameLimit - above renameLimit^2 source/destination pairs, only identical files are paired
+const defaultRenameLimit = 1000
+
+// Values of DiffOptions.Renames
+const (
+	DiffRenamesDefault = iota
+	DiffRenamesOff
+	DiffRenamesOn
+	DiffCopiesOn
+)
+
+// Parse -M/-C score - "5" and "50" mean 50% (digits are a fraction), "50%" is a percentage (same as git)
+func ParseRenameScore(value string) (int, error) {
+	num, scale := 0, 1
+	dot := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '.' && !dot:
+			scale, dot = 1, true
+		case c == '%' && i == len(value)-1:
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+		case c >= '0' && c <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			return 0, fmt.Errorf("bad rename score: %s", value)
+		}
+	}
+	if num >= scale {
+		return diffMaxScore, nil
+	}
+	return diffMaxScore * num / scale, nil
+}
+
+// Fill in what options leave to configuration - diff.renames (true, false or copies) decides whether
+// renames are detected, renames are detected by default; diff.algorithm is the line diff algorithm
+func resolveDiffOptions(options DiffOptions) (DiffOptions, error) {
+	if options.MinScore == 0 {
+		options.MinScore = diffDefaultMinScore
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return options, err
+	}
+	if options.Algorithm == "" {
+		options.Algorithm, _ = config.Get("diff.algorithm")
+	}
+	if _, err := lookupDiffAlgorithm(options.Algorithm); err != nil {
+		return options, err
+	}
+	if options.Renames != DiffRenamesDefault {
+		return options, nil
+	}
+
+	options.Renames = DiffRenamesOn
+	if value, ok := config.Get("diff.renames"); ok {
+		switch {
+		case strings.EqualFold(value, "copies") || strings.EqualFold(value, "copy"):
+			options.Renames = DiffCopiesOn
+		case !parseBoolValue(value, true):
+			options.Renames = DiffRenamesOff
+		}
+	}
+	return options, nil
+}
+
+// Pair added files in changes with their sources - changes must be sorted by path, oldFiles is the old
+// tree (copy sources with FindCopiesHarder). Returned changes stay sorted by new path.
+func detectRenames(changes []FileChange, oldFiles map[string]TreeEntry, options DiffOptions) ([]FileChange, error) {
+	if options.Renames == DiffRenamesOff || options.Renames == DiffRenamesDefault {
+		return changes, nil
+	}
+	copies := options.Renames == DiffCopiesOn
+
+	// Sources are deleted files, with copies also modified ones (and every old file when looking harder)
+	// Files that stay count as one use of themselves, so they are never renamed
+	var sources []FileChange
+	var destinations []int
+	sourceUses := make(map[string]int)
+	changed := make(map[string]bool)
+	for i, change := range changes {
+		changed[change.OldPath] = true
+		switch {
+		case change.Status == 'A' && change.NewMode != "160000":
+			destinations = append(destinations, i)
+		case change.Status == 'D' && change.OldMode != "160000":
+			sources = append(sources, change)
+		case change.Status == 'M' && copies && change.OldMode != "160000":
+			sources = append(sources, change)
+			sourceUses[change.OldPath] = 1
+		}
+	}
+	if copies && options.FindCopiesHarder {
+		for _, filePath := range sortedKeys(oldFiles) {
+			if entry := oldFiles[filePath]; !changed[filePath] && entry.Mode != "160000" {
+				sources = append(sources, FileChange{Status: 'M', OldPath: filePath, OldMode: entry.Mode, OldHash: entry.Hash})
+				sourceUses[filePath] = 1
+			}
+		}
+	}
+	if len(sources) == 0 || len(destinations) == 0 {
+		return changes, nil
+	}
+
+	// Source of every paired destination (index into sources) and its score
+	pairedSource := make(map[int]int)
+	pairedScore := make(map[int]int)
+	pair := func(dst, src, score int) {
+		pairedSource[dst], pairedScore[dst] = src, score
+		sourceUses[sources[src].OldPath]++
+	}
+
+	// Identical blobs - source with the same file name is preferred
+	for _, dst := range destinations {
+		best := -1
+		for src, source := range sources {
+			if source.OldHash != changes[dst].NewHash || (!copies && sourceUses[source.OldPath] > 0) {
+				continue
+			}
+			if best == -1 || (path.Base(source.OldPath) == path.Base(changes[dst].NewPath) &&
+				path.Base(sources[best].OldPath) != path.Base(changes[dst].NewPath)) {
+				best = src
+			}
+		}
+		if best != -1 {
+			pair(dst, best, diffMaxScore)
+		}
+	}
+
+	var remaining []int
+	for _, dst := range destinations {
+		if _, ok := pairedSource[dst]; !ok {
+			remaining = append(remaining, dst)
+		}
+	}
+	if err := findInexactRenames(changes, sources, remaining, options, copies, sourceUses, pair); err != nil {
+		return nil, err
+	}
+	if len(pairedSource) == 0 {
+		return changes, nil
+	}
+
+	// Rename takes the place of the added file, deleted sources disappear - every use of a source but the last
+	// one is a copy, and so are all uses of sources that stay
+	usedSources := make(map[string]bool)
+	for _, src := range pairedSource {
+		usedSources[sources[src].OldPath] = true
+	}
+	var result []FileChange
+	for i, change := range changes {
+		if src, ok := pairedSource[i]; ok {
+			source := sources[src]
+			sourceUses[source.OldPath]--
+			status := byte('R')
+			if sourceUses[source.OldPath] > 0 {
+				status = 'C'
+			}
+			result = append(result, FileChange{
+				Status: status, OldPath: source.OldPath, NewPath: change.NewPath,
+				OldMode: source.OldMode, NewMode: change.NewMode, OldHash: source.OldHash, NewHash: change.NewHash,
+				Similarity: pairedScore[i] * 100 / diffMaxScore,
+			})
+			continue
+		}
+		if change.Status == 'D' && usedSources[change.OldPath] {
+			continue
+		}
+		result = append(result, change)
+	}
+	return result, nil
+}
+
+// Pair destinations with similar sources - best scores first, renames (deleted sources not used yet)
+// before copies
+func findInexactRenames(changes, sources []FileChange, destinations []int, options DiffOptions, copies bool,
+	sourceUses map[string]int, pair func(dst, src, score int)) error {
+	if len(destinations) == 0 {
+		return nil
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	limit := int(config.GetInt("diff.renameLimit", defaultRenameLimit))
+	if limit > 0 && len(sources)*len(destinations) > limit*limit {
+		fmt.Fprintf(os.Stderr, "warning: inexact rename detection was skipped due to too many files.\n")
+		return nil
+	}
+
+	contents := make(map[string][]byte)
+	chunks := make(map[string]map[uint32]int)
+	load := func(hash string) ([]byte, map[uint32]int, error) {
+		if content, ok := contents[hash]; ok {
+			return content, chunks[hash], nil
+		}
+		content, err := readDiffBlob(hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		contents[hash], chunks[hash] = content, similarityChunks(content)
+		return content, chunks[hash], nil
+	}
+
+	var candidates []renameCandidate
+	for _, dst := range destinations {
+		change := changes[dst]
+		if !isRegularFileMode(change.NewMode) {
+			continue
+		}
+		dstContent, dstChunks, err := load(change.NewHash)
+		if err != nil {
+			return err
+		}
+		for src, source := range sources {
+			if !isRegularFileMode(source.OldMode) {
+				continue
+			}
+			srcContent, srcChunks, err := load(source.OldHash)
+			if err != nil {
+				return err
+			}
+			score := similarityScore(len(srcContent), srcChunks, len(dstContent), dstChunks, options.MinScore)
+			if score >= options.MinScore {
+				candidates = append(candidates, renameCandidate{
+					src: src, dst: dst, score: score,
+					sameName: path.Base(source.OldPath) == path.Base(change.NewPath),
+				})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].sameName && !candidates[j].sameName
+	})
+
+	paired := make(map[int]bool)
+	for _, copyPass := range []bool{false, true} {
+		if copyPass && !copies {
+			break
+		}
+		for _, candidate := range candidates {
+			if paired[candidate.dst] || (!copyPass && sourceUses[sources[candidate.src].OldPath] > 0) {
+				continue
+			}
+			paired[candidate.dst] = true
+			pair(candidate.dst, candidate.src, candidate.score)
+		}
+	}
+	return nil
+}
+
+func isRegularFileMode(mode string) bool {
+	return mode == "100644" || mode == "100755"
+}
+
+// Similarity of two files in diffMaxScore units - 0 when sizes alone make minScore impossible
+func similarityScore(srcSize int, srcChunks map[uint32]int, dstSize int, dstChunks map[uint32]int, minScore int) int {
+	maxSize, baseSize := max(srcSize, dstSize), min(srcSize, dstSize)
+	if dstSize == 0 || int64(maxSize)*int64(diffMaxScore-minScore) < int64(maxSize-baseSize)*diffMaxScore {
+		return 0
+	}
+
+	copied := 0
+	for hash, srcCount := range srcChunks {
+		copied += min(srcCount, dstChunks[hash])
+	}
+	return int(int64(copied) * diffMaxScore / int64(maxSize))
+}
+
+// Bytes of content per chunk hash - a chunk ends after a newline or similarityChunkSize bytes, CR of CRLF
+// is left out in text files
+func similarityChunks(content []byte) map[uint32]int {
+	text := !isBinaryContent(content)
+	chunks := make(map[uint32]int)
+	for i := 0; i < len(content); {
+		var accum1, accum2 uint32
+		n := 0
+		for i < len(content) {
+			c := uint32(content[i])
+			i++
+			if text && c == '\r' && i < len(content) && content[i] == '\n' {
+				continue
+			}
+			old1 := accum1
+			accum1 = (accum1 << 7) ^ (accum2 >> 25)
+			accum2 = (accum2 << 7) ^ (old1 >> 25)
+			accum1 += c
+			n++
+			if n == similarityChunkSize || c == '\n' {
+				break
+			}
+		}
+		chunks[(accum1+accum2*0x61)%similarityHashBase] += n
+	}
+	return chunks
+}
+
+// Name of renamed/copied file for diffstat - common leading directories and trailing path are only shown once:
+// "dir/{old => new}/file" (same as git)
+func renamedPathName(oldPath, newPath string) string {
+	// Common prefix ends with a slash, common suffix starts with one (it may reuse the slash ending the prefix)
+	prefix := 0
+	for i := 0; i < len(oldPath) && i < len(newPath) && oldPath[i] == newPath[i]; i++ {
+		if oldPath[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	limit := max(prefix-1, 0)
+	suffix := 0
+	for i, j := len(oldPath)-1, len(newPath)-1; i >= limit && j >= limit && oldPath[i] == newPath[j]; i, j = i-1, j-1 {
+		if oldPath[i] == '/' {
+			suffix = len(oldPath) - i
+		}
+	}
+	if prefix == 0 && suffix == 0 {
+		return oldPath + " => " + newPath
+	}
+
+	oldMiddle := oldPath[prefix : prefix+max(len(oldPath)-prefix-suffix, 0)]
+	newMiddle := newPath[prefix : prefix+max(len(newPath)-prefix-suffix, 0)]
+	return fmt.Sprintf("%s{%s => %s}%s", oldPath[:prefix], oldMiddle, newMiddle, oldPath[len(oldPath)-suffix:])
+}
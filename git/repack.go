@@ -0,0 +1,477 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Repack - consolidate packs in objects/pack:
+//   - (default)          loose objects that are not packed yet go to a new pack
+//   - -a                 every reachable object (from refs, HEAD, reflogs, the index and MERGE_HEAD) goes to
+//     one new pack, with a bitmap when -b or repack.writeBitmaps is set (by default in bare repositories)
+//   - --geometric=<n>    packs sorted by object count have to grow by a factor of n - the smallest ones that
+//     break the progression (and loose objects) are rolled into one new pack, so the many small packs of
+//     incremental updates don't pile up while big packs are not rewritten every time
+//
+// -d deletes packs whose objects are now in the new pack, and loose objects that are packed. Unreachable
+// objects of deleted packs are lost with -a, -A writes them out as loose objects first - with the time of
+// their pack, and only from packs newer than --unpack-unreachable (see prune.go). Packs with .keep (and
+// .promisor packs of partial clones) are never rewritten or deleted - objects in them are not packed again
+// either. Deleting packs removes the multi-pack-index, which would still name them.
+
+// Repack objects - writes what was done to w
+func runRepack(options RepackOptions, w io.Writer) error {
+	defer ignoreReplaceRefs()()
+	if options.Geometric > 0 && options.All {
+		return fmt.Errorf("options '--geometric' and '-A/-a' cannot be used together")
+	}
+	if options.Geometric == 1 {
+		return fmt.Errorf("geometric factor must be at least 2")
+	}
+	if options.WriteBitmap && !options.All {
+		return fmt.Errorf("--write-bitmap-index needs -a")
+	}
+	defer tracePerformance("repack")()
+
+	switch {
+	case options.All:
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		bitmap := options.WriteBitmap || config.GetBool("repack.writeBitmaps", resolveRepoLayout().Bare)
+		var unpackExpire time.Time
+		if options.UnpackUnreachable != "" {
+			if unpackExpire, err = parseExpiryDate(options.UnpackUnreachable, time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := repackAll(options, bitmap, unpackExpire, w); err != nil {
+			return err
+		}
+	case options.Geometric > 0:
+		if err := repackGeometric(options.Geometric, options.Delete, w); err != nil {
+			return err
+		}
+	default:
+		packed, err := packLooseObjects(0, w)
+		if err != nil {
+			return err
+		}
+		if packed == 0 {
+			fmt.Fprintln(w, "Nothing new to pack.")
+		}
+	}
+
+	if options.Delete {
+		if _, err := prunePackedObjects(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pack every reachable object that is not in a kept pack into one pack - with options.Delete, other packs are
+// deleted (unreachable objects in them are written loose first with options.KeepUnreachable, unless their
+// pack is not newer than unpackExpire)
+func repackAll(options RepackOptions, bitmap bool, unpackExpire time.Time, w io.Writer) error {
+	packs, err := listLocalPacks()
+	if err != nil {
+		return err
+	}
+	kept := keptObjects(packs)
+	roots, err := reachabilityRoots()
+	if err != nil {
+		return err
+	}
+	reachable, err := collectReachableObjects(roots, kept)
+	if err != nil {
+		return err
+	}
+
+	newPack := ""
+	if len(reachable) > 0 {
+		if newPack, err = writePack(reachable, bitmap); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Packed %d objects into %s\n", len(reachable), newPack)
+	} else {
+		fmt.Fprintln(w, "Nothing new to pack.")
+	}
+	if !options.Delete {
+		return nil
+	}
+
+	var superseded []localPack
+	for _, pack := range packs {
+		if !pack.Keep && filepath.Base(pack.Path) != newPack {
+			superseded = append(superseded, pack)
+		}
+	}
+	if options.KeepUnreachable {
+		packed := make(map[string]bool, len(reachable))
+		for _, object := range reachable {
+			packed[object.Hash] = true
+		}
+		loosened, err := loosenUnreachableObjects(superseded, packed, kept, unpackExpire)
+		if err != nil {
+			return err
+		}
+		if loosened > 0 {
+			fmt.Fprintf(w, "Unpacked %d unreachable objects\n", loosened)
+		}
+	}
+	return removePacks(superseded, w)
+}
+
+// Roll the smallest packs that break the geometric progression (and loose objects) into one new pack - with
+// remove, the rolled up packs are deleted
+func repackGeometric(factor int, remove bool, w io.Writer) error {
+	packs, err := listLocalPacks()
+	if err != nil {
+		return err
+	}
+	var candidates []localPack
+	for _, pack := range packs {
+		if !pack.Keep {
+			candidates = append(candidates, pack)
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Objects < candidates[b].Objects })
+	rolled := candidates[:geometricSplit(candidates, factor)]
+
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, pack := range rolled {
+		for _, hash := range pack.Hashes {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	loose, err := listLooseObjects()
+	if err != nil {
+		return err
+	}
+	for _, hash := range loose {
+		if seen[hash] {
+			continue
+		}
+		raw, _ := hex.DecodeString(hash)
+		if _, _, packed, err := findPackedObject(raw); err != nil {
+			return err
+		} else if !packed {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 || (len(rolled) == 1 && len(hashes) == rolled[0].Objects) {
+		fmt.Fprintln(w, "Nothing new to pack.")
+		return nil
+	}
+
+	objects, err := readGitObjects(hashes)
+	if err != nil {
+		return err
+	}
+	newPack, err := writePack(objects, false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Packed %d objects from %d packs into %s\n", len(objects), len(rolled), newPack)
+	if !remove {
+		return nil
+	}
+	var superseded []localPack
+	for _, pack := range rolled {
+		if filepath.Base(pack.Path) != newPack {
+			superseded = append(superseded, pack)
+		}
+	}
+	return removePacks(superseded, w)
+}
+
+// Number of the smallest packs (sorted by object count) that have to be rolled up - packs after the split
+// grow by at least factor each, and each of them is bigger than factor times all the packs before it together
+func geometricSplit(packs []localPack, factor int) int {
+	if len(packs) == 0 {
+		return 0
+	}
+	split := len(packs) - 1
+	for ; split > 0; split-- {
+		if packs[split].Objects < factor*packs[split-1].Objects {
+			break
+		}
+	}
+	// The bigger pack of the pair that broke the progression can't stay in it either
+	if split > 0 {
+		split++
+	}
+
+	// The new pack may be big enough to break the progression of the packs above it - those join it
+	total := 0
+	for _, pack := range packs[:split] {
+		total += pack.Objects
+	}
+	for split < len(packs) && total*factor > packs[split].Objects {
+		total += packs[split].Objects
+		split++
+	}
+	return split
+}
+
+// Packs in own pack directory, sorted by name
+func listLocalPacks() ([]localPack, error) {
+	packPaths, err := filepath.Glob(objectDirPath("pack", "*.pack"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(packPaths)
+	var packs []localPack
+	for _, packPath := range packPaths {
+		base := strings.TrimSuffix(packPath, ".pack")
+		index, err := parsePackIndex(base + ".idx")
+		if err != nil {
+			// Pack without .idx is still being written (or can't be read anyway)
+			continue
+		}
+		pack := localPack{Path: packPath, Objects: index.count()}
+		for i := 0; i < pack.Objects; i++ {
+			pack.Hashes = append(pack.Hashes, hex.EncodeToString(index.Hashes[i*20:i*20+20]))
+		}
+		for _, marker := range []string{".keep", ".promisor"} {
+			if _, err := os.Stat(base + marker); err == nil {
+				pack.Keep = true
+			}
+		}
+		packs = append(packs, pack)
+	}
+	return packs, nil
+}
+
+// Objects stored in kept packs
+func keptObjects(packs []localPack) map[string]bool {
+	kept := make(map[string]bool)
+	for _, pack := range packs {
+		if pack.Keep {
+			for _, hash := range pack.Hashes {
+				kept[hash] = true
+			}
+		}
+	}
+	return kept
+}
+
+// Objects that must survive repacking and pruning - refs, HEAD, every reflog entry, blobs staged in the
+// index and commits of a merge in progress (MERGE_HEAD)
+func reachabilityRoots() ([]string, error) {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var roots []string
+	add := func(hash string) {
+		if hash != "" && hash != zeroHash && !seen[hash] {
+			seen[hash] = true
+			roots = append(roots, hash)
+		}
+	}
+	for _, name := range sortedKeys(refs) {
+		add(refs[name])
+	}
+	if _, head, err := readHead(); err == nil {
+		add(head)
+	}
+
+	reflogs, err := listReflogs()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range reflogs {
+		entries, err := readReflog(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			add(entry.Old)
+			add(entry.New)
+		}
+	}
+
+	mergeHeads, err := readMergeHeads()
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range mergeHeads {
+		add(hash)
+	}
+
+	if !resolveRepoLayout().Bare {
+		entries, err := readGitIndex()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.Mode != 0160000 {
+				add(hex.EncodeToString(entry.Hash))
+			}
+		}
+		// Cached trees are reused by the next write-tree or commit, so they must stay
+		if state, ok := indexStateCache[indexFilePath()]; ok {
+			for _, hash := range state.CacheTree.validHashes() {
+				add(hash)
+			}
+		}
+	}
+	return roots, nil
+}
+
+// Read objects reachable from roots, with the paths they were found at - objects in exclude are walked
+// through but left out
+func collectReachableObjects(roots []string, exclude map[string]bool) ([]GitObject, error) {
+	var objects []GitObject
+	err := walkReachableObjects(roots, func(object GitObject) {
+		if !exclude[object.Hash] {
+			objects = append(objects, object)
+		}
+	})
+	return objects, err
+}
+
+// Call visit with every object reachable from roots (read, with the path it was found at) - objects missing
+// here (filtered out of a partial clone) are skipped, and parents of shallow commits are not followed
+func walkReachableObjects(roots []string, visit func(object GitObject)) error {
+	shallow, err := loadShallowSet()
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		hash string
+		path string
+	}
+	queue := make([]pending, 0, len(roots))
+	for _, hash := range roots {
+		queue = append(queue, pending{hash, ""})
+	}
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if seen[item.hash] {
+			continue
+		}
+		seen[item.hash] = true
+		if exists, err := objectExists(item.hash); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+
+		typeName, _, content, err := readObjectFromHash(item.hash)
+		if err != nil {
+			return fmt.Errorf("failed to read object %s: %w", item.hash, err)
+		}
+		objType, err := ObjectTypeFromString(typeName)
+		if err != nil {
+			return err
+		}
+		switch objType {
+		case OBJ_COMMIT, OBJ_TAG:
+			references, err := objectReferences(objType, content)
+			if err != nil {
+				return fmt.Errorf("failed to parse object %s: %w", item.hash, err)
+			}
+			if objType == OBJ_COMMIT && shallow[item.hash] {
+				// Tree comes first, parents are not here
+				references = references[:min(1, len(references))]
+			}
+			for _, hash := range references {
+				queue = append(queue, pending{hash, ""})
+			}
+		case OBJ_TREE:
+			entries, err := parseTreeContent(content)
+			if err != nil {
+				return fmt.Errorf("failed to parse object %s: %w", item.hash, err)
+			}
+			for _, entry := range entries {
+				if entry.Mode != "160000" {
+					queue = append(queue, pending{entry.Hash, path.Join(item.path, entry.Name)})
+				}
+			}
+		}
+		visit(GitObject{Type: objType, Data: content, Hash: item.hash, Path: item.path})
+	}
+	return nil
+}
+
+// Write objects of packs that are neither packed again nor kept as loose objects, with the time of their pack
+// (so that they expire as if they were unreachable since then) - packs not newer than expire are skipped,
+// their objects would be pruned right away. Returns how many objects were written.
+func loosenUnreachableObjects(packs []localPack, packed, kept map[string]bool, expire time.Time) (int, error) {
+	loosened := 0
+	for _, pack := range packs {
+		info, err := os.Stat(pack.Path)
+		if err != nil {
+			return loosened, fmt.Errorf("failed to read %s: %w", filepath.Base(pack.Path), err)
+		}
+		packTime := info.ModTime()
+		if !expire.IsZero() && !packTime.After(expire) {
+			continue
+		}
+		for _, hash := range pack.Hashes {
+			if packed[hash] || kept[hash] {
+				continue
+			}
+			if _, loose := looseObjectPath(hash); loose {
+				continue
+			}
+			typeName, _, content, err := readObjectFromHash(hash)
+			if err != nil {
+				return loosened, fmt.Errorf("failed to read object %s: %w", hash, err)
+			}
+			objType, err := ObjectTypeFromString(typeName)
+			if err != nil {
+				return loosened, err
+			}
+			if _, err := writeObjectWithType(content, objType); err != nil {
+				return loosened, fmt.Errorf("failed to write object %s: %w", hash, err)
+			}
+			if err := os.Chtimes(objectDirPath(hash[:2], hash[2:]), packTime, packTime); err != nil {
+				return loosened, fmt.Errorf("failed to write object %s: %w", hash, err)
+			}
+			loosened++
+		}
+	}
+	return loosened, nil
+}
+
+// Delete packs with their .idx, .bitmap and .rev files - and the multi-pack-index, which names them
+func removePacks(packs []localPack, w io.Writer) error {
+	if len(packs) == 0 {
+		return nil
+	}
+	for _, pack := range packs {
+		base := strings.TrimSuffix(pack.Path, ".pack")
+		for _, extension := range []string{".bitmap", ".rev", ".pack", ".idx"} {
+			if err := os.Remove(base + extension); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", filepath.Base(base+extension), err)
+			}
+		}
+	}
+	if err := os.Remove(objectDirPath("pack", multiPackIndexName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", multiPackIndexName, err)
+	}
+	resetPackIndexCache()
+	fmt.Fprintf(w, "Removed %d redundant packs\n", len(packs))
+	return nil
+}
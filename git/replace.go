@@ -0,0 +1,206 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Replace refs - refs/replace/<hash> points to an object that is read instead of <hash>, so history can be
+// changed (e.g. a commit given other parents) without rewriting the commits that refer to it. Log,
+// checkout, cat-file and every other reader see the replacement under the original name; a replacement may
+// itself be replaced, up to replaceMaxDepth times.
+//
+// Objects are read as they are stored when the repository context says so (--no-replace-objects), when
+// GIT_NO_REPLACE_OBJECTS is set, when core.useReplaceRefs is false, and by commands that must see the real objects - fsck, prune, repack, gc,
+// commit-graph and everything that packs or receives objects (push, upload-pack, receive-pack, bundle) -
+// while they run. Delta bases are always read as they are stored (readStoredObject).
+// GIT_REPLACE_REF_BASE moves the refs away from refs/replace/.
+
+const (
+	replaceRefBase  = "refs/replace/"
+	replaceMaxDepth = 5
+)
+
+// Git directories whose objects are read as they are stored, ignoring replace refs - set while a command
+// working on stored objects runs
+var replaceRefsIgnored = make(map[string]bool)
+
+// Replacements by git directory (original hash -> replacement) - replace refs are read only once per process
+var replaceRefsCache = make(map[string]map[string]string)
+
+// Read objects of the current repository as they are stored until the returned function is called
+func ignoreReplaceRefs() func() {
+	gitDir := resolveRepoLayout().GitDir
+	previous := replaceRefsIgnored[gitDir]
+	replaceRefsIgnored[gitDir] = true
+	return func() {
+		replaceRefsIgnored[gitDir] = previous
+	}
+}
+
+// Prefix of replace refs - GIT_REPLACE_REF_BASE or refs/replace/
+func replaceRefPrefix() string {
+	base := os.Getenv("GIT_REPLACE_REF_BASE")
+	if base == "" {
+		return replaceRefBase
+	}
+	return strings.TrimSuffix(base, "/") + "/"
+}
+
+// Replacements of objects (original hash -> replacement) - none when replace refs are not followed
+func loadReplaceRefs() (map[string]string, error) {
+	gitDir := resolveRepoLayout().GitDir
+	if replacements, ok := replaceRefsCache[gitDir]; ok {
+		return replacements, nil
+	}
+
+	replacements := make(map[string]string)
+	_, disabled := os.LookupEnv("GIT_NO_REPLACE_OBJECTS")
+	if config, err := loadConfig(); err == nil && !config.GetBool("core.useReplaceRefs", true) {
+		disabled = true
+	}
+	if !disabled {
+		prefix := replaceRefPrefix()
+		refs, err := listRefs(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for refName, hash := range refs {
+			original := strings.TrimPrefix(refName, prefix)
+			if len(original) == 40 && strings.Trim(original, "0123456789abcdef") == "" {
+				replacements[original] = hash
+			}
+		}
+	}
+	replaceRefsCache[gitDir] = replacements
+	return replacements, nil
+}
+
+// Object to read in place of hash - hash itself when it isn't replaced (or replace refs are not followed)
+func lookupReplaceObject(hash string) (string, error) {
+	if replaceRefsIgnored[resolveRepoLayout().GitDir] || currentContext().NoReplaceObjects {
+		return hash, nil
+	}
+	replacements, err := loadReplaceRefs()
+	if err != nil || len(replacements) == 0 {
+		return hash, err
+	}
+	for depth := 0; depth <= replaceMaxDepth; depth++ {
+		replacement, ok := replacements[hash]
+		if !ok {
+			return hash, nil
+		}
+		hash = replacement
+	}
+	return "", fmt.Errorf("replace depth too high for object %s", hash)
+}
+
+// Replace object with another one - both must be of the same type, and the object must not be replaced
+// already, unless Force is given
+func createReplaceRef(options ReplaceOptions) error {
+	defer ignoreReplaceRefs()()
+	_, object, err := resolveRevision(options.Object)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s' as a valid ref: %w", options.Object, err)
+	}
+	_, replacement, err := resolveRevision(options.Replacement)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s' as a valid ref: %w", options.Replacement, err)
+	}
+
+	refName := replaceRefPrefix() + object
+	if existing, err := resolveRef(refName); err != nil {
+		return err
+	} else if existing != "" && !options.Force {
+		return fmt.Errorf("replace ref '%s' already exists", refName)
+	}
+	if object == replacement {
+		return fmt.Errorf("new object is the same as the old one: '%s'", object)
+	}
+	if !options.Force {
+		objectType, _, _, err := readObjectFromHash(object)
+		if err != nil {
+			return err
+		}
+		replacementType, _, _, err := readObjectFromHash(replacement)
+		if err != nil {
+			return err
+		}
+		if objectType != replacementType {
+			return fmt.Errorf("objects must be of the same type - '%s' is a %s, while '%s' is a %s",
+				options.Object, objectType, options.Replacement, replacementType)
+		}
+	}
+	delete(replaceRefsCache, resolveRepoLayout().GitDir)
+	return updateRef(refName, replacement, "replace")
+}
+
+// Delete replace refs of objects - objects without one are reported after the others are deleted
+func deleteReplaceRefs(objects []string, w io.Writer) error {
+	defer ignoreReplaceRefs()()
+	var missing []string
+	for _, name := range objects {
+		_, object, err := resolveRevision(name)
+		if err != nil {
+			return fmt.Errorf("failed to resolve '%s' as a valid ref: %w", name, err)
+		}
+		refName := replaceRefPrefix() + object
+		hash, err := resolveRef(refName)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			missing = append(missing, object)
+			continue
+		}
+		if err := deleteRef(refName); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Deleted replace ref '%s'\n", object)
+	}
+	delete(replaceRefsCache, resolveRepoLayout().GitDir)
+	if len(missing) > 0 {
+		return fmt.Errorf("replace ref '%s' not found", strings.Join(missing, "', '"))
+	}
+	return nil
+}
+
+// List replaced objects matching pattern (all when empty) - short format gives their hashes, medium also
+// the replacements ("<hash> -> <replacement>"), long also the types ("<hash> (<type>) -> ...")
+func listReplaceRefs(pattern, format string, w io.Writer) error {
+	defer ignoreReplaceRefs()()
+	if format != "" && format != "short" && format != "medium" && format != "long" {
+		return fmt.Errorf("invalid replace format '%s' - valid formats are 'short', 'medium' and 'long'", format)
+	}
+	prefix := replaceRefPrefix()
+	refs, err := listRefs(prefix)
+	if err != nil {
+		return err
+	}
+	for _, refName := range sortedKeys(refs) {
+		object := strings.TrimPrefix(refName, prefix)
+		if pattern != "" && !matchRefPattern(pattern, object) {
+			continue
+		}
+		replacement := refs[refName]
+		switch format {
+		case "medium":
+			fmt.Fprintf(w, "%s -> %s\n", object, replacement)
+		case "long":
+			objectType, _, _, err := readObjectFromHash(object)
+			if err != nil {
+				objectType = "unknown"
+			}
+			replacementType, _, _, err := readObjectFromHash(replacement)
+			if err != nil {
+				replacementType = "unknown"
+			}
+			fmt.Fprintf(w, "%s (%s) -> %s (%s)\n", object, objectType, replacement, replacementType)
+		default:
+			fmt.Fprintln(w, object)
+		}
+	}
+	return nil
+}
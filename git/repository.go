@@ -0,0 +1,498 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Repository layout resolver - every path that points into .git (or the work tree) should be built here,
+// so GIT_DIR, GIT_WORK_TREE, GIT_OBJECT_DIRECTORY and GIT_INDEX_FILE are honored by all commands
+//
+// Repository is bare when the directory it is found in is a git directory itself (HEAD, objects and refs, no
+// .git), or when GIT_DIR points to a repository with core.bare = true and no GIT_WORK_TREE is given.
+//
+// Layout of a Repository is resolved once, when it is opened (with absolute paths), and is the active one
+// while one of its methods runs - the process never changes its directory. Methods of different
+// repositories run one at a time. Global options (-C, --git-dir, --work-tree, --no-replace-objects) live in
+// the RepoContext the repository is opened in, never in the process environment. Code that runs without a
+// Repository (servers, ls-remote outside of a repository) resolves the layout from the environment and the
+// directory of its context.
+
+// Layout of the repository whose method is running - nil outside of methods
+var activeLayout *RepoLayout
+
+// Context of the running repository method (or of a command that runs without a repository) - nil when
+// nothing runs
+var activeContext *RepoContext
+
+// Held while a repository (or context) is active
+var repositoryLock sync.Mutex
+
+// Context of Open, Init and Clone called on the package
+var defaultRepoContext = &RepoContext{}
+
+// Make layout and context of repository the active ones - returns function that restores the previous ones
+func (r *Repository) enter() func() {
+	repositoryLock.Lock()
+	previousLayout, previousContext := activeLayout, activeContext
+	activeLayout, activeContext = &r.layout, r.context
+	return func() {
+		activeLayout, activeContext = previousLayout, previousContext
+		repositoryLock.Unlock()
+	}
+}
+
+// Make context active for a command without repository (no active layout) - returns function that
+// restores the previous state
+func (c *RepoContext) enter() func() {
+	repositoryLock.Lock()
+	previousLayout, previousContext := activeLayout, activeContext
+	activeLayout, activeContext = nil, c
+	return func() {
+		activeLayout, activeContext = previousLayout, previousContext
+		repositoryLock.Unlock()
+	}
+}
+
+// Context of the running command - the default one when nothing is active
+func currentContext() *RepoContext {
+	if activeContext != nil {
+		return activeContext
+	}
+	return defaultRepoContext
+}
+
+// Resolve global options into a context - -C directories are applied in order (each must exist), git
+// directory and work tree are made absolute. Nothing changes in the process.
+func newRepoContext(options GlobalOptions) (*RepoContext, error) {
+	c := &RepoContext{NoReplaceObjects: options.NoReplaceObjects, Program: options.Program}
+	for _, dir := range options.Directories {
+		path := c.path(dir)
+		if info, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("cannot change to '%s': %w", dir, err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("cannot change to '%s': not a directory", dir)
+		}
+		c.Dir = path
+	}
+	if options.GitDir != "" {
+		c.GitDir = c.path(options.GitDir)
+	}
+	if options.WorkTree != "" {
+		c.WorkTree = c.path(options.WorkTree)
+	}
+	return c, nil
+}
+
+// Absolute form of path relative to the context directory
+func (c *RepoContext) path(path string) string {
+	if c.Dir == "" || filepath.IsAbs(path) {
+		return absolutePath(path)
+	}
+	return filepath.Join(c.Dir, path)
+}
+
+// Git directory of the context (absolute) - --git-dir, then GIT_DIR; empty when the repository is searched for
+func (c *RepoContext) gitDir() string {
+	if c.GitDir != "" {
+		return c.path(c.GitDir)
+	}
+	if gitDir := os.Getenv("GIT_DIR"); gitDir != "" {
+		return c.path(gitDir)
+	}
+	return ""
+}
+
+// Work tree of the context (absolute) - --work-tree, then GIT_WORK_TREE; empty when not given
+func (c *RepoContext) workTree() string {
+	if c.WorkTree != "" {
+		return c.path(c.WorkTree)
+	}
+	if workTree := os.Getenv("GIT_WORK_TREE"); workTree != "" {
+		return c.path(workTree)
+	}
+	return ""
+}
+
+// Environment for child processes of the running command - the process environment, plus
+// GIT_NO_REPLACE_OBJECTS when the context ignores replace refs
+func contextEnvironment(env []string) []string {
+	if currentContext().NoReplaceObjects {
+		env = append(env, "GIT_NO_REPLACE_OBJECTS=1")
+	}
+	return env
+}
+
+// Resolve repository layout - layout of the active repository, otherwise from the environment, falling back
+// to the default .git layout in CWD
+func resolveRepoLayout() RepoLayout {
+	if activeLayout != nil {
+		return *activeLayout
+	}
+	gitDir := os.Getenv("GIT_DIR")
+	bare := false
+	if gitDir == "" {
+		gitDir = ".git"
+		if _, err := os.Stat(".git"); err != nil && isGitDirectory(".") {
+			gitDir, bare = ".", true
+		}
+	} else {
+		bare = os.Getenv("GIT_WORK_TREE") == "" && isBareConfig(gitDir)
+	}
+
+	workTree := os.Getenv("GIT_WORK_TREE")
+	if workTree == "" {
+		workTree = "."
+	}
+
+	objectDir := os.Getenv("GIT_OBJECT_DIRECTORY")
+	if objectDir == "" {
+		objectDir = filepath.Join(gitDir, "objects")
+	}
+
+	indexFile := os.Getenv("GIT_INDEX_FILE")
+	if indexFile == "" {
+		indexFile = filepath.Join(gitDir, "index")
+	}
+
+	return RepoLayout{
+		GitDir:    gitDir,
+		WorkTree:  workTree,
+		ObjectDir: objectDir,
+		IndexFile: indexFile,
+		Bare:      bare,
+	}
+}
+
+// Layout of repository with git directory and work tree (absolute paths) - GIT_OBJECT_DIRECTORY and
+// GIT_INDEX_FILE still override the object directory and index
+func (c *RepoContext) newRepoLayout(gitDir, workTree string, bare bool) RepoLayout {
+	layout := RepoLayout{
+		GitDir:    gitDir,
+		WorkTree:  workTree,
+		ObjectDir: filepath.Join(gitDir, "objects"),
+		IndexFile: filepath.Join(gitDir, "index"),
+		Bare:      bare,
+	}
+	if objectDir := os.Getenv("GIT_OBJECT_DIRECTORY"); objectDir != "" {
+		layout.ObjectDir = c.path(objectDir)
+	}
+	if indexFile := os.Getenv("GIT_INDEX_FILE"); indexFile != "" {
+		layout.IndexFile = c.path(indexFile)
+	}
+	return layout
+}
+
+// Layout of new repository in dir (absolute) - .git inside it, or dir itself for bare repository. Git
+// directory and work tree of the context (--git-dir, GIT_DIR and --work-tree, GIT_WORK_TREE) choose other
+// directories.
+func (c *RepoContext) initRepoLayout(dir string, bare bool) RepoLayout {
+	if gitDir := c.gitDir(); gitDir != "" {
+		workTree := dir
+		if value := c.workTree(); value != "" {
+			workTree = value
+		}
+		return c.newRepoLayout(gitDir, workTree, bare)
+	}
+	if bare {
+		return c.newRepoLayout(dir, dir, true)
+	}
+	return c.newRepoLayout(filepath.Join(dir, ".git"), dir, false)
+}
+
+// Directory looks like a git directory - HEAD file, objects and refs directories
+func isGitDirectory(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// core.bare of repository, by git directory - read once per process (missing config is not remembered,
+// init writes it later)
+var bareConfigCache = make(map[string]bool)
+
+func isBareConfig(gitDir string) bool {
+	if bare, ok := bareConfigCache[gitDir]; ok {
+		return bare
+	}
+	configPath := filepath.Join(gitDir, "config")
+	if _, err := os.Stat(configPath); err != nil {
+		return false
+	}
+	config := &Config{values: make(map[string][]string)}
+	if err := config.loadFile(configPath); err != nil {
+		return false
+	}
+	bareConfigCache[gitDir] = config.GetBool("core.bare", false)
+	return bareConfigCache[gitDir]
+}
+
+// Error for commands that need a work tree when run in a bare repository
+func requireWorkTree() error {
+	if resolveRepoLayout().Bare {
+		return fmt.Errorf("this operation must be run in a work tree")
+	}
+	return nil
+}
+
+// Path inside the git directory (e.g. gitDirPath("HEAD") -> .git/HEAD)
+func gitDirPath(parts ...string) string {
+	layout := resolveRepoLayout()
+	return filepath.Join(append([]string{layout.GitDir}, parts...)...)
+}
+
+// Path inside the object directory (e.g. objectDirPath("ab", "cdef...") -> .git/objects/ab/cdef...)
+func objectDirPath(parts ...string) string {
+	layout := resolveRepoLayout()
+	return filepath.Join(append([]string{layout.ObjectDir}, parts...)...)
+}
+
+// Path of the index file (staging area)
+func indexFilePath() string {
+	return resolveRepoLayout().IndexFile
+}
+
+// Path inside the work tree (where checked out files live)
+func workTreePath(parts ...string) string {
+	layout := resolveRepoLayout()
+	return filepath.Join(append([]string{layout.WorkTree}, parts...)...)
+}
+
+// Alternates are followed at most this deep (alternate of alternate of ...)
+const maxAlternateDepth = 5
+
+// Object directories by own object directory - alternates are read only once per process
+var objectDirectoriesCache = make(map[string][]string)
+
+// Object directories to search - own object directory first, then GIT_ALTERNATE_OBJECT_DIRECTORIES and
+// directories listed in objects/info/alternates (recursively, relative paths are relative to the
+// objects directory that lists them)
+func objectDirectories() []string {
+	objectDir := resolveRepoLayout().ObjectDir
+	if dirs, ok := objectDirectoriesCache[objectDir]; ok {
+		return dirs
+	}
+
+	dirs := []string{objectDir}
+	seen := map[string]bool{absolutePath(objectDir): true}
+	var addAlternates func(dir string, depth int)
+	addAlternate := func(dir string, depth int) {
+		if depth > maxAlternateDepth || seen[absolutePath(dir)] {
+			return
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return
+		}
+		seen[absolutePath(dir)] = true
+		dirs = append(dirs, dir)
+		addAlternates(dir, depth+1)
+	}
+	addAlternates = func(dir string, depth int) {
+		data, err := os.ReadFile(filepath.Join(dir, "info", "alternates"))
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if !filepath.IsAbs(line) {
+				line = filepath.Join(dir, line)
+			}
+			addAlternate(filepath.Clean(line), depth)
+		}
+	}
+
+	if env := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); env != "" {
+		for _, dir := range filepath.SplitList(env) {
+			addAlternate(dir, 1)
+		}
+	}
+	addAlternates(objectDir, 1)
+
+	objectDirectoriesCache[objectDir] = dirs
+	return dirs
+}
+
+// Path of loose object in the first object directory (own or alternate) that has it
+func looseObjectPath(objectHash string) (string, bool) {
+	for _, dir := range objectDirectories() {
+		objectPath := filepath.Join(dir, objectHash[:2], objectHash[2:])
+		if _, err := os.Stat(objectPath); err == nil {
+			return objectPath, true
+		}
+	}
+	return objectDirPath(objectHash[:2], objectHash[2:]), false
+}
+
+// Add object directory to objects/info/alternates (stored as absolute path)
+func addAlternateObjectDir(dir string) error {
+	absolute, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(objectDirPath("info"), 0755); err != nil {
+		return err
+	}
+
+	alternatesPath := objectDirPath("info", "alternates")
+	file, err := os.OpenFile(alternatesPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write alternates: %w", err)
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, absolute); err != nil {
+		return fmt.Errorf("failed to write alternates: %w", err)
+	}
+
+	delete(objectDirectoriesCache, resolveRepoLayout().ObjectDir)
+	resetPackIndexCache()
+	return nil
+}
+
+// Absolute form of path - path itself if it can't be resolved
+func absolutePath(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return path
+}
+
+// Find repository containing directory start (absolute) - directories are searched upwards for .git
+// (directory, or "gitdir: <path>" file) or a bare git directory. Search stops at the filesystem boundary
+// (unless GIT_DISCOVERY_ACROSS_FILESYSTEM is set) and doesn't enter GIT_CEILING_DIRECTORIES. Git directory
+// of the context (--git-dir or GIT_DIR) is used instead; the work tree is then that of the context, or start.
+//
+// Returns layout of the repository and prefix - start relative to the work tree root ("" at the root, in a
+// bare repository, or when start is outside of the work tree).
+func (c *RepoContext) discoverRepository(start string) (RepoLayout, string, error) {
+	if gitDir := c.gitDir(); gitDir != "" {
+		return c.explicitRepoLayout(gitDir, start)
+	}
+
+	ceilings := make(map[string]bool)
+	for _, ceiling := range filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")) {
+		if filepath.IsAbs(ceiling) {
+			ceilings[filepath.Clean(ceiling)] = true
+		}
+	}
+	acrossFilesystems := false
+	if value := os.Getenv("GIT_DISCOVERY_ACROSS_FILESYSTEM"); value != "" {
+		acrossFilesystems = parseBoolValue(value, false)
+	}
+
+	for dir := start; ; {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			gitDir := dotGit
+			if !info.IsDir() {
+				if gitDir, err = readGitFile(dotGit); err != nil {
+					return RepoLayout{}, "", err
+				}
+			}
+			return c.newRepoLayout(gitDir, dir, false), workTreePrefix(dir, start), nil
+		}
+		if isGitDirectory(dir) {
+			// Bare repository (or inside .git) - there is no work tree, so no prefix either
+			return c.newRepoLayout(dir, dir, true), "", nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || ceilings[parent] || (!acrossFilesystems && !sameFilesystem(dir, parent)) {
+			return RepoLayout{}, "", ErrNotARepository
+		}
+		dir = parent
+	}
+}
+
+// Git directory is given - start is the work tree, unless the context names one; repository is bare when
+// there is no work tree in the context and its config says so
+func (c *RepoContext) explicitRepoLayout(gitDir, start string) (RepoLayout, string, error) {
+	workTree := c.workTree()
+	if workTree == "" {
+		return c.newRepoLayout(gitDir, start, isBareConfig(gitDir)), "", nil
+	}
+	return c.newRepoLayout(gitDir, workTree, false), workTreePrefix(workTree, start), nil
+}
+
+// Starting directory relative to work tree root - "" at the root, or when start is outside of the work tree
+// (paths are then relative to the root)
+func workTreePrefix(root, start string) string {
+	prefix, err := filepath.Rel(root, start)
+	if err != nil || prefix == "." || prefix == ".." || strings.HasPrefix(prefix, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.ToSlash(prefix)
+}
+
+// Directory commands run in - work tree root, or the git directory of a bare repository. Relative paths
+// from config (hooksPath, remote URLs) are relative to it.
+func commandDir() string {
+	layout := resolveRepoLayout()
+	if layout.Bare {
+		return layout.GitDir
+	}
+	return layout.WorkTree
+}
+
+// Absolute form of path relative to the directory commands run in (the context directory when no
+// repository is active)
+func commandDirPath(path string) string {
+	if filepath.IsAbs(path) || activeLayout == nil {
+		return currentContext().path(path)
+	}
+	return filepath.Join(commandDir(), path)
+}
+
+// Read "gitdir: <path>" file (work trees and submodules) - relative path is relative to the file
+func readGitFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return "", fmt.Errorf("invalid gitfile format: %s", path)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	if !isGitDirectory(gitDir) {
+		return "", fmt.Errorf("%w: %s", ErrNotARepository, gitDir)
+	}
+	return absolutePath(gitDir), nil
+}
+
+// Path given on command line, relative to the work tree root - absolute paths and "-" (stdin) are kept
+func prefixPath(prefix, path string) string {
+	if prefix == "" || path == "-" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.FromSlash(prefix), path)
+}
+
+func prefixPaths(prefix string, paths []string) []string {
+	prefixed := make([]string, len(paths))
+	for i, path := range paths {
+		prefixed[i] = prefixPath(prefix, path)
+	}
+	return prefixed
+}
+
+// Paths relative to work tree root in index form (slashes, "" for the root itself)
+func indexPaths(paths []string) []string {
+	cleaned := make([]string, len(paths))
+	for i, path := range paths {
+		if cleaned[i] = filepath.ToSlash(filepath.Clean(path)); cleaned[i] == "." {
+			cleaned[i] = ""
+		}
+	}
+	return cleaned
+}
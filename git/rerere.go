@@ -0,0 +1,371 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rerere - reuse recorded resolution of conflicts, like git rerere. Enabled with rerere.enabled (when it
+// isn't set, by .git/rr-cache existing, like in git).
+//
+// When a merge leaves a file with conflicts, the conflict is normalized - labels and the base part of every
+// hunk are dropped and the two sides are put in sorted order, so the same conflict looks the same whichever
+// side it came from:
+//
+//	<<<<<<<
+//	one side
+//	=======
+//	other side
+//	>>>>>>>
+//
+// The conflict ID is SHA-1 of the sides (each followed by NUL), and the normalized file is kept as
+// .git/rr-cache/<id>/preimage. .git/MERGE_RR lists conflicts in progress ("<id>\t<path>\0"). When a
+// conflicted file is committed without markers, it is recorded as the conflict's postimage. When the same
+// conflict shows up again, preimage -> postimage is merged into the file (three-way, like any merge) and the
+// file is resolved (and staged with rerere.autoUpdate).
+//
+// rerere commands: (none) records resolutions of resolved files, status lists conflicts rerere tracks,
+// forget <path> drops the resolution of a file's conflict, clear drops the state of the merge in progress,
+// gc removes old entries (gc.rerereResolved days, 60 by default, and gc.rerereUnresolved, 15).
+
+// Length of conflict markers
+const rerereMarkerSize = 7
+
+// Check whether rerere is on - rerere.enabled, or existing rr-cache when it isn't set
+func rerereEnabled() (bool, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := config.Get("rerere.enabled"); ok {
+		return config.GetBool("rerere.enabled", false), nil
+	}
+	info, err := os.Stat(gitDirPath("rr-cache"))
+	return err == nil && info.IsDir(), nil
+}
+
+// Run rerere command - action is "" (record resolutions), status, forget, clear or gc
+func runRerere(action string, paths []string, w io.Writer) error {
+	switch action {
+	case "":
+		return rerereRecordResolutions(w)
+	case "status":
+		mergeRR, err := readMergeRR()
+		if err != nil {
+			return err
+		}
+		for _, filePath := range sortedKeys(mergeRR) {
+			fmt.Fprintln(w, filePath)
+		}
+		return nil
+	case "forget":
+		return rerereForget(paths, w)
+	case "clear":
+		return rerereClear()
+	case "gc":
+		return rerereGc()
+	}
+	return fmt.Errorf("unknown rerere command: %s", action)
+}
+
+// Remember conflicts of paths merge left in the work tree - conflicts resolved before are resolved again the
+// same way
+func rerereConflicts(paths []string, w io.Writer) error {
+	if enabled, err := rerereEnabled(); err != nil || !enabled {
+		return err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	autoUpdate := config.GetBool("rerere.autoupdate", false)
+	mergeRR, err := readMergeRR()
+	if err != nil {
+		return err
+	}
+
+	var staged []string
+	for _, filePath := range paths {
+		content, err := os.ReadFile(workTreePath(filepath.FromSlash(filePath)))
+		if err != nil {
+			continue
+		}
+		normalized, id, hunks := normalizeConflicts(content)
+		if hunks == 0 {
+			continue
+		}
+
+		postimage, err := os.ReadFile(gitDirPath("rr-cache", id, "postimage"))
+		if err == nil {
+			preimage, err := os.ReadFile(gitDirPath("rr-cache", id, "preimage"))
+			if err != nil {
+				return fmt.Errorf("failed to read preimage of %s: %w", filePath, err)
+			}
+			merged, conflicts := mergeText(preimage, normalized, postimage, MergeSettings{ConflictStyle: "merge"})
+			if conflicts == 0 {
+				if err := os.WriteFile(workTreePath(filepath.FromSlash(filePath)), merged, 0644); err != nil {
+					return fmt.Errorf("failed to write %s: %w", filePath, err)
+				}
+				if autoUpdate {
+					staged = append(staged, filePath)
+					fmt.Fprintf(w, "Staged '%s' using previous resolution.\n", filePath)
+				} else {
+					fmt.Fprintf(w, "Resolved '%s' using previous resolution.\n", filePath)
+				}
+				delete(mergeRR, filePath)
+				continue
+			}
+		}
+
+		// Conflict rerere doesn't know (or can't replay) - its resolution is recorded when it is committed
+		mergeRR[filePath] = id
+		if err := writeRerereImage(id, "preimage", normalized); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Recorded preimage for '%s'\n", filePath)
+	}
+
+	if len(staged) > 0 {
+		if err := addPaths(staged, false); err != nil {
+			return err
+		}
+	}
+	return writeMergeRR(mergeRR)
+}
+
+// Record postimages of conflicts in MERGE_RR whose files don't have conflict markers any more
+func rerereRecordResolutions(w io.Writer) error {
+	if enabled, err := rerereEnabled(); err != nil || !enabled {
+		return err
+	}
+	mergeRR, err := readMergeRR()
+	if err != nil || len(mergeRR) == 0 {
+		return err
+	}
+
+	for _, filePath := range sortedKeys(mergeRR) {
+		id := mergeRR[filePath]
+		content, err := os.ReadFile(workTreePath(filepath.FromSlash(filePath)))
+		if os.IsNotExist(err) {
+			// Conflict resolved by removing the file - nothing to replay
+			delete(mergeRR, filePath)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, _, hunks := normalizeConflicts(content); hunks > 0 {
+			continue
+		}
+
+		if err := writeRerereImage(id, "postimage", content); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Recorded resolution for '%s'.\n", filePath)
+		delete(mergeRR, filePath)
+	}
+	return writeMergeRR(mergeRR)
+}
+
+// Drop recorded resolutions of conflicts in paths - conflicts still in the work tree get a new preimage
+func rerereForget(paths []string, w io.Writer) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("forget requires a path")
+	}
+	mergeRR, err := readMergeRR()
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range paths {
+		content, err := os.ReadFile(workTreePath(filepath.FromSlash(filePath)))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		normalized, id, hunks := normalizeConflicts(content)
+		if hunks == 0 {
+			if id = mergeRR[filePath]; id == "" {
+				return fmt.Errorf("no remembered resolution for '%s'", filePath)
+			}
+		}
+
+		if err := os.Remove(gitDirPath("rr-cache", id, "postimage")); err == nil {
+			fmt.Fprintf(w, "Forgot resolution for '%s'\n", filePath)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if hunks > 0 {
+			if err := writeRerereImage(id, "preimage", normalized); err != nil {
+				return err
+			}
+			mergeRR[filePath] = id
+			fmt.Fprintf(w, "Updated preimage for '%s'\n", filePath)
+		}
+	}
+	return writeMergeRR(mergeRR)
+}
+
+// Forget conflicts of the merge in progress - their preimages (unless resolved before) and MERGE_RR
+func rerereClear() error {
+	mergeRR, err := readMergeRR()
+	if err != nil {
+		return err
+	}
+	for _, id := range mergeRR {
+		if _, err := os.Stat(gitDirPath("rr-cache", id, "postimage")); os.IsNotExist(err) {
+			os.RemoveAll(gitDirPath("rr-cache", id))
+		}
+	}
+	return writeMergeRR(nil)
+}
+
+// Remove rr-cache entries not used for long - resolved ones after gc.rerereResolved days, unresolved ones
+// after gc.rerereUnresolved days
+func rerereGc() error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	resolvedDays := config.GetInt("gc.rerereresolved", 60)
+	unresolvedDays := config.GetInt("gc.rerereunresolved", 15)
+
+	dirs, err := os.ReadDir(gitDirPath("rr-cache"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	mergeRR, err := readMergeRR()
+	if err != nil {
+		return err
+	}
+	inProgress := make(map[string]bool)
+	for _, id := range mergeRR {
+		inProgress[id] = true
+	}
+
+	now := time.Now()
+	for _, dir := range dirs {
+		if !dir.IsDir() || inProgress[dir.Name()] {
+			continue
+		}
+		image, days := "postimage", resolvedDays
+		info, err := os.Stat(gitDirPath("rr-cache", dir.Name(), image))
+		if os.IsNotExist(err) {
+			image, days = "preimage", unresolvedDays
+			info, err = os.Stat(gitDirPath("rr-cache", dir.Name(), image))
+		}
+		if err == nil && now.Sub(info.ModTime()) <= time.Duration(days)*24*time.Hour {
+			continue
+		}
+		if err := os.RemoveAll(gitDirPath("rr-cache", dir.Name())); err != nil {
+			return fmt.Errorf("failed to remove rr-cache entry %s: %w", dir.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Normalize conflict hunks of content - returns normalized content, conflict ID and number of hunks (0 when
+// there are none, or the markers don't make complete hunks)
+func normalizeConflicts(content []byte) ([]byte, string, int) {
+	var normalized, one, two bytes.Buffer
+	hasher := sha1.New()
+	hunks := 0
+	// 0 - outside of conflict, 1 - first side, 2 - base (dropped), 3 - second side
+	state := 0
+
+	for _, line := range splitLines(content) {
+		switch {
+		case state == 0 && isConflictMarker(line, '<'):
+			state = 1
+			one.Reset()
+			two.Reset()
+		case state == 1 && isConflictMarker(line, '|'):
+			state = 2
+		case (state == 1 || state == 2) && isConflictMarker(line, '='):
+			state = 3
+		case state == 3 && isConflictMarker(line, '>'):
+			a, b := one.Bytes(), two.Bytes()
+			if bytes.Compare(a, b) > 0 {
+				a, b = b, a
+			}
+			fmt.Fprintf(&normalized, "%s\n%s%s\n%s%s\n", strings.Repeat("<", rerereMarkerSize), a,
+				strings.Repeat("=", rerereMarkerSize), b, strings.Repeat(">", rerereMarkerSize))
+			hasher.Write(a)
+			hasher.Write([]byte{0})
+			hasher.Write(b)
+			hasher.Write([]byte{0})
+			hunks++
+			state = 0
+		case state == 0:
+			normalized.WriteString(line)
+		case state == 1:
+			one.WriteString(line)
+		case state == 3:
+			two.WriteString(line)
+		}
+	}
+	if state != 0 || hunks == 0 {
+		return content, "", 0
+	}
+	return normalized.Bytes(), fmt.Sprintf("%x", hasher.Sum(nil)), hunks
+}
+
+// Line is conflict marker of kind c - the marker is followed by a space (and a label) or the line end
+func isConflictMarker(line string, c byte) bool {
+	if len(line) < rerereMarkerSize || line[:rerereMarkerSize] != strings.Repeat(string(c), rerereMarkerSize) {
+		return false
+	}
+	rest := line[rerereMarkerSize:]
+	return rest == "" || rest[0] == ' ' || rest[0] == '\n' || rest == "\r\n"
+}
+
+func writeRerereImage(id, image string, content []byte) error {
+	if err := os.MkdirAll(gitDirPath("rr-cache", id), 0755); err != nil {
+		return fmt.Errorf("failed to create rr-cache entry: %w", err)
+	}
+	if err := os.WriteFile(gitDirPath("rr-cache", id, image), content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", image, err)
+	}
+	return nil
+}
+
+// Conflicts rerere tracks - path -> conflict ID
+func readMergeRR() (map[string]string, error) {
+	mergeRR := make(map[string]string)
+	data, err := os.ReadFile(gitDirPath("MERGE_RR"))
+	if os.IsNotExist(err) {
+		return mergeRR, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read MERGE_RR: %w", err)
+	}
+	for _, record := range strings.Split(string(data), "\x00") {
+		if id, filePath, ok := strings.Cut(record, "\t"); ok {
+			mergeRR[filePath] = id
+		}
+	}
+	return mergeRR, nil
+}
+
+// Write MERGE_RR - removed when there are no conflicts left
+func writeMergeRR(mergeRR map[string]string) error {
+	if len(mergeRR) == 0 {
+		if err := os.Remove(gitDirPath("MERGE_RR")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, filePath := range sortedKeys(mergeRR) {
+		fmt.Fprintf(&buf, "%s\t%s\x00", mergeRR[filePath], filePath)
+	}
+	if err := os.WriteFile(gitDirPath("MERGE_RR"), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_RR: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package git
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Commit walker - commits reachable from include but not from exclude (like git rev-list A ^B / B..A)
+//
+// Commits are returned newest first: a commit is only returned once all its children (from the walked
+// set) were, and among the ready ones the one with the latest committer date goes first.
+//
+// `rev-list` prints the walked commits (--count - only how many). --objects adds annotated tags that were
+// named, then trees and blobs of the commits that are not reachable from excluded commits, each with its path - or, when a reachability
+// bitmap can answer, every object without paths in pack order and without walking bitmapped history.
+
+// Walk commits reachable from include hashes and not from exclude hashes
+func revList(include, exclude []string) ([]string, error) {
+	return walkCommits(include, exclude, false)
+}
+
+// Walk commits like revList - with firstParent, only first parents of merges are followed from include
+// (commits reachable from exclude are still left out through all parents)
+func walkCommits(include, exclude []string, firstParent bool) ([]string, error) {
+	excluded := make(map[string]bool)
+	queue := append([]string(nil), exclude...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if excluded[hash] {
+			continue
+		}
+		excluded[hash] = true
+		parents, err := readCommitParents(hash)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, parents...)
+	}
+
+	// Collect commits with their parents and dates, counting children inside the walked set
+	commits := make(map[string]*Commit)
+	children := make(map[string]int)
+	queue = append(queue, include...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if excluded[hash] || commits[hash] != nil {
+			continue
+		}
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if firstParent && len(commit.Parents) > 1 {
+			commit.Parents = commit.Parents[:1]
+		}
+		commits[hash] = commit
+		for _, parent := range commit.Parents {
+			if !excluded[parent] {
+				children[parent]++
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	ready := &commitDateHeap{}
+	for hash, commit := range commits {
+		if children[hash] == 0 {
+			heap.Push(ready, datedCommit{hash, commitTime(commit)})
+		}
+	}
+
+	ordered := make([]string, 0, len(commits))
+	for ready.Len() > 0 {
+		hash := heap.Pop(ready).(datedCommit).hash
+		ordered = append(ordered, hash)
+		for _, parent := range commits[hash].Parents {
+			if _, ok := commits[parent]; !ok {
+				continue
+			}
+			children[parent]--
+			if children[parent] == 0 {
+				heap.Push(ready, datedCommit{parent, commitTime(commits[parent])})
+			}
+		}
+	}
+
+	if len(ordered) != len(commits) {
+		return nil, fmt.Errorf("commit graph has a cycle")
+	}
+	return ordered, nil
+}
+
+// Reorder commits of revList so that lines of history are not mixed (--topo-order) - a commit still comes
+// after all its children, but once it is shown, its parents come before other commits that are ready (the
+// last parent first); parents are the parents of every commit
+func topoOrder(commits []string, parents map[string][]string) []string {
+	children := make(map[string]int, len(commits))
+	for _, hash := range commits {
+		children[hash] += 0
+	}
+	for _, hash := range commits {
+		for _, parent := range parents[hash] {
+			if _, ok := children[parent]; ok {
+				children[parent]++
+			}
+		}
+	}
+
+	// Stack of ready commits - tips are taken in their revList order
+	var ready []string
+	for i := len(commits) - 1; i >= 0; i-- {
+		if children[commits[i]] == 0 {
+			ready = append(ready, commits[i])
+		}
+	}
+	ordered := make([]string, 0, len(commits))
+	for len(ready) > 0 {
+		hash := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		ordered = append(ordered, hash)
+		for _, parent := range parents[hash] {
+			if count, ok := children[parent]; ok {
+				if children[parent] = count - 1; count == 1 {
+					ready = append(ready, parent)
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// Check whether ancestor is reachable from commit (commit itself included) - used for fast-forward checks
+func isAncestor(ancestor, commit string) (bool, error) {
+	seen := make(map[string]bool)
+	queue := []string{commit}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if hash == ancestor {
+			return true, nil
+		}
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		parents, err := readCommitParents(hash)
+		if err != nil {
+			return false, err
+		}
+		queue = append(queue, parents...)
+	}
+	return false, nil
+}
+
+// Committer date as unix time - 0 if signature can't be parsed
+func commitTime(commit *Commit) int64 {
+	_, _, when, err := parseSignature(commit.Committer)
+	if err != nil {
+		return 0
+	}
+	return when.Unix()
+}
+
+// Resolve revision arguments into include and exclude hashes - "^rev" and "a..b" exclude, HEAD when
+// nothing is included
+func resolveRevisionArgs(revs []string) ([]string, []string, error) {
+	var include, exclude []string
+	for _, rev := range revs {
+		if name, ok := strings.CutPrefix(rev, "^"); ok {
+			hash, err := resolveCommitRevision(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, hash)
+			continue
+		}
+
+		if from, to, ok := strings.Cut(rev, ".."); ok {
+			if from == "" {
+				from = "HEAD"
+			}
+			fromHash, err := resolveCommitRevision(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, fromHash)
+			rev = to
+			if rev == "" {
+				rev = "HEAD"
+			}
+		}
+		hash, err := resolveCommitRevision(rev)
+		if err != nil {
+			return nil, nil, err
+		}
+		include = append(include, hash)
+	}
+
+	if len(include) == 0 {
+		hash, err := resolveCommitRevision("HEAD")
+		if err != nil {
+			return nil, nil, err
+		}
+		include = append(include, hash)
+	}
+	return include, exclude, nil
+}
+
+// Resolve revision to a commit - annotated tags are peeled
+func resolveCommitRevision(name string) (string, error) {
+	_, hash, err := resolveRevision(name)
+	if err != nil {
+		return "", err
+	}
+	if peeled, err := peelTag(hash); err == nil && peeled != "" {
+		hash = peeled
+	}
+	return hash, nil
+}
+
+// Split arguments given without "--" - arguments that are not revisions but name files (relative to prefix)
+// are paths, as in "git log <file>"
+func splitRevisionsAndPaths(args []string, prefix string) ([]string, []string) {
+	var revisions, paths []string
+	for _, arg := range args {
+		if _, _, err := resolveRevisionArgs([]string{arg}); err != nil {
+			file := prefixPath(prefix, arg)
+			if !filepath.IsAbs(file) {
+				file = workTreePath(file)
+			}
+			if _, statErr := os.Lstat(file); statErr == nil {
+				paths = append(paths, arg)
+				continue
+			}
+		}
+		revisions = append(revisions, arg)
+	}
+	return revisions, paths
+}
+
+// Print commits reachable from revisions (and every ref with All) - with Objects also their trees and blobs
+func listRevisions(options RevListOptions, w io.Writer) error {
+	var include, exclude []string
+	if !options.All || len(options.Revisions) > 0 {
+		var err error
+		if include, exclude, err = resolveRevisionArgs(options.Revisions); err != nil {
+			return err
+		}
+	}
+	if options.All {
+		tips, err := refTipCommits()
+		if err != nil {
+			return err
+		}
+		include = append(include, tips...)
+	}
+
+	var tags []string
+	if options.Objects {
+		var err error
+		if tags, err = includedTagObjects(options); err != nil {
+			return err
+		}
+		wants := append([]string(nil), include...)
+		for _, line := range tags {
+			hash, _, _ := strings.Cut(line, " ")
+			wants = append(wants, hash)
+		}
+		if hashes, ok, err := bitmapObjects(wants, exclude); err != nil {
+			return err
+		} else if ok {
+			if options.Count {
+				fmt.Fprintln(w, len(hashes))
+				return nil
+			}
+			for _, hash := range hashes {
+				fmt.Fprintln(w, hash)
+			}
+			return nil
+		}
+	}
+
+	commits, err := revList(include, exclude)
+	if err != nil {
+		return err
+	}
+	objects := tags
+	if options.Objects {
+		trees, err := listCommitObjects(commits, exclude)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, trees...)
+	}
+	if options.Count {
+		fmt.Fprintln(w, len(commits)+len(objects))
+		return nil
+	}
+	for _, hash := range commits {
+		fmt.Fprintln(w, hash)
+	}
+	for _, line := range objects {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// Annotated tags among included revisions (every tag ref with All) as "<hash> <name>" lines - tags of tags
+// too
+func includedTagObjects(options RevListOptions) ([]string, error) {
+	names := make(map[string]string)
+	var order []string
+	for _, rev := range options.Revisions {
+		if strings.HasPrefix(rev, "^") || strings.Contains(rev, "..") {
+			continue
+		}
+		if _, hash, err := resolveRevision(rev); err == nil {
+			names[rev] = hash
+			order = append(order, rev)
+		}
+	}
+	if options.All {
+		refs, err := listRefs("refs/")
+		if err != nil {
+			return nil, err
+		}
+		for _, refName := range sortedKeys(refs) {
+			name := shortRefName(refName)
+			names[name] = refs[refName]
+			order = append(order, name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var lines []string
+	for _, name := range order {
+		for hash := names[name]; !seen[hash]; {
+			seen[hash] = true
+			objType, _, content, err := readObjectFromHash(hash)
+			if err != nil || objType != "tag" {
+				break
+			}
+			lines = append(lines, hash+" "+name)
+			object, _, _ := strings.Cut(string(content), "\n")
+			hash = strings.TrimPrefix(object, "object ")
+		}
+	}
+	return lines, nil
+}
+
+// Trees and blobs of commits' root trees as "<hash> <path>" lines (root tree has an empty path) - each
+// object once, those reachable from commits of exclude are left out
+func listCommitObjects(commits, exclude []string) ([]string, error) {
+	seen := make(map[string]bool)
+	if len(exclude) > 0 {
+		excluded, err := revList(exclude, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range excluded {
+			commit, err := readCommit(hash)
+			if err != nil {
+				return nil, err
+			}
+			if err := walkTreeObjects(commit.Tree, "", seen, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var lines []string
+	for _, hash := range commits {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := walkTreeObjects(commit.Tree, "", seen, &lines); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// Walk tree and everything below it not seen yet (tree before its entries) - adds "<hash> <path>" lines
+// when lines is not nil
+func walkTreeObjects(tree, treePath string, seen map[string]bool, lines *[]string) error {
+	if seen[tree] {
+		return nil
+	}
+	seen[tree] = true
+	if lines != nil {
+		*lines = append(*lines, tree+" "+treePath)
+	}
+	_, _, content, err := readObjectFromHash(tree)
+	if err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", tree, err)
+	}
+	entries, err := parseTreeContent(content)
+	if err != nil {
+		return fmt.Errorf("failed to parse tree %s: %w", tree, err)
+	}
+	for _, entry := range entries {
+		entryPath := path.Join(treePath, entry.Name)
+		switch entry.Mode {
+		case "160000":
+		case "40000":
+			if err := walkTreeObjects(entry.Hash, entryPath, seen, lines); err != nil {
+				return err
+			}
+		default:
+			if !seen[entry.Hash] {
+				seen[entry.Hash] = true
+				if lines != nil {
+					*lines = append(*lines, entry.Hash+" "+entryPath)
+				}
+			}
+		}
+	}
+	return nil
+}
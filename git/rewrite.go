@@ -0,0 +1,543 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Rewrite history - every commit reachable from any ref is rebuilt, parents first:
+//   - --remove-path drops a file or directory from every tree (directories left empty go too)
+//   - --mailmap rewrites author, committer and tagger identities with a mailmap file, one mapping per line:
+//     "New Name <commit@email>", "<new@email> <commit@email>", "New Name <new@email> <commit@email>" or
+//     "New Name <new@email> Commit Name <commit@email>"
+//
+// A commit that the removal left without changes is dropped (its children get its parent instead), unless
+// it had no changes to begin with. Commit signatures become invalid and are dropped; annotated tags of
+// rewritten commits are rewritten as well, without their signatures. Grafts become real parents.
+//
+// Refs (branches, tags, remote-tracking branches, stash) then point to the new commits and the work tree is
+// moved to the new HEAD. The old -> new hashes of commits and refs are written to
+// .git/rewrite-history/commit-map and ref-map. Finally reflogs are expired, objects repacked and
+// unreachable ones pruned, so the old history (e.g. a committed secret) is gone from the repository -
+// --no-gc keeps it. An existing commit-graph is written again without the old commits.
+
+// Hash of the tree without entries
+const emptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// Rewrite every commit and ref - progress and summary go to w
+func rewriteHistory(options RewriteHistoryOptions, w io.Writer) error {
+	defer tracePerformance("rewrite-history")()
+	// Stored objects are rewritten, not their replacements
+	defer ignoreReplaceRefs()()
+	if len(options.RemovePaths) == 0 && options.Mailmap == "" {
+		return fmt.Errorf("nothing to rewrite - give --remove-path or --mailmap")
+	}
+	if shallow, err := readShallowCommits(); err != nil {
+		return err
+	} else if len(shallow) > 0 {
+		return fmt.Errorf("rewrite-history is not supported in shallow repositories")
+	}
+
+	rewriter := &historyRewriter{
+		removed:  make(map[string]bool),
+		trees:    make(map[string]string),
+		commits:  make(map[string]string),
+		newTrees: make(map[string]string),
+		dropped:  make(map[string]bool),
+		tags:     make(map[string]string),
+	}
+	for _, removed := range options.RemovePaths {
+		if removed = strings.Trim(removed, "/"); removed != "" {
+			rewriter.removed[removed] = true
+		}
+	}
+	if options.Mailmap != "" {
+		data, err := os.ReadFile(options.Mailmap)
+		if err != nil {
+			return fmt.Errorf("failed to read mailmap: %w", err)
+		}
+		rewriter.mailmap = parseMailmap(string(data))
+	}
+
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return err
+	}
+	replaceRefs := replaceRefPrefix()
+	var tips []string
+	for refName, hash := range refs {
+		if strings.HasPrefix(refName, replaceRefs) {
+			continue
+		}
+		if commit, err := peelObject(hash, "commit"); err == nil {
+			tips = append(tips, commit)
+		}
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if head != "" {
+		tips = append(tips, head)
+	}
+
+	// Walk gives children first - parents are rewritten first
+	commits, err := revList(tips, nil)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(commits)
+	for i, hash := range commits {
+		if err := rewriter.rewriteCommit(hash); err != nil {
+			return err
+		}
+		if (i+1)%1000 == 0 {
+			fmt.Fprintf(w, "Rewritten %d/%d commits\n", i+1, len(commits))
+		}
+	}
+
+	// New value of every ref - refs whose commit was dropped with all its history are deleted
+	updates := make(map[string]string)
+	for _, refName := range sortedKeys(refs) {
+		if strings.HasPrefix(refName, replaceRefs) {
+			continue
+		}
+		rewritten, err := rewriter.rewriteRefTarget(refs[refName])
+		if err != nil {
+			return err
+		}
+		if rewritten != refs[refName] {
+			updates[refName] = rewritten
+		}
+	}
+
+	// Work tree follows HEAD - local changes in the paths that change stop the rewrite before refs move
+	newHead := head
+	if head != "" {
+		newHead = rewriter.commits[head]
+	}
+	if newHead != head && !resolveRepoLayout().Bare {
+		oldFiles, err := commitFiles(head)
+		if err != nil {
+			return err
+		}
+		newFiles := make(map[string]TreeEntry)
+		if newHead != "" {
+			if err := flattenTree(rewriter.newTrees[newHead], "", newFiles); err != nil {
+				return err
+			}
+		}
+		if err := checkoutFiles(oldFiles, newFiles, newFiles, "rewrite-history"); err != nil {
+			return err
+		}
+	}
+
+	for _, refName := range sortedKeys(updates) {
+		if updates[refName] == "" {
+			err = deleteRef(refName)
+		} else {
+			err = updateRef(refName, updates[refName], "rewrite-history")
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Ref '%s' was rewritten\n", refName)
+	}
+	if branch == "" && newHead != head {
+		if newHead == "" {
+			return fmt.Errorf("detached HEAD commit %s was dropped with all its history", head)
+		}
+		if err := updateRef("HEAD", newHead, "rewrite-history"); err != nil {
+			return err
+		}
+	}
+
+	if err := writeRewriteMaps(commits, rewriter.commits, refs, updates); err != nil {
+		return err
+	}
+	changed := 0
+	dropped := 0
+	for _, hash := range commits {
+		switch rewritten := rewriter.commits[hash]; {
+		case rewriter.dropped[hash]:
+			dropped++
+		case rewritten != hash:
+			changed++
+		}
+	}
+	fmt.Fprintf(w, "Rewrote %d of %d commits (%d dropped as empty)\n", changed, len(commits), dropped)
+	if options.NoGc || changed+dropped == 0 {
+		return nil
+	}
+
+	// Old history is only reachable from reflogs now
+	expire := ReflogExpireOptions{All: true, Expire: "now", ExpireUnreachable: "now"}
+	if _, err := expireReflogs(expire, io.Discard); err != nil {
+		return err
+	}
+	if err := runRepack(RepackOptions{All: true, Delete: true}, io.Discard); err != nil {
+		return err
+	}
+	if _, err := pruneObjects(PruneOptions{Expire: "now"}, io.Discard); err != nil {
+		return err
+	}
+	if err := refreshCommitGraph(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Old history was pruned")
+	return nil
+}
+
+// Write the commit-graph again after the old history was pruned - it would still list the old commits.
+// Repack already dropped the multi-pack-index and bitmaps of the packs it deleted
+func refreshCommitGraph() error {
+	graphPath := objectDirPath("info", commitGraphName)
+	if _, err := os.Stat(graphPath); os.IsNotExist(err) {
+		return nil
+	}
+	if grafted, err := hasCommitGrafts(); err != nil {
+		return err
+	} else if !grafted {
+		_, err := writeCommitGraph()
+		return err
+	}
+	// Grafted history can't have a commit-graph
+	if err := os.Remove(graphPath); err != nil {
+		return fmt.Errorf("failed to remove commit-graph: %w", err)
+	}
+	return nil
+}
+
+// Rewrite commit whose parents were already rewritten - dropped commits map to their (rewritten) parent,
+// or to "" when they had none
+func (rewriter *historyRewriter) rewriteCommit(hash string) error {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return err
+	}
+	tree, err := rewriter.rewriteTree(commit.Tree, "")
+	if err != nil {
+		return err
+	}
+
+	var parents []string
+	parentDropped := false
+	for _, parent := range commit.Parents {
+		rewritten, ok := rewriter.commits[parent]
+		if !ok {
+			// Not walked (missing) - kept as it is
+			rewritten = parent
+		}
+		parentDropped = parentDropped || rewriter.dropped[parent]
+		if rewritten != "" && !slices.Contains(parents, rewritten) {
+			parents = append(parents, rewritten)
+		}
+	}
+	if parentDropped && len(parents) > 1 {
+		// A merge of a dropped side branch may now merge an ancestor of its other parent - that adds nothing
+		if parents, err = dropAncestorParents(parents); err != nil {
+			return err
+		}
+	}
+
+	// Commit that only changed removed paths has nothing left to change
+	if len(parents) <= 1 && len(rewriter.removed) > 0 {
+		parentTree := emptyTreeHash
+		if len(parents) == 1 {
+			if parentTree, err = rewriter.commitTree(parents[0]); err != nil {
+				return err
+			}
+		}
+		wasEmpty := len(commit.Parents) == 0 && commit.Tree == emptyTreeHash
+		if len(commit.Parents) == 1 {
+			originalParentTree, err := readCommitTreeHash(commit.Parents[0])
+			if err != nil {
+				return err
+			}
+			wasEmpty = commit.Tree == originalParentTree
+		}
+		if tree == parentTree && !wasEmpty {
+			rewriter.dropped[hash] = true
+			rewriter.commits[hash] = ""
+			if len(parents) == 1 {
+				rewriter.commits[hash] = parents[0]
+			}
+			return nil
+		}
+	}
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(&content, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&content, "author %s\n", rewriter.rewriteIdentity(commit.Author))
+	fmt.Fprintf(&content, "committer %s\n", rewriter.rewriteIdentity(commit.Committer))
+	if commit.Encoding != "" {
+		fmt.Fprintf(&content, "encoding %s\n", commit.Encoding)
+	}
+	for _, header := range commit.ExtraHeaders {
+		if key, _, _ := strings.Cut(header, " "); key == "gpgsig" || key == "gpgsig-sha256" {
+			// Signature doesn't match the new content
+			continue
+		}
+		fmt.Fprintf(&content, "%s\n", header)
+	}
+	fmt.Fprintf(&content, "\n%s", commit.Message)
+
+	newHash, err := writeObject(generateObjectByte("commit", content.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to write commit: %w", err)
+	}
+	rewriter.commits[hash] = hex.EncodeToString(newHash)
+	rewriter.newTrees[rewriter.commits[hash]] = tree
+	return nil
+}
+
+// Parents without those that are ancestors of another parent
+func dropAncestorParents(parents []string) ([]string, error) {
+	var kept []string
+	for i, parent := range parents {
+		redundant := false
+		for j, other := range parents {
+			if i == j {
+				continue
+			}
+			var err error
+			if redundant, err = isAncestor(parent, other); err != nil {
+				return nil, err
+			} else if redundant {
+				break
+			}
+		}
+		if !redundant {
+			kept = append(kept, parent)
+		}
+	}
+	return kept, nil
+}
+
+// Tree of a rewritten (or kept) commit
+func (rewriter *historyRewriter) commitTree(hash string) (string, error) {
+	if tree, ok := rewriter.newTrees[hash]; ok {
+		return tree, nil
+	}
+	return readCommitTreeHash(hash)
+}
+
+// Rewrite tree at dirPath without removed paths - "" when nothing is left in it
+func (rewriter *historyRewriter) rewriteTree(hash, dirPath string) (string, error) {
+	if len(rewriter.removed) == 0 {
+		return hash, nil
+	}
+	key := dirPath + "\x00" + hash
+	if rewritten, ok := rewriter.trees[key]; ok {
+		return rewritten, nil
+	}
+
+	_, _, content, err := readObjectFromHash(hash)
+	if err != nil {
+		return "", fmt.Errorf("cannot read tree %s: %w", hash, err)
+	}
+	entries, err := parseTreeContent(content)
+	if err != nil {
+		return "", err
+	}
+	var rewritten bytes.Buffer
+	for _, entry := range entries {
+		entryPath := entry.Name
+		if dirPath != "" {
+			entryPath = dirPath + "/" + entry.Name
+		}
+		if rewriter.removed[entryPath] {
+			continue
+		}
+		if entry.Mode == "40000" && rewriter.removesBelow(entryPath) {
+			if entry.Hash, err = rewriter.rewriteTree(entry.Hash, entryPath); err != nil {
+				return "", err
+			}
+			if entry.Hash == "" {
+				continue
+			}
+		}
+		rawHash, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&rewritten, "%s %s\x00", entry.Mode, entry.Name)
+		rewritten.Write(rawHash)
+	}
+
+	result := ""
+	if rewritten.Len() > 0 || dirPath == "" {
+		newHash, err := writeObject(generateObjectByte("tree", rewritten.Bytes()))
+		if err != nil {
+			return "", fmt.Errorf("failed to write tree: %w", err)
+		}
+		result = hex.EncodeToString(newHash)
+	}
+	rewriter.trees[key] = result
+	return result, nil
+}
+
+// Check whether a removed path is inside directory dirPath
+func (rewriter *historyRewriter) removesBelow(dirPath string) bool {
+	for removed := range rewriter.removed {
+		if strings.HasPrefix(removed, dirPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// New value of a ref - commits map to their rewritten commits, annotated tags of them are rewritten, other
+// objects stay. "" when the commit was dropped with all its history.
+func (rewriter *historyRewriter) rewriteRefTarget(hash string) (string, error) {
+	if rewritten, ok := rewriter.commits[hash]; ok {
+		return rewritten, nil
+	}
+	if rewritten, ok := rewriter.tags[hash]; ok {
+		return rewritten, nil
+	}
+	objType, _, content, err := readObjectFromHash(hash)
+	if err != nil || objType != "tag" {
+		return hash, err
+	}
+	tag, err := parseTag(content)
+	if err != nil {
+		return "", fmt.Errorf("bad tag %s: %w", hash, err)
+	}
+	target, err := rewriter.rewriteRefTarget(tag.Object)
+	if err != nil {
+		return "", err
+	}
+	tagger := rewriter.rewriteIdentity(tag.Tagger)
+	if target == tag.Object && tagger == tag.Tagger {
+		rewriter.tags[hash] = hash
+		return hash, nil
+	}
+	if target == "" {
+		rewriter.tags[hash] = ""
+		return "", nil
+	}
+
+	message := tag.Message
+	if start := strings.Index(message, "-----BEGIN PGP SIGNATURE-----"); start != -1 {
+		// Signature doesn't match the new content
+		message = message[:start]
+	}
+	var rewritten strings.Builder
+	fmt.Fprintf(&rewritten, "object %s\ntype %s\ntag %s\n", target, tag.Type, tag.Name)
+	if tag.Tagger != "" {
+		fmt.Fprintf(&rewritten, "tagger %s\n", tagger)
+	}
+	fmt.Fprintf(&rewritten, "\n%s", message)
+	newHash, err := writeObject(generateObjectByte("tag", []byte(rewritten.String())))
+	if err != nil {
+		return "", fmt.Errorf("failed to write tag: %w", err)
+	}
+	rewriter.tags[hash] = hex.EncodeToString(newHash)
+	return rewriter.tags[hash], nil
+}
+
+// Rewrite identity of signature ("Name <email> <time> <zone>") with mailmap - the date stays
+func (rewriter *historyRewriter) rewriteIdentity(signature string) string {
+	if len(rewriter.mailmap) == 0 {
+		return signature
+	}
+	open := strings.IndexByte(signature, '<')
+	closing := strings.IndexByte(signature, '>')
+	if open == -1 || closing < open {
+		return signature
+	}
+	name, email := strings.TrimSpace(signature[:open]), signature[open+1:closing]
+	entry, ok := rewriter.mailmap[strings.ToLower(email)+"\x00"+name]
+	if !ok {
+		if entry, ok = rewriter.mailmap[strings.ToLower(email)]; !ok {
+			return signature
+		}
+	}
+	if entry.Name != "" {
+		name = entry.Name
+	}
+	if entry.Email != "" {
+		email = entry.Email
+	}
+	return fmt.Sprintf("%s <%s>%s", name, email, signature[closing+1:])
+}
+
+// Parse mailmap file - entries by lowercase commit email, or by lowercase commit email + "\x00" + commit
+// name when the line names the commit name too
+func parseMailmap(data string) map[string]mailmapEntry {
+	mailmap := make(map[string]mailmapEntry)
+	for _, line := range strings.Split(data, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		// Split into names and emails: "A <a> B <b>" -> A, a, B, b
+		var names, emails []string
+		for {
+			open := strings.IndexByte(line, '<')
+			closing := strings.IndexByte(line, '>')
+			if open == -1 || closing < open {
+				break
+			}
+			names = append(names, strings.TrimSpace(line[:open]))
+			emails = append(emails, line[open+1:closing])
+			line = line[closing+1:]
+		}
+		switch len(emails) {
+		case 1:
+			// "New Name <commit@email>"
+			if names[0] != "" {
+				mailmap[strings.ToLower(emails[0])] = mailmapEntry{Name: names[0]}
+			}
+		case 2:
+			entry := mailmapEntry{Name: names[0], Email: emails[0]}
+			key := strings.ToLower(emails[1])
+			if names[1] != "" {
+				key += "\x00" + names[1]
+			}
+			mailmap[key] = entry
+		}
+	}
+	return mailmap
+}
+
+// Write old -> new hashes of commits ("<old> <new>", all zeros for dropped commits without parents) and refs
+// ("<old> <new> <ref>") to .git/rewrite-history
+func writeRewriteMaps(commits []string, rewritten map[string]string, refs, updates map[string]string) error {
+	dir := gitDirPath("rewrite-history")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	var commitMap strings.Builder
+	for _, hash := range commits {
+		newHash := rewritten[hash]
+		if newHash == "" {
+			newHash = zeroHash
+		}
+		fmt.Fprintf(&commitMap, "%s %s\n", hash, newHash)
+	}
+	commitMapPath := gitDirPath("rewrite-history", "commit-map")
+	if err := os.WriteFile(commitMapPath, []byte(commitMap.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write commit map: %w", err)
+	}
+
+	var refMap strings.Builder
+	for _, refName := range sortedKeys(updates) {
+		newHash := updates[refName]
+		if newHash == "" {
+			newHash = zeroHash
+		}
+		fmt.Fprintf(&refMap, "%s %s %s\n", refs[refName], newHash, refName)
+	}
+	if err := os.WriteFile(gitDirPath("rewrite-history", "ref-map"), []byte(refMap.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write ref map: %w", err)
+	}
+	return nil
+}
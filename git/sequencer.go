@@ -0,0 +1,504 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Picking commits - the changes a commit made to its parent are merged into HEAD (three-way merge with
+// the parent as base, HEAD as ours and the commit as theirs) and committed with the commit's author and
+// message. Reverting is the same merge the other way round (the commit as base, its parent as theirs).
+// For a merge commit the parent is the mainline one (-m <parent-number>). Commands that replay commits
+// (rebase, cherry-pick, revert) are built on it.
+//
+// Cherry-pick and revert keep their state in .git/sequencer, so that a pick stopped by conflicts can be
+// resumed with --continue, left out with --skip or undone with --abort:
+//
+//	todo        commits still to do, "pick <hash> <subject>" or "revert <hash> <subject>" per line
+//	head        commit HEAD was at before the command started
+//	no-commit   present when changes are only applied to index and work tree (-n)
+//	mainline    parent number merge commits are picked against (-m)
+//
+// The commit whose pick stopped is in .git/CHERRY_PICK_HEAD (.git/REVERT_HEAD for revert).
+
+// Command doing each todo command, and the pseudo ref holding its stopped commit
+var sequencerOperations = map[string]string{
+	"pick":   "cherry-pick",
+	"revert": "revert",
+}
+var sequencerStoppedRefs = map[string]string{
+	"pick":   "CHERRY_PICK_HEAD",
+	"revert": "REVERT_HEAD",
+}
+
+// Apply changes of commit (undo them with options.Revert) on top of HEAD as a new commit - with
+// options.FastForward a commit whose parent is HEAD is reused as it is, with options.NoCommit changes are
+// merged into the index (not HEAD) and left there.
+// Returns the commit HEAD moved to ("" when the changes are already in HEAD and nothing was committed, or
+// nothing was to be committed) and whether the merge stopped with conflicts (left in the work tree, HEAD
+// doesn't move).
+func pickCommit(commit string, options pickOptions, w io.Writer) (string, bool, error) {
+	parsed, err := readCommit(commit)
+	if err != nil {
+		return "", false, err
+	}
+	parent, err := mainlineParent(commit, parsed, options.Mainline)
+	if err != nil {
+		return "", false, err
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return "", false, err
+	}
+	headFiles, err := commitFiles(head)
+	if err != nil {
+		return "", false, err
+	}
+	// Without a commit the changes pile up in the index - it takes HEAD's place in the merge
+	if options.NoCommit {
+		indexTree, err := writeTreeFromIndex()
+		if err != nil {
+			return "", false, err
+		}
+		headFiles = make(map[string]TreeEntry)
+		if err := flattenTree(indexTree, "", headFiles); err != nil {
+			return "", false, err
+		}
+	}
+	commitFileSet, err := commitFiles(commit)
+	if err != nil {
+		return "", false, err
+	}
+
+	if options.FastForward && len(parsed.Parents) == 1 && parent == head {
+		if err := checkoutFiles(headFiles, commitFileSet, commitFileSet, options.Operation); err != nil {
+			return "", false, err
+		}
+		return commit, false, moveHead(commit, options.Operation+": fast-forward")
+	}
+
+	baseFiles := make(map[string]TreeEntry)
+	if parent != "" {
+		if baseFiles, err = commitFiles(parent); err != nil {
+			return "", false, err
+		}
+	}
+	subject, _ := splitCommitMessage(parsed.Message)
+	label := fmt.Sprintf("%s (%s)", shortHash(commit), subject)
+	settings, err := resolveMergeSettings(nil, label)
+	if err != nil {
+		return "", false, err
+	}
+	settings.BaseLabel = "parent of " + label
+	message, author := parsed.Message, parsed.Author
+	// Revert merges the commit's parent in, with the commit as base - and is authored by the current user
+	if options.Revert {
+		baseFiles, commitFileSet = commitFileSet, baseFiles
+		settings.BaseLabel, settings.TheirsLabel = label, "parent of "+label
+		message, author = revertMessage(commit, parsed, options.Mainline), ""
+	}
+	result, err := mergeFileSets(baseFiles, headFiles, commitFileSet, settings)
+	if err != nil {
+		return "", false, err
+	}
+	// Clean picks are quiet - what the merge found is only interesting when it stops
+	if len(result.Conflicts) > 0 {
+		for _, message := range result.Messages {
+			fmt.Fprintln(w, message.Text)
+		}
+	}
+	conflicts, err := checkoutMergeResult(headFiles, result, options.Operation, w)
+	if err != nil || conflicts || options.NoCommit {
+		return "", conflicts, err
+	}
+
+	treeHash, err := writeTreeFromFiles(result.Files)
+	if err != nil {
+		return "", false, err
+	}
+	if headTree, err := readCommitTreeHash(head); err != nil {
+		return "", false, err
+	} else if headTree == treeHash {
+		return "", false, nil
+	}
+	hash, err := createCommit(treeHash, []string{head}, CommitOptions{Message: message, Author: author})
+	if err != nil {
+		return "", false, err
+	}
+	subject, _ = splitCommitMessage(message)
+	return hash, false, moveHead(hash, options.Operation+": "+subject)
+}
+
+// Parent the changes of commit are taken against - mainline (1-based) selects it for a merge commit,
+// "" for a root commit
+func mainlineParent(commit string, parsed *Commit, mainline int) (string, error) {
+	switch {
+	case len(parsed.Parents) > 1 && mainline == 0:
+		return "", fmt.Errorf("commit %s is a merge but no -m option was given", commit)
+	case len(parsed.Parents) > 1 && mainline > len(parsed.Parents):
+		return "", fmt.Errorf("commit %s does not have parent %d", commit, mainline)
+	case len(parsed.Parents) <= 1 && mainline > 0:
+		return "", fmt.Errorf("mainline was specified but commit %s is not a merge", commit)
+	case len(parsed.Parents) > 1:
+		return parsed.Parents[mainline-1], nil
+	case len(parsed.Parents) == 1:
+		return parsed.Parents[0], nil
+	}
+	return "", nil
+}
+
+// Message of the commit reverting commit - a merge commit says which parent's changes it reverses
+func revertMessage(commit string, parsed *Commit, mainline int) string {
+	subject, _ := splitCommitMessage(parsed.Message)
+	message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject, commit)
+	if mainline > 0 {
+		return message + fmt.Sprintf(", reversing\nchanges made to %s.\n", parsed.Parents[mainline-1])
+	}
+	return message + ".\n"
+}
+
+// Replace HEAD commit with one of treeHash and message ("" keeps HEAD's) - author and parents stay, returns
+// the new commit
+func amendHead(treeHash, message string) (string, error) {
+	_, head, err := readHead()
+	if err != nil {
+		return "", err
+	}
+	parsed, err := readCommit(head)
+	if err != nil {
+		return "", err
+	}
+	if treeHash == "" {
+		treeHash = parsed.Tree
+	}
+	if message == "" {
+		message = parsed.Message
+	}
+	hash, err := createCommit(treeHash, parsed.Parents, CommitOptions{Message: message, Author: parsed.Author})
+	if err != nil {
+		return "", err
+	}
+	subject, _ := splitCommitMessage(message)
+	return hash, moveHead(hash, "commit (amend): "+subject)
+}
+
+// Point HEAD at commit - the branch HEAD is on moves, detached HEAD is changed itself. Message goes to the
+// reflog
+func moveHead(commit, message string) error {
+	branch, _, err := readHead()
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		return updateRef("HEAD", commit, message)
+	}
+	return updateRef(branch, commit, message)
+}
+
+// Commits named by revision arguments, oldest first - with a range ("a..b", "^a") history is walked,
+// otherwise the revisions are picked as given
+func resolvePickCommits(revs []string) ([]string, error) {
+	walk := false
+	for _, rev := range revs {
+		if strings.HasPrefix(rev, "^") || strings.Contains(rev, "..") {
+			walk = true
+		}
+	}
+	if !walk {
+		var commits []string
+		for _, rev := range revs {
+			hash, err := resolveCommitRevision(rev)
+			if err != nil {
+				return nil, err
+			}
+			commits = append(commits, hash)
+		}
+		return commits, nil
+	}
+
+	include, exclude, err := resolveRevisionArgs(revs)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := revList(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("empty commit set passed")
+	}
+	slices.Reverse(commits)
+	return commits, nil
+}
+
+// Start cherry-pick or revert (command "pick" or "revert" of every commit) - the todo list and the starting
+// HEAD are saved in .git/sequencer, then done
+func sequencerStart(command string, revs []string, noCommit bool, mainline int, w io.Writer) error {
+	operation := sequencerOperations[command]
+	if sequencerInProgress() {
+		return fmt.Errorf("cherry-pick or revert is already in progress - use \"git %s (--continue | --skip | --abort)\"", operation)
+	}
+	commits, err := resolvePickCommits(revs)
+	if err != nil {
+		return err
+	}
+	_, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	// Picks are committed from the index - staged changes would end up in the first one
+	if !noCommit {
+		if dirty, err := indexDiffersFromCommit(head); err != nil {
+			return err
+		} else if dirty {
+			return fmt.Errorf("your local changes would be overwritten by %s - commit your changes or stash them to proceed", operation)
+		}
+	}
+
+	var todo strings.Builder
+	for _, commit := range commits {
+		parsed, err := readCommit(commit)
+		if err != nil {
+			return err
+		}
+		// Merge commits without -m (or -m for the others) fail before anything is picked
+		if _, err := mainlineParent(commit, parsed, mainline); err != nil {
+			return err
+		}
+		subject, _ := splitCommitMessage(parsed.Message)
+		fmt.Fprintf(&todo, "%s %s %s\n", command, commit, subject)
+	}
+	stateDir := gitDirPath("sequencer")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", stateDir, err)
+	}
+	state := map[string]string{"head": head + "\n", "todo": todo.String()}
+	if noCommit {
+		state["no-commit"] = ""
+	}
+	if mainline > 0 {
+		state["mainline"] = strconv.Itoa(mainline) + "\n"
+	}
+	for name, content := range state {
+		if err := os.WriteFile(filepath.Join(stateDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return sequencerRun(w)
+}
+
+// Do lines of the todo list until it is empty (or a pick stops), then drop the sequencer state
+func sequencerRun(w io.Writer) error {
+	stateDir := gitDirPath("sequencer")
+	noCommit, mainline, err := readSequencerOptions()
+	if err != nil {
+		return err
+	}
+
+	for {
+		todo, err := os.ReadFile(filepath.Join(stateDir, "todo"))
+		if err != nil {
+			return fmt.Errorf("failed to read todo list: %w", err)
+		}
+		line, rest, _ := strings.Cut(string(todo), "\n")
+		if strings.TrimSpace(line) == "" && rest == "" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || sequencerOperations[fields[0]] == "" {
+			return fmt.Errorf("invalid line in todo list: %s", line)
+		}
+		command, commit, subject := fields[0], fields[1], strings.Join(fields[2:], " ")
+		operation := sequencerOperations[command]
+
+		// A failed pick (not a conflict) stays in the todo list - --continue tries it again
+		options := pickOptions{Operation: operation, NoCommit: noCommit, Mainline: mainline, Revert: command == "revert"}
+		hash, conflicts, err := pickCommit(commit, options, w)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(stateDir, "todo"), []byte(rest), 0644); err != nil {
+			return err
+		}
+		if conflicts || (hash == "" && !noCommit) {
+			if err := updateRef(sequencerStoppedRefs[command], commit, command); err != nil {
+				return err
+			}
+		}
+		if conflicts {
+			verb := "apply"
+			if options.Revert {
+				verb = "revert"
+			}
+			return fmt.Errorf("could not %s %s... %s\n"+
+				"After resolving the conflicts, mark them with \"git add/rm <pathspec>\", then run \"git %s --continue\".\n"+
+				"You can instead skip this commit with \"git %s --skip\".\n"+
+				"To abort and get back to the state before \"git %s\", run \"git %s --abort\".",
+				verb, shortHash(commit), subject, operation, operation, operation, operation)
+		}
+		if noCommit {
+			continue
+		}
+		if hash == "" {
+			return fmt.Errorf("the previous %s is now empty, possibly due to conflict resolution - "+
+				"use \"git %s --skip\" to leave it out, or \"git %s --continue\" to go on without it", operation, operation, operation)
+		}
+		parsed, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		writeCommitSummary(hash, parsed.Message, w)
+	}
+	return os.RemoveAll(stateDir)
+}
+
+// Commit what the user resolved for the stopped pick (with the message the pick would have committed) and
+// go on with the todo list
+func sequencerContinue(w io.Writer) error {
+	if !sequencerInProgress() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	if command, stopped := sequencerStopped(); stopped != "" {
+		if err := requireMergedIndex("Committing"); err != nil {
+			return err
+		}
+		if err := rerereRecordResolutions(w); err != nil {
+			return err
+		}
+		noCommit, mainline, err := readSequencerOptions()
+		if err != nil {
+			return err
+		}
+		_, head, err := readHead()
+		if err != nil {
+			return err
+		}
+		dirty, err := indexDiffersFromCommit(head)
+		if err != nil {
+			return err
+		}
+		// Resolution that leaves nothing of the commit drops it
+		if dirty && !noCommit {
+			parsed, err := readCommit(stopped)
+			if err != nil {
+				return err
+			}
+			options := CommitOptions{Message: parsed.Message, Author: parsed.Author}
+			if command == "revert" {
+				options = CommitOptions{Message: revertMessage(stopped, parsed, mainline)}
+			}
+			hash, err := commitIndex(options)
+			if err != nil {
+				return err
+			}
+			writeCommitSummary(hash, options.Message, w)
+		}
+		if err := os.Remove(gitDirPath(sequencerStoppedRefs[command])); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(gitDirPath("sequencer")); err != nil {
+		return nil
+	}
+	return sequencerRun(w)
+}
+
+// Drop the stopped pick (its conflicts included) and go on with the todo list
+func sequencerSkip(w io.Writer) error {
+	if !sequencerInProgress() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	if command, stopped := sequencerStopped(); stopped != "" {
+		_, head, err := readHead()
+		if err != nil {
+			return err
+		}
+		if err := resetWorkTreeToCommit(head); err != nil {
+			return err
+		}
+		if err := rerereClear(); err != nil {
+			return err
+		}
+		if err := os.Remove(gitDirPath(sequencerStoppedRefs[command])); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(gitDirPath("sequencer")); err != nil {
+		return nil
+	}
+	return sequencerRun(w)
+}
+
+// Stop cherry-pick or revert - HEAD, index and work tree go back to where they were before it started
+func sequencerAbort() error {
+	if !sequencerInProgress() {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+	head, err := os.ReadFile(gitDirPath("sequencer", "head"))
+	if os.IsNotExist(err) {
+		// Stopped pick without a todo list - only its changes are undone
+		_, current, err := readHead()
+		if err != nil {
+			return err
+		}
+		head = []byte(current)
+	} else if err != nil {
+		return err
+	}
+
+	original := strings.TrimSpace(string(head))
+	if err := resetWorkTreeToCommit(original); err != nil {
+		return err
+	}
+	if err := moveHead(original, "reset: moving to "+original); err != nil {
+		return err
+	}
+	if err := rerereClear(); err != nil {
+		return err
+	}
+	for _, ref := range sequencerStoppedRefs {
+		os.Remove(gitDirPath(ref))
+	}
+	return os.RemoveAll(gitDirPath("sequencer"))
+}
+
+// Whether a cherry-pick or revert stopped (its todo list or stopped pick is still there)
+func sequencerInProgress() bool {
+	if _, err := os.Stat(gitDirPath("sequencer")); err == nil {
+		return true
+	}
+	command, _ := sequencerStopped()
+	return command != ""
+}
+
+// Todo command and commit of the stopped pick - empty when no pick stopped
+func sequencerStopped() (string, string) {
+	for _, command := range sortedKeys(sequencerStoppedRefs) {
+		if commit, err := resolveCommitRevision(sequencerStoppedRefs[command]); err == nil {
+			return command, commit
+		}
+	}
+	return "", ""
+}
+
+// Options the sequencer was started with - no-commit and the mainline parent number (0 when not set)
+func readSequencerOptions() (bool, int, error) {
+	_, err := os.Stat(gitDirPath("sequencer", "no-commit"))
+	noCommit := err == nil
+	data, err := os.ReadFile(gitDirPath("sequencer", "mainline"))
+	if os.IsNotExist(err) {
+		return noCommit, 0, nil
+	} else if err != nil {
+		return false, 0, err
+	}
+	mainline, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid mainline in sequencer state: %w", err)
+	}
+	return noCommit, mainline, nil
+}
@@ -0,0 +1,173 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Shallow repositories - .git/shallow lists commits whose parents are not in the object database
+// (one hash per line). History walks treat those commits as if they had no parents.
+//
+// Grafts - .git/info/grafts (or GIT_GRAFT_FILE) gives commits other parents than they store, one commit per
+// line followed by its parents ("<commit> [<parent>...]", # starts a comment). Shallow commits are grafts
+// without parents, unless the grafts file lists them. Every commit read (readCommit, readCommitParents) gets
+// its grafted parents, so log, merge-base, rev-list and fast-export see the grafted history, while repack,
+// prune and fsck follow the stored parents (except of shallow commits) - objects are never lost to a graft.
+
+// Read .git/shallow - sorted list of shallow commits (empty if repository is not shallow)
+func readShallowCommits() ([]string, error) {
+	data, err := os.ReadFile(gitDirPath("shallow"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read shallow file: %w", err)
+	}
+
+	var commits []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			commits = append(commits, line)
+		}
+	}
+	sort.Strings(commits)
+	return commits, nil
+}
+
+// Grafts by git directory (commit -> parents it is treated as having) - grafts are read only once per process
+var commitGraftsCache = make(map[string]map[string][]string)
+
+// Load grafts of the grafts file and shallow commits (no parents) - commit -> parents
+func loadCommitGrafts() (map[string][]string, error) {
+	gitDir := resolveRepoLayout().GitDir
+	if grafts, ok := commitGraftsCache[gitDir]; ok {
+		return grafts, nil
+	}
+
+	grafts := make(map[string][]string)
+	graftFile := os.Getenv("GIT_GRAFT_FILE")
+	if graftFile == "" {
+		graftFile = gitDirPath("info", "grafts")
+	}
+	data, err := os.ReadFile(graftFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read grafts file: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hashes := strings.Fields(line)
+		for _, hash := range hashes {
+			if len(hash) != 40 || strings.Trim(hash, "0123456789abcdef") != "" {
+				return nil, fmt.Errorf("bad graft data: %s", line)
+			}
+		}
+		grafts[hashes[0]] = hashes[1:]
+	}
+
+	shallow, err := readShallowCommits()
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range shallow {
+		if _, ok := grafts[hash]; !ok {
+			grafts[hash] = nil
+		}
+	}
+	commitGraftsCache[gitDir] = grafts
+	return grafts, nil
+}
+
+// Check whether commits may have other parents than they store - shallow commits or grafts
+func hasCommitGrafts() (bool, error) {
+	grafts, err := loadCommitGrafts()
+	return len(grafts) > 0, err
+}
+
+// Add shallow commits to and remove unshallow commits from .git/shallow - file is removed once it is empty
+func updateShallowFile(shallow, unshallow []string) error {
+	existing, err := readShallowCommits()
+	if err != nil {
+		return err
+	}
+
+	commits := make(map[string]bool)
+	for _, hash := range existing {
+		commits[hash] = true
+	}
+	for _, hash := range shallow {
+		commits[hash] = true
+	}
+	for _, hash := range unshallow {
+		delete(commits, hash)
+	}
+
+	delete(commitGraftsCache, resolveRepoLayout().GitDir)
+	shallowPath := gitDirPath("shallow")
+	if len(commits) == 0 {
+		if err := os.Remove(shallowPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove shallow file: %w", err)
+		}
+		return nil
+	}
+
+	sorted := make([]string, 0, len(commits))
+	for hash := range commits {
+		sorted = append(sorted, hash)
+	}
+	sort.Strings(sorted)
+
+	if err := os.WriteFile(shallowPath, []byte(strings.Join(sorted, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write shallow file: %w", err)
+	}
+	return nil
+}
+
+// Parent hashes of commit - grafted parents for grafted commits, and a shallow commit has no (available)
+// parents, so walks stop there
+func readCommitParents(commitHash string) ([]string, error) {
+	grafts, err := loadCommitGrafts()
+	if err != nil {
+		return nil, err
+	}
+	if parents, ok := grafts[commitHash]; ok {
+		return parents, nil
+	}
+
+	objType, _, content, err := readObjectFromHash(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "commit" {
+		return nil, fmt.Errorf("object %s is not a commit", commitHash)
+	}
+
+	var parents []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			break
+		}
+		if parent, ok := strings.CutPrefix(line, "parent "); ok {
+			parents = append(parents, parent)
+		}
+	}
+	return parents, nil
+}
+
+// Load shallow commits as a set
+func loadShallowSet() (map[string]bool, error) {
+	commits, err := readShallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	shallow := make(map[string]bool, len(commits))
+	for _, hash := range commits {
+		shallow[hash] = true
+	}
+	return shallow, nil
+}
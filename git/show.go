@@ -0,0 +1,160 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Show - objects, each as git show writes it:
+//
+//	commit   its log (log options apply - format, decorations, ...) and patch against its parent
+//	tag      "tag <name>", the tagger (as the commit format shows authors) and the message, then the object
+//	         it tags
+//	tree     "tree <name>", an empty line and the names of its entries (directories end with "/")
+//	blob     its content
+//
+// Objects are revisions or <revision>:<path> (the tree or file at path of the commit). Merges get a combined
+// diff unless -m or --first-parent says otherwise - only the combined diffs of merges that took every file
+// from one of their parents are shown (those are empty, only the diffstat against the first parent is
+// written); --stat replaces the patch unless -p asks for both, -s leaves out diffs.
+
+// Write objects selected by options
+func writeShow(options ShowOptions, output io.Writer) error {
+	logOptions := options.Log
+	names := logOptions.Revisions
+	if len(names) == 0 {
+		names = []string{"HEAD"}
+	}
+
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+	out, err := newLogOutput(logOptions, writer)
+	if err != nil {
+		return err
+	}
+	out.patch = !options.NoPatch && (logOptions.Patch || !logOptions.Diff.Stat.enabled())
+	if options.NoPatch {
+		out.diffOptions.Stat = DiffStatOptions{}
+	}
+	if out.merges == "" {
+		out.merges = "combined"
+	}
+	paths := indexPaths(logOptions.Paths)
+
+	for _, name := range names {
+		hash, err := resolveShowObject(name)
+		if err != nil {
+			return err
+		}
+		// Tags are followed to the objects they tag
+		for depth := 0; ; depth++ {
+			if depth == 10 {
+				return fmt.Errorf("tag chain too long: %s", name)
+			}
+			objType, _, content, err := readObjectFromHash(hash)
+			if err != nil {
+				return fmt.Errorf("could not read object %s: %w", hash, err)
+			}
+			switch objType {
+			case "commit":
+				commit, err := parseCommit(content)
+				if err != nil {
+					return fmt.Errorf("bad commit %s: %w", hash, err)
+				}
+				if err := out.writeCommit(hash, commit, paths); err != nil {
+					return err
+				}
+			case "tag":
+				tag, err := parseTag(content)
+				if err != nil {
+					return fmt.Errorf("bad tag %s: %w", hash, err)
+				}
+				out.writeTag(tag)
+				hash = tag.Object
+				continue
+			case "tree":
+				if err := out.writeTree(name, content); err != nil {
+					return err
+				}
+			default:
+				writer.Write(content)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Resolve object name of show - revision (tags are not peeled) or <revision>:<path>
+func resolveShowObject(name string) (string, error) {
+	revision, filePath, ok := strings.Cut(name, ":")
+	if !ok {
+		_, hash, err := resolveRevision(name)
+		return hash, err
+	}
+	if revision == "" {
+		revision = "HEAD"
+	}
+	commitHash, err := resolveCommitRevision(revision)
+	if err != nil {
+		return "", err
+	}
+	treeHash, err := readCommitTreeHash(commitHash)
+	if err != nil {
+		return "", err
+	}
+	entries, err := treeEntriesAtPaths(treeHash, []string{filePath})
+	if err != nil {
+		return "", err
+	}
+	if entries[0] == "" {
+		return "", fmt.Errorf("%w: path '%s' does not exist in '%s'", ErrObjectNotFound, filePath, revision)
+	}
+	_, hash, _ := strings.Cut(entries[0], " ")
+	return hash, nil
+}
+
+// Write tag - its name, the tagger as the commit format shows authors, then the message
+func (out *logOutput) writeTag(tag *Tag) {
+	if out.shown > 0 {
+		out.writer.WriteString("\n")
+	}
+	out.shown++
+	formatter := out.formatter
+	out.writer.WriteString(colorize(formatter.colors, "commit", "tag "+tag.Name) + "\n")
+
+	if name, email, when, err := parseSignature(tag.Tagger); err == nil {
+		switch pretty := formatter.pretty; pretty.name {
+		case "oneline":
+		case "medium":
+			fmt.Fprintf(out.writer, "Tagger: %s <%s>\nDate:   %s\n", name, email, formatDate(when, pretty.date, formatter.now))
+		case "fuller":
+			fmt.Fprintf(out.writer, "Tagger:     %s <%s>\nTaggerDate: %s\n", name, email, formatDate(when, pretty.date, formatter.now))
+		default:
+			fmt.Fprintf(out.writer, "Tagger: %s <%s>\n", name, email)
+		}
+	}
+	out.writer.WriteString("\n" + tag.Message)
+}
+
+// Write tree - its name and the names of its entries
+func (out *logOutput) writeTree(name string, content []byte) error {
+	entries, err := parseTreeContent(content)
+	if err != nil {
+		return err
+	}
+	if out.shown > 0 {
+		out.writer.WriteString("\n")
+	}
+	out.shown++
+	out.writer.WriteString(colorize(out.formatter.colors, "commit", "tree "+name) + "\n\n")
+	for _, entry := range entries {
+		if entry.Mode == "40000" {
+			entry.Name += "/"
+		}
+		out.writer.WriteString(entry.Name + "\n")
+	}
+	return nil
+}
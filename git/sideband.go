@@ -0,0 +1,101 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Upload-pack response (protocol v0):
+//   - shallow-update section ("shallow <hash>" / "unshallow <hash>" lines + flush) - only for shallow requests
+//   - ACK/NAK lines - negotiation result
+//   - pack - multiplexed over side-band-64k if we asked for it, raw otherwise
+//
+// Side-band packets start with channel byte: 1 - pack data, 2 - progress text (printed as it arrives),
+// 3 - fatal error message. Flush packet ends the stream.
+
+// Read v0 response stream - returns pack data and shallow/unshallow commits
+func readUploadPackResponse(reader *bufio.Reader, shallowRequest bool) ([]byte, []string, []string, error) {
+	var shallow, unshallow []string
+
+	if shallowRequest {
+		for {
+			payload, kind, err := readPktPayload(reader)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to read shallow update: %w", err)
+			}
+			if kind == PKT_FLUSH {
+				break
+			}
+			line := strings.TrimSuffix(string(payload), "\n")
+			if hash, ok := strings.CutPrefix(line, "shallow "); ok {
+				shallow = append(shallow, hash)
+			} else if hash, ok := strings.CutPrefix(line, "unshallow "); ok {
+				unshallow = append(unshallow, hash)
+			} else {
+				return nil, nil, nil, fmt.Errorf("unexpected line in shallow update: %s", line)
+			}
+		}
+	}
+
+	// Negotiation ends with NAK (nothing in common) or final ACK
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read acknowledgments: %w", err)
+		}
+		if kind != PKT_DATA {
+			continue
+		}
+		line := strings.TrimSuffix(string(payload), "\n")
+		if line == "NAK" || (strings.HasPrefix(line, "ACK ") && len(strings.Fields(line)) == 2) {
+			break
+		}
+		if message, ok := strings.CutPrefix(line, "ERR "); ok {
+			return nil, nil, nil, fmt.Errorf("remote error: %s", message)
+		}
+	}
+
+	// Server that doesn't support side-band sends raw pack right away
+	if magic, err := reader.Peek(4); err == nil && string(magic) == "PACK" {
+		pack, err := io.ReadAll(reader)
+		return pack, shallow, unshallow, err
+	}
+
+	pack, err := readSideband(reader)
+	return pack, shallow, unshallow, err
+}
+
+// Demultiplex side-band stream until flush - pack data is collected, progress goes to stderr
+func readSideband(reader *bufio.Reader) ([]byte, error) {
+	var pack bytes.Buffer
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err == io.EOF {
+			// Some servers just close the connection after the last packet
+			return pack.Bytes(), nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read side-band: %w", err)
+		}
+		if kind == PKT_FLUSH || kind == PKT_RESPONSE_END {
+			return pack.Bytes(), nil
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		switch payload[0] {
+		case 1:
+			pack.Write(payload[1:])
+		case 2:
+			os.Stderr.Write(payload[1:])
+		case 3:
+			return nil, fmt.Errorf("remote error: %s", strings.TrimSpace(string(payload[1:])))
+		default:
+			return nil, fmt.Errorf("unknown side-band channel %d", payload[0])
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Signing commits and tags - payload (object content without signature) is signed by an external program:
+//   - gpg.format=openpgp (default) - gpg.program (gpg), "--status-fd=2 -bsau <key>"
+//   - gpg.format=x509              - gpg.x509.program (gpgsm), same arguments
+//   - gpg.format=ssh               - gpg.ssh.program (ssh-keygen), "-Y sign -n git -f <key file>"
+//
+// Key is user.signingkey (for ssh a key file, or "key::<public key>"), for gpg the committer identity
+// is used when it is not set. Commit signature goes into "gpgsig" header, tag signature is appended to
+// the tag message.
+
+// Sign payload - returns ASCII armored signature
+func signPayload(payload []byte, keyID string) ([]byte, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		keyID, _ = config.Get("user.signingkey")
+	}
+
+	format, _ := config.Get("gpg.format")
+	switch format {
+	case "", "openpgp", "x509":
+		program := signingProgram(config, format)
+		if keyID == "" {
+			// Committer identity without the date - "Name <email>"
+			identity := userSignature("COMMITTER")
+			keyID = identity[:strings.LastIndexByte(identity, '>')+1]
+		}
+		return runGpgSign(program, keyID, payload)
+	case "ssh":
+		if keyID == "" {
+			return nil, fmt.Errorf("user.signingkey needs to be set for ssh signing")
+		}
+		return runSshSign(signingProgram(config, format), keyID, payload)
+	default:
+		return nil, fmt.Errorf("unsupported gpg.format: %s", format)
+	}
+}
+
+// Program for signature format - gpg.<format>.program config, or the default one
+func signingProgram(config *Config, format string) string {
+	switch format {
+	case "ssh":
+		if program, ok := config.Get("gpg.ssh.program"); ok {
+			return program
+		}
+		return "ssh-keygen"
+	case "x509":
+		if program, ok := config.Get("gpg.x509.program"); ok {
+			return program
+		}
+		return "gpgsm"
+	default:
+		if program, ok := config.Get("gpg.openpgp.program"); ok {
+			return program
+		}
+		if program, ok := config.Get("gpg.program"); ok {
+			return program
+		}
+		return "gpg"
+	}
+}
+
+// Detached armored signature from gpg - SIG_CREATED status line confirms it was really made
+func runGpgSign(program, keyID string, payload []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", keyID)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil || !strings.Contains(stderr.String(), "[GNUPG:] SIG_CREATED ") {
+		return nil, fmt.Errorf("gpg failed to sign the data:\n%s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// SSH signature from ssh-keygen - literal "key::<public key>" is written to a temporary file first
+// (private key is then taken from ssh-agent)
+func runSshSign(program, key string, payload []byte) ([]byte, error) {
+	keyFile := expandConfigPath(key)
+	if literal, ok := strings.CutPrefix(key, "key::"); ok {
+		file, err := os.CreateTemp("", "mini-git-signing-key-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file.Name())
+		file.WriteString(literal + "\n")
+		file.Close()
+		keyFile = file.Name()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "-Y", "sign", "-n", "git", "-f", keyFile)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil || stdout.Len() == 0 {
+		return nil, fmt.Errorf("ssh-keygen failed to sign the data:\n%s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Add gpgsig header to commit content - signature lines are continuation lines (prefixed with space)
+func addCommitSignature(content, signature []byte) []byte {
+	header, message, _ := bytes.Cut(content, []byte("\n\n"))
+
+	var signed bytes.Buffer
+	signed.Write(header)
+	signed.WriteString("\ngpgsig")
+	for _, line := range strings.Split(strings.TrimSuffix(string(signature), "\n"), "\n") {
+		signed.WriteString(" " + line + "\n")
+	}
+	signed.WriteString("\n")
+	signed.Write(message)
+	return signed.Bytes()
+}
+
+// Signature headers that mark start of signature block in tag message
+var signatureMarkers = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN PGP MESSAGE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+}
+
+// Split signed commit into payload (content without gpgsig header) and signature - ok is false for
+// unsigned commits
+func splitCommitSignature(content []byte) ([]byte, []byte, bool) {
+	header, message, _ := bytes.Cut(content, []byte("\n\n"))
+
+	var payload, signature bytes.Buffer
+	inSignature := false
+	for _, line := range strings.Split(string(header), "\n") {
+		switch {
+		case strings.HasPrefix(line, "gpgsig "):
+			inSignature = true
+			signature.WriteString(strings.TrimPrefix(line, "gpgsig ") + "\n")
+		case inSignature && strings.HasPrefix(line, " "):
+			signature.WriteString(line[1:] + "\n")
+		default:
+			inSignature = false
+			payload.WriteString(line + "\n")
+		}
+	}
+	if signature.Len() == 0 {
+		return content, nil, false
+	}
+
+	payload.WriteString("\n")
+	payload.Write(message)
+	return payload.Bytes(), signature.Bytes(), true
+}
+
+// Split signed tag into payload and signature - signature is the trailing block of the message that starts
+// with one of signature markers
+func splitTagSignature(content []byte) ([]byte, []byte, bool) {
+	start := -1
+	for _, marker := range signatureMarkers {
+		index := bytes.LastIndex(content, []byte("\n"+marker))
+		if index >= 0 && index+1 > start {
+			start = index + 1
+		}
+	}
+	if start < 0 {
+		return content, nil, false
+	}
+	return content[:start], content[start:], true
+}
+
+// Verify signature of payload - program is chosen by signature kind, not by gpg.format, so objects
+// signed in any format can be checked
+func verifySignature(payload, signature []byte) (*SignatureCheck, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	sigFile, err := os.CreateTemp("", "mini-git-signature-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write signature: %w", err)
+	}
+	defer os.Remove(sigFile.Name())
+	sigFile.Write(signature)
+	sigFile.Close()
+
+	switch {
+	case bytes.HasPrefix(signature, []byte("-----BEGIN SSH SIGNATURE-----")):
+		return runSshVerify(config, signingProgram(config, "ssh"), sigFile.Name(), payload)
+	case bytes.HasPrefix(signature, []byte("-----BEGIN SIGNED MESSAGE-----")):
+		return runGpgVerify(signingProgram(config, "x509"), sigFile.Name(), payload)
+	default:
+		return runGpgVerify(signingProgram(config, "openpgp"), sigFile.Name(), payload)
+	}
+}
+
+// Verify with gpg/gpgsm - status lines (on stdout) decide the result, stderr is the report for the user
+func runGpgVerify(program, sigFile string, payload []byte) (*SignatureCheck, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "--keyid-format=long", "--status-fd=1", "--verify", sigFile, "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil && stdout.Len() == 0 {
+		return nil, fmt.Errorf("failed to run %s: %w", program, err)
+	}
+
+	check := &SignatureCheck{Status: 'E', Output: stderr.String()}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.SplitN(strings.TrimPrefix(line, "[GNUPG:] "), " ", 3)
+		switch fields[0] {
+		case "GOODSIG":
+			check.Status = 'G'
+			if len(fields) == 3 {
+				check.Signer = fields[2]
+			}
+		case "BADSIG":
+			check.Status = 'B'
+			if len(fields) == 3 {
+				check.Signer = fields[2]
+			}
+		case "TRUST_UNDEFINED", "TRUST_NEVER":
+			if check.Status == 'G' {
+				check.Status = 'U'
+			}
+		}
+	}
+	return check, nil
+}
+
+// Verify with ssh-keygen against gpg.ssh.allowedSignersFile - signer is the principal matching the key;
+// a valid signature from a key that is not in the file is reported as unknown
+func runSshVerify(config *Config, program, sigFile string, payload []byte) (*SignatureCheck, error) {
+	allowedSigners, _ := config.Get("gpg.ssh.allowedSignersFile")
+	allowedSigners = expandConfigPath(allowedSigners)
+	if _, err := os.Stat(allowedSigners); allowedSigners == "" || err != nil {
+		return nil, fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+
+	output, _ := exec.Command(program, "-Y", "find-principals", "-f", allowedSigners, "-s", sigFile).Output()
+	principals := strings.Fields(string(output))
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-Y", "check-novalidate", "-n", "git", "-s", sigFile}
+	if len(principals) > 0 {
+		args = []string{"-Y", "verify", "-n", "git", "-f", allowedSigners, "-I", principals[0], "-s", sigFile}
+	}
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	check := &SignatureCheck{Status: 'B', Output: stdout.String() + stderr.String()}
+	switch {
+	case err != nil:
+		// Bad signature - keep the status
+	case len(principals) == 0:
+		check.Status = 'U'
+		check.Output += "No principal matched.\n"
+	default:
+		check.Status = 'G'
+		check.Signer = principals[0]
+	}
+	return check, nil
+}
+
+// Verify signature of commit or tag object (objType) - returns the signed payload (the whole content when
+// object is not signed, which is an error)
+func verifyObjectSignature(hash, expectedType string) (*SignatureCheck, []byte, error) {
+	objType, _, content, err := readObjectFromHash(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if objType != expectedType {
+		return nil, nil, fmt.Errorf("cannot verify a non-%s object of type %s", expectedType, objType)
+	}
+
+	var payload, signature []byte
+	var signed bool
+	switch objType {
+	case "commit":
+		payload, signature, signed = splitCommitSignature(content)
+	case "tag":
+		payload, signature, signed = splitTagSignature(content)
+	default:
+		return nil, nil, fmt.Errorf("cannot verify %s object", objType)
+	}
+	if !signed {
+		return nil, content, ErrNoSignature
+	}
+
+	check, err := verifySignature(payload, signature)
+	return check, payload, err
+}
@@ -0,0 +1,328 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sparse checkout - only paths matching .git/info/sparse-checkout are in the work tree, index entries of the
+// other paths get the skip-worktree flag (status doesn't compare them with the work tree).
+// Enabled with core.sparseCheckout, core.sparseCheckoutCone selects cone mode, where patterns have a fixed form:
+//
+//	/*          - files in the root directory
+//	!/*/        - but no directories
+//	/a/         - directory a...
+//	!/a/*/      - ...without its subdirectories (a is only a parent of a recursive directory)
+//	/a/b/       - directory a/b with everything below it
+
+// Default patterns - only files in the root directory
+const sparseCheckoutRootPatterns = "/*\n!/*/\n"
+
+func sparseCheckoutFilePath() string {
+	return gitDirPath("info", "sparse-checkout")
+}
+
+// Load sparse checkout patterns - nil when sparse checkout is not enabled
+// Cone mode patterns that aren't in cone form are matched as plain patterns (as git does, with a warning)
+func loadSparseCheckout() (*SparseCheckout, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !config.GetBool("core.sparseCheckout", false) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(sparseCheckoutFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read sparse-checkout file: %w", err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			lines = append(lines, line)
+		}
+	}
+
+	sparse := &SparseCheckout{}
+	if config.GetBool("core.sparseCheckoutCone", false) {
+		if parseConePatterns(sparse, lines) {
+			return sparse, nil
+		}
+		fmt.Fprintf(os.Stderr, "warning: disabling cone pattern matching\n")
+	}
+	for _, line := range lines {
+		if pattern, ok := parseIgnoreLine(line); ok {
+			sparse.Patterns = append(sparse.Patterns, pattern)
+		}
+	}
+	return sparse, nil
+}
+
+// Parse cone mode patterns - returns false if some pattern is not in cone form
+func parseConePatterns(sparse *SparseCheckout, lines []string) bool {
+	sparse.Cone = true
+	sparse.Recursive = make(map[string]bool)
+	sparse.Parents = make(map[string]bool)
+
+	listed := make(map[string]bool)
+	for _, line := range lines {
+		switch {
+		case line == "/*" || line == "!/*/":
+		case strings.HasPrefix(line, "!/") && strings.HasSuffix(line, "/*/"):
+			dir := strings.TrimSuffix(strings.TrimPrefix(line, "!/"), "/*/")
+			if !listed[dir] {
+				return false
+			}
+			sparse.Parents[dir] = true
+		case strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && len(line) > 2:
+			dir := strings.Trim(line, "/")
+			if strings.ContainsAny(dir, "*?[") {
+				return false
+			}
+			listed[dir] = true
+		default:
+			return false
+		}
+	}
+
+	for dir := range listed {
+		if !sparse.Parents[dir] {
+			sparse.Recursive[dir] = true
+		}
+	}
+	return true
+}
+
+// Check whether path (relative to work tree root) is checked out - everything is when sparse is nil
+func (sparse *SparseCheckout) includes(filePath string) bool {
+	if sparse == nil {
+		return true
+	}
+
+	if sparse.Cone {
+		dir := parentDir(filePath)
+		if dir == "" || sparse.Parents[dir] {
+			return true
+		}
+		for ; dir != ""; dir = parentDir(dir) {
+			if sparse.Recursive[dir] {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Last matching pattern wins - a pattern matching a directory covers everything below it
+	for candidate, isDir := filePath, false; candidate != ""; candidate, isDir = parentDir(candidate), true {
+		var matched *IgnorePattern
+		for i := range sparse.Patterns {
+			if sparse.Patterns[i].matches(candidate, isDir) {
+				matched = &sparse.Patterns[i]
+			}
+		}
+		if matched != nil {
+			return !matched.Negate
+		}
+	}
+	return false
+}
+
+// Cone mode patterns for directories - directories below another listed directory are dropped, parents of
+// listed directories only include their direct files
+func conePatterns(dirs []string) (string, error) {
+	recursive := make(map[string]bool)
+	for _, dir := range dirs {
+		dir = strings.Trim(filepath.ToSlash(filepath.Clean(dir)), "/")
+		if dir == "" || dir == "." {
+			continue
+		}
+		if strings.ContainsAny(dir, "*?[\\") || strings.HasPrefix(dir, "../") || dir == ".." {
+			return "", fmt.Errorf("'%s' is not a directory - cone mode only accepts directories", dir)
+		}
+		recursive[dir] = true
+	}
+
+	parents := make(map[string]bool)
+	for dir := range recursive {
+		for parent := parentDir(dir); parent != ""; parent = parentDir(parent) {
+			if recursive[parent] {
+				delete(recursive, dir)
+				break
+			}
+		}
+	}
+	for dir := range recursive {
+		for parent := parentDir(dir); parent != ""; parent = parentDir(parent) {
+			parents[parent] = true
+		}
+	}
+
+	var patterns strings.Builder
+	patterns.WriteString(sparseCheckoutRootPatterns)
+	for _, dir := range sortedKeys(parents) {
+		fmt.Fprintf(&patterns, "/%s/\n!/%s/*/\n", dir, dir)
+	}
+	for _, dir := range sortedKeys(recursive) {
+		fmt.Fprintf(&patterns, "/%s/\n", dir)
+	}
+	return patterns.String(), nil
+}
+
+// Enable sparse checkout in repository config and write patterns (existing patterns are kept when patterns is empty)
+func enableSparseCheckout(cone bool, patterns string) error {
+	configPath := gitDirPath("config")
+	if err := setConfigValue(configPath, "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+	if err := setConfigValue(configPath, "core.sparseCheckoutCone", fmt.Sprintf("%t", cone)); err != nil {
+		return err
+	}
+
+	if patterns == "" {
+		if _, err := os.Stat(sparseCheckoutFilePath()); err == nil {
+			return nil
+		}
+		patterns = sparseCheckoutRootPatterns
+	}
+	if err := os.MkdirAll(gitDirPath("info"), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(sparseCheckoutFilePath(), []byte(patterns), 0644); err != nil {
+		return fmt.Errorf("failed to write sparse-checkout file: %w", err)
+	}
+	return nil
+}
+
+// Whether init/set use cone mode - --cone/--no-cone, then mode of the current sparse checkout, cone by default
+func sparseConeMode(options SparseCheckoutOptions) bool {
+	if options.Cone || options.NoCone {
+		return options.Cone
+	}
+	config, err := loadConfig()
+	if err != nil || !config.GetBool("core.sparseCheckout", false) {
+		return true
+	}
+	return config.GetBool("core.sparseCheckoutCone", true)
+}
+
+// Run sparse-checkout subcommand - patterns of set are directories in cone mode and plain patterns otherwise
+func sparseCheckout(options SparseCheckoutOptions, w io.Writer) error {
+	switch options.Command {
+	case "list":
+		sparse, err := loadSparseCheckout()
+		if err != nil {
+			return err
+		}
+		if sparse == nil {
+			return fmt.Errorf("this worktree is not sparse")
+		}
+		if sparse.Cone {
+			for _, dir := range sortedKeys(sparse.Recursive) {
+				fmt.Fprintln(w, dir)
+			}
+			return nil
+		}
+		data, err := os.ReadFile(sparseCheckoutFilePath())
+		if err != nil {
+			return fmt.Errorf("failed to read sparse-checkout file: %w", err)
+		}
+		fmt.Fprint(w, string(data))
+		return nil
+	case "init":
+		if err := enableSparseCheckout(sparseConeMode(options), ""); err != nil {
+			return err
+		}
+	case "set":
+		cone := sparseConeMode(options)
+		patterns := strings.Join(options.Directories, "\n") + "\n"
+		if cone {
+			var err error
+			if patterns, err = conePatterns(options.Directories); err != nil {
+				return err
+			}
+		}
+		if err := enableSparseCheckout(cone, patterns); err != nil {
+			return err
+		}
+	case "disable":
+		if err := setConfigValue(gitDirPath("config"), "core.sparseCheckout", "false"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown sparse-checkout subcommand: %s", options.Command)
+	}
+	return applySparseCheckout()
+}
+
+// Update work tree and skip-worktree flags to match sparse checkout patterns - newly included files are checked
+// out from the index, excluded files are removed unless they have local changes
+func applySparseCheckout() error {
+	sparse, err := loadSparseCheckout()
+	if err != nil {
+		return err
+	}
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	converter, err := newEolConverter()
+	if err != nil {
+		return err
+	}
+
+	var leftBehind []string
+	for i, entry := range entries {
+		included := sparse.includes(entry.Path)
+		switch {
+		case included && entry.SkipWorktree:
+			if entry.Mode == 0160000 {
+				if err := createWorkTreeDir(entry.Path); err != nil {
+					return err
+				}
+			} else {
+				treeEntry := TreeEntry{Mode: fmt.Sprintf("%o", entry.Mode), Hash: fmt.Sprintf("%x", entry.Hash)}
+				if err := renderBlob(treeEntry, entry.Path, converter); err != nil {
+					return fmt.Errorf("failed to check out %s: %w", entry.Path, err)
+				}
+			}
+			entries[i].SkipWorktree = false
+			entries[i].Stat = workTreeFileStat(entry.Path)
+		case !included && !entry.SkipWorktree && !entry.IntentToAdd:
+			if entry.Mode == 0160000 {
+				// Gitlink directory is only removed when the submodule is not checked out (directory is empty)
+				os.Remove(workTreePath(filepath.FromSlash(entry.Path)))
+			} else {
+				hash, mode, err := hashWorkTreeFile(entry.Path, converter)
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				if err == nil && (!bytes.Equal(hash, entry.Hash) || indexFileMode(mode, entry.Mode) != entry.Mode) {
+					leftBehind = append(leftBehind, entry.Path)
+					continue
+				}
+				if err := os.Remove(workTreePath(filepath.FromSlash(entry.Path))); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+				}
+			}
+			removeEmptyParentDirs(entry.Path)
+			entries[i].SkipWorktree = true
+		}
+	}
+
+	if len(leftBehind) > 0 {
+		sort.Strings(leftBehind)
+		fmt.Fprintf(os.Stderr, "warning: The following paths are not up to date and were left despite sparse patterns:\n")
+		for _, path := range leftBehind {
+			fmt.Fprintf(os.Stderr, "\t%s\n", path)
+		}
+	}
+	return writeGitIndex(entries)
+}
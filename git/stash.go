@@ -0,0 +1,370 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// Stash - local changes (the index and tracked files of the work tree) are saved as commits and the work tree
+// goes back to HEAD. An entry is a commit of the work tree whose parents are HEAD and a commit of the index:
+//
+//	     .----W    WIP on <branch>: <short hash> <subject>   ("On <branch>: <message>" with -m)
+//	    /    /
+//	HEAD----I      index on <branch>: <short hash> <subject>
+//
+// refs/stash points to the latest entry and its reflog keeps the whole stack - stash@{0} is the latest
+// entry, stash@{1} the one before. Applying an entry merges the changes it made to its HEAD into the work
+// tree (three-way merge with that HEAD as base and the index as ours); the index keeps what it had, only new
+// files are added to it - with --index, the changes staged in the entry are restored in the index too.
+// Conflicts leave the entry on the stack.
+
+// Ref pointing to the latest stash entry
+const stashRef = "refs/stash"
+
+// Save local changes as a new stash entry and reset index and work tree to HEAD - message replaces the
+// default description
+func pushStash(message string, w io.Writer) error {
+	if err := requireMergedIndex("Stashing"); err != nil {
+		return err
+	}
+	branch, head, err := readHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+	headCommit, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+
+	indexTree, err := writeTreeFromIndex()
+	if err != nil {
+		return err
+	}
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	files, err := workTreeFiles(entries)
+	if err != nil {
+		return err
+	}
+	// Changed work tree files are only hashed so far - the entry keeps them
+	for _, entry := range files {
+		if content, ok := workTreeBlobs[entry.Hash]; ok {
+			if _, err := writeObject(generateObjectByte("blob", content)); err != nil {
+				return fmt.Errorf("failed to write %s: %w", entry.Name, err)
+			}
+		}
+	}
+	workTree, err := writeTreeFromFiles(files)
+	if err != nil {
+		return err
+	}
+	if indexTree == headCommit.Tree && workTree == headCommit.Tree {
+		fmt.Fprintln(w, "No local changes to save")
+		return nil
+	}
+
+	branchName := "(no branch)"
+	if branch != "" {
+		branchName = strings.TrimPrefix(branch, "refs/heads/")
+	}
+	subject, _ := splitCommitMessage(headCommit.Message)
+	description := fmt.Sprintf("%s: %s %s", branchName, shortHash(head), subject)
+	indexCommit, err := writeStashCommit(indexTree, []string{head}, "index on "+description)
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		message = "WIP on " + description
+	} else {
+		message = "On " + branchName + ": " + message
+	}
+	stash, err := writeStashCommit(workTree, []string{head, indexCommit}, message)
+	if err != nil {
+		return err
+	}
+
+	previous, err := resolveRef(stashRef)
+	if err != nil {
+		return err
+	}
+	if err := updateRef(stashRef, stash, ""); err != nil {
+		return err
+	}
+	if err := appendReflog(stashRef, previous, stash, message); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Saved working directory and index state %s\n", message)
+	return resetWorkTreeToCommit(head)
+}
+
+// Write commit of stash entry - never signed, unlike commits of commit
+func writeStashCommit(treeHash string, parents []string, message string) (string, error) {
+	content := buildCommitContent(treeHash, parents, userSignature("AUTHOR"), userSignature("COMMITTER"), message+"\n")
+	hash, err := writeObject(generateObjectByte("commit", content))
+	if err != nil {
+		return "", fmt.Errorf("failed to write stash commit: %w", err)
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// Write stash entries, the latest first - "stash@{<n>}: <description>"
+func listStash(w io.Writer) error {
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		fmt.Fprintf(w, "stash@{%d}: %s\n", i, entries[len(entries)-1-i].Message)
+	}
+	return nil
+}
+
+// Write changes of stash entry against the HEAD it was made on - a diffstat unless options.Diff asks for
+// the patch
+func showStash(options StashOptions, w io.Writer) error {
+	hash, _, _, err := resolveStash(options.Stash)
+	if err != nil {
+		return err
+	}
+	stash, err := readStashCommit(hash)
+	if err != nil {
+		return err
+	}
+	diff := options.Diff
+	diff.Revisions = []string{stash.Parents[0], hash}
+	if !diff.Patch && diff.Format == "" && !diff.Diff.Stat.enabled() {
+		diff.Diff.Stat.Stat = true
+	}
+	return writeDiff(diff, w)
+}
+
+// Apply stash entry named name to the work tree (and its staged changes to the index with restoreIndex), then
+// write the status - returns whether the merge stopped with conflicts
+func applyStash(name string, restoreIndex bool, w io.Writer) (bool, error) {
+	hash, _, _, err := resolveStash(name)
+	if err != nil {
+		return false, err
+	}
+	if err := requireMergedIndex("Applying a stash"); err != nil {
+		return false, err
+	}
+	stash, err := readStashCommit(hash)
+	if err != nil {
+		return false, err
+	}
+	baseFiles, err := commitFiles(stash.Parents[0])
+	if err != nil {
+		return false, err
+	}
+	stashFiles, err := commitFiles(hash)
+	if err != nil {
+		return false, err
+	}
+	indexTree, err := writeTreeFromIndex()
+	if err != nil {
+		return false, err
+	}
+	oursFiles := make(map[string]TreeEntry)
+	if err := flattenTree(indexTree, "", oursFiles); err != nil {
+		return false, err
+	}
+
+	settings, err := resolveMergeSettings(nil, "Stashed changes")
+	if err != nil {
+		return false, err
+	}
+	settings.OursLabel, settings.BaseLabel = "Updated upstream", "Stash base"
+	indexFiles := maps.Clone(oursFiles)
+	if restoreIndex {
+		stagedFiles, err := commitFiles(stash.Parents[1])
+		if err != nil {
+			return false, err
+		}
+		staged, err := mergeFileSets(baseFiles, oursFiles, stagedFiles, settings)
+		if err != nil {
+			return false, err
+		}
+		if len(staged.Conflicts) > 0 {
+			return false, fmt.Errorf("conflicts in index - try without --index")
+		}
+		indexFiles = staged.Files
+	}
+	result, err := mergeFileSets(baseFiles, oursFiles, stashFiles, settings)
+	if err != nil {
+		return false, err
+	}
+
+	conflicts := len(result.Conflicts) > 0
+	if conflicts {
+		for _, message := range result.Messages {
+			fmt.Fprintln(w, message.Text)
+		}
+		if _, err := checkoutMergeResult(oursFiles, result, "merge", w); err != nil {
+			return false, err
+		}
+		if restoreIndex {
+			fmt.Fprintln(w, "Index was not unstashed.")
+		}
+	} else {
+		// Files the entry added are tracked again - other changes stay unstaged
+		for filePath, entry := range result.Files {
+			if _, ok := oursFiles[filePath]; !ok {
+				if _, ok := indexFiles[filePath]; !ok {
+					indexFiles[filePath] = entry
+				}
+			}
+		}
+		if err := checkoutFiles(oursFiles, result.Files, indexFiles, "merge"); err != nil {
+			return false, err
+		}
+	}
+
+	status, err := computeStatus(false)
+	if err != nil {
+		return conflicts, err
+	}
+	return conflicts, printStatus(status, StatusOptions{}, w)
+}
+
+// Apply stash entry and drop it - it is kept when the merge stopped with conflicts
+func popStash(name string, restoreIndex bool, w io.Writer) error {
+	conflicts, err := applyStash(name, restoreIndex, w)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return fmt.Errorf("conflicts in the work tree - the stash entry is kept in case you need it again")
+	}
+	return dropStash(name, w)
+}
+
+// Remove stash entry from the stack - refs/stash moves to the latest remaining one (and is deleted with
+// the last one)
+func dropStash(name string, w io.Writer) error {
+	hash, position, label, err := resolveStash(name)
+	if err != nil {
+		return err
+	}
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	index := len(entries) - 1 - position
+	// Entry that came after the dropped one now follows the one before it
+	if index+1 < len(entries) {
+		entries[index+1].Old = entries[index].Old
+	}
+	entries = append(entries[:index], entries[index+1:]...)
+	if err := writeReflog(stashRef, entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		err = deleteRef(stashRef)
+	} else {
+		err = updateRef(stashRef, entries[len(entries)-1].New, "")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Dropped %s (%s)\n", label, hash)
+	return nil
+}
+
+// Remove all stash entries
+func clearStash() error {
+	if err := writeReflog(stashRef, nil); err != nil {
+		return err
+	}
+	return deleteRef(stashRef)
+}
+
+// Create branch at the commit stash entry was made on, check it out and pop the entry there (with its index)
+func stashBranch(branch, name string, w io.Writer) error {
+	if err := CheckBranchName(branch); err != nil {
+		return err
+	}
+	if existing, err := resolveRef("refs/heads/" + branch); err != nil {
+		return err
+	} else if existing != "" {
+		return fmt.Errorf("a branch named '%s' already exists", branch)
+	}
+	hash, _, _, err := resolveStash(name)
+	if err != nil {
+		return err
+	}
+	stash, err := readStashCommit(hash)
+	if err != nil {
+		return err
+	}
+
+	headFiles, err := readHeadFiles()
+	if err != nil {
+		return err
+	}
+	baseFiles, err := commitFiles(stash.Parents[0])
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(headFiles, baseFiles, baseFiles, "checkout"); err != nil {
+		return err
+	}
+	if err := updateRef("refs/heads/"+branch, stash.Parents[0], "branch: Created from "+shortHash(stash.Parents[0])); err != nil {
+		return err
+	}
+	if err := writeSymbolicRef("HEAD", "refs/heads/"+branch); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Switched to a new branch '%s'\n", branch)
+	return popStash(name, true, w)
+}
+
+// Resolve stash entry name - stash@{<n>}, refs/stash@{<n>} or <n>, the latest entry when empty. Returns the
+// entry's commit, its position on the stack and its name as it is reported.
+func resolveStash(name string) (string, int, string, error) {
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return "", 0, "", err
+	}
+	if len(entries) == 0 {
+		return "", 0, "", fmt.Errorf("No stash entries found.")
+	}
+
+	label, selector := name, name
+	switch {
+	case name == "":
+		label, selector = stashRef+"@{0}", "0"
+	case strings.HasPrefix(name, "stash@{") || strings.HasPrefix(name, stashRef+"@{"):
+		_, selector, _ = strings.Cut(strings.TrimSuffix(name, "}"), "@{")
+	default:
+		label = "stash@{" + name + "}"
+	}
+	position, err := strconv.Atoi(selector)
+	if err != nil || position < 0 {
+		return "", 0, "", fmt.Errorf("'%s' is not a stash-like commit", name)
+	}
+	if position >= len(entries) {
+		return "", 0, "", fmt.Errorf("%s is not a valid reference", label)
+	}
+	return entries[len(entries)-1-position].New, position, label, nil
+}
+
+// Read commit of stash entry - it has HEAD and the index commit as parents
+func readStashCommit(hash string) (*Commit, error) {
+	stash, err := readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(stash.Parents) < 2 {
+		return nil, fmt.Errorf("'%s' is not a stash-like commit", shortHash(hash))
+	}
+	return stash, nil
+}
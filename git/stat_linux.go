@@ -0,0 +1,32 @@
+//go:build linux
+
+package git
+
+import (
+	"os"
+	"syscall"
+)
+
+// Index stat data of lstat result
+func indexStatFromFileInfo(info os.FileInfo) IndexStat {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return indexStatFromModTime(info)
+	}
+	return IndexStat{
+		CTimeSeconds:     uint32(stat.Ctim.Sec),
+		CTimeNanoseconds: uint32(stat.Ctim.Nsec),
+		MTimeSeconds:     uint32(stat.Mtim.Sec),
+		MTimeNanoseconds: uint32(stat.Mtim.Nsec),
+		Dev:              uint32(stat.Dev),
+		Ino:              uint32(stat.Ino),
+		UID:              stat.Uid,
+		GID:              stat.Gid,
+		Size:             uint32(stat.Size),
+	}
+}
+
+// Kernel name, as uname reports it
+func systemName() string {
+	return "Linux"
+}
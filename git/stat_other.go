@@ -0,0 +1,30 @@
+//go:build !linux
+
+package git
+
+import (
+	"os"
+	"runtime"
+)
+
+// Index stat data of lstat result - only modification time and size are portable
+func indexStatFromFileInfo(info os.FileInfo) IndexStat {
+	return indexStatFromModTime(info)
+}
+
+// Kernel name, as uname reports it - derived from the target system name
+func systemName() string {
+	switch runtime.GOOS {
+	case "darwin", "ios":
+		return "Darwin"
+	case "freebsd":
+		return "FreeBSD"
+	case "netbsd":
+		return "NetBSD"
+	case "openbsd":
+		return "OpenBSD"
+	case "windows":
+		return "Windows"
+	}
+	return runtime.GOOS
+}
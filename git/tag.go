@@ -0,0 +1,170 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Tags - lightweight tag is just refs/tags/<name> pointing to an object, annotated tag points to a
+// tag object (tagger, message and optional signature) that points to the object. A tag object may point to
+// another tag - peeling follows the chain to the object at its end (<rev>^{} or <rev>^{<type>}).
+
+// Create tag - annotated when message, -a or -s is given (or tag.gpgSign is set for annotated tags)
+func createTag(options TagOptions) error {
+	refName := "refs/tags/" + options.Name
+	if existing, err := resolveRef(refName); err != nil {
+		return err
+	} else if existing != "" && !options.Force {
+		return fmt.Errorf("tag '%s' already exists", options.Name)
+	}
+
+	target := options.Target
+	if target == "" {
+		target = "HEAD"
+	}
+	_, targetHash, err := resolveRevision(target)
+	if err != nil {
+		return err
+	}
+
+	annotate := options.Annotate || options.Sign || options.Message != ""
+	if !annotate {
+		return updateRef(refName, targetHash, "tag: tagging "+targetHash)
+	}
+	if options.Message == "" {
+		return fmt.Errorf("annotated tag needs a message (-m)")
+	}
+
+	targetType, _, _, err := readObjectFromHash(targetHash)
+	if err != nil {
+		return err
+	}
+	message := options.Message
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	content := []byte(fmt.Sprintf("object %s\ntype %s\ntag %s\ntagger %s\n\n%s", targetHash, targetType, options.Name, userSignature("COMMITTER"), message))
+
+	sign := options.Sign
+	if config, err := loadConfig(); err == nil && !sign {
+		sign = config.GetBool("tag.gpgsign", false)
+	}
+	if sign {
+		// Tag signature is simply appended to the tag content
+		signature, err := signPayload(content, options.SigningKey)
+		if err != nil {
+			return err
+		}
+		content = append(content, signature...)
+	}
+
+	hash, err := writeObject(generateObjectByte("tag", content))
+	if err != nil {
+		return fmt.Errorf("failed to write tag: %w", err)
+	}
+	return updateRef(refName, fmt.Sprintf("%x", hash), "tag: tagging "+targetHash)
+}
+
+// Delete tags, writing the value each had - missing tags are reported after the others are deleted
+func deleteTags(names []string, w io.Writer) error {
+	var missing []string
+	for _, name := range names {
+		refName := "refs/tags/" + name
+		hash, err := resolveRef(refName)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			missing = append(missing, name)
+			continue
+		}
+		if err := deleteRef(refName); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Deleted tag '%s' (was %s)\n", name, shortHash(hash))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tag '%s' not found", strings.Join(missing, "', '"))
+	}
+	return nil
+}
+
+// Names of tags matching any of patterns (all tags when there are none), sorted - with contains, only tags
+// whose commit has that commit in its history
+func listTags(patterns []string, contains string) ([]string, error) {
+	refs, err := listRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	containsHash := ""
+	if contains != "" {
+		if containsHash, err = resolveCommitRevision(contains); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, refName := range sortedKeys(refs) {
+		name := strings.TrimPrefix(refName, "refs/tags/")
+		if len(patterns) > 0 && !slices.ContainsFunc(patterns, func(pattern string) bool { return matchRefPattern(pattern, name) }) {
+			continue
+		}
+		if containsHash != "" {
+			// Tags of trees and blobs contain no commits
+			commit, err := peelObject(refs[refName], "commit")
+			if err != nil {
+				continue
+			}
+			if ok, err := isAncestor(containsHash, commit); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Match ref name against shell glob pattern - unlike in gitignore patterns, * and ? match / too
+func matchRefPattern(pattern, name string) bool {
+	// Slashes are hidden from wildmatch - they are matched as any other character then
+	return wildmatch(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(name, "/", "\x00"))
+}
+
+// Peel object to objType - tags are followed to the objects they point to, commits give their trees when
+// a tree is asked for. Empty objType peels to the first object that is not a tag.
+func peelObject(hash, objType string) (string, error) {
+	switch objType {
+	case "", "commit", "tree", "blob", "tag":
+	default:
+		return "", fmt.Errorf("unknown object type %s", objType)
+	}
+	for depth := 0; depth <= 10; depth++ {
+		actualType, _, content, err := readObjectFromHash(hash)
+		if err != nil {
+			return "", fmt.Errorf("could not read object %s: %w", hash, err)
+		}
+		switch {
+		case actualType == objType || (objType == "" && actualType != "tag"):
+			return hash, nil
+		case actualType == "tag":
+			tag, err := parseTag(content)
+			if err != nil {
+				return "", fmt.Errorf("bad tag %s: %w", hash, err)
+			}
+			hash = tag.Object
+		case actualType == "commit" && objType == "tree":
+			commit, err := parseCommit(content)
+			if err != nil {
+				return "", fmt.Errorf("bad commit %s: %w", hash, err)
+			}
+			hash = commit.Tree
+		default:
+			return "", fmt.Errorf("%s is a %s, not a %s", shortHash(hash), actualType, objType)
+		}
+	}
+	return "", fmt.Errorf("tag chain too long")
+}
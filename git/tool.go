@@ -0,0 +1,390 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// difftool and mergetool - external programs show changes and resolve conflicts. A tool is a shell command
+// that finds its files in environment variables:
+//
+//	LOCAL    old side (difftool) or our version (mergetool)
+//	REMOTE   new side (difftool) or their version (mergetool)
+//	BASE     merge base version (mergetool)
+//	MERGED   path of the file in the work tree - mergetool's result is expected there
+//
+// The command is difftool.<tool>.cmd or mergetool.<tool>.cmd; the tools in builtinDiffTools and
+// builtinMergeTools work without one. The tool is picked with --tool, else diff.tool (difftool only) or
+// merge.tool.
+//
+// difftool exports the old and new blobs to a temporary directory - a work tree side is passed as the file
+// itself, so edits made in the tool are kept. mergetool writes the stages of a conflicted path next to it
+// (<name>_BASE_<pid><ext>, _LOCAL_ and _REMOTE_), keeps the file with conflict markers as <path>.orig
+// (unless mergetool.keepBackup is off) and adds the path once the tool succeeds - by its exit code with
+// mergetool.<tool>.trustExitCode, otherwise by having changed the file (or the user saying so).
+
+// Commands of well-known diff tools
+var builtinDiffTools = map[string]string{
+	"meld":    `meld "$LOCAL" "$REMOTE"`,
+	"kdiff3":  `kdiff3 --L1 "$MERGED (A)" --L2 "$MERGED (B)" "$LOCAL" "$REMOTE"`,
+	"vscode":  `code --wait --diff "$LOCAL" "$REMOTE"`,
+	"vimdiff": `vim -R -f -d "$LOCAL" "$REMOTE"`,
+}
+
+// Commands of well-known merge tools
+var builtinMergeTools = map[string]string{
+	"meld":    `meld --output="$MERGED" "$LOCAL" "$BASE" "$REMOTE"`,
+	"kdiff3":  `kdiff3 --auto --L1 "$MERGED (Base)" --L2 "$MERGED (Local)" --L3 "$MERGED (Remote)" -o "$MERGED" "$BASE" "$LOCAL" "$REMOTE"`,
+	"vscode":  `code --wait --merge "$REMOTE" "$LOCAL" "$BASE" "$MERGED"`,
+	"vimdiff": `vim -f -d -c 'wincmd J' "$MERGED" "$LOCAL" "$BASE" "$REMOTE"`,
+}
+
+// Name and command of the tool of kind "diff" or "merge" - tool is the --tool value ("" for configured one)
+func toolCommand(kind, tool string) (string, string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", "", err
+	}
+	if tool == "" {
+		keys := []string{kind + ".tool"}
+		if kind == "diff" {
+			keys = append(keys, "merge.tool")
+		}
+		for _, key := range keys {
+			if value, ok := config.Get(key); ok && value != "" {
+				tool = value
+				break
+			}
+		}
+	}
+	if tool == "" {
+		return "", "", fmt.Errorf("no %stool configured - set %s.tool or use --tool=<tool>", kind, kind)
+	}
+
+	if command, ok := config.Get(kind + "tool." + tool + ".cmd"); ok {
+		return tool, command, nil
+	}
+	builtin := builtinDiffTools
+	if kind == "merge" {
+		builtin = builtinMergeTools
+	}
+	if command, ok := builtin[tool]; ok {
+		return tool, command, nil
+	}
+	return "", "", fmt.Errorf("unknown %stool '%s' - set %stool.%s.cmd", kind, tool, kind, tool)
+}
+
+// Run tool command in the work tree root with its files in env - returns whether it exited with success,
+// error only when it could not be run
+func runTool(command string, env map[string]string) (bool, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = workTreePath()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = hookEnvironment()
+	for _, name := range sortedKeys(env) {
+		cmd.Env = append(cmd.Env, name+"="+env[name])
+	}
+	traceRunCommand(cmd)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to run '%s': %w", command, err)
+	}
+	return true, nil
+}
+
+// Ask question and read the answer line (lowercased, without spaces) - "" on end of input
+func askUser(input *bufio.Reader, output io.Writer, question string) string {
+	fmt.Fprint(output, question)
+	answer, _ := input.ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(answer))
+}
+
+// Show changes selected by options.Diff in the diff tool, one file at a time
+func runDifftool(options DifftoolOptions, w io.Writer) error {
+	tool, command, err := toolCommand("diff", options.Tool)
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	prompt := !options.NoPrompt && config.GetBool("difftool.prompt", true)
+
+	diffOptions, err := resolveDiffOptions(options.Diff.Diff)
+	if err != nil {
+		return err
+	}
+	oldFiles, newFiles, err := diffSides(options.Diff)
+	if err != nil {
+		return err
+	}
+	if len(options.Diff.Paths) > 0 {
+		paths := indexPaths(options.Diff.Paths)
+		oldFiles = filterPaths(oldFiles, paths)
+		newFiles = filterPaths(newFiles, paths)
+	}
+	changes, err := diffFileSets(oldFiles, newFiles, diffOptions)
+	if err != nil {
+		return err
+	}
+	// New side is the work tree unless commits or the index are compared
+	revisions := options.Diff.Revisions
+	workTree := !options.Diff.Cached && (len(revisions) == 0 || (len(revisions) == 1 && !strings.Contains(revisions[0], "..")))
+
+	dir, err := os.MkdirTemp("", "git-difftool-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := bufio.NewReader(os.Stdin)
+	for i, change := range changes {
+		if prompt {
+			question := fmt.Sprintf("\nViewing (%d/%d): '%s'\nLaunch '%s' [Y/n]? ", i+1, len(changes), change.NewPath, tool)
+			if strings.HasPrefix(askUser(input, w, question), "n") {
+				continue
+			}
+		}
+
+		local, err := exportDiffSide(filepath.Join(dir, "left"), change.OldPath, change.OldHash, change.OldMode)
+		if err != nil {
+			return err
+		}
+		remote := workTreePath(filepath.FromSlash(change.NewPath))
+		if !workTree || change.NewHash == "" || change.NewMode == "160000" {
+			if remote, err = exportDiffSide(filepath.Join(dir, "right"), change.NewPath, change.NewHash, change.NewMode); err != nil {
+				return err
+			}
+		}
+		env := map[string]string{"LOCAL": local, "REMOTE": remote, "MERGED": change.NewPath, "BASE": change.NewPath}
+		if _, err := runTool(command, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write one side of a change below dir - returns its path (the null device for a missing side)
+func exportDiffSide(dir, filePath, hash, mode string) (string, error) {
+	if hash == "" {
+		return os.DevNull, nil
+	}
+	content, err := diffBlobContent(hash, mode)
+	if err != nil {
+		return "", err
+	}
+	fullPath := filepath.Join(dir, filepath.FromSlash(filePath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(fullPath, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", fullPath, err)
+	}
+	return fullPath, nil
+}
+
+// Resolve conflicted paths (all of them, or those at or below options.Paths) with the merge tool
+func runMergetool(options MergetoolOptions, w io.Writer) error {
+	name, command, err := toolCommand("merge", options.Tool)
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	entries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	unmerged := unmergedPaths(entries)
+	selected := sortedKeys(unmerged)
+	if len(options.Paths) > 0 {
+		files := make(map[string]TreeEntry, len(unmerged))
+		for filePath := range unmerged {
+			files[filePath] = TreeEntry{}
+		}
+		selected = sortedKeys(filterPaths(files, indexPaths(options.Paths)))
+	}
+	if len(selected) == 0 {
+		fmt.Fprintf(w, "No files need merging\n")
+		return nil
+	}
+	fmt.Fprintf(w, "Merging:\n%s\n", strings.Join(selected, "\n"))
+
+	tool := &MergeTool{
+		Name:          name,
+		Command:       command,
+		TrustExitCode: config.GetBool("mergetool."+name+".trustExitCode", false),
+		KeepBackup:    config.GetBool("mergetool.keepBackup", true),
+		Prompt:        !options.NoPrompt && (options.Prompt || config.GetBool("mergetool.prompt", false)),
+		input:         bufio.NewReader(os.Stdin),
+		output:        w,
+	}
+	var failed []string
+	for _, filePath := range selected {
+		resolved, err := tool.resolve(filePath, unmerged[filePath])
+		if err != nil {
+			return err
+		}
+		if !resolved {
+			fmt.Fprintf(w, "merge of %s failed\n", filePath)
+			failed = append(failed, filePath)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d conflicted paths were not resolved", len(failed), len(selected))
+	}
+	return nil
+}
+
+// Resolve one conflicted path - returns whether it was resolved (and added)
+func (tool *MergeTool) resolve(filePath string, stages []IndexEntry) (bool, error) {
+	var sides [3]*IndexEntry
+	for i := range stages {
+		sides[stages[i].Stage-1] = &stages[i]
+	}
+	base, local, remote := sides[0], sides[1], sides[2]
+
+	if local == nil || remote == nil {
+		return tool.resolveDeleted(filePath, base, local, remote)
+	}
+	fmt.Fprintf(tool.output, "\nNormal merge conflict for '%s':\n  {local}: %s\n  {remote}: %s\n", filePath,
+		describeStage(base, local), describeStage(base, remote))
+	if tool.Prompt {
+		askUser(tool.input, tool.output, fmt.Sprintf("Hit return to start merge resolution tool (%s): ", tool.Name))
+	}
+
+	// Stage files are written next to the path, named like git names them
+	ext := path.Ext(path.Base(filePath))
+	stem := strings.TrimSuffix(filePath, ext)
+	env := map[string]string{"MERGED": filePath}
+	for i, label := range []string{"BASE", "LOCAL", "REMOTE"} {
+		stagePath := fmt.Sprintf("%s_%s_%d%s", stem, label, os.Getpid(), ext)
+		env[label] = stagePath
+		defer os.Remove(workTreePath(filepath.FromSlash(stagePath)))
+		if err := writeStageFile(stagePath, sides[i]); err != nil {
+			return false, err
+		}
+	}
+
+	mergedPath := workTreePath(filepath.FromSlash(filePath))
+	before, err := os.ReadFile(mergedPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	backupPath := mergedPath + ".orig"
+	if err := os.WriteFile(backupPath, before, 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", backupPath, err)
+	}
+
+	succeeded, err := tool.run(filePath, env, before)
+	if err != nil {
+		return false, err
+	}
+	if !succeeded {
+		// Failed merge leaves the file as it was
+		return false, os.Rename(backupPath, mergedPath)
+	}
+	if err := addPaths([]string{filePath}, false); err != nil {
+		return false, err
+	}
+	if !tool.KeepBackup {
+		os.Remove(backupPath)
+	}
+	return true, nil
+}
+
+// Run the tool on a conflicted file with content before - success is its exit code when it is trusted,
+// otherwise whether the file changed (or the user says the merge worked)
+func (tool *MergeTool) run(filePath string, env map[string]string, before []byte) (bool, error) {
+	succeeded, err := runTool(tool.Command, env)
+	if err != nil || tool.TrustExitCode {
+		return succeeded, err
+	}
+	after, err := os.ReadFile(workTreePath(filepath.FromSlash(filePath)))
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if !bytes.Equal(before, after) {
+		return true, nil
+	}
+	answer := askUser(tool.input, tool.output, fmt.Sprintf("%s seems unchanged.\nWas the merge successful [y/n]? ", filePath))
+	return strings.HasPrefix(answer, "y"), nil
+}
+
+// Resolve conflict of a path deleted on one side (or both) - the user keeps the modified file or the
+// deletion, or aborts mergetool
+func (tool *MergeTool) resolveDeleted(filePath string, base, local, remote *IndexEntry) (bool, error) {
+	kept := local
+	if kept == nil {
+		kept = remote
+	}
+	fmt.Fprintf(tool.output, "\nDeleted merge conflict for '%s':\n  {local}: %s\n  {remote}: %s\n", filePath,
+		describeStage(base, local), describeStage(base, remote))
+
+	choice := "d"
+	if kept != nil {
+		choice = askUser(tool.input, tool.output, "Use (m)odified or (d)eleted file, or (a)bort? ")
+	}
+	fullPath := workTreePath(filepath.FromSlash(filePath))
+	switch {
+	case strings.HasPrefix(choice, "m"):
+		if _, err := os.Lstat(fullPath); os.IsNotExist(err) {
+			if err := writeStageFile(filePath, kept); err != nil {
+				return false, err
+			}
+		}
+	case strings.HasPrefix(choice, "d"):
+		if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	case strings.HasPrefix(choice, "a"):
+		return false, fmt.Errorf("merge of %s aborted", filePath)
+	default:
+		return false, nil
+	}
+	return true, addPaths([]string{filePath}, false)
+}
+
+// How a side of a conflict changed the path - as mergetool describes {local} and {remote}
+func describeStage(base, side *IndexEntry) string {
+	switch {
+	case side == nil:
+		return "deleted"
+	case side.Mode == 0120000:
+		return "a symbolic link"
+	case base == nil:
+		return "created file"
+	default:
+		return "modified file"
+	}
+}
+
+// Check out stage entry to relPath (an empty file when the stage is missing)
+func writeStageFile(relPath string, stage *IndexEntry) error {
+	if stage == nil {
+		return writeWorkTreeFile(relPath, "100644", nil, nil)
+	}
+	converter, err := newEolConverter()
+	if err != nil {
+		return err
+	}
+	entry := TreeEntry{Mode: fmt.Sprintf("%o", stage.Mode), Name: path.Base(relPath), Hash: fmt.Sprintf("%x", stage.Hash)}
+	return renderBlob(entry, relPath, converter)
+}
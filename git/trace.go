@@ -0,0 +1,221 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tracing, like git's GIT_TRACE* variables - every one of them is off by default and is turned on with:
+//   - 1, 2 or true    - write to stderr
+//   - 3 ... 9         - write to that (already open) file descriptor
+//   - /absolute/path  - append to the file
+//
+// Variables:
+//   - GIT_TRACE             - commands being run (built-in command, child processes like upload-pack or ssh)
+//   - GIT_TRACE_PACKET      - pkt-lines sent to and received from the other side ("clone> want ...",
+//     "upload-pack< want ..."); pack data is shown as "PACK ..." and nothing after it is traced
+//   - GIT_TRACE_PERFORMANCE - time spent in each phase of the command (refs discovery, pack download,
+//     checkout, ...) and in the whole command
+//
+// Both sides of a local clone write to the same destination (upload-pack inherits the environment), so
+// the negotiation can be followed from one trace.
+
+// Trace destinations by variable name - opened once per process (nil when tracing is off)
+var traceWriters = make(map[string]io.Writer)
+
+// Running command - its name is shown in packet traces, start is used for the whole command timing
+var traceCommandArgs []string
+var traceIdentity = "git"
+var traceCommandStart = time.Now()
+
+// Destination for trace variable, nil when it's not enabled
+func traceWriter(key string) io.Writer {
+	if w, ok := traceWriters[key]; ok {
+		return w
+	}
+	w := openTraceWriter(key)
+	traceWriters[key] = w
+	return w
+}
+
+func openTraceWriter(key string) io.Writer {
+	value := os.Getenv(key)
+	switch strings.ToLower(value) {
+	case "", "0", "false", "no", "off":
+		return nil
+	case "1", "2", "true", "yes", "on":
+		return os.Stderr
+	}
+
+	if fd, err := strconv.Atoi(value); err == nil && fd >= 3 && fd <= 9 {
+		return os.NewFile(uintptr(fd), key)
+	}
+	if filepath.IsAbs(value) {
+		file, err := os.OpenFile(value, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not open '%s' for tracing: %v\n", value, err)
+			return nil
+		}
+		return file
+	}
+	fmt.Fprintf(os.Stderr, "warning: unknown trace value for '%s': %s\n", key, value)
+	return nil
+}
+
+// Write one trace line, prefixed with time of day - line is written at once, so lines of processes
+// sharing the destination don't get mixed
+func tracef(key, format string, args ...any) {
+	w := traceWriter(key)
+	if w == nil {
+		return
+	}
+	line := time.Now().Format("15:04:05.000000") + " " + fmt.Sprintf(format, args...) + "\n"
+	io.WriteString(w, line)
+}
+
+// Log command about to run (GIT_TRACE) - TraceCommandEnd logs how long it took
+func TraceCommand(args []string) {
+	traceCommandArgs = args
+	traceIdentity = args[0]
+	traceCommandStart = time.Now()
+	tracef("GIT_TRACE", "trace: built-in: %s", quoteTraceArgs(append([]string{"git"}, args...)))
+}
+
+// Log time of the whole command (GIT_TRACE_PERFORMANCE) - call just before exiting
+func TraceCommandEnd() {
+	tracef("GIT_TRACE_PERFORMANCE", "performance: %.9f s: git command: %s",
+		time.Since(traceCommandStart).Seconds(), quoteTraceArgs(append([]string{"git"}, traceCommandArgs...)))
+}
+
+// Log child process about to start (GIT_TRACE)
+func traceRunCommand(cmd *exec.Cmd) {
+	tracef("GIT_TRACE", "trace: run_command: %s", quoteTraceArgs(cmd.Args))
+}
+
+// Start timing a phase of the command - returned function logs its duration (GIT_TRACE_PERFORMANCE),
+// so it's used as: defer tracePerformance("phase")()
+func tracePerformance(phase string) func() {
+	if traceWriter("GIT_TRACE_PERFORMANCE") == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		tracef("GIT_TRACE_PERFORMANCE", "performance: %.9f s: %s", time.Since(start).Seconds(), phase)
+	}
+}
+
+// Arguments joined by spaces - ones with special characters are single-quoted, so they can be pasted to shell
+func quoteTraceArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+		}) {
+			quoted[i] = shellQuote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Wrap transport so pkt-lines going through it are traced - transport is returned as it is when
+// GIT_TRACE_PACKET is off
+func tracePacketTransport(transport Transport) Transport {
+	if traceWriter("GIT_TRACE_PACKET") == nil {
+		return transport
+	}
+	return &PacketTraceTransport{Transport: transport}
+}
+
+func (transport *PacketTraceTransport) Connect(ctx context.Context, service string) ([]byte, error) {
+	advertisement, err := transport.Transport.Connect(ctx, service)
+	if err == nil {
+		(&PacketTracer{Direction: '<'}).feed(advertisement)
+	}
+	return advertisement, err
+}
+
+func (transport *PacketTraceTransport) Request(ctx context.Context, service string, request []byte, protocolVersion int) (io.ReadCloser, error) {
+	(&PacketTracer{Direction: '>'}).feed(request)
+	stream, err := transport.Transport.Request(ctx, service, request, protocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &PacketTraceReader{ReadCloser: stream, tracer: &PacketTracer{Direction: '<'}}, nil
+}
+
+func (reader *PacketTraceReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.tracer.feed(p[:n])
+	return n, err
+}
+
+func (writer *PacketTraceWriter) Write(p []byte) (int, error) {
+	writer.tracer.feed(p)
+	return writer.Writer.Write(p)
+}
+
+// Take next bytes of the stream - every complete pkt-line in them is logged, the rest waits for more data
+func (tracer *PacketTracer) feed(data []byte) {
+	if tracer.done {
+		return
+	}
+	tracer.pending = append(tracer.pending, data...)
+
+	for !tracer.done && len(tracer.pending) >= 4 {
+		// Pack without side-band comes raw, right after the last pkt-line
+		if bytes.HasPrefix(tracer.pending, []byte("PACK")) {
+			tracer.packet([]byte("PACK"))
+			break
+		}
+
+		length, err := strconv.ParseUint(string(tracer.pending[:4]), 16, 16)
+		if err != nil {
+			tracef("GIT_TRACE_PACKET", "packet: %12s%c <invalid pkt-line length %q>", traceIdentity, tracer.Direction, tracer.pending[:4])
+			tracer.done = true
+			break
+		}
+		if length < 4 {
+			tracef("GIT_TRACE_PACKET", "packet: %12s%c %04d", traceIdentity, tracer.Direction, length)
+			tracer.pending = tracer.pending[4:]
+			continue
+		}
+		if len(tracer.pending) < int(length) {
+			break
+		}
+		tracer.packet(tracer.pending[4:length])
+		tracer.pending = tracer.pending[length:]
+	}
+
+	if tracer.done {
+		tracer.pending = nil
+	}
+}
+
+// Log payload of one pkt-line - trailing newline is dropped, non-printable bytes are shown as octal escapes
+func (tracer *PacketTracer) packet(payload []byte) {
+	if bytes.HasPrefix(payload, []byte("PACK")) || bytes.HasPrefix(payload, []byte("\x01PACK")) {
+		// Pack data isn't worth reading - it would only flood the trace
+		tracef("GIT_TRACE_PACKET", "packet: %12s%c PACK ...", traceIdentity, tracer.Direction)
+		tracer.done = true
+		return
+	}
+
+	var line strings.Builder
+	for _, b := range bytes.TrimSuffix(payload, []byte("\n")) {
+		if b >= 0x20 && b <= 0x7e {
+			line.WriteByte(b)
+		} else {
+			fmt.Fprintf(&line, "\\%o", b)
+		}
+	}
+	tracef("GIT_TRACE_PACKET", "packet: %12s%c %s", traceIdentity, tracer.Direction, line.String())
+}
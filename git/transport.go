@@ -0,0 +1,419 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Transports - how requests reach git-upload-pack/git-receive-pack on the remote side
+//
+//   - http(s)://host/repo.git          - smart HTTP, every request is a separate POST (stateless)
+//     (dumb HTTP if server only serves repository files - see dumbhttp.go)
+//   - ssh://[user@]host[:port]/path    - ssh runs the service on remote host, pkt-lines go over its stdin/stdout
+//   - [user@]host:path                 - scp-like syntax for ssh
+//   - git://host[:port]/path           - git daemon, plain TCP (port 9418 by default)
+//   - /path/to/repo, file:///path      - local repository, our own upload-pack runs as a child process
+//   - /path/to/file.bundle             - bundle file, refs and pack are read from it (see bundle.go)
+//
+// The ssh command can be replaced with GIT_SSH_COMMAND (run by shell) or GIT_SSH (program), like in git.
+
+// Pick transport for remote URL - token (or GIT_TOKEN env if empty) is used to authenticate HTTP requests
+func newTransport(remoteUrl, token string) (Transport, error) {
+	transport, err := pickTransport(remoteUrl, token)
+	if err != nil {
+		return nil, err
+	}
+	return tracePacketTransport(transport), nil
+}
+
+func pickTransport(remoteUrl, token string) (Transport, error) {
+	switch {
+	case strings.HasPrefix(remoteUrl, "http://"), strings.HasPrefix(remoteUrl, "https://"):
+		cleanUrl, auth, err := newHttpAuth(remoteUrl, token)
+		if err != nil {
+			return nil, err
+		}
+		return &HttpTransport{Url: strings.TrimSuffix(cleanUrl, "/"), Auth: auth}, nil
+	case strings.HasPrefix(remoteUrl, "ssh://"), isScpLikeUrl(remoteUrl):
+		return parseSshUrl(remoteUrl)
+	case strings.HasPrefix(remoteUrl, "git://"):
+		return parseGitDaemonUrl(remoteUrl)
+	case localRepositoryPath(remoteUrl) != "" && isBundleFile(localRepositoryPath(remoteUrl)):
+		return &BundleTransport{Path: localRepositoryPath(remoteUrl)}, nil
+	case localRepositoryPath(remoteUrl) != "":
+		return &LocalTransport{Path: localRepositoryPath(remoteUrl)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported remote url: %s", remoteUrl)
+	}
+}
+
+// host:path without scheme (git@github.com:org/repo.git) - colon has to come before any slash
+func isScpLikeUrl(remoteUrl string) bool {
+	if strings.Contains(remoteUrl, "://") {
+		return false
+	}
+	colon := strings.IndexByte(remoteUrl, ':')
+	slash := strings.IndexByte(remoteUrl, '/')
+	return colon > 0 && (slash == -1 || colon < slash)
+}
+
+// Smart servers answer with "# service=<service>" pkt-line, static file servers return plain info/refs file
+func (transport *HttpTransport) Connect(ctx context.Context, service string) ([]byte, error) {
+	advertisement, err := fetchRefs(ctx, transport.Url, service, transport.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if isSmartAdvertisement(advertisement) {
+		return advertisement, nil
+	}
+
+	if service != "git-upload-pack" {
+		return nil, fmt.Errorf("%s is not supported by dumb HTTP server", service)
+	}
+	transport.Dumb = true
+	return transport.dumbRefsAdvertisement(ctx, advertisement)
+}
+
+func (transport *HttpTransport) Request(ctx context.Context, service string, request []byte, protocolVersion int) (io.ReadCloser, error) {
+	if transport.Dumb {
+		response, err := transport.dumbUploadPack(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(response)), nil
+	}
+	return sendServiceRequest(ctx, transport.Url, service, request, protocolVersion, transport.Auth)
+}
+
+func (transport *HttpTransport) Close() error {
+	if transport.tempDir != "" {
+		os.RemoveAll(transport.tempDir)
+		transport.tempDir = ""
+	}
+	return nil
+}
+
+// Split ssh URL into host (with user), port and repository path - host, port or path starting with '-' would be
+// taken by ssh as an option (e.g. -oProxyCommand=...), so they are refused
+func parseSshUrl(remoteUrl string) (*SshTransport, error) {
+	transport := &SshTransport{}
+
+	if rest, ok := strings.CutPrefix(remoteUrl, "ssh://"); ok {
+		hostPart, path, found := strings.Cut(rest, "/")
+		if !found {
+			return nil, fmt.Errorf("missing repository path in %s", remoteUrl)
+		}
+		transport.Host = hostPart
+		if at := strings.LastIndexByte(hostPart, '@'); strings.LastIndexByte(hostPart, ':') > at {
+			colon := strings.LastIndexByte(hostPart, ':')
+			transport.Host, transport.Port = hostPart[:colon], hostPart[colon+1:]
+		}
+		// ssh://host/~user/repo is relative to user's home, everything else is absolute
+		transport.Path = "/" + path
+		if strings.HasPrefix(path, "~") {
+			transport.Path = path
+		}
+	} else {
+		host, path, _ := strings.Cut(remoteUrl, ":")
+		transport.Host, transport.Path = host, path
+	}
+
+	if transport.Host == "" || transport.Path == "" {
+		return nil, fmt.Errorf("invalid ssh url: %s", remoteUrl)
+	}
+	if strings.HasPrefix(transport.Host, "-") {
+		return nil, fmt.Errorf("strange hostname '%s' blocked", transport.Host)
+	}
+	if strings.HasPrefix(transport.Port, "-") {
+		return nil, fmt.Errorf("strange port '%s' blocked", transport.Port)
+	}
+	if strings.HasPrefix(transport.Path, "-") {
+		return nil, fmt.Errorf("strange pathname '%s' blocked", transport.Path)
+	}
+	return transport, nil
+}
+
+// Start ssh running the service and read its refs advertisement
+func (transport *SshTransport) Connect(ctx context.Context, service string) ([]byte, error) {
+	remoteCommand := fmt.Sprintf("%s %s", service, shellQuote(transport.Path))
+	return transport.start(sshCommand(ctx, transport.Host, transport.Port, remoteCommand))
+}
+
+// Start process speaking pkt-lines on stdin/stdout and read its refs advertisement (pkt-lines until the first flush)
+func (transport *ProcessTransport) start(cmd *exec.Cmd) ([]byte, error) {
+	if transport.cmd != nil {
+		return nil, fmt.Errorf("transport is already connected")
+	}
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	traceRunCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", filepath.Base(cmd.Path), err)
+	}
+	transport.cmd = cmd
+	transport.stdin = stdin
+	transport.stdout = bufio.NewReader(stdout)
+
+	advertisement, err := readAdvertisement(transport.stdout)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return advertisement, nil
+}
+
+// Send request to the running service - it exits after answering, so response ends with EOF
+func (transport *ProcessTransport) Request(ctx context.Context, service string, request []byte, protocolVersion int) (io.ReadCloser, error) {
+	if transport.cmd == nil {
+		return nil, fmt.Errorf("transport is not connected")
+	}
+	if protocolVersion == 2 {
+		return nil, fmt.Errorf("protocol v2 is not supported over this transport")
+	}
+
+	if _, err := transport.stdin.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	transport.stdin.Close()
+
+	return &ResponseReader{Reader: transport.stdout, close: func() error {
+		if err := transport.Close(); err != nil {
+			return fmt.Errorf("%s failed: %w", service, err)
+		}
+		return nil
+	}}, nil
+}
+
+func (transport *ProcessTransport) Close() error {
+	if transport.cmd == nil {
+		return nil
+	}
+	transport.stdin.Close()
+	err := transport.cmd.Wait()
+	transport.cmd = nil
+	return err
+}
+
+// Run upload-pack (or receive-pack) on the local repository in a child process (see serviceCommand)
+func (transport *LocalTransport) Connect(ctx context.Context, service string) ([]byte, error) {
+	if service != "git-upload-pack" && service != "git-receive-pack" {
+		return nil, fmt.Errorf("%s is not supported for local repositories", service)
+	}
+	cmd := serviceCommand(ctx, currentContext().Program, strings.TrimPrefix(service, "git-"), transport.Path)
+	cmd.Env = serviceEnvironment()
+	return transport.start(cmd)
+}
+
+// Command running a service of program (e.g. "upload-pack <dir>") - program is our own binary when the
+// command line gave it (RepoContext.Program), git is used when it is empty
+func serviceCommand(ctx context.Context, program string, args ...string) *exec.Cmd {
+	if program == "" {
+		program = "git"
+	}
+	return exec.CommandContext(ctx, program, args...)
+}
+
+// Environment for our own service process (upload-pack, receive-pack) - repository is found from its path argument alone,
+// so variables pointing to our repository are left out
+func serviceEnvironment() []string {
+	var env []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if name != "GIT_DIR" && name != "GIT_WORK_TREE" && name != "GIT_OBJECT_DIRECTORY" && name != "GIT_INDEX_FILE" {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
+
+// Local repository path for file:// URL or plain path - empty if remote is not local
+func localRepositoryPath(remoteUrl string) string {
+	if path, ok := strings.CutPrefix(remoteUrl, "file://"); ok {
+		return path
+	}
+	if strings.Contains(remoteUrl, "://") || isScpLikeUrl(remoteUrl) {
+		return ""
+	}
+	return remoteUrl
+}
+
+// Make local remote absolute - relative to the directory commands run in (see commandDirPath), so remote
+// URLs in config are relative to the work tree root
+func absoluteRemoteUrl(remoteUrl string) string {
+	path := localRepositoryPath(remoteUrl)
+	if path == "" || strings.HasPrefix(remoteUrl, "file://") {
+		return remoteUrl
+	}
+	return commandDirPath(path)
+}
+
+// Build ssh command - GIT_SSH_COMMAND is run by shell (may contain options), GIT_SSH is a program
+// Options end with "--", so the host is never read as one
+func sshCommand(ctx context.Context, host, port, remoteCommand string) *exec.Cmd {
+	args := []string{}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", host, remoteCommand)
+
+	if sshCmd := os.Getenv("GIT_SSH_COMMAND"); sshCmd != "" {
+		return exec.CommandContext(ctx, "sh", append([]string{"-c", sshCmd + ` "$@"`, sshCmd}, args...)...)
+	}
+	if sshProgram := os.Getenv("GIT_SSH"); sshProgram != "" {
+		return exec.CommandContext(ctx, sshProgram, args...)
+	}
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// Quote string for remote shell - wrapped in single quotes, inner single quotes escaped
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Read refs advertisement from stream - pkt-lines up to (and including) the first flush
+// Server that refuses the request (git daemon) sends "ERR <message>" instead
+func readAdvertisement(reader *bufio.Reader) ([]byte, error) {
+	var advertisement bytes.Buffer
+	for {
+		packet, isFlush, err := readPktLineFrom(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read refs advertisement: %w", err)
+		}
+		if message, ok := bytes.CutPrefix(packet[4:], []byte("ERR ")); ok && advertisement.Len() == 0 {
+			return nil, fmt.Errorf("remote error: %s", bytes.TrimSuffix(message, []byte("\n")))
+		}
+		advertisement.Write(packet)
+		if isFlush {
+			return advertisement.Bytes(), nil
+		}
+	}
+}
+
+// Read one raw pkt-line (length prefix included) from stream - reports whether it was a flush packet
+func readPktLineFrom(reader *bufio.Reader) ([]byte, bool, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, false, err
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pkt-line length %q", header)
+	}
+	if length < 4 {
+		// Special packet (flush/delim) - header is the whole packet
+		return header, length == 0, nil
+	}
+
+	packet := make([]byte, length)
+	copy(packet, header)
+	if _, err := io.ReadFull(reader, packet[4:]); err != nil {
+		return nil, false, err
+	}
+	return packet, false, nil
+}
+
+// Split git:// URL into host, port and repository path
+func parseGitDaemonUrl(remoteUrl string) (*GitDaemonTransport, error) {
+	rest := strings.TrimPrefix(remoteUrl, "git://")
+	hostPart, path, found := strings.Cut(rest, "/")
+	if !found || hostPart == "" {
+		return nil, fmt.Errorf("invalid git url: %s", remoteUrl)
+	}
+
+	transport := &GitDaemonTransport{Host: hostPart, Port: "9418", Path: "/" + path}
+	if host, port, err := net.SplitHostPort(hostPart); err == nil {
+		transport.Host, transport.Port = host, port
+	}
+	return transport, nil
+}
+
+// Open TCP connection, ask daemon for the service and read its refs advertisement
+// Request line: "<service> <path>\0host=<host>\0"
+func (transport *GitDaemonTransport) Connect(ctx context.Context, service string) ([]byte, error) {
+	if transport.conn != nil {
+		return nil, fmt.Errorf("git transport is already connected")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(transport.Host, transport.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", transport.Host, err)
+	}
+	transport.conn = conn
+	transport.reader = bufio.NewReader(conn)
+	transport.watch(ctx)
+
+	hostHeader := transport.Host
+	if transport.Port != "9418" {
+		hostHeader = net.JoinHostPort(transport.Host, transport.Port)
+	}
+	writePktLine(conn, fmt.Sprintf("%s %s\x00host=%s\x00", service, transport.Path, hostHeader))
+
+	advertisement, err := readAdvertisement(transport.reader)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return advertisement, nil
+}
+
+// Send request over the open connection - response ends when daemon closes it
+func (transport *GitDaemonTransport) Request(ctx context.Context, service string, request []byte, protocolVersion int) (io.ReadCloser, error) {
+	if transport.conn == nil {
+		return nil, fmt.Errorf("git transport is not connected")
+	}
+	if protocolVersion == 2 {
+		return nil, fmt.Errorf("protocol v2 is not supported over git://")
+	}
+
+	transport.watch(ctx)
+	if _, err := transport.conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if tcpConn, ok := transport.conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	return &ResponseReader{Reader: transport.reader, close: transport.Close}, nil
+}
+
+// Close connection when ctx is canceled - blocked reads and writes return right away
+func (transport *GitDaemonTransport) watch(ctx context.Context) {
+	conn := transport.conn
+	transport.stopWatching = append(transport.stopWatching, context.AfterFunc(ctx, func() { conn.Close() }))
+}
+
+func (transport *GitDaemonTransport) Close() error {
+	if transport.conn == nil {
+		return nil
+	}
+	for _, stop := range transport.stopWatching {
+		stop()
+	}
+	transport.stopWatching = nil
+	err := transport.conn.Close()
+	transport.conn = nil
+	return err
+}
+
+func (response *ResponseReader) Close() error {
+	return response.close()
+}
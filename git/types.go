@@ -0,0 +1,1590 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// All types that our program uses
+
+type ObjectType int
+
+func (objType ObjectType) String() string {
+	switch objType {
+	case OBJ_TREE:
+		return "tree"
+	case OBJ_COMMIT:
+		return "commit"
+	case OBJ_BLOB:
+		return "blob"
+	case OBJ_TAG:
+		return "tag"
+	case OBJ_OFS_DELTA:
+		return "ofs_delta"
+	case OBJ_REF_DELTA:
+		return "ref_delta"
+	default:
+		return ""
+	}
+}
+
+func ObjectTypeFromString(s string) (ObjectType, error) {
+	switch s {
+	case "tree":
+		return OBJ_TREE, nil
+	case "commit":
+		return OBJ_COMMIT, nil
+	case "blob":
+		return OBJ_BLOB, nil
+	case "tag":
+		return OBJ_TAG, nil
+	default:
+		return 0, fmt.Errorf("unknown ObjectType: %s", s)
+	}
+}
+
+const (
+	OBJ_COMMIT    = 1
+	OBJ_TREE      = 2
+	OBJ_BLOB      = 3
+	OBJ_TAG       = 4
+	OBJ_OFS_DELTA = 6
+	OBJ_REF_DELTA = 7
+)
+
+type IndexEntry struct {
+	Path         string
+	Hash         []byte
+	Mode         uint32
+	SkipWorktree bool  // Work tree file is not checked out and is not compared
+	IntentToAdd  bool  // Path is recorded with "add -N" - not part of the tree yet
+	Stage        uint8 // Merge stage - 0, or 1 (base), 2 (ours) and 3 (theirs) for versions of a conflicted path
+	Stat         IndexStat
+	// File didn't change since the last fsmonitor query, so it doesn't even have to be stat-ed
+	FSMonitorValid bool
+}
+
+// Stat data of work tree file when its index entry was written - same stat means the file was not changed
+// Values are truncated to 32 bits, as in the index file
+type IndexStat struct {
+	CTimeSeconds     uint32
+	CTimeNanoseconds uint32
+	MTimeSeconds     uint32
+	MTimeNanoseconds uint32
+	Dev              uint32
+	Ino              uint32
+	UID              uint32
+	GID              uint32
+	Size             uint32
+}
+
+type TreeNode struct {
+	Name     string
+	IsDir    bool
+	Hash     []byte
+	Mode     uint32
+	Children map[string]*TreeNode
+}
+
+type GitObject struct {
+	Type        ObjectType
+	Data        []byte
+	Hash        string
+	BaseObjHash string
+	Size        uint64
+	Offset      uint64
+	BaseOffset  uint64
+	// Path the object was found at (blobs and trees) - versions of one file are good delta bases for each other
+	Path string
+}
+
+// Delta compression of a written pack - each object is compared with the Window objects sorted before it,
+// delta chains are at most Depth long (0 for either - objects are stored whole). With OffsetDeltas the base
+// is referenced by its position in the pack (OFS_DELTA), otherwise by its hash (REF_DELTA).
+type packCompression struct {
+	Window       int
+	Depth        int
+	OffsetDeltas bool
+}
+
+// Object whose content doesn't hash to its name - returned by readObjectFromHash
+type ObjectCorruptError struct {
+	Hash   string
+	Actual string
+}
+
+func (err *ObjectCorruptError) Error() string {
+	return fmt.Sprintf("object %s is corrupt: content hashes to %s", err.Hash, err.Actual)
+}
+
+func (err *ObjectCorruptError) Unwrap() error {
+	return ErrCorruptObject
+}
+
+// Object content opened for streaming - Read hashes the content as it goes and returns *ObjectCorruptError
+// at the end if it doesn't match the object name
+type ObjectStream struct {
+	Type     string
+	Size     int64
+	reader   io.Reader
+	closer   io.Closer
+	hasher   hash.Hash
+	expected string
+}
+
+// Repository opened with Open (or created with Init or Clone) - Prefix is the directory it was opened from,
+// relative to the work tree root. Every repository has its own layout (absolute paths), which its methods
+// make the active one while they run, together with the context it was opened in.
+type Repository struct {
+	Prefix string
+	layout RepoLayout
+	// Directory the repository was opened from - file arguments are relative to it
+	dir     string
+	context *RepoContext
+}
+
+// Context repositories are opened, created, cloned and served in - global options (see NewRepoContext)
+// kept with the repository instead of the process directory and environment. The zero value is the
+// current directory with GIT_DIR and GIT_WORK_TREE from the environment.
+type RepoContext struct {
+	// Directory relative paths start from (-C) - the current directory when empty
+	Dir string
+	// Git directory and work tree (--git-dir, --work-tree) - GIT_DIR and GIT_WORK_TREE when empty
+	GitDir   string
+	WorkTree string
+	// Ignore replace refs (--no-replace-objects) - passed on to child processes as GIT_NO_REPLACE_OBJECTS
+	NoReplaceObjects bool
+	// Program child processes for other repositories run (see GlobalOptions.Program) - git when empty
+	Program string
+}
+
+type RepoLayout struct {
+	GitDir    string
+	WorkTree  string
+	ObjectDir string
+	IndexFile string
+	// Bare repository - HEAD, objects and refs at the top level, no work tree
+	Bare bool
+}
+
+type Config struct {
+	values map[string][]string
+}
+
+type IgnorePattern struct {
+	Pattern  string
+	Base     string
+	Source   string
+	Line     int
+	Negate   bool
+	DirOnly  bool
+	Anchored bool
+}
+
+type IgnoreMatcher struct {
+	patterns   []IgnorePattern
+	loadedDirs map[string]bool
+}
+
+type TreeEntry struct {
+	Mode string
+	Name string
+	Hash string
+}
+
+type WorkTreeStatus struct {
+	Branch    string
+	Head      string
+	Staged    map[string]string
+	Unstaged  map[string]string
+	Untracked []string
+	Ignored   []string
+	// Staged renames - new path (listed in Staged as "renamed") -> old path, and similarity in percent
+	RenamedFrom  map[string]string
+	RenameScores map[string]int
+	// Entries behind the changes (for porcelain v2) - HEAD files, index entries and modes of files changed
+	// in the work tree (0 when deleted)
+	HeadFiles     map[string]TreeEntry
+	IndexEntries  map[string]IndexEntry
+	WorkTreeModes map[string]uint32
+	// Conflicted paths with their stage 1-3 entries, and whether a merge is in progress (MERGE_HEAD)
+	Unmerged map[string][]IndexEntry
+	Merging  bool
+	// Upstream of the branch - nil when it has none
+	Tracking *BranchTracking
+}
+
+// Options of status command - Color is the --color value (always, never or auto); Porcelain is the
+// machine readable format version (0 for the long format), Branch adds branch headers to it and
+// NullTerminated ends entries with NUL (-z)
+type StatusOptions struct {
+	ShowIgnored    bool
+	Color          string
+	Porcelain      int
+	Branch         bool
+	NullTerminated bool
+	// Write status as JSON (global --json)
+	JSON bool
+}
+
+// Options of ls-files command - Stage shows mode, hash and stage of entries, NullTerminated ends them with NUL (-z)
+type LsFilesOptions struct {
+	Stage          bool
+	Unmerged       bool // Only conflicted paths (implies Stage)
+	NullTerminated bool
+	Paths          []string
+}
+
+type CleanOptions struct {
+	DryRun      bool
+	Dirs        bool
+	NoIgnore    bool
+	OnlyIgnored bool
+}
+
+type AttributeState int
+
+const (
+	ATTR_UNSPECIFIED AttributeState = iota
+	ATTR_SET
+	ATTR_UNSET
+	ATTR_VALUE
+)
+
+type AttributeValue struct {
+	State AttributeState
+	Value string
+}
+
+type AttributeAssignment struct {
+	Name  string
+	Value AttributeValue
+}
+
+type AttributeRule struct {
+	Pattern     IgnorePattern
+	Assignments []AttributeAssignment
+}
+
+type AttributeMatcher struct {
+	globalRules []AttributeRule
+	dirRules    []AttributeRule
+	infoRules   []AttributeRule
+	macros      map[string][]AttributeAssignment
+	loadedDirs  map[string]bool
+}
+
+type EolConverter struct {
+	attributes *AttributeMatcher
+	autocrlf   string
+	eol        string
+}
+
+type PackIndex struct {
+	PackPath string
+	Version  uint32
+	Fanout   [256]uint32
+	Hashes   []byte
+	Offsets  []uint64
+}
+
+// State of rewrite-history - old -> new hashes of commits ("" for dropped commits without parents), of
+// trees by directory path and hash, and of annotated tags
+type historyRewriter struct {
+	removed map[string]bool
+	mailmap map[string]mailmapEntry
+	trees   map[string]string
+	commits map[string]string
+	// Tree of every new commit
+	newTrees map[string]string
+	// Commits dropped because they changed nothing once paths were removed
+	dropped map[string]bool
+	tags    map[string]string
+}
+
+// Identity a mailmap gives - empty fields stay as they are in the commit
+type mailmapEntry struct {
+	Name  string
+	Email string
+}
+
+// Object read by fsck - its type and the objects it links to
+type fsckObject struct {
+	Type  string
+	Links []fsckLink
+}
+
+// Link to an object with the type the linking object claims for it - empty when any type will do
+type fsckLink struct {
+	Hash string
+	Type string
+}
+
+// Pack in own pack directory while repacking
+type localPack struct {
+	Path    string
+	Objects int
+	Hashes  []string
+	// Has .keep (or .promisor) - never rewritten or deleted
+	Keep bool
+}
+
+// Reachability bitmap of a pack - bits are positions in Objects (pack order)
+type PackBitmap struct {
+	PackPath  string
+	Objects   []string
+	Positions map[string]int
+	// Commits, trees, blobs, tags
+	Types [4][]bool
+	// Objects reachable from each bitmapped commit
+	Commits map[string][]bool
+}
+
+// Parsed multi-pack-index - one sorted list of objects for several packs
+type MultiPackIndex struct {
+	PackPaths    []string
+	Fanout       [256]uint32
+	Hashes       []byte
+	Offsets      []byte
+	LargeOffsets []byte
+}
+
+// Object entry while multi-pack-index is written - newest pack wins for duplicates
+type MultiPackObject struct {
+	Hash    []byte
+	PackID  uint32
+	Offset  uint64
+	ModTime int64
+}
+
+type MultiPackChunk struct {
+	ID   string
+	Data []byte
+}
+
+// Mapped region of a pack file - Data covers [Offset, Offset+len(Data))
+type PackWindow struct {
+	PackPath string
+	Offset   int64
+	Data     []byte
+	lastUsed uint64
+}
+
+// LRU of mapped pack windows - total mapped size is kept under Limit (core.packedGitLimit), every window is at
+// most WindowSize bytes (core.packedGitWindowSize)
+type PackWindowCache struct {
+	windows    []*PackWindow
+	mapped     int64
+	WindowSize int64
+	Limit      int64
+	tick       uint64
+}
+
+// Sequential reader over pack windows - starts at Offset and moves to the next window when one ends
+type PackCursor struct {
+	PackPath string
+	Offset   int64
+}
+
+// Ref update of a refTransaction - New is zeroHash for deletion, Old as in updateRefVerified
+type refTransactionUpdate struct {
+	Name string
+	Old  string
+	New  string
+	// Taken lock (nil once the update is written or released) and the value read under it
+	lock     *os.File
+	previous string
+	written  bool
+}
+
+// Ref updates applied together - every ref is locked and checked before any of them is written (see
+// refTransaction.prepare and refTransaction.commit)
+type refTransaction struct {
+	updates []*refTransactionUpdate
+	// Reflog message of the updates
+	message string
+}
+
+type PackedRef struct {
+	Name   string
+	Hash   string
+	Peeled string
+}
+
+// Options given before the command (git [-C <path>] [--git-dir=<path>] [--work-tree=<path>] [--no-pager] <command>)
+type GlobalOptions struct {
+	// -C directories, applied in order (relative ones are relative to the previous one)
+	Directories []string
+	GitDir      string
+	WorkTree    string
+	NoPager     bool
+	// Write output as JSON (commands in jsonCommands)
+	JSON bool
+	// Ignore replace refs (--no-replace-objects) - also for commands run by this one
+	NoReplaceObjects bool
+	// mygit binary run for work in other processes (upload-pack, receive-pack, background maintenance) -
+	// when empty, git serves other repositories and auto maintenance runs in this process
+	Program string
+}
+
+// Options of ls-remote command - Remote is a remote name or URL, Heads/Tags limit refs to branches/tags
+type LsRemoteOptions struct {
+	Remote string
+	Heads  bool
+	Tags   bool
+	// Write refs as JSON (global --json)
+	JSON bool
+}
+
+// Remote ref as ls-remote lists it - Target is where a symbolic ref (HEAD) points
+type RemoteRef struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Target string `json:"target,omitempty"`
+}
+
+// JSON form of objects and of status (--json) - see json.go
+type JSONObject struct {
+	Hash string `json:"hash"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	// Blob content - base64 encoded when it isn't UTF-8
+	Content       *string `json:"content,omitempty"`
+	ContentBase64 string  `json:"content_base64,omitempty"`
+	// Tree entries, parsed commit or tag
+	Entries []JSONTreeEntry `json:"entries,omitempty"`
+	Commit  *JSONCommit     `json:"commit,omitempty"`
+	Tag     *JSONTag        `json:"tag,omitempty"`
+}
+
+type JSONTreeEntry struct {
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+}
+
+type JSONCommit struct {
+	Hash      string         `json:"hash,omitempty"`
+	Tree      string         `json:"tree"`
+	Parents   []string       `json:"parents"`
+	Author    *JSONSignature `json:"author"`
+	Committer *JSONSignature `json:"committer"`
+	Message   string         `json:"message"`
+}
+
+type JSONTag struct {
+	Object     string         `json:"object"`
+	ObjectType string         `json:"object_type"`
+	Tag        string         `json:"tag"`
+	Tagger     *JSONSignature `json:"tagger,omitempty"`
+	Message    string         `json:"message"`
+}
+
+// Author, committer or tagger - Date is in RFC 3339 format
+type JSONSignature struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+type JSONStatus struct {
+	Branch    string             `json:"branch,omitempty"`
+	Head      string             `json:"head,omitempty"`
+	Staged    []JSONStatusChange `json:"staged"`
+	Unstaged  []JSONStatusChange `json:"unstaged"`
+	Unmerged  []JSONStatusChange `json:"unmerged,omitempty"`
+	Untracked []string           `json:"untracked"`
+	Ignored   []string           `json:"ignored,omitempty"`
+}
+
+type JSONStatusChange struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	// Old path of a renamed file
+	From string `json:"from,omitempty"`
+}
+
+// Pager started by startPager - Input is the pager's standard input, Stdout and Stderr are the original
+// standard output and error (restored when it is stopped)
+type Pager struct {
+	Cmd            *exec.Cmd
+	Input          *os.File
+	Stdout, Stderr *os.File
+	Signals        chan os.Signal
+}
+
+type InitOptions struct {
+	Directory string
+	Bare      bool
+	// Branch HEAD points to - init.defaultBranch config (or master) when empty
+	InitialBranch string
+}
+
+type CloneOptions struct {
+	Url       string
+	Directory string
+	Branch    string
+	Tag       string
+	Depth     int
+	Filter    string
+	Token     string
+	// Objects are borrowed through objects/info/alternates - from the source (--shared) or another
+	// local repository (--reference)
+	Shared    bool
+	Reference string
+	Bare      bool
+	Sparse    bool // Only files in the root directory are checked out (sparse-checkout init)
+	// Bare clone with every remote ref copied as it is - fetch refspec +refs/*:refs/*, and pushes to the
+	// remote mirror the local refs (remote.origin.mirror)
+	Mirror bool
+}
+
+type CommitOptions struct {
+	Message string
+	// Author signature - empty means the current user
+	Author     string
+	AllowEmpty bool
+	Sign       bool
+	SigningKey string
+	// Skip pre-commit and commit-msg hooks
+	NoVerify bool
+	// Without Message, take the prepared message (MERGE_MSG, SQUASH_MSG) without opening the editor
+	NoEdit bool
+}
+
+type TagOptions struct {
+	Name       string
+	Target     string
+	Message    string
+	Annotate   bool
+	Sign       bool
+	SigningKey string
+	Force      bool
+	List       bool
+	Patterns   []string
+	Contains   string
+	Delete     bool
+	Verify     bool
+	Names      []string
+}
+
+// Options of replace command - Object is replaced by Replacement, or with Delete the replace refs of Objects
+// are deleted. With List replaced objects matching Pattern are listed in Format (short, medium or long).
+type ReplaceOptions struct {
+	Object      string
+	Replacement string
+	Force       bool
+	Delete      bool
+	Objects     []string
+	List        bool
+	Pattern     string
+	Format      string
+}
+
+type BranchOptions struct {
+	Name       string
+	StartPoint string
+	Force      bool
+	Delete     bool
+	Names      []string
+	Remotes    bool
+	All        bool
+	Merged     string
+	NoMerged   string
+	Contains   string
+	Color      string
+	// -v shows commits and tracking state, -vv also names upstreams
+	Verbose int
+	// Upstream set with --set-upstream-to, Track/NoTrack override branch.autoSetupMerge on creation
+	Upstream      string
+	UnsetUpstream bool
+	Track         bool
+	NoTrack       bool
+}
+
+type branchFilter struct {
+	merged   string
+	noMerged string
+	contains string
+}
+
+// Branch as listed - name as shown, color slot, commit and target of symbolic remote ref
+type branchListEntry struct {
+	current bool
+	name    string
+	refName string
+	slot    string
+	hash    string
+	target  string
+}
+
+// Tracking state of branch - its upstream (origin/main) and commits on each side; Gone when the
+// remote-tracking ref doesn't exist
+type BranchTracking struct {
+	Upstream string
+	Ahead    int
+	Behind   int
+	Gone     bool
+}
+
+// Options of remote command - Command is add, remove, rename, set-url or show ("" lists remotes)
+type RemoteOptions struct {
+	Command string
+	Name    string
+	NewName string
+	Url     string
+	Push    bool
+	Verbose bool
+	NoQuery bool
+}
+
+// Refspec - "[+]<source>:<destination>" (see refspec.go). Glob refspecs have one "*" on each side, Negative
+// ones ("^<source>") only a source; Matching is ":" (branches that exist on both sides).
+type Refspec struct {
+	Source      string
+	Destination string
+	Force       bool
+	Negative    bool
+	Glob        bool
+	Matching    bool
+}
+
+// Ref selected by refspec - Source in the repository refs come from, Destination where they go ("" when
+// only FETCH_HEAD gets it), Hash its value ("" deletes Destination on push)
+type refMapping struct {
+	Source      string
+	Destination string
+	Hash        string
+	Force       bool
+}
+
+type FetchOptions struct {
+	// Remote name or URL - upstream remote of the current branch (or origin) when empty
+	Remote string
+	// Refspecs from the command line - remote.<name>.fetch when none
+	Refspecs []string
+	// Fetch every tag (--tags) or none (--no-tags) - by default tags pointing into fetched history follow
+	Tags   bool
+	NoTags bool
+}
+
+type PushOptions struct {
+	// Remote name or URL - push remote of the current branch (or origin) when empty
+	Remote string
+	// Refspecs from the command line - remote.<name>.push or push.default when none
+	Refspecs []string
+	// Update refs even when that loses commits on the remote (like "+" on every refspec)
+	Force bool
+	// Force only when remote refs are where their remote-tracking refs say (ForceWithLease), or where
+	// Leases say - "<ref>" (its remote-tracking ref) or "<ref>:<expected value>"
+	ForceWithLease bool
+	Leases         []string
+	// Delete refs named by Refspecs from the remote
+	Delete bool
+	// Push every tag too
+	Tags bool
+	// Update all refs or none (push.atomic by default)
+	Atomic bool
+	// Make remote refs exactly the local ones - every ref is force pushed and remote refs that don't exist
+	// here are deleted (remote.<name>.mirror by default)
+	Mirror bool
+	// Skip the pre-push hook
+	NoVerify bool
+}
+
+type RevListOptions struct {
+	// Revisions to walk ("^rev" and "a..b" exclude) - HEAD when empty
+	Revisions []string
+	// Every ref and HEAD too
+	All bool
+	// Trees and blobs too, not only commits
+	Objects bool
+	// Only how many
+	Count bool
+}
+
+type RepackOptions struct {
+	// Every reachable object into one pack (-a)
+	All bool
+	// With Delete, unreachable objects of deleted packs are written loose (-A)
+	KeepUnreachable bool
+	// Delete superseded packs and packed loose objects (-d)
+	Delete bool
+	// With KeepUnreachable, only packs newer than this date are unpacked (--unpack-unreachable) - all when empty
+	UnpackUnreachable string
+	// Roll small packs together so that pack sizes grow by this factor (--geometric) - 0 is off
+	Geometric int
+	// Write reachability bitmap (-b) - needs All
+	WriteBitmap bool
+}
+
+type GcOptions struct {
+	// Only when gc.auto thresholds are exceeded
+	Auto bool
+	// Prune unreachable objects older than this date (--prune, "never" with --no-prune) - gc.pruneExpire
+	// when empty
+	Prune string
+}
+
+type ReflogExpireOptions struct {
+	// Refs whose reflogs expire (HEAD, branch names, ...)
+	Refs []string
+	// Every reflog
+	All bool
+	// Entries older than this date are dropped (--expire) - gc.reflogExpire when empty
+	Expire string
+	// Entries older than this date whose commits the ref doesn't reach are dropped (--expire-unreachable) -
+	// gc.reflogExpireUnreachable when empty
+	ExpireUnreachable string
+	// Only report what would be dropped (-n)
+	DryRun bool
+	// Print every entry with what happens to it
+	Verbose bool
+}
+
+// Options of rewrite-history command - at least one of RemovePaths and Mailmap is needed
+type RewriteHistoryOptions struct {
+	// Files and directories to drop from every commit (paths from the repository root)
+	RemovePaths []string
+	// Mailmap file whose identities replace authors, committers and taggers
+	Mailmap string
+	// Keep the old history in the repository (no reflog expiry, repack and prune)
+	NoGc bool
+}
+
+type FsckOptions struct {
+	// Only check that reachable objects exist - objects are not verified, blobs not even read
+	ConnectivityOnly bool
+	// List every unreachable object, not only dangling ones
+	Unreachable bool
+	// Don't list dangling objects
+	NoDangling bool
+}
+
+type PruneOptions struct {
+	// Only objects older than this date (--expire) - every unreachable one when empty
+	Expire string
+	// Only list what would be deleted (-n)
+	DryRun bool
+	// List deleted objects (-v)
+	Verbose bool
+}
+
+type MaintenanceOptions struct {
+	// Tasks to run (loose-objects, gc, commit-graph) - the enabled ones when empty
+	Tasks []string
+	// Only tasks whose thresholds are exceeded
+	Auto bool
+}
+
+type PullOptions struct {
+	// Remote and refspecs to fetch - the upstream of the current branch when none are given
+	Remote   string
+	Refspecs []string
+	// Rebase onto the fetched commit instead of merging it (--rebase, --no-rebase - branch.<name>.rebase
+	// and pull.rebase decide when neither is set)
+	Rebase   bool
+	NoRebase bool
+	// "ff", "no" or "only" as for merge ("" - as pull.ff config says)
+	FastForward string
+}
+
+// Line of FETCH_HEAD - Merge unless "not-for-merge", Description names the ref and where it came from
+// ("branch 'main' of <url>")
+type fetchHeadEntry struct {
+	Hash        string
+	Merge       bool
+	Description string
+}
+
+// Ref update push decided on - Old is the remote value ("" for a new ref, like New for a deletion), Flag
+// and Summary are shown in front of the refs (" ", "abc1234..def5678"), Reason explains a rejection
+type pushRefUpdate struct {
+	Source      string
+	Destination string
+	Old         string
+	New         string
+	Force       bool
+	Flag        byte
+	Summary     string
+	Reason      string
+}
+
+type RevParseOptions struct {
+	Revisions []string
+	Verify    bool
+	Short     int
+}
+
+type FormatPatchOptions struct {
+	Range     string
+	MaxCount  int
+	Stdout    bool
+	OutputDir string
+	Diff      DiffOptions
+	// "[PATCH n/m]" subjects - by default only when there is more than one patch
+	Numbered   bool
+	NoNumbered bool
+}
+
+type UploadPackOptions struct {
+	Directory string
+	// Only advertise refs and exit (first GET of smart HTTP)
+	AdvertiseRefs bool
+	// Answer one request without advertising refs first (POST of smart HTTP)
+	StatelessRPC bool
+}
+
+type ReceivePackOptions struct {
+	Directory string
+	// Only advertise refs and exit (first GET of smart HTTP)
+	AdvertiseRefs bool
+	// Read commands and pack without advertising refs first (POST of smart HTTP)
+	StatelessRPC bool
+}
+
+// One ref update sent by push - zero hash as Old creates the ref, as New deletes it
+type RefUpdateCommand struct {
+	Old  string
+	New  string
+	Name string
+	// Reason the update was rejected ("ng" in report-status) - empty when it was applied
+	Error string
+}
+
+// Reader that keeps a copy of everything read through it - ReadByte is passed through, so zlib doesn't
+// read ahead of the stream it inflates
+type RecordingReader struct {
+	*bufio.Reader
+	Recorded bytes.Buffer
+}
+
+type DaemonOptions struct {
+	// 0 picks a free port
+	Port int
+	// Requested paths are relative to it (empty - paths are used as they are)
+	BasePath string
+	// Serve repositories without git-daemon-export-ok file
+	ExportAll bool
+	// Whitelist - only repositories inside these directories are served (all when empty)
+	Directories []string
+}
+
+type ServeHttpOptions struct {
+	// Repository, or directory with repositories below it
+	Directory string
+	// 0 picks a free port
+	Port int
+}
+
+// http.Handler answering smart HTTP requests for repositories below Root (see httpserver.go)
+type HttpBackend struct {
+	Root string
+	// Program services run as child processes of (see GlobalOptions.Program) - git when empty
+	Executable string
+}
+
+// Connection to a remote repository, for one service (git-upload-pack or git-receive-pack)
+//   - Connect returns refs advertisement (or v2 capabilities)
+//   - Request sends request and returns response stream (read as it arrives)
+type Transport interface {
+	Connect(ctx context.Context, service string) ([]byte, error)
+	Request(ctx context.Context, service string, request []byte, protocolVersion int) (io.ReadCloser, error)
+	Close() error
+}
+
+// Response stream whose Close also finishes the connection/process it comes from
+type ResponseReader struct {
+	io.Reader
+	close func() error
+}
+
+type HttpTransport struct {
+	Url  string
+	Auth *HttpAuth
+	// Server without smart HTTP support - objects are downloaded as static files
+	Dumb        bool
+	remotePacks []*PackIndex
+	tempDir     string
+}
+
+// Service running as a process (ssh, local upload-pack) - pkt-lines go over its stdin/stdout
+type ProcessTransport struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+type SshTransport struct {
+	Host string
+	Port string
+	Path string
+	ProcessTransport
+}
+
+type LocalTransport struct {
+	Path string
+	ProcessTransport
+}
+
+// Bundle file used as remote - refs and pack come from the file, nothing is negotiated
+type BundleTransport struct {
+	Path   string
+	bundle *Bundle
+}
+
+type GitDaemonTransport struct {
+	Host   string
+	Port   string
+	Path   string
+	conn   net.Conn
+	reader *bufio.Reader
+	// Stop closing the connection on context cancellation (see watch)
+	stopWatching []func() bool
+}
+
+// Credentials for HTTP requests - basic auth (username/password) or token
+type HttpAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// HTTP response body that aborts the transfer when it is too slow (http.lowSpeedLimit) - bytes read so far
+// are counted for the watchdog, which cancels ctx with the reason
+type LowSpeedReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	read   atomic.Int64
+}
+
+// Transport whose pkt-line traffic is logged to GIT_TRACE_PACKET (see trace.go)
+type PacketTraceTransport struct {
+	Transport
+}
+
+// Pkt-line decoder for GIT_TRACE_PACKET - bytes of one direction of the stream are fed to it as they pass
+type PacketTracer struct {
+	Direction byte // '<' received, '>' sent
+	pending   []byte
+	// Pack data started - the rest of the stream isn't traced
+	done bool
+}
+
+type PacketTraceReader struct {
+	io.ReadCloser
+	tracer *PacketTracer
+}
+
+type PacketTraceWriter struct {
+	io.Writer
+	tracer *PacketTracer
+}
+
+// Credential in git-credential format (key=value lines) - exchanged with credential helpers
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// Bundle file (git bundle v2/v3) - refs and prerequisites header followed by pack
+type Bundle struct {
+	Version int
+	// Commits the receiving repository must already have (objects reachable from them are not in the pack)
+	Prerequisites []string
+	Refs          []PackedRef
+	Pack          []byte
+}
+
+// Parsed commit object - Author/Committer are kept as "Name <email> timestamp timezone"
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Encoding  string
+	// Headers we don't interpret (gpgsig, mergetag, ...) - continuation lines included
+	ExtraHeaders []string
+	Message      string
+}
+
+// Parsed annotated tag object
+type Tag struct {
+	Object  string
+	Type    string
+	Name    string
+	Tagger  string
+	Message string
+}
+
+type LogOptions struct {
+	Revisions     []string
+	Paths         []string
+	MaxCount      int
+	ShowSignature bool
+	// --pretty/--format value ("" uses format.pretty, then medium; --oneline is "oneline" with AbbrevCommit)
+	Format       string
+	AbbrevCommit bool
+	// --date style ("" uses log.date)
+	Date string
+	// Keep following the (single) path through renames, Diff.MinScore is the similarity renames need
+	Follow bool
+	Diff   DiffOptions
+	// Show the patch of every commit against its first parent (-p); merges get theirs against every parent
+	// with Merges (-m), against the first one with FirstParent - which also walks first parents only
+	Patch       bool
+	Merges      bool
+	FirstParent bool
+	// --color value (always, never or auto)
+	Color string
+	// Draw commit graph left of the log (implies topological order)
+	Graph bool
+	// --decorate value (short, full, auto or no; "" uses log.decorate)
+	Decorate string
+	Filter   CommitFilter
+	// Write commits as JSON (global --json)
+	JSON bool
+}
+
+// Commit format of log - name is oneline, short, medium, full, fuller, raw or "format" (user is its text);
+// terminator ends every commit with a newline instead of separating commits; abbrev shortens the header
+// hash; date is the --date style
+type prettyFormat struct {
+	name       string
+	user       string
+	terminator bool
+	abbrev     bool
+	date       string
+}
+
+// Writer of commits in a format - decorations (with the branch HEAD is on) are shown in headers when
+// decorate is set and by %d; colors are log colors (nil when not colored), now is what relative dates are
+// relative to
+type commitFormatter struct {
+	pretty        prettyFormat
+	decorations   map[string][]string
+	headBranch    string
+	decorate      bool
+	fullRefNames  bool
+	colors        map[string]string
+	showSignature bool
+	now           time.Time
+}
+
+// Update of a ref recorded in its reflog - the ref moved from Old to New (zeros when it didn't exist),
+// Committer is the signature of who moved it
+type reflogEntry struct {
+	Old       string
+	New       string
+	Committer string
+	Message   string
+}
+
+// Options of stash - Command is push (the default), list, show, apply, pop, drop, branch or clear; Stash
+// names the entry (stash@{<n>} or <n>, the latest when empty) and Branch the branch stash branch creates.
+// Message describes the pushed entry (-m), Index restores the index too (--index); Diff selects what show
+// writes (a diffstat by default)
+type StashOptions struct {
+	Command string
+	Stash   string
+	Branch  string
+	Message string
+	Index   bool
+	Diff    DiffCmdOptions
+}
+
+// Options of show - objects are Log.Revisions (HEAD when there are none), the other Log options say how
+// commits are shown; NoPatch (-s) leaves out their diffs
+type ShowOptions struct {
+	Log     LogOptions
+	NoPatch bool
+}
+
+// Writer of log output - shown counts the commit headers written (commits are separated after the first
+// one), missingNewline is set when the last message left its line open. Diffs (patch and the diffstat of
+// diffOptions) of merges are shown as merges says: "" (none), "separate" (against every parent, -m),
+// "first-parent" or "combined" (show - see show.go)
+type logOutput struct {
+	writer         *bufio.Writer
+	graph          *LogGraph
+	formatter      *commitFormatter
+	diffOptions    DiffOptions
+	patch          bool
+	merges         string
+	shown          int
+	missingNewline bool
+}
+
+// Commit the log goes through - shown is false for commits only the graph needs (--follow); paths limit
+// its diff
+type logEntry struct {
+	hash  string
+	shown bool
+	paths []string
+}
+
+// Commit filters of log (--author, --committer, --grep, --since, --until) - patterns are basic regular
+// expressions unless ExtendedRegexp (-E) or FixedStrings (-F); IgnoreCase is -i, AllMatch requires every
+// grep pattern to match, InvertGrep shows commits no grep pattern matches; Since and Until are approximate dates
+type CommitFilter struct {
+	Authors        []string
+	Committers     []string
+	Grep           []string
+	AllMatch       bool
+	InvertGrep     bool
+	IgnoreCase     bool
+	ExtendedRegexp bool
+	FixedStrings   bool
+	Since          string
+	Until          string
+}
+
+// Compiled commit filters - zero since/until leave the date unlimited
+type commitMatcher struct {
+	authors    []*regexp.Regexp
+	committers []*regexp.Regexp
+	grep       []*regexp.Regexp
+	allMatch   bool
+	invertGrep bool
+	since      time.Time
+	until      time.Time
+}
+
+// Branch line of log --graph - the commit it leads to; color indexes graphColors (len(graphColors) when
+// the graph isn't colored)
+type graphColumn struct {
+	commit string
+	color  int
+}
+
+// State of log --graph drawing - parents are the shown parents of every shown commit; columns are the lines
+// above the current commit's row, newColumns those below it, and mapping (mappingSize long) sends screen
+// columns of the next row to newColumns
+type LogGraph struct {
+	parents         map[string][]string
+	colored         bool
+	commit          string
+	width           int
+	expansionRow    int
+	state           int
+	prevState       int
+	commitIndex     int
+	prevCommitIndex int
+	mergeLayout     int
+	edgesAdded      int
+	prevEdgesAdded  int
+	columns         []graphColumn
+	newColumns      []graphColumn
+	mapping         []int
+	oldMapping      []int
+	mappingSize     int
+	defaultColor    int
+}
+
+// Row of log --graph being drawn - width counts characters, text also has color escapes
+type graphLine struct {
+	text  strings.Builder
+	width int
+}
+
+// Result of signature verification - Status is G (good), U (good, unknown/untrusted key), B (bad)
+// or E (can't be checked, e.g. missing key); Output is the verifier's human readable report
+type SignatureCheck struct {
+	Status byte
+	Signer string
+	Output string
+}
+
+// State of fast-export run - marks are shared by blobs and commits (":<n>" in the stream)
+type FastExporter struct {
+	writer   *bufio.Writer
+	marks    map[string]int
+	nextMark int
+}
+
+// State of fast-import run - marks (":<n>") and ref tips are kept in memory and refs are written at the end
+type FastImporter struct {
+	reader *bufio.Reader
+	// Line read ahead by a command that had to look at the next command to know where it ends
+	pending    string
+	hasPending bool
+	marks      map[string]string
+	refs       map[string]string
+	refOrder   []string
+	counts     map[string]int
+}
+
+// One line of diff - Kind is ' ' (context), '-' (removed) or '+' (added), Text keeps its newline (if it has one)
+type DiffLine struct {
+	Kind byte
+	Text string
+}
+
+// Line diff of two files (Myers, patience, histogram)
+type diffAlgorithm func(a, b []string) []DiffLine
+
+// Unified diff hunk - starts are 1-based line numbers (0 when the side has no lines)
+type DiffHunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []DiffLine
+}
+
+// Changed file between two trees - Status is 'A' (added), 'D' (deleted), 'M' (modified, content or mode),
+// 'R' (renamed) or 'C' (copied); Similarity is the percentage of content renamed/copied files share
+type FileChange struct {
+	Status     byte
+	OldPath    string
+	NewPath    string
+	OldMode    string
+	NewMode    string
+	OldHash    string
+	NewHash    string
+	Similarity int
+}
+
+// Diff options - Renames is one of diffRenames* (DiffRenamesDefault leaves it to diff.renames), MinScore is
+// the similarity needed in diffMaxScore units (0 means 50%); WordDiff is "" (line diff), "plain", "color"
+// or "porcelain", WordRegex what a word is (runs of non-space characters by default); Colors are colors of
+// diff parts by slot (nil when output is not colored); BinaryPatch writes binary files as GIT binary patch
+// instead of "Binary files ... differ"; Algorithm is the line diff algorithm ("" leaves it to diff.algorithm)
+type DiffOptions struct {
+	Renames          int
+	FindCopiesHarder bool
+	MinScore         int
+	WordDiff         string
+	WordRegex        string
+	Colors           map[string]string
+	BinaryPatch      bool
+	Algorithm        string
+	Stat             DiffStatOptions
+}
+
+// Diffstat output (--stat, --numstat, --shortstat, --summary) - Width limits stat lines (terminal width when
+// 0), NameWidth and GraphWidth their parts, Count the number of files listed; NullTerminated ends numstat
+// lines with NUL (-z)
+type DiffStatOptions struct {
+	Stat           bool
+	NumStat        bool
+	ShortStat      bool
+	Summary        bool
+	Width          int
+	NameWidth      int
+	GraphWidth     int
+	Count          int
+	NullTerminated bool
+}
+
+// Options of diff command - Revisions are 0-2 commits (or one <commit>..<commit>), Cached compares with the index;
+// Color is the --color value (always, never or auto); Format is "" (patch), "raw", "name-only" or "name-status",
+// NullTerminated separates fields of those with NUL (-z); Patch (-p) shows the patch after diffstat output
+type DiffCmdOptions struct {
+	Cached         bool
+	Patch          bool
+	Revisions      []string
+	Paths          []string
+	Color          string
+	Format         string
+	NullTerminated bool
+	Diff           DiffOptions
+}
+
+// Options of difftool - Diff selects the changed files (like diff does), Tool overrides diff.tool and
+// NoPrompt skips asking before each file (-y)
+type DifftoolOptions struct {
+	Diff     DiffCmdOptions
+	Tool     string
+	NoPrompt bool
+}
+
+// Options of mergetool - Tool overrides merge.tool, NoPrompt skips asking before each file (-y), Prompt asks
+// even when mergetool.prompt is off; Paths limit the resolved conflicts
+type MergetoolOptions struct {
+	Tool     string
+	NoPrompt bool
+	Prompt   bool
+	Paths    []string
+}
+
+// External tool of mergetool - Command is the shell command of tool Name, answers to prompts are read
+// from input and questions written to output
+type MergeTool struct {
+	Name          string
+	Command       string
+	TrustExitCode bool
+	KeepBackup    bool
+	Prompt        bool
+	input         *bufio.Reader
+	output        io.Writer
+}
+
+// Markers around removed, added and unchanged text of word diff, and what ends a line
+type WordDiffStyle struct {
+	OldPrefix, OldSuffix         string
+	NewPrefix, NewSuffix         string
+	ContextPrefix, ContextSuffix string
+	Newline                      string
+}
+
+// File change with its line diff - Lines is empty for binary files, which keep their contents instead
+type FileDiff struct {
+	Change     FileChange
+	Lines      []DiffLine
+	Binary     bool
+	Added      int
+	Deleted    int
+	OldContent []byte
+	NewContent []byte
+}
+
+// Candidate pair for rename/copy detection - indexes of source and destination, similarity score
+type renameCandidate struct {
+	src, dst int
+	score    int
+	sameName bool
+}
+
+// Commit with its committer date - revList orders ready commits by date
+type datedCommit struct {
+	hash string
+	time int64
+}
+
+// Max-heap of commits by date (ties broken by hash, so the order is stable)
+type commitDateHeap []datedCommit
+
+func (h commitDateHeap) Len() int { return len(h) }
+func (h commitDateHeap) Less(i, j int) bool {
+	if h[i].time != h[j].time {
+		return h[i].time > h[j].time
+	}
+	return h[i].hash < h[j].hash
+}
+func (h commitDateHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *commitDateHeap) Push(x any)   { *h = append(*h, x.(datedCommit)) }
+func (h *commitDateHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// One file from a patch - paths are relative to work tree root, empty OldPath/NewPath mean /dev/null
+type FilePatch struct {
+	OldPath  string
+	NewPath  string
+	OldMode  string
+	NewMode  string
+	IsNew    bool
+	IsDelete bool
+	IsRename bool
+	IsCopy   bool
+	Hunks    []DiffHunk
+	// Hashes of the index line, and for a binary file its hunks (none for "Binary files ... differ")
+	OldHash     string
+	NewHash     string
+	Binary      bool
+	BinaryHunks []BinaryHunk
+}
+
+// Hunk of binary patch - Data is the new content, or a delta from the old one
+type BinaryHunk struct {
+	Delta bool
+	Data  []byte
+}
+
+// File state while a patch is applied - results are kept in memory until every file patch applied cleanly
+type PatchedFile struct {
+	Mode    string
+	Content []byte
+	Deleted bool
+}
+
+// Patch mail split out of mbox - author and message for the commit, and the diff to apply
+type MailPatch struct {
+	Author  string
+	Message string
+	Patch   []byte
+}
+
+// Index extension - 4 byte signature and its raw data
+type IndexExtension struct {
+	Signature string
+	Data      []byte
+}
+
+// Cached tree (TREE index extension) of one directory - EntryCount is the number of index entries below it,
+// -1 means the directory changed and Hash can't be used
+type CacheTree struct {
+	Name       string
+	EntryCount int
+	Hash       []byte
+	Children   []*CacheTree
+}
+
+// Index as it was last read - entries are kept to find out which paths changed before the index is written back
+type IndexState struct {
+	ModTime        time.Time // Entries modified at or after this time are racily clean - stat can't be trusted
+	Entries        map[string]IndexEntry
+	CacheTree      *CacheTree
+	UntrackedCache *UntrackedCache
+	FSMonitorToken string // Token of the last fsmonitor query (FSMN extension), empty when there was none
+	Extensions     []IndexExtension
+}
+
+// Untracked cache (UNTR index extension) - untracked files of every directory, valid while stat data of the
+// directory and hashes of the ignore files that apply to it don't change
+type UntrackedCache struct {
+	Ident            string // Work tree location and system - cache from another location is not used
+	InfoExcludeStat  IndexStat
+	ExcludesFileStat IndexStat
+	InfoExcludeHash  []byte
+	ExcludesFileHash []byte
+	DirFlags         uint32
+	ExcludePerDir    string
+	Root             *UntrackedCacheDir
+	// Directories are not stat-ed - fsmonitor invalidates the ones that changed (not stored in the index)
+	UseFSMonitor bool
+}
+
+type UntrackedCacheDir struct {
+	Name        string
+	Valid       bool
+	CheckOnly   bool // Directory is untracked - it was only listed to find out whether it is empty
+	Stat        IndexStat
+	ExcludeHash []byte // Hash of .gitignore in the directory, nil when there is none
+	Untracked   []string
+	Dirs        []*UntrackedCacheDir
+}
+
+// Sparse checkout patterns (.git/info/sparse-checkout) - in cone mode only directories are listed: files in
+// Recursive directories (and everything below them) are checked out, for Parents only their direct files are
+type SparseCheckout struct {
+	Cone      bool
+	Recursive map[string]bool
+	Parents   map[string]bool
+	Patterns  []IgnorePattern
+}
+
+type SparseCheckoutOptions struct {
+	Command     string // init, set, list or disable
+	Directories []string
+	// --cone / --no-cone - without either the current mode is kept (cone for a new sparse checkout)
+	Cone   bool
+	NoCone bool
+}
+
+// Options of merge command - Commits are merged into HEAD (names as given); Strategy is recursive (default)
+// or ours, StrategyOptions are -X values
+type MergeOptions struct {
+	Commits         []string
+	Strategy        string
+	StrategyOptions []string
+	Message         string
+	// Merge commits without a common ancestor (the base is an empty tree)
+	AllowUnrelatedHistories bool
+	// "ff" fast-forwards when possible, "no" always creates a merge commit, "only" refuses to do anything but
+	// fast-forward ("" - as merge.ff config says)
+	FastForward string
+	// Leave the merge result in index and work tree (HEAD doesn't move), .git/SQUASH_MSG lists merged commits
+	Squash bool
+}
+
+// How one tree merge resolves what it finds - Favor is "ours" or "theirs" for conflicting hunks resolved in
+// favor of that side ("" leaves them as conflicts), RenameScore is the similarity renames need (diffMaxScore
+// units), ConflictStyle is merge, diff3 or zdiff3; labels name the sides in conflict markers and messages
+type MergeSettings struct {
+	Favor         string
+	Renames       bool
+	RenameScore   int
+	ConflictStyle string
+	OursLabel     string
+	TheirsLabel   string
+	BaseLabel     string
+}
+
+// Path the merge couldn't resolve - Kind is content, add/add, modify/delete, rename/delete, rename/rename or
+// rename/add; Base, Ours and Theirs are versions of the file (nil when the side doesn't have it)
+type MergeConflict struct {
+	Path   string
+	Kind   string
+	Base   *TreeEntry
+	Ours   *TreeEntry
+	Theirs *TreeEntry
+}
+
+// Result of tree merge - Files are the merged files (conflicted ones with conflict markers, or the version
+// left in the tree), Messages what the merge has to say about paths ("Auto-merging", "CONFLICT ...")
+type MergeResult struct {
+	Files     map[string]TreeEntry
+	Conflicts []MergeConflict
+	Messages  []MergeMessage
+}
+
+type MergeMessage struct {
+	Path string
+	Text string
+}
+
+// Part of three-way merged text - lines unchanged on all sides, lines one side changed (or both the same
+// way, taken from Ours) or a conflict
+type mergeHunk struct {
+	conflict bool
+	stable   bool
+	base     []string
+	ours     []string
+	theirs   []string
+}
+
+// Options of rebase - commits of HEAD not in Upstream are replayed on Onto (Upstream when empty);
+// Interactive lets the user edit the todo list first
+type RebaseOptions struct {
+	Upstream    string
+	Onto        string
+	Interactive bool
+}
+
+// Options of cherry-pick - Commits are revisions or ranges ("a..b", "^a b"), picked oldest first;
+// NoCommit applies the changes to index and work tree without committing; Mainline (1-based) is the
+// parent merge commits are picked against
+type CherryPickOptions struct {
+	Commits  []string
+	NoCommit bool
+	Mainline int
+}
+
+// Options of revert - same as cherry-pick, the changes of Commits are undone instead
+type RevertOptions struct {
+	Commits  []string
+	NoCommit bool
+	Mainline int
+}
+
+// How pickCommit applies a commit - Operation names the command in errors, FastForward reuses a commit
+// whose parent is HEAD, NoCommit leaves the changes in index and work tree, Mainline selects the parent of
+// a merge commit, Revert undoes the changes
+type pickOptions struct {
+	Operation   string
+	FastForward bool
+	NoCommit    bool
+	Mainline    int
+	Revert      bool
+}
@@ -0,0 +1,471 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Untracked cache (UNTR index extension) - status doesn't have to read directories that didn't change:
+//
+//	varint ident length + ident ("Location <work tree>, system <sysname>" NUL)
+//	stat data of .git/info/exclude + stat data of core.excludesFile (36 bytes each, as in index entries)
+//	4 byte dir flags + hash of info/exclude + hash of core.excludesFile + per directory ignore file name NUL
+//	varint number of directories, then every directory (depth first, subdirectories sorted by name):
+//	    varint untracked count + varint subdirectory count + name NUL + untracked names (NUL terminated)
+//	EWAH bitmaps (bit per directory): valid, check only, has .gitignore hash
+//	stat data of every valid directory, hash of .gitignore of every directory that has one, NUL
+//
+// Directory is valid while its stat data and .gitignore hash are the same - new or deleted files change
+// the directory mtime. Untracked directories are listed as "name/" (they are only read to check they aren't empty).
+
+// Status shows untracked directories as a whole and hides empty ones
+const untrackedCacheDirFlags = 1<<1 | 1<<2
+
+// Ident of this work tree - cache written for another location (or system) is not used
+func untrackedCacheIdent() string {
+	workTree := absolutePath(workTreePath())
+	if resolved, err := filepath.EvalSymlinks(workTree); err == nil {
+		workTree = resolved
+	}
+	return fmt.Sprintf("Location %s, system %s\x00", workTree, systemName())
+}
+
+func parseUntrackedCache(data []byte) (*UntrackedCache, error) {
+	cache := &UntrackedCache{}
+	identLength, offset, err := readIndexVarint(data, 0)
+	if err != nil || offset+identLength > len(data) {
+		return nil, fmt.Errorf("ident is truncated")
+	}
+	cache.Ident = string(data[offset : offset+identLength])
+	offset += identLength
+
+	if offset+2*36+4+40 > len(data) {
+		return nil, fmt.Errorf("header is truncated")
+	}
+	cache.InfoExcludeStat = decodeIndexStat(data[offset:])
+	cache.ExcludesFileStat = decodeIndexStat(data[offset+36:])
+	cache.DirFlags = binary.BigEndian.Uint32(data[offset+72:])
+	cache.InfoExcludeHash = nonZeroHash(data[offset+76 : offset+96])
+	cache.ExcludesFileHash = nonZeroHash(data[offset+96 : offset+116])
+	offset += 116
+
+	end := bytes.IndexByte(data[offset:], 0)
+	if end == -1 {
+		return nil, fmt.Errorf("ignore file name is not terminated")
+	}
+	cache.ExcludePerDir = string(data[offset : offset+end])
+	offset += end + 1
+
+	dirCount, offset, err := readIndexVarint(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if dirCount == 0 {
+		return cache, nil
+	}
+
+	// Directories in depth first order - bitmaps and stat data refer to this order
+	var dirs []*UntrackedCacheDir
+	var readDir func() (*UntrackedCacheDir, error)
+	readDir = func() (*UntrackedCacheDir, error) {
+		untrackedCount, next, err := readIndexVarint(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		subdirCount, next, err := readIndexVarint(data, next)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+
+		names := make([]string, 0, untrackedCount+1)
+		for i := 0; i <= untrackedCount; i++ {
+			end := bytes.IndexByte(data[offset:], 0)
+			if end == -1 {
+				return nil, fmt.Errorf("directory entry is not terminated")
+			}
+			names = append(names, string(data[offset:offset+end]))
+			offset += end + 1
+		}
+		dir := &UntrackedCacheDir{Name: names[0], Untracked: names[1:]}
+		dirs = append(dirs, dir)
+
+		for i := 0; i < subdirCount; i++ {
+			child, err := readDir()
+			if err != nil {
+				return nil, err
+			}
+			dir.Dirs = append(dir.Dirs, child)
+		}
+		return dir, nil
+	}
+	if cache.Root, err = readDir(); err != nil {
+		return nil, err
+	}
+	if len(dirs) != dirCount {
+		return nil, fmt.Errorf("expected %d directories, found %d", dirCount, len(dirs))
+	}
+
+	var bitmaps [3][]bool
+	for i := range bitmaps {
+		bits, size, err := decodeEwahBitmap(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		bitmaps[i] = bits
+		offset += size
+	}
+	valid, checkOnly, hashValid := bitmaps[0], bitmaps[1], bitmaps[2]
+
+	for i, dir := range dirs {
+		dir.CheckOnly = i < len(checkOnly) && checkOnly[i]
+		if i < len(valid) && valid[i] {
+			if offset+36 > len(data) {
+				return nil, fmt.Errorf("stat data is truncated")
+			}
+			dir.Valid = true
+			dir.Stat = decodeIndexStat(data[offset:])
+			offset += 36
+		} else {
+			dir.Untracked = nil
+		}
+	}
+	for i, dir := range dirs {
+		if i < len(hashValid) && hashValid[i] {
+			if offset+20 > len(data) {
+				return nil, fmt.Errorf("ignore file hash is truncated")
+			}
+			dir.ExcludeHash = append([]byte(nil), data[offset:offset+20]...)
+			offset += 20
+		}
+	}
+	return cache, nil
+}
+
+func writeUntrackedCache(buf *bytes.Buffer, cache *UntrackedCache) {
+	buf.Write(encodeIndexVarint(len(cache.Ident)))
+	buf.WriteString(cache.Ident)
+	buf.Write(encodeIndexStat(cache.InfoExcludeStat))
+	buf.Write(encodeIndexStat(cache.ExcludesFileStat))
+	binary.Write(buf, binary.BigEndian, cache.DirFlags)
+	buf.Write(hashOrZero(cache.InfoExcludeHash))
+	buf.Write(hashOrZero(cache.ExcludesFileHash))
+	buf.WriteString(cache.ExcludePerDir)
+	buf.WriteByte(0)
+
+	if cache.Root == nil {
+		buf.Write(encodeIndexVarint(0))
+		return
+	}
+
+	var dirs bytes.Buffer
+	var valid, checkOnly, hashValid []bool
+	var stats, hashes bytes.Buffer
+	var writeDir func(dir *UntrackedCacheDir)
+	writeDir = func(dir *UntrackedCacheDir) {
+		if !dir.Valid {
+			dir.Untracked, dir.CheckOnly = nil, false
+		}
+		valid = append(valid, dir.Valid)
+		checkOnly = append(checkOnly, dir.CheckOnly)
+		hashValid = append(hashValid, dir.ExcludeHash != nil)
+		if dir.Valid {
+			stats.Write(encodeIndexStat(dir.Stat))
+		}
+		if dir.ExcludeHash != nil {
+			hashes.Write(dir.ExcludeHash)
+		}
+
+		sort.Strings(dir.Untracked)
+		sort.Slice(dir.Dirs, func(i, j int) bool { return dir.Dirs[i].Name < dir.Dirs[j].Name })
+		dirs.Write(encodeIndexVarint(len(dir.Untracked)))
+		dirs.Write(encodeIndexVarint(len(dir.Dirs)))
+		dirs.WriteString(dir.Name)
+		dirs.WriteByte(0)
+		for _, name := range dir.Untracked {
+			dirs.WriteString(name)
+			dirs.WriteByte(0)
+		}
+		for _, child := range dir.Dirs {
+			writeDir(child)
+		}
+	}
+	writeDir(cache.Root)
+
+	buf.Write(encodeIndexVarint(len(valid)))
+	buf.Write(dirs.Bytes())
+	buf.Write(encodeEwahBitmap(valid))
+	buf.Write(encodeEwahBitmap(checkOnly))
+	buf.Write(encodeEwahBitmap(hashValid))
+	buf.Write(stats.Bytes())
+	buf.Write(hashes.Bytes())
+	buf.WriteByte(0)
+}
+
+func decodeIndexStat(data []byte) IndexStat {
+	return IndexStat{
+		CTimeSeconds:     binary.BigEndian.Uint32(data[0:4]),
+		CTimeNanoseconds: binary.BigEndian.Uint32(data[4:8]),
+		MTimeSeconds:     binary.BigEndian.Uint32(data[8:12]),
+		MTimeNanoseconds: binary.BigEndian.Uint32(data[12:16]),
+		Dev:              binary.BigEndian.Uint32(data[16:20]),
+		Ino:              binary.BigEndian.Uint32(data[20:24]),
+		UID:              binary.BigEndian.Uint32(data[24:28]),
+		GID:              binary.BigEndian.Uint32(data[28:32]),
+		Size:             binary.BigEndian.Uint32(data[32:36]),
+	}
+}
+
+func encodeIndexStat(stat IndexStat) []byte {
+	data := make([]byte, 36)
+	for i, value := range []uint32{stat.CTimeSeconds, stat.CTimeNanoseconds, stat.MTimeSeconds, stat.MTimeNanoseconds,
+		stat.Dev, stat.Ino, stat.UID, stat.GID, stat.Size} {
+		binary.BigEndian.PutUint32(data[i*4:], value)
+	}
+	return data
+}
+
+// Hash of stored data - all zero hash means there is none
+func nonZeroHash(hash []byte) []byte {
+	if bytes.Equal(hash, make([]byte, 20)) {
+		return nil
+	}
+	return append([]byte(nil), hash...)
+}
+
+func hashOrZero(hash []byte) []byte {
+	if hash == nil {
+		return make([]byte, 20)
+	}
+	return hash
+}
+
+// Stat data and hash of ignore file - zero stat and nil hash when it doesn't exist
+// Git hashes the content with a newline appended (as it parses it), only an empty file is the empty blob
+func ignoreFileState(filePath string) (IndexStat, []byte) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return IndexStat{}, nil
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return IndexStat{}, nil
+	}
+	if len(content) > 0 {
+		content = append(content, '\n')
+	}
+	return indexStatFromFileInfo(info), hashObject(generateObjectByte("blob", content))
+}
+
+// Untracked cache to use for status - core.untrackedCache "true" creates it, "false" drops it, and by default
+// ("keep") the cache from the index is used if there is one. Cache from another work tree location, or built
+// with different ignore files, is started over.
+func prepareUntrackedCache(state *IndexState) (*UntrackedCache, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	mode, _ := config.Get("core.untrackedCache")
+	switch strings.ToLower(mode) {
+	case "", "keep":
+		if state.UntrackedCache == nil || state.UntrackedCache.Ident != untrackedCacheIdent() {
+			return nil, nil
+		}
+	default:
+		if !parseBoolValue(mode, false) {
+			state.UntrackedCache = nil
+			return nil, nil
+		}
+	}
+
+	cache := state.UntrackedCache
+	if cache == nil || cache.Ident != untrackedCacheIdent() || cache.DirFlags != untrackedCacheDirFlags {
+		cache = &UntrackedCache{Ident: untrackedCacheIdent(), DirFlags: untrackedCacheDirFlags, ExcludePerDir: ".gitignore"}
+	}
+
+	infoExcludeStat, infoExcludeHash := ignoreFileState(gitDirPath("info", "exclude"))
+	var excludesFileStat IndexStat
+	var excludesFileHash []byte
+	if excludesFile, ok := config.Get("core.excludesFile"); ok {
+		excludesFileStat, excludesFileHash = ignoreFileState(expandConfigPath(excludesFile))
+	}
+	if !bytes.Equal(infoExcludeHash, cache.InfoExcludeHash) || !bytes.Equal(excludesFileHash, cache.ExcludesFileHash) {
+		// Global ignore rules changed - every directory has to be read again
+		cache.Root = nil
+	}
+	cache.InfoExcludeStat, cache.InfoExcludeHash = infoExcludeStat, infoExcludeHash
+	cache.ExcludesFileStat, cache.ExcludesFileHash = excludesFileStat, excludesFileHash
+	if cache.Root == nil {
+		cache.Root = &UntrackedCacheDir{}
+	}
+
+	state.UntrackedCache = cache
+	return cache, nil
+}
+
+// Mark directories on the way to path (and the one containing it) as changed - a file there was added to or
+// removed from the index, or fsmonitor reported it
+func (cache *UntrackedCache) invalidate(path string) {
+	if cache == nil {
+		return
+	}
+	for dir := cache.Root; dir != nil; {
+		dir.Valid = false
+		dir.Untracked = nil
+		name, rest, isDir := strings.Cut(path, "/")
+		if !isDir {
+			return
+		}
+		dir, path = dir.child(name), rest
+	}
+}
+
+// Subdirectory with given name, nil when there is none
+func (dir *UntrackedCacheDir) child(name string) *UntrackedCacheDir {
+	for _, child := range dir.Dirs {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Mark directory and everything below it as changed - ignore rules that apply to them changed
+func (dir *UntrackedCacheDir) invalidateAll() {
+	dir.Valid = false
+	dir.Untracked = nil
+	for _, child := range dir.Dirs {
+		child.invalidateAll()
+	}
+}
+
+// Collect untracked paths like collectUntracked (without ignored paths) - only directories that changed since
+// the cache was built are read. Returns whether the cache was updated.
+func collectUntrackedCached(cache *UntrackedCache, tracked, trackedDirs map[string]bool, matcher *IgnoreMatcher,
+	indexModTime time.Time) ([]string, bool, error) {
+	changed := false
+
+	var collect func(dirPath string, dir *UntrackedCacheDir, checkOnly bool) ([]string, error)
+	collect = func(dirPath string, dir *UntrackedCacheDir, checkOnly bool) ([]string, error) {
+		// .gitignore of the directory applies to everything below it
+		_, ignoreHash := ignoreFileState(workTreePath(filepath.FromSlash(path.Join(dirPath, ".gitignore"))))
+		if !bytes.Equal(ignoreHash, dir.ExcludeHash) {
+			dir.invalidateAll()
+			dir.ExcludeHash = ignoreHash
+			changed = true
+		}
+
+		valid := dir.Valid && dir.CheckOnly == checkOnly
+		var stat IndexStat
+		if !valid || !cache.UseFSMonitor {
+			info, err := os.Lstat(workTreePath(filepath.FromSlash(dirPath)))
+			if err != nil {
+				return nil, err
+			}
+			stat = indexStatFromFileInfo(info)
+			valid = valid && statUnchanged(IndexEntry{Stat: dir.Stat}, stat, indexModTime)
+		}
+
+		if !valid {
+			if err := readUntrackedCacheDir(dirPath, dir, tracked, trackedDirs, matcher); err != nil {
+				return nil, err
+			}
+			dir.Valid, dir.CheckOnly, dir.Stat = true, checkOnly, stat
+			changed = true
+		}
+
+		// Untracked files come from the cache, subdirectories are checked on their own - an untracked
+		// directory is listed only while something in it is untracked
+		var untracked []string
+		var names []string
+		for _, name := range dir.Untracked {
+			if !strings.HasSuffix(name, "/") {
+				names = append(names, name)
+				untracked = append(untracked, path.Join(dirPath, name))
+			}
+		}
+		for _, child := range dir.Dirs {
+			childPath := path.Join(dirPath, child.Name)
+			childUntracked, err := collect(childPath, child, !trackedDirs[childPath])
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if trackedDirs[childPath] {
+				untracked = append(untracked, childUntracked...)
+			} else if len(childUntracked) > 0 {
+				names = append(names, child.Name+"/")
+				untracked = append(untracked, childPath+"/")
+			}
+		}
+		if !equalStrings(names, dir.Untracked) {
+			dir.Untracked = names
+			changed = true
+		}
+		return untracked, nil
+	}
+
+	untracked, err := collect("", cache.Root, false)
+	if err != nil {
+		return nil, false, err
+	}
+	sort.Strings(untracked)
+	return untracked, changed, nil
+}
+
+// Read directory into cache entry - untracked files and subdirectories that are not ignored (their content is
+// checked by the caller)
+func readUntrackedCacheDir(dirPath string, dir *UntrackedCacheDir, tracked, trackedDirs map[string]bool, matcher *IgnoreMatcher) error {
+	dirEntries, err := os.ReadDir(workTreePath(filepath.FromSlash(dirPath)))
+	if err != nil {
+		return err
+	}
+
+	previous := dir.Dirs
+	dir.Dirs, dir.Untracked = nil, nil
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		relPath := path.Join(dirPath, name)
+		if name == ".git" || tracked[relPath] {
+			continue
+		}
+		if matcher.isIgnored(relPath, dirEntry.IsDir()) {
+			continue
+		}
+		if !dirEntry.IsDir() {
+			dir.Untracked = append(dir.Untracked, name)
+			continue
+		}
+
+		child := &UntrackedCacheDir{Name: name}
+		for _, existing := range previous {
+			if existing.Name == name {
+				child = existing
+			}
+		}
+		dir.Dirs = append(dir.Dirs, child)
+	}
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
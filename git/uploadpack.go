@@ -0,0 +1,315 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// upload-pack - server side of fetch/clone (protocol v0, one request per connection):
+//  1. advertise refs (HEAD first, capabilities after NUL on the first line), flush
+//  2. read "want" lines (+ "shallow"/"deepen"/"filter"), flush
+//  3. for shallow requests send shallow-update section ("shallow <hash>" lines), flush
+//  4. read "have" lines until "done", answer NAK (we never look for common commits)
+//  5. send pack - over side-band-64k if client asked for it
+//
+// The pack is not deltified - objects are stored whole.
+//
+// Smart HTTP is stateless - GET /info/refs only advertises refs (--advertise-refs), and every POST carries
+// one whole request (wants, haves, done) that is answered without advertising refs first (--stateless-rpc).
+
+const uploadPackCapabilities = "ofs-delta side-band-64k shallow filter agent=mini-git"
+
+// Largest side-band payload - pkt-line limit (65520) minus length and channel byte
+const sidebandChunkSize = 65515
+
+// Serve upload-pack for repository in options.Directory, reading requests from input and answering to output
+func (c *RepoContext) uploadPack(options UploadPackOptions, input io.Reader, output io.Writer) error {
+	repo, err := c.openServedRepository(options.Directory)
+	if err != nil {
+		return err
+	}
+	defer repo.enter()()
+	defer ignoreReplaceRefs()()
+	if traceWriter("GIT_TRACE_PACKET") != nil {
+		input = &PacketTraceReader{ReadCloser: io.NopCloser(input), tracer: &PacketTracer{Direction: '<'}}
+		output = &PacketTraceWriter{Writer: output, tracer: &PacketTracer{Direction: '>'}}
+	}
+
+	if options.AdvertiseRefs || !options.StatelessRPC {
+		if err := writeRefsAdvertisement(output); err != nil {
+			return err
+		}
+	}
+	if options.AdvertiseRefs {
+		return nil
+	}
+	return answerUploadPackRequest(bufio.NewReader(input), output)
+}
+
+// Read one request (wants, then haves until done) and send shallow update, NAK and the pack
+func answerUploadPackRequest(reader *bufio.Reader, output io.Writer) error {
+	wants, capabilities, depth, filter, err := readWants(reader)
+	if err != nil {
+		return err
+	}
+	if len(wants) == 0 {
+		// Client only wanted to see the refs
+		return nil
+	}
+
+	objects, shallow, err := collectUploadObjects(wants, depth, filter)
+	if err != nil {
+		return err
+	}
+
+	if depth > 0 {
+		for _, hash := range shallow {
+			writePktLine(output, "shallow "+hash+"\n")
+		}
+		io.WriteString(output, "0000")
+	}
+
+	// Haves don't matter - we send everything that is reachable from wants
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if kind == PKT_DATA && strings.TrimSpace(string(payload)) == "done" {
+			break
+		}
+	}
+	writePktLine(output, "NAK\n")
+
+	pack := buildPackData(objects, loadPackCompression(capabilities["ofs-delta"]))
+	if !capabilities["side-band-64k"] {
+		_, err := output.Write(pack)
+		return err
+	}
+
+	writeSidebandPacket(output, 2, []byte(fmt.Sprintf("Enumerating objects: %d, done.\n", len(objects))))
+	for start := 0; start < len(pack); start += sidebandChunkSize {
+		end := min(start+sidebandChunkSize, len(pack))
+		writeSidebandPacket(output, 1, pack[start:end])
+	}
+	_, err = io.WriteString(output, "0000")
+	return err
+}
+
+// Repository served in directory - directory with .git inside, or a bare repository (HEAD and objects
+// directly in it)
+func (c *RepoContext) openServedRepository(directory string) (*Repository, error) {
+	dir := c.path(directory)
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+		return &Repository{layout: c.newRepoLayout(filepath.Join(dir, ".git"), dir, false), dir: dir, context: c}, nil
+	}
+	if !isGitDirectory(dir) {
+		return nil, fmt.Errorf("%w: '%s'", ErrNotARepository, directory)
+	}
+	return &Repository{layout: c.newRepoLayout(dir, dir, true), dir: dir, context: c}, nil
+}
+
+// Write refs advertisement - HEAD, then every ref by name, annotated tags followed by their peeled value
+func writeRefsAdvertisement(output io.Writer) error {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return err
+	}
+	headBranch, headHash, err := readHead()
+	if err != nil {
+		return err
+	}
+
+	capabilities := uploadPackCapabilities
+	if headBranch != "" && headHash != "" {
+		capabilities += " symref=HEAD:" + headBranch
+	}
+
+	var lines []string
+	if headHash != "" {
+		lines = append(lines, headHash+" HEAD")
+	}
+	for _, name := range sortedKeys(refs) {
+		lines = append(lines, refs[name]+" "+name)
+		if strings.HasPrefix(name, "refs/tags/") {
+			if peeled, err := peelTag(refs[name]); err == nil && peeled != "" {
+				lines = append(lines, peeled+" "+name+"^{}")
+			}
+		}
+	}
+	if len(lines) == 0 {
+		// Empty repository still has to advertise its capabilities
+		lines = append(lines, strings.Repeat("0", 40)+" capabilities^{}")
+	}
+
+	for i, line := range lines {
+		if i == 0 {
+			line += "\x00" + capabilities
+		}
+		writePktLine(output, line+"\n")
+	}
+	_, err = io.WriteString(output, "0000")
+	return err
+}
+
+// Read want section - wanted hashes, capabilities from the first want line, depth and filter
+func readWants(reader *bufio.Reader) ([]string, map[string]bool, int, string, error) {
+	var wants []string
+	capabilities := make(map[string]bool)
+	depth := 0
+	filter := ""
+
+	for {
+		payload, kind, err := readPktPayload(reader)
+		if err == io.EOF {
+			return wants, capabilities, depth, filter, nil
+		} else if err != nil {
+			return nil, nil, 0, "", err
+		}
+		if kind == PKT_FLUSH {
+			return wants, capabilities, depth, filter, nil
+		}
+
+		fields := strings.Fields(string(payload))
+		if len(fields) < 2 {
+			return nil, nil, 0, "", fmt.Errorf("protocol error: unexpected line %q", payload)
+		}
+		switch fields[0] {
+		case "want":
+			if len(wants) == 0 {
+				for _, capability := range fields[2:] {
+					capabilities[capability] = true
+				}
+			}
+			wants = append(wants, fields[1])
+		case "deepen":
+			depth, err = strconv.Atoi(fields[1])
+			if err != nil || depth < 1 {
+				return nil, nil, 0, "", fmt.Errorf("protocol error: invalid depth %s", fields[1])
+			}
+		case "filter":
+			filter = fields[1]
+			if err := ValidateFilterSpec(filter); err != nil {
+				return nil, nil, 0, "", err
+			}
+		case "shallow":
+			// Client's shallow commits - everything below wants is sent anyway
+		default:
+			return nil, nil, 0, "", fmt.Errorf("protocol error: unexpected line %q", payload)
+		}
+	}
+}
+
+// Collect every object reachable from wants - history is cut after depth commits (if depth > 0),
+// blobs not matching filter are left out. Returns objects and shallow commits (whose parents are not sent)
+func collectUploadObjects(wants []string, depth int, filter string) ([]GitObject, []string, error) {
+	defer tracePerformance("collect objects")()
+	if depth == 0 && filter == "" {
+		if hashes, ok, err := bitmapObjects(wants, nil); err != nil {
+			return nil, nil, err
+		} else if ok {
+			objects, err := readGitObjects(hashes)
+			return objects, nil, err
+		}
+	}
+	var err error
+	blobLimit := int64(-1)
+	if filter == "blob:none" {
+		blobLimit = 0
+	} else if limit, ok := strings.CutPrefix(filter, "blob:limit="); ok {
+		if blobLimit, err = parseSizeValue(limit); err != nil {
+			return nil, nil, fmt.Errorf("invalid filter: %s", filter)
+		}
+	}
+
+	type pending struct {
+		hash  string
+		level int
+		path  string
+	}
+	queue := make([]pending, 0, len(wants))
+	for _, hash := range wants {
+		queue = append(queue, pending{hash, 1, ""})
+	}
+
+	seen := make(map[string]bool)
+	var objects []GitObject
+	shallowSet := make(map[string]bool)
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if seen[item.hash] {
+			continue
+		}
+		seen[item.hash] = true
+
+		typeName, _, content, err := readObjectFromHash(item.hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read object %s: %w", item.hash, err)
+		}
+		objType, err := ObjectTypeFromString(typeName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch objType {
+		case OBJ_BLOB:
+			if blobLimit >= 0 && int64(len(content)) > blobLimit {
+				continue
+			}
+		case OBJ_COMMIT, OBJ_TAG:
+			for _, line := range strings.Split(string(content), "\n") {
+				if line == "" {
+					break
+				}
+				key, value, _ := strings.Cut(line, " ")
+				switch {
+				case key == "tree" || key == "object":
+					queue = append(queue, pending{value, item.level, ""})
+				case key == "parent" && depth > 0 && item.level >= depth:
+					// Parent is below requested depth - this commit becomes shallow
+					shallowSet[item.hash] = true
+				case key == "parent":
+					queue = append(queue, pending{value, item.level + 1, ""})
+				}
+			}
+		case OBJ_TREE:
+			entries, err := parseTreeContent(content)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, entry := range entries {
+				// Gitlinks point to another repository, blobs are skipped without reading if filter drops all of them
+				if entry.Mode == "160000" || (blobLimit == 0 && entry.Mode != "40000") {
+					continue
+				}
+				queue = append(queue, pending{entry.Hash, item.level, path.Join(item.path, entry.Name)})
+			}
+		}
+
+		objects = append(objects, GitObject{Type: objType, Data: content, Hash: item.hash, Path: item.path})
+	}
+
+	var shallow []string
+	for hash := range shallowSet {
+		shallow = append(shallow, hash)
+	}
+	sort.Strings(shallow)
+	return objects, shallow, nil
+}
+
+// Write one side-band packet - channel byte followed by data
+func writeSidebandPacket(output io.Writer, channel byte, data []byte) {
+	fmt.Fprintf(output, "%04x", len(data)+5)
+	output.Write([]byte{channel})
+	output.Write(data)
+}
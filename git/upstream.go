@@ -0,0 +1,205 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Upstream (tracking) branches - branch.<name>.remote and branch.<name>.merge name the branch a local
+// branch follows: a remote and the branch there (refs/heads/<branch>). Its remote-tracking ref, which the
+// remote's fetch refspecs map it to (refs/remotes/<remote>/<branch>), is compared with the local branch -
+// status and branch -v report commits each side has that the other doesn't:
+//
+//	Your branch is ahead of 'origin/main' by 2 commits.          [origin/main: ahead 2, behind 1]
+//
+// Remote "." is the repository itself - merge names a local branch then. Clone sets up the checked out
+// branch to track its remote branch, and so does creating a branch from a remote-tracking branch.
+
+// Remote-tracking ref of branch's upstream ("" when branch has none) and the upstream's short name
+// (origin/main) - the ref may not exist (the upstream is gone)
+func branchUpstream(config *Config, branch string) (string, string) {
+	name := strings.TrimPrefix(branch, "refs/heads/")
+	remote, _ := config.Get("branch." + name + ".remote")
+	merge, _ := config.Get("branch." + name + ".merge")
+	if remote == "" || merge == "" {
+		return "", ""
+	}
+	if remote == "." {
+		return merge, shortRefName(merge)
+	}
+	specs, err := parseRefspecs(config.GetAll("remote." + remote + ".fetch"))
+	if err != nil {
+		return "", ""
+	}
+	if tracking, _ := mapRefThroughRefspecs(specs, merge); tracking != "" {
+		return tracking, shortRefName(tracking)
+	}
+	return "", ""
+}
+
+// Name of ref without refs/heads/, refs/remotes/ or refs/tags/
+func shortRefName(refName string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/", "refs/tags/"} {
+		if name, ok := strings.CutPrefix(refName, prefix); ok {
+			return name
+		}
+	}
+	return refName
+}
+
+// Tracking state of branch - nil when it has no upstream
+func branchTracking(branch string) (*BranchTracking, error) {
+	if branch == "" {
+		return nil, nil
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	trackingRef, upstream := branchUpstream(config, branch)
+	if trackingRef == "" {
+		return nil, nil
+	}
+	tracking := &BranchTracking{Upstream: upstream}
+	local, err := resolveRef(branch)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := resolveRef(trackingRef)
+	if err != nil {
+		return nil, err
+	}
+	if remote == "" {
+		tracking.Gone = true
+		return tracking, nil
+	}
+	if local == "" {
+		return tracking, nil
+	}
+
+	ahead, err := revList([]string{local}, []string{remote})
+	if err != nil {
+		return nil, err
+	}
+	behind, err := revList([]string{remote}, []string{local})
+	if err != nil {
+		return nil, err
+	}
+	tracking.Ahead, tracking.Behind = len(ahead), len(behind)
+	return tracking, nil
+}
+
+// Write tracking state the way long status does
+func writeTrackingStatus(tracking *BranchTracking, w io.Writer) {
+	commits := func(n int) string {
+		if n == 1 {
+			return "1 commit"
+		}
+		return fmt.Sprintf("%d commits", n)
+	}
+	switch {
+	case tracking.Gone:
+		fmt.Fprintf(w, "Your branch is based on '%s', but the upstream is gone.\n", tracking.Upstream)
+	case tracking.Ahead > 0 && tracking.Behind > 0:
+		fmt.Fprintf(w, "Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.\n",
+			tracking.Upstream, tracking.Ahead, tracking.Behind)
+	case tracking.Ahead > 0:
+		fmt.Fprintf(w, "Your branch is ahead of '%s' by %s.\n", tracking.Upstream, commits(tracking.Ahead))
+	case tracking.Behind > 0:
+		fmt.Fprintf(w, "Your branch is behind '%s' by %s, and can be fast-forwarded.\n", tracking.Upstream, commits(tracking.Behind))
+	default:
+		fmt.Fprintf(w, "Your branch is up to date with '%s'.\n", tracking.Upstream)
+	}
+}
+
+// Short tracking state - "ahead 1, behind 2", "gone" or "" when branch and upstream are the same
+func (tracking *BranchTracking) summary() string {
+	if tracking.Gone {
+		return "gone"
+	}
+	var parts []string
+	if tracking.Ahead > 0 {
+		parts = append(parts, fmt.Sprintf("ahead %d", tracking.Ahead))
+	}
+	if tracking.Behind > 0 {
+		parts = append(parts, fmt.Sprintf("behind %d", tracking.Behind))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Make branch track upstream - a remote-tracking branch (the remote whose fetch refspec maps to it is
+// recorded) or a local branch (remote "."). Reports the new upstream to w.
+func setUpstream(branch, upstream string, w io.Writer) error {
+	if exists, err := resolveRef("refs/heads/" + branch); err != nil {
+		return err
+	} else if exists == "" {
+		return fmt.Errorf("branch '%s' does not exist", branch)
+	}
+	refName, _, err := resolveRevision(upstream)
+	if err != nil || refName == "" {
+		return fmt.Errorf("the requested upstream branch '%s' does not exist", upstream)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	remote, merge := "", ""
+	if strings.HasPrefix(refName, "refs/heads/") {
+		remote, merge = ".", refName
+	} else {
+		// Remote-tracking ref is mapped back to the remote branch through the fetch refspecs
+		for _, name := range config.Subsections("remote") {
+			specs, err := parseRefspecs(config.GetAll("remote." + name + ".fetch"))
+			if err != nil {
+				return err
+			}
+			for _, spec := range specs {
+				if remoteRef, ok := spec.mapDestination(refName); ok && !excludedByRefspecs(specs, remoteRef) {
+					remote, merge = name, remoteRef
+					break
+				}
+			}
+			if remote != "" {
+				break
+			}
+		}
+	}
+	if remote == "" {
+		return fmt.Errorf("cannot set up tracking information; starting point '%s' is not a branch", upstream)
+	}
+	if err := writeUpstreamConfig(branch, remote, merge); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "branch '%s' set up to track '%s'.\n", branch, shortRefName(refName))
+	return nil
+}
+
+// Record upstream of branch in repository config
+func writeUpstreamConfig(branch, remote, merge string) error {
+	configPath := gitDirPath("config")
+	if err := setConfigValue(configPath, "branch."+branch+".remote", remote); err != nil {
+		return fmt.Errorf("failed to write branch config: %w", err)
+	}
+	if err := setConfigValue(configPath, "branch."+branch+".merge", merge); err != nil {
+		return fmt.Errorf("failed to write branch config: %w", err)
+	}
+	return nil
+}
+
+// Forget upstream of branch
+func unsetUpstream(branch string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Get("branch." + branch + ".merge"); !ok {
+		return fmt.Errorf("branch '%s' has no upstream information", branch)
+	}
+	configPath := gitDirPath("config")
+	if err := unsetConfigValue(configPath, "branch."+branch+".remote"); err != nil {
+		return err
+	}
+	return unsetConfigValue(configPath, "branch."+branch+".merge")
+}
@@ -0,0 +1,212 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Word diff - removed and added lines of every change are diffed word by word, and the new text is shown
+// with removed and added words marked (git's --word-diff / --color-words):
+//
+//	plain      hello {+brave+} world [-foo-]{+bar+}
+//	color      same, removed and added words colored (red and green by default) instead of bracketed
+//	porcelain  one line per piece: " " unchanged, "-" removed, "+" added, "~" ends a line of the text
+//
+// Text between words comes from the new side. Words are runs of non-space characters, or matches of
+// --word-diff-regex (never crossing a line end).
+
+// Word diff styles by --word-diff mode - color marks words only with colors (see wordDiffStyle)
+var wordDiffStyles = map[string]WordDiffStyle{
+	"plain":     {OldPrefix: "[-", OldSuffix: "-]", NewPrefix: "{+", NewSuffix: "+}", Newline: "\n"},
+	"color":     {Newline: "\n"},
+	"porcelain": {OldPrefix: "-", OldSuffix: "\n", NewPrefix: "+", NewSuffix: "\n", ContextPrefix: " ", ContextSuffix: "\n", Newline: "~\n"},
+}
+
+// Resolve word diff options - mode checked, default word regex from diff.wordRegex
+func resolveWordDiffOptions(options DiffOptions) (DiffOptions, error) {
+	if options.WordDiff == "" {
+		return options, nil
+	}
+	if _, ok := wordDiffStyles[options.WordDiff]; !ok {
+		return options, fmt.Errorf("bad --word-diff argument: %s", options.WordDiff)
+	}
+	if options.WordRegex == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return options, err
+		}
+		options.WordRegex, _ = config.Get("diff.wordRegex")
+	}
+	if options.WordRegex != "" {
+		if _, err := regexp.Compile(options.WordRegex); err != nil {
+			return options, fmt.Errorf("invalid regular expression: %s", options.WordRegex)
+		}
+	}
+	return options, nil
+}
+
+// Style of word diff - with colors, markers of removed and added words are wrapped in them (and so is
+// unchanged text, when context has a color)
+func wordDiffStyle(options DiffOptions) WordDiffStyle {
+	style := wordDiffStyles[options.WordDiff]
+	if colors := options.Colors; colors != nil {
+		style.OldPrefix, style.OldSuffix = colors["old"]+style.OldPrefix, style.OldSuffix+colorReset
+		style.NewPrefix, style.NewSuffix = colors["new"]+style.NewPrefix, style.NewSuffix+colorReset
+		if colors["context"] != "" {
+			style.ContextPrefix, style.ContextSuffix = colors["context"]+style.ContextPrefix, style.ContextSuffix+colorReset
+		}
+	}
+	return style
+}
+
+// Write hunk lines as word diff - runs of removed and added lines are diffed together, unchanged lines
+// are written as they are
+func writeWordDiff(w io.Writer, lines []DiffLine, options DiffOptions) {
+	style := wordDiffStyle(options)
+	lineStyle := wordDiffStyles[options.WordDiff]
+	var wordRegex *regexp.Regexp
+	if options.WordRegex != "" {
+		wordRegex = regexp.MustCompile(options.WordRegex)
+	}
+
+	var minus, plus strings.Builder
+	flush := func() {
+		if minus.Len() > 0 || plus.Len() > 0 {
+			writeWordDiffChange(w, minus.String(), plus.String(), wordRegex, style)
+		}
+		minus.Reset()
+		plus.Reset()
+	}
+
+	for _, line := range lines {
+		// Missing newline at the end of file doesn't matter for words
+		text := line.Text
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		switch line.Kind {
+		case '-':
+			minus.WriteString(text)
+		case '+':
+			plus.WriteString(text)
+		default:
+			flush()
+			// Whole unchanged lines are colored like context lines of a line diff
+			text = lineStyle.ContextPrefix + strings.TrimSuffix(text, "\n")
+			if text != "" {
+				text = colorize(options.Colors, "context", text)
+			}
+			io.WriteString(w, text+lineStyle.ContextSuffix+lineStyle.Newline)
+		}
+	}
+	flush()
+}
+
+// Write one change (removed text, added text) with words marked
+func writeWordDiffChange(w io.Writer, minus, plus string, wordRegex *regexp.Regexp, style WordDiffStyle) {
+	if plus == "" {
+		writeWordSegment(w, minus, style.OldPrefix, style.OldSuffix, style.Newline)
+		return
+	}
+
+	minusWords := splitWords(minus, wordRegex)
+	plusWords := splitWords(plus, wordRegex)
+	a := make([]string, len(minusWords))
+	for i, word := range minusWords {
+		a[i] = minus[word[0]:word[1]]
+	}
+	b := make([]string, len(plusWords))
+	for i, word := range plusWords {
+		b[i] = plus[word[0]:word[1]]
+	}
+
+	// Every run of removed/added words is written after the new text that precedes it
+	current := 0
+	mi, pi := 0, 0
+	wordLines := diffLines(a, b)
+	for i := 0; i < len(wordLines); {
+		if wordLines[i].Kind == ' ' {
+			mi, pi, i = mi+1, pi+1, i+1
+			continue
+		}
+		minusStart, plusStart := mi, pi
+		for ; i < len(wordLines) && wordLines[i].Kind != ' '; i++ {
+			if wordLines[i].Kind == '-' {
+				mi++
+			} else {
+				pi++
+			}
+		}
+
+		// Position in new text where the change is - right after the previous word when nothing was added
+		plusBegin, plusEnd := 0, 0
+		if pi > plusStart {
+			plusBegin, plusEnd = plusWords[plusStart][0], plusWords[pi-1][1]
+		} else if plusStart > 0 {
+			plusBegin, plusEnd = plusWords[plusStart-1][1], plusWords[plusStart-1][1]
+		}
+		writeWordSegment(w, plus[current:plusBegin], style.ContextPrefix, style.ContextSuffix, style.Newline)
+		if mi > minusStart {
+			writeWordSegment(w, minus[minusWords[minusStart][0]:minusWords[mi-1][1]], style.OldPrefix, style.OldSuffix, style.Newline)
+		}
+		writeWordSegment(w, plus[plusBegin:plusEnd], style.NewPrefix, style.NewSuffix, style.Newline)
+		current = plusEnd
+	}
+	writeWordSegment(w, plus[current:], style.ContextPrefix, style.ContextSuffix, style.Newline)
+}
+
+// Write text with markers around every line of it - line ends are written as newline
+func writeWordSegment(w io.Writer, text, prefix, suffix, newline string) {
+	for text != "" {
+		piece, rest, found := strings.Cut(text, "\n")
+		if piece != "" {
+			io.WriteString(w, prefix+piece+suffix)
+		}
+		if !found {
+			return
+		}
+		io.WriteString(w, newline)
+		text = rest
+	}
+}
+
+// Word boundaries (start, end) in text - like git, a regex match is cut at the line end, and where the regex
+// doesn't match anymore words are runs of non-space characters
+func splitWords(text string, wordRegex *regexp.Regexp) [][2]int {
+	var words [][2]int
+	for position := 0; position < len(text); {
+		if wordRegex != nil {
+			if match := wordRegex.FindStringIndex(text[position:]); match != nil {
+				begin, end := position+match[0], position+match[1]
+				if newline := strings.IndexByte(text[begin:end], '\n'); newline != -1 {
+					end = begin + newline
+				}
+				if begin >= end {
+					return words
+				}
+				words = append(words, [2]int{begin, end})
+				position = end
+				continue
+			}
+		}
+
+		for position < len(text) && isSpaceByte(text[position]) {
+			position++
+		}
+		if position == len(text) {
+			break
+		}
+		begin := position
+		for position < len(text) && !isSpaceByte(text[position]) {
+			position++
+		}
+		words = append(words, [2]int{begin, position})
+	}
+	return words
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
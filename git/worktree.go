@@ -0,0 +1,1008 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Work tree commands - add, status and clean
+
+// Parse tree object content into list of entries (<mode> <name>\0<20 byte sha>)
+func parseTreeContent(content []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	i := 0
+	for i < len(content) {
+		nullIndex := bytes.IndexByte(content[i:], 0)
+		if nullIndex == -1 {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+
+		mode, name, ok := bytes.Cut(content[i:i+nullIndex], []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+		i += nullIndex + 1
+
+		if i+20 > len(content) {
+			return nil, fmt.Errorf("unexpected end of SHA")
+		}
+		entries = append(entries, TreeEntry{
+			Mode: string(mode),
+			Name: string(name),
+			Hash: hex.EncodeToString(content[i : i+20]),
+		})
+		i += 20
+	}
+
+	return entries, nil
+}
+
+// Read tree hash from commit object
+func readCommitTreeHash(commitHash string) (string, error) {
+	objType, _, content, err := readObjectFromHash(commitHash)
+	if err != nil {
+		return "", err
+	}
+	if objType != "commit" {
+		return "", fmt.Errorf("object %s is not a commit", commitHash)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "tree ") {
+			return strings.TrimPrefix(line, "tree "), nil
+		}
+		if line == "" {
+			break
+		}
+	}
+	return "", fmt.Errorf("tree hash not found in commit %s", commitHash)
+}
+
+// Recursively collects all files from tree into map (path -> entry), entry names are full paths
+func flattenTree(treeHash, prefix string, files map[string]TreeEntry) error {
+	_, _, content, err := readObjectFromHash(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %s: %w", treeHash, err)
+	}
+
+	entries, err := parseTreeContent(content)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		fullPath := entry.Name
+		if prefix != "" {
+			fullPath = prefix + "/" + entry.Name
+		}
+
+		if entry.Mode == "40000" {
+			if err := flattenTree(entry.Hash, fullPath, files); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry.Name = fullPath
+		files[fullPath] = entry
+	}
+
+	return nil
+}
+
+// Write tree objects for files (path -> entry, as returned by flattenTree) - returns root tree hash
+func writeTreeFromFiles(files map[string]TreeEntry) (string, error) {
+	entries := make([]IndexEntry, 0, len(files))
+	for filePath, entry := range files {
+		mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+		if err != nil {
+			return "", fmt.Errorf("bad mode %s for %s", entry.Mode, filePath)
+		}
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, IndexEntry{Path: filePath, Hash: hash, Mode: uint32(mode)})
+	}
+	return writeTreeFromEntries(entries)
+}
+
+// Write tree objects for index entries - returns root tree hash
+func writeTreeFromEntries(entries []IndexEntry) (string, error) {
+	if len(entries) == 0 {
+		// Empty tree has no entries to build it from
+		hash, err := writeObject(generateObjectByte("tree", nil))
+		return hex.EncodeToString(hash), err
+	}
+
+	root := makeDirTree(entries)
+	if err := dfsTreeCreation(root); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(root.Hash), nil
+}
+
+// Write tree of the current index - returns root tree hash
+// Directories with a valid cached tree (TREE extension) are not rebuilt, and the cache is written back to the index
+func writeTreeFromIndex() (string, error) {
+	entries, err := readGitIndex()
+	if err != nil {
+		return "", err
+	}
+	if unmerged := unmergedPaths(entries); len(unmerged) > 0 {
+		return "", fmt.Errorf("cannot write tree - unmerged paths: %s", strings.Join(sortedKeys(unmerged), ", "))
+	}
+	state, ok := indexStateCache[indexFilePath()]
+	if !ok {
+		// No index file - nothing to cache trees in
+		return writeTreeFromEntries(entries)
+	}
+
+	root := makeDirTree(entries)
+	root.Name = ""
+	cacheTree, err := writeTreeWithCache(root, state.CacheTree)
+	if err != nil {
+		return "", err
+	}
+	// Intent-to-add entries are counted by git, but are not in the tree - their directories can't be cached
+	for _, entry := range entries {
+		if entry.IntentToAdd {
+			cacheTree.invalidate(entry.Path)
+		}
+	}
+
+	state.CacheTree = cacheTree
+	if err := writeGitIndex(entries); err != nil {
+		return "", fmt.Errorf("failed to write index: %w", err)
+	}
+	return hex.EncodeToString(root.Hash), nil
+}
+
+// Make index and work tree match commit - tracked files that are not in commit are removed
+func resetWorkTreeToCommit(commitHash string) error {
+	treeHash, err := readCommitTreeHash(commitHash)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]TreeEntry)
+	if err := flattenTree(treeHash, "", files); err != nil {
+		return err
+	}
+
+	entries, err := readGitIndex()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, ok := files[entry.Path]; ok {
+			continue
+		}
+		if err := os.Remove(workTreePath(filepath.FromSlash(entry.Path))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+		}
+		removeEmptyParentDirs(entry.Path)
+	}
+
+	if err := renderFilesFromCommit(context.Background(), commitHash); err != nil {
+		return err
+	}
+	return writeIndexFromCommit(commitHash)
+}
+
+// Files from the tree of the commit HEAD points to - empty map if there are no commits yet
+func readHeadFiles() (map[string]TreeEntry, error) {
+	files := make(map[string]TreeEntry)
+
+	_, headHash, err := readHead()
+	if err != nil || headHash == "" {
+		return files, err
+	}
+
+	treeHash, err := readCommitTreeHash(headHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, flattenTree(treeHash, "", files)
+}
+
+// Read work tree file as blob content and index mode - symlinks are read as their target (mode 120000),
+// regular files have line endings normalized
+func readWorkTreeFile(relPath string, converter *EolConverter) ([]byte, uint32, error) {
+	fullPath := workTreePath(filepath.FromSlash(relPath))
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []byte(filepath.ToSlash(target)), 0120000, nil
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return converter.toGit(relPath, content), workTreeFileMode(fullPath), nil
+}
+
+// Index mode of regular file - any executable bit makes the file executable for git (there are only
+// 100644 and 100755 modes)
+func workTreeFileMode(fullPath string) uint32 {
+	if info, err := os.Stat(fullPath); err == nil && info.Mode().Perm()&0111 != 0 {
+		return 0100755
+	}
+	return 0100644
+}
+
+// Mode to record for work tree file whose index entry has indexMode (0 when it has none) - with core.fileMode
+// false the executable bit can't be trusted, so a regular file keeps the mode of its index entry (100644
+// when there is none, or when it isn't a regular file)
+func indexFileMode(mode, indexMode uint32) uint32 {
+	if (mode != 0100644 && mode != 0100755) || fileModeEnabled() {
+		return mode
+	}
+	if indexMode == 0100644 || indexMode == 0100755 {
+		return indexMode
+	}
+	return 0100644
+}
+
+// Check single tree entry name - empty names, "." and "..", names with "/" or NUL and ".git" (in any letter
+// case) would escape the work tree or write into the repository
+func validPathComponent(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.EqualFold(name, ".git") {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\x00")
+}
+
+// Check work tree path (slash separated) before anything is written to it
+func verifyPath(relPath string) error {
+	for _, name := range strings.Split(relPath, "/") {
+		if !validPathComponent(name) {
+			return fmt.Errorf("invalid path '%s'", relPath)
+		}
+	}
+	return nil
+}
+
+// Check entries of one tree before it is checked out - every name has to be valid and appear only once
+func verifyTreeEntries(dirPath string, entries []TreeEntry) error {
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		relPath := path.Join(dirPath, entry.Name)
+		if !validPathComponent(entry.Name) {
+			return fmt.Errorf("invalid path '%s'", relPath)
+		}
+		if seen[entry.Name] {
+			return fmt.Errorf("duplicate entry '%s' in tree", relPath)
+		}
+		seen[entry.Name] = true
+	}
+	return nil
+}
+
+// Create work tree directory with all its parents - every existing component is checked with Lstat, so
+// nothing is ever created through a symlink (MkdirAll would follow it)
+func createWorkTreeDir(relDir string) error {
+	if relDir == "" || relDir == "." {
+		return nil
+	}
+	if err := verifyPath(relDir); err != nil {
+		return err
+	}
+
+	dir := ""
+	for _, name := range strings.Split(relDir, "/") {
+		dir = path.Join(dir, name)
+		fullPath := workTreePath(filepath.FromSlash(dir))
+		info, err := os.Lstat(fullPath)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(fullPath, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("'%s' is beyond a symbolic link", relDir)
+		case !info.IsDir():
+			return fmt.Errorf("cannot create directory %s: file is in the way", dir)
+		}
+	}
+	return nil
+}
+
+// Write blob content to work tree - symlink entries (120000) become real symlinks unless core.symlinks is false
+func writeWorkTreeFile(relPath, mode string, content []byte, converter *EolConverter) error {
+	if err := verifyPath(relPath); err != nil {
+		return err
+	}
+	if err := createWorkTreeDir(path.Dir(relPath)); err != nil {
+		return err
+	}
+	fullPath := workTreePath(filepath.FromSlash(relPath))
+
+	// Existing file (or symlink) has to be removed - WriteFile would follow a symlink
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if mode == "120000" && symlinksEnabled() {
+		return os.Symlink(filepath.FromSlash(string(content)), fullPath)
+	}
+	if mode == "120000" {
+		return os.WriteFile(fullPath, content, 0644)
+	}
+
+	perm := os.FileMode(0644)
+	if mode == "100755" {
+		perm = 0755
+	}
+	if err := os.WriteFile(fullPath, converter.toWorkTree(relPath, content), perm); err != nil {
+		return err
+	}
+	// WriteFile permissions are filtered by umask - executable bit has to be set explicitly
+	return os.Chmod(fullPath, perm)
+}
+
+// core.fileMode - when false, executable bits in the work tree are ignored (see indexFileMode)
+func fileModeEnabled() bool {
+	config, err := loadConfig()
+	if err != nil {
+		return true
+	}
+	return config.GetBool("core.fileMode", true)
+}
+
+// core.symlinks - when false, symlinks are checked out as plain files containing the link target
+func symlinksEnabled() bool {
+	config, err := loadConfig()
+	if err != nil {
+		return true
+	}
+	return config.GetBool("core.symlinks", true)
+}
+
+// Hash work tree file as blob (without writing it) - content is converted the same way add would do it
+func hashWorkTreeFile(filePath string, converter *EolConverter) ([]byte, uint32, error) {
+	if fullPath := workTreePath(filepath.FromSlash(filePath)); isBigFile(fullPath, bigFileThreshold()) {
+		hash, err := hashBlobFromFile(fullPath)
+		return hash, workTreeFileMode(fullPath), err
+	}
+	content, mode, err := readWorkTreeFile(filePath, converter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hashObject(generateObjectByte("blob", content)), mode, nil
+}
+
+// Stat data of work tree file for its index entry - zero when file can't be read
+func workTreeFileStat(relPath string) IndexStat {
+	info, err := os.Lstat(workTreePath(filepath.FromSlash(relPath)))
+	if err != nil {
+		return IndexStat{}
+	}
+	return indexStatFromFileInfo(info)
+}
+
+// Index stat data from portable file info - ctime is not available, so mtime is used instead
+func indexStatFromModTime(info os.FileInfo) IndexStat {
+	modTime := info.ModTime()
+	return IndexStat{
+		CTimeSeconds:     uint32(modTime.Unix()),
+		CTimeNanoseconds: uint32(modTime.Nanosecond()),
+		MTimeSeconds:     uint32(modTime.Unix()),
+		MTimeNanoseconds: uint32(modTime.Nanosecond()),
+		Size:             uint32(info.Size()),
+	}
+}
+
+// Check whether work tree file is unchanged according to stat data alone - entries without stat data, smudged
+// entries (size 0) and entries modified at or after the index was written (racily clean) always need hashing
+func statUnchanged(entry IndexEntry, stat IndexStat, indexModTime time.Time) bool {
+	if entry.Stat.Size == 0 || entry.Stat != stat {
+		return false
+	}
+	modTime := time.Unix(int64(stat.MTimeSeconds), int64(stat.MTimeNanoseconds))
+	return modTime.Before(indexModTime)
+}
+
+// Every directory that contains at least one tracked file
+func trackedDirectories(indexEntries []IndexEntry) map[string]bool {
+	dirs := make(map[string]bool)
+	for _, entry := range indexEntries {
+		for dir := parentDir(entry.Path); dir != ""; dir = parentDir(dir) {
+			dirs[dir] = true
+		}
+	}
+	return dirs
+}
+
+// Walk work tree directory and collect untracked and ignored paths (relative to work tree root)
+// Untracked directories without tracked files are reported as a whole ("dir/") instead of file by file
+// If matcher is nil, nothing is treated as ignored
+func collectUntracked(dir string, tracked, trackedDirs map[string]bool, matcher *IgnoreMatcher) ([]string, []string, error) {
+	dirEntries, err := os.ReadDir(workTreePath(filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var untracked, ignored []string
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if name == ".git" {
+			continue
+		}
+
+		relPath := name
+		if dir != "" {
+			relPath = dir + "/" + name
+		}
+
+		if dirEntry.IsDir() {
+			if tracked[relPath] {
+				// Tracked directory is a submodule (gitlink)
+				continue
+			}
+			if matcher != nil && matcher.isIgnored(relPath, true) {
+				ignored = append(ignored, relPath+"/")
+				continue
+			}
+
+			subUntracked, subIgnored, err := collectUntracked(relPath, tracked, trackedDirs, matcher)
+			if err != nil {
+				return nil, nil, err
+			}
+			ignored = append(ignored, subIgnored...)
+
+			if trackedDirs[relPath] {
+				untracked = append(untracked, subUntracked...)
+			} else if len(subUntracked) > 0 {
+				untracked = append(untracked, relPath+"/")
+			}
+			continue
+		}
+
+		if tracked[relPath] {
+			continue
+		}
+		if matcher != nil && matcher.isIgnored(relPath, false) {
+			ignored = append(ignored, relPath)
+			continue
+		}
+		untracked = append(untracked, relPath)
+	}
+
+	return untracked, ignored, nil
+}
+
+// Stage provided paths (files or directories) - ignored untracked files are refused unless force is set
+func addPaths(pathspecs []string, force bool) error {
+	indexEntries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	// Conflicted path stands in the map as one of its stages - adding (or removing) it resolves the conflict
+	index := make(map[string]IndexEntry)
+	for _, entry := range indexEntries {
+		index[entry.Path] = entry
+	}
+	unmerged := unmergedPaths(indexEntries)
+
+	matcher, err := newIgnoreMatcher()
+	if err != nil {
+		return err
+	}
+
+	converter, err := newEolConverter()
+	if err != nil {
+		return err
+	}
+
+	var ignoredPaths []string
+	for _, pathspec := range pathspecs {
+		relPath := filepath.ToSlash(filepath.Clean(pathspec))
+		if relPath == "." {
+			relPath = ""
+		}
+
+		info, err := os.Lstat(workTreePath(filepath.FromSlash(relPath)))
+		if os.IsNotExist(err) {
+			// File was deleted - remove it (or everything below it) from index
+			removed := removeIndexPaths(index, relPath)
+			if removed == 0 {
+				return fmt.Errorf("pathspec '%s' did not match any files", pathspec)
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			if _, isTracked := index[relPath]; !isTracked && !force && matcher.isIgnored(relPath, false) {
+				ignoredPaths = append(ignoredPaths, relPath)
+				continue
+			}
+			if err := addFileToIndex(index, relPath, converter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Directory - drop index entries for files that no longer exist, then add everything that isn't ignored
+		for indexPath := range index {
+			if relPath == "" || strings.HasPrefix(indexPath, relPath+"/") {
+				if _, err := os.Lstat(workTreePath(filepath.FromSlash(indexPath))); os.IsNotExist(err) {
+					delete(index, indexPath)
+				}
+			}
+		}
+
+		err = filepath.WalkDir(workTreePath(filepath.FromSlash(relPath)), func(walkPath string, dirEntry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(workTreePath(), walkPath)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			if dirEntry.IsDir() {
+				if dirEntry.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				if existing, isTracked := index[rel]; isTracked && existing.Mode == 0160000 {
+					// Submodule - its files belong to another repository
+					return filepath.SkipDir
+				}
+				if rel != "." && rel != relPath && !force && matcher.isIgnored(rel, true) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if _, isTracked := index[rel]; !isTracked && !force && matcher.isIgnored(rel, false) {
+				return nil
+			}
+			return addFileToIndex(index, rel, converter)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	entries := make([]IndexEntry, 0, len(index))
+	for _, entry := range index {
+		if entry.Stage != 0 {
+			entries = append(entries, unmerged[entry.Path]...)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := writeGitIndex(entries); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	if len(ignoredPaths) > 0 {
+		return fmt.Errorf("the following paths are ignored by one of your .gitignore files:\n%s\nUse -f if you really want to add them",
+			strings.Join(ignoredPaths, "\n"))
+	}
+	return nil
+}
+
+// Write file (or symlink target) as blob object and put it in index
+func addFileToIndex(index map[string]IndexEntry, relPath string, converter *EolConverter) error {
+	if fullPath := workTreePath(filepath.FromSlash(relPath)); isBigFile(fullPath, bigFileThreshold()) {
+		hash, err := writeBlobFromFile(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to write blob for %s: %w", relPath, err)
+		}
+		mode := indexFileMode(workTreeFileMode(fullPath), index[relPath].Mode)
+		index[relPath] = IndexEntry{Path: relPath, Hash: hash, Mode: mode, Stat: workTreeFileStat(relPath)}
+		return nil
+	}
+
+	// Stat is taken before reading, so a change during the read makes the entry look modified, not clean
+	stat := workTreeFileStat(relPath)
+	content, mode, err := readWorkTreeFile(relPath, converter)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", relPath, err)
+	}
+	mode = indexFileMode(mode, index[relPath].Mode)
+
+	hash, err := writeObject(generateObjectByte("blob", content))
+	if err != nil {
+		return fmt.Errorf("failed to write blob for %s: %w", relPath, err)
+	}
+
+	index[relPath] = IndexEntry{
+		Path: relPath,
+		Hash: hash,
+		Mode: mode,
+		Stat: stat,
+	}
+	return nil
+}
+
+// Remove path (file, or every file below directory) from index - returns number of removed entries
+func removeIndexPaths(index map[string]IndexEntry, relPath string) int {
+	removed := 0
+	for indexPath := range index {
+		if indexPath == relPath || relPath == "" || strings.HasPrefix(indexPath, relPath+"/") {
+			delete(index, indexPath)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Compare HEAD, index and work tree and collect staged/unstaged changes and untracked files
+func computeStatus(showIgnored bool) (*WorkTreeStatus, error) {
+	headFiles, err := readHeadFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD tree: %w", err)
+	}
+
+	indexEntries, err := readGitIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	status := &WorkTreeStatus{
+		Staged:        make(map[string]string),
+		Unstaged:      make(map[string]string),
+		HeadFiles:     headFiles,
+		IndexEntries:  make(map[string]IndexEntry, len(indexEntries)),
+		WorkTreeModes: make(map[string]uint32),
+	}
+	status.Branch, status.Head, err = readHead()
+	if err != nil {
+		return nil, err
+	}
+	if status.Tracking, err = branchTracking(status.Branch); err != nil {
+		return nil, err
+	}
+
+	converter, err := newEolConverter()
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok := indexStateCache[indexFilePath()]
+	if !ok {
+		state = &IndexState{}
+	}
+	indexModTime := state.ModTime
+
+	// Untracked cache doesn't keep ignored files, so listing them needs a full scan
+	// Index is written when the cache or fsmonitor state was dropped, so they don't linger in it
+	var cache *UntrackedCache
+	refreshed := false
+	if !showIgnored {
+		hadCache := state.UntrackedCache != nil
+		if cache, err = prepareUntrackedCache(state); err != nil {
+			return nil, err
+		}
+		refreshed = hadCache && cache == nil
+	}
+	hook, fsmonitorEnabled := fsmonitorHook()
+	if fsmonitorEnabled {
+		refreshFSMonitor(hook, state, indexEntries, cache)
+	} else if state.FSMonitorToken != "" {
+		state.FSMonitorToken = ""
+		refreshed = true
+	}
+
+	status.Unmerged = unmergedPaths(indexEntries)
+	if _, err := os.Stat(gitDirPath("MERGE_HEAD")); err == nil {
+		status.Merging = true
+	}
+
+	tracked := make(map[string]bool)
+	for i, entry := range indexEntries {
+		tracked[entry.Path] = true
+		// Conflicted path is only reported as unmerged - its stages are not compared with HEAD or work tree
+		if entry.Stage != 0 {
+			continue
+		}
+		status.IndexEntries[entry.Path] = entry
+
+		// HEAD vs index - intent-to-add entries are not staged yet, they show up as new files in the work tree
+		headEntry, inHead := headFiles[entry.Path]
+		if entry.IntentToAdd {
+			if _, err := os.Lstat(workTreePath(filepath.FromSlash(entry.Path))); os.IsNotExist(err) {
+				status.Unstaged[entry.Path] = "deleted"
+			} else {
+				status.Unstaged[entry.Path] = "new file"
+				status.WorkTreeModes[entry.Path] = entry.Mode
+			}
+			continue
+		} else if !inHead {
+			status.Staged[entry.Path] = "new file"
+		} else if headEntry.Hash != hex.EncodeToString(entry.Hash) || headEntry.Mode != fmt.Sprintf("%06o", entry.Mode) {
+			status.Staged[entry.Path] = "modified"
+		}
+
+		// Index vs work tree - submodule content lives in its own repository, so gitlinks are not compared,
+		// and skip-worktree files are not expected to be checked out
+		// Fsmonitor saw no change since the entry was last found clean - file is not even stat'ed
+		if entry.Mode == 0160000 || entry.SkipWorktree || entry.FSMonitorValid {
+			continue
+		}
+		info, err := os.Lstat(workTreePath(filepath.FromSlash(entry.Path)))
+		if os.IsNotExist(err) {
+			status.Unstaged[entry.Path] = "deleted"
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// Same stat data as when the entry was written - file is not hashed
+		stat := indexStatFromFileInfo(info)
+		if statUnchanged(entry, stat, indexModTime) {
+			indexEntries[i].FSMonitorValid = fsmonitorEnabled
+			continue
+		}
+
+		hash, mode, err := hashWorkTreeFile(entry.Path, converter)
+		mode = indexFileMode(mode, entry.Mode)
+		if os.IsNotExist(err) {
+			status.Unstaged[entry.Path] = "deleted"
+		} else if err != nil {
+			return nil, err
+		} else if (mode == 0120000) != (entry.Mode == 0120000) {
+			status.Unstaged[entry.Path] = "typechange"
+			status.WorkTreeModes[entry.Path] = mode
+		} else if !bytes.Equal(hash, entry.Hash) || mode != entry.Mode {
+			status.Unstaged[entry.Path] = "modified"
+			status.WorkTreeModes[entry.Path] = mode
+		} else {
+			// Content didn't change - new stat data lets the next status skip hashing it
+			if entry.Stat != stat {
+				indexEntries[i].Stat = stat
+				refreshed = true
+			}
+			indexEntries[i].FSMonitorValid = fsmonitorEnabled
+		}
+	}
+
+	for headPath := range headFiles {
+		if !tracked[headPath] {
+			status.Staged[headPath] = "deleted"
+		}
+	}
+	if err := detectStagedRenames(status); err != nil {
+		return nil, err
+	}
+
+	matcher, err := newIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		var cacheChanged bool
+		status.Untracked, cacheChanged, err = collectUntrackedCached(cache, tracked, trackedDirectories(indexEntries), matcher, indexModTime)
+		refreshed = refreshed || cacheChanged
+	} else {
+		status.Untracked, status.Ignored, err = collectUntracked("", tracked, trackedDirectories(indexEntries), matcher)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Refreshing the index is only an optimization, so failing to write it is not an error (e.g. read-only repository)
+	// With fsmonitor the index is always written - it keeps the new token
+	if refreshed || fsmonitorEnabled {
+		writeGitIndex(indexEntries)
+	}
+
+	return status, nil
+}
+
+// Pair staged deletions with staged new files of similar content - the pair is one "renamed" change of the new
+// path. status.renames (diff.renames by default) can turn it off; copies are not looked for.
+func detectStagedRenames(status *WorkTreeStatus) error {
+	status.RenamedFrom = make(map[string]string)
+	status.RenameScores = make(map[string]int)
+	options, err := resolveDiffOptions(DiffOptions{})
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if value, ok := config.Get("status.renames"); ok && !parseBoolValue(value, true) {
+		options.Renames = DiffRenamesOff
+	} else if ok || options.Renames == DiffCopiesOn {
+		options.Renames = DiffRenamesOn
+	}
+
+	var changes []FileChange
+	for _, filePath := range sortedKeys(status.Staged) {
+		switch status.Staged[filePath] {
+		case "new file":
+			entry := status.IndexEntries[filePath]
+			changes = append(changes, FileChange{Status: 'A', OldPath: filePath, NewPath: filePath,
+				NewMode: fmt.Sprintf("%06o", entry.Mode), NewHash: hex.EncodeToString(entry.Hash)})
+		case "deleted":
+			entry := status.HeadFiles[filePath]
+			changes = append(changes, FileChange{Status: 'D', OldPath: filePath, NewPath: filePath,
+				OldMode: entry.Mode, OldHash: entry.Hash})
+		}
+	}
+	changes, err = detectRenames(changes, status.HeadFiles, options)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.Status == 'R' {
+			delete(status.Staged, change.OldPath)
+			status.Staged[change.NewPath] = "renamed"
+			status.RenamedFrom[change.NewPath] = change.OldPath
+			status.RenameScores[change.NewPath] = change.Similarity
+		}
+	}
+	return nil
+}
+
+// Print status in git's long (human readable) format - colored as color.status says
+func printStatus(status *WorkTreeStatus, options StatusOptions, w io.Writer) error {
+	color, err := useColor("status", options.Color)
+	if err != nil {
+		return err
+	}
+	var colors map[string]string
+	if color {
+		if colors, err = loadColors("status"); err != nil {
+			return err
+		}
+	}
+
+	if status.Branch != "" {
+		fmt.Fprintf(w, "On branch %s\n", strings.TrimPrefix(status.Branch, "refs/heads/"))
+	} else {
+		fmt.Fprintf(w, "%s%s\n", colorize(colors, "nobranch", "HEAD detached at "), status.Head[:7])
+	}
+	if status.Tracking != nil && status.Head != "" {
+		writeTrackingStatus(status.Tracking, w)
+	}
+	switch {
+	case len(status.Unmerged) > 0:
+		fmt.Fprintf(w, "You have unmerged paths.\n")
+	case status.Merging:
+		fmt.Fprintf(w, "All conflicts fixed but you are still merging.\n")
+	}
+	if status.Head == "" {
+		fmt.Fprintf(w, "\nNo commits yet\n")
+	}
+
+	if len(status.Staged) > 0 {
+		fmt.Fprintf(w, "\nChanges to be committed:\n")
+		for _, filePath := range sortedKeys(status.Staged) {
+			shown := filePath
+			if oldPath, ok := status.RenamedFrom[filePath]; ok {
+				shown = oldPath + " -> " + filePath
+			}
+			fmt.Fprintf(w, "\t%s\n", colorize(colors, "updated", fmt.Sprintf("%-12s%s", status.Staged[filePath]+":", shown)))
+		}
+	}
+
+	if len(status.Unmerged) > 0 {
+		fmt.Fprintf(w, "\nUnmerged paths:\n")
+		for _, filePath := range sortedKeys(status.Unmerged) {
+			label := unmergedLabel(status.Unmerged[filePath])
+			fmt.Fprintf(w, "\t%s\n", colorize(colors, "unmerged", fmt.Sprintf("%-17s%s", label+":", filePath)))
+		}
+	}
+
+	if len(status.Unstaged) > 0 {
+		fmt.Fprintf(w, "\nChanges not staged for commit:\n")
+		for _, filePath := range sortedKeys(status.Unstaged) {
+			fmt.Fprintf(w, "\t%s\n", colorize(colors, "changed", fmt.Sprintf("%-12s%s", status.Unstaged[filePath]+":", filePath)))
+		}
+	}
+
+	if len(status.Untracked) > 0 {
+		fmt.Fprintf(w, "\nUntracked files:\n")
+		for _, filePath := range status.Untracked {
+			fmt.Fprintf(w, "\t%s\n", colorize(colors, "untracked", filePath))
+		}
+	}
+
+	if options.ShowIgnored && len(status.Ignored) > 0 {
+		fmt.Fprintf(w, "\nIgnored files:\n")
+		for _, filePath := range status.Ignored {
+			fmt.Fprintf(w, "\t%s\n", colorize(colors, "ignored", filePath))
+		}
+	}
+
+	if len(status.Staged) == 0 && len(status.Unstaged) == 0 && len(status.Unmerged) == 0 {
+		if len(status.Untracked) > 0 {
+			fmt.Fprintf(w, "\nnothing added to commit but untracked files present\n")
+		} else {
+			fmt.Fprintf(w, "\nnothing to commit, working tree clean\n")
+		}
+	}
+	return nil
+}
+
+// Remove untracked files - dirs also removes untracked directories, noIgnore (-x) removes ignored files too
+// and onlyIgnored (-X) removes only ignored files. With dryRun, paths are only printed.
+func cleanWorkTree(dryRun, dirs, noIgnore, onlyIgnored bool, w io.Writer) error {
+	indexEntries, err := readGitIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	tracked := make(map[string]bool)
+	for _, entry := range indexEntries {
+		tracked[entry.Path] = true
+	}
+
+	var matcher *IgnoreMatcher
+	if !noIgnore {
+		matcher, err = newIgnoreMatcher()
+		if err != nil {
+			return err
+		}
+	}
+
+	untracked, ignored, err := collectUntracked("", tracked, trackedDirectories(indexEntries), matcher)
+	if err != nil {
+		return err
+	}
+
+	candidates := untracked
+	if onlyIgnored {
+		candidates = ignored
+	}
+
+	for _, candidate := range candidates {
+		isDir := strings.HasSuffix(candidate, "/")
+		if isDir && !dirs {
+			continue
+		}
+
+		if dryRun {
+			fmt.Fprintf(w, "Would remove %s\n", candidate)
+			continue
+		}
+
+		fmt.Fprintf(w, "Removing %s\n", candidate)
+		if err := os.RemoveAll(workTreePath(filepath.FromSlash(strings.TrimSuffix(candidate, "/")))); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", candidate, err)
+		}
+	}
+
+	return nil
+}
+
+// Sorted keys of string map (for deterministic output)
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}